@@ -183,6 +183,33 @@ type BlobIngester interface {
 	Resume(ctx context.Context, id string) (BlobWriter, error)
 }
 
+// BlobUpload describes a blob upload in progress.
+type BlobUpload struct {
+	// ID identifies the upload, as returned by BlobWriter.ID.
+	ID string `json:"uuid"`
+
+	// StartedAt is the time the upload was started.
+	StartedAt time.Time `json:"startedAt"`
+
+	// Size is the number of bytes received so far.
+	Size int64 `json:"size"`
+
+	// LastActivity is the time bytes were last received, or StartedAt if
+	// none were.
+	LastActivity time.Time `json:"lastActivity"`
+}
+
+// BlobUploadLister enables listing and cancelling the blob uploads in
+// progress of a repository, to find the ones which are stuck.
+type BlobUploadLister interface {
+	// Uploads returns the uploads in progress, sorted by id.
+	Uploads(ctx context.Context) ([]BlobUpload, error)
+
+	// CancelUpload cancels the upload id and frees its resources. It returns
+	// ErrBlobUploadUnknown if there is no such upload.
+	CancelUpload(ctx context.Context, id string) error
+}
+
 // BlobCreateOption is a general extensible function argument for blob creation
 // methods. A BlobIngester may choose to honor any or none of the given
 // BlobCreateOptions, which can be specific to the implementation of the
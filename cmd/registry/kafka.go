@@ -0,0 +1,7 @@
+//go:build kafka
+
+package main
+
+import (
+	_ "github.com/distribution/distribution/v3/notifications/kafka"
+)
@@ -0,0 +1,7 @@
+//go:build nats
+
+package main
+
+import (
+	_ "github.com/distribution/distribution/v3/notifications/nats"
+)
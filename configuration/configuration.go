@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"path"
 	"reflect"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 )
 
@@ -59,6 +62,10 @@ type Configuration struct {
 	// used to gate requests.
 	Auth Auth `yaml:"auth,omitempty"`
 
+	// AccessPolicy configures an external policy which is consulted for
+	// every authenticated request.
+	AccessPolicy AccessPolicy `yaml:"accesspolicy,omitempty"`
+
 	// Middleware lists all middlewares to be used by the registry.
 	Middleware map[string][]Middleware `yaml:"middleware,omitempty"`
 
@@ -84,6 +91,22 @@ type Configuration struct {
 		// Location headers
 		RelativeURLs bool `yaml:"relativeurls,omitempty"`
 
+		// TrustedProxies lists the networks, in CIDR notation, and the IP
+		// addresses of the proxies whose Forwarded and X-Forwarded-* headers
+		// are honored when building URLs. If empty, these headers are
+		// honored regardless of where the request comes from.
+		TrustedProxies []string `yaml:"trustedproxies,omitempty"`
+
+		// ProxyProtocol accepts connections starting with a PROXY protocol
+		// header, version 1 or 2, whose client address is then used as the
+		// remote address of the requests.
+		ProxyProtocol bool `yaml:"proxyprotocol,omitempty"`
+
+		// ProxyProtocolSources lists the networks, in CIDR notation, and the
+		// IP addresses of the proxies which may send a PROXY protocol header.
+		// It is required by ProxyProtocol.
+		ProxyProtocolSources []string `yaml:"proxyprotocolsources,omitempty"`
+
 		// Amount of time to wait for connection to drain before shutting down when registry
 		// receives a stop signal
 		DrainTimeout time.Duration `yaml:"draintimeout,omitempty"`
@@ -166,18 +189,67 @@ type Configuration struct {
 			// Useful when deploying the registry behind a load balancer (e.g. Cloud Run)
 			Enabled bool `yaml:"enabled,omitempty"`
 		} `yaml:"h2c,omitempty"`
+
+		// RateLimit configures the rate limiting of API requests.
+		RateLimit RateLimit `yaml:"ratelimit,omitempty"`
+
+		// MaxRequestBodyBytes limits the size of request bodies.
+		MaxRequestBodyBytes MaxRequestBodyBytes `yaml:"maxrequestbodybytes,omitempty"`
+
+		// Limits caps the number of requests served concurrently.
+		Limits Limits `yaml:"limits,omitempty"`
+
+		// ChunkMinLength is the minimum length, in bytes, of the chunks of
+		// blob uploads advertised to clients in the OCI-Chunk-Min-Length
+		// header. It defaults to 1.
+		ChunkMinLength int64 `yaml:"chunkminlength,omitempty"`
+
+		// RequestTimeout bounds the time spent serving API requests.
+		RequestTimeout RequestTimeout `yaml:"requesttimeout,omitempty"`
+
+		// UploadResume configures the resumption of blob uploads whose
+		// previous chunk was received by another registry instance.
+		UploadResume UploadResume `yaml:"uploadresume,omitempty"`
+
+		// Info configures the /v2/_distribution/registry/info route, which
+		// describes the build and the configuration of the registry.
+		Info Info `yaml:"info,omitempty"`
+
+		// RequestID configures the ids of the requests.
+		RequestID RequestID `yaml:"requestid,omitempty"`
+
+		// APIs enables optional routes and semantics of the API.
+		APIs APIs `yaml:"apis,omitempty"`
 	} `yaml:"http,omitempty"`
 
 	// Notifications specifies configuration about various endpoint to which
 	// registry events are dispatched.
 	Notifications Notifications `yaml:"notifications,omitempty"`
 
+	// Audit configures the audit log of write operations.
+	Audit Audit `yaml:"audit,omitempty"`
+
 	// Redis configures the redis pool available to the registry webapp.
 	Redis Redis `yaml:"redis,omitempty"`
 
+	// Coordination configures the election of the instance running the
+	// background maintenance among the instances sharing the storage.
+	Coordination Coordination `yaml:"coordination,omitempty"`
+
+	// Reporting configures the reporting of the registry to monitoring
+	// services.
+	Reporting Reporting `yaml:"reporting,omitempty"`
+
+	// Warnings configures the Warning headers of the manifest responses
+	// nudging clients off deprecated content.
+	Warnings Warnings `yaml:"warnings,omitempty"`
+
 	Health  Health  `yaml:"health,omitempty"`
 	Catalog Catalog `yaml:"catalog,omitempty"`
 
+	// Referrers configures the listing of the referrers of manifests.
+	Referrers Referrers `yaml:"referrers,omitempty"`
+
 	Proxy Proxy `yaml:"proxy,omitempty"`
 
 	// Validation configures validation options for the registry.
@@ -190,15 +262,61 @@ type Configuration struct {
 		// Manifests configures manifest validation.
 		Manifests struct {
 			// URLs configures validation for URLs in pushed manifests.
-			URLs struct {
-				// Allow specifies regular expressions (https://godoc.org/regexp/syntax)
-				// that URLs in pushed manifests must match.
-				Allow []string `yaml:"allow,omitempty"`
-				// Deny specifies regular expressions (https://godoc.org/regexp/syntax)
-				// that URLs in pushed manifests must not match.
-				Deny []string `yaml:"deny,omitempty"`
-			} `yaml:"urls,omitempty"`
+			URLs ManifestURLs `yaml:"urls,omitempty"`
+			// ForeignLayers configures validation of the foreign layers of
+			// schema2 manifests.
+			ForeignLayers struct {
+				// URLs configures validation for the URLs of foreign
+				// layers. If neither Allow nor Deny is set, the URLs
+				// section of Manifests applies.
+				URLs ManifestURLs `yaml:"urls,omitempty"`
+			} `yaml:"foreignlayers,omitempty"`
+			// Layers configures validation of the layers of image
+			// manifests.
+			Layers struct {
+				// MediaTypes lists the allowed media types of the layers
+				// of image manifests. If empty, the OCI and Docker layer
+				// media types are allowed.
+				MediaTypes []string `yaml:"mediatypes,omitempty"`
+			} `yaml:"layers,omitempty"`
+			// ImageConfig rejects the image manifests whose config does
+			// not describe their layers: its diff_ids and history must
+			// match them.
+			ImageConfig bool `yaml:"imageconfig,omitempty"`
+			// Canonical configures the repositories requiring manifests
+			// serialized in canonical form.
+			Canonical struct {
+				// Repositories specifies globs (https://pkg.go.dev/path#Match)
+				// matching the repositories, or their parent namespaces,
+				// whose pushed manifests must be in canonical form.
+				Repositories []string `yaml:"repositories,omitempty"`
+			} `yaml:"canonical,omitempty"`
+			// MediaTypes restricts the media types of the manifests pushed
+			// to the repositories matching the globs it is keyed by.
+			MediaTypes map[string]ManifestMediaTypes `yaml:"mediatypes,omitempty"`
 		} `yaml:"manifests,omitempty"`
+		// Repositories configures validation of repository names.
+		Repositories struct {
+			// MaxComponents limits the number of path components of
+			// repository names. Zero means no limit.
+			MaxComponents int `yaml:"maxcomponents,omitempty"`
+			// AllowPatterns specifies globs (https://pkg.go.dev/path#Match)
+			// that repository names, or one of their parent namespaces,
+			// must match.
+			AllowPatterns []string `yaml:"allowpatterns,omitempty"`
+			// DenyPatterns specifies globs that repository names, and their
+			// parent namespaces, must not match. They take precedence over
+			// AllowPatterns.
+			DenyPatterns []string `yaml:"denypatterns,omitempty"`
+		} `yaml:"repositories,omitempty"`
+		// Tags configures validation of tag updates.
+		Tags struct {
+			// Immutable maps globs (https://pkg.go.dev/path#Match)
+			// matching repositories, or their parent namespaces, to globs
+			// matching the tags which, once created, cannot be changed to
+			// reference another manifest.
+			Immutable map[string][]string `yaml:"immutable,omitempty"`
+		} `yaml:"tags,omitempty"`
 	} `yaml:"validation,omitempty"`
 
 	// Policy configures registry policy options.
@@ -215,6 +333,15 @@ type Configuration struct {
 	} `yaml:"policy,omitempty"`
 }
 
+// Referrers configures the referrers endpoint
+// (/v2/<name>/referrers/<digest>).
+type Referrers struct {
+	// PageSize is the number of referrers listed by a response, past which
+	// a Link header points to the next page. An empty or a negative value
+	// sets a default of 1000.
+	PageSize int `yaml:"pagesize,omitempty"`
+}
+
 // Catalog is composed of MaxEntries.
 // Catalog endpoint (/v2/_catalog) configuration, it provides the configuration
 // options to control the maximum number of entries returned by the catalog endpoint.
@@ -223,6 +350,330 @@ type Catalog struct {
 	// to the catalog endpoint will return at most MaxEntries entries.
 	// An empty or a negative value will set a default of 1000 maximum entries by default.
 	MaxEntries int `yaml:"maxentries,omitempty"`
+
+	// CountBudget bounds the time spent counting the repositories of the
+	// catalog and the tags of a repository for the X-Total-Count header.
+	// Past it, the entries counted so far are returned as an estimate.
+	// Zero, the default, does not bound the count.
+	CountBudget time.Duration `yaml:"countbudget,omitempty"`
+}
+
+// RateLimit configures the rate limiting of API requests. Requests are
+// counted in token buckets keyed by client, repository and operation class,
+// where the client is the authenticated user name or, for anonymous requests,
+// the remote IP address.
+type RateLimit struct {
+	// Enabled enables rate limiting.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Backend is the limiter keeping the token buckets: inmemory, the
+	// default, or redis to share the buckets across registry instances.
+	Backend string `yaml:"backend,omitempty"`
+
+	// Read limits the GET and HEAD requests of each client to a repository.
+	Read RateLimitBucket `yaml:"read,omitempty"`
+
+	// Write limits the other requests of each client to a repository.
+	Write RateLimitBucket `yaml:"write,omitempty"`
+
+	// Repositories overrides the limits for repositories matching a name
+	// prefix. The longest matching prefix applies.
+	Repositories []RateLimitOverride `yaml:"repositories,omitempty"`
+}
+
+// RateLimitBucket configures a token bucket.
+type RateLimitBucket struct {
+	// Rate is the number of requests per second the bucket is refilled
+	// with. A zero rate disables the limit.
+	Rate float64 `yaml:"rate,omitempty"`
+
+	// Burst is the capacity of the bucket, the number of requests allowed
+	// at once. It defaults to the rate, rounded up.
+	Burst int `yaml:"burst,omitempty"`
+}
+
+// RateLimitOverride overrides the rate limits for the repositories starting
+// with Prefix. A nil bucket keeps the global limit of its operation class.
+type RateLimitOverride struct {
+	Prefix string           `yaml:"prefix"`
+	Read   *RateLimitBucket `yaml:"read,omitempty"`
+	Write  *RateLimitBucket `yaml:"write,omitempty"`
+}
+
+// ManifestURLs configures validation for the URLs of the layers of pushed
+// manifests.
+type ManifestURLs struct {
+	// Allow specifies regular expressions (https://godoc.org/regexp/syntax)
+	// that URLs in pushed manifests must match.
+	Allow []string `yaml:"allow,omitempty"`
+	// Deny specifies regular expressions (https://godoc.org/regexp/syntax)
+	// that URLs in pushed manifests must not match.
+	Deny []string `yaml:"deny,omitempty"`
+}
+
+// ManifestMediaTypes lists the media types allowed in a repository. An empty
+// list allows any media type.
+type ManifestMediaTypes struct {
+	// Manifests lists the allowed media types of manifests, including the
+	// manifests referenced by indexes.
+	Manifests []string `yaml:"manifests,omitempty"`
+
+	// Configs lists the allowed config media types of OCI image manifests,
+	// identifying the type of OCI artifacts.
+	Configs []string `yaml:"configs,omitempty"`
+}
+
+// MaxRequestBodyBytes limits the size of request bodies, in bytes.
+type MaxRequestBodyBytes struct {
+	// Manifest limits the body of manifest PUT requests. It defaults to
+	// 4MiB.
+	Manifest int64 `yaml:"manifest,omitempty"`
+
+	// BlobChunk limits the body of each blob upload PATCH and PUT request.
+	// It does not limit the size of blobs, which may be uploaded in several
+	// chunks. A zero value, the default, disables the limit.
+	BlobChunk int64 `yaml:"blobchunk,omitempty"`
+}
+
+// CORSHeaders returns the Access-Control-* entries of headers, the
+// http.headers configuration, which configure the Cross-Origin Resource
+// Sharing headers of the responses: they are only sent to the requests from
+// the origins listed by Access-Control-Allow-Origin. An origin ending with
+// ":*" matches any port, and "*" matches any origin, which cannot be
+// combined with Access-Control-Allow-Credentials, as any site could then
+// call the API with the credentials of its visitors.
+func CORSHeaders(headers http.Header) (http.Header, error) {
+	cors := http.Header{}
+	for name, values := range headers {
+		if name = http.CanonicalHeaderKey(name); strings.HasPrefix(name, "Access-Control-") {
+			cors[name] = append(cors[name], values...)
+		}
+	}
+
+	if credentials := cors.Get("Access-Control-Allow-Credentials"); strings.EqualFold(credentials, "true") {
+		for _, origin := range cors.Values("Access-Control-Allow-Origin") {
+			for _, o := range strings.Split(origin, ",") {
+				if strings.TrimSpace(o) == "*" {
+					return nil, errors.New(`Access-Control-Allow-Origin "*" cannot be combined with Access-Control-Allow-Credentials`)
+				}
+			}
+		}
+	} else if credentials != "" && !strings.EqualFold(credentials, "false") {
+		return nil, fmt.Errorf("invalid Access-Control-Allow-Credentials %q: must be true or false", credentials)
+	}
+	if maxAge := cors.Get("Access-Control-Max-Age"); maxAge != "" {
+		if seconds, err := strconv.Atoi(maxAge); err != nil || seconds < 0 {
+			return nil, fmt.Errorf("invalid Access-Control-Max-Age %q: must be a number of seconds", maxAge)
+		}
+	}
+	return cors, nil
+}
+
+// Limits caps the number of blob upload requests served concurrently, and
+// the number of goroutines doing work on their behalf. A zero value, the
+// default, leaves the uploads unlimited.
+type Limits struct {
+	// UploadsPerClient caps the blob upload requests of each client, its
+	// user name if authenticated or else its IP address.
+	UploadsPerClient int `yaml:"uploadsperclient,omitempty"`
+
+	// UploadsPerRepository caps the blob upload requests to each
+	// repository.
+	UploadsPerRepository int `yaml:"uploadsperrepository,omitempty"`
+
+	// Workers caps the goroutines looking up the tags of manifests and
+	// broadcasting notifications across the process. Work finding no worker
+	// available runs synchronously, and a limit of 1 runs all of it
+	// synchronously. Zero uses the default of 1024 workers.
+	Workers int `yaml:"workers,omitempty"`
+}
+
+// RequestTimeout sets the deadlines of API requests. Once a deadline passes,
+// the context of the request is canceled, which aborts the storage operations
+// serving it. A zero value, the default, leaves the requests unbounded.
+type RequestTimeout struct {
+	// Read is the deadline of GET and HEAD requests.
+	Read time.Duration `yaml:"read,omitempty"`
+
+	// Write is the deadline of all other requests, such as blob uploads
+	// and manifest pushes.
+	Write time.Duration `yaml:"write,omitempty"`
+}
+
+// UploadResume configures the resumption of blob uploads from their signed
+// upload state. When http.secret is set, every registry instance sharing it
+// can resume an upload. The upload state is trusted over a storage which does
+// not reflect the previous chunk yet, such as an eventually consistent one,
+// and the upload is resumed again until the storage catches up or Timeout
+// passes.
+type UploadResume struct {
+	// Disabled fails the requests of uploads which the storage does not
+	// reflect immediately.
+	Disabled bool `yaml:"disabled,omitempty"`
+
+	// Timeout bounds the time spent waiting for the storage to reflect an
+	// upload. It defaults to 5 seconds.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// Info configures the route describing the registry.
+type Info struct {
+	// Disabled removes the route.
+	Disabled bool `yaml:"disabled,omitempty"`
+}
+
+// APIs configures the optional routes and semantics of the API.
+type APIs struct {
+	// TagDelete configures the deletion of tags, removing the tag only and
+	// not the manifest it points to, with DELETE /v2/<name>/manifests/<tag>
+	// and DELETE /v2/<name>/tags/<tag>.
+	TagDelete TagDelete `yaml:"tagdelete,omitempty"`
+}
+
+// TagDelete configures the deletion of tags.
+type TagDelete struct {
+	// Disabled rejects the deletes of tags.
+	Disabled bool `yaml:"disabled,omitempty"`
+}
+
+// RequestID configures the ids of the requests, which are logged and recorded
+// in notification events.
+type RequestID struct {
+	// TrustedHeader is the header, such as X-Request-Id, whose value is used
+	// as the id of the requests carrying it, instead of a generated id. It
+	// must only be set if a trusted proxy always sets or strips the header.
+	TrustedHeader string `yaml:"trustedheader,omitempty"`
+}
+
+// Audit configures the audit log, which records the write operations on
+// repositories with the identity of their actor.
+type Audit struct {
+	// Enabled enables the audit log.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Sink is where the records are written: storage or syslog.
+	Sink string `yaml:"sink,omitempty"`
+
+	// FailClosed fails the requests whose record cannot be written. By
+	// default, the failure is logged and the request succeeds.
+	FailClosed bool `yaml:"failclosed,omitempty"`
+
+	// Storage configures the storage sink, which writes each record to its
+	// own file through the storage driver.
+	Storage struct {
+		// RootDirectory is the directory the records are written to.
+		RootDirectory string `yaml:"rootdirectory,omitempty"`
+	} `yaml:"storage,omitempty"`
+
+	// Syslog configures the syslog sink.
+	Syslog struct {
+		// Network and Address locate the syslog server. If both are
+		// empty, the local syslog server is used.
+		Network string `yaml:"network,omitempty"`
+		Address string `yaml:"address,omitempty"`
+
+		// Tag is the syslog tag of the records.
+		Tag string `yaml:"tag,omitempty"`
+	} `yaml:"syslog,omitempty"`
+}
+
+// Coordination configures the election of a leader among the registry
+// instances sharing the storage, so that the background maintenance, such as
+// upload purging, proxy cache expiry and the replication journal scan, runs on
+// only one of them.
+type Coordination struct {
+	// Lock is the lock the instances compete for: redis, which requires the
+	// redis section, or storage, a lock file written through the storage
+	// driver. Coordination is disabled when it is empty, and each instance
+	// runs the background maintenance.
+	Lock string `yaml:"lock,omitempty"`
+
+	// TTL is how long the leadership lasts unless renewed, and so how long
+	// the maintenance stops when the leader fails. It is renewed every third
+	// of the TTL, 30s by default.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+}
+
+// Reporting configures the reporting of the registry to monitoring services.
+type Reporting struct {
+	// OTel configures the export of OpenTelemetry traces.
+	OTel OTel `yaml:"otel,omitempty"`
+}
+
+// OTel configures the export of the OpenTelemetry traces of the requests
+// served by the registry to an OTLP collector. Tracing is disabled when
+// Endpoint is empty, unless the standard OTEL_TRACES_EXPORTER or
+// OTEL_EXPORTER_OTLP_ENDPOINT environment variables configure an exporter.
+type OTel struct {
+	// Endpoint is the URL of the collector, such as
+	// http://localhost:4318. Traces are sent without TLS to http URLs.
+	Endpoint string `yaml:"endpoint,omitempty"`
+
+	// Protocol is the OTLP protocol spoken to the collector, grpc or
+	// http/protobuf, the default.
+	Protocol string `yaml:"protocol,omitempty"`
+
+	// Sampling is the ratio, between 0 and 1, of the traces started by the
+	// registry which are sampled. Requests which are part of a trace follow
+	// the sampling decision of their parent. Defaults to 1.
+	Sampling float64 `yaml:"sampling,omitempty"`
+}
+
+// Warnings configures the Warning headers, with the 299 code, added to
+// manifest responses. Each warning is a text/template message, executed
+// with the Repository, Tag and Digest of the request, and disabled when
+// empty. Warnings never change the status of the response.
+type Warnings struct {
+	// Schema1 is the warning of the schema1 manifest pushes, and of the
+	// pulls of clients which accept neither schema2 nor OCI manifests.
+	Schema1 string `yaml:"schema1,omitempty"`
+
+	// NoAnnotations is the warning of the pushes of OCI manifests and
+	// indexes without annotations.
+	NoAnnotations string `yaml:"noannotations,omitempty"`
+
+	// TagPulls lists the warnings of the manifest pulls by tag.
+	TagPulls []TagPullWarning `yaml:"tagpulls,omitempty"`
+}
+
+// validate checks that the messages are valid templates and the patterns
+// valid globs.
+func (w Warnings) validate() error {
+	for name, message := range map[string]string{"schema1": w.Schema1, "noannotations": w.NoAnnotations} {
+		if _, err := template.New(name).Parse(message); err != nil {
+			return err
+		}
+	}
+	for i, rule := range w.TagPulls {
+		if rule.Message == "" {
+			return fmt.Errorf("tagpulls[%d]: no message", i)
+		}
+		if _, err := template.New("tagpulls").Parse(rule.Message); err != nil {
+			return fmt.Errorf("tagpulls[%d]: %v", i, err)
+		}
+		for _, pattern := range append(append([]string(nil), rule.Repositories...), rule.Tags...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("tagpulls[%d]: invalid pattern %q: %v", i, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// TagPullWarning warns the clients pulling a manifest by tag from the
+// repositories matching Repositories.
+type TagPullWarning struct {
+	// Repositories lists globs (https://pkg.go.dev/path#Match) matching
+	// the repositories, or their parent namespaces, whose pulls by tag
+	// are warned. Empty matches all repositories.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Tags lists globs matching the tags whose pulls are warned. Empty
+	// matches all tags.
+	Tags []string `yaml:"tags,omitempty"`
+
+	// Message is the warning.
+	Message string `yaml:"message"`
 }
 
 // LogHook is composed of hook Level and Type.
@@ -364,6 +815,40 @@ type Health struct {
 		// unhealthy state
 		Threshold int `yaml:"threshold,omitempty"`
 	} `yaml:"storagedriver,omitempty"`
+	// Dependencies configures health checks on the services the registry
+	// depends on
+	Dependencies Dependencies `yaml:"dependencies,omitempty"`
+}
+
+// Dependencies configures health checks on the services the registry
+// depends on.
+type Dependencies struct {
+	// Degraded reports failing dependencies in the health status without
+	// making the registry unavailable
+	Degraded bool `yaml:"degraded,omitempty"`
+	// Redis checks the redis pool with PING
+	Redis DependencyChecker `yaml:"redis,omitempty"`
+	// Notifications checks every enabled notification endpoint, with a HEAD
+	// request to http endpoints and a TCP connection to the brokers of
+	// others
+	Notifications DependencyChecker `yaml:"notifications,omitempty"`
+	// TokenService checks the realm of the token authentication with a HEAD
+	// request
+	TokenService DependencyChecker `yaml:"tokenservice,omitempty"`
+}
+
+// DependencyChecker configures the health check of a dependency.
+type DependencyChecker struct {
+	// Enabled turns on the health check
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Interval is the duration in between checks
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// Timeout is the duration to wait for the dependency, the interval if
+	// zero
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Threshold is the number of times a check must fail to trigger an
+	// unhealthy state
+	Threshold int `yaml:"threshold,omitempty"`
 }
 
 // v0_1Configuration is a Version 0.1 Configuration struct
@@ -443,6 +928,20 @@ func (storage Storage) Type() string {
 			// allow configuration of redirect
 		case "tag":
 			// allow configuration of tag
+		case "replication":
+			// allow configuration of replication
+		case "manifest":
+			// allow configuration of manifest
+		case "walk":
+			// allow configuration of walk
+		case "verifyonread":
+			// allow configuration of verifyonread
+		case "blobs":
+			// allow configuration of blobs
+		case "refindex":
+			// allow configuration of refindex
+		case "timeouts":
+			// allow configuration of timeouts
 		default:
 			storageType = append(storageType, k)
 		}
@@ -499,6 +998,20 @@ func (storage *Storage) UnmarshalYAML(unmarshal func(interface{}) error) error {
 					// allow configuration of redirect
 				case "tag":
 					// allow configuration of tag
+				case "replication":
+					// allow configuration of replication
+				case "manifest":
+					// allow configuration of manifest
+				case "walk":
+					// allow configuration of walk
+				case "verifyonread":
+					// allow configuration of verifyonread
+				case "blobs":
+					// allow configuration of blobs
+				case "refindex":
+					// allow configuration of refindex
+				case "timeouts":
+					// allow configuration of timeouts
 				default:
 					types = append(types, k)
 				}
@@ -591,41 +1104,104 @@ func (auth Auth) MarshalYAML() (interface{}, error) {
 	return map[string]Parameters(auth), nil
 }
 
+// AccessPolicy defines the configuration for the access policy consulted
+// after authentication. Like Auth, it holds exactly one policy type.
+type AccessPolicy map[string]Parameters
+
+// Type returns the access policy type, such as webhook
+func (policy AccessPolicy) Type() string {
+	return Auth(policy).Type()
+}
+
+// Parameters returns the Parameters map for an AccessPolicy configuration
+func (policy AccessPolicy) Parameters() Parameters {
+	return Auth(policy).Parameters()
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+func (policy *AccessPolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var auth Auth
+	if err := auth.UnmarshalYAML(unmarshal); err != nil {
+		return err
+	}
+	*policy = AccessPolicy(auth)
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface
+func (policy AccessPolicy) MarshalYAML() (interface{}, error) {
+	return Auth(policy).MarshalYAML()
+}
+
 // Notifications configures multiple http endpoints.
 type Notifications struct {
 	// EventConfig is the configuration for the event format that is sent to each Endpoint.
 	EventConfig Events `yaml:"events,omitempty"`
-	// Endpoints is a list of http configurations for endpoints that
-	// respond to webhook notifications. In the future, we may allow other
-	// kinds of endpoints, such as external queues.
+	// Endpoints is a list of configurations for endpoints that receive
+	// notifications, either as http webhooks or through a message transport.
 	Endpoints []Endpoint `yaml:"endpoints,omitempty"`
 }
 
-// Endpoint describes the configuration of an http webhook notification
-// endpoint.
+// Endpoint describes the configuration of a notification endpoint. Events
+// are posted to an http webhook unless another transport is configured.
 type Endpoint struct {
-	Name              string        `yaml:"name"`              // identifies the endpoint in the registry instance.
-	Disabled          bool          `yaml:"disabled"`          // disables the endpoint
-	URL               string        `yaml:"url"`               // post url for the endpoint.
-	Headers           http.Header   `yaml:"headers"`           // static headers that should be added to all requests
-	Timeout           time.Duration `yaml:"timeout"`           // HTTP timeout
-	Threshold         int           `yaml:"threshold"`         // circuit breaker threshold before backing off on failure
-	Backoff           time.Duration `yaml:"backoff"`           // backoff duration
-	IgnoredMediaTypes []string      `yaml:"ignoredmediatypes"` // target media types to ignore
-	Ignore            Ignore        `yaml:"ignore"`            // ignore event types
+	Name              string        `yaml:"name"`                // identifies the endpoint in the registry instance.
+	Disabled          bool          `yaml:"disabled"`            // disables the endpoint
+	Transport         string        `yaml:"transport,omitempty"` // transport delivering the events, http if empty
+	Options           Parameters    `yaml:"options,omitempty"`   // options of a transport other than http
+	URL               string        `yaml:"url"`                 // post url for the endpoint.
+	Headers           http.Header   `yaml:"headers"`             // static headers that should be added to all requests
+	TLS               EndpointTLS   `yaml:"tls,omitempty"`       // client TLS settings of the http transport
+	SigningSecret     string        `yaml:"signingsecret"`       // key of the HMAC-SHA256 signature of request bodies, unsigned if empty
+	Timeout           time.Duration `yaml:"timeout"`             // HTTP timeout
+	Threshold         int           `yaml:"threshold"`           // deprecated: no longer used, failures are retried with exponential backoff
+	Backoff           time.Duration `yaml:"backoff"`             // initial backoff duration, doubled on every retry
+	MaxBackoff        time.Duration `yaml:"maxbackoff"`          // upper bound of the backoff duration
+	MaxRetries        int           `yaml:"maxretries"`          // retries before an event is dead lettered, zero retries forever
+	DeadLetter        DeadLetter    `yaml:"deadletter"`          // where events are sent once retries are exhausted
+	IgnoredMediaTypes []string      `yaml:"ignoredmediatypes"`   // target media types to ignore
+	Include           Filter        `yaml:"include,omitempty"`   // only send events matching all of these criteria
+	Ignore            Ignore        `yaml:"ignore"`              // ignore event types
+}
+
+// EndpointTLS configures the TLS client of a notification endpoint. CertFile
+// and KeyFile must be set together.
+type EndpointTLS struct {
+	CAFile             string `yaml:"cafile,omitempty"`             // PEM bundle of the CAs verifying the endpoint, system roots if empty
+	CertFile           string `yaml:"certfile,omitempty"`           // client certificate presented to the endpoint
+	KeyFile            string `yaml:"keyfile,omitempty"`            // private key of the client certificate
+	InsecureSkipVerify bool   `yaml:"insecureskipverify,omitempty"` // skips the verification of the endpoint certificate
+}
+
+// DeadLetter configures where the events an endpoint failed to deliver are
+// sent. At most one of URL and Path may be set; if neither is, such events
+// are dropped.
+type DeadLetter struct {
+	URL     string        `yaml:"url,omitempty"`     // post url of a secondary endpoint
+	Headers http.Header   `yaml:"headers,omitempty"` // static headers added to requests to the secondary endpoint
+	Timeout time.Duration `yaml:"timeout,omitempty"` // HTTP timeout of the secondary endpoint
+	Path    string        `yaml:"path,omitempty"`    // directory of the storage driver in which events are written
 }
 
 // Events configures notification events.
 type Events struct {
-	IncludeReferences bool `yaml:"includereferences"` // include reference data in manifest events
+	IncludeReferences bool `yaml:"includereferences"`       // include reference data in manifest events
+	MaxReferences     int  `yaml:"maxreferences,omitempty"` // references included per event, zero for no limit
 }
 
-// Ignore configures mediaTypes and actions of the event, that it won't be propagated
-type Ignore struct {
-	MediaTypes []string `yaml:"mediatypes"` // target media types to ignore
-	Actions    []string `yaml:"actions"`    // ignore action types
+// Filter selects events by target repository, action and target media type.
+// Repositories are matched by glob patterns, in which "*" matches within a
+// path component and "**" across components.
+type Filter struct {
+	Repositories []string `yaml:"repositories,omitempty"` // target repository patterns
+	MediaTypes   []string `yaml:"mediatypes,omitempty"`   // target media types
+	Actions      []string `yaml:"actions,omitempty"`      // action types
 }
 
+// Ignore configures mediaTypes, actions and repositories of the event, that
+// it won't be propagated
+type Ignore = Filter
+
 // Middleware configures named middlewares to be applied at injection points.
 type Middleware struct {
 	// Name the middleware registers itself as
@@ -651,6 +1227,103 @@ type Proxy struct {
 	// if not set, defaults to 7 * 24 hours
 	// If set to zero, will never expire cache
 	TTL *time.Duration `yaml:"ttl,omitempty"`
+
+	// MaxRetries is the number of times a rate limited request to the
+	// remote is retried before giving up. Zero disables retries.
+	MaxRetries int `yaml:"maxretries,omitempty"`
+
+	// MaxBackoff is the longest delay the proxy will wait before retrying
+	// a rate limited request. If the remote asks for a longer delay the
+	// request fails immediately. Zero does not limit the delay.
+	MaxBackoff time.Duration `yaml:"maxbackoff,omitempty"`
+
+	// CacheSizeLimit is the total size in bytes of cached blobs above which
+	// the least recently used repositories are evicted before their TTL
+	// expires. Zero disables the limit.
+	CacheSizeLimit int64 `yaml:"cachesizelimit,omitempty"`
+
+	// CheckpointInterval is how often the cache expiry state is written to
+	// storage. If not set, defaults to 5 seconds.
+	CheckpointInterval time.Duration `yaml:"checkpointinterval,omitempty"`
+
+	// PrefetchLayers enables fetching the layers of manifests pulled
+	// through the cache in the background.
+	PrefetchLayers bool `yaml:"prefetchlayers,omitempty"`
+
+	// AllowPush enables pushes through the cache. Pushed content is written
+	// to the remote first and then cached locally. The registry refuses to
+	// start if the remote credentials lack push access.
+	AllowPush bool `yaml:"allowpush,omitempty"`
+
+	// TokenRefreshBefore is how long before its expiry a bearer token for
+	// the remote is refreshed. Zero refreshes tokens once they expire.
+	TokenRefreshBefore time.Duration `yaml:"tokenrefreshbefore,omitempty"`
+
+	// ChallengeTTL is how long the authentication challenges of a remote
+	// host are cached before it is pinged again. If not set, defaults to
+	// one hour.
+	ChallengeTTL time.Duration `yaml:"challengettl,omitempty"`
+
+	// Failover is what a manifest lookup does when the local storage keeps
+	// failing after a retry: "remote", the default, fetches the manifest
+	// from the remote, and "error" fails the request.
+	Failover string `yaml:"failover,omitempty"`
+
+	// Transport tunes the HTTP connections to the remotes.
+	Transport HTTPTransport `yaml:"transport,omitempty"`
+
+	// Remotes lists further remote registries, each serving the
+	// repositories under a namespace prefix. Repositories matching no
+	// namespace are proxied from RemoteURL.
+	Remotes []ProxyRemote `yaml:"remotes,omitempty"`
+}
+
+// ProxyRemote configures a remote registry serving a namespace of a
+// pull-through cache.
+type ProxyRemote struct {
+	// Namespace is the repository name prefix routed to this remote, for
+	// example "quay.io". The prefix is removed from repository names sent
+	// to the remote.
+	Namespace string `yaml:"namespace"`
+
+	// RemoteURL is the URL of the remote registry
+	RemoteURL string `yaml:"remoteurl"`
+
+	// Username of the remote registry user
+	Username string `yaml:"username"`
+
+	// Password of the remote registry user
+	Password string `yaml:"password"`
+
+	// HTTPProxy is the URL of the HTTP proxy requests to this remote are
+	// sent through, overriding the httpproxy of the proxy transport.
+	HTTPProxy string `yaml:"httpproxy,omitempty"`
+}
+
+// HTTPTransport tunes the HTTP connections to an upstream. Zero values keep
+// the defaults of the Go HTTP client.
+type HTTPTransport struct {
+	// MaxIdleConns limits the idle connections kept across all hosts.
+	MaxIdleConns int `yaml:"maxidleconns,omitempty"`
+
+	// MaxIdleConnsPerHost limits the idle connections kept to each host.
+	MaxIdleConnsPerHost int `yaml:"maxidleconnsperhost,omitempty"`
+
+	// IdleConnTimeout is how long an idle connection is kept open.
+	IdleConnTimeout time.Duration `yaml:"idleconntimeout,omitempty"`
+
+	// TLSHandshakeTimeout limits the time spent on TLS handshakes.
+	TLSHandshakeTimeout time.Duration `yaml:"tlshandshaketimeout,omitempty"`
+
+	// HTTPProxy is the URL of the HTTP proxy requests are sent through. If
+	// empty, the proxy is taken from the HTTP_PROXY, HTTPS_PROXY and
+	// NO_PROXY environment variables.
+	HTTPProxy string `yaml:"httpproxy,omitempty"`
+
+	// HTTP2 is "force" to attempt HTTP/2 on every TLS connection, or
+	// "disable" to only use HTTP/1.1. If empty, HTTP/2 is negotiated as by
+	// the Go HTTP client.
+	HTTP2 string `yaml:"http2,omitempty"`
 }
 
 // Parse parses an input configuration yaml document into a Configuration struct
@@ -686,10 +1359,34 @@ func Parse(rd io.Reader) (*Configuration, error) {
 					if v0_1.Catalog.MaxEntries <= 0 {
 						v0_1.Catalog.MaxEntries = 1000
 					}
+					if v0_1.Referrers.PageSize <= 0 {
+						v0_1.Referrers.PageSize = 1000
+					}
 
 					if v0_1.Storage.Type() == "" {
 						return nil, errors.New("no storage configuration provided")
 					}
+
+					for _, endpoint := range v0_1.Notifications.Endpoints {
+						if (endpoint.TLS.CertFile == "") != (endpoint.TLS.KeyFile == "") {
+							return nil, fmt.Errorf("notifications endpoint %s: tls certfile and keyfile must be set together", endpoint.Name)
+						}
+					}
+
+					switch v0_1.Reporting.OTel.Protocol {
+					case "", "grpc", "http/protobuf":
+					default:
+						return nil, fmt.Errorf("reporting.otel: unsupported protocol %q", v0_1.Reporting.OTel.Protocol)
+					}
+					if v0_1.Reporting.OTel.Sampling < 0 || v0_1.Reporting.OTel.Sampling > 1 {
+						return nil, fmt.Errorf("reporting.otel: sampling must be between 0 and 1, got %v", v0_1.Reporting.OTel.Sampling)
+					}
+					if err := v0_1.Warnings.validate(); err != nil {
+						return nil, fmt.Errorf("warnings: %v", err)
+					}
+					if _, err := CORSHeaders(v0_1.HTTP.Headers); err != nil {
+						return nil, fmt.Errorf("http.headers: %v", err)
+					}
 					return (*Configuration)(v0_1), nil
 				}
 				return nil, fmt.Errorf("expected *v0_1Configuration, received %#v", c)
@@ -68,15 +68,21 @@ var configStruct = Configuration{
 	Catalog: Catalog{
 		MaxEntries: 1000,
 	},
+	Referrers: Referrers{
+		PageSize: 1000,
+	},
 	HTTP: struct {
-		Addr         string        `yaml:"addr,omitempty"`
-		Net          string        `yaml:"net,omitempty"`
-		Host         string        `yaml:"host,omitempty"`
-		Prefix       string        `yaml:"prefix,omitempty"`
-		Secret       string        `yaml:"secret,omitempty"`
-		RelativeURLs bool          `yaml:"relativeurls,omitempty"`
-		DrainTimeout time.Duration `yaml:"draintimeout,omitempty"`
-		TLS          struct {
+		Addr                 string        `yaml:"addr,omitempty"`
+		Net                  string        `yaml:"net,omitempty"`
+		Host                 string        `yaml:"host,omitempty"`
+		Prefix               string        `yaml:"prefix,omitempty"`
+		Secret               string        `yaml:"secret,omitempty"`
+		RelativeURLs         bool          `yaml:"relativeurls,omitempty"`
+		TrustedProxies       []string      `yaml:"trustedproxies,omitempty"`
+		ProxyProtocol        bool          `yaml:"proxyprotocol,omitempty"`
+		ProxyProtocolSources []string      `yaml:"proxyprotocolsources,omitempty"`
+		DrainTimeout         time.Duration `yaml:"draintimeout,omitempty"`
+		TLS                  struct {
 			Certificate  string   `yaml:"certificate,omitempty"`
 			Key          string   `yaml:"key,omitempty"`
 			ClientCAs    []string `yaml:"clientcas,omitempty"`
@@ -103,6 +109,15 @@ var configStruct = Configuration{
 		H2C struct {
 			Enabled bool `yaml:"enabled,omitempty"`
 		} `yaml:"h2c,omitempty"`
+		RateLimit           RateLimit           `yaml:"ratelimit,omitempty"`
+		MaxRequestBodyBytes MaxRequestBodyBytes `yaml:"maxrequestbodybytes,omitempty"`
+		Limits              Limits              `yaml:"limits,omitempty"`
+		ChunkMinLength      int64               `yaml:"chunkminlength,omitempty"`
+		RequestTimeout      RequestTimeout      `yaml:"requesttimeout,omitempty"`
+		UploadResume        UploadResume        `yaml:"uploadresume,omitempty"`
+		Info                Info                `yaml:"info,omitempty"`
+		RequestID           RequestID           `yaml:"requestid,omitempty"`
+		APIs                APIs                `yaml:"apis,omitempty"`
 	}{
 		TLS: struct {
 			Certificate  string   `yaml:"certificate,omitempty"`
@@ -411,6 +426,111 @@ func (suite *ConfigSuite) TestParseInvalidVersion() {
 	suite.Require().Error(err)
 }
 
+// TestParseEndpointTLS validates that the client certificate and key of a
+// notification endpoint are parsed, and must be set together.
+func (suite *ConfigSuite) TestParseEndpointTLS() {
+	configYaml := `version: 0.1
+storage: inmemory
+notifications:
+  endpoints:
+    - name: endpoint-1
+      url: https://example.com
+      signingsecret: s3cr3t
+      tls:
+        cafile: /path/to/ca.pem
+        certfile: /path/to/client.pem
+        keyfile: /path/to/client-key.pem
+`
+	config, err := Parse(bytes.NewReader([]byte(configYaml)))
+	suite.Require().NoError(err)
+	suite.Require().Equal(EndpointTLS{
+		CAFile:   "/path/to/ca.pem",
+		CertFile: "/path/to/client.pem",
+		KeyFile:  "/path/to/client-key.pem",
+	}, config.Notifications.Endpoints[0].TLS)
+	suite.Require().Equal("s3cr3t", config.Notifications.Endpoints[0].SigningSecret)
+
+	_, err = Parse(bytes.NewReader([]byte(strings.Replace(configYaml, "        keyfile: /path/to/client-key.pem\n", "", 1))))
+	suite.Require().ErrorContains(err, "certfile and keyfile must be set together")
+}
+
+// TestParseReportingOTel validates that the OpenTelemetry reporting options
+// are parsed, and that invalid protocols and sampling ratios are rejected.
+func (suite *ConfigSuite) TestParseReportingOTel() {
+	configYaml := `version: 0.1
+storage: inmemory
+reporting:
+  otel:
+    endpoint: http://collector:4317
+    protocol: grpc
+    sampling: 0.25
+`
+	config, err := Parse(bytes.NewReader([]byte(configYaml)))
+	suite.Require().NoError(err)
+	suite.Require().Equal(OTel{Endpoint: "http://collector:4317", Protocol: "grpc", Sampling: 0.25}, config.Reporting.OTel)
+
+	suite.T().Setenv("REGISTRY_REPORTING_OTEL_PROTOCOL", "thrift")
+	_, err = Parse(bytes.NewReader([]byte(configYaml)))
+	suite.Require().ErrorContains(err, "unsupported protocol")
+
+	suite.T().Setenv("REGISTRY_REPORTING_OTEL_PROTOCOL", "http/protobuf")
+	suite.T().Setenv("REGISTRY_REPORTING_OTEL_SAMPLING", "1.5")
+	_, err = Parse(bytes.NewReader([]byte(configYaml)))
+	suite.Require().ErrorContains(err, "sampling must be between 0 and 1")
+}
+
+// TestParseCORSHeaders validates that the CORS headers of http.headers
+// allowing any origin with credentials are rejected.
+func (suite *ConfigSuite) TestParseCORSHeaders() {
+	configYaml := `version: 0.1
+storage: inmemory
+http:
+  headers:
+    Access-Control-Allow-Origin: ["https://ui.example.com"]
+    Access-Control-Allow-Credentials: [true]
+`
+	config, err := Parse(bytes.NewReader([]byte(configYaml)))
+	suite.Require().NoError(err)
+	suite.Require().Equal([]string{"https://ui.example.com"}, config.HTTP.Headers.Values("Access-Control-Allow-Origin"))
+
+	configYaml = strings.Replace(configYaml, `"https://ui.example.com"`, `"*"`, 1)
+	_, err = Parse(bytes.NewReader([]byte(configYaml)))
+	suite.Require().ErrorContains(err, "cannot be combined with Access-Control-Allow-Credentials")
+}
+
+func (suite *ConfigSuite) TestParseWarnings() {
+	configYaml := `version: 0.1
+storage: inmemory
+warnings:
+  schema1: schema1 manifests are deprecated
+  tagpulls:
+    - repositories: [library/*]
+      tags: [latest]
+      message: pull {{.Repository}} by digest
+`
+	config, err := Parse(bytes.NewReader([]byte(configYaml)))
+	suite.Require().NoError(err)
+	suite.Require().Equal(Warnings{
+		Schema1: "schema1 manifests are deprecated",
+		TagPulls: []TagPullWarning{{
+			Repositories: []string{"library/*"},
+			Tags:         []string{"latest"},
+			Message:      "pull {{.Repository}} by digest",
+		}},
+	}, config.Warnings)
+
+	suite.T().Setenv("REGISTRY_WARNINGS_NOANNOTATIONS", "'{{.Digest'")
+	_, err = Parse(bytes.NewReader([]byte(configYaml)))
+	suite.Require().ErrorContains(err, "warnings:")
+
+	for _, tagPulls := range []string{"[{message: ''}]", "[{repositories: ['['], message: warned}]"} {
+		suite.T().Setenv("REGISTRY_WARNINGS_NOANNOTATIONS", "")
+		suite.T().Setenv("REGISTRY_WARNINGS_TAGPULLS", tagPulls)
+		_, err = Parse(bytes.NewReader([]byte(configYaml)))
+		suite.Require().ErrorContains(err, "warnings: tagpulls[0]", tagPulls)
+	}
+}
+
 // TestParseExtraneousVars validates that environment variables referring to
 // nonexistent variables don't cause side effects.
 func (suite *ConfigSuite) TestParseExtraneousVars() {
@@ -538,6 +658,7 @@ func copyConfig(config Configuration) *Configuration {
 	configCopy.Loglevel = config.Loglevel
 	configCopy.Log = config.Log
 	configCopy.Catalog = config.Catalog
+	configCopy.Referrers = config.Referrers
 	configCopy.Log.Fields = make(map[string]interface{}, len(config.Log.Fields))
 	for k, v := range config.Log.Fields {
 		configCopy.Log.Fields[k] = v
@@ -33,6 +33,30 @@ func (err ErrTagUnknown) Error() string {
 	return fmt.Sprintf("unknown tag=%s", err.Tag)
 }
 
+// ErrTagImmutable is returned if a tag which is immutable would be changed to
+// reference another manifest.
+type ErrTagImmutable struct {
+	Tag string
+	// Digest is the manifest the tag references.
+	Digest digest.Digest
+}
+
+func (err ErrTagImmutable) Error() string {
+	return fmt.Sprintf("tag %s is immutable and references %s", err.Tag, err.Digest)
+}
+
+// ErrContentCorrupt is returned if stored content does not match the digest
+// it is stored under.
+type ErrContentCorrupt struct {
+	Digest digest.Digest
+	// Actual is the digest of the stored content.
+	Actual digest.Digest
+}
+
+func (err ErrContentCorrupt) Error() string {
+	return fmt.Sprintf("stored content of %s has digest %s", err.Digest, err.Actual)
+}
+
 // ErrRepositoryUnknown is returned if the named repository is not known by
 // the registry.
 type ErrRepositoryUnknown struct {
@@ -43,6 +67,22 @@ func (err ErrRepositoryUnknown) Error() string {
 	return fmt.Sprintf("unknown repository name=%s", err.Name)
 }
 
+// ErrRepositoryEnumerationInterrupted is returned when an enumeration of
+// repositories fails midway. Last is the last repository which was ingested,
+// or empty if none was.
+type ErrRepositoryEnumerationInterrupted struct {
+	Last string
+	Err  error
+}
+
+func (err ErrRepositoryEnumerationInterrupted) Error() string {
+	return fmt.Sprintf("repository enumeration interrupted after %q: %v", err.Last, err.Err)
+}
+
+func (err ErrRepositoryEnumerationInterrupted) Unwrap() error {
+	return err.Err
+}
+
 // ErrRepositoryNameInvalid should be used to denote an invalid repository
 // name. Reason may set, indicating the cause of invalidity.
 type ErrRepositoryNameInvalid struct {
@@ -117,3 +157,38 @@ type ErrManifestNameInvalid struct {
 func (err ErrManifestNameInvalid) Error() string {
 	return fmt.Sprintf("manifest name %q invalid: %v", err.Name, err.Reason)
 }
+
+// ErrManifestMediaTypeNotAllowed is returned when a media type of a manifest
+// is not allowed in the repository. Kind is what the media type describes:
+// the manifest itself, its config, one of its layers or a manifest
+// referenced by an index.
+type ErrManifestMediaTypeNotAllowed struct {
+	Kind      string
+	MediaType string
+	Allowed   []string
+}
+
+func (err ErrManifestMediaTypeNotAllowed) Error() string {
+	return fmt.Sprintf("%s media type %q is not allowed, allowed media types: %s", err.Kind, err.MediaType, strings.Join(err.Allowed, ", "))
+}
+
+// ErrManifestImageConfigInvalid is returned when the config of an image
+// manifest does not describe its layers.
+type ErrManifestImageConfigInvalid struct {
+	Reason error
+}
+
+func (err ErrManifestImageConfigInvalid) Error() string {
+	return fmt.Sprintf("image config does not match the manifest: %v", err.Reason)
+}
+
+// ErrManifestNotCanonical is returned when a manifest is required to be
+// serialized in canonical form and is not. Canonical is the digest of its
+// canonical form.
+type ErrManifestNotCanonical struct {
+	Canonical digest.Digest
+}
+
+func (err ErrManifestNotCanonical) Error() string {
+	return fmt.Sprintf("manifest is not in canonical form, expected the payload with digest %v", err.Canonical)
+}
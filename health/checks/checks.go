@@ -61,6 +61,27 @@ func HTTPChecker(r string, statusCode int, timeout time.Duration, headers http.H
 	})
 }
 
+// ReachableHTTPChecker does a HEAD request with client and verifies that the
+// server answers without a server error. Other statuses, such as the refusal
+// of an unauthenticated request, show that the service is up.
+func ReachableHTTPChecker(r string, client *http.Client) health.Checker {
+	return health.CheckFunc(func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, r, nil)
+		if err != nil {
+			return fmt.Errorf("%v: error creating request: %w", r, err)
+		}
+		response, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("%v: error while checking: %w", r, err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("%v: downstream service returned unexpected status: %d", r, response.StatusCode)
+		}
+		return nil
+	})
+}
+
 // TCPChecker attempts to open a TCP connection.
 func TCPChecker(addr string, timeout time.Duration) health.Checker {
 	return health.CheckFunc(func(ctx context.Context) error {
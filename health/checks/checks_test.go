@@ -2,6 +2,8 @@ package checks
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -24,3 +26,29 @@ func TestHTTPChecker(t *testing.T) {
 		t.Errorf("Google at Portugal was expected as exists, error:%v", err)
 	}
 }
+
+func TestReachableHTTPChecker(t *testing.T) {
+	status := http.StatusUnauthorized
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("unexpected method %s", r.Method)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	checker := ReachableHTTPChecker(server.URL, server.Client())
+	if err := checker.Check(context.Background()); err != nil {
+		t.Errorf("a service refusing the request was expected as reachable, error:%v", err)
+	}
+
+	status = http.StatusBadGateway
+	if err := checker.Check(context.Background()); err == nil {
+		t.Errorf("a service failing with %d was expected as unreachable", status)
+	}
+
+	server.Close()
+	if err := checker.Check(context.Background()); err == nil {
+		t.Errorf("a closed server was expected as unreachable")
+	}
+}
@@ -35,6 +35,11 @@
 // After importing these packages to your main application, you can start
 // registering checks.
 //
+// The package-level functions register checks in DefaultRegistry, which is
+// shared by the whole process. Services embedded side by side in a process
+// should each create a Registry with NewRegistry, register their checks with
+// its methods and serve it with Registry.StatusHandler and Registry.Handler.
+//
 // # Registering Checks
 //
 // The recommended way of registering checks is using a periodic Check.
@@ -19,25 +19,30 @@ func init() {
 	http.HandleFunc("/debug/health", StatusHandler)
 }
 
-// A Registry is a collection of checks. Most applications will use the global
-// registry defined in DefaultRegistry. However, unit tests may need to create
-// separate registries to isolate themselves from other tests.
+// A Registry is a collection of checks. Each service embedded in a process
+// should have its own registry, so that their checks do not collide. The
+// global registry defined in DefaultRegistry remains for the package-level
+// functions.
 type Registry struct {
 	mu               sync.RWMutex
 	registeredChecks map[string]Checker
+	// nonCritical holds the names of the checks whose failures are reported
+	// without making the service unavailable.
+	nonCritical map[string]struct{}
 }
 
-// NewRegistry creates a new registry. This isn't necessary for normal use of
-// the package, but may be useful for unit tests so individual tests have their
-// own set of checks.
+// NewRegistry creates a new registry, with its own set of checks.
 func NewRegistry() *Registry {
 	return &Registry{
 		registeredChecks: make(map[string]Checker),
+		nonCritical:      make(map[string]struct{}),
 	}
 }
 
-// DefaultRegistry is the default registry where checks are registered. It is
-// the registry used by the HTTP handler.
+// DefaultRegistry is the registry used by the package-level functions and
+// served on "/debug/health" of http.DefaultServeMux. It is kept for
+// compatibility; the registry application registers its checks in a registry
+// of its own instead.
 var DefaultRegistry *Registry
 
 // Checker is the interface for a Health Checker
@@ -181,6 +186,20 @@ func (registry *Registry) CheckStatus(ctx context.Context) map[string]string { /
 	return statusKeys
 }
 
+// Unavailable reports whether checks, as returned by CheckStatus, contain the
+// failure of a check critical to the service. Failures of checks registered
+// with RegisterNonCritical only leave the service degraded.
+func (registry *Registry) Unavailable(checks map[string]string) bool {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	for name := range checks {
+		if _, ok := registry.nonCritical[name]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
 // CheckStatus returns a map with all the current health check errors from the
 // default registry.
 func CheckStatus(ctx context.Context) map[string]string {
@@ -201,12 +220,28 @@ func (registry *Registry) Register(name string, check Checker) {
 	registry.registeredChecks[name] = check
 }
 
+// RegisterNonCritical associates the checker with the provided name. Its
+// failures are reported by CheckStatus, but do not make the service
+// unavailable.
+func (registry *Registry) RegisterNonCritical(name string, check Checker) {
+	registry.Register(name, check)
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.nonCritical[name] = struct{}{}
+}
+
 // Register associates the checker with the provided name in the default
 // registry.
 func Register(name string, check Checker) {
 	DefaultRegistry.Register(name, check)
 }
 
+// RegisterNonCritical associates the checker with the provided name in the
+// default registry, without its failures making the service unavailable.
+func RegisterNonCritical(name string, check Checker) {
+	DefaultRegistry.RegisterNonCritical(name, check)
+}
+
 // RegisterFunc allows the convenience of registering a checker directly from
 // an arbitrary func(context.Context) error.
 func (registry *Registry) RegisterFunc(name string, check CheckFunc) {
@@ -221,14 +256,20 @@ func RegisterFunc(name string, check CheckFunc) {
 
 // StatusHandler returns a JSON blob with all the currently registered Health Checks
 // and their corresponding status.
-// Returns 503 if any Error status exists, 200 otherwise
+// Returns 503 if any critical check failed, 200 otherwise
 func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	DefaultRegistry.StatusHandler(w, r)
+}
+
+// StatusHandler returns a JSON blob with the status of the checks of the
+// registry. Returns 503 if any critical check failed, 200 otherwise.
+func (registry *Registry) StatusHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		checks := CheckStatus(r.Context())
+		checks := registry.CheckStatus(r.Context())
 		status := http.StatusOK
 
-		// If there is an error, return 503
-		if len(checks) != 0 {
+		// If a critical check failed, return 503
+		if registry.Unavailable(checks) {
 			status = http.StatusServiceUnavailable
 		}
 
@@ -238,14 +279,21 @@ func StatusHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Handler returns a handler that will return 503 response code if the health
-// checks have failed. If everything is okay with the health checks, the
-// handler will pass through to the provided handler. Use this handler to
-// disable a web application when the health checks fail.
+// Handler returns a handler that will return 503 response code if the
+// critical health checks have failed. If everything is okay with the health
+// checks, the handler will pass through to the provided handler. Use this
+// handler to disable a web application when the health checks fail.
 func Handler(handler http.Handler) http.Handler {
+	return DefaultRegistry.Handler(handler)
+}
+
+// Handler returns a handler that will return 503 response code if the
+// critical checks of the registry have failed, and pass through to handler
+// otherwise.
+func (registry *Registry) Handler(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		checks := CheckStatus(r.Context())
-		if len(checks) != 0 {
+		checks := registry.CheckStatus(r.Context())
+		if registry.Unavailable(checks) {
 			// NOTE(milosgajdos): disable errcheck as the error is
 			// accessible via /debug/health
 			// nolint:errcheck
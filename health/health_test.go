@@ -2,6 +2,7 @@ package health
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -49,6 +50,41 @@ func TestReturns503IfThereAreErrorChecks(t *testing.T) {
 	}
 }
 
+// TestNonCriticalChecks ensures that failing non-critical checks are
+// reported with a 200, and only critical ones make the service unavailable.
+func TestNonCriticalChecks(t *testing.T) {
+	registry := DefaultRegistry
+	defer func() { DefaultRegistry = registry }()
+	DefaultRegistry = NewRegistry()
+
+	RegisterNonCritical("dependency", CheckFunc(func(context.Context) error {
+		return errors.New("dependency down")
+	}))
+	updater := NewStatusUpdater()
+	Register("critical", updater)
+
+	check := func(expected int, expectedChecks int) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "https://fakeurl.com/debug/health", nil)
+		StatusHandler(recorder, req)
+		if recorder.Code != expected {
+			t.Fatalf("unexpected status: %d != %d", recorder.Code, expected)
+		}
+		var checks map[string]string
+		if err := json.Unmarshal(recorder.Body.Bytes(), &checks); err != nil {
+			t.Fatal(err)
+		}
+		if len(checks) != expectedChecks || checks["dependency"] != "dependency down" {
+			t.Fatalf("unexpected checks: %v", checks)
+		}
+	}
+
+	check(http.StatusOK, 1)
+	updater.Update(errors.New("critical down"))
+	check(http.StatusServiceUnavailable, 2)
+}
+
 // TestHealthHandler ensures that our handler implementation correct protects
 // the web application when things aren't so healthy.
 func TestHealthHandler(t *testing.T) {
@@ -108,6 +144,40 @@ func TestHealthHandler(t *testing.T) {
 	checkUp(t, "when server is back up") // now we should be back up.
 }
 
+// TestRegistryHandlers ensures that the handlers of a registry only report
+// its own checks, so that several registries can coexist in a process.
+func TestRegistryHandlers(t *testing.T) {
+	first, second := NewRegistry(), NewRegistry()
+	updater := NewStatusUpdater()
+	first.Register("shared_name", updater)
+	second.Register("shared_name", NewStatusUpdater())
+	updater.Update(errors.New("first is down"))
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for _, tc := range []struct {
+		registry *Registry
+		handled  int
+		status   int
+	}{
+		{first, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		{second, http.StatusNoContent, http.StatusOK},
+	} {
+		recorder := httptest.NewRecorder()
+		tc.registry.Handler(handler).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "https://fakeurl.com/", nil))
+		if recorder.Code != tc.handled {
+			t.Errorf("unexpected response code: %d != %d", recorder.Code, tc.handled)
+		}
+
+		recorder = httptest.NewRecorder()
+		tc.registry.StatusHandler(recorder, httptest.NewRequest(http.MethodGet, "https://fakeurl.com/debug/health", nil))
+		if recorder.Code != tc.status {
+			t.Errorf("unexpected status response code: %d != %d", recorder.Code, tc.status)
+		}
+	}
+}
+
 func TestThresholdStatusUpdater(t *testing.T) {
 	u := NewThresholdStatusUpdater(3)
 
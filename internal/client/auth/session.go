@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
@@ -108,6 +109,64 @@ func (ea *endpointAuthorizer) ModifyRequest(req *http.Request) error {
 	return nil
 }
 
+// Invalidator is implemented by authentication handlers which cache
+// credentials, such as the token handler.
+type Invalidator interface {
+	// Invalidate discards any cached credentials.
+	Invalidate()
+}
+
+// NewUnauthorizedRetryTransport returns a transport which retries a request
+// exactly once if it is rejected with 401 Unauthorized, after invalidating
+// the credentials cached by handlers. base is expected to authorize
+// requests, for example using an authorizer from NewAuthorizer. Requests
+// whose body cannot be replayed are not retried.
+func NewUnauthorizedRetryTransport(base http.RoundTripper, handlers ...AuthenticationHandler) http.RoundTripper {
+	return &unauthorizedRetryTransport{
+		base:     base,
+		handlers: handlers,
+	}
+}
+
+type unauthorizedRetryTransport struct {
+	base     http.RoundTripper
+	handlers []AuthenticationHandler
+}
+
+func (t *unauthorizedRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+
+	var invalidated bool
+	for _, handler := range t.handlers {
+		if i, ok := handler.(Invalidator); ok {
+			i.Invalidate()
+			invalidated = true
+		}
+	}
+	if !invalidated {
+		return resp, nil
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return t.base.RoundTrip(retry)
+}
+
 // This is the minimum duration a token can last (in seconds).
 // A token must not live less than 60 seconds because older versions
 // of the Docker client didn't read their expiration from the token
@@ -133,6 +192,8 @@ type tokenHandler struct {
 	tokenLock       sync.Mutex
 	tokenCache      string
 	tokenExpiration time.Time
+	refreshBefore   time.Duration
+	onTokenFetch    func(err error)
 
 	logger Logger
 }
@@ -198,6 +259,14 @@ type TokenHandlerOptions struct {
 	ClientID      string
 	Scopes        []Scope
 	Logger        Logger
+
+	// RefreshBefore is how long before its expiry a cached token is
+	// replaced with a fresh one.
+	RefreshBefore time.Duration
+
+	// OnTokenFetch, if set, is called with the result of every attempt to
+	// fetch a token.
+	OnTokenFetch func(err error)
 }
 
 // An implementation of clock for providing real time data.
@@ -234,6 +303,8 @@ func NewTokenHandlerWithOptions(options TokenHandlerOptions) AuthenticationHandl
 		scopes:        options.Scopes,
 		clock:         realClock{},
 		logger:        options.Logger,
+		refreshBefore: options.RefreshBefore,
+		onTokenFetch:  options.OnTokenFetch,
 	}
 
 	return handler
@@ -286,8 +357,11 @@ func (th *tokenHandler) getToken(ctx context.Context, params map[string]string,
 	}
 
 	now := th.clock.Now()
-	if now.After(th.tokenExpiration) || addedScopes {
+	if now.Add(th.refreshBefore).After(th.tokenExpiration) || addedScopes {
 		token, expiration, err := th.fetchToken(ctx, params, scopes)
+		if th.onTokenFetch != nil {
+			th.onTokenFetch(err)
+		}
 		if err != nil {
 			return "", err
 		}
@@ -304,6 +378,16 @@ func (th *tokenHandler) getToken(ctx context.Context, params map[string]string,
 	return th.tokenCache, nil
 }
 
+// Invalidate discards the cached token, so that the next request fetches a
+// fresh one.
+func (th *tokenHandler) Invalidate() {
+	th.tokenLock.Lock()
+	defer th.tokenLock.Unlock()
+
+	th.tokenCache = ""
+	th.tokenExpiration = time.Time{}
+}
+
 func hasScope(scopes []string, scope string) bool {
 	for _, s := range scopes {
 		if s == scope {
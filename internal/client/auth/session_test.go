@@ -875,3 +875,117 @@ func TestEndpointAuthorizeBasic(t *testing.T) {
 		t.Fatalf("Unexpected status code: %d, expected %d", resp.StatusCode, http.StatusAccepted)
 	}
 }
+
+func TestTokenHandlerRefreshBefore(t *testing.T) {
+	var fetches int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		fmt.Fprintf(w, `{"token":"token-%d","expires_in":120}`, fetches)
+	}))
+	defer s.Close()
+
+	var results []error
+	clock := &fakeClock{current: time.Now()}
+	th := NewTokenHandlerWithOptions(TokenHandlerOptions{
+		Transport:     http.DefaultTransport,
+		Scopes:        []Scope{RepositoryScope{Repository: "foo/bar", Actions: []string{"pull"}}},
+		RefreshBefore: 30 * time.Second,
+		OnTokenFetch: func(err error) {
+			results = append(results, err)
+		},
+	})
+	th.(*tokenHandler).clock = clock
+
+	params := map[string]string{"realm": s.URL}
+	authorize := func() string {
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com/v2/", nil)
+		if err := th.AuthorizeRequest(req, params); err != nil {
+			t.Fatal(err)
+		}
+		return req.Header.Get("Authorization")
+	}
+
+	if got := authorize(); got != "Bearer token-1" {
+		t.Fatalf("Unexpected authorization %q", got)
+	}
+
+	clock.current = clock.current.Add(80 * time.Second)
+	if got := authorize(); got != "Bearer token-1" {
+		t.Fatalf("Expected cached token, got %q", got)
+	}
+
+	// Within RefreshBefore of the expiry the token is refreshed.
+	clock.current = clock.current.Add(15 * time.Second)
+	if got := authorize(); got != "Bearer token-2" {
+		t.Fatalf("Expected refreshed token, got %q", got)
+	}
+
+	if len(results) != 2 || results[0] != nil || results[1] != nil {
+		t.Errorf("Expected 2 successful fetches to be reported, got %v", results)
+	}
+}
+
+func TestUnauthorizedRetryTransport(t *testing.T) {
+	var (
+		tokens   int
+		requests int
+		valid    = "token-2"
+	)
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			tokens++
+			fmt.Fprintf(w, `{"token":"token-%d","expires_in":3600}`, tokens)
+		case "/v2/foo":
+			requests++
+			if r.Header.Get("Authorization") == "Bearer "+valid {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			fallthrough
+		default:
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q`, s.URL+"/token"))
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer s.Close()
+
+	cm := challenge.NewSimpleManager()
+	if _, err := ping(cm, s.URL+"/v2/", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	th := NewTokenHandler(nil, nil, "foo", "pull")
+	c := &http.Client{
+		Transport: NewUnauthorizedRetryTransport(transport.NewTransport(nil, NewAuthorizer(cm, th)), th),
+	}
+
+	// The first token is rejected; it is discarded and the request retried.
+	resp, err := c.Get(s.URL + "/v2/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected retried request to succeed, got %d", resp.StatusCode)
+	}
+	if requests != 2 || tokens != 2 {
+		t.Errorf("Expected 2 requests and 2 tokens, got %d and %d", requests, tokens)
+	}
+
+	// A request that keeps failing is retried exactly once.
+	valid = "never"
+	requests = 0
+	resp, err = c.Get(s.URL + "/v2/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %d", resp.StatusCode)
+	}
+	if requests != 2 {
+		t.Errorf("Expected exactly one retry, got %d requests", requests)
+	}
+}
@@ -7,8 +7,12 @@ import (
 	"io"
 	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/distribution/distribution/v3/internal/client/auth/challenge"
+	"github.com/distribution/distribution/v3/internal/client/transport"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 )
 
@@ -38,6 +42,61 @@ func (e *UnexpectedHTTPResponseError) Error() string {
 	return fmt.Sprintf("error parsing HTTP %d response body: %s: %q", e.StatusCode, e.ParseErr.Error(), string(e.Response))
 }
 
+// ErrRateLimited is returned when the registry rejects a request with 429
+// Too Many Requests or 503 Service Unavailable and advertises when the
+// client may try again.
+type ErrRateLimited struct {
+	// RetryAfter is the delay advertised by the Retry-After header, or
+	// zero if the header was absent.
+	RetryAfter time.Duration
+
+	// Remaining is the value of the RateLimit-Remaining header, or -1 if
+	// the header was absent.
+	Remaining int
+
+	// Err is the error parsed from the response.
+	Err error
+}
+
+func (e ErrRateLimited) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited, retry after %s: %v", e.RetryAfter, e.Err)
+	}
+	return fmt.Sprintf("rate limited: %v", e.Err)
+}
+
+// Unwrap returns the error parsed from the response.
+func (e ErrRateLimited) Unwrap() error {
+	return e.Err
+}
+
+// rateLimitError wraps err in an ErrRateLimited if resp carries rate limit
+// headers.
+func rateLimitError(resp *http.Response, err error) error {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return err
+	}
+
+	retryAfter, hasRetryAfter := transport.RetryAfter(resp)
+	remaining := -1
+	if h := resp.Header.Get("RateLimit-Remaining"); h != "" {
+		// The header may carry a policy, e.g. "76;w=21600".
+		v, _, _ := strings.Cut(h, ";")
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			remaining = n
+		}
+	}
+	if !hasRetryAfter && remaining < 0 {
+		return err
+	}
+
+	return ErrRateLimited{
+		RetryAfter: retryAfter,
+		Remaining:  remaining,
+		Err:        err,
+	}
+}
+
 func parseHTTPErrorResponse(resp *http.Response) error {
 	var errors errcode.Errors
 	body, err := io.ReadAll(resp.Body)
@@ -127,11 +186,17 @@ func mergeErrors(err1, err2 error) error {
 // for unsuccessful HTTP response codes (in the range 400 - 499 inclusive).
 // If possible, it returns a typed error, but an UnexpectedHTTPStatusError
 // is returned for response code outside the expected range (HTTP status < 200
-// and > 500).
+// and > 500). Rate limited responses carrying Retry-After or
+// RateLimit-Remaining headers are returned as an ErrRateLimited wrapping
+// the parsed error.
 func HandleHTTPResponseError(resp *http.Response) error {
 	if resp.StatusCode >= 200 && resp.StatusCode <= 399 {
 		return nil
 	}
+	return rateLimitError(resp, handleHTTPResponseError(resp))
+}
+
+func handleHTTPResponseError(resp *http.Response) error {
 	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
 		// Check for OAuth errors within the `WWW-Authenticate` header first
 		// See https://tools.ietf.org/html/rfc6750#section-3
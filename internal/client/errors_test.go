@@ -2,10 +2,14 @@ package client
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/api/errcode"
 )
 
 type nopCloser struct {
@@ -165,3 +169,55 @@ func TestHandleHTTPResponseErrorNonJson(t *testing.T) {
 		t.Errorf("Expected %q, got: %q", msg, err.Error())
 	}
 }
+
+func TestHandleHTTPResponseErrorRateLimited(t *testing.T) {
+	json := `{"errors":[{"code":"TOOMANYREQUESTS","message":"slow down"}]}`
+	response := &http.Response{
+		Status:     "429 Too Many Requests",
+		StatusCode: 429,
+		Body:       nopCloser{bytes.NewBufferString(json)},
+		Header: http.Header{
+			"Content-Type":        []string{"application/json"},
+			"Retry-After":         []string{"30"},
+			"Ratelimit-Remaining": []string{"0;w=21600"},
+		},
+	}
+	err := HandleHTTPResponseError(response)
+
+	var rateLimited ErrRateLimited
+	if !errors.As(err, &rateLimited) {
+		t.Fatalf("Expected ErrRateLimited, got: %T", err)
+	}
+	if rateLimited.RetryAfter != 30*time.Second {
+		t.Errorf("Unexpected RetryAfter: %v", rateLimited.RetryAfter)
+	}
+	if rateLimited.Remaining != 0 {
+		t.Errorf("Unexpected Remaining: %d", rateLimited.Remaining)
+	}
+	var errs errcode.Errors
+	if !errors.As(err, &errs) || errs[0].(errcode.Error).Code != errcode.ErrorCodeTooManyRequests {
+		t.Errorf("Expected wrapped TOOMANYREQUESTS error, got: %v", rateLimited.Err)
+	}
+}
+
+func TestHandleHTTPResponseError503RetryAfter(t *testing.T) {
+	response := &http.Response{
+		Status:     "503 Service Unavailable",
+		StatusCode: 503,
+		Body:       nopCloser{bytes.NewBufferString("")},
+		Header:     http.Header{"Retry-After": []string{"5"}},
+	}
+	err := HandleHTTPResponseError(response)
+
+	var rateLimited ErrRateLimited
+	if !errors.As(err, &rateLimited) {
+		t.Fatalf("Expected ErrRateLimited, got: %T", err)
+	}
+	if rateLimited.RetryAfter != 5*time.Second || rateLimited.Remaining != -1 {
+		t.Errorf("Unexpected rate limit: %+v", rateLimited)
+	}
+	var statusErr *UnexpectedHTTPStatusError
+	if !errors.As(err, &statusErr) {
+		t.Errorf("Expected wrapped UnexpectedHTTPStatusError, got: %T", rateLimited.Err)
+	}
+}
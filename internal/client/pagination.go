@@ -0,0 +1,231 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/reference"
+)
+
+// TagPager lists the tags of a repository a page at a time. The TagService
+// of the repositories returned by NewRepository implements it.
+type TagPager interface {
+	// TagsPage returns up to n tags sorting after last, or the number of
+	// tags the registry returns by default if n is zero. io.EOF is returned
+	// with the last page.
+	TagsPage(ctx context.Context, last string, n int) ([]string, error)
+}
+
+// TagsPage returns up to n tags of the repository sorting after last, or the
+// number of tags the registry returns by default if n is zero. io.EOF is
+// returned with the last page.
+func (t *tags) TagsPage(ctx context.Context, last string, n int) ([]string, error) {
+	u, err := t.ub.BuildTagsURL(t.name, buildCatalogValues(n, last))
+	if err != nil {
+		return nil, err
+	}
+	pageURL, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := getListPage(ctx, t.client, pageURL, "tags")
+	if err != nil {
+		return nil, err
+	}
+	if page.isLast(n) {
+		return page.entries, io.EOF
+	}
+	return page.entries, nil
+}
+
+// TagIterator iterates over the tags of a repository, following the
+// pagination of the registry.
+type TagIterator struct {
+	pages pageIterator
+}
+
+// NewTagIterator returns a TagIterator over the tags of the repository name,
+// requesting pageSize tags at a time, or the number of tags the registry
+// returns by default if pageSize is zero.
+func NewTagIterator(name reference.Named, baseURL string, transport http.RoundTripper, pageSize int) (*TagIterator, error) {
+	ub, err := v2.NewURLBuilderFromString(baseURL, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TagIterator{
+		pages: pageIterator{
+			client: &http.Client{
+				Transport:     transport,
+				CheckRedirect: checkHTTPRedirect,
+			},
+			field:    "tags",
+			pageSize: pageSize,
+			pageURL: func(last string, n int) (string, error) {
+				return ub.BuildTagsURL(name, buildCatalogValues(n, last))
+			},
+		},
+	}, nil
+}
+
+// Next returns the next tag, or io.EOF once all the tags were returned.
+func (it *TagIterator) Next(ctx context.Context) (string, error) {
+	return it.pages.next(ctx)
+}
+
+// CatalogIterator iterates over the repositories of a registry, following
+// the pagination of the registry.
+type CatalogIterator struct {
+	pages pageIterator
+}
+
+// NewCatalogIterator returns a CatalogIterator over the repositories of the
+// registry at baseURL, requesting pageSize repositories at a time, or the
+// number of repositories the registry returns by default if pageSize is zero.
+func NewCatalogIterator(baseURL string, transport http.RoundTripper, pageSize int) (*CatalogIterator, error) {
+	ub, err := v2.NewURLBuilderFromString(baseURL, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CatalogIterator{
+		pages: pageIterator{
+			client: &http.Client{
+				Transport:     transport,
+				Timeout:       1 * time.Minute,
+				CheckRedirect: checkHTTPRedirect,
+			},
+			field:    "repositories",
+			pageSize: pageSize,
+			pageURL: func(last string, n int) (string, error) {
+				return ub.BuildCatalogURL(buildCatalogValues(n, last))
+			},
+		},
+	}, nil
+}
+
+// Next returns the next repository, or io.EOF once all the repositories were
+// returned.
+func (it *CatalogIterator) Next(ctx context.Context) (string, error) {
+	return it.pages.next(ctx)
+}
+
+// pageIterator iterates over the entries of a paginated list. The next page
+// is requested from the Link header of the previous response when the
+// registry provides one, and from the last entry otherwise.
+type pageIterator struct {
+	client   *http.Client
+	field    string
+	pageSize int
+	// pageURL returns the URL of the page of n entries sorting after last.
+	pageURL func(last string, n int) (string, error)
+
+	entries []string
+	nextURL *url.URL
+	last    string
+	// linked is set once the registry paginated with a Link header, after
+	// which a response without one ends the list.
+	linked bool
+	done   bool
+}
+
+func (it *pageIterator) next(ctx context.Context) (string, error) {
+	for len(it.entries) == 0 {
+		if it.done {
+			return "", io.EOF
+		}
+
+		pageURL := it.nextURL
+		if pageURL == nil {
+			u, err := it.pageURL(it.last, it.pageSize)
+			if err != nil {
+				return "", err
+			}
+			if pageURL, err = url.Parse(u); err != nil {
+				return "", err
+			}
+		}
+
+		page, err := getListPage(ctx, it.client, pageURL, it.field)
+		if err != nil {
+			return "", err
+		}
+		it.entries, it.nextURL = page.entries, page.next
+		it.linked = it.linked || page.next != nil
+		// An empty page ends the list, even if it links to another.
+		it.done = len(page.entries) == 0 || (it.linked && page.next == nil) || page.isLast(it.pageSize)
+	}
+
+	entry := it.entries[0]
+	it.entries = it.entries[1:]
+	it.last = entry
+	return entry, nil
+}
+
+// listPage is a page of a paginated list of tags or repositories.
+type listPage struct {
+	entries []string
+	// next is the URL of the following page, given by the Link header of
+	// the response.
+	next *url.URL
+}
+
+// isLast reports whether p is the last page of a list requested n entries
+// at a time. Without a Link header, only a partial page of an explicit size
+// shows that entries follow.
+func (p listPage) isLast(n int) bool {
+	return p.next == nil && (n <= 0 || len(p.entries) < n)
+}
+
+// getListPage gets the page of the list at pageURL, whose entries are the
+// field of the response body.
+func getListPage(ctx context.Context, client *http.Client, pageURL *url.URL, field string) (listPage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL.String(), nil)
+	if err != nil {
+		return listPage{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return listPage{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := HandleHTTPResponseError(resp); err != nil {
+		return listPage{}, err
+	}
+
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return listPage{}, err
+	}
+	var page listPage
+	if raw, ok := body[field]; ok {
+		if err := json.Unmarshal(raw, &page.entries); err != nil {
+			return listPage{}, err
+		}
+	}
+	page.next, err = nextPageURL(pageURL, resp.Header)
+	return page, err
+}
+
+// nextPageURL returns the URL of the Link header of a response to a request
+// for u, resolved against u, or nil if there is no Link header.
+func nextPageURL(u *url.URL, header http.Header) (*url.URL, error) {
+	link := header.Get("Link")
+	if link == "" {
+		return nil, nil
+	}
+	firstLink, _, _ := strings.Cut(link, ";")
+	linkURL, err := url.Parse(strings.Trim(strings.TrimSpace(firstLink), "<>"))
+	if err != nil {
+		return nil, err
+	}
+	return u.ResolveReference(linkURL), nil
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/reference"
+)
+
+// paginatedServer serves the tags of repository foo/bar and the catalog from
+// sorted lists. With links set, it paginates with Link headers carrying an
+// opaque token the next request must send back; otherwise it only honors the
+// n and last query parameters.
+type paginatedServer struct {
+	tags         []string
+	repositories []string
+	links        bool
+	requests     int
+}
+
+func (s *paginatedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests++
+	var field string
+	var entries []string
+	switch r.URL.Path {
+	case "/v2/foo/bar/tags/list":
+		field, entries = "tags", s.tags
+	case "/v2/_catalog":
+		field, entries = "repositories", s.repositories
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	last := query.Get("last")
+	if s.links && last != "" && query.Get("token") != "t"+last {
+		http.Error(w, "pagination token mismatch", http.StatusBadRequest)
+		return
+	}
+	start := sort.SearchStrings(entries, last)
+	if start < len(entries) && entries[start] == last {
+		start++
+	}
+	end := len(entries)
+	if n, err := strconv.Atoi(query.Get("n")); err == nil && start+n < end {
+		end = start + n
+	}
+	page := entries[start:end]
+
+	if s.links && end < len(entries) {
+		next := url.Values{"n": {query.Get("n")}, "last": {page[len(page)-1]}, "token": {"t" + page[len(page)-1]}}
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, next.Encode()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string][]string{field: page}); err != nil {
+		panic(err)
+	}
+}
+
+func TestTagIterator(t *testing.T) {
+	repo, _ := reference.WithName("foo/bar")
+	expected := []string{"a", "b", "c", "d", "e"}
+	ctx := dcontext.Background()
+
+	for _, links := range []bool{true, false} {
+		s := &paginatedServer{tags: expected, links: links}
+		server := httptest.NewServer(s)
+
+		it, err := NewTagIterator(repo, server.URL, nil, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tags []string
+		for {
+			tag, err := it.Next(ctx)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error iterating with links %t: %v", links, err)
+			}
+			tags = append(tags, tag)
+		}
+		server.Close()
+
+		if !reflect.DeepEqual(tags, expected) {
+			t.Errorf("unexpected tags with links %t: %v != %v", links, tags, expected)
+		}
+		if s.requests != 3 {
+			t.Errorf("unexpected number of requests with links %t: %d", links, s.requests)
+		}
+		if _, err := it.Next(ctx); err != io.EOF {
+			t.Errorf("expected io.EOF after the last tag, got %v", err)
+		}
+	}
+}
+
+func TestCatalogIterator(t *testing.T) {
+	expected := []string{"bar/a", "bar/b", "foo/bar", "foo/baz"}
+	ctx := dcontext.Background()
+
+	for _, links := range []bool{true, false} {
+		// A full last page without Link header needs an empty page to end
+		// the list.
+		s := &paginatedServer{repositories: expected, links: links}
+		server := httptest.NewServer(s)
+
+		it, err := NewCatalogIterator(server.URL, nil, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var repositories []string
+		for {
+			repository, err := it.Next(ctx)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error iterating with links %t: %v", links, err)
+			}
+			repositories = append(repositories, repository)
+		}
+		server.Close()
+
+		if !reflect.DeepEqual(repositories, expected) {
+			t.Errorf("unexpected repositories with links %t: %v != %v", links, repositories, expected)
+		}
+		if expectedRequests := map[bool]int{true: 2, false: 3}[links]; s.requests != expectedRequests {
+			t.Errorf("unexpected number of requests with links %t: %d != %d", links, s.requests, expectedRequests)
+		}
+	}
+}
+
+func TestTagsPage(t *testing.T) {
+	repo, _ := reference.WithName("foo/bar")
+	server := httptest.NewServer(&paginatedServer{tags: []string{"a", "b", "c", "d", "e"}})
+	defer server.Close()
+
+	r, err := NewRepository(repo, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := dcontext.Background()
+	pager, ok := r.Tags(ctx).(TagPager)
+	if !ok {
+		t.Fatal("tag service does not implement TagPager")
+	}
+
+	for _, tc := range []struct {
+		last     string
+		n        int
+		expected []string
+		err      error
+	}{
+		{last: "", n: 2, expected: []string{"a", "b"}},
+		{last: "b", n: 2, expected: []string{"c", "d"}},
+		{last: "d", n: 2, expected: []string{"e"}, err: io.EOF},
+		{last: "c", n: 0, expected: []string{"d", "e"}, err: io.EOF},
+	} {
+		tags, err := pager.TagsPage(ctx, tc.last, tc.n)
+		if err != tc.err {
+			t.Fatalf("unexpected error for last %q and n %d: %v != %v", tc.last, tc.n, err, tc.err)
+		}
+		if !reflect.DeepEqual(tags, tc.expected) {
+			t.Errorf("unexpected tags for last %q and n %d: %v != %v", tc.last, tc.n, tags, tc.expected)
+		}
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"net/url"
 	"path"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/distribution/distribution/v3"
@@ -235,17 +234,14 @@ func (t *tags) All(ctx context.Context) ([]string, error) {
 			return allTags, err
 		}
 		allTags = append(allTags, tagsResponse.Tags...)
-		if link := resp.Header.Get("Link"); link != "" {
-			firsLink, _, _ := strings.Cut(link, ";")
-			linkURL, err := url.Parse(strings.Trim(firsLink, "<>"))
-			if err != nil {
-				return allTags, err
-			}
-
-			listURL = listURL.ResolveReference(linkURL)
-		} else {
+		nextURL, err := nextPageURL(listURL, resp.Header)
+		if err != nil {
+			return allTags, err
+		}
+		if nextURL == nil {
 			return allTags, nil
 		}
+		listURL = nextURL
 	}
 }
 
@@ -414,6 +410,105 @@ func (ms *manifests) Exists(ctx context.Context, dgst digest.Digest) (bool, erro
 	return true, nil
 }
 
+// ManifestStatter describes manifests without fetching their content. The
+// ManifestService of the repositories returned by NewRepository implements
+// it.
+type ManifestStatter interface {
+	Stat(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Descriptor, error)
+}
+
+// Stat issues a HEAD request for the manifest dgst, or for the manifest
+// tagged with the distribution.WithTag option, and returns its descriptor
+// from the response headers. An unknown manifest is reported as
+// distribution.ErrManifestUnknownRevision, or distribution.ErrManifestUnknown
+// for a tag.
+func (ms *manifests) Stat(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Descriptor, error) {
+	var (
+		tag        string
+		mediaTypes []string
+	)
+	for _, option := range options {
+		switch opt := option.(type) {
+		case distribution.WithTagOption:
+			tag = opt.Tag
+		case distribution.WithManifestMediaTypesOption:
+			mediaTypes = opt.MediaTypes
+		default:
+			if err := option.Apply(ms); err != nil {
+				return distribution.Descriptor{}, err
+			}
+		}
+	}
+	if len(mediaTypes) == 0 {
+		mediaTypes = distribution.ManifestMediaTypes()
+	}
+
+	var (
+		ref reference.Named
+		err error
+	)
+	if tag != "" {
+		ref, err = reference.WithTag(ms.name, tag)
+	} else {
+		ref, err = reference.WithDigest(ms.name, dgst)
+	}
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	u, err := ms.ub.BuildManifestURL(ref)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	for _, t := range mediaTypes {
+		req.Header.Add("Accept", t)
+	}
+	resp, err := ms.client.Do(req)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		if tag != "" {
+			return distribution.Descriptor{}, distribution.ErrManifestUnknown{Name: ms.name.Name(), Tag: tag}
+		}
+		return distribution.Descriptor{}, distribution.ErrManifestUnknownRevision{Name: ms.name.Name(), Revision: dgst}
+	}
+	if err := HandleHTTPResponseError(resp); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	desc := distribution.Descriptor{MediaType: resp.Header.Get("Content-Type")}
+	if desc.MediaType == "" {
+		return distribution.Descriptor{}, errors.New("missing or empty Content-Type header")
+	}
+	if digestHeader := resp.Header.Get("Docker-Content-Digest"); digestHeader != "" {
+		if desc.Digest, err = digest.Parse(digestHeader); err != nil {
+			return distribution.Descriptor{}, err
+		}
+		if tag == "" && desc.Digest != dgst {
+			return distribution.Descriptor{}, fmt.Errorf("manifest %s has Docker-Content-Digest %s", dgst, desc.Digest)
+		}
+	} else if tag == "" {
+		desc.Digest = dgst
+	} else {
+		return distribution.Descriptor{}, errors.New("missing or empty Docker-Content-Digest header")
+	}
+	lengthHeader := resp.Header.Get("Content-Length")
+	if lengthHeader == "" {
+		return distribution.Descriptor{}, errors.New("missing or empty Content-Length header")
+	}
+	if desc.Size, err = strconv.ParseInt(lengthHeader, 10, 64); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return desc, nil
+}
+
 // AddEtagToTag allows a client to supply an eTag to Get which will be
 // used for a conditional HTTP request.  If the eTag matches, a nil manifest
 // and ErrManifestNotModified error will be returned. etag is automatically
@@ -446,12 +541,26 @@ func (o contentDigestOption) Apply(ms distribution.ManifestService) error {
 	return nil
 }
 
+// ReturnResponseHeader allows a client to collect the headers of the
+// response to a successful Get, for example to honor the caching directives
+// of the registry.
+func ReturnResponseHeader(header *http.Header) distribution.ManifestServiceOption {
+	return responseHeaderOption{header}
+}
+
+type responseHeaderOption struct{ header *http.Header }
+
+func (o responseHeaderOption) Apply(ms distribution.ManifestService) error {
+	return nil
+}
+
 func (ms *manifests) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
 	var (
 		digestOrTag string
 		ref         reference.Named
 		err         error
 		contentDgst *digest.Digest
+		respHeader  *http.Header
 		mediaTypes  []string
 	)
 
@@ -465,6 +574,8 @@ func (ms *manifests) Get(ctx context.Context, dgst digest.Digest, options ...dis
 			}
 		case contentDigestOption:
 			contentDgst = opt.digest
+		case responseHeaderOption:
+			respHeader = opt.header
 		case distribution.WithManifestMediaTypesOption:
 			mediaTypes = opt.MediaTypes
 		default:
@@ -510,19 +621,25 @@ func (ms *manifests) Get(ctx context.Context, dgst digest.Digest, options ...dis
 		return nil, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusNotModified {
-		return nil, distribution.ErrManifestNotModified
-	}
-	if err := HandleHTTPResponseError(resp); err != nil {
-		return nil, err
-	}
 
+	// The digest and headers are returned for 304 responses too, so that
+	// callers can check that the remote agrees with their cached copy.
 	if contentDgst != nil {
 		dgst, err := digest.Parse(resp.Header.Get("Docker-Content-Digest"))
 		if err == nil {
 			*contentDgst = dgst
 		}
 	}
+	if respHeader != nil {
+		*respHeader = resp.Header.Clone()
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, distribution.ErrManifestNotModified
+	}
+	if err := HandleHTTPResponseError(resp); err != nil {
+		return nil, err
+	}
+
 	mt := resp.Header.Get("Content-Type")
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -1140,6 +1141,70 @@ func TestOCIManifestFetch(t *testing.T) {
 	}
 }
 
+func TestManifestStat(t *testing.T) {
+	ctx := dcontext.Background()
+	repo, _ := reference.WithName("test.example.com/repo")
+	_, dgst, pl := newRandomOCIManifest(t, 6)
+	_, missing, _ := newRandomOCIManifest(t, 6)
+	var m testutil.RequestResponseMap
+	addTestManifest(repo, dgst.String(), v1.MediaTypeImageManifest, pl, &m)
+	addTestManifest(repo, "latest", v1.MediaTypeImageManifest, pl, &m)
+	addTestManifestWithoutDigestHeader(repo, "nodigest", v1.MediaTypeImageManifest, pl, &m)
+	for _, ref := range []string{missing.String(), "missing"} {
+		m = append(m, testutil.RequestResponseMapping{
+			Request: testutil.Request{
+				Method: http.MethodHead,
+				Route:  "/v2/" + repo.Name() + "/manifests/" + ref,
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusNotFound,
+			},
+		})
+	}
+
+	e, c := testServer(m)
+	defer c()
+
+	r, err := NewRepository(repo, e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := r.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statter, ok := ms.(ManifestStatter)
+	if !ok {
+		t.Fatal("manifest service does not implement ManifestStatter")
+	}
+
+	expected := distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: dgst, Size: int64(len(pl))}
+	desc, err := statter.Stat(ctx, dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(desc, expected) {
+		t.Fatalf("unexpected descriptor: %v != %v", desc, expected)
+	}
+	desc, err = statter.Stat(ctx, "", distribution.WithTag("latest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(desc, expected) {
+		t.Fatalf("unexpected descriptor for tag: %v != %v", desc, expected)
+	}
+
+	if _, err := statter.Stat(ctx, "", distribution.WithTag("nodigest")); err == nil {
+		t.Fatal("expected an error stating a tag without Docker-Content-Digest")
+	}
+	if _, err := statter.Stat(ctx, missing); !errors.As(err, &distribution.ErrManifestUnknownRevision{}) {
+		t.Fatalf("expected ErrManifestUnknownRevision, got %v", err)
+	}
+	if _, err := statter.Stat(ctx, "", distribution.WithTag("missing")); !errors.As(err, &distribution.ErrManifestUnknown{}) {
+		t.Fatalf("expected ErrManifestUnknown, got %v", err)
+	}
+}
+
 func TestManifestFetchWithEtag(t *testing.T) {
 	repo, _ := reference.WithName("test.example.com/repo/by/tag")
 	_, d1, p1 := newRandomOCIManifest(t, 6)
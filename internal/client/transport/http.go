@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTP2Mode selects whether a transport built by NewHTTPTransport speaks
+// HTTP/2.
+type HTTP2Mode string
+
+const (
+	// HTTP2Default negotiates HTTP/2 as http.DefaultTransport does.
+	HTTP2Default HTTP2Mode = ""
+	// HTTP2Force attempts HTTP/2 even where the transport would not by
+	// default, such as with a custom TLS configuration.
+	HTTP2Force HTTP2Mode = "force"
+	// HTTP2Disable restricts the transport to HTTP/1.1.
+	HTTP2Disable HTTP2Mode = "disable"
+)
+
+// HTTPOptions tunes the connections of a transport built by
+// NewHTTPTransport. Zero fields keep the values of http.DefaultTransport.
+type HTTPOptions struct {
+	// MaxIdleConns limits the idle connections kept across all hosts.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost limits the idle connections kept to each host.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept.
+	IdleConnTimeout time.Duration
+	// TLSHandshakeTimeout limits the time spent on TLS handshakes.
+	TLSHandshakeTimeout time.Duration
+	// Proxy is the URL of the HTTP proxy requests are sent through. If
+	// empty, the proxy is taken from the environment.
+	Proxy string
+	// HTTP2 selects whether HTTP/2 is used.
+	HTTP2 HTTP2Mode
+}
+
+// NewHTTPTransport returns a transport tuned by opts. With zero options
+// http.DefaultTransport itself is returned, sharing its connection pool.
+func NewHTTPTransport(opts HTTPOptions) (http.RoundTripper, error) {
+	if opts == (HTTPOptions{}) {
+		return http.DefaultTransport, nil
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.MaxIdleConns > 0 {
+		t.MaxIdleConns = opts.MaxIdleConns
+	}
+	if opts.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	}
+	if opts.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = opts.IdleConnTimeout
+	}
+	if opts.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	}
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", opts.Proxy, err)
+		}
+		if proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q: scheme and host are required", opts.Proxy)
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	switch opts.HTTP2 {
+	case HTTP2Default:
+	case HTTP2Force:
+		t.ForceAttemptHTTP2 = true
+	case HTTP2Disable:
+		// A non-nil empty TLSNextProto disables the HTTP/2 upgrade.
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(map[string]func(authority string, c *tls.Conn) http.RoundTripper)
+	default:
+		return nil, fmt.Errorf("unknown HTTP/2 mode %q", opts.HTTP2)
+	}
+
+	return t, nil
+}
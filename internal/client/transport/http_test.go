@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPTransportDefaults(t *testing.T) {
+	rt, err := NewHTTPTransport(HTTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt != http.DefaultTransport {
+		t.Fatalf("expected http.DefaultTransport without options, got %#v", rt)
+	}
+}
+
+func TestNewHTTPTransport(t *testing.T) {
+	rt, err := NewHTTPTransport(HTTPOptions{
+		MaxIdleConns:        500,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     30 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+		Proxy:               "http://proxy.example.com:3128",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, ok := rt.(*http.Transport)
+	if !ok {
+		t.Fatalf("unexpected transport type %T", rt)
+	}
+	if tr == http.DefaultTransport {
+		t.Fatal("expected http.DefaultTransport to be cloned")
+	}
+
+	if tr.MaxIdleConns != 500 {
+		t.Errorf("unexpected MaxIdleConns: %d", tr.MaxIdleConns)
+	}
+	if tr.MaxIdleConnsPerHost != 100 {
+		t.Errorf("unexpected MaxIdleConnsPerHost: %d", tr.MaxIdleConnsPerHost)
+	}
+	if tr.IdleConnTimeout != 30*time.Second {
+		t.Errorf("unexpected IdleConnTimeout: %v", tr.IdleConnTimeout)
+	}
+	if tr.TLSHandshakeTimeout != 5*time.Second {
+		t.Errorf("unexpected TLSHandshakeTimeout: %v", tr.TLSHandshakeTimeout)
+	}
+	if !tr.ForceAttemptHTTP2 {
+		t.Error("expected HTTP/2 to be attempted by default")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "https://registry.example.com/v2/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL, err := tr.Proxy(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (&url.URL{Scheme: "http", Host: "proxy.example.com:3128"}); proxyURL == nil || *proxyURL != *expected {
+		t.Errorf("unexpected proxy: %v != %v", proxyURL, expected)
+	}
+}
+
+func TestNewHTTPTransportKeepsDefaults(t *testing.T) {
+	rt, err := NewHTTPTransport(HTTPOptions{MaxIdleConnsPerHost: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := rt.(*http.Transport)
+	def := http.DefaultTransport.(*http.Transport)
+
+	if tr.MaxIdleConns != def.MaxIdleConns {
+		t.Errorf("unexpected MaxIdleConns: %d != %d", tr.MaxIdleConns, def.MaxIdleConns)
+	}
+	if tr.IdleConnTimeout != def.IdleConnTimeout {
+		t.Errorf("unexpected IdleConnTimeout: %v != %v", tr.IdleConnTimeout, def.IdleConnTimeout)
+	}
+	if tr.TLSHandshakeTimeout != def.TLSHandshakeTimeout {
+		t.Errorf("unexpected TLSHandshakeTimeout: %v != %v", tr.TLSHandshakeTimeout, def.TLSHandshakeTimeout)
+	}
+	if tr.ForceAttemptHTTP2 != def.ForceAttemptHTTP2 {
+		t.Errorf("unexpected ForceAttemptHTTP2: %t", tr.ForceAttemptHTTP2)
+	}
+	if tr.TLSNextProto != nil {
+		t.Error("expected HTTP/2 upgrade to be left enabled")
+	}
+}
+
+func TestNewHTTPTransportHTTP2(t *testing.T) {
+	rt, err := NewHTTPTransport(HTTPOptions{HTTP2: HTTP2Disable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := rt.(*http.Transport)
+	if tr.ForceAttemptHTTP2 || tr.TLSNextProto == nil || len(tr.TLSNextProto) != 0 {
+		t.Errorf("expected HTTP/2 to be disabled: ForceAttemptHTTP2 %t, TLSNextProto %v", tr.ForceAttemptHTTP2, tr.TLSNextProto)
+	}
+
+	rt, err = NewHTTPTransport(HTTPOptions{HTTP2: HTTP2Force})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr := rt.(*http.Transport); !tr.ForceAttemptHTTP2 || tr.TLSNextProto != nil {
+		t.Errorf("expected HTTP/2 to be forced: ForceAttemptHTTP2 %t, TLSNextProto %v", tr.ForceAttemptHTTP2, tr.TLSNextProto)
+	}
+
+	if _, err := NewHTTPTransport(HTTPOptions{HTTP2: "sometimes"}); err == nil {
+		t.Error("expected an error for an unknown HTTP/2 mode")
+	}
+}
+
+func TestNewHTTPTransportInvalidProxy(t *testing.T) {
+	for _, proxy := range []string{"proxy.example.com:3128", "http://[::1"} {
+		if _, err := NewHTTPTransport(HTTPOptions{Proxy: proxy}); err == nil {
+			t.Errorf("expected an error for proxy %q", proxy)
+		}
+	}
+}
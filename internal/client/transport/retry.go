@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRetryBackoff is the initial delay used when a rate limited response
+// carries no Retry-After header. It doubles with every attempt.
+const defaultRetryBackoff = time.Second
+
+// RetryAfter returns the delay advertised by the Retry-After header of resp,
+// which may be given either in seconds or as an HTTP date. The second return
+// value is false if the header is absent or malformed.
+func RetryAfter(resp *http.Response) (time.Duration, bool) {
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(h); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(h); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+
+	return 0, false
+}
+
+// NewRetryTransport returns a transport which transparently retries
+// idempotent requests that were rejected with 429 Too Many Requests, or with
+// 503 Service Unavailable and a Retry-After header. Requests are retried at
+// most maxRetries times. The delay between attempts honors Retry-After; if
+// the upstream asks for a longer delay than maxBackoff the response is
+// returned to the caller instead. A zero maxBackoff does not limit the delay.
+func NewRetryTransport(base http.RoundTripper, maxRetries int, maxBackoff time.Duration) http.RoundTripper {
+	return &retryTransport{
+		Base:       base,
+		MaxRetries: maxRetries,
+		MaxBackoff: maxBackoff,
+	}
+}
+
+type retryTransport struct {
+	Base       http.RoundTripper
+	MaxRetries int
+	MaxBackoff time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base().RoundTrip(req)
+		if err != nil || attempt >= t.MaxRetries || !retryable(req) {
+			return resp, err
+		}
+
+		wait, ok := t.backoff(resp, attempt)
+		if !ok {
+			return resp, nil
+		}
+
+		// Drain the body so the connection can be reused.
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns how long to wait before retrying the request that produced
+// resp, or false if it should not be retried.
+func (t *retryTransport) backoff(resp *http.Response, attempt int) (time.Duration, bool) {
+	wait, hasRetryAfter := RetryAfter(resp)
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		if !hasRetryAfter {
+			wait = defaultRetryBackoff << attempt
+			if t.MaxBackoff > 0 && wait > t.MaxBackoff {
+				wait = t.MaxBackoff
+			}
+		}
+	case http.StatusServiceUnavailable:
+		if !hasRetryAfter {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	if t.MaxBackoff > 0 && wait > t.MaxBackoff {
+		return 0, false
+	}
+	return wait, true
+}
+
+func (t *retryTransport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// retryable reports whether req can safely be sent again.
+func retryable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
@@ -0,0 +1,93 @@
+package transport
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer s.Close()
+
+	c := &http.Client{Transport: NewRetryTransport(nil, 3, time.Second)}
+	resp, err := c.Get(s.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error reading body: %v", err)
+	}
+	if string(body) != "ok" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Fatalf("unexpected number of requests: %d", n)
+	}
+}
+
+func TestRetryTransportGivesUp(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name       string
+		method     string
+		retryAfter string
+		maxRetries int
+		requests   int32
+	}{
+		{name: "retries exhausted", method: http.MethodGet, retryAfter: "0", maxRetries: 1, requests: 2},
+		{name: "backoff too long", method: http.MethodGet, retryAfter: "120", maxRetries: 3, requests: 1},
+		{name: "not idempotent", method: http.MethodPost, retryAfter: "0", maxRetries: 3, requests: 1},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var requests atomic.Int32
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.Header().Set("Retry-After", tc.retryAfter)
+				w.WriteHeader(http.StatusTooManyRequests)
+			}))
+			defer s.Close()
+
+			req, err := http.NewRequest(tc.method, s.URL, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			c := &http.Client{Transport: NewRetryTransport(nil, tc.maxRetries, time.Minute)}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusTooManyRequests {
+				t.Fatalf("unexpected status: %d", resp.StatusCode)
+			}
+			if n := requests.Load(); n != tc.requests {
+				t.Fatalf("unexpected number of requests: %d != %d", n, tc.requests)
+			}
+		})
+	}
+}
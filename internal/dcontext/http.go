@@ -11,6 +11,8 @@ import (
 	"github.com/distribution/distribution/v3/internal/requestutil"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Common errors used with this package.
@@ -25,21 +27,42 @@ var (
 // the prefix "http.request.". If a request is already present on the context,
 // this method will panic.
 func WithRequest(ctx context.Context, r *http.Request) context.Context {
+	return WithRequestID(ctx, r, "")
+}
+
+// WithRequestID places the request on the context as WithRequest does, but
+// assigns it id, such as an id set by a trusted proxy, rather than a unique
+// one. A unique id is assigned if id is empty.
+func WithRequestID(ctx context.Context, r *http.Request, id string) context.Context {
 	if ctx.Value("http.request") != nil {
 		// NOTE(stevvooe): This needs to be considered a programming error. It
 		// is unlikely that we'd want to have more than one request in
 		// context.
 		panic("only one request per context")
 	}
+	if id == "" {
+		id = uuid.NewString()
+	}
 
 	return &httpRequestContext{
 		Context:   ctx,
 		startedAt: time.Now(),
-		id:        uuid.NewString(),
+		id:        id,
 		r:         r,
 	}
 }
 
+// WithTraceContext returns a context carrying the remote span context of the
+// W3C traceparent and tracestate headers of r, if any. A context already
+// carrying a valid span context, such as the span of an instrumented
+// handler, is returned as is.
+func WithTraceContext(ctx context.Context, r *http.Request) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, propagation.HeaderCarrier(r.Header))
+}
+
 // GetRequestID attempts to resolve the current request id, if possible. An
 // error is return if it is not available on the context.
 func GetRequestID(ctx context.Context) string {
@@ -230,6 +253,11 @@ func (irw *instrumentedResponseWriter) Flush() {
 	}
 }
 
+// Unwrap returns the parent ResponseWriter, for http.ResponseController.
+func (irw *instrumentedResponseWriter) Unwrap() http.ResponseWriter {
+	return irw.ResponseWriter
+}
+
 func (irw *instrumentedResponseWriter) Value(key interface{}) interface{} {
 	if keyStr, ok := key.(string); ok {
 		switch keyStr {
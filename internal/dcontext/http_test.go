@@ -5,6 +5,8 @@ import (
 	"reflect"
 	"testing"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 func TestWithRequest(t *testing.T) {
@@ -92,6 +94,43 @@ func TestWithRequest(t *testing.T) {
 	}
 }
 
+func TestWithRequestID(t *testing.T) {
+	req := &http.Request{Header: make(http.Header)}
+
+	ctx := WithRequestID(Background(), req, "proxy-assigned-id")
+	if id := GetRequestID(ctx); id != "proxy-assigned-id" {
+		t.Fatalf("unexpected request id: %q != %q", id, "proxy-assigned-id")
+	}
+
+	ctx = WithRequestID(Background(), req, "")
+	if id := GetRequestID(ctx); id == "" {
+		t.Fatalf("request id not generated")
+	}
+}
+
+func TestWithTraceContext(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	req := &http.Request{Header: make(http.Header)}
+	ctx := WithTraceContext(Background(), req)
+	if _, ok := GetLogger(ctx).(*logrus.Entry).Data[traceIDField]; ok {
+		t.Fatalf("unexpected %s field without traceparent header", traceIDField)
+	}
+
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	ctx = WithTraceContext(Background(), req)
+	if v := GetLogger(ctx).(*logrus.Entry).Data[traceIDField]; v != traceID {
+		t.Fatalf("unexpected %s field: %v != %v", traceIDField, v, traceID)
+	}
+
+	// A context already carrying a span context is kept.
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	ctx = WithTraceContext(ctx, req)
+	if v := GetLogger(ctx).(*logrus.Entry).Data[traceIDField]; v != traceID {
+		t.Fatalf("span context replaced: %v != %v", v, traceID)
+	}
+}
+
 type testResponseWriter struct {
 	flushed bool
 	status  int
@@ -7,8 +7,14 @@ import (
 	"sync"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// traceIDField is the field of the loggers of contexts carrying a span
+// context, holding its W3C trace id. It is distinct from the "trace.id" of
+// WithTrace.
+const traceIDField = "otel.trace.id"
+
 var (
 	defaultLogger   *logrus.Entry = logrus.StandardLogger().WithField("go.version", runtime.Version())
 	defaultLoggerMu sync.RWMutex
@@ -128,6 +134,9 @@ func getLogrusLogger(ctx context.Context, keys ...interface{}) *logrus.Entry {
 	}
 
 	fields := logrus.Fields{}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		fields[traceIDField] = sc.TraceID().String()
+	}
 	for _, key := range keys {
 		v := ctx.Value(key)
 		if v != nil {
@@ -1,13 +1,13 @@
-package v2
+package requestutil
 
 import (
 	"testing"
 )
 
 // FuzzParseForwardedHeader implements a fuzzer
-// that targets parseForwardedHeader
+// that targets ParseForwardedHeader
 func FuzzParseForwardedHeader(f *testing.F) {
 	f.Fuzz(func(t *testing.T, data string) {
-		_, _, _ = parseForwardedHeader(data)
+		_, _, _ = ParseForwardedHeader(data)
 	})
 }
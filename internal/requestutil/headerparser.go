@@ -1,7 +1,8 @@
-package v2
+package requestutil
 
 import (
 	"fmt"
+	"net"
 	"regexp"
 	"strings"
 	"unicode"
@@ -14,7 +15,7 @@ var (
 	reEscapedCharacter = regexp.MustCompile(`^[[:blank:][:graph:]]`)
 )
 
-// parseForwardedHeader is a benevolent parser of Forwarded header defined in rfc7239. The header contains
+// ParseForwardedHeader is a benevolent parser of Forwarded header defined in rfc7239. The header contains
 // a comma-separated list of forwarding key-value pairs. Each list element is set by single proxy. The
 // function parses only the first element of the list, which is set by the very first proxy. It returns a map
 // of corresponding key-value pairs and an unparsed slice of the input string.
@@ -26,7 +27,7 @@ var (
 //
 // The first will be parsed into {"for": "192.0.2.43", "proto": "https"} while the second into
 // {"for": "192.0.2.43:443", "host": "registry.example.org"}.
-func parseForwardedHeader(forwarded string) (map[string]string, string, error) {
+func ParseForwardedHeader(forwarded string) (map[string]string, string, error) {
 	// Following are states of forwarded header parser. Any state could transition to a failure.
 	const (
 		// terminating state; can transition to Parameter
@@ -159,3 +160,30 @@ Loop:
 
 	return res, parse, nil
 }
+
+// ParseForwardedElements parses every element of a Forwarded header.
+func ParseForwardedElements(forwarded string) ([]map[string]string, error) {
+	var elements []map[string]string
+	for rest := forwarded; strings.TrimSpace(rest) != ""; {
+		element, r, err := ParseForwardedHeader(rest)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+		rest = r
+	}
+	return elements, nil
+}
+
+// ParseForwardedIP parses an IP address, optionally in brackets and followed
+// by a port, as found in RemoteAddr, X-Forwarded-For and the "for" parameter
+// of Forwarded. It returns nil for obfuscated identifiers and "unknown".
+func ParseForwardedIP(addr string) net.IP {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+}
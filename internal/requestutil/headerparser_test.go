@@ -1,4 +1,4 @@
-package v2
+package requestutil
 
 import (
 	"testing"
@@ -128,7 +128,7 @@ func TestParseForwardedHeader(t *testing.T) {
 			expectedError: true,
 		},
 	} {
-		parsed, rest, err := parseForwardedHeader(tc.raw)
+		parsed, rest, err := ParseForwardedHeader(tc.raw)
 		if err != nil && !tc.expectedError {
 			t.Errorf("[%s] got unexpected error: %v", tc.name, err)
 		}
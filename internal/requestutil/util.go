@@ -1,6 +1,7 @@
 package requestutil
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"strings"
@@ -49,3 +50,91 @@ func RemoteIP(r *http.Request) string {
 
 	return addr
 }
+
+// TrustedRemoteIP extracts the IP of the client of the request. The
+// Forwarded and X-Forwarded-For headers are only honored when the request
+// comes from one of trustedProxies, Forwarded taking precedence: the "for"
+// parameters of its elements, or the addresses of X-Forwarded-For, are then
+// read from right to left, skipping the trusted proxies, and the first
+// untrusted address is the client. Otherwise, the address of the peer is
+// returned, which the client cannot spoof.
+func TrustedRemoteIP(r *http.Request, trustedProxies []net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !NetworksContain(trustedProxies, ip) {
+		return host
+	}
+
+	var hops []string
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		elements, err := ParseForwardedElements(strings.Join(forwarded, ","))
+		if err != nil {
+			return ip.String()
+		}
+		for _, element := range elements {
+			hops = append(hops, element["for"])
+		}
+	} else {
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := ParseForwardedIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// The hops before an invalid or obfuscated one cannot be
+			// trusted.
+			break
+		}
+		ip = hop
+		if !NetworksContain(trustedProxies, ip) {
+			break
+		}
+	}
+	// If every hop is a trusted proxy, the leftmost one is the client.
+	return ip.String()
+}
+
+// NetworksContain reports whether one of networks contains ip.
+func NetworksContain(networks []net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNetworks parses networks in CIDR notation, or single IP addresses,
+// as listed in the trusted proxies and sources options. Blank entries are
+// skipped.
+func ParseNetworks(networks []string) ([]net.IPNet, error) {
+	var parsed []net.IPNet
+	for _, network := range networks {
+		network = strings.TrimSpace(network)
+		if network == "" {
+			continue
+		}
+		if !strings.Contains(network, "/") {
+			ip := net.ParseIP(network)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip address: %s", network)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			parsed = append(parsed, net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, *ipNet)
+	}
+	return parsed, nil
+}
@@ -1,6 +1,7 @@
 package requestutil
 
 import (
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
@@ -74,3 +75,62 @@ func TestRemoteAddr(t *testing.T) {
 	}
 	defer resp.Body.Close()
 }
+
+func TestTrustedRemoteIP(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustedProxies := []net.IPNet{*proxies}
+
+	for _, tc := range []struct {
+		name, remoteAddr, forwardedFor, forwarded, expected string
+	}{
+		{name: "direct", remoteAddr: "192.0.2.1:1234", expected: "192.0.2.1"},
+		{name: "untrusted peer", remoteAddr: "192.0.2.1:1234", forwardedFor: "198.51.100.1", expected: "192.0.2.1"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:1234", forwardedFor: "198.51.100.1", expected: "198.51.100.1"},
+		{name: "spoofed hop", remoteAddr: "10.0.0.1:1234", forwardedFor: "203.0.113.1, 198.51.100.1, 10.0.0.2", expected: "198.51.100.1"},
+		{name: "invalid hop", remoteAddr: "10.0.0.1:1234", forwardedFor: "198.51.100.1, garbage", expected: "10.0.0.1"},
+		{name: "only proxies", remoteAddr: "10.0.0.1:1234", forwardedFor: "10.0.0.3, 10.0.0.2", expected: "10.0.0.3"},
+		{name: "no header", remoteAddr: "10.0.0.1:1234", expected: "10.0.0.1"},
+		{name: "ipv6 client", remoteAddr: "10.0.0.1:1234", forwardedFor: "2001:db8::42", expected: "2001:db8::42"},
+		{name: "remote address without port", remoteAddr: "2001:db8::7", expected: "2001:db8::7"},
+		{name: "forwarded untrusted peer", remoteAddr: "192.0.2.1:1234", forwarded: "for=198.51.100.1", expected: "192.0.2.1"},
+		{name: "forwarded trusted proxy", remoteAddr: "10.0.0.1:1234", forwarded: "for=198.51.100.1;proto=https", expected: "198.51.100.1"},
+		{name: "forwarded spoofed hop", remoteAddr: "10.0.0.1:1234", forwarded: `for=203.0.113.1, for="198.51.100.1:4711", for=10.0.0.2`, expected: "198.51.100.1"},
+		{name: "forwarded ipv6 client", remoteAddr: "10.0.0.1:1234", forwarded: `for="[2001:db8::42]:4711"`, expected: "2001:db8::42"},
+		{name: "forwarded obfuscated hop", remoteAddr: "10.0.0.1:1234", forwarded: "for=198.51.100.1, for=_hidden", expected: "10.0.0.1"},
+		{name: "forwarded without for", remoteAddr: "10.0.0.1:1234", forwarded: "proto=https", expected: "10.0.0.1"},
+		{name: "invalid forwarded", remoteAddr: "10.0.0.1:1234", forwarded: `for="198.51.100.1`, expected: "10.0.0.1"},
+		{name: "forwarded precedence", remoteAddr: "10.0.0.1:1234", forwarded: "for=198.51.100.1", forwardedFor: "203.0.113.1", expected: "198.51.100.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			if tc.forwarded != "" {
+				r.Header.Set("Forwarded", tc.forwarded)
+			}
+			if ip := TrustedRemoteIP(r, trustedProxies); ip != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, ip)
+			}
+		})
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks([]string{"10.0.0.0/8", " 192.0.2.1", "", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 3 || networks[1].String() != "192.0.2.1/32" || networks[2].String() != "2001:db8::1/128" {
+		t.Fatalf("unexpected networks %v", networks)
+	}
+	for _, invalid := range []string{"10.0.0.0/33", "not-an-ip"} {
+		if _, err := ParseNetworks([]string{invalid}); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
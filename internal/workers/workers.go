@@ -0,0 +1,196 @@
+// Package workers bounds the goroutines spawned across the process to run
+// work concurrently.
+//
+// The pools of the package share the workers of a Semaphore. Work never waits
+// for a worker: when none is available, or when the work is submitted by a
+// worker, it runs synchronously in the submitting goroutine instead. This
+// keeps the number of goroutines bounded without deadlocking on work which
+// submits more work, such as a notification sink looking up tags.
+package workers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+)
+
+// DefaultLimit is the number of workers of DefaultSemaphore.
+const DefaultLimit = 1024
+
+var (
+	// activeWorkers is the number of workers running, by pool.
+	activeWorkers = prometheus.LimitsNamespace.NewLabeledGauge("active_workers", "The number of workers running", metrics.Total, "pool")
+
+	// queueWait is the time the work waits to run, by pool.
+	queueWait = prometheus.LimitsNamespace.NewLabeledTimer("worker_queue_wait", "The time work waits before it runs", "pool")
+)
+
+// DefaultSemaphore is the semaphore of the pools created without one.
+var DefaultSemaphore = NewSemaphore(DefaultLimit)
+
+// Semaphore limits the workers running at once across the pools sharing it.
+type Semaphore struct {
+	// slots has a slot per worker, nil if the work runs synchronously.
+	slots chan struct{}
+}
+
+// NewSemaphore returns a Semaphore limiting the workers to limit. With a
+// limit of 1 or less, all work runs synchronously.
+func NewSemaphore(limit int) *Semaphore {
+	if limit <= 1 {
+		return &Semaphore{}
+	}
+	return &Semaphore{slots: make(chan struct{}, limit)}
+}
+
+// tryAcquire takes a slot if one is free.
+func (s *Semaphore) tryAcquire() bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Semaphore) release() {
+	<-s.slots
+}
+
+// Pool runs a kind of work on the workers of a semaphore.
+type Pool struct {
+	name string
+	sem  *Semaphore
+}
+
+// New returns a Pool running work on the workers of sem, or of
+// DefaultSemaphore if sem is nil. The name of the pool labels its metrics.
+func New(name string, sem *Semaphore) *Pool {
+	if sem == nil {
+		sem = DefaultSemaphore
+	}
+	return &Pool{name: name, sem: sem}
+}
+
+type workerKey struct{}
+
+// inWorker reports whether ctx is the context of work run by a worker.
+func inWorker(ctx context.Context) bool {
+	_, ok := ctx.Value(workerKey{}).(bool)
+	return ok
+}
+
+// WithContext returns a Group running functions on the workers of the pool,
+// and a context derived from ctx which is canceled once a function of the
+// group fails or Wait returns. The functions of the group should use the
+// returned context, so that the work they submit runs synchronously rather
+// than competing with them for workers.
+func (p *Pool) WithContext(ctx context.Context) (*Group, context.Context) {
+	nested := inWorker(ctx)
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, workerKey{}, true))
+	return &Group{
+		pool:    p,
+		nested:  nested,
+		cancel:  cancel,
+		created: time.Now(),
+	}, ctx
+}
+
+// Group is a collection of functions run by the workers of a pool, as an
+// errgroup.Group. It never blocks to submit a function: the functions which
+// find no worker available run synchronously in Go.
+type Group struct {
+	pool *Pool
+	// nested is set if the group is created by a worker.
+	nested  bool
+	cancel  context.CancelFunc
+	created time.Time
+	// slots limits the functions of the group running on workers, nil if
+	// unlimited.
+	slots chan struct{}
+
+	wg      sync.WaitGroup
+	errOnce sync.Once
+	err     error
+}
+
+// SetLimit limits the functions of the group running on workers at once to
+// n, in addition to the limit of the pool. A negative n removes the limit,
+// and an n of 0 or 1 runs all the functions synchronously.
+func (g *Group) SetLimit(n int) {
+	if n < 0 {
+		g.slots = nil
+		return
+	}
+	if n <= 1 {
+		n = 0
+	}
+	g.slots = make(chan struct{}, n)
+}
+
+// Go runs f on a worker if one is available, otherwise before returning.
+func (g *Group) Go(f func() error) {
+	if !g.acquire() {
+		g.run(f)
+		return
+	}
+
+	g.wg.Add(1)
+	go func() {
+		activeWorkers.WithValues(g.pool.name).Inc(1)
+		defer func() {
+			activeWorkers.WithValues(g.pool.name).Dec(1)
+			g.release()
+			g.wg.Done()
+		}()
+		g.run(f)
+	}()
+}
+
+// Wait waits for the functions of the group, returning the first error
+// returned by any of them.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+func (g *Group) acquire() bool {
+	if g.nested {
+		return false
+	}
+	if g.slots != nil {
+		select {
+		case g.slots <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	if !g.pool.sem.tryAcquire() {
+		if g.slots != nil {
+			<-g.slots
+		}
+		return false
+	}
+	return true
+}
+
+func (g *Group) release() {
+	g.pool.sem.release()
+	if g.slots != nil {
+		<-g.slots
+	}
+}
+
+func (g *Group) run(f func() error) {
+	queueWait.WithValues(g.pool.name).UpdateSince(g.created)
+	if err := f(); err != nil {
+		g.errOnce.Do(func() {
+			g.err = err
+			g.cancel()
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrency records the functions running at once.
+type concurrency struct {
+	running, max atomic.Int32
+}
+
+func (c *concurrency) run(delay time.Duration) {
+	n := c.running.Add(1)
+	defer c.running.Add(-1)
+	for {
+		m := c.max.Load()
+		if n <= m || c.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	time.Sleep(delay)
+}
+
+func TestSynchronous(t *testing.T) {
+	for _, limit := range []int{-1, 0, 1} {
+		pool := New("test", NewSemaphore(limit))
+		g, _ := pool.WithContext(context.Background())
+		var order []int
+		for i := 0; i < 10; i++ {
+			i := i
+			g.Go(func() error {
+				order = append(order, i)
+				return nil
+			})
+			// The function ran before Go returned.
+			if len(order) != i+1 {
+				t.Fatalf("limit %d: expected function %d to run synchronously", limit, i)
+			}
+		}
+		if err := g.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSharedLimit(t *testing.T) {
+	const limit = 4
+	sem := NewSemaphore(limit)
+	var c concurrency
+
+	// The groups of the pools sharing the semaphore are limited together,
+	// the goroutines submitting the work running the rest of it.
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b", "a", "b"} {
+		pool := New(name, sem)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g, _ := pool.WithContext(context.Background())
+			for i := 0; i < 20; i++ {
+				g.Go(func() error {
+					c.run(5 * time.Millisecond)
+					return nil
+				})
+			}
+			if err := g.Wait(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if max := c.max.Load(); max > limit+4 {
+		t.Fatalf("expected at most %d functions running at once, got %d", limit+4, max)
+	}
+	if len(sem.slots) != 0 {
+		t.Fatalf("expected the workers to be released, %d are not", len(sem.slots))
+	}
+}
+
+func TestGroupLimit(t *testing.T) {
+	pool := New("test", NewSemaphore(100))
+	var c concurrency
+	g, _ := pool.WithContext(context.Background())
+	g.SetLimit(2)
+	for i := 0; i < 20; i++ {
+		g.Go(func() error {
+			c.run(5 * time.Millisecond)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	// Two workers, and the goroutine submitting the functions.
+	if max := c.max.Load(); max > 3 {
+		t.Fatalf("expected at most 3 functions running at once, got %d", max)
+	}
+}
+
+// TestNested ensures that the work submitted by workers runs, even though
+// the workers hold all the slots of the semaphore.
+func TestNested(t *testing.T) {
+	pool := New("test", NewSemaphore(2))
+	done := make(chan error)
+	go func() {
+		g, ctx := pool.WithContext(context.Background())
+		for i := 0; i < 4; i++ {
+			g.Go(func() error {
+				nested, _ := pool.WithContext(ctx)
+				if nested.acquire() {
+					return errors.New("nested work acquired a worker")
+				}
+				for j := 0; j < 4; j++ {
+					nested.Go(func() error {
+						time.Sleep(time.Millisecond)
+						return nil
+					})
+				}
+				return nested.Wait()
+			})
+		}
+		done <- g.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("nested work deadlocked")
+	}
+}
+
+func TestError(t *testing.T) {
+	pool := New("test", NewSemaphore(4))
+	g, ctx := pool.WithContext(context.Background())
+	failure := errors.New("failure")
+	g.Go(func() error { return failure })
+	g.Go(func() error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := g.Wait(); !errors.Is(err, failure) {
+		t.Fatalf("expected the first error, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Canonicalize returns the canonical form of a JSON manifest payload: compact
+// JSON, with the keys of objects sorted and strings minimally escaped.
+// Payloads differing only in whitespace, key order or string escaping have
+// the same canonical form, although their digests differ.
+func Canonicalize(payload []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after manifest JSON")
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	// Encode terminates the value with a newline.
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// IsCanonical reports whether payload is in canonical form, as returned by
+// Canonicalize.
+func IsCanonical(payload []byte) (bool, error) {
+	canonical, err := Canonicalize(payload)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(payload, canonical), nil
+}
+
+// Equivalent reports whether the payloads a and b have the same canonical
+// form, that is whether they describe the same manifest.
+func Equivalent(a, b []byte) (bool, error) {
+	ca, err := Canonicalize(a)
+	if err != nil {
+		return false, err
+	}
+	cb, err := Canonicalize(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ca, cb), nil
+}
@@ -0,0 +1,137 @@
+package manifest_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var (
+	testConfig = distribution.Descriptor{
+		MediaType: v1.MediaTypeImageConfig,
+		Digest:    "sha256:1a9ec845ee94c202b2d5da74a24f0ed2058318bfa9879fa541efaecba272e86b",
+		Size:      985,
+	}
+	testLayer = distribution.Descriptor{
+		MediaType: v1.MediaTypeImageLayerGzip,
+		Digest:    "sha256:62d8908bee94c202b2d35224a221aaa2058318bfa9879fa541efaecba272331b",
+		Size:      153263,
+		Annotations: map[string]string{
+			"org.example.note": "a <layer> & more",
+		},
+	}
+)
+
+func testPayloads(t *testing.T) map[string][]byte {
+	schema2Manifest, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    distribution.Descriptor{MediaType: schema2.MediaTypeImageConfig, Digest: testConfig.Digest, Size: testConfig.Size},
+		Layers:    []distribution.Descriptor{{MediaType: schema2.MediaTypeLayer, Digest: testLayer.Digest, Size: testLayer.Size}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ociManifest, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+		Config:    testConfig,
+		Layers:    []distribution.Descriptor{testLayer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ociIndex, err := ocischema.FromDescriptors([]distribution.Descriptor{
+		{MediaType: v1.MediaTypeImageManifest, Digest: testConfig.Digest, Size: 42, Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestList, err := manifestlist.FromDescriptors([]manifestlist.ManifestDescriptor{
+		{Descriptor: distribution.Descriptor{MediaType: schema2.MediaTypeManifest, Digest: testConfig.Digest, Size: 42}, Platform: manifestlist.PlatformSpec{OS: "linux", Architecture: "arm64"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := make(map[string][]byte)
+	for name, m := range map[string]distribution.Manifest{
+		"schema2":       schema2Manifest,
+		"oci manifest":  ociManifest,
+		"oci index":     ociIndex,
+		"manifest list": manifestList,
+	} {
+		_, payload, err := m.Payload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		payloads[name] = payload
+	}
+	return payloads
+}
+
+func TestCanonicalize(t *testing.T) {
+	for name, payload := range testPayloads(t) {
+		t.Run(name, func(t *testing.T) {
+			canonical, err := manifest.Canonicalize(payload)
+			if err != nil {
+				t.Fatalf("unexpected error canonicalizing: %v", err)
+			}
+			if ok, err := manifest.IsCanonical(canonical); err != nil || !ok {
+				t.Fatalf("canonical form is not canonical: %v %s", err, canonical)
+			}
+			// The builders indent manifests.
+			if ok, _ := manifest.IsCanonical(payload); ok {
+				t.Fatalf("indented payload reported as canonical: %s", payload)
+			}
+
+			// Re-serializing the decoded payload with other whitespace, key
+			// order and escaping gives an equivalent payload.
+			var v map[string]interface{}
+			if err := json.Unmarshal(payload, &v); err != nil {
+				t.Fatal(err)
+			}
+			reserialized, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := manifest.Equivalent(payload, reserialized); err != nil || !ok {
+				t.Fatalf("expected payloads to be equivalent: %v\n%s\n%s", err, payload, reserialized)
+			}
+
+			// Changing a value gives a different manifest.
+			v["schemaVersion"] = 3
+			changed, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := manifest.Equivalent(payload, changed); err != nil || ok {
+				t.Fatalf("expected payloads to differ: %v\n%s\n%s", err, payload, changed)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeForm(t *testing.T) {
+	canonical, err := manifest.Canonicalize([]byte(` { "b": "<é>", "a" : [ 1, 2.50, {"d": null, "c": true} ] } `))
+	if err != nil {
+		t.Fatalf("unexpected error canonicalizing: %v", err)
+	}
+	expected := `{"a":[1,2.50,{"c":true,"d":null}],"b":"<é>"}`
+	if string(canonical) != expected {
+		t.Fatalf("unexpected canonical form: %s != %s", canonical, expected)
+	}
+
+	for _, payload := range []string{"", "{", `{"a": 1} {"b": 2}`, `{"a": 1}]`} {
+		if _, err := manifest.Canonicalize([]byte(payload)); err == nil {
+			t.Errorf("expected error canonicalizing %q", payload)
+		}
+		if _, err := manifest.Equivalent([]byte(`{}`), []byte(payload)); err == nil {
+			t.Errorf("expected error comparing %q", payload)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package manifest
+
+import (
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+)
+
+// ErrDuplicateReference is returned by the manifest builders rejecting
+// duplicates when a reference with the digest and media type of Descriptor
+// was already appended.
+type ErrDuplicateReference struct {
+	Descriptor distribution.Descriptor
+}
+
+func (err ErrDuplicateReference) Error() string {
+	return fmt.Sprintf("duplicate reference %s with media type %q", err.Descriptor.Digest, err.Descriptor.MediaType)
+}
+
+// Duplicates is how the manifest builders handle the references appended
+// twice, with the same digest and media type.
+type Duplicates int
+
+const (
+	// AppendDuplicates appends the duplicate references again.
+	AppendDuplicates Duplicates = iota
+	// SkipDuplicates ignores the duplicate references.
+	SkipDuplicates
+	// RejectDuplicates fails to append the duplicate references with an
+	// ErrDuplicateReference error.
+	RejectDuplicates
+)
+
+// AppendReference appends desc to refs, handling desc as duplicates
+// requires if refs already has a reference with its digest and media type.
+func AppendReference(refs []distribution.Descriptor, desc distribution.Descriptor, duplicates Duplicates) ([]distribution.Descriptor, error) {
+	if duplicates != AppendDuplicates {
+		for _, ref := range refs {
+			if ref.Digest != desc.Digest || ref.MediaType != desc.MediaType {
+				continue
+			}
+			if duplicates == RejectDuplicates {
+				return refs, ErrDuplicateReference{Descriptor: desc}
+			}
+			return refs, nil
+		}
+	}
+	return append(refs, desc), nil
+}
@@ -0,0 +1,59 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
+)
+
+// imageConfig is the part of an OCI or Docker image config describing the
+// layers of the image.
+type imageConfig struct {
+	RootFS struct {
+		Type    string          `json:"type"`
+		DiffIDs []digest.Digest `json:"diff_ids"`
+	} `json:"rootfs"`
+	History []struct {
+		EmptyLayer bool `json:"empty_layer,omitempty"`
+	} `json:"history,omitempty"`
+}
+
+// ValidateImageConfig checks that configJSON, an OCI or Docker image config,
+// describes layers, the layers of its manifest: its rootfs must have a valid
+// diff_id per layer and its history, if any, must have an entry which is not
+// an empty layer per layer. It returns an error describing the first
+// inconsistency found.
+func ValidateImageConfig(configJSON []byte, layers []distribution.Descriptor) error {
+	var config imageConfig
+	if err := json.Unmarshal(configJSON, &config); err != nil {
+		return fmt.Errorf("invalid image config: %w", err)
+	}
+
+	if config.RootFS.Type != "layers" {
+		return fmt.Errorf("image config has rootfs type %q, expected \"layers\"", config.RootFS.Type)
+	}
+	if len(config.RootFS.DiffIDs) != len(layers) {
+		return fmt.Errorf("image config has %d diff_ids, but the manifest has %d layers", len(config.RootFS.DiffIDs), len(layers))
+	}
+	for i, diffID := range config.RootFS.DiffIDs {
+		if err := diffID.Validate(); err != nil {
+			return fmt.Errorf("image config has an invalid diff_id %q for layer %d: %w", diffID, i, err)
+		}
+	}
+
+	if len(config.History) == 0 {
+		return nil
+	}
+	var nonEmpty int
+	for _, h := range config.History {
+		if !h.EmptyLayer {
+			nonEmpty++
+		}
+	}
+	if nonEmpty != len(layers) {
+		return fmt.Errorf("image config history has %d entries creating layers, but the manifest has %d layers", nonEmpty, len(layers))
+	}
+	return nil
+}
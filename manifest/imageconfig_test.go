@@ -0,0 +1,61 @@
+package manifest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+)
+
+func TestValidateImageConfig(t *testing.T) {
+	layers := []distribution.Descriptor{testLayer, testLayer}
+
+	for _, tc := range []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{
+			name:   "valid",
+			config: `{"rootfs":{"type":"layers","diff_ids":["sha256:c6f988f4874bb0add23a778f753c65efe992244e148a1d2ec2a8b664fb66bbd1","sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef"]},"history":[{"created_by":"ADD"},{"created_by":"CMD","empty_layer":true},{"created_by":"RUN"}]}`,
+		},
+		{
+			name:   "no history",
+			config: `{"rootfs":{"type":"layers","diff_ids":["sha256:c6f988f4874bb0add23a778f753c65efe992244e148a1d2ec2a8b664fb66bbd1","sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef"]}}`,
+		},
+		{
+			name:   "malformed",
+			config: `{"rootfs":`,
+			err:    "invalid image config",
+		},
+		{
+			name:   "rootfs type",
+			config: `{"rootfs":{"type":"snapshots","diff_ids":[]}}`,
+			err:    `rootfs type "snapshots"`,
+		},
+		{
+			name:   "diff_ids count",
+			config: `{"rootfs":{"type":"layers","diff_ids":["sha256:c6f988f4874bb0add23a778f753c65efe992244e148a1d2ec2a8b664fb66bbd1"]}}`,
+			err:    "1 diff_ids, but the manifest has 2 layers",
+		},
+		{
+			name:   "invalid diff_id",
+			config: `{"rootfs":{"type":"layers","diff_ids":["sha256:c6f988f4874bb0add23a778f753c65efe992244e148a1d2ec2a8b664fb66bbd1","sha256:short"]}}`,
+			err:    "invalid diff_id",
+		},
+		{
+			name:   "history",
+			config: `{"rootfs":{"type":"layers","diff_ids":["sha256:c6f988f4874bb0add23a778f753c65efe992244e148a1d2ec2a8b664fb66bbd1","sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef"]},"history":[{"created_by":"ADD"},{"created_by":"CMD","empty_layer":true}]}`,
+			err:    "history has 1 entries creating layers",
+		},
+	} {
+		err := manifest.ValidateImageConfig([]byte(tc.config), layers)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package manifestlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+)
+
+// ListBuilderOption configures a ListBuilder.
+type ListBuilderOption func(*ListBuilder)
+
+// AllowDuplicatePlatforms permits more than one child manifest to be
+// appended for the same platform.
+func AllowDuplicatePlatforms() ListBuilderOption {
+	return func(lb *ListBuilder) {
+		lb.allowDuplicatePlatforms = true
+	}
+}
+
+// ListBuilder is a type for constructing manifest lists. It mirrors
+// ocischema.IndexBuilder for the Docker manifest list media type.
+type ListBuilder struct {
+	// manifests is a list of child manifest descriptors that gets built by
+	// successive calls to AppendManifest.
+	manifests []ManifestDescriptor
+
+	// platforms records the platforms already appended, keyed by their
+	// canonical string form.
+	platforms map[string]struct{}
+
+	allowDuplicatePlatforms bool
+}
+
+// NewListBuilder is used to build new manifest lists.
+func NewListBuilder(opts ...ListBuilderOption) *ListBuilder {
+	lb := &ListBuilder{
+		platforms: make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(lb)
+	}
+	return lb
+}
+
+// AppendManifest adds a child manifest for the given platform to the list.
+// The descriptor must carry a media type and digest.
+func (lb *ListBuilder) AppendManifest(desc distribution.Descriptor, platform PlatformSpec) error {
+	if desc.MediaType == "" {
+		return fmt.Errorf("manifest list builder: descriptor %s has no media type", desc.Digest)
+	}
+	if desc.Digest == "" {
+		return errors.New("manifest list builder: descriptor has no digest")
+	}
+	if err := desc.Digest.Validate(); err != nil {
+		return fmt.Errorf("manifest list builder: invalid digest %q: %w", desc.Digest, err)
+	}
+
+	key := platformKey(platform)
+	if _, ok := lb.platforms[key]; ok && !lb.allowDuplicatePlatforms {
+		return fmt.Errorf("manifest list builder: duplicate platform %s", key)
+	}
+	lb.platforms[key] = struct{}{}
+
+	// The platform is carried by the ManifestDescriptor, not the embedded
+	// descriptor.
+	desc.Platform = nil
+	lb.manifests = append(lb.manifests, ManifestDescriptor{
+		Descriptor: desc,
+		Platform:   platform,
+	})
+	return nil
+}
+
+// References returns the child manifests added to this builder.
+func (lb *ListBuilder) References() []ManifestDescriptor {
+	return lb.manifests
+}
+
+// Build produces the final manifest list. The payload is canonical: building
+// the same set of manifests always yields the same bytes.
+func (lb *ListBuilder) Build(ctx context.Context) (*DeserializedManifestList, error) {
+	return FromDescriptors(lb.manifests)
+}
+
+// platformKey returns a stable string identifying a platform.
+func platformKey(p PlatformSpec) string {
+	key := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		key += "/" + p.Variant
+	}
+	if p.OSVersion != "" {
+		key += ":" + p.OSVersion
+	}
+	if len(p.OSFeatures) > 0 {
+		features := make([]string, len(p.OSFeatures))
+		copy(features, p.OSFeatures)
+		sort.Strings(features)
+		key += "+" + strings.Join(features, ",")
+	}
+	if len(p.Features) > 0 {
+		features := make([]string, len(p.Features))
+		copy(features, p.Features)
+		sort.Strings(features)
+		key += "#" + strings.Join(features, ",")
+	}
+	return key
+}
@@ -0,0 +1,82 @@
+package manifestlist
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+)
+
+func TestListBuilder(t *testing.T) {
+	build := func() *DeserializedManifestList {
+		lb := NewListBuilder()
+		err := lb.AppendManifest(distribution.Descriptor{
+			MediaType: schema2.MediaTypeManifest,
+			Digest:    "sha256:1a9ec845ee94c202b2d5da74a24f0ed2058318bfa9879fa541efaecba272e86b",
+			Size:      985,
+		}, PlatformSpec{Architecture: "amd64", OS: "linux", Features: []string{"sse4"}})
+		if err != nil {
+			t.Fatalf("unexpected error appending manifest: %v", err)
+		}
+		err = lb.AppendManifest(distribution.Descriptor{
+			MediaType: schema2.MediaTypeManifest,
+			Digest:    "sha256:6346340964309634683409684360934680934608934608934608934068934608",
+			Size:      2392,
+		}, PlatformSpec{Architecture: "sun4m", OS: "sunos"})
+		if err != nil {
+			t.Fatalf("unexpected error appending manifest: %v", err)
+		}
+
+		ml, err := lb.Build(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error building manifest list: %v", err)
+		}
+		return ml
+	}
+
+	_, payload, err := build().Payload()
+	if err != nil {
+		t.Fatalf("unexpected error getting payload: %v", err)
+	}
+	if !bytes.Equal([]byte(expectedManifestListSerialization), payload) {
+		t.Fatalf("manifest list bytes not equal:\nexpected:\n%s\nactual:\n%s\n", expectedManifestListSerialization, string(payload))
+	}
+
+	_, again, _ := build().Payload()
+	if !bytes.Equal(payload, again) {
+		t.Fatal("manifest list payload is not stable across builds")
+	}
+}
+
+func TestListBuilderValidation(t *testing.T) {
+	linux := PlatformSpec{Architecture: "amd64", OS: "linux"}
+	desc := distribution.Descriptor{
+		MediaType: schema2.MediaTypeManifest,
+		Digest:    "sha256:1a9ec845ee94c202b2d5da74a24f0ed2058318bfa9879fa541efaecba272e86b",
+		Size:      985,
+	}
+
+	lb := NewListBuilder()
+	if err := lb.AppendManifest(distribution.Descriptor{Digest: desc.Digest}, linux); err == nil {
+		t.Error("expected error for descriptor without media type")
+	}
+	if err := lb.AppendManifest(distribution.Descriptor{MediaType: desc.MediaType}, linux); err == nil {
+		t.Error("expected error for descriptor without digest")
+	}
+	if err := lb.AppendManifest(desc, linux); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := lb.AppendManifest(desc, linux); err == nil {
+		t.Error("expected error for duplicate platform")
+	}
+
+	lb = NewListBuilder(AllowDuplicatePlatforms())
+	if err := lb.AppendManifest(desc, linux); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := lb.AppendManifest(desc, linux); err != nil {
+		t.Errorf("unexpected error for duplicate platform when allowed: %v", err)
+	}
+}
@@ -25,14 +25,50 @@ type Builder struct {
 	// Annotations contains arbitrary metadata relating to the targeted content.
 	annotations map[string]string
 
+	// validateConfig enables the validation of the config against the
+	// layers on Build.
+	validateConfig bool
+
+	// duplicates is how AppendReference handles duplicate references.
+	duplicates manifest.Duplicates
+
 	// For testing purposes
 	mediaType string
 }
 
+// BuilderOption configures a Builder.
+type BuilderOption func(*Builder)
+
+// WithConfigValidation makes Build fail if the image config does not
+// describe the layers of the manifest, as checked by
+// manifest.ValidateImageConfig.
+func WithConfigValidation() BuilderOption {
+	return func(mb *Builder) {
+		mb.validateConfig = true
+	}
+}
+
+// WithDeduplicateLayers makes AppendReference skip the references with the
+// digest and media type of a reference already appended.
+func WithDeduplicateLayers() BuilderOption {
+	return func(mb *Builder) {
+		mb.duplicates = manifest.SkipDuplicates
+	}
+}
+
+// WithRejectDuplicates makes AppendReference fail with a
+// manifest.ErrDuplicateReference error for the references with the digest
+// and media type of a reference already appended.
+func WithRejectDuplicates() BuilderOption {
+	return func(mb *Builder) {
+		mb.duplicates = manifest.RejectDuplicates
+	}
+}
+
 // NewManifestBuilder is used to build new manifests for the current schema
 // version. It takes a BlobService so it can publish the configuration blob
 // as part of the Build process, and annotations.
-func NewManifestBuilder(bs distribution.BlobService, configJSON []byte, annotations map[string]string) distribution.ManifestBuilder {
+func NewManifestBuilder(bs distribution.BlobService, configJSON []byte, annotations map[string]string, opts ...BuilderOption) distribution.ManifestBuilder {
 	mb := &Builder{
 		bs:          bs,
 		configJSON:  make([]byte, len(configJSON)),
@@ -40,6 +76,9 @@ func NewManifestBuilder(bs distribution.BlobService, configJSON []byte, annotati
 		mediaType:   v1.MediaTypeImageManifest,
 	}
 	copy(mb.configJSON, configJSON)
+	for _, opt := range opts {
+		opt(mb)
+	}
 
 	return mb
 }
@@ -57,6 +96,12 @@ func (mb *Builder) SetMediaType(mediaType string) error {
 
 // Build produces a final manifest from the given references.
 func (mb *Builder) Build(ctx context.Context) (distribution.Manifest, error) {
+	if mb.validateConfig {
+		if err := manifest.ValidateImageConfig(mb.configJSON, mb.layers); err != nil {
+			return nil, err
+		}
+	}
+
 	m := Manifest{
 		Versioned: manifest.Versioned{
 			SchemaVersion: 2,
@@ -97,7 +142,11 @@ func (mb *Builder) Build(ctx context.Context) (distribution.Manifest, error) {
 
 // AppendReference adds a reference to the current ManifestBuilder.
 func (mb *Builder) AppendReference(d distribution.Describable) error {
-	mb.layers = append(mb.layers, d.Descriptor())
+	refs, err := manifest.AppendReference(mb.layers, d.Descriptor(), mb.duplicates)
+	if err != nil {
+		return err
+	}
+	mb.layers = refs
 	return nil
 }
 
@@ -2,10 +2,12 @@ package ocischema
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"testing"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
 	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
@@ -156,3 +158,80 @@ func TestBuilder(t *testing.T) {
 		t.Fatal("References() does not match the descriptors added")
 	}
 }
+
+func TestBuilderConfigValidation(t *testing.T) {
+	imgJSON := []byte(`{"rootfs":{"type":"layers","diff_ids":["sha256:c6f988f4874bb0add23a778f753c65efe992244e148a1d2ec2a8b664fb66bbd1"]},"history":[{"created_by":"ADD"},{"created_by":"CMD","empty_layer":true}]}`)
+	layer := distribution.Descriptor{
+		MediaType: v1.MediaTypeImageLayerGzip,
+		Digest:    digest.Digest("sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"),
+		Size:      5312,
+	}
+
+	for _, tc := range []struct {
+		layers int
+		valid  bool
+	}{
+		{layers: 0},
+		{layers: 1, valid: true},
+		{layers: 2},
+	} {
+		bs := &mockBlobService{descriptors: make(map[digest.Digest]distribution.Descriptor)}
+		builder := NewManifestBuilder(bs, imgJSON, nil, WithConfigValidation())
+		for i := 0; i < tc.layers; i++ {
+			if err := builder.AppendReference(layer); err != nil {
+				t.Fatalf("AppendReference returned error: %v", err)
+			}
+		}
+		_, err := builder.Build(context.Background())
+		if tc.valid != (err == nil) {
+			t.Errorf("%d layers: unexpected Build result: %v", tc.layers, err)
+		}
+		// The config is not published if it is invalid.
+		if _, statErr := bs.Stat(context.Background(), digest.FromBytes(imgJSON)); (statErr == nil) != tc.valid {
+			t.Errorf("%d layers: unexpected config publication: %v", tc.layers, statErr)
+		}
+	}
+}
+
+func TestBuilderDuplicates(t *testing.T) {
+	layer := distribution.Descriptor{
+		MediaType: v1.MediaTypeImageLayerGzip,
+		Digest:    digest.Digest("sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"),
+		Size:      32,
+	}
+	// The same content with another media type is not a duplicate.
+	otherMediaType := layer
+	otherMediaType.MediaType = v1.MediaTypeImageLayer
+	appended := []distribution.Descriptor{layer, layer, otherMediaType}
+
+	for _, tc := range []struct {
+		name     string
+		opts     []BuilderOption
+		expected []distribution.Descriptor
+		rejected bool
+	}{
+		{name: "default", expected: appended},
+		{name: "deduplicate", opts: []BuilderOption{WithDeduplicateLayers()}, expected: []distribution.Descriptor{layer, otherMediaType}},
+		{name: "reject", opts: []BuilderOption{WithRejectDuplicates()}, expected: []distribution.Descriptor{layer, otherMediaType}, rejected: true},
+	} {
+		bs := &mockBlobService{descriptors: make(map[digest.Digest]distribution.Descriptor)}
+		builder := NewManifestBuilder(bs, []byte("{}"), nil, tc.opts...)
+		var rejected bool
+		for _, d := range appended {
+			err := builder.AppendReference(d)
+			var duplicate manifest.ErrDuplicateReference
+			switch {
+			case errors.As(err, &duplicate) && duplicate.Descriptor.Digest == layer.Digest:
+				rejected = true
+			case err != nil:
+				t.Fatalf("%s: AppendReference returned error: %v", tc.name, err)
+			}
+		}
+		if rejected != tc.rejected {
+			t.Errorf("%s: expected rejection %t, got %t", tc.name, tc.rejected, rejected)
+		}
+		if !reflect.DeepEqual(builder.References(), tc.expected) {
+			t.Errorf("%s: unexpected references: %v", tc.name, builder.References())
+		}
+	}
+}
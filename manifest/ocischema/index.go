@@ -54,9 +54,17 @@ func init() {
 type ImageIndex struct {
 	manifest.Versioned
 
+	// ArtifactType is the type of the artifact the manifest describes, if
+	// any.
+	ArtifactType string `json:"artifactType,omitempty"`
+
 	// Manifests references a list of manifests
 	Manifests []distribution.Descriptor `json:"manifests"`
 
+	// Subject is the manifest this manifest refers to, as when it is
+	// attached to an image.
+	Subject *distribution.Descriptor `json:"subject,omitempty"`
+
 	// Annotations is an optional field that contains arbitrary metadata for the
 	// image index
 	Annotations map[string]string `json:"annotations,omitempty"`
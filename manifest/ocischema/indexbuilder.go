@@ -0,0 +1,122 @@
+package ocischema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// IndexBuilderOption configures an IndexBuilder.
+type IndexBuilderOption func(*IndexBuilder)
+
+// AllowDuplicatePlatforms permits more than one child manifest to be
+// appended for the same platform.
+func AllowDuplicatePlatforms() IndexBuilderOption {
+	return func(ib *IndexBuilder) {
+		ib.allowDuplicatePlatforms = true
+	}
+}
+
+// IndexBuilder is a type for constructing OCI image indexes.
+type IndexBuilder struct {
+	// manifests is a list of child manifest descriptors that gets built by
+	// successive calls to AppendManifest.
+	manifests []distribution.Descriptor
+
+	// annotations contains arbitrary metadata relating to the index.
+	annotations map[string]string
+
+	// platforms records the platforms already appended, keyed by their
+	// canonical string form.
+	platforms map[string]struct{}
+
+	allowDuplicatePlatforms bool
+}
+
+// NewIndexBuilder is used to build new OCI image indexes. The annotations
+// are copied into the resulting index.
+func NewIndexBuilder(annotations map[string]string, opts ...IndexBuilderOption) *IndexBuilder {
+	ib := &IndexBuilder{
+		platforms: make(map[string]struct{}),
+	}
+	for k, v := range annotations {
+		ib.SetAnnotation(k, v)
+	}
+	for _, opt := range opts {
+		opt(ib)
+	}
+	return ib
+}
+
+// SetAnnotation sets an annotation on the index itself.
+func (ib *IndexBuilder) SetAnnotation(key, value string) {
+	if ib.annotations == nil {
+		ib.annotations = make(map[string]string)
+	}
+	ib.annotations[key] = value
+}
+
+// AppendManifest adds a child manifest to the index. The descriptor must
+// carry a media type and digest. If platform is non-nil it overrides any
+// platform already set on the descriptor.
+func (ib *IndexBuilder) AppendManifest(desc distribution.Descriptor, platform *v1.Platform) error {
+	if desc.MediaType == "" {
+		return fmt.Errorf("index builder: descriptor %s has no media type", desc.Digest)
+	}
+	if desc.Digest == "" {
+		return errors.New("index builder: descriptor has no digest")
+	}
+	if err := desc.Digest.Validate(); err != nil {
+		return fmt.Errorf("index builder: invalid digest %q: %w", desc.Digest, err)
+	}
+
+	if platform != nil {
+		p := *platform
+		desc.Platform = &p
+	}
+
+	if desc.Platform != nil {
+		key := platformKey(desc.Platform)
+		if _, ok := ib.platforms[key]; ok && !ib.allowDuplicatePlatforms {
+			return fmt.Errorf("index builder: duplicate platform %s", key)
+		}
+		ib.platforms[key] = struct{}{}
+	}
+
+	ib.manifests = append(ib.manifests, desc)
+	return nil
+}
+
+// References returns the child manifests added to this builder.
+func (ib *IndexBuilder) References() []distribution.Descriptor {
+	return ib.manifests
+}
+
+// Build produces the final image index. The payload is canonical: building
+// the same set of manifests and annotations always yields the same bytes.
+func (ib *IndexBuilder) Build(ctx context.Context) (*DeserializedImageIndex, error) {
+	return FromDescriptors(ib.manifests, ib.annotations)
+}
+
+// platformKey returns a stable string identifying a platform.
+func platformKey(p *v1.Platform) string {
+	key := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		key += "/" + p.Variant
+	}
+	if p.OSVersion != "" {
+		key += ":" + p.OSVersion
+	}
+	if len(p.OSFeatures) > 0 {
+		features := make([]string, len(p.OSFeatures))
+		copy(features, p.OSFeatures)
+		sort.Strings(features)
+		key += "+" + strings.Join(features, ",")
+	}
+	return key
+}
@@ -0,0 +1,102 @@
+package ocischema
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestIndexBuilder(t *testing.T) {
+	build := func() *DeserializedImageIndex {
+		ib := NewIndexBuilder(map[string]string{
+			"com.example.locale":           "en_GB",
+			"com.example.favourite-colour": "blue",
+		})
+		err := ib.AppendManifest(distribution.Descriptor{
+			MediaType: v1.MediaTypeImageManifest,
+			Digest:    "sha256:1a9ec845ee94c202b2d5da74a24f0ed2058318bfa9879fa541efaecba272e86b",
+			Size:      985,
+		}, &v1.Platform{Architecture: "amd64", OS: "linux"})
+		if err != nil {
+			t.Fatalf("unexpected error appending manifest: %v", err)
+		}
+		err = ib.AppendManifest(distribution.Descriptor{
+			MediaType:   v1.MediaTypeImageManifest,
+			Digest:      "sha256:1a9ec845ee94c202b2d5da74a24f0ed2058318bfa9879fa541efaecba272e86b",
+			Size:        985,
+			Annotations: map[string]string{"platform": "none"},
+		}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error appending manifest: %v", err)
+		}
+		err = ib.AppendManifest(distribution.Descriptor{
+			MediaType:   v1.MediaTypeImageManifest,
+			Digest:      "sha256:6346340964309634683409684360934680934608934608934608934068934608",
+			Size:        2392,
+			Annotations: map[string]string{"what": "for"},
+		}, &v1.Platform{Architecture: "sun4m", OS: "sunos"})
+		if err != nil {
+			t.Fatalf("unexpected error appending manifest: %v", err)
+		}
+
+		idx, err := ib.Build(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error building index: %v", err)
+		}
+		return idx
+	}
+
+	first := build()
+	_, payload, err := first.Payload()
+	if err != nil {
+		t.Fatalf("unexpected error getting payload: %v", err)
+	}
+	if !bytes.Equal([]byte(expectedOCIImageIndexSerialization), payload) {
+		t.Fatalf("index bytes not equal:\nexpected:\n%s\nactual:\n%s\n", expectedOCIImageIndexSerialization, string(payload))
+	}
+
+	_, again, _ := build().Payload()
+	if !bytes.Equal(payload, again) {
+		t.Fatal("index payload is not stable across builds")
+	}
+}
+
+func TestIndexBuilderValidation(t *testing.T) {
+	linux := &v1.Platform{Architecture: "amd64", OS: "linux"}
+	desc := distribution.Descriptor{
+		MediaType: v1.MediaTypeImageManifest,
+		Digest:    "sha256:1a9ec845ee94c202b2d5da74a24f0ed2058318bfa9879fa541efaecba272e86b",
+		Size:      985,
+	}
+
+	ib := NewIndexBuilder(nil)
+	if err := ib.AppendManifest(distribution.Descriptor{Digest: desc.Digest}, linux); err == nil {
+		t.Error("expected error for descriptor without media type")
+	}
+	if err := ib.AppendManifest(distribution.Descriptor{MediaType: desc.MediaType}, linux); err == nil {
+		t.Error("expected error for descriptor without digest")
+	}
+	if err := ib.AppendManifest(desc, linux); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ib.AppendManifest(desc, &v1.Platform{Architecture: "amd64", OS: "linux"}); err == nil {
+		t.Error("expected error for duplicate platform")
+	}
+	if err := ib.AppendManifest(desc, &v1.Platform{Architecture: "arm64", OS: "linux", Variant: "v8"}); err != nil {
+		t.Errorf("unexpected error for distinct platform: %v", err)
+	}
+
+	ib = NewIndexBuilder(nil, AllowDuplicatePlatforms())
+	if err := ib.AppendManifest(desc, linux); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ib.AppendManifest(desc, linux); err != nil {
+		t.Errorf("unexpected error for duplicate platform when allowed: %v", err)
+	}
+	if len(ib.References()) != 2 {
+		t.Errorf("unexpected number of references: %d", len(ib.References()))
+	}
+}
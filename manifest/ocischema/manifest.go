@@ -47,6 +47,10 @@ func init() {
 type Manifest struct {
 	manifest.Versioned
 
+	// ArtifactType is the type of the artifact the manifest describes, if
+	// any.
+	ArtifactType string `json:"artifactType,omitempty"`
+
 	// Config references the image configuration as a blob.
 	Config distribution.Descriptor `json:"config"`
 
@@ -54,6 +58,10 @@ type Manifest struct {
 	// configuration.
 	Layers []distribution.Descriptor `json:"layers"`
 
+	// Subject is the manifest this manifest refers to, as when it is
+	// attached to an image.
+	Subject *distribution.Descriptor `json:"subject,omitempty"`
+
 	// Annotations contains arbitrary metadata for the image manifest.
 	Annotations map[string]string `json:"annotations,omitempty"`
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
 )
 
 // builder is a type for constructing manifests.
@@ -17,23 +18,68 @@ type builder struct {
 	// dependencies is a list of descriptors that gets built by successive
 	// calls to AppendReference. In case of image configuration these are layers.
 	dependencies []distribution.Descriptor
+
+	// validateConfig enables the validation of the config against the
+	// layers on Build.
+	validateConfig bool
+
+	// duplicates is how AppendReference handles duplicate references.
+	duplicates manifest.Duplicates
+}
+
+// BuilderOption configures the builders returned by NewManifestBuilder.
+type BuilderOption func(*builder)
+
+// WithConfigValidation makes Build fail if the image config does not
+// describe the layers of the manifest, as checked by
+// manifest.ValidateImageConfig.
+func WithConfigValidation() BuilderOption {
+	return func(mb *builder) {
+		mb.validateConfig = true
+	}
+}
+
+// WithDeduplicateLayers makes AppendReference skip the references with the
+// digest and media type of a reference already appended.
+func WithDeduplicateLayers() BuilderOption {
+	return func(mb *builder) {
+		mb.duplicates = manifest.SkipDuplicates
+	}
+}
+
+// WithRejectDuplicates makes AppendReference fail with a
+// manifest.ErrDuplicateReference error for the references with the digest
+// and media type of a reference already appended.
+func WithRejectDuplicates() BuilderOption {
+	return func(mb *builder) {
+		mb.duplicates = manifest.RejectDuplicates
+	}
 }
 
 // NewManifestBuilder is used to build new manifests for the current schema
 // version. It takes a BlobService so it can publish the configuration blob
 // as part of the Build process.
-func NewManifestBuilder(configDescriptor distribution.Descriptor, configJSON []byte) distribution.ManifestBuilder {
+func NewManifestBuilder(configDescriptor distribution.Descriptor, configJSON []byte, opts ...BuilderOption) distribution.ManifestBuilder {
 	mb := &builder{
 		configDescriptor: configDescriptor,
 		configJSON:       make([]byte, len(configJSON)),
 	}
 	copy(mb.configJSON, configJSON)
+	for _, opt := range opts {
+		opt(mb)
+	}
 
 	return mb
 }
 
 // Build produces a final manifest from the given references.
 func (mb *builder) Build(ctx context.Context) (distribution.Manifest, error) {
+	if mb.validateConfig {
+		if err := manifest.ValidateImageConfig(mb.configJSON, mb.dependencies); err != nil {
+			return nil, err
+		}
+	}
+
 	m := Manifest{
 		Versioned: SchemaVersion,
 		Layers:    make([]distribution.Descriptor, len(mb.dependencies)),
@@ -47,7 +93,11 @@ func (mb *builder) Build(ctx context.Context) (distribution.Manifest, error) {
 
 // AppendReference adds a reference to the current ManifestBuilder.
 func (mb *builder) AppendReference(d distribution.Describable) error {
-	mb.dependencies = append(mb.dependencies, d.Descriptor())
+	refs, err := manifest.AppendReference(mb.dependencies, d.Descriptor(), mb.duplicates)
+	if err != nil {
+		return err
+	}
+	mb.dependencies = refs
 	return nil
 }
 
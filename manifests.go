@@ -69,6 +69,26 @@ type ManifestEnumerator interface {
 	Enumerate(ctx context.Context, ingester func(digest.Digest) error) error
 }
 
+// ManifestReferrers lists the manifests referring to another manifest as
+// their subject, without reading them.
+type ManifestReferrers interface {
+	// Referrers returns the referrers of the manifest subject, ordered by
+	// digest, starting after the digest last if it is set, and up to n of
+	// them if n is positive. Only the referrers of artifactType are returned
+	// if it is set. The subject need not exist.
+	Referrers(ctx context.Context, subject digest.Digest, artifactType string, last digest.Digest, n int) ([]Referrer, error)
+}
+
+// Referrer describes a manifest referring to another manifest as its
+// subject. The annotations of the descriptor are those of the manifest.
+type Referrer struct {
+	Descriptor
+
+	// ArtifactType is the artifact type of the manifest, or else the media
+	// type of its config.
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
 // Describable is an interface for descriptors
 type Describable interface {
 	Descriptor() Descriptor
@@ -16,4 +16,13 @@ var (
 
 	// ProxyNamespace is the prometheus namespace of proxy related metrics
 	ProxyNamespace = metrics.NewNamespace(NamespacePrefix, "proxy", nil)
+
+	// RateLimitNamespace is the prometheus namespace of rate limiting related metrics
+	RateLimitNamespace = metrics.NewNamespace(NamespacePrefix, "ratelimit", nil)
+
+	// LimitsNamespace is the prometheus namespace of concurrency limits related metrics
+	LimitsNamespace = metrics.NewNamespace(NamespacePrefix, "limits", nil)
+
+	// CoordinationNamespace is the prometheus namespace of leader election related metrics
+	CoordinationNamespace = metrics.NewNamespace(NamespacePrefix, "coordination", nil)
 )
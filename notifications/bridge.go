@@ -10,11 +10,13 @@ import (
 	events "github.com/docker/go-events"
 	"github.com/google/uuid"
 	"github.com/opencontainers/go-digest"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 type bridge struct {
 	ub                URLBuilder
 	includeReferences bool
+	maxReferences     int
 	actor             ActorRecord
 	source            SourceRecord
 	request           RequestRecord
@@ -31,12 +33,15 @@ type URLBuilder interface {
 
 // NewBridge returns a notification listener that writes records to sink,
 // using the actor and source. Any urls populated in the events created by
-// this bridge will be created using the URLBuilder.
+// this bridge will be created using the URLBuilder. If includeReferences is
+// set, manifest events list the descriptors referenced by the manifest, up to
+// maxReferences of them unless maxReferences is zero.
 // TODO(stevvooe): Update this to simply take a context.Context object.
-func NewBridge(ub URLBuilder, source SourceRecord, actor ActorRecord, request RequestRecord, sink events.Sink, includeReferences bool) Listener {
+func NewBridge(ub URLBuilder, source SourceRecord, actor ActorRecord, request RequestRecord, sink events.Sink, includeReferences bool, maxReferences int) Listener {
 	return &bridge{
 		ub:                ub,
 		includeReferences: includeReferences,
+		maxReferences:     maxReferences,
 		actor:             actor,
 		source:            source,
 		request:           request,
@@ -47,12 +52,16 @@ func NewBridge(ub URLBuilder, source SourceRecord, actor ActorRecord, request Re
 // NewRequestRecord builds a RequestRecord for use in NewBridge from an
 // http.Request, associating it with a request id.
 func NewRequestRecord(id string, r *http.Request) RequestRecord {
+	carrier := propagation.HeaderCarrier{}
+	propagation.TraceContext{}.Inject(r.Context(), carrier)
 	return RequestRecord{
-		ID:        id,
-		Addr:      requestutil.RemoteAddr(r),
-		Host:      r.Host,
-		Method:    r.Method,
-		UserAgent: r.UserAgent(),
+		ID:          id,
+		Addr:        requestutil.RemoteAddr(r),
+		Host:        r.Host,
+		Method:      r.Method,
+		UserAgent:   r.UserAgent(),
+		TraceParent: carrier.Get("traceparent"),
+		TraceState:  carrier.Get("tracestate"),
 	}
 }
 
@@ -111,16 +120,17 @@ func (b *bridge) BlobDeleted(repo reference.Named, dgst digest.Digest) error {
 	return b.createBlobDeleteEventAndWrite(EventActionDelete, repo, dgst)
 }
 
-func (b *bridge) TagDeleted(repo reference.Named, tag string) error {
-	event := b.createEvent(EventActionDelete)
+func (b *bridge) TagDeleted(repo reference.Named, tag string, dgst digest.Digest) error {
+	event := b.createEvent(EventActionTagDelete)
 	event.Target.Repository = repo.Name()
 	event.Target.Tag = tag
+	event.Target.Digest = dgst
 
 	return b.sink.Write(*event)
 }
 
 func (b *bridge) RepoDeleted(repo reference.Named) error {
-	event := b.createEvent(EventActionDelete)
+	event := b.createEvent(EventActionRepositoryDelete)
 	event.Target.Repository = repo.Name()
 
 	return b.sink.Write(*event)
@@ -154,7 +164,7 @@ func (b *bridge) createManifestEvent(action string, repo reference.Named, sm dis
 	event.Target.Size = desc.Size
 	event.Target.Length = desc.Size
 	if b.includeReferences {
-		event.Target.References = append(event.Target.References, manifest.References()...)
+		event.Target.References, event.Target.Truncated = b.references(manifest.References())
 	}
 
 	ref, err := reference.WithDigest(repo, event.Target.Digest)
@@ -170,6 +180,25 @@ func (b *bridge) createManifestEvent(action string, repo reference.Named, sm dis
 	return event, nil
 }
 
+// references returns the digest, media type and size of the descriptors in
+// refs, and whether some were left out to honor maxReferences.
+func (b *bridge) references(refs []distribution.Descriptor) ([]distribution.Descriptor, bool) {
+	truncated := false
+	if b.maxReferences > 0 && len(refs) > b.maxReferences {
+		refs, truncated = refs[:b.maxReferences], true
+	}
+
+	references := make([]distribution.Descriptor, 0, len(refs))
+	for _, ref := range refs {
+		references = append(references, distribution.Descriptor{
+			MediaType: ref.MediaType,
+			Digest:    ref.Digest,
+			Size:      ref.Size,
+		})
+	}
+	return references, truncated
+}
+
 func (b *bridge) createBlobDeleteEventAndWrite(action string, repo reference.Named, dgst digest.Digest) error {
 	event := b.createEvent(action)
 	event.Target.Digest = dgst
@@ -1,6 +1,7 @@
 package notifications
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/distribution/distribution/v3"
@@ -68,6 +69,91 @@ func TestEventBridgeManifestPushed(t *testing.T) {
 	}
 }
 
+func TestEventBridgeManifestReferences(t *testing.T) {
+	layers := []distribution.Descriptor{
+		{MediaType: v1.MediaTypeImageLayerGzip, Digest: "sha256:1111", Size: 10, Annotations: map[string]string{"a": "b"}},
+		{MediaType: v1.MediaTypeImageLayerGzip, Digest: "sha256:2222", Size: 20},
+		{MediaType: v1.MediaTypeImageLayerGzip, Digest: "sha256:3333", Size: 30},
+	}
+	m, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     schema2.MediaTypeManifest,
+		},
+		Config: cfg,
+		Layers: layers,
+	})
+	if err != nil {
+		t.Fatalf("creating manifest: %v", err)
+	}
+	repoRef, _ := reference.WithName(repo)
+
+	for _, tc := range []struct {
+		desc              string
+		includeReferences bool
+		maxReferences     int
+		expected          []distribution.Descriptor
+		truncated         bool
+	}{
+		{
+			desc: "disabled",
+		},
+		{
+			desc:              "all references",
+			includeReferences: true,
+			expected: []distribution.Descriptor{
+				{MediaType: cfg.MediaType, Digest: cfg.Digest, Size: cfg.Size},
+				{MediaType: v1.MediaTypeImageLayerGzip, Digest: "sha256:1111", Size: 10},
+				{MediaType: v1.MediaTypeImageLayerGzip, Digest: "sha256:2222", Size: 20},
+				{MediaType: v1.MediaTypeImageLayerGzip, Digest: "sha256:3333", Size: 30},
+			},
+		},
+		{
+			desc:              "under the limit",
+			includeReferences: true,
+			maxReferences:     4,
+			expected: []distribution.Descriptor{
+				{MediaType: cfg.MediaType, Digest: cfg.Digest, Size: cfg.Size},
+				{MediaType: v1.MediaTypeImageLayerGzip, Digest: "sha256:1111", Size: 10},
+				{MediaType: v1.MediaTypeImageLayerGzip, Digest: "sha256:2222", Size: 20},
+				{MediaType: v1.MediaTypeImageLayerGzip, Digest: "sha256:3333", Size: 30},
+			},
+		},
+		{
+			desc:              "truncated",
+			includeReferences: true,
+			maxReferences:     2,
+			expected: []distribution.Descriptor{
+				{MediaType: cfg.MediaType, Digest: cfg.Digest, Size: cfg.Size},
+				{MediaType: v1.MediaTypeImageLayerGzip, Digest: "sha256:1111", Size: 10},
+			},
+			truncated: true,
+		},
+	} {
+		var got []Event
+		l := NewBridge(ub, source, actor, request, testSinkFn(func(event events.Event) error {
+			got = append(got, event.(Event))
+			return nil
+		}), tc.includeReferences, tc.maxReferences)
+
+		if err := l.ManifestPushed(repoRef, m); err != nil {
+			t.Fatalf("%s: unexpected error notifying manifest push: %v", tc.desc, err)
+		}
+		if err := l.ManifestPulled(repoRef, m); err != nil {
+			t.Fatalf("%s: unexpected error notifying manifest pull: %v", tc.desc, err)
+		}
+
+		for _, event := range got {
+			if !reflect.DeepEqual(event.Target.References, tc.expected) {
+				t.Fatalf("%s: unexpected references on %s event: %#v != %#v", tc.desc, event.Action, event.Target.References, tc.expected)
+			}
+			if event.Target.Truncated != tc.truncated {
+				t.Fatalf("%s: unexpected truncated marker on %s event: %v", tc.desc, event.Action, event.Target.Truncated)
+			}
+		}
+	}
+}
+
 func TestEventBridgeManifestPushedWithTag(t *testing.T) {
 	l := createTestEnv(t, testSinkFn(func(event events.Event) error {
 		checkCommonManifest(t, EventActionPush, event)
@@ -117,22 +203,28 @@ func TestEventBridgeManifestDeleted(t *testing.T) {
 
 func TestEventBridgeTagDeleted(t *testing.T) {
 	l := createTestEnv(t, testSinkFn(func(event events.Event) error {
-		checkDeleted(t, EventActionDelete, event)
+		checkDeleted(t, EventActionTagDelete, event)
 		if event.(Event).Target.Tag != tag {
 			t.Fatalf("unexpected tag on event target: %q != %q", event.(Event).Target.Tag, tag)
 		}
+		if event.(Event).Target.Digest != dgst {
+			t.Fatalf("unexpected digest on event target: %q != %q", event.(Event).Target.Digest, dgst)
+		}
 		return nil
 	}))
 
 	repoRef, _ := reference.WithName(repo)
-	if err := l.TagDeleted(repoRef, tag); err != nil {
+	if err := l.TagDeleted(repoRef, tag, dgst); err != nil {
 		t.Fatalf("unexpected error notifying tag deletion: %v", err)
 	}
 }
 
 func TestEventBridgeRepoDeleted(t *testing.T) {
 	l := createTestEnv(t, testSinkFn(func(event events.Event) error {
-		checkDeleted(t, EventActionDelete, event)
+		checkDeleted(t, EventActionRepositoryDelete, event)
+		if event.(Event).Target.Tag != "" || event.(Event).Target.Digest != "" {
+			t.Fatalf("unexpected target of repository deletion: %#v", event.(Event).Target)
+		}
 		return nil
 	}))
 
@@ -159,10 +251,14 @@ func createTestEnv(t *testing.T, fn testSinkFn) Listener {
 	dgst = digest.FromBytes(payload)
 	sm = deserializedManifest
 
-	return NewBridge(ub, source, actor, request, fn, true)
+	return NewBridge(ub, source, actor, request, fn, true, 0)
 }
 
 func checkDeleted(t *testing.T, action string, event events.Event) {
+	if event.(Event).Action != action {
+		t.Fatalf("unexpected event action: %q != %q", event.(Event).Action, action)
+	}
+
 	if event.(Event).Source != source {
 		t.Fatalf("source not equal: %#v != %#v", event.(Event).Source, source)
 	}
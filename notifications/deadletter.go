@@ -0,0 +1,83 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	events "github.com/docker/go-events"
+)
+
+// NewHTTPDeadLetterSink returns a sink which posts events that could not be
+// delivered to an endpoint to a secondary http endpoint, using the same
+// envelope format.
+func NewHTTPDeadLetterSink(url string, timeout time.Duration, headers http.Header) events.Sink {
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	return newHTTPSink(url, timeout, headers, nil)
+}
+
+// storageSink writes each event to its own file below a directory of a
+// storage driver, so that undelivered events can be inspected and replayed.
+type storageSink struct {
+	driver storagedriver.StorageDriver
+	dir    string
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewStorageDeadLetterSink returns a sink which writes events that could not
+// be delivered to an endpoint below dir, using driver. Each event is stored
+// as an envelope in a file named after its timestamp and id.
+func NewStorageDeadLetterSink(driver storagedriver.StorageDriver, dir string) events.Sink {
+	return &storageSink{
+		driver: driver,
+		dir:    dir,
+	}
+}
+
+// Write stores the event.
+func (ss *storageSink) Write(event events.Event) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.closed {
+		return ErrSinkClosed
+	}
+
+	p, err := json.MarshalIndent(Envelope{Events: []events.Event{event}}, "", "   ")
+	if err != nil {
+		return fmt.Errorf("%v: error marshaling event envelope: %v", ss, err)
+	}
+
+	name := time.Now().UTC().Format("20060102T150405.000000000Z")
+	if e, ok := event.(Event); ok && e.ID != "" {
+		name += "-" + e.ID
+	}
+
+	return ss.driver.PutContent(context.Background(), path.Join(ss.dir, name+".json"), p)
+}
+
+// Close closes the sink. Further writes fail with ErrSinkClosed.
+func (ss *storageSink) Close() error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.closed {
+		return fmt.Errorf("storagesink: already closed")
+	}
+
+	ss.closed = true
+	return nil
+}
+
+func (ss *storageSink) String() string {
+	return fmt.Sprintf("storagesink{%s}", ss.dir)
+}
@@ -11,13 +11,31 @@ import (
 // EndpointConfig covers the optional configuration parameters for an active
 // endpoint.
 type EndpointConfig struct {
-	Headers           http.Header
-	Timeout           time.Duration
+	Headers http.Header
+	Timeout time.Duration
+	// Threshold is no longer used: failed deliveries are retried with
+	// exponential backoff, see Backoff, MaxBackoff and MaxRetries.
 	Threshold         int
 	Backoff           time.Duration
+	MaxBackoff        time.Duration
+	MaxRetries        int
 	IgnoredMediaTypes []string
 	Transport         *http.Transport `json:"-"`
+	Include           configuration.Filter
 	Ignore            configuration.Ignore
+
+	// SigningSecret, if set, signs the body of every request to the
+	// endpoint with HMAC-SHA256 in the SignatureHeader.
+	SigningSecret string `json:"-"`
+
+	// DeadLetter receives the events which could not be delivered within
+	// MaxRetries retries. If nil, such events are dropped.
+	DeadLetter events.Sink `json:"-"`
+
+	// MessageSink makes a single attempt at delivering each event, usually over a
+	// message transport created with NewTransportSink. If nil, events are
+	// posted to the endpoint URL.
+	MessageSink events.Sink `json:"-"`
 }
 
 // defaults set any zero-valued fields to a reasonable default.
@@ -34,6 +52,10 @@ func (ec *EndpointConfig) defaults() {
 		ec.Backoff = time.Second
 	}
 
+	if ec.MaxBackoff <= 0 {
+		ec.MaxBackoff = time.Minute
+	}
+
 	if ec.Transport == nil {
 		ec.Transport = http.DefaultTransport.(*http.Transport)
 	}
@@ -62,13 +84,21 @@ func NewEndpoint(name, url string, config EndpointConfig) *Endpoint {
 	endpoint.metrics = newSafeMetrics(name)
 
 	// Configures the inmemory queue, retry, http pipeline.
-	endpoint.Sink = newHTTPSink(
-		endpoint.url, endpoint.Timeout, endpoint.Headers,
-		endpoint.Transport, endpoint.metrics.httpStatusListener())
-	endpoint.Sink = events.NewRetryingSink(endpoint.Sink, events.NewBreaker(endpoint.Threshold, endpoint.Backoff))
+	endpoint.Sink = config.MessageSink
+	if endpoint.Sink == nil {
+		sink := newHTTPSink(
+			endpoint.url, endpoint.Timeout, endpoint.Headers,
+			endpoint.Transport, endpoint.metrics.httpStatusListener())
+		sink.secret = []byte(endpoint.SigningSecret)
+		endpoint.Sink = sink
+	}
+	endpoint.Sink = newRetryingSink(endpoint.Sink, endpoint.DeadLetter,
+		endpoint.Backoff, endpoint.MaxBackoff, endpoint.MaxRetries, endpoint.metrics.retryListener())
 	endpoint.Sink = newEventQueue(endpoint.Sink, endpoint.metrics.eventQueueListener())
-	mediaTypes := append(config.Ignore.MediaTypes, config.IgnoredMediaTypes...)
-	endpoint.Sink = newIgnoredSink(endpoint.Sink, mediaTypes, config.Ignore.Actions)
+	// The deprecated ignoredmediatypes are folded into the ignore filter.
+	ignore := config.Ignore
+	ignore.MediaTypes = append(append([]string(nil), ignore.MediaTypes...), config.IgnoredMediaTypes...)
+	endpoint.Sink = newFilterSink(endpoint.Sink, config.Include, ignore)
 
 	register(&endpoint)
 	return &endpoint
@@ -14,13 +14,21 @@ const (
 	EventActionPush   = "push"
 	EventActionMount  = "mount"
 	EventActionDelete = "delete"
+
+	// EventActionTagDelete is the action of the event sent when a tag is
+	// deleted. The event target holds the digest the tag pointed to.
+	EventActionTagDelete = "tag.delete"
+
+	// EventActionRepositoryDelete is the action of the event sent when a
+	// repository is deleted.
+	EventActionRepositoryDelete = "repository.delete"
 )
 
 const (
 	// EventsMediaType is the mediatype for the json event envelope. If the
 	// Event, ActorRecord, SourceRecord or Envelope structs change, the version
 	// number should be incremented.
-	EventsMediaType = "application/vnd.docker.distribution.events.v2+json"
+	EventsMediaType = "application/vnd.docker.distribution.events.v4+json"
 	// LayerMediaType is the media type for image rootfs diffs (aka "layers")
 	// used by Docker. We don't expect this to change for quite a while.
 	layerMediaType = "application/vnd.docker.container.image.rootfs.diff+x-gtar"
@@ -73,8 +81,13 @@ type Event struct {
 		// Tag provides the tag
 		Tag string `json:"tag,omitempty"`
 
-		// References provides the references descriptors.
+		// References provides the digest, media type and size of the
+		// descriptors referenced by a manifest, if enabled.
 		References []distribution.Descriptor `json:"references,omitempty"`
+
+		// Truncated is set if References does not hold all the descriptors
+		// referenced by the manifest.
+		Truncated bool `json:"truncated,omitempty"`
 	} `json:"target,omitempty"`
 
 	// Request covers the request that generated the event.
@@ -127,6 +140,12 @@ type RequestRecord struct {
 
 	// UserAgent contains the user agent header of the request.
 	UserAgent string `json:"useragent"`
+
+	// TraceParent and TraceState carry the W3C trace context of the
+	// request, forwarded in the traceparent and tracestate headers of the
+	// notifications of the event.
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
 }
 
 // SourceRecord identifies the registry node that generated the event. Put
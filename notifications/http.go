@@ -2,15 +2,27 @@ package notifications
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/distribution/distribution/v3/configuration"
 	events "github.com/docker/go-events"
 )
 
+// SignatureHeader carries the HMAC-SHA256 signature of the body of requests
+// to endpoints configured with a signing secret, as "sha256=" followed by the
+// hex encoded digest.
+const SignatureHeader = "X-Registry-Signature"
+
 // httpSink implements a single-flight, http notification endpoint. This is
 // very lightweight in that it only makes an attempt at an http request.
 // Reliability should be provided by the caller.
@@ -21,6 +33,8 @@ type httpSink struct {
 	closed    bool
 	client    *http.Client
 	listeners []httpStatusListener
+	// secret, if set, signs request bodies in the SignatureHeader.
+	secret []byte
 
 	// TODO(stevvooe): Allow one to configure the media type accepted by this
 	// sink and choose the serialization based on that.
@@ -80,8 +94,25 @@ func (hs *httpSink) Write(event events.Event) error {
 		return fmt.Errorf("%v: error marshaling event envelope: %v", hs, err)
 	}
 
-	body := bytes.NewReader(p)
-	resp, err := hs.client.Post(hs.url, EventsMediaType, body)
+	req, err := http.NewRequest(http.MethodPost, hs.url, bytes.NewReader(p))
+	if err != nil {
+		for _, listener := range hs.listeners {
+			listener.err(err, event)
+		}
+		return fmt.Errorf("%v: error creating request: %v", hs, err)
+	}
+	req.Header.Set("Content-Type", EventsMediaType)
+	if e, ok := event.(Event); ok && e.Request.TraceParent != "" {
+		req.Header.Set("traceparent", e.Request.TraceParent)
+		if e.Request.TraceState != "" {
+			req.Header.Set("tracestate", e.Request.TraceState)
+		}
+	}
+	if len(hs.secret) > 0 {
+		req.Header.Set(SignatureHeader, sign(hs.secret, p))
+	}
+
+	resp, err := hs.client.Do(req)
 	if err != nil {
 		for _, listener := range hs.listeners {
 			listener.err(err, event)
@@ -129,6 +160,49 @@ func (hs *httpSink) String() string {
 	return fmt.Sprintf("httpSink{%s}", hs.url)
 }
 
+// sign returns the value of the SignatureHeader of body.
+func sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewTLSTransport returns a transport for an endpoint configured with
+// config, which verifies the endpoint against the CAs in config.CAFile and
+// presents the client certificate in config.CertFile, if set.
+func NewTLSTransport(config configuration.EndpointTLS) (*http.Transport, error) {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, fmt.Errorf("certificate and key files must be set together")
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.InsecureSkipVerify, //nolint:gosec // opt-in for testing
+	}
+	if config.CAFile != "" {
+		caPEM, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
 type headerRoundTripper struct {
 	*http.Transport // must be transport to support CancelRequest
 	headers         http.Header
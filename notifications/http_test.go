@@ -1,20 +1,36 @@
 package notifications
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"math/big"
 	"mime"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/manifest/schema2"
 	events "github.com/docker/go-events"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 // TestHTTPSink mocks out an http endpoint and notifies it under a couple of
@@ -207,3 +223,157 @@ func createTestEvent(action, repo, typ string) Event {
 
 	return *event
 }
+
+// writeClientCertificate writes a self-signed client certificate and its key
+// to dir, returning their paths and the certificate.
+func writeClientCertificate(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "registry"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+// TestHTTPSinkClientCertificate ensures the sink authenticates with a client
+// certificate to an endpoint requiring one, and signs its requests.
+func TestHTTPSinkClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeClientCertificate(t, dir)
+	secret := "s3cr3t"
+
+	var signatures []string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if r.Header.Get(SignatureHeader) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		signatures = append(signatures, r.Header.Get(SignatureHeader))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The endpoint is trusted, but refuses the handshake without a client
+	// certificate.
+	tr, err := NewTLSTransport(configuration.EndpointTLS{CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := newHTTPSink(server.URL, 0, nil, tr)
+	sink.secret = []byte(secret)
+	if err := sink.Write(Event{}); err == nil {
+		t.Fatal("expected the handshake to fail without a client certificate")
+	}
+
+	tr, err = NewTLSTransport(configuration.EndpointTLS{CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink = newHTTPSink(server.URL, 0, nil, tr)
+	sink.secret = []byte(secret)
+	if err := sink.Write(Event{}); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+	if len(signatures) != 1 {
+		t.Fatalf("expected one signed request, got %d", len(signatures))
+	}
+
+	// Without the secret the endpoint rejects the unsigned request.
+	sink = newHTTPSink(server.URL, 0, nil, tr)
+	if err := sink.Write(Event{}); err == nil {
+		t.Fatal("expected an unsigned request to be rejected")
+	}
+
+	if _, err := NewTLSTransport(configuration.EndpointTLS{CertFile: certFile}); err == nil {
+		t.Fatal("expected an error for a certificate without key")
+	}
+	if _, err := NewTLSTransport(configuration.EndpointTLS{CAFile: keyFile}); err == nil {
+		t.Fatal("expected an error for a CA file without certificates")
+	}
+}
+
+// TestHTTPSinkTraceContext ensures the sink forwards the trace context of the
+// request of an event in the headers of the notification.
+func TestHTTPSinkTraceContext(t *testing.T) {
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+	req.Header.Set("traceparent", traceParent)
+	req.Header.Set("tracestate", "vendor=value")
+	ctx := propagation.TraceContext{}.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	record := NewRequestRecord("id", req.WithContext(ctx))
+	if record.TraceParent != traceParent {
+		t.Fatalf("unexpected traceparent: %q != %q", record.TraceParent, traceParent)
+	}
+
+	sink := newHTTPSink(server.URL, 0, nil, nil)
+	if err := sink.Write(Event{Request: record}); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+	if got := headers.Get("traceparent"); got != traceParent {
+		t.Fatalf("unexpected traceparent header: %q != %q", got, traceParent)
+	}
+	if got := headers.Get("tracestate"); got != "vendor=value" {
+		t.Fatalf("unexpected tracestate header: %q != %q", got, "vendor=value")
+	}
+
+	// Events without a trace context send no trace headers.
+	if err := sink.Write(Event{}); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+	if got := headers.Get("traceparent"); got != "" {
+		t.Fatalf("unexpected traceparent header: %q", got)
+	}
+}
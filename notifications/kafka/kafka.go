@@ -0,0 +1,204 @@
+// Package kafka provides a notification transport which publishes registry
+// events to a Kafka topic. The transport is registered as "kafka" when the
+// package is imported; the registry binary only imports it when built with
+// the "kafka" build tag.
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/notifications"
+	events "github.com/docker/go-events"
+	"github.com/mitchellh/mapstructure"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+// defaultTimeout bounds the delivery of a single event, including the
+// retries made by the Kafka client itself.
+const defaultTimeout = 10 * time.Second
+
+func init() {
+	if err := notifications.RegisterTransport("kafka", newSink); err != nil {
+		panic(fmt.Sprintf("failed to register kafka transport: %v", err))
+	}
+}
+
+// SASL configures the authentication to the brokers.
+type SASL struct {
+	Mechanism string `mapstructure:"mechanism"`
+	Username  string `mapstructure:"username"`
+	Password  string `mapstructure:"password"`
+}
+
+// Parameters configures the kafka transport.
+type Parameters struct {
+	Brokers []string      `mapstructure:"brokers"`
+	Topic   string        `mapstructure:"topic"`
+	Timeout time.Duration `mapstructure:"timeout"`
+	TLS     bool          `mapstructure:"tls"`
+	SASL    SASL          `mapstructure:"sasl"`
+}
+
+// NewParameters parses the options of the kafka transport.
+func NewParameters(options map[string]interface{}) (*Parameters, error) {
+	params := Parameters{
+		Timeout: defaultTimeout,
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+		WeaklyTypedInput: true,
+		Result:           &params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(options); err != nil {
+		return nil, err
+	}
+	if len(params.Brokers) == 0 {
+		return nil, errors.New("no brokers parameter provided")
+	}
+	if params.Topic == "" {
+		return nil, errors.New("no topic parameter provided")
+	}
+	if params.Timeout <= 0 {
+		return nil, fmt.Errorf("timeout must be positive, %v invalid", params.Timeout)
+	}
+	return &params, nil
+}
+
+// mechanism returns the SASL mechanism configured by s, or nil if SASL is
+// not configured.
+func (s SASL) mechanism() (sasl.Mechanism, error) {
+	switch strings.ToLower(s.Mechanism) {
+	case "":
+		if s.Username != "" {
+			return nil, errors.New("sasl mechanism must be set when a username is provided")
+		}
+		return nil, nil
+	case "plain":
+		return plain.Auth{User: s.Username, Pass: s.Password}.AsMechanism(), nil
+	case "scram-sha-256":
+		return scram.Auth{User: s.Username, Pass: s.Password}.AsSha256Mechanism(), nil
+	case "scram-sha-512":
+		return scram.Auth{User: s.Username, Pass: s.Password}.AsSha512Mechanism(), nil
+	default:
+		return nil, fmt.Errorf("unsupported sasl mechanism: %s", s.Mechanism)
+	}
+}
+
+// sink publishes each event as a message whose value is the envelope of the
+// event, keyed by the name of the target repository.
+type sink struct {
+	topic   string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	closed bool
+	client *kgo.Client
+}
+
+func newSink(options map[string]interface{}) (events.Sink, error) {
+	params, err := NewParameters(options)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(params.Brokers...),
+		kgo.DefaultProduceTopic(params.Topic),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		kgo.RecordDeliveryTimeout(params.Timeout),
+	}
+	if params.TLS {
+		opts = append(opts, kgo.DialTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+	mechanism, err := params.SASL.mechanism()
+	if err != nil {
+		return nil, err
+	}
+	if mechanism != nil {
+		opts = append(opts, kgo.SASL(mechanism))
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sink{
+		topic:   params.Topic,
+		timeout: params.Timeout,
+		client:  client,
+	}, nil
+}
+
+// Write makes an attempt at publishing the event, returning an error if the
+// brokers do not acknowledge it in time.
+func (s *sink) Write(event events.Event) error {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return notifications.ErrSinkClosed
+	}
+
+	key, payload, err := notifications.EncodeMessage(event)
+	if err != nil {
+		return fmt.Errorf("%v: %v", s, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	record := &kgo.Record{
+		Key:   []byte(key),
+		Value: payload,
+	}
+	if err := s.client.ProduceSync(ctx, record).FirstErr(); err != nil {
+		return fmt.Errorf("%v: error producing: %v", s, err)
+	}
+	return nil
+}
+
+// Check verifies that a broker is reachable.
+func (s *sink) Check(ctx context.Context) error {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return notifications.ErrSinkClosed
+	}
+
+	if err := s.client.Ping(ctx); err != nil {
+		return fmt.Errorf("%v: brokers unreachable: %w", s, err)
+	}
+	return nil
+}
+
+// Close the sink and the connections to the brokers.
+func (s *sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("kafkasink: already closed")
+	}
+
+	s.closed = true
+	s.client.Close()
+	return nil
+}
+
+func (s *sink) String() string {
+	return fmt.Sprintf("kafkaSink{%s}", s.topic)
+}
@@ -0,0 +1,64 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewParameters(t *testing.T) {
+	params, err := NewParameters(map[string]interface{}{
+		"brokers": []interface{}{"kafka-1:9092", "kafka-2:9092"},
+		"topic":   "registry-events",
+		"timeout": "30s",
+		"sasl": map[interface{}]interface{}{
+			"mechanism": "SCRAM-SHA-512",
+			"username":  "registry",
+			"password":  "secret",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(params.Brokers) != 2 || params.Topic != "registry-events" || params.Timeout != 30*time.Second {
+		t.Fatalf("unexpected parameters: %#v", params)
+	}
+	if params.SASL.Username != "registry" || params.SASL.Password != "secret" {
+		t.Fatalf("unexpected sasl parameters: %#v", params.SASL)
+	}
+	if mechanism, err := params.SASL.mechanism(); err != nil || mechanism.Name() != "SCRAM-SHA-512" {
+		t.Fatalf("unexpected sasl mechanism: %v, %v", mechanism, err)
+	}
+
+	params, err = NewParameters(map[string]interface{}{
+		"brokers": "kafka:9092",
+		"topic":   "registry-events",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(params.Brokers) != 1 || params.Timeout != defaultTimeout {
+		t.Fatalf("unexpected parameters: %#v", params)
+	}
+	if mechanism, err := params.SASL.mechanism(); err != nil || mechanism != nil {
+		t.Fatalf("unexpected sasl mechanism: %v, %v", mechanism, err)
+	}
+
+	for _, options := range []map[string]interface{}{
+		{"topic": "registry-events"},
+		{"brokers": "kafka:9092"},
+		{"brokers": "kafka:9092", "topic": "registry-events", "timeout": "-1s"},
+	} {
+		if _, err := NewParameters(options); err == nil {
+			t.Fatalf("expected an error parsing %v", options)
+		}
+	}
+
+	for _, s := range []SASL{
+		{Mechanism: "gssapi"},
+		{Username: "registry"},
+	} {
+		if _, err := s.mechanism(); err == nil {
+			t.Fatalf("expected an error for sasl %#v", s)
+		}
+	}
+}
@@ -29,7 +29,7 @@ type BlobListener interface {
 
 // RepoListener provides repository methods that respond to repository lifecycle
 type RepoListener interface {
-	TagDeleted(repo reference.Named, tag string) error
+	TagDeleted(repo reference.Named, tag string, dgst digest.Digest) error
 	RepoDeleted(repo reference.Named) error
 }
 
@@ -114,6 +114,29 @@ func (msl *manifestServiceListener) Get(ctx context.Context, dgst digest.Digest,
 	return sm, err
 }
 
+// Stat describes the manifest dgst if the wrapped manifest service can do so
+// without getting it, and returns distribution.ErrUnsupported otherwise. No
+// pull is dispatched, as the manifest is not fetched.
+func (msl *manifestServiceListener) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	statter, ok := msl.ManifestService.(interface {
+		Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error)
+	})
+	if !ok {
+		return distribution.Descriptor{}, distribution.ErrUnsupported
+	}
+	return statter.Stat(ctx, dgst)
+}
+
+// Referrers implements distribution.ManifestReferrers for the manifest
+// services which support it. No pull is dispatched, as the manifests are not
+// fetched.
+func (msl *manifestServiceListener) Referrers(ctx context.Context, subject digest.Digest, artifactType string, last digest.Digest, n int) ([]distribution.Referrer, error) {
+	if referrers, ok := msl.ManifestService.(distribution.ManifestReferrers); ok {
+		return referrers.Referrers(ctx, subject, artifactType, last, n)
+	}
+	return nil, distribution.ErrUnsupported
+}
+
 func (msl *manifestServiceListener) Put(ctx context.Context, sm distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
 	dgst, err := msl.ManifestService.Put(ctx, sm, options...)
 
@@ -217,6 +240,24 @@ func (bsl *blobServiceListener) Resume(ctx context.Context, id string) (distribu
 	return bsl.decorateWriter(wr), err
 }
 
+// Uploads implements distribution.BlobUploadLister for the blob stores which
+// support it.
+func (bsl *blobServiceListener) Uploads(ctx context.Context) ([]distribution.BlobUpload, error) {
+	if lister, ok := bsl.BlobStore.(distribution.BlobUploadLister); ok {
+		return lister.Uploads(ctx)
+	}
+	return nil, distribution.ErrUnsupported
+}
+
+// CancelUpload implements distribution.BlobUploadLister for the blob stores
+// which support it.
+func (bsl *blobServiceListener) CancelUpload(ctx context.Context, id string) error {
+	if lister, ok := bsl.BlobStore.(distribution.BlobUploadLister); ok {
+		return lister.CancelUpload(ctx, id)
+	}
+	return distribution.ErrUnsupported
+}
+
 func (bsl *blobServiceListener) decorateWriter(wr distribution.BlobWriter) distribution.BlobWriter {
 	return &blobWriterListener{
 		BlobWriter: wr,
@@ -240,6 +281,17 @@ func (bwl *blobWriterListener) Commit(ctx context.Context, desc distribution.Des
 	return committed, err
 }
 
+// RedirectURL forwards to the decorated writer, if it supports redirecting
+// uploads to the storage backend.
+func (bwl *blobWriterListener) RedirectURL(r *http.Request, dgst digest.Digest) (string, error) {
+	if redirector, ok := bwl.BlobWriter.(interface {
+		RedirectURL(r *http.Request, dgst digest.Digest) (string, error)
+	}); ok {
+		return redirector.RedirectURL(r, dgst)
+	}
+	return "", nil
+}
+
 type tagServiceListener struct {
 	distribution.TagService
 	parent *repositoryListener
@@ -252,11 +304,36 @@ func (rl *repositoryListener) Tags(ctx context.Context) distribution.TagService
 	}
 }
 
+// Count implements distribution.TagCounter for the tag services which
+// support it.
+func (tagSL *tagServiceListener) Count(ctx context.Context) (int, error) {
+	if counter, ok := tagSL.TagService.(distribution.TagCounter); ok {
+		return counter.Count(ctx)
+	}
+	tags, err := tagSL.TagService.All(ctx)
+	return len(tags), err
+}
+
+// Describe implements distribution.TagDescriber for the tag services which
+// support it.
+func (tagSL *tagServiceListener) Describe(ctx context.Context, tag string) (distribution.TagDetails, error) {
+	if describer, ok := tagSL.TagService.(distribution.TagDescriber); ok {
+		return describer.Describe(ctx, tag)
+	}
+	return distribution.TagDetails{}, distribution.ErrUnsupported
+}
+
 func (tagSL *tagServiceListener) Untag(ctx context.Context, tag string) error {
+	// Resolve the tag first, so that the event records the manifest it
+	// pointed to.
+	desc, err := tagSL.TagService.Get(ctx, tag)
+	if err != nil {
+		return err
+	}
 	if err := tagSL.TagService.Untag(ctx, tag); err != nil {
 		return err
 	}
-	if err := tagSL.parent.listener.TagDeleted(tagSL.parent.Repository.Named(), tag); err != nil {
+	if err := tagSL.parent.listener.TagDeleted(tagSL.parent.Repository.Named(), tag, desc.Digest); err != nil {
 		dcontext.GetLogger(ctx).Errorf("error dispatching tag deleted to listener: %v", err)
 		return err
 	}
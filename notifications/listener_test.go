@@ -58,10 +58,50 @@ func TestListener(t *testing.T) {
 	if !reflect.DeepEqual(tl.ops, expectedOps) {
 		t.Fatalf("counts do not match:\n%v\n !=\n%v", tl.ops, expectedOps)
 	}
+
+	// The tag pointed to the manifest which was deleted afterwards.
+	expectedTagDeletions := []string{"foo/bar:thetag@" + tl.manifestDeletions[0]}
+	if !reflect.DeepEqual(tl.tagDeletions, expectedTagDeletions) {
+		t.Fatalf("unexpected tag deletions: %v != %v", tl.tagDeletions, expectedTagDeletions)
+	}
+	if !reflect.DeepEqual(tl.repoDeletions, []string{"foo/bar"}) {
+		t.Fatalf("unexpected repository deletions: %v", tl.repoDeletions)
+	}
+}
+
+func TestListenerUntagUnknown(t *testing.T) {
+	ctx := dcontext.Background()
+
+	registry, err := storage.NewRegistry(ctx, inmemory.New(), storage.EnableDelete)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	tl := &testListener{
+		ops: make(map[string]int),
+	}
+
+	repoRef, _ := reference.WithName("foo/bar")
+	repository, err := registry.Repository(ctx, repoRef)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	repository, _ = Listen(repository, nil, tl)
+
+	err = repository.Tags(ctx).Untag(ctx, "unknown")
+	if _, ok := err.(distribution.ErrTagUnknown); !ok {
+		t.Fatalf("expected ErrTagUnknown, got %v", err)
+	}
+	if len(tl.ops) != 0 {
+		t.Fatalf("unexpected events: %v", tl.ops)
+	}
 }
 
 type testListener struct {
 	ops map[string]int
+
+	manifestDeletions []string
+	tagDeletions      []string
+	repoDeletions     []string
 }
 
 func (tl *testListener) ManifestPushed(repo reference.Named, m distribution.Manifest, options ...distribution.ManifestServiceOption) error {
@@ -76,6 +116,7 @@ func (tl *testListener) ManifestPulled(repo reference.Named, m distribution.Mani
 
 func (tl *testListener) ManifestDeleted(repo reference.Named, d digest.Digest) error {
 	tl.ops["manifest:delete"]++
+	tl.manifestDeletions = append(tl.manifestDeletions, d.String())
 	return nil
 }
 
@@ -99,13 +140,15 @@ func (tl *testListener) BlobDeleted(repo reference.Named, d digest.Digest) error
 	return nil
 }
 
-func (tl *testListener) TagDeleted(repo reference.Named, tag string) error {
+func (tl *testListener) TagDeleted(repo reference.Named, tag string, d digest.Digest) error {
 	tl.ops["tag:delete"]++
+	tl.tagDeletions = append(tl.tagDeletions, repo.Name()+":"+tag+"@"+d.String())
 	return nil
 }
 
 func (tl *testListener) RepoDeleted(repo reference.Named) error {
 	tl.ops["repo:delete"]++
+	tl.repoDeletions = append(tl.repoDeletions, repo.Name())
 	return nil
 }
 
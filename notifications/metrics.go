@@ -75,12 +75,15 @@ func init() {
 // number of events. The goal of this to export it via expvar but we may find
 // some other future solution to be better.
 type EndpointMetrics struct {
-	Pending   int            // events pending in queue
-	Events    int            // total events incoming
-	Successes int            // total events written successfully
-	Failures  int            // total events failed
-	Errors    int            // total events errored
-	Statuses  map[string]int // status code histogram, per call event
+	Pending      int            // events pending in queue
+	Events       int            // total events incoming
+	Successes    int            // total events written successfully
+	Failures     int            // total events failed
+	Errors       int            // total events errored
+	Retries      int            // total delivery retries
+	DeadLettered int            // total events handed to the dead letter sink
+	Dropped      int            // total events dropped after exhausting retries
+	Statuses     map[string]int // status code histogram, per call event
 }
 
 // safeMetrics guards the metrics implementation with a lock and provides a
@@ -114,6 +117,13 @@ func (sm *safeMetrics) eventQueueListener() eventQueueListener {
 	}
 }
 
+// retryListener returns a listener that maintains retry related counters.
+func (sm *safeMetrics) retryListener() retryListener {
+	return &endpointMetricsRetryListener{
+		safeMetrics: sm,
+	}
+}
+
 // endpointMetricsHTTPStatusListener increments counters related to http sinks
 // for the relevant events.
 type endpointMetricsHTTPStatusListener struct {
@@ -174,6 +184,38 @@ func (eqc *endpointMetricsEventQueueListener) egress(event events.Event) {
 	pendingGauge.WithValues(eqc.EndpointName).Dec(1)
 }
 
+// endpointMetricsRetryListener counts retried, dead lettered and dropped
+// events.
+type endpointMetricsRetryListener struct {
+	*safeMetrics
+}
+
+var _ retryListener = &endpointMetricsRetryListener{}
+
+func (emrl *endpointMetricsRetryListener) retry(event events.Event) {
+	emrl.Lock()
+	defer emrl.Unlock()
+	emrl.Retries++
+
+	eventsCounter.WithValues("Retries", emrl.EndpointName).Inc(1)
+}
+
+func (emrl *endpointMetricsRetryListener) deadLettered(event events.Event) {
+	emrl.Lock()
+	defer emrl.Unlock()
+	emrl.DeadLettered++
+
+	eventsCounter.WithValues("DeadLettered", emrl.EndpointName).Inc(1)
+}
+
+func (emrl *endpointMetricsRetryListener) dropped(event events.Event) {
+	emrl.Lock()
+	defer emrl.Unlock()
+	emrl.Dropped++
+
+	eventsCounter.WithValues("Dropped", emrl.EndpointName).Inc(1)
+}
+
 // register places the endpoint into expvar so that stats are tracked.
 func register(e *Endpoint) {
 	endpoints.mu.Lock()
@@ -0,0 +1,209 @@
+// Package nats provides a notification transport which publishes registry
+// events to a NATS subject, optionally through JetStream. The transport is
+// registered as "nats" when the package is imported; the registry binary
+// only imports it when built with the "nats" build tag.
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/notifications"
+	events "github.com/docker/go-events"
+	"github.com/mitchellh/mapstructure"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// defaultTimeout bounds the delivery of a single event.
+	defaultTimeout = 5 * time.Second
+
+	// RepositoryHeader is the message header holding the name of the
+	// repository targeted by the event.
+	RepositoryHeader = "Registry-Repository"
+)
+
+func init() {
+	if err := notifications.RegisterTransport("nats", newSink); err != nil {
+		panic(fmt.Sprintf("failed to register nats transport: %v", err))
+	}
+}
+
+// Parameters configures the nats transport.
+type Parameters struct {
+	// URL is a comma separated list of server URLs.
+	URL         string        `mapstructure:"url"`
+	Subject     string        `mapstructure:"subject"`
+	JetStream   bool          `mapstructure:"jetstream"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+	Username    string        `mapstructure:"username"`
+	Password    string        `mapstructure:"password"`
+	Token       string        `mapstructure:"token"`
+	Credentials string        `mapstructure:"credentials"`
+}
+
+// NewParameters parses the options of the nats transport.
+func NewParameters(options map[string]interface{}) (*Parameters, error) {
+	params := Parameters{
+		Timeout: defaultTimeout,
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+		WeaklyTypedInput: true,
+		Result:           &params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(options); err != nil {
+		return nil, err
+	}
+	if params.URL == "" {
+		return nil, errors.New("no url parameter provided")
+	}
+	if params.Subject == "" {
+		return nil, errors.New("no subject parameter provided")
+	}
+	if params.Timeout <= 0 {
+		return nil, fmt.Errorf("timeout must be positive, %v invalid", params.Timeout)
+	}
+	return &params, nil
+}
+
+// sink publishes each event as a message holding the envelope of the event.
+// The name of the target repository is set in the RepositoryHeader header,
+// and the event ID in the Nats-Msg-Id header, so that JetStream discards
+// the duplicates caused by retries.
+type sink struct {
+	subject string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	closed bool
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+}
+
+func newSink(options map[string]interface{}) (events.Sink, error) {
+	params, err := NewParameters(options)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []nats.Option{
+		nats.Name("registry notifications"),
+		nats.Timeout(params.Timeout),
+		// Connect in the background, so that the registry starts while the
+		// servers are unavailable, and keep reconnecting forever.
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		// Fail writes while disconnected rather than buffering them, so
+		// that undelivered events are retried by the endpoint.
+		nats.ReconnectBufSize(-1),
+	}
+	switch {
+	case params.Credentials != "":
+		opts = append(opts, nats.UserCredentials(params.Credentials))
+	case params.Token != "":
+		opts = append(opts, nats.Token(params.Token))
+	case params.Username != "":
+		opts = append(opts, nats.UserInfo(params.Username, params.Password))
+	}
+
+	conn, err := nats.Connect(params.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sink{
+		subject: params.Subject,
+		timeout: params.Timeout,
+		conn:    conn,
+	}
+	if params.JetStream {
+		s.js, err = conn.JetStream(nats.MaxWait(params.Timeout))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Write makes an attempt at publishing the event. Without JetStream, the
+// event is delivered once the server has received it; with JetStream, once
+// the stream has acknowledged it.
+func (s *sink) Write(event events.Event) error {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return notifications.ErrSinkClosed
+	}
+
+	key, payload, err := notifications.EncodeMessage(event)
+	if err != nil {
+		return fmt.Errorf("%v: %v", s, err)
+	}
+
+	msg := nats.NewMsg(s.subject)
+	msg.Data = payload
+	if key != "" {
+		msg.Header.Set(RepositoryHeader, key)
+	}
+
+	if s.js != nil {
+		var opts []nats.PubOpt
+		if e, ok := event.(notifications.Event); ok && e.ID != "" {
+			opts = append(opts, nats.MsgId(e.ID))
+		}
+		if _, err := s.js.PublishMsg(msg, opts...); err != nil {
+			return fmt.Errorf("%v: error publishing: %v", s, err)
+		}
+		return nil
+	}
+
+	if err := s.conn.PublishMsg(msg); err != nil {
+		return fmt.Errorf("%v: error publishing: %v", s, err)
+	}
+	if err := s.conn.FlushTimeout(s.timeout); err != nil {
+		return fmt.Errorf("%v: error flushing: %v", s, err)
+	}
+	return nil
+}
+
+// Check verifies that the servers answer a round trip on the connection.
+func (s *sink) Check(ctx context.Context) error {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return notifications.ErrSinkClosed
+	}
+
+	if err := s.conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("%v: servers unreachable: %w", s, err)
+	}
+	return nil
+}
+
+// Close the sink and the connection to the servers.
+func (s *sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("natssink: already closed")
+	}
+
+	s.closed = true
+	s.conn.Close()
+	return nil
+}
+
+func (s *sink) String() string {
+	return fmt.Sprintf("natsSink{%s}", s.subject)
+}
@@ -0,0 +1,42 @@
+package nats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewParameters(t *testing.T) {
+	params, err := NewParameters(map[string]interface{}{
+		"url":       "nats://nats-1:4222,nats://nats-2:4222",
+		"subject":   "registry.events",
+		"jetstream": true,
+		"timeout":   "2s",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if params.Subject != "registry.events" || !params.JetStream || params.Timeout != 2*time.Second {
+		t.Fatalf("unexpected parameters: %#v", params)
+	}
+
+	params, err = NewParameters(map[string]interface{}{
+		"url":     "nats://nats:4222",
+		"subject": "registry.events",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if params.JetStream || params.Timeout != defaultTimeout {
+		t.Fatalf("unexpected parameters: %#v", params)
+	}
+
+	for _, options := range []map[string]interface{}{
+		{"subject": "registry.events"},
+		{"url": "nats://nats:4222"},
+		{"url": "nats://nats:4222", "subject": "registry.events", "timeout": 0},
+	} {
+		if _, err := NewParameters(options); err == nil {
+			t.Fatalf("expected an error parsing %v", options)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package notifications
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	events "github.com/docker/go-events"
+	"github.com/sirupsen/logrus"
+)
+
+// retryingSink retries writes to a sink with exponential backoff and jitter.
+// Once an event has exhausted the retry budget it is handed to the dead
+// letter sink, if any, and dropped otherwise.
+type retryingSink struct {
+	sink       events.Sink
+	deadLetter events.Sink
+
+	backoff    time.Duration
+	maxBackoff time.Duration
+	maxRetries int // zero retries forever
+
+	listeners []retryListener
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// retryListener is called when events are retried or given up on.
+type retryListener interface {
+	retry(event events.Event)
+	deadLettered(event events.Event)
+	dropped(event events.Event)
+}
+
+// newRetryingSink returns a sink which retries failed writes to sink. The
+// delay before a retry starts at backoff and doubles with every attempt, up
+// to maxBackoff. After maxRetries retries the event is written to
+// deadLetter, which may be nil. A zero maxRetries retries forever.
+func newRetryingSink(sink, deadLetter events.Sink, backoff, maxBackoff time.Duration, maxRetries int, listeners ...retryListener) *retryingSink {
+	if maxBackoff < backoff {
+		maxBackoff = backoff
+	}
+	return &retryingSink{
+		sink:       sink,
+		deadLetter: deadLetter,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		maxRetries: maxRetries,
+		listeners:  listeners,
+		closed:     make(chan struct{}),
+	}
+}
+
+// Write writes the event to the sink, retrying until it succeeds, the retry
+// budget is exhausted or the sink is closed.
+func (rs *retryingSink) Write(event events.Event) error {
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-rs.closed:
+			return ErrSinkClosed
+		default:
+		}
+
+		err := rs.sink.Write(event)
+		if err == nil {
+			return nil
+		}
+
+		if rs.maxRetries > 0 && attempt >= rs.maxRetries {
+			return rs.giveUp(event, err)
+		}
+
+		for _, listener := range rs.listeners {
+			listener.retry(event)
+		}
+
+		timer := time.NewTimer(rs.delay(attempt))
+		select {
+		case <-rs.closed:
+			timer.Stop()
+			return ErrSinkClosed
+		case <-timer.C:
+		}
+	}
+}
+
+// giveUp hands an event which could not be delivered to the dead letter
+// sink.
+func (rs *retryingSink) giveUp(event events.Event, cause error) error {
+	if rs.deadLetter == nil {
+		for _, listener := range rs.listeners {
+			listener.dropped(event)
+		}
+		return fmt.Errorf("%v: retries exhausted, dropping event: %v", rs.sink, cause)
+	}
+
+	if err := rs.deadLetter.Write(event); err != nil {
+		for _, listener := range rs.listeners {
+			listener.dropped(event)
+		}
+		return fmt.Errorf("%v: retries exhausted and dead letter sink failed, dropping event: %v", rs.sink, err)
+	}
+
+	for _, listener := range rs.listeners {
+		listener.deadLettered(event)
+	}
+	logrus.Warnf("%v: retries exhausted, event written to dead letter sink: %v", rs.sink, cause)
+	return nil
+}
+
+// delay returns the jittered backoff before the given retry attempt. Half of
+// the delay is fixed and the other half random, so that endpoints recovering
+// from an outage are not hit by every registry at once.
+func (rs *retryingSink) delay(attempt int) time.Duration {
+	d := rs.backoff
+	for i := 0; i < attempt && d < rs.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > rs.maxBackoff {
+		d = rs.maxBackoff
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// Close stops any retries in progress and closes the sink and the dead
+// letter sink.
+func (rs *retryingSink) Close() error {
+	err := fmt.Errorf("retryingsink: already closed")
+	rs.closeOnce.Do(func() {
+		close(rs.closed)
+		err = rs.sink.Close()
+		if rs.deadLetter != nil {
+			if derr := rs.deadLetter.Close(); err == nil {
+				err = derr
+			}
+		}
+	})
+	return err
+}
+
+func (rs *retryingSink) String() string {
+	return fmt.Sprintf("retryingsink{%v}", rs.sink)
+}
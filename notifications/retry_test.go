@@ -0,0 +1,157 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	events "github.com/docker/go-events"
+)
+
+// flakySink fails the first failures writes.
+type flakySink struct {
+	testSink
+	failures int
+	attempts int
+}
+
+func (fs *flakySink) Write(event events.Event) error {
+	fs.mu.Lock()
+	fs.attempts++
+	fail := fs.attempts <= fs.failures
+	fs.mu.Unlock()
+
+	if fail {
+		return errors.New("unavailable")
+	}
+	return fs.testSink.Write(event)
+}
+
+func TestRetryingSink(t *testing.T) {
+	event := createTestEvent("push", "library/test", "manifest")
+
+	for _, tc := range []struct {
+		desc         string
+		failures     int
+		maxRetries   int
+		deadLetter   bool
+		delivered    bool
+		deadLettered bool
+		err          bool
+	}{
+		{desc: "success", delivered: true},
+		{desc: "retried", failures: 3, maxRetries: 3, delivered: true},
+		{desc: "unlimited", failures: 5, delivered: true},
+		{desc: "dead lettered", failures: 5, maxRetries: 3, deadLetter: true, deadLettered: true},
+		{desc: "dropped", failures: 5, maxRetries: 3, err: true},
+	} {
+		sink := &flakySink{failures: tc.failures}
+		dl := &testSink{}
+		var deadLetter events.Sink
+		if tc.deadLetter {
+			deadLetter = dl
+		}
+		metrics := newSafeMetrics(tc.desc)
+		rs := newRetryingSink(sink, deadLetter, time.Microsecond, time.Millisecond, tc.maxRetries, metrics.retryListener())
+
+		err := rs.Write(event)
+		if (err != nil) != tc.err {
+			t.Fatalf("%s: unexpected error: %v", tc.desc, err)
+		}
+		if delivered := sink.count == 1; delivered != tc.delivered {
+			t.Fatalf("%s: delivered = %v, want %v", tc.desc, delivered, tc.delivered)
+		}
+		if deadLettered := dl.count == 1; deadLettered != tc.deadLettered {
+			t.Fatalf("%s: dead lettered = %v, want %v", tc.desc, deadLettered, tc.deadLettered)
+		}
+
+		expectedRetries := tc.failures
+		if tc.maxRetries > 0 && expectedRetries > tc.maxRetries {
+			expectedRetries = tc.maxRetries
+		}
+		if metrics.Retries != expectedRetries {
+			t.Fatalf("%s: unexpected retries: %d != %d", tc.desc, metrics.Retries, expectedRetries)
+		}
+		if tc.deadLettered && metrics.DeadLettered != 1 {
+			t.Fatalf("%s: expected the dead lettered event to be counted", tc.desc)
+		}
+		if tc.err && metrics.Dropped != 1 {
+			t.Fatalf("%s: expected the dropped event to be counted", tc.desc)
+		}
+
+		checkClose(t, rs)
+		if tc.deadLetter && !dl.closed {
+			t.Fatalf("%s: expected the dead letter sink to be closed", tc.desc)
+		}
+	}
+}
+
+func TestRetryingSinkCloseInterruptsBackoff(t *testing.T) {
+	rs := newRetryingSink(&flakySink{failures: 1 << 30}, nil, time.Hour, time.Hour, 0)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := rs.Write(createTestEvent("push", "library/test", "manifest")); err != ErrSinkClosed {
+			t.Errorf("expected ErrSinkClosed, got %v", err)
+		}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if err := rs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
+
+func TestRetryingSinkDelay(t *testing.T) {
+	rs := newRetryingSink(&testSink{}, nil, time.Second, 10*time.Second, 0)
+
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		for i := 0; i < 20; i++ {
+			d := rs.delay(attempt)
+			if d < max/2 || d > max {
+				t.Fatalf("attempt %d: delay %v out of range [%v, %v]", attempt, d, max/2, max)
+			}
+		}
+	}
+}
+
+func TestStorageDeadLetterSink(t *testing.T) {
+	driver := inmemory.New()
+	sink := NewStorageDeadLetterSink(driver, "/deadletter/endpoint")
+
+	event := createTestEvent("push", "library/test", "manifest")
+	if err := sink.Write(event); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := driver.List(context.Background(), "/deadletter/endpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected one dead lettered event, got %v", files)
+	}
+
+	p, err := driver.GetContent(context.Background(), files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope struct {
+		Events []Event `json:"events"`
+	}
+	if err := json.Unmarshal(p, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if len(envelope.Events) != 1 || envelope.Events[0].ID != event.ID {
+		t.Fatalf("unexpected dead lettered envelope: %s", p)
+	}
+
+	checkClose(t, sink)
+}
@@ -2,13 +2,95 @@ package notifications
 
 import (
 	"container/list"
+	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 	"sync"
 
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/workers"
 	events "github.com/docker/go-events"
 	"github.com/sirupsen/logrus"
 )
 
+// broadcaster writes each event to all of its sinks, concurrently on the
+// workers of a pool. The events are written one after the other, so that
+// each sink receives them in order.
+type broadcaster struct {
+	pool *workers.Pool
+
+	mu     sync.Mutex
+	sinks  []events.Sink
+	closed bool
+}
+
+// NewBroadcaster returns a sink writing the events to sinks on the workers of
+// pool, or synchronously when no worker is available. As with the
+// broadcaster of go-events, the sinks should be reliable, accepting the
+// events and queueing them, and the sinks which are closed are removed.
+func NewBroadcaster(pool *workers.Pool, sinks ...events.Sink) events.Sink {
+	return &broadcaster{
+		pool:  pool,
+		sinks: sinks,
+	}
+}
+
+// Write writes event to the sinks, returning once all of them accepted it.
+func (b *broadcaster) Write(event events.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return events.ErrSinkClosed
+	}
+
+	closed := make([]bool, len(b.sinks))
+	g, _ := b.pool.WithContext(context.Background())
+	for i, sink := range b.sinks {
+		i, sink := i, sink
+		g.Go(func() error {
+			err := sink.Write(event)
+			switch {
+			case errors.Is(err, events.ErrSinkClosed):
+				closed[i] = true
+			case err != nil:
+				logrus.WithField("event", event).WithField("events.sink", sink).WithError(err).
+					Errorf("broadcaster: dropping event")
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	sinks := b.sinks[:0]
+	for i, sink := range b.sinks {
+		if !closed[i] {
+			sinks = append(sinks, sink)
+		}
+	}
+	b.sinks = sinks
+	return nil
+}
+
+// Close closes the sinks, once the events being written are.
+func (b *broadcaster) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	for _, sink := range b.sinks {
+		if err := sink.Close(); err != nil && !errors.Is(err, events.ErrSinkClosed) {
+			logrus.WithField("events.sink", sink).WithError(err).
+				Errorf("broadcaster: closing sink failed")
+		}
+	}
+	return nil
+}
+
 // eventQueue accepts all messages into a queue for asynchronous consumption
 // by a sink. It is unbounded and thread safe but the sink must be reliable or
 // events will be dropped.
@@ -119,46 +201,129 @@ func (eq *eventQueue) next() events.Event {
 	return block
 }
 
-// ignoredSink discards events with ignored target media types and actions.
-// passes the rest along.
-type ignoredSink struct {
+// filterSink discards the events which do not match the include filter or
+// which match the ignore filter, and passes the rest along.
+type filterSink struct {
 	events.Sink
-	ignoreMediaTypes map[string]bool
-	ignoreActions    map[string]bool
+	include *eventFilter
+	ignore  *eventFilter
 }
 
-func newIgnoredSink(sink events.Sink, ignored []string, ignoreActions []string) events.Sink {
-	if len(ignored) == 0 {
+// newFilterSink returns a sink which only passes along events matching
+// include and not matching ignore. Either filter may be empty.
+func newFilterSink(sink events.Sink, include, ignore configuration.Filter) events.Sink {
+	fs := &filterSink{
+		Sink:    sink,
+		include: newEventFilter(include),
+		ignore:  newEventFilter(ignore),
+	}
+	if fs.include == nil && fs.ignore == nil {
 		return sink
 	}
+	return fs
+}
 
-	ignoredMap := make(map[string]bool)
-	for _, mediaType := range ignored {
-		ignoredMap[mediaType] = true
-	}
+// newIgnoredSink returns a sink which discards events with ignored target
+// media types and actions.
+func newIgnoredSink(sink events.Sink, ignored []string, ignoreActions []string) events.Sink {
+	return newFilterSink(sink, configuration.Filter{}, configuration.Filter{
+		MediaTypes: ignored,
+		Actions:    ignoreActions,
+	})
+}
 
-	ignoredActionsMap := make(map[string]bool)
-	for _, action := range ignoreActions {
-		ignoredActionsMap[action] = true
+// Write passes the event along if it is included and not ignored.
+func (fs *filterSink) Write(event events.Event) error {
+	e := event.(Event)
+	if fs.include != nil && !fs.include.matchesAll(e) {
+		return nil
 	}
-
-	return &ignoredSink{
-		Sink:             sink,
-		ignoreMediaTypes: ignoredMap,
-		ignoreActions:    ignoredActionsMap,
+	if fs.ignore != nil && fs.ignore.matchesAny(e) {
+		return nil
 	}
+
+	return fs.Sink.Write(event)
 }
 
-// Write discards events with ignored target media types and passes the rest
-// along.
-func (imts *ignoredSink) Write(event events.Event) error {
-	if imts.ignoreMediaTypes[event.(Event).Target.MediaType] || imts.ignoreActions[event.(Event).Action] {
+func (fs *filterSink) Close() error {
+	return nil
+}
+
+// eventFilter matches events by target repository, action and target media
+// type.
+type eventFilter struct {
+	repositories []*regexp.Regexp
+	actions      map[string]bool
+	mediaTypes   map[string]bool
+}
+
+// newEventFilter compiles f, returning nil if it has no criteria.
+func newEventFilter(f configuration.Filter) *eventFilter {
+	if len(f.Repositories) == 0 && len(f.Actions) == 0 && len(f.MediaTypes) == 0 {
 		return nil
 	}
 
-	return imts.Sink.Write(event)
+	ef := &eventFilter{
+		actions:    make(map[string]bool),
+		mediaTypes: make(map[string]bool),
+	}
+	for _, pattern := range f.Repositories {
+		ef.repositories = append(ef.repositories, globRegexp(pattern))
+	}
+	for _, action := range f.Actions {
+		ef.actions[action] = true
+	}
+	for _, mediaType := range f.MediaTypes {
+		ef.mediaTypes[mediaType] = true
+	}
+	return ef
 }
 
-func (imts *ignoredSink) Close() error {
-	return nil
+// matchesAll reports whether the event satisfies every criterion of the
+// filter. Empty criteria match any event.
+func (ef *eventFilter) matchesAll(e Event) bool {
+	return (len(ef.repositories) == 0 || ef.matchesRepository(e.Target.Repository)) &&
+		(len(ef.actions) == 0 || ef.actions[e.Action]) &&
+		(len(ef.mediaTypes) == 0 || ef.mediaTypes[e.Target.MediaType])
+}
+
+// matchesAny reports whether the event satisfies any criterion of the
+// filter.
+func (ef *eventFilter) matchesAny(e Event) bool {
+	return ef.matchesRepository(e.Target.Repository) || ef.actions[e.Action] || ef.mediaTypes[e.Target.MediaType]
+}
+
+func (ef *eventFilter) matchesRepository(repository string) bool {
+	for _, re := range ef.repositories {
+		if re.MatchString(repository) {
+			return true
+		}
+	}
+	return false
+}
+
+// globRegexp compiles a repository glob pattern. "*" matches any sequence
+// of characters within a path component, "**" matches across components and
+// "?" matches a single character other than "/". Everything else matches
+// literally.
+func globRegexp(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				sb.WriteString(".*")
+				i++
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
 }
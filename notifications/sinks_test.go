@@ -1,11 +1,14 @@
 package notifications
 
 import (
+	"fmt"
 	"reflect"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/workers"
 	events "github.com/docker/go-events"
 
 	"github.com/sirupsen/logrus"
@@ -172,3 +175,174 @@ func checkClose(t *testing.T, sink events.Sink) {
 		t.Fatalf("error should be ErrSinkClosed")
 	}
 }
+
+func TestGlobRegexp(t *testing.T) {
+	for _, tc := range []struct {
+		pattern    string
+		repository string
+		match      bool
+	}{
+		{"prod/*", "prod/app", true},
+		{"prod/*", "prod/team/app", false},
+		{"prod/*", "prod", false},
+		{"prod/*", "production/app", false},
+		{"prod/**", "prod/team/app", true},
+		{"prod/**", "prod/app", true},
+		{"**/app", "prod/team/app", true},
+		{"**/app", "prod/team/application", false},
+		{"prod/app?", "prod/app1", true},
+		{"prod/app?", "prod/app", false},
+		{"prod/app?", "prod/app/", false},
+		{"library/ubuntu", "library/ubuntu", true},
+		{"library/ubuntu", "library/ubuntu2", false},
+		{"prod/a.b", "prod/axb", false},
+		{"prod/a.b", "prod/a.b", true},
+	} {
+		if got := globRegexp(tc.pattern).MatchString(tc.repository); got != tc.match {
+			t.Errorf("pattern %q against %q: got %v, want %v", tc.pattern, tc.repository, got, tc.match)
+		}
+	}
+}
+
+func TestFilterSink(t *testing.T) {
+	prodPush := createTestEvent("push", "prod/app", "manifest")
+	prodPull := createTestEvent("pull", "prod/app", "manifest")
+	prodBlob := createTestEvent("push", "prod/app", "blob")
+	devPush := createTestEvent("push", "dev/app", "manifest")
+
+	for _, tc := range []struct {
+		desc     string
+		include  configuration.Filter
+		ignore   configuration.Filter
+		expected []events.Event
+	}{
+		{
+			desc:     "no filters",
+			expected: []events.Event{prodPush, prodPull, prodBlob, devPush},
+		},
+		{
+			desc:     "include repositories",
+			include:  configuration.Filter{Repositories: []string{"prod/*"}},
+			expected: []events.Event{prodPush, prodPull, prodBlob},
+		},
+		{
+			desc: "include criteria must all match",
+			include: configuration.Filter{
+				Repositories: []string{"prod/*"},
+				Actions:      []string{"push"},
+				MediaTypes:   []string{"manifest"},
+			},
+			expected: []events.Event{prodPush},
+		},
+		{
+			desc:     "include values are alternatives",
+			include:  configuration.Filter{Repositories: []string{"prod/*", "dev/*"}, Actions: []string{"push"}},
+			expected: []events.Event{prodPush, prodBlob, devPush},
+		},
+		{
+			desc:     "ignore criteria are alternatives",
+			ignore:   configuration.Filter{Repositories: []string{"dev/**"}, Actions: []string{"pull"}},
+			expected: []events.Event{prodPush, prodBlob},
+		},
+		{
+			desc:     "ignore wins over include",
+			include:  configuration.Filter{Repositories: []string{"prod/*"}},
+			ignore:   configuration.Filter{MediaTypes: []string{"blob"}},
+			expected: []events.Event{prodPush, prodPull},
+		},
+	} {
+		ts := &recordingSink{}
+		s := newFilterSink(ts, tc.include, tc.ignore)
+		for _, event := range []events.Event{prodPush, prodPull, prodBlob, devPush} {
+			if err := s.Write(event); err != nil {
+				t.Fatalf("%s: error writing event: %v", tc.desc, err)
+			}
+		}
+		if !reflect.DeepEqual(ts.events, tc.expected) {
+			t.Fatalf("%s: unexpected events: %v != %v", tc.desc, ts.events, tc.expected)
+		}
+	}
+}
+
+// recordingSink records the events written to it.
+type recordingSink struct {
+	events []events.Event
+}
+
+func (rs *recordingSink) Write(event events.Event) error {
+	rs.events = append(rs.events, event)
+	return nil
+}
+
+func (rs *recordingSink) Close() error {
+	return nil
+}
+
+// orderedSink records the events it is written.
+type orderedSink struct {
+	mu     sync.Mutex
+	events []events.Event
+	closed bool
+}
+
+func (s *orderedSink) Write(event events.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return events.ErrSinkClosed
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *orderedSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestBroadcaster(t *testing.T) {
+	for _, limit := range []int{1, 4} {
+		pool := workers.New("test", workers.NewSemaphore(limit))
+		sinks := []*orderedSink{{}, {}, {}}
+		b := NewBroadcaster(pool,
+			&delayedSink{Sink: sinks[0], delay: time.Millisecond},
+			sinks[1],
+			&delayedSink{Sink: sinks[2], delay: 2 * time.Millisecond})
+
+		var written []events.Event
+		for i := 0; i < 10; i++ {
+			if i == 5 {
+				// The closed sinks are removed.
+				if err := sinks[1].Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			event := createTestEvent("push", "library/test", fmt.Sprint(i))
+			if err := b.Write(event); err != nil {
+				t.Fatalf("limit %d: unexpected error writing event: %v", limit, err)
+			}
+			written = append(written, event)
+		}
+
+		// Each sink is written the events in order.
+		for i, expected := range [][]events.Event{written, written[:5], written} {
+			if !reflect.DeepEqual(sinks[i].events, expected) {
+				t.Fatalf("limit %d: unexpected events of sink %d: %v != %v", limit, i, sinks[i].events, expected)
+			}
+		}
+
+		if err := b.Close(); err != nil {
+			t.Fatal(err)
+		}
+		for i, sink := range sinks {
+			if !sink.closed {
+				t.Fatalf("limit %d: sink %d was not closed", limit, i)
+			}
+		}
+		if err := b.Write(createTestEvent("push", "library/test", "closed")); err != events.ErrSinkClosed {
+			t.Fatalf("limit %d: expected ErrSinkClosed, got %v", limit, err)
+		}
+	}
+}
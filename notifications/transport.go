@@ -0,0 +1,67 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	events "github.com/docker/go-events"
+)
+
+// TransportInitFunc is the type of a transport factory function. It returns
+// a single-flight sink which makes one attempt at delivering each event and
+// returns an error if it fails; queueing, retries and dead lettering are
+// provided by the endpoint.
+type TransportInitFunc func(options map[string]interface{}) (events.Sink, error)
+
+var (
+	transportsMu sync.Mutex
+	transports   = make(map[string]TransportInitFunc)
+)
+
+// RegisterTransport is used to register a TransportInitFunc for a message
+// transport with the given name. The HTTP transport is always available and
+// is not registered.
+func RegisterTransport(name string, initFunc TransportInitFunc) error {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	if _, exists := transports[name]; exists || name == "http" {
+		return fmt.Errorf("name already registered: %s", name)
+	}
+
+	transports[name] = initFunc
+
+	return nil
+}
+
+// NewTransportSink constructs a sink with the given options using the named
+// transport. Transports other than HTTP are only available if the registry
+// was built with their build tag.
+func NewTransportSink(name string, options map[string]interface{}) (events.Sink, error) {
+	transportsMu.Lock()
+	initFunc, exists := transports[name]
+	transportsMu.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("no notification transport registered with name: %s (is the registry built with the %q build tag?)", name, name)
+	}
+
+	return initFunc(options)
+}
+
+// EncodeMessage returns the payload of the message carrying event, which is
+// an envelope holding the single event, and its key, which is the name of the
+// target repository. Keying messages by repository keeps the events of a
+// repository in order on partitioned transports.
+func EncodeMessage(event events.Event) (key string, payload []byte, err error) {
+	if e, ok := event.(Event); ok {
+		key = e.Target.Repository
+	}
+
+	payload, err = json.Marshal(Envelope{Events: []events.Event{event}})
+	if err != nil {
+		return "", nil, fmt.Errorf("error marshaling event envelope: %v", err)
+	}
+	return key, payload, nil
+}
@@ -0,0 +1,86 @@
+package notifications
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	events "github.com/docker/go-events"
+)
+
+func TestRegisterTransport(t *testing.T) {
+	sink := &testSink{}
+	if err := RegisterTransport("test-transport", func(options map[string]interface{}) (events.Sink, error) {
+		if options["topic"] != "events" {
+			t.Fatalf("unexpected options: %v", options)
+		}
+		return sink, nil
+	}); err != nil {
+		t.Fatalf("unexpected error registering transport: %v", err)
+	}
+
+	if err := RegisterTransport("test-transport", nil); err == nil {
+		t.Fatal("expected an error registering a transport twice")
+	}
+	if err := RegisterTransport("http", nil); err == nil {
+		t.Fatal("expected an error registering the http transport")
+	}
+
+	s, err := NewTransportSink("test-transport", map[string]interface{}{"topic": "events"})
+	if err != nil {
+		t.Fatalf("unexpected error creating sink: %v", err)
+	}
+	if s != sink {
+		t.Fatalf("unexpected sink: %v", s)
+	}
+
+	if _, err := NewTransportSink("unknown", nil); err == nil {
+		t.Fatal("expected an error creating a sink with an unknown transport")
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	event := createTestEvent("push", "library/test", "manifest")
+
+	key, payload, err := EncodeMessage(event)
+	if err != nil {
+		t.Fatalf("unexpected error encoding message: %v", err)
+	}
+	if key != "library/test" {
+		t.Fatalf("unexpected key: %q", key)
+	}
+
+	var envelope struct {
+		Events []Event `json:"events"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		t.Fatalf("unexpected error decoding payload: %v", err)
+	}
+	if len(envelope.Events) != 1 || envelope.Events[0].ID != event.ID {
+		t.Fatalf("unexpected envelope: %#v", envelope)
+	}
+}
+
+func TestEndpointMessageSink(t *testing.T) {
+	sink := &flakySink{failures: 2}
+	endpoint := NewEndpoint("message-sink", "", EndpointConfig{
+		Backoff:     time.Microsecond,
+		MaxBackoff:  time.Millisecond,
+		MessageSink: sink,
+	})
+
+	if err := endpoint.Write(createTestEvent("push", "library/test", "manifest")); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+	checkClose(t, endpoint)
+
+	if sink.count != 1 {
+		t.Fatalf("expected the event to be delivered once, got %d", sink.count)
+	}
+
+	var metrics EndpointMetrics
+	endpoint.ReadMetrics(&metrics)
+	if metrics.Retries != 2 {
+		t.Fatalf("unexpected retries: %d != 2", metrics.Retries)
+	}
+}
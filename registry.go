@@ -52,6 +52,20 @@ type Namespace interface {
 // RepositoryEnumerator describes an operation to enumerate repositories
 type RepositoryEnumerator interface {
 	Enumerate(ctx context.Context, ingester func(string) error) error
+
+	// EnumerateFrom applies ingester to each repository sorting lexically
+	// after start. If the enumeration fails, the returned error is an
+	// ErrRepositoryEnumerationInterrupted giving the last repository
+	// ingested, from which the enumeration can be resumed.
+	EnumerateFrom(ctx context.Context, start string, ingester func(string) error) error
+}
+
+// RepositoryPrefixEnumerator describes an operation to enumerate, in lexical
+// order, the repositories whose name starts with prefix and sorts after last.
+// The enumeration stops early, without error, if ingester returns
+// driver.ErrFilledBuffer.
+type RepositoryPrefixEnumerator interface {
+	EnumeratePrefix(ctx context.Context, prefix, last string, ingester func(string) error) error
 }
 
 // RepositoryRemover removes given repository
@@ -0,0 +1,81 @@
+package errcode
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// DetailDecoder decodes the raw JSON detail of an error into a typed value.
+type DetailDecoder func(raw json.RawMessage) (interface{}, error)
+
+var (
+	detailDecoders     = map[ErrorCode]DetailDecoder{}
+	detailDecodersLock sync.RWMutex
+)
+
+// RegisterDetailDecoder registers the decoder used to reconstruct the detail
+// of errors with the given code when unmarshaling Errors. Registering a
+// decoder for a code that already has one replaces it.
+func RegisterDetailDecoder(code ErrorCode, decoder DetailDecoder) {
+	detailDecodersLock.Lock()
+	defer detailDecodersLock.Unlock()
+
+	detailDecoders[code] = decoder
+}
+
+// decodeDetail decodes raw using the decoder registered for code. Codes
+// without a decoder, or details the decoder rejects, are decoded into a
+// generic interface{} as before.
+func decodeDetail(code ErrorCode, raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	detailDecodersLock.RLock()
+	decoder, ok := detailDecoders[code]
+	detailDecodersLock.RUnlock()
+
+	if ok {
+		if detail, err := decoder(raw); err == nil {
+			return detail, nil
+		}
+	}
+
+	var detail interface{}
+	if err := json.Unmarshal(raw, &detail); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// BlobUnknownDetail is the typed detail of a BLOB_UNKNOWN error.
+type BlobUnknownDetail struct {
+	Digest digest.Digest `json:"digest"`
+}
+
+// decodeBlobUnknownDetail accepts both the structured form and the bare
+// digest string historically sent by the registry.
+func decodeBlobUnknownDetail(raw json.RawMessage) (interface{}, error) {
+	var dgst digest.Digest
+	if err := json.Unmarshal(raw, &dgst); err == nil {
+		if err := dgst.Validate(); err != nil {
+			return nil, err
+		}
+		return BlobUnknownDetail{Digest: dgst}, nil
+	}
+
+	var detail BlobUnknownDetail
+	if err := json.Unmarshal(raw, &detail); err != nil {
+		return nil, err
+	}
+	if err := detail.Digest.Validate(); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+func init() {
+	RegisterDetailDecoder(ErrorCodeBlobUnknown, decodeBlobUnknownDetail)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 )
 
 // ErrorCoder is the base interface for ErrorCode and Error allowing
@@ -73,26 +74,44 @@ func (ec *ErrorCode) UnmarshalText(text []byte) error {
 // overrides the Message property.
 func (ec ErrorCode) WithMessage(message string) Error {
 	return Error{
-		Code:    ec,
-		Message: message,
+		Code:       ec,
+		Message:    message,
+		RetryAfter: ec.Descriptor().RetryAfter,
 	}
 }
 
+// WithMessagef creates a new Error struct based on the passed-in info and
+// overrides the Message property with the formatted string.
+func (ec ErrorCode) WithMessagef(format string, args ...interface{}) Error {
+	return ec.WithMessage(fmt.Sprintf(format, args...))
+}
+
 // WithDetail creates a new Error struct based on the passed-in info and
 // set the Detail property appropriately
 func (ec ErrorCode) WithDetail(detail interface{}) Error {
 	return Error{
-		Code:    ec,
-		Message: ec.Message(),
+		Code:       ec,
+		Message:    ec.Message(),
+		RetryAfter: ec.Descriptor().RetryAfter,
 	}.WithDetail(detail)
 }
 
 // WithArgs creates a new Error struct and sets the Args slice
 func (ec ErrorCode) WithArgs(args ...interface{}) Error {
+	return Error{
+		Code:       ec,
+		Message:    ec.Message(),
+		RetryAfter: ec.Descriptor().RetryAfter,
+	}.WithArgs(args...)
+}
+
+// WithRetryAfter creates a new Error struct based on the passed-in info and
+// sets the RetryAfter property, advising the client when to retry.
+func (ec ErrorCode) WithRetryAfter(d time.Duration) Error {
 	return Error{
 		Code:    ec,
 		Message: ec.Message(),
-	}.WithArgs(args...)
+	}.WithRetryAfter(d)
 }
 
 // Error provides a wrapper around ErrorCode with extra Details provided.
@@ -101,6 +120,10 @@ type Error struct {
 	Message string      `json:"message"`
 	Detail  interface{} `json:"detail,omitempty"`
 
+	// RetryAfter, if non-zero, is served to the client in a Retry-After
+	// header. It is never part of the JSON body.
+	RetryAfter time.Duration `json:"-"`
+
 	// TODO(duglin): See if we need an "args" property so we can do the
 	// variable substitution right before showing the message to the user
 }
@@ -121,9 +144,10 @@ func (e Error) Error() string {
 // some Detail info added
 func (e Error) WithDetail(detail interface{}) Error {
 	return Error{
-		Code:    e.Code,
-		Message: e.Message,
-		Detail:  detail,
+		Code:       e.Code,
+		Message:    e.Message,
+		Detail:     detail,
+		RetryAfter: e.RetryAfter,
 	}
 }
 
@@ -131,9 +155,21 @@ func (e Error) WithDetail(detail interface{}) Error {
 // variables in the Error's Message string, but returns a new Error
 func (e Error) WithArgs(args ...interface{}) Error {
 	return Error{
-		Code:    e.Code,
-		Message: fmt.Sprintf(e.Code.Message(), args...),
-		Detail:  e.Detail,
+		Code:       e.Code,
+		Message:    fmt.Sprintf(e.Code.Message(), args...),
+		Detail:     e.Detail,
+		RetryAfter: e.RetryAfter,
+	}
+}
+
+// WithRetryAfter will return a new Error, based on the current one, but
+// advising the client to retry after the given duration.
+func (e Error) WithRetryAfter(d time.Duration) Error {
+	return Error{
+		Code:       e.Code,
+		Message:    e.Message,
+		Detail:     e.Detail,
+		RetryAfter: d,
 	}
 }
 
@@ -158,6 +194,10 @@ type ErrorDescriptor struct {
 	// HTTPStatusCode provides the http status code that is associated with
 	// this error condition.
 	HTTPStatusCode int
+
+	// RetryAfter, if non-zero, is the default delay advertised to clients
+	// in a Retry-After header when this error condition is served.
+	RetryAfter time.Duration
 }
 
 // ParseErrorCode returns the value by the string error code.
@@ -244,10 +284,15 @@ func (errs Errors) MarshalJSON() ([]byte, error) {
 }
 
 // UnmarshalJSON deserializes []Error and then converts it into slice of
-// Error or ErrorCode
+// Error or ErrorCode. Details of error codes with a registered
+// DetailDecoder are decoded into their typed form.
 func (errs *Errors) UnmarshalJSON(data []byte) error {
 	var tmpErrs struct {
-		Errors []Error
+		Errors []struct {
+			Code    ErrorCode       `json:"code"`
+			Message string          `json:"message"`
+			Detail  json.RawMessage `json:"detail,omitempty"`
+		}
 	}
 
 	if err := json.Unmarshal(data, &tmpErrs); err != nil {
@@ -255,7 +300,17 @@ func (errs *Errors) UnmarshalJSON(data []byte) error {
 	}
 
 	var newErrs Errors
-	for _, daErr := range tmpErrs.Errors {
+	for _, rawErr := range tmpErrs.Errors {
+		daErr := Error{
+			Code:    rawErr.Code,
+			Message: rawErr.Message,
+		}
+		detail, err := decodeDetail(rawErr.Code, rawErr.Detail)
+		if err != nil {
+			return err
+		}
+		daErr.Detail = detail
+
 		// If Message is empty or exactly matches the Code's message string
 		// then just use the Code, no need for a full Error struct
 		if daErr.Detail == nil && (daErr.Message == "" || daErr.Message == daErr.Code.Message()) {
@@ -263,11 +318,7 @@ func (errs *Errors) UnmarshalJSON(data []byte) error {
 			newErrs = append(newErrs, daErr.Code)
 		} else {
 			// Error's w/ details are untouched
-			newErrs = append(newErrs, Error{
-				Code:    daErr.Code,
-				Message: daErr.Message,
-				Detail:  daErr.Detail,
-			})
+			newErrs = append(newErrs, daErr)
 		}
 	}
 
@@ -3,9 +3,13 @@ package errcode
 import (
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
 )
 
 // TestErrorsManagement does a quick check of the Errors type to ensure that
@@ -94,6 +98,31 @@ func TestErrorCodes(t *testing.T) {
 	}
 }
 
+// TestManifestSchemaV1DisabledRegistration ensures that the code rejecting
+// schema1 pushes is registered in the v2 group and found by its value.
+func TestManifestSchemaV1DisabledRegistration(t *testing.T) {
+	desc := ErrorCodeManifestSchemaV1Disabled.Descriptor()
+	if desc.Value != "MANIFEST_SCHEMA_V1_DISABLED" {
+		t.Fatalf("unexpected value: %q", desc.Value)
+	}
+	if desc.HTTPStatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code: %d", desc.HTTPStatusCode)
+	}
+	if ec := ParseErrorCode("MANIFEST_SCHEMA_V1_DISABLED"); ec != ErrorCodeManifestSchemaV1Disabled {
+		t.Fatalf("unexpected error code parsed: %v", ec)
+	}
+
+	var found bool
+	for _, d := range GetErrorCodeGroup(errGroup) {
+		if d.Code == ErrorCodeManifestSchemaV1Disabled {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("MANIFEST_SCHEMA_V1_DISABLED not registered in group %s", errGroup)
+	}
+}
+
 func TestErrorsManagement(t *testing.T) {
 	var errs Errors
 
@@ -186,3 +215,87 @@ func TestErrorsManagement(t *testing.T) {
 		t.Fatalf("e2 had wrong detail: %q", e2.Detail)
 	}
 }
+
+func TestErrorRetryAfter(t *testing.T) {
+	e := ErrorCodeTest1.WithRetryAfter(1500 * time.Millisecond).WithDetail("data")
+	if e.RetryAfter != 1500*time.Millisecond {
+		t.Fatalf("RetryAfter not preserved by WithDetail: %v", e.RetryAfter)
+	}
+
+	p, err := json.Marshal(Errors{e})
+	if err != nil {
+		t.Fatalf("error marshaling errors: %v", err)
+	}
+	expectedJSON := `{"errors":[{"code":"TEST1","message":"test error 1","detail":"data"}]}`
+	if string(p) != expectedJSON {
+		t.Fatalf("unexpected json: %q != %q", string(p), expectedJSON)
+	}
+
+	w := httptest.NewRecorder()
+	if err := ServeJSON(w, Errors{e}); err != nil {
+		t.Fatalf("unexpected error serving json: %v", err)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("unexpected Retry-After header: %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	if err := ServeJSON(w, ErrorCodeTest1); err != nil {
+		t.Fatalf("unexpected error serving json: %v", err)
+	}
+	if got := w.Header().Get("Retry-After"); got != "" {
+		t.Fatalf("unexpected Retry-After header: %q", got)
+	}
+}
+
+func TestErrorsTypedDetail(t *testing.T) {
+	dgst := digest.FromString("sometestblob")
+
+	errs := Errors{
+		ErrorCodeBlobUnknown.WithDetail(BlobUnknownDetail{Digest: dgst}),
+		ErrorCodeTest2.WithMessagef("blob %s is %d bytes", dgst, 42).WithDetail(map[string]interface{}{"digest": dgst.String()}),
+	}
+
+	p, err := json.Marshal(errs)
+	if err != nil {
+		t.Fatalf("error marashaling errors: %v", err)
+	}
+
+	expectedJSON := `{"errors":[` +
+		`{"code":"BLOB_UNKNOWN","message":"blob unknown to registry","detail":{"digest":"` + dgst.String() + `"}},` +
+		`{"code":"TEST2","message":"blob ` + dgst.String() + ` is 42 bytes","detail":{"digest":"` + dgst.String() + `"}}` +
+		`]}`
+	if string(p) != expectedJSON {
+		t.Fatalf("unexpected json:\ngot:\n%q\n\nexpected:\n%q", string(p), expectedJSON)
+	}
+
+	var unmarshaled Errors
+	if err := json.Unmarshal(p, &unmarshaled); err != nil {
+		t.Fatalf("unexpected error unmarshaling error envelope: %v", err)
+	}
+
+	if !reflect.DeepEqual(unmarshaled, errs) {
+		t.Fatalf("errors not equal after round trip:\nunmarshaled:\n%#v\n\nerrs:\n%#v", unmarshaled, errs)
+	}
+
+	// The bare digest string historically sent as the detail is also
+	// reconstructed into the typed detail.
+	p = []byte(`{"errors":[{"code":"BLOB_UNKNOWN","message":"blob unknown to registry","detail":"` + dgst.String() + `"}]}`)
+	unmarshaled = nil
+	if err := json.Unmarshal(p, &unmarshaled); err != nil {
+		t.Fatalf("unexpected error unmarshaling error envelope: %v", err)
+	}
+	if detail, ok := unmarshaled[0].(Error).Detail.(BlobUnknownDetail); !ok || detail.Digest != dgst {
+		t.Fatalf("unexpected detail: %#v", unmarshaled[0].(Error).Detail)
+	}
+
+	// Details a decoder cannot handle fall back to the generic form.
+	p = []byte(`{"errors":[{"code":"BLOB_UNKNOWN","message":"blob unknown to registry","detail":"not a digest"}]}`)
+	unmarshaled = nil
+	if err := json.Unmarshal(p, &unmarshaled); err != nil {
+		t.Fatalf("unexpected error unmarshaling error envelope: %v", err)
+	}
+	if detail, ok := unmarshaled[0].(Error).Detail.(string); !ok || detail != "not a digest" {
+		t.Fatalf("unexpected detail: %#v", unmarshaled[0].(Error).Detail)
+	}
+}
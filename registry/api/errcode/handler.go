@@ -2,15 +2,22 @@ package errcode
 
 import (
 	"encoding/json"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // ServeJSON attempts to serve the errcode in a JSON envelope. It marshals err
 // and sets the content-type header to 'application/json'. It will handle
-// ErrorCoder and Errors, and if necessary will create an envelope.
+// ErrorCoder and Errors, and if necessary will create an envelope. If the
+// first error advises a retry delay, a Retry-After header is also set.
 func ServeJSON(w http.ResponseWriter, err error) error {
 	w.Header().Set("Content-Type", "application/json")
-	var sc int
+	var (
+		sc         int
+		retryAfter time.Duration
+	)
 
 	switch errs := err.(type) {
 	case Errors:
@@ -20,9 +27,11 @@ func ServeJSON(w http.ResponseWriter, err error) error {
 
 		if err, ok := errs[0].(ErrorCoder); ok {
 			sc = err.ErrorCode().Descriptor().HTTPStatusCode
+			retryAfter = retryAfterOf(err)
 		}
 	case ErrorCoder:
 		sc = errs.ErrorCode().Descriptor().HTTPStatusCode
+		retryAfter = retryAfterOf(errs)
 		err = Errors{err} // create an envelope.
 	default:
 		// We just have an unhandled error type, so just place in an envelope
@@ -34,7 +43,22 @@ func ServeJSON(w http.ResponseWriter, err error) error {
 		sc = http.StatusInternalServerError
 	}
 
+	if retryAfter > 0 {
+		// Retry-After is expressed in whole seconds; round up so clients
+		// never retry early.
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+
 	w.WriteHeader(sc)
 
 	return json.NewEncoder(w).Encode(err)
 }
+
+// retryAfterOf returns the retry delay carried by err, falling back to the
+// default registered for its error code.
+func retryAfterOf(err ErrorCoder) time.Duration {
+	if e, ok := err.(Error); ok && e.RetryAfter > 0 {
+		return e.RetryAfter
+	}
+	return err.ErrorCode().Descriptor().RetryAfter
+}
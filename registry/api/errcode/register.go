@@ -101,6 +101,18 @@ var (
 		HTTPStatusCode: http.StatusBadRequest,
 	})
 
+	// ErrorCodeSizeExceeded is returned when a request body is larger than
+	// the registry accepts.
+	ErrorCodeSizeExceeded = register(errGroup, ErrorDescriptor{
+		Value:   "SIZE_EXCEEDED",
+		Message: "request body too large",
+		Description: `When a manifest or a blob upload chunk is uploaded, the
+		size of the request body is checked against the limit configured for
+		the endpoint. If it is larger, this error will be returned. A blob
+		larger than the limit may still be uploaded in several chunks.`,
+		HTTPStatusCode: http.StatusRequestEntityTooLarge,
+	})
+
 	// ErrorCodeRangeInvalid is returned when uploading a blob if the provided
 	// content range is invalid.
 	ErrorCodeRangeInvalid = register(errGroup, ErrorDescriptor{
@@ -132,6 +144,17 @@ var (
 		HTTPStatusCode: http.StatusBadRequest,
 	})
 
+	// ErrorCodeTagImmutable is returned when a manifest upload would
+	// repoint an immutable tag.
+	ErrorCodeTagImmutable = register(errGroup, ErrorDescriptor{
+		Value:   "TAG_IMMUTABLE",
+		Message: "tag is immutable",
+		Description: `During a manifest upload, if the tag is immutable and
+		already references a different manifest, this error will be
+		returned. Uploading the manifest the tag references succeeds.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
 	// ErrorCodeNameUnknown when the repository name is not known.
 	ErrorCodeNameUnknown = register(errGroup, ErrorDescriptor{
 		Value:   "NAME_UNKNOWN",
@@ -173,6 +196,17 @@ var (
 		HTTPStatusCode: http.StatusBadRequest,
 	})
 
+	// ErrorCodeManifestSchemaV1Disabled is returned when a client attempts
+	// to push a schema1 manifest.
+	ErrorCodeManifestSchemaV1Disabled = register(errGroup, ErrorDescriptor{
+		Value:   "MANIFEST_SCHEMA_V1_DISABLED",
+		Message: "schema1 manifests are not accepted by this registry",
+		Description: `During manifest upload, if the manifest uses the
+		deprecated schema1 format, this error will be returned. Clients
+		should push a schema2 or OCI manifest instead.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
 	// ErrorCodeManifestBlobUnknown is returned when a manifest blob is
 	// unknown to the registry.
 	ErrorCodeManifestBlobUnknown = register(errGroup, ErrorDescriptor{
@@ -214,6 +248,17 @@ var (
 		HTTPStatusCode: http.StatusNotFound,
 	})
 
+	// ErrorCodeContentCorrupt is returned when stored content read to be
+	// served does not match its digest.
+	ErrorCodeContentCorrupt = register(errGroup, ErrorDescriptor{
+		Value:   "CONTENT_CORRUPT",
+		Message: "stored content does not match its digest",
+		Description: `When content verification on read is enabled, the
+		registry digests the manifests it serves. If the stored content of a
+		manifest does not match its digest, this error will be returned.`,
+		HTTPStatusCode: http.StatusInternalServerError,
+	})
+
 	// ErrorCodePaginationNumberInvalid is returned when the `n` parameter is
 	// not an integer, or `n` is negative.
 	ErrorCodePaginationNumberInvalid = register(errGroup, ErrorDescriptor{
@@ -36,6 +36,14 @@ var (
 		Description: `Tag or digest of the target manifest.`,
 	}
 
+	tagParameterDescriptor = ParameterDescriptor{
+		Name:        "reference",
+		Type:        "string",
+		Format:      reference.TagRegexp.String(),
+		Required:    true,
+		Description: `Name of the target tag.`,
+	}
+
 	uuidParameterDescriptor = ParameterDescriptor{
 		Name:        "uuid",
 		Type:        "opaque",
@@ -105,6 +113,20 @@ var (
 		Format:      `<<url>?n=<last n value>&last=<last entry from response>>; rel="next"`,
 	}
 
+	totalCountHeader = ParameterDescriptor{
+		Name:        "X-Total-Count",
+		Type:        "integer",
+		Description: "The total number of entries of the list, regardless of pagination. It is absent if counting the entries exceeded the configured count budget.",
+		Format:      "<count>",
+	}
+
+	totalCountEstimateHeader = ParameterDescriptor{
+		Name:        "X-Total-Count-Estimate",
+		Type:        "integer",
+		Description: "The number of entries of the list counted before the configured count budget was exceeded, a lower bound of their total. It is only set instead of X-Total-Count.",
+		Format:      "<count>",
+	}
+
 	paginationParameters = []ParameterDescriptor{
 		{
 			Name:        "n",
@@ -461,6 +483,8 @@ var routeDescriptors = []RouteDescriptor{
 										Description: "Length of the JSON response body.",
 										Format:      "<length>",
 									},
+									totalCountHeader,
+									totalCountEstimateHeader,
 								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
@@ -498,6 +522,8 @@ var routeDescriptors = []RouteDescriptor{
 										Format:      "<length>",
 									},
 									linkHeader,
+									totalCountHeader,
+									totalCountEstimateHeader,
 								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
@@ -521,6 +547,108 @@ var routeDescriptors = []RouteDescriptor{
 					},
 				},
 			},
+			{
+				Method:      http.MethodHead,
+				Description: "Count the tags under the repository identified by `name`, without listing them.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Tags Count",
+						Description: "Return the number of tags of the repository in the headers of the response.",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "The number of tags of the named repository.",
+								Headers: []ParameterDescriptor{
+									totalCountHeader,
+									totalCountEstimateHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameTag,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/tags/{reference:" + reference.TagRegexp.String() + "}",
+		Entity:      "Tag",
+		Description: "Delete tags. The route is removed if the registry is configured with `http.apis.tagdelete.disabled`. The tag `list` is deleted through the path of the tags route, `/v2/<name>/tags/list`.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodDelete,
+				Description: "Delete the tag identified by `name` and `reference`, leaving the manifest it points to in place.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							tagParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusAccepted,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Name or Tag",
+								Description: "The specified `name` or `reference` were invalid and the delete was unable to proceed.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeNameInvalid,
+									errcode.ErrorCodeTagInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+							{
+								Name:        "Unknown Tag",
+								Description: "The specified `name` or `reference` are unknown to the registry and the delete was unable to proceed.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeNameUnknown,
+									errcode.ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Not allowed",
+								Description: "Tag delete is not allowed because the registry is configured as a pull-through cache or read-only.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
 		},
 	},
 	{
@@ -623,6 +751,7 @@ var routeDescriptors = []RouteDescriptor{
 									errcode.ErrorCodeTagInvalid,
 									errcode.ErrorCodeManifestInvalid,
 									errcode.ErrorCodeManifestUnverified,
+									errcode.ErrorCodeManifestSchemaV1Disabled,
 									errcode.ErrorCodeBlobUnknown,
 								},
 							},
@@ -666,7 +795,7 @@ var routeDescriptors = []RouteDescriptor{
 			},
 			{
 				Method:      http.MethodDelete,
-				Description: "Delete the manifest or tag identified by `name` and `reference` where `reference` can be a tag or digest. Note that a manifest can _only_ be deleted by digest.",
+				Description: "Delete the manifest or tag identified by `name` and `reference` where `reference` can be a tag or digest. Note that a manifest can _only_ be deleted by digest, and a tag unless the registry is configured with `http.apis.tagdelete.disabled`.",
 				Requests: []RequestDescriptor{
 					{
 						Headers: []ParameterDescriptor{
@@ -1560,6 +1689,8 @@ var routeDescriptors = []RouteDescriptor{
 										Description: "Length of the JSON response body.",
 										Format:      "<length>",
 									},
+									totalCountHeader,
+									totalCountEstimateHeader,
 								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
@@ -1598,6 +1729,8 @@ var routeDescriptors = []RouteDescriptor{
 										Format:      "<length>",
 									},
 									linkHeader,
+									totalCountHeader,
+									totalCountEstimateHeader,
 								},
 							},
 						},
@@ -1607,6 +1740,460 @@ var routeDescriptors = []RouteDescriptor{
 					},
 				},
 			},
+			{
+				Method:      http.MethodHead,
+				Description: "Count the repositories available in the registry, without listing them.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Catalog Count",
+						Description: "Return the number of repositories in the headers of the response. The count is restricted to the repositories starting with the `q` query parameter, if any.",
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The number of repositories.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									totalCountHeader,
+									totalCountEstimateHeader,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameInfo,
+		Path:        "/v2/_distribution/registry/info",
+		Entity:      "Info",
+		Description: "Describe the build and the configuration of the registry. This route is an extension of the registry, which may be disabled.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the version, the enabled features and the storage driver of the registry.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Info Fetch",
+						Successes: []ResponseDescriptor{
+							{
+								Description: "Returns the description of the registry as a json response.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"version": <version>,
+	"revision": <revision>,
+	"storage": <driver name>,
+	"features": {
+		<feature>: <enabled>,
+		...
+	}
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameTagDetails,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_distribution/registry/tags/{reference:" + reference.TagRegexp.String() + "}",
+		Entity:      "Tag Details",
+		Description: "Describe a tag, with when it was changed. This route is an extension of the registry.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the manifest the tag identified by `name` and `reference` points to, when the tag was last changed, and the manifests it pointed to.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Tag Details Fetch",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							tagParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "Returns the details of the tag as a json response. The history lists the manifests the tag pointed to, ordered by when the tag was last made to point to them.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Last-Modified",
+										Type:        "string",
+										Description: "When the tag was last changed.",
+										Format:      "<http date>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"name": <name>,
+	"tag": <tag>,
+	"digest": <digest>,
+	"lastModified": <RFC 3339 time>,
+	"history": [
+		{
+			"digest": <digest>,
+			"tagged": <RFC 3339 time>
+		},
+		...
+	]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+							{
+								Name:        "Unknown Tag",
+								Description: "The tag is unknown to the registry.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Not supported",
+								Description: "The registry cannot describe tags, as when it is configured as a pull-through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameTagsResolve,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_distribution/registry/resolve",
+		Entity:      "Tags Resolution",
+		Description: "Resolve tags to the manifests they point to in a single request. This route is an extension of the registry.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodPost,
+				Description: "Resolve the tags of the repository identified by `name` listed in the request body, at most 1000 of them. Resolving tags only requires pull access to the repository.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Tags Resolution",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format:      `[<tag>, ...]`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "Returns the descriptors of the manifests the known tags point to, and lists the unknown tags.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"name": <name>,
+	"tags": {
+		<tag>: {
+			"digest": <digest>,
+			"mediaType": <media type>,
+			"size": <size>
+		},
+		...
+	},
+	"unknown": [<tag>, ...]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+							{
+								Name:        "Invalid Tags",
+								Description: "The request body is not a list of valid tags.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeTagInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Too Many Tags",
+								Description: "The request lists more tags than the registry resolves at once.",
+								StatusCode:  http.StatusRequestEntityTooLarge,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeSizeExceeded,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameReferrers,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/referrers/{digest:" + digest.DigestRegexp.String() + "}",
+		Entity:      "Referrers",
+		Description: "List the manifests referring to the manifest identified by `name` and `digest` as their subject.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch the referrers of the manifest identified by `digest`, as an image index, whether or not the manifest exists.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Referrers",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							digestPathParameter,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "artifactType",
+								Type:        "string",
+								Description: "Only list the referrers of this artifact type.",
+								Format:      "<artifact type>",
+								Required:    false,
+							},
+							{
+								Name:        "last",
+								Type:        "string",
+								Description: "Result set will include the referrers whose digest sorts after last.",
+								Format:      "<digest>",
+								Required:    false,
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The referrers of the manifest, ordered by digest, up to the configured page size.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "OCI-Filters-Applied",
+										Type:        "string",
+										Description: "The filters applied to the referrers, set if `artifactType` is.",
+										Format:      "artifactType",
+									},
+									{
+										Name:        "Link",
+										Type:        "link",
+										Description: "RFC5988 compliant rel='next' with URL to next result set, if available",
+										Format:      `<<url>?last=<last digest from response>>; rel="next"`,
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/vnd.oci.image.index.v1+json",
+									Format: `{
+	"schemaVersion": 2,
+	"mediaType": "application/vnd.oci.image.index.v1+json",
+	"manifests": [
+		{
+			"mediaType": <media type>,
+			"digest": <digest>,
+			"size": <size>,
+			"artifactType": <artifact type>,
+			"annotations": {...}
+		},
+		...
+	]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The `digest` of the manifest, or the `last` query parameter, is invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeNameInvalid,
+									errcode.ErrorCodeDigestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The registry does not index the referrers of the repository, as when it is a pull through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameUploads,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_distribution/registry/uploads",
+		Entity:      "Uploads",
+		Description: "List the blob uploads in progress of a repository, to find the ones which are stuck. This route is an extension of the registry.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch the uploads in progress of the repository identified by `name`, sorted by uuid. Listing uploads requires push access to the repository.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Uploads",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The uploads in progress, with the bytes received so far and the time bytes were last received.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"name": <name>,
+	"uploads": [
+		{
+			"uuid": <uuid>,
+			"startedAt": <time>,
+			"size": <bytes received>,
+			"lastActivity": <time>
+		},
+		...
+	]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Not supported",
+								Description: "The registry cannot list uploads, as when it is configured as a pull-through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameUpload,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_distribution/registry/uploads/{uuid:[a-zA-Z0-9-_.=]+}",
+		Entity:      "Upload",
+		Description: "Cancel a blob upload in progress of a repository. This route is an extension of the registry.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodDelete,
+				Description: "Cancel the upload identified by `uuid`, as its client would, and remove the data received so far. Unlike the blob upload route, it does not require the upload state.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Cancel Upload",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							uuidParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The upload was cancelled.",
+								StatusCode:  http.StatusNoContent,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The upload is unknown to the registry. It may have been completed or cancelled.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeBlobUploadUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Not supported",
+								Description: "The registry cannot cancel uploads, as when it is configured as a pull-through cache or is read-only.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
 		},
 	},
 }
@@ -0,0 +1,74 @@
+package v2
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/distribution/distribution/v3/internal/requestutil"
+)
+
+// trustedForwardedSchemeAndHost returns the scheme and host of the original
+// request, as reported by the trusted proxies which forwarded r. It returns
+// empty strings for the values which are not reported, and if r does not
+// come from a trusted proxy.
+//
+// The "Forwarded" header takes precedence over the X-Forwarded-Proto and
+// X-Forwarded-Host headers. Each proxy appends an element to these headers,
+// describing the request it received: they are read from the last element
+// backwards, as long as the element was received from a trusted proxy, the
+// values of older elements overriding the values of newer ones.
+func trustedForwardedSchemeAndHost(r *http.Request, trustedProxies []net.IPNet) (scheme, host string) {
+	if !requestutil.NetworksContain(trustedProxies, requestutil.ParseForwardedIP(r.RemoteAddr)) {
+		return "", ""
+	}
+
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		elements, err := requestutil.ParseForwardedElements(strings.Join(forwarded, ","))
+		if err != nil {
+			return "", ""
+		}
+		for i := len(elements) - 1; i >= 0; i-- {
+			if proto := elements[i]["proto"]; proto != "" {
+				scheme = proto
+			}
+			if h := elements[i]["host"]; h != "" {
+				host = h
+			}
+			if !requestutil.NetworksContain(trustedProxies, requestutil.ParseForwardedIP(elements[i]["for"])) {
+				break
+			}
+		}
+		return scheme, host
+	}
+
+	// The legacy headers are aligned with X-Forwarded-For, if every proxy
+	// appends to them. Otherwise, only their last value, set by the proxy
+	// in front of the registry, can be trusted.
+	hops := splitHeaderList(r.Header.Values("X-Forwarded-For"))
+	first := len(hops) - 1
+	for first > 0 && requestutil.NetworksContain(trustedProxies, requestutil.ParseForwardedIP(hops[first])) {
+		first--
+	}
+	pick := func(values []string) string {
+		if len(values) == 0 {
+			return ""
+		}
+		if len(values) == len(hops) {
+			return values[first]
+		}
+		return values[len(values)-1]
+	}
+	return pick(splitHeaderList(r.Header.Values("X-Forwarded-Proto"))), pick(splitHeaderList(r.Header.Values("X-Forwarded-Host")))
+}
+
+// splitHeaderList splits the comma separated lists of a header's values.
+func splitHeaderList(values []string) []string {
+	var items []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+	}
+	return items
+}
@@ -12,10 +12,17 @@ const (
 	RouteNameBase            = "base"
 	RouteNameManifest        = "manifest"
 	RouteNameTags            = "tags"
+	RouteNameTag             = "tag"
 	RouteNameBlob            = "blob"
 	RouteNameBlobUpload      = "blob-upload"
 	RouteNameBlobUploadChunk = "blob-upload-chunk"
 	RouteNameCatalog         = "catalog"
+	RouteNameInfo            = "info"
+	RouteNameTagDetails      = "tag-details"
+	RouteNameTagsResolve     = "tags-resolve"
+	RouteNameReferrers       = "referrers"
+	RouteNameUploads         = "uploads"
+	RouteNameUpload          = "upload"
 )
 
 var (
@@ -35,6 +35,11 @@ func TestRouter(t *testing.T) {
 			RequestURI: "/v2/",
 			Vars:       map[string]string{},
 		},
+		{
+			RouteName:  RouteNameInfo,
+			RequestURI: "/v2/_distribution/registry/info",
+			Vars:       map[string]string{},
+		},
 		{
 			RouteName:  RouteNameManifest,
 			RequestURI: "/v2/foo/manifests/bar",
@@ -87,6 +92,60 @@ func TestRouter(t *testing.T) {
 				"name": "docker.com/foo/bar/baz",
 			},
 		},
+		{
+			RouteName:  RouteNameTag,
+			RequestURI: "/v2/foo/bar/tags/latest",
+			Vars: map[string]string{
+				"name":      "foo/bar",
+				"reference": "latest",
+			},
+		},
+		{
+			RouteName:  RouteNameTag,
+			RequestURI: "/v2/foo/tags/tags/v1.0",
+			Vars: map[string]string{
+				"name":      "foo/tags",
+				"reference": "v1.0",
+			},
+		},
+		{
+			RouteName:  RouteNameTagDetails,
+			RequestURI: "/v2/foo/bar/_distribution/registry/tags/latest",
+			Vars: map[string]string{
+				"name":      "foo/bar",
+				"reference": "latest",
+			},
+		},
+		{
+			RouteName:  RouteNameTagsResolve,
+			RequestURI: "/v2/foo/bar/_distribution/registry/resolve",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameUploads,
+			RequestURI: "/v2/foo/bar/_distribution/registry/uploads",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameUpload,
+			RequestURI: "/v2/foo/bar/_distribution/registry/uploads/D95306FA-FAD3-4E36-8D41-CF1C93EF8286",
+			Vars: map[string]string{
+				"name": "foo/bar",
+				"uuid": "D95306FA-FAD3-4E36-8D41-CF1C93EF8286",
+			},
+		},
+		{
+			RouteName:  RouteNameReferrers,
+			RequestURI: "/v2/foo/bar/referrers/sha256:abcdef0919234",
+			Vars: map[string]string{
+				"name":   "foo/bar",
+				"digest": "sha256:abcdef0919234",
+			},
+		},
 		{
 			RouteName:  RouteNameBlob,
 			RequestURI: "/v2/foo/bar/blobs/sha256:abcdef0919234",
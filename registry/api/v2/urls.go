@@ -2,10 +2,12 @@ package v2
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
 
+	"github.com/distribution/distribution/v3/internal/requestutil"
 	"github.com/distribution/reference"
 	"github.com/gorilla/mux"
 )
@@ -45,24 +47,16 @@ func NewURLBuilderFromString(root string, relative bool) (*URLBuilder, error) {
 }
 
 // NewURLBuilderFromRequest uses information from an *http.Request to
-// construct the root url.
+// construct the root url. The forwarded headers of the request are trusted
+// regardless of where it comes from.
 func NewURLBuilderFromRequest(r *http.Request, relative bool) *URLBuilder {
-	var (
-		scheme = "http"
-		host   = r.Host
-	)
-
-	if r.TLS != nil {
-		scheme = "https"
-	} else if len(r.URL.Scheme) > 0 {
-		scheme = r.URL.Scheme
-	}
+	scheme, host := requestSchemeAndHost(r)
 
 	// Handle forwarded headers
 	// Prefer "Forwarded" header as defined by rfc7239 if given
 	// see https://tools.ietf.org/html/rfc7239
 	if forwarded := r.Header.Get("Forwarded"); len(forwarded) > 0 {
-		forwardedHeader, _, err := parseForwardedHeader(forwarded)
+		forwardedHeader, _, err := requestutil.ParseForwardedHeader(forwarded)
 		if err == nil {
 			if fproto := forwardedHeader["proto"]; len(fproto) > 0 {
 				scheme = fproto
@@ -85,6 +79,41 @@ func NewURLBuilderFromRequest(r *http.Request, relative bool) *URLBuilder {
 		}
 	}
 
+	return newURLBuilderFromRequest(r, scheme, host, relative)
+}
+
+// NewURLBuilderFromTrustedRequest works like NewURLBuilderFromRequest,
+// except that the forwarded headers are only honored if the request comes
+// from one of trustedProxies. The headers are then read from the most recent
+// hop backwards, as long as the hops are trusted proxies, so that a client
+// cannot spoof the values set by the proxies in front of the registry.
+func NewURLBuilderFromTrustedRequest(r *http.Request, relative bool, trustedProxies []net.IPNet) *URLBuilder {
+	scheme, host := requestSchemeAndHost(r)
+	fscheme, fhost := trustedForwardedSchemeAndHost(r, trustedProxies)
+	if fscheme != "" {
+		scheme = fscheme
+	}
+	if fhost != "" {
+		host = fhost
+	}
+	return newURLBuilderFromRequest(r, scheme, host, relative)
+}
+
+// requestSchemeAndHost returns the scheme and host of the request, as
+// received by the registry.
+func requestSchemeAndHost(r *http.Request) (scheme, host string) {
+	scheme, host = "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	} else if len(r.URL.Scheme) > 0 {
+		scheme = r.URL.Scheme
+	}
+	return scheme, host
+}
+
+// newURLBuilderFromRequest creates a URLBuilder rooted at the path of the
+// request preceding the API base path.
+func newURLBuilderFromRequest(r *http.Request, scheme, host string, relative bool) *URLBuilder {
 	basePath := routeDescriptorsMap[RouteNameBase].Path
 
 	requestPath := r.URL.Path
@@ -127,6 +156,18 @@ func (ub *URLBuilder) BuildCatalogURL(values ...url.Values) (string, error) {
 	return appendValuesURL(catalogURL, values...).String(), nil
 }
 
+// BuildInfoURL constructs a url to describe the registry.
+func (ub *URLBuilder) BuildInfoURL() (string, error) {
+	route := ub.cloneRoute(RouteNameInfo)
+
+	infoURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return infoURL.String(), nil
+}
+
 // BuildTagsURL constructs a url to list the tags in the named repository.
 func (ub *URLBuilder) BuildTagsURL(name reference.Named, values ...url.Values) (string, error) {
 	route := ub.cloneRoute(RouteNameTags)
@@ -139,6 +180,82 @@ func (ub *URLBuilder) BuildTagsURL(name reference.Named, values ...url.Values) (
 	return appendValuesURL(tagsURL, values...).String(), nil
 }
 
+// BuildTagURL constructs a url for the tag of ref.
+func (ub *URLBuilder) BuildTagURL(ref reference.NamedTagged) (string, error) {
+	route := ub.cloneRoute(RouteNameTag)
+
+	tagURL, err := route.URL("name", ref.Name(), "reference", ref.Tag())
+	if err != nil {
+		return "", err
+	}
+
+	return tagURL.String(), nil
+}
+
+// BuildTagDetailsURL constructs a url to describe the tag of ref.
+func (ub *URLBuilder) BuildTagDetailsURL(ref reference.NamedTagged) (string, error) {
+	route := ub.cloneRoute(RouteNameTagDetails)
+
+	tagDetailsURL, err := route.URL("name", ref.Name(), "reference", ref.Tag())
+	if err != nil {
+		return "", err
+	}
+
+	return tagDetailsURL.String(), nil
+}
+
+// BuildTagsResolveURL constructs a url to resolve tags of the repository
+// name.
+func (ub *URLBuilder) BuildTagsResolveURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameTagsResolve)
+
+	resolveURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return resolveURL.String(), nil
+}
+
+// BuildUploadsURL constructs a url to list the uploads in progress of the
+// repository name.
+func (ub *URLBuilder) BuildUploadsURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameUploads)
+
+	uploadsURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return uploadsURL.String(), nil
+}
+
+// BuildUploadURL constructs a url to cancel the upload uuid in progress of
+// the repository name.
+func (ub *URLBuilder) BuildUploadURL(name reference.Named, uuid string) (string, error) {
+	route := ub.cloneRoute(RouteNameUpload)
+
+	uploadURL, err := route.URL("name", name.Name(), "uuid", uuid)
+	if err != nil {
+		return "", err
+	}
+
+	return uploadURL.String(), nil
+}
+
+// BuildReferrersURL constructs a url to list the referrers of the manifest
+// of ref.
+func (ub *URLBuilder) BuildReferrersURL(ref reference.Canonical, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameReferrers)
+
+	referrersURL, err := route.URL("name", ref.Name(), "digest", ref.Digest().String())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(referrersURL, values...).String(), nil
+}
+
 // BuildManifestURL constructs a url for the manifest identified by name and
 // reference. The argument reference may be either a tag or digest.
 func (ub *URLBuilder) BuildManifestURL(ref reference.Named) (string, error) {
@@ -2,6 +2,7 @@ package v2
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"reflect"
@@ -34,6 +35,59 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 				return urlBuilder.BuildTagsURL(fooBarRef)
 			},
 		},
+		{
+			description:  "test tag url",
+			expectedPath: "/v2/foo/bar/tags/latest",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				ref, _ := reference.WithTag(fooBarRef, "latest")
+				return urlBuilder.BuildTagURL(ref)
+			},
+		},
+		{
+			description:  "test tag details url",
+			expectedPath: "/v2/foo/bar/_distribution/registry/tags/latest",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				ref, _ := reference.WithTag(fooBarRef, "latest")
+				return urlBuilder.BuildTagDetailsURL(ref)
+			},
+		},
+		{
+			description:  "test tags resolve url",
+			expectedPath: "/v2/foo/bar/_distribution/registry/resolve",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildTagsResolveURL(fooBarRef)
+			},
+		},
+		{
+			description:  "test uploads url",
+			expectedPath: "/v2/foo/bar/_distribution/registry/uploads",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildUploadsURL(fooBarRef)
+			},
+		},
+		{
+			description:  "test upload url",
+			expectedPath: "/v2/foo/bar/_distribution/registry/uploads/uuid-4321",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildUploadURL(fooBarRef, "uuid-4321")
+			},
+		},
+		{
+			description:  "test referrers url with artifactType query parameter",
+			expectedPath: "/v2/foo/bar/referrers/sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5?artifactType=application%2Fvnd.example",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				ref, _ := reference.WithDigest(fooBarRef, "sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5")
+				return urlBuilder.BuildReferrersURL(ref, url.Values{
+					"artifactType": []string{"application/vnd.example"},
+				})
+			},
+		},
 		{
 			description:  "test tags url with n query parameter",
 			expectedPath: "/v2/foo/bar/tags/list?n=10",
@@ -533,3 +587,143 @@ func TestBuilderFromRequestWithPrefix(t *testing.T) {
 		}
 	}
 }
+
+func TestBuilderFromTrustedRequest(t *testing.T) {
+	u, err := url.Parse("http://registry.internal:5000/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var trustedProxies []net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "fd00::/8"} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trustedProxies = append(trustedProxies, *network)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		base       string
+	}{
+		{
+			name:       "untrusted peer",
+			remoteAddr: "203.0.113.7:4711",
+			header: http.Header{
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Host":  []string{"evil.example.com"},
+				"Forwarded":         []string{"proto=https;host=evil.example.com"},
+			},
+			base: "http://registry.internal:5000",
+		},
+		{
+			name:       "trusted peer without headers",
+			remoteAddr: "10.0.0.1:4711",
+			base:       "http://registry.internal:5000",
+		},
+		{
+			name:       "trusted peer with legacy headers",
+			remoteAddr: "10.0.0.1:4711",
+			header: http.Header{
+				"X-Forwarded-For":   []string{"198.51.100.1"},
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Host":  []string{"registry.example.com"},
+			},
+			base: "https://registry.example.com",
+		},
+		{
+			name:       "legacy headers appended by each hop",
+			remoteAddr: "10.0.0.1:4711",
+			header: http.Header{
+				"X-Forwarded-For":   []string{"198.51.100.1, 10.0.0.2"},
+				"X-Forwarded-Proto": []string{"https, http"},
+				"X-Forwarded-Host":  []string{"registry.example.com, lb.internal"},
+			},
+			base: "https://registry.example.com",
+		},
+		{
+			name:       "legacy headers spoofed by the client",
+			remoteAddr: "10.0.0.1:4711",
+			header: http.Header{
+				"X-Forwarded-For":   []string{"10.9.9.9, 198.51.100.1, 10.0.0.2"},
+				"X-Forwarded-Proto": []string{"http, https, http"},
+				"X-Forwarded-Host":  []string{"evil.example.com", "registry.example.com, lb.internal"},
+			},
+			base: "https://registry.example.com",
+		},
+		{
+			name:       "legacy headers set by the last proxy",
+			remoteAddr: "10.0.0.1:4711",
+			header: http.Header{
+				"X-Forwarded-For":   []string{"198.51.100.1, 10.0.0.2"},
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Host":  []string{"evil.example.com, registry.example.com, lb.internal"},
+			},
+			base: "https://lb.internal",
+		},
+		{
+			name:       "forwarded header takes precedence",
+			remoteAddr: "10.0.0.1:4711",
+			header: http.Header{
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Host":  []string{"legacy.example.com"},
+				"Forwarded":         []string{`for=198.51.100.1;proto=https;host=registry.example.com`},
+			},
+			base: "https://registry.example.com",
+		},
+		{
+			name:       "forwarded chain through trusted proxies",
+			remoteAddr: "[fd00::1]:4711",
+			header: http.Header{
+				"Forwarded": []string{
+					`for=198.51.100.1;proto=https;host=registry.example.com, for="[fd00::2]:80";proto=http;host=lb.internal`,
+					`for=10.0.0.3;proto=http;host=lb2.internal`,
+				},
+			},
+			base: "https://registry.example.com",
+		},
+		{
+			name:       "forwarded chain spoofed by the client",
+			remoteAddr: "10.0.0.1:4711",
+			header: http.Header{
+				"Forwarded": []string{`for=10.9.9.9;host=evil.example.com, for=198.51.100.1;proto=https;host=registry.example.com, for=10.0.0.2;host=lb.internal`},
+			},
+			base: "https://registry.example.com",
+		},
+		{
+			name:       "forwarded element without host",
+			remoteAddr: "10.0.0.1:4711",
+			header: http.Header{
+				"Forwarded": []string{`for=unknown;proto=https, for=10.0.0.2;proto=http;host=registry.example.com`},
+			},
+			base: "https://registry.example.com",
+		},
+		{
+			name:       "invalid forwarded header",
+			remoteAddr: "10.0.0.1:4711",
+			header: http.Header{
+				"Forwarded":         []string{`for=198.51.100.1;host="registry.example.com`},
+				"X-Forwarded-Proto": []string{"https"},
+			},
+			base: "http://registry.internal:5000",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &http.Request{URL: u, Host: u.Host, RemoteAddr: tc.remoteAddr, Header: tc.header}
+			if r.Header == nil {
+				r.Header = http.Header{}
+			}
+			builder := NewURLBuilderFromTrustedRequest(r, false, trustedProxies)
+			baseURL, err := builder.BuildBaseURL()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if baseURL != tc.base+"/v2/" {
+				t.Errorf("%q != %q", baseURL, tc.base+"/v2/")
+			}
+		})
+	}
+}
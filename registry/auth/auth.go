@@ -79,6 +79,10 @@ type Access struct {
 type Grant struct {
 	User      UserInfo   // The authenticated user for the request.
 	Resources []Resource // The list of resources which have been authorized for the request.
+
+	// RepositoryFilter, if not nil, limits the repositories visible to the
+	// request, such as those listed in the catalog.
+	RepositoryFilter func(name string) bool
 }
 
 // Challenge is a special error type which is used for HTTP 401 Unauthorized
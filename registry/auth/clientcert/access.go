@@ -0,0 +1,237 @@
+// Package clientcert provides an authentication scheme that identifies
+// clients by the certificate they presented to the TLS layer. The
+// certificate must have been verified against http.tls.clientcas: requests
+// without a verified certificate, including all requests when the registry
+// does not terminate TLS itself, are denied.
+//
+// The user name is taken from a configurable field of the certificate: its
+// subject common name, its first URI subject alternative name, or a subject
+// attribute identified by its OID. Any key type verified by the TLS layer,
+// such as RSA, ECDSA or ed25519, is supported.
+package clientcert
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNoCertificate is returned when the request carries no verified
+	// client certificate.
+	ErrNoCertificate = errors.New("no verified client certificate")
+
+	// ErrNoIdentity is returned when the client certificate has no value in
+	// the field identifying its user.
+	ErrNoIdentity = errors.New("client certificate does not identify a user")
+
+	// ErrAccessDenied is returned when the identity of the client
+	// certificate is not allowed the requested access.
+	ErrAccessDenied = errors.New("access denied to client certificate identity")
+)
+
+// init registers the clientcert auth backend.
+func init() {
+	if err := auth.Register("clientcert", auth.InitFunc(newAccessController)); err != nil {
+		logrus.Errorf("failed to register clientcert auth: %v", err)
+	}
+}
+
+// identityFunc returns the user name a certificate identifies, or "" if it
+// does not have one.
+type identityFunc func(cert *x509.Certificate) string
+
+type accessController struct {
+	identity identityFunc
+
+	// identities, if not nil, maps the identities allowed to use the
+	// registry to the repository prefixes they may access.
+	identities map[string][]string
+}
+
+var _ auth.AccessController = &accessController{}
+
+func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
+	identity, err := parseIdentityField(options["username"])
+	if err != nil {
+		return nil, err
+	}
+	identities, err := parseIdentities(options["identities"])
+	if err != nil {
+		return nil, err
+	}
+	return &accessController{identity: identity, identities: identities}, nil
+}
+
+// parseIdentityField returns the identityFunc of the "username" option:
+// "cn", the default, "uri" or "oid:" followed by a dotted OID.
+func parseIdentityField(opt interface{}) (identityFunc, error) {
+	field, ok := opt.(string)
+	if opt != nil && !ok {
+		return nil, fmt.Errorf(`"username" must be a string, got %v`, opt)
+	}
+	switch {
+	case field == "" || field == "cn":
+		return func(cert *x509.Certificate) string {
+			return cert.Subject.CommonName
+		}, nil
+	case field == "uri":
+		return func(cert *x509.Certificate) string {
+			if len(cert.URIs) == 0 {
+				return ""
+			}
+			return cert.URIs[0].String()
+		}, nil
+	case strings.HasPrefix(field, "oid:"):
+		oid, err := parseOID(strings.TrimPrefix(field, "oid:"))
+		if err != nil {
+			return nil, fmt.Errorf(`invalid "username" %q: %v`, field, err)
+		}
+		return func(cert *x509.Certificate) string {
+			for _, name := range cert.Subject.Names {
+				if name.Type.Equal(oid) {
+					value, _ := name.Value.(string)
+					return value
+				}
+			}
+			return ""
+		}, nil
+	default:
+		return nil, fmt.Errorf(`"username" must be "cn", "uri" or "oid:<oid>", got %q`, field)
+	}
+}
+
+// parseOID parses an OID in dotted form, such as 2.5.4.45.
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("OID must have at least two components")
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID component %q", part)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+// parseIdentities reads the "identities" option, mapping identities to
+// lists of repository prefixes. Prefixes may be written with a trailing
+// "/" or "/*".
+func parseIdentities(opt interface{}) (map[string][]string, error) {
+	var entries map[string]interface{}
+	switch opt := opt.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		entries = opt
+	case map[interface{}]interface{}:
+		entries = make(map[string]interface{}, len(opt))
+		for k, v := range opt {
+			name, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf(`"identities" keys must be strings, got %v`, k)
+			}
+			entries[name] = v
+		}
+	default:
+		return nil, fmt.Errorf(`"identities" must map identities to lists of repository prefixes, got %v`, opt)
+	}
+
+	identities := make(map[string][]string, len(entries))
+	for name, v := range entries {
+		var values []string
+		switch v := v.(type) {
+		case []string:
+			values = v
+		case []interface{}:
+			for _, value := range v {
+				s, ok := value.(string)
+				if !ok {
+					return nil, fmt.Errorf(`"identities" of %q must be a list of strings, got %v`, name, value)
+				}
+				values = append(values, s)
+			}
+		default:
+			return nil, fmt.Errorf(`"identities" of %q must be a list of strings, got %v`, name, v)
+		}
+
+		prefixes := make([]string, 0, len(values))
+		for _, value := range values {
+			prefix := strings.TrimSuffix(strings.TrimSuffix(value, "*"), "/")
+			if prefix == "" {
+				return nil, fmt.Errorf("invalid repository prefix %q of %q: prefix must name a namespace", value, name)
+			}
+			prefixes = append(prefixes, prefix)
+		}
+		sort.Strings(prefixes)
+		identities[name] = prefixes
+	}
+	return identities, nil
+}
+
+// Authorized identifies the client by its verified certificate, and checks
+// that the repositories it accesses fall under its prefixes, if identities
+// are configured.
+func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.Access) (*auth.Grant, error) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil, &challenge{err: ErrNoCertificate}
+	}
+	name := ac.identity(req.TLS.VerifiedChains[0][0])
+	if name == "" {
+		return nil, &challenge{err: ErrNoIdentity}
+	}
+
+	grant := &auth.Grant{User: auth.UserInfo{Name: name}}
+	if ac.identities == nil {
+		return grant, nil
+	}
+
+	prefixes, ok := ac.identities[name]
+	if !ok {
+		return nil, &challenge{err: fmt.Errorf("%w: %s", ErrAccessDenied, name)}
+	}
+	matches := func(repo string) bool {
+		for _, prefix := range prefixes {
+			if repo == prefix || strings.HasPrefix(repo, prefix+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	for _, access := range accessRecords {
+		if access.Type == "repository" && !matches(access.Name) {
+			return nil, &challenge{err: fmt.Errorf("%w: %s may not access repository %s", ErrAccessDenied, name, access.Name)}
+		}
+	}
+	// The catalog lists the repositories the identity may access.
+	grant.RepositoryFilter = matches
+	return grant, nil
+}
+
+// challenge implements the auth.Challenge interface. No HTTP authentication
+// scheme applies to client certificates, so it sets no header.
+type challenge struct {
+	err error
+}
+
+var _ auth.Challenge = challenge{}
+
+// SetHeaders does nothing: the client can only authenticate again with
+// another certificate.
+func (ch challenge) SetHeaders(r *http.Request, w http.ResponseWriter) {}
+
+func (ch challenge) Error() string {
+	return fmt.Sprintf("client certificate authentication failed: %s", ch.err)
+}
@@ -0,0 +1,215 @@
+package clientcert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// testCA issues client certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns a client certificate for subject, signed by the CA, with a
+// key of the type of key.
+func (ca *testCA) issue(t *testing.T, key crypto.Signer, subject pkix.Name, uris ...string) tls.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.URIs = append(template.URIs, u)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// authorize serves a request authorized by ac over TLS, verifying client
+// certificates against ca, and returns the result of the authorization
+// made with the certificate cert, if any.
+func authorize(t *testing.T, ac auth.AccessController, ca *testCA, cert *tls.Certificate, access ...auth.Access) (*auth.Grant, error) {
+	t.Helper()
+	var (
+		grant *auth.Grant
+		err   error
+	)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		grant, err = ac.Authorized(r, access...)
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool}
+	server.StartTLS()
+	defer server.Close()
+
+	client := server.Client()
+	if cert != nil {
+		client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{*cert}
+	}
+	resp, reqErr := client.Get(server.URL)
+	if reqErr != nil {
+		t.Fatal(reqErr)
+	}
+	resp.Body.Close()
+	return grant, err
+}
+
+func TestClientCertIdentity(t *testing.T) {
+	ca := newTestCA(t)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	teamOID := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+	subject := pkix.Name{
+		CommonName: "ci-runner",
+		ExtraNames: []pkix.AttributeTypeAndValue{{Type: teamOID, Value: "team-a"}},
+	}
+	for _, tc := range []struct {
+		username, expected string
+	}{
+		{"", "ci-runner"},
+		{"cn", "ci-runner"},
+		{"uri", "spiffe://example.com/ci"},
+		{"oid:1.3.6.1.4.1.99999.1", "team-a"},
+	} {
+		ac, err := newAccessController(map[string]interface{}{"username": tc.username})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for keyType, key := range map[string]crypto.Signer{"ecdsa": ecdsaKey, "ed25519": ed25519Key} {
+			cert := ca.issue(t, key, subject, "spiffe://example.com/ci")
+			grant, err := authorize(t, ac, ca, &cert)
+			if err != nil {
+				t.Fatalf("%s certificate, username %q: unexpected error: %v", keyType, tc.username, err)
+			}
+			if grant.User.Name != tc.expected {
+				t.Errorf("%s certificate, username %q: expected user %q, got %q", keyType, tc.username, tc.expected, grant.User.Name)
+			}
+		}
+	}
+
+	// Requests without a certificate, or with one not identifying a user,
+	// are denied.
+	ac, err := newAccessController(map[string]interface{}{"username": "uri"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authorize(t, ac, ca, nil); !errors.Is(err.(*challenge).err, ErrNoCertificate) {
+		t.Fatalf("expected %v, got %v", ErrNoCertificate, err)
+	}
+	cert := ca.issue(t, ecdsaKey, subject)
+	if _, err := authorize(t, ac, ca, &cert); !errors.Is(err.(*challenge).err, ErrNoIdentity) {
+		t.Fatalf("expected %v, got %v", ErrNoIdentity, err)
+	}
+
+	for _, username := range []interface{}{"dns", "oid:1", "oid:1.x", 1} {
+		if _, err := newAccessController(map[string]interface{}{"username": username}); err == nil {
+			t.Errorf("expected an error for username %v", username)
+		}
+	}
+}
+
+func TestClientCertIdentities(t *testing.T) {
+	ca := newTestCA(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := newAccessController(map[string]interface{}{
+		"identities": map[interface{}]interface{}{
+			"ci-runner": []interface{}{"team-a/*", "shared"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pull := func(name string) auth.Access {
+		return auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: "pull"}
+	}
+
+	runner := ca.issue(t, key, pkix.Name{CommonName: "ci-runner"})
+	grant, err := authorize(t, ac, ca, &runner, pull("team-a/app"), pull("shared"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, expected := range map[string]bool{"team-a/app": true, "shared/base": true, "team-b/app": false, "team-ab": false} {
+		if grant.RepositoryFilter(name) != expected {
+			t.Errorf("expected %s to be visible: %t", name, expected)
+		}
+	}
+	if _, err := authorize(t, ac, ca, &runner, pull("team-a/app"), pull("team-b/app")); !errors.Is(err.(*challenge).err, ErrAccessDenied) {
+		t.Fatalf("expected %v, got %v", ErrAccessDenied, err)
+	}
+
+	stranger := ca.issue(t, key, pkix.Name{CommonName: "stranger"})
+	if _, err := authorize(t, ac, ca, &stranger); !errors.Is(err.(*challenge).err, ErrAccessDenied) {
+		t.Fatalf("expected %v, got %v", ErrAccessDenied, err)
+	}
+
+	if _, err := newAccessController(map[string]interface{}{
+		"identities": map[interface{}]interface{}{"ci-runner": []interface{}{"*"}},
+	}); err == nil {
+		t.Fatal("expected an error for an empty prefix")
+	}
+}
@@ -35,6 +35,10 @@ type accessController struct {
 	modtime  time.Time
 	mu       sync.Mutex
 	htpasswd *htpasswd
+
+	// public lists the repositories which may be pulled without
+	// credentials.
+	public auth.PublicPrefixes
 }
 
 var _ auth.AccessController = &accessController{}
@@ -50,15 +54,22 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 	if !present || !ok {
 		return nil, fmt.Errorf(`"path" must be set for htpasswd access controller`)
 	}
+	public, err := auth.PublicPrefixesFromOptions(options)
+	if err != nil {
+		return nil, err
+	}
 	if err := createHtpasswdFile(path); err != nil {
 		return nil, err
 	}
-	return &accessController{realm: realm.(string), path: path}, nil
+	return &accessController{realm: realm.(string), path: path, public: public}, nil
 }
 
 func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.Access) (*auth.Grant, error) {
 	username, password, ok := req.BasicAuth()
 	if !ok {
+		if grant := ac.public.AnonymousGrant(accessRecords...); grant != nil {
+			return grant, nil
+		}
 		return nil, &challenge{
 			realm: ac.realm,
 			err:   auth.ErrInvalidCredential,
@@ -66,42 +77,71 @@ func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.
 	}
 
 	// Dynamically parsing the latest account list
+	localHTPasswd, err := ac.reload(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := localHTPasswd.authenticateUser(username, password); err != nil {
+		dcontext.GetLogger(req.Context()).Errorf("error authenticating user %q: %v", username, err)
+		return nil, &challenge{
+			realm: ac.realm,
+			err:   auth.ErrAuthenticationFailure,
+		}
+	}
+
+	return &auth.Grant{User: auth.UserInfo{Name: username}}, nil
+}
+
+// reload returns the entries of the htpasswd file, parsing it again if it
+// changed since it was last read. The entries are replaced atomically. If
+// the changed file cannot be parsed, or would leave no users, the error is
+// logged and the previous entries are kept.
+func (ac *accessController) reload(ctx context.Context) (*htpasswd, error) {
 	fstat, err := os.Stat(ac.path)
 	if err != nil {
 		return nil, err
 	}
 
-	lastModified := fstat.ModTime()
 	ac.mu.Lock()
-	if ac.htpasswd == nil || !ac.modtime.Equal(lastModified) {
-		ac.modtime = lastModified
+	defer ac.mu.Unlock()
 
-		f, err := os.Open(ac.path)
-		if err != nil {
-			ac.mu.Unlock()
-			return nil, err
-		}
-		defer f.Close()
+	lastModified := fstat.ModTime()
+	if ac.htpasswd != nil && ac.modtime.Equal(lastModified) {
+		return ac.htpasswd, nil
+	}
 
-		h, err := newHTPasswd(f)
-		if err != nil {
-			ac.mu.Unlock()
+	h, err := ac.load()
+	if err == nil && ac.htpasswd != nil && len(h.entries) == 0 {
+		err = fmt.Errorf("htpasswd: no valid users in %s", ac.path)
+	}
+	if err != nil {
+		if ac.htpasswd == nil {
 			return nil, err
 		}
+		dcontext.GetLogger(ctx).Errorf("error reloading htpasswd file %s, keeping previous entries: %v", ac.path, err)
+	} else {
+		if err := h.invalidEntriesError(); err != nil {
+			dcontext.GetLogger(ctx).Error(err)
+		}
 		ac.htpasswd = h
 	}
-	localHTPasswd := ac.htpasswd
-	ac.mu.Unlock()
 
-	if err := localHTPasswd.authenticateUser(username, password); err != nil {
-		dcontext.GetLogger(req.Context()).Errorf("error authenticating user %q: %v", username, err)
-		return nil, &challenge{
-			realm: ac.realm,
-			err:   auth.ErrAuthenticationFailure,
-		}
+	// Remember the modification time even if the reload failed, so that a
+	// broken file is not parsed again on every request.
+	ac.modtime = lastModified
+	return ac.htpasswd, nil
+}
+
+// load parses the htpasswd file.
+func (ac *accessController) load() (*htpasswd, error) {
+	f, err := os.Open(ac.path)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
 
-	return &auth.Grant{User: auth.UserInfo{Name: username}}, nil
+	return newHTPasswd(f)
 }
 
 // challenge implements the auth.Challenge interface.
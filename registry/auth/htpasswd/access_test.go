@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3/registry/auth"
 )
@@ -154,3 +155,101 @@ func TestCreateHtpasswdFile(t *testing.T) {
 		t.Fatalf("failed to find default user in file %s", string(content))
 	}
 }
+
+func TestAccessControllerReload(t *testing.T) {
+	path := t.TempDir() + "/htpasswd"
+	write := func(content string, mtime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// Modification times may be coarse, set them explicitly.
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	write("frodo:$2y$05$926C3y10Quzn/LnqQH86VOEVh/18T6RnLaS.khre96jLNL/7e.K5W\n", now)
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm": "The-Shire",
+		"path":  path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller := ac.(*accessController)
+
+	authorized := func(user, password string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(user, password)
+		_, err := controller.Authorized(req)
+		return err == nil
+	}
+
+	if !authorized("frodo", "baggins") {
+		t.Fatal("expected frodo to be authorized")
+	}
+
+	// A changed file is picked up without a restart.
+	write("MiShil:$2y$05$0oHgwMehvoe8iAWS8I.7l.KoECXrwVaC16RPfaSCU5eVTFrATuMI2\n", now.Add(time.Minute))
+	if authorized("frodo", "baggins") {
+		t.Fatal("expected frodo to be rejected after reload")
+	}
+	if !authorized("MiShil", "새주") {
+		t.Fatal("expected MiShil to be authorized after reload")
+	}
+
+	// A reload that leaves no valid users keeps the previous entries.
+	write("# truncated\n", now.Add(2*time.Minute))
+	if !authorized("MiShil", "새주") {
+		t.Fatal("expected previous entries to be kept after an empty reload")
+	}
+
+	// As does a file that cannot be parsed.
+	write("garbage\n", now.Add(3*time.Minute))
+	if !authorized("MiShil", "새주") {
+		t.Fatal("expected previous entries to be kept after a failed reload")
+	}
+}
+
+func TestAccessControllerPublicPrefixes(t *testing.T) {
+	path := t.TempDir() + "/htpasswd"
+	if err := os.WriteFile(path, []byte("frodo:$2y$05$926C3y10Quzn/LnqQH86VOEVh/18T6RnLaS.khre96jLNL/7e.K5W\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":          "The-Shire",
+		"path":           path,
+		"publicprefixes": []interface{}{"library"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository := func(name, action string) auth.Access {
+		return auth.Access{
+			Resource: auth.Resource{Type: "repository", Name: name},
+			Action:   action,
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err := ac.Authorized(req, repository("library/ubuntu", "pull")); err != nil {
+		t.Fatalf("expected anonymous pull of a public repository: %v", err)
+	}
+	for _, access := range []auth.Access{repository("library/ubuntu", "push"), repository("library2/ubuntu", "pull")} {
+		if _, err := ac.Authorized(req, access); err == nil {
+			t.Fatalf("expected %v to require credentials", access)
+		} else if _, ok := err.(auth.Challenge); !ok {
+			t.Fatalf("expected a challenge, got %v", err)
+		}
+	}
+
+	// Wrong credentials are rejected even for public repositories.
+	req.SetBasicAuth("frodo", "wrong")
+	if _, err := ac.Authorized(req, repository("library/ubuntu", "pull")); err == nil {
+		t.Fatal("expected invalid credentials to be rejected")
+	}
+}
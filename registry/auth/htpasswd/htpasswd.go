@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 
 	"github.com/distribution/distribution/v3/registry/auth"
@@ -15,6 +16,10 @@ import (
 // it. Only bcrypt hash entries are supported.
 type htpasswd struct {
 	entries map[string][]byte // maps username to password byte slice.
+
+	// invalid lists, sorted, the users whose entries are not bcrypt hashes
+	// with a valid cost. They are left out of entries.
+	invalid []string
 }
 
 // newHTPasswd parses the reader and returns an htpasswd or an error.
@@ -24,7 +29,25 @@ func newHTPasswd(rd io.Reader) (*htpasswd, error) {
 		return nil, err
 	}
 
-	return &htpasswd{entries: entries}, nil
+	var invalid []string
+	for user, hash := range entries {
+		if _, err := bcrypt.Cost(hash); err != nil {
+			invalid = append(invalid, user)
+			delete(entries, user)
+		}
+	}
+	sort.Strings(invalid)
+
+	return &htpasswd{entries: entries, invalid: invalid}, nil
+}
+
+// invalidEntriesError returns an error naming the users whose entries are
+// not bcrypt hashes, or nil if there are none.
+func (htpasswd *htpasswd) invalidEntriesError() error {
+	if len(htpasswd.invalid) == 0 {
+		return nil
+	}
+	return fmt.Errorf("htpasswd: entries for users %s are not bcrypt hashes with a valid cost and are ignored; only bcrypt is supported", strings.Join(htpasswd.invalid, ", "))
 }
 
 // AuthenticateUser checks a given user:password credential against the
@@ -81,3 +81,22 @@ asdf
 		}
 	}
 }
+
+func TestNewHTPasswdRejectsNonBcrypt(t *testing.T) {
+	h, err := newHTPasswd(strings.NewReader(`bilbo:{SHA}5siv5c0SHx681xU6GiSx9ZQryqs=
+frodo:$2y$05$926C3y10Quzn/LnqQH86VOEVh/18T6RnLaS.khre96jLNL/7e.K5W
+DeokMan:공주님
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := h.entries["frodo"]; !ok || len(h.entries) != 1 {
+		t.Fatalf("expected only the bcrypt entry to be kept, got %v", h.entries)
+	}
+
+	expected := "htpasswd: entries for users DeokMan, bilbo are not bcrypt hashes with a valid cost and are ignored; only bcrypt is supported"
+	if err := h.invalidEntriesError(); err == nil || err.Error() != expected {
+		t.Fatalf("unexpected invalid entries error: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// PolicyInitFunc is the type of an AccessPolicy factory function and is used
+// to register the constructor for different AccessPolicy backends.
+type PolicyInitFunc func(options map[string]interface{}) (AccessPolicy, error)
+
+var accessPolicies = make(map[string]PolicyInitFunc)
+
+// AccessPolicy is consulted for every request after it has been
+// authenticated by the AccessController, and may deny access on grounds that
+// the credentials alone cannot express, such as namespace ownership.
+type AccessPolicy interface {
+	// Authorized returns nil if the requested access is allowed by the
+	// policy. A request denied by the policy should return a
+	// *PolicyDeniedError; any other error is treated as a failure to
+	// evaluate the policy.
+	Authorized(ctx context.Context, access []Access, r *http.Request) error
+}
+
+// PolicyDeniedError is returned by an AccessPolicy which denies a request.
+type PolicyDeniedError struct {
+	// Message explains the denial to the client.
+	Message string
+}
+
+// Error returns the denial message.
+func (e *PolicyDeniedError) Error() string {
+	if e.Message == "" {
+		return "denied by access policy"
+	}
+	return e.Message
+}
+
+// RegisterPolicy is used to register a PolicyInitFunc for an AccessPolicy
+// backend with the given name.
+func RegisterPolicy(name string, initFunc PolicyInitFunc) error {
+	if _, exists := accessPolicies[name]; exists {
+		return fmt.Errorf("name already registered: %s", name)
+	}
+
+	accessPolicies[name] = initFunc
+
+	return nil
+}
+
+// GetAccessPolicy constructs an AccessPolicy with the given options using the
+// named backend.
+func GetAccessPolicy(name string, options map[string]interface{}) (AccessPolicy, error) {
+	if initFunc, exists := accessPolicies[name]; exists {
+		return initFunc(options)
+	}
+
+	return nil, fmt.Errorf("no access policy registered with name: %s", name)
+}
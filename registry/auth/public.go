@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PublicPrefixes lists repository name prefixes whose repositories may be
+// pulled without credentials. A prefix matches the repository of the same
+// name and the repositories below it: "library" matches "library" and
+// "library/ubuntu", but not "library2".
+type PublicPrefixes []string
+
+// PublicPrefixesFromOptions reads the "publicprefixes" option of an access
+// controller. Prefixes may be written with a trailing "/" or "/*".
+func PublicPrefixesFromOptions(options map[string]interface{}) (PublicPrefixes, error) {
+	var values []string
+	switch opt := options["publicprefixes"].(type) {
+	case nil:
+		return nil, nil
+	case []string:
+		values = opt
+	case []interface{}:
+		for _, v := range opt {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf(`"publicprefixes" must be a list of strings, got %v`, v)
+			}
+			values = append(values, s)
+		}
+	default:
+		return nil, fmt.Errorf(`"publicprefixes" must be a list of strings, got %v`, opt)
+	}
+
+	prefixes := make(PublicPrefixes, 0, len(values))
+	for _, v := range values {
+		prefix := strings.TrimSuffix(strings.TrimSuffix(v, "*"), "/")
+		if prefix == "" {
+			return nil, fmt.Errorf("invalid public prefix %q: prefix must name a namespace", v)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// Matches reports whether the repository name falls under a public prefix.
+func (p PublicPrefixes) Matches(name string) bool {
+	for _, prefix := range p {
+		if name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// AnonymousGrant returns a grant for a request without credentials if all
+// of the requested access is permitted anonymously: pulling public
+// repositories, or listing the catalog, which is then restricted to public
+// repositories. It returns nil otherwise. Requests for no access at all,
+// such as the base API endpoint, are not granted so that clients still
+// discover the authentication challenge.
+func (p PublicPrefixes) AnonymousGrant(access ...Access) *Grant {
+	if len(p) == 0 || len(access) == 0 {
+		return nil
+	}
+
+	for _, a := range access {
+		switch {
+		case a.Type == "repository" && a.Action == "pull" && p.Matches(a.Name):
+		case a.Type == "registry" && a.Name == "catalog":
+		default:
+			return nil
+		}
+	}
+
+	return &Grant{RepositoryFilter: p.Matches}
+}
@@ -0,0 +1,86 @@
+package auth
+
+import "testing"
+
+func TestPublicPrefixesMatches(t *testing.T) {
+	prefixes, err := PublicPrefixesFromOptions(map[string]interface{}{
+		"publicprefixes": []interface{}{"library/*", "team/public/", "tools"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		match bool
+	}{
+		{"library", true},
+		{"library/ubuntu", true},
+		{"library/nested/ubuntu", true},
+		{"library2", false},
+		{"library2/ubuntu", false},
+		{"librar", false},
+		{"mirror/library/ubuntu", false},
+		{"team/public/app", true},
+		{"team/publicity", false},
+		{"team/app", false},
+		{"tools", true},
+		{"tools/make", true},
+		{"toolshed/make", false},
+	} {
+		if got := prefixes.Matches(tc.name); got != tc.match {
+			t.Errorf("Matches(%q) = %v, want %v", tc.name, got, tc.match)
+		}
+	}
+}
+
+func TestPublicPrefixesFromOptions(t *testing.T) {
+	prefixes, err := PublicPrefixesFromOptions(map[string]interface{}{})
+	if err != nil || prefixes != nil {
+		t.Fatalf("expected no prefixes, got %v, %v", prefixes, err)
+	}
+
+	for _, opt := range []interface{}{
+		"library",
+		[]interface{}{"library", 1},
+		[]interface{}{"*"},
+		[]interface{}{"/"},
+	} {
+		if _, err := PublicPrefixesFromOptions(map[string]interface{}{"publicprefixes": opt}); err == nil {
+			t.Errorf("expected an error for %v", opt)
+		}
+	}
+}
+
+func TestPublicPrefixesAnonymousGrant(t *testing.T) {
+	prefixes := PublicPrefixes{"library"}
+	access := func(typ, name, action string) Access {
+		return Access{Resource: Resource{Type: typ, Name: name}, Action: action}
+	}
+
+	for _, tc := range []struct {
+		desc    string
+		access  []Access
+		granted bool
+	}{
+		{"public pull", []Access{access("repository", "library/ubuntu", "pull")}, true},
+		{"public push", []Access{access("repository", "library/ubuntu", "pull"), access("repository", "library/ubuntu", "push")}, false},
+		{"private pull", []Access{access("repository", "library2/ubuntu", "pull")}, false},
+		{"mount from private", []Access{access("repository", "library/ubuntu", "pull"), access("repository", "private/app", "pull")}, false},
+		{"delete", []Access{access("repository", "library/ubuntu", "delete")}, false},
+		{"catalog", []Access{access("registry", "catalog", "*")}, true},
+		{"base", nil, false},
+	} {
+		grant := prefixes.AnonymousGrant(tc.access...)
+		if (grant != nil) != tc.granted {
+			t.Errorf("%s: granted = %v, want %v", tc.desc, grant != nil, tc.granted)
+		}
+		if grant != nil && (grant.RepositoryFilter == nil || grant.RepositoryFilter("private/app")) {
+			t.Errorf("%s: expected the grant to filter private repositories", tc.desc)
+		}
+	}
+
+	if grant := PublicPrefixes(nil).AnonymousGrant(access("registry", "catalog", "*")); grant != nil {
+		t.Error("expected no anonymous access without public prefixes")
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// RepositoryScopeMatcher reports whether the name of a repository resource
+// granted to a request, which may be a pattern, grants access to the
+// repository name.
+type RepositoryScopeMatcher func(pattern, name string) bool
+
+var (
+	scopeMatchersMu sync.RWMutex
+	scopeMatchers   = map[string]RepositoryScopeMatcher{
+		"exact": MatchRepositoryScopeExact,
+		"glob":  MatchRepositoryScope,
+	}
+)
+
+// RegisterRepositoryScopeMatcher registers a RepositoryScopeMatcher under
+// name, for the access controllers to look up with GetRepositoryScopeMatcher.
+// The "exact" and "glob" matchers are always registered.
+func RegisterRepositoryScopeMatcher(name string, matcher RepositoryScopeMatcher) error {
+	scopeMatchersMu.Lock()
+	defer scopeMatchersMu.Unlock()
+	if _, exists := scopeMatchers[name]; exists {
+		return fmt.Errorf("repository scope matcher already registered: %s", name)
+	}
+	scopeMatchers[name] = matcher
+	return nil
+}
+
+// GetRepositoryScopeMatcher returns the RepositoryScopeMatcher registered
+// under name.
+func GetRepositoryScopeMatcher(name string) (RepositoryScopeMatcher, error) {
+	scopeMatchersMu.RLock()
+	defer scopeMatchersMu.RUnlock()
+	matcher, ok := scopeMatchers[name]
+	if !ok {
+		return nil, fmt.Errorf("no repository scope matcher registered with name: %s", name)
+	}
+	return matcher, nil
+}
+
+// MatchRepositoryScopeExact matches the repository named pattern only.
+func MatchRepositoryScopeExact(pattern, name string) bool {
+	return pattern == name
+}
+
+// MatchRepositoryScope matches the repositories matching pattern as a glob
+// (https://pkg.go.dev/path#Match), or whose parent namespace matches it:
+// "team-a/*" matches "team-a/app" and "team-a/app/web". A pattern without
+// wildcards only matches the repository it names.
+func MatchRepositoryScope(pattern, name string) bool {
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		return pattern == name
+	}
+	for {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			return false
+		}
+		name = name[:i]
+	}
+}
+
+// RepositoryPullFilter returns a repository filter limiting the repositories
+// to those access grants pull on, matched by match.
+func RepositoryPullFilter(access []Access, match RepositoryScopeMatcher) func(name string) bool {
+	var patterns []string
+	for _, a := range access {
+		if a.Type == "repository" && (a.Action == "pull" || a.Action == "*") {
+			patterns = append(patterns, a.Name)
+		}
+	}
+	return func(name string) bool {
+		for _, pattern := range patterns {
+			if match(pattern, name) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,24 @@
+package auth
+
+import "testing"
+
+func TestMatchRepositoryScope(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		expected      bool
+	}{
+		{"team-a/app", "team-a/app", true},
+		{"team-a/app", "team-a/app/web", false},
+		{"team-a/app", "team-a/application", false},
+		{"team-a/*", "team-a/app", true},
+		{"team-a/*", "team-a/app/web", true},
+		{"team-a/*", "team-a", false},
+		{"team-a/*", "team-b/app", false},
+		{"*", "library/ubuntu", true},
+		{"team-?/app", "team-b/app", true},
+	} {
+		if got := MatchRepositoryScope(tc.pattern, tc.name); got != tc.expected {
+			t.Errorf("MatchRepositoryScope(%q, %q) = %t, expected %t", tc.pattern, tc.name, got, tc.expected)
+		}
+	}
+}
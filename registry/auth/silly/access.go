@@ -29,6 +29,7 @@ func init() {
 type accessController struct {
 	realm   string
 	service string
+	public  auth.PublicPrefixes
 }
 
 var _ auth.AccessController = &accessController{}
@@ -44,13 +45,23 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		return nil, fmt.Errorf(`"service" must be set for silly access controller`)
 	}
 
-	return &accessController{realm: realm.(string), service: service.(string)}, nil
+	public, err := auth.PublicPrefixesFromOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &accessController{realm: realm.(string), service: service.(string), public: public}, nil
 }
 
 // Authorized simply checks for the existence of the authorization header,
-// responding with a bearer challenge if it doesn't exist.
+// responding with a bearer challenge if it doesn't exist. Pulls of public
+// repositories are granted without the header.
 func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.Access) (*auth.Grant, error) {
 	if req.Header.Get("Authorization") == "" {
+		if grant := ac.public.AnonymousGrant(accessRecords...); grant != nil {
+			return grant, nil
+		}
+
 		challenge := challenge{
 			realm:   ac.realm,
 			service: ac.service,
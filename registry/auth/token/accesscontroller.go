@@ -1,6 +1,7 @@
 package token
 
 import (
+	"context"
 	"crypto"
 	"crypto/x509"
 	"encoding/json"
@@ -12,6 +13,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/distribution/distribution/v3/registry/auth"
 	"github.com/go-jose/go-jose/v3"
@@ -158,12 +160,30 @@ type accessController struct {
 	service          string
 	rootCerts        *x509.CertPool
 	trustedKeys      map[string]crypto.PublicKey
+
+	// remoteKeys, if not nil, holds the trusted keys when they are fetched
+	// from a JWKS URL. It supersedes trustedKeys.
+	remoteKeys *remoteKeySet
+
+	// catalogFilter, if not nil, limits the catalog to the repositories the
+	// token grants pull access to, as matched by catalogFilter. The catalog
+	// then requires no registry:catalog:* scope.
+	catalogFilter auth.RepositoryScopeMatcher
+	// catalogAdmin lets the tokens holding the registry:catalog:* scope list
+	// the whole catalog when it is filtered.
+	catalogAdmin bool
 }
 
 const (
 	defaultAutoRedirectPath = "/auth/token"
 )
 
+// catalogAccess is the access requested to list the catalog.
+var catalogAccess = auth.Access{
+	Resource: auth.Resource{Type: "registry", Name: "catalog"},
+	Action:   "*",
+}
+
 // tokenAccessOptions is a convenience type for handling
 // options to the constructor of an accessController.
 type tokenAccessOptions struct {
@@ -172,8 +192,12 @@ type tokenAccessOptions struct {
 	autoRedirectPath string
 	issuer           string
 	service          string
-	rootCertBundle   string
+	rootCertBundles  []string
 	jwks             string
+	jwksCache        string
+	jwksRefresh      time.Duration
+	catalogFilter    string
+	catalogAdmin     bool
 }
 
 // checkOptions gathers the necessary options
@@ -181,7 +205,7 @@ type tokenAccessOptions struct {
 func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 	var opts tokenAccessOptions
 
-	keys := []string{"realm", "issuer", "service", "rootcertbundle", "jwks"}
+	keys := []string{"realm", "issuer", "service", "jwks"}
 	vals := make([]string, 0, len(keys))
 	for _, key := range keys {
 		val, ok := options[key].(string)
@@ -191,7 +215,7 @@ func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 			// Either of these config options may be missing, but
 			// at least one must be present: we handle those cases
 			// in newAccessController func which consumes this one.
-			if key == "rootcertbundle" || key == "jwks" {
+			if key == "jwks" {
 				vals = append(vals, "")
 				continue
 			}
@@ -200,7 +224,51 @@ func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 		vals = append(vals, val)
 	}
 
-	opts.realm, opts.issuer, opts.service, opts.rootCertBundle, opts.jwks = vals[0], vals[1], vals[2], vals[3], vals[4]
+	opts.realm, opts.issuer, opts.service, opts.jwks = vals[0], vals[1], vals[2], vals[3]
+
+	// rootcertbundle may be a single path or a list of paths.
+	switch bundles := options["rootcertbundle"].(type) {
+	case nil:
+	case string:
+		if bundles != "" {
+			opts.rootCertBundles = []string{bundles}
+		}
+	case []string:
+		opts.rootCertBundles = bundles
+	case []interface{}:
+		for _, bundle := range bundles {
+			path, ok := bundle.(string)
+			if !ok {
+				return opts, fmt.Errorf("token auth requires a valid option string or list of strings: rootcertbundle")
+			}
+			opts.rootCertBundles = append(opts.rootCertBundles, path)
+		}
+	default:
+		return opts, fmt.Errorf("token auth requires a valid option string or list of strings: rootcertbundle")
+	}
+
+	if jwksCacheVal, ok := options["jwkscache"]; ok {
+		jwksCache, ok := jwksCacheVal.(string)
+		if !ok {
+			return opts, fmt.Errorf("token auth requires a valid option string: jwkscache")
+		}
+		opts.jwksCache = jwksCache
+	}
+
+	if refreshVal, ok := options["jwksrefreshinterval"]; ok {
+		switch refresh := refreshVal.(type) {
+		case time.Duration:
+			opts.jwksRefresh = refresh
+		case string:
+			d, err := time.ParseDuration(refresh)
+			if err != nil {
+				return opts, fmt.Errorf("token auth requires a valid option duration: jwksrefreshinterval: %v", err)
+			}
+			opts.jwksRefresh = d
+		default:
+			return opts, fmt.Errorf("token auth requires a valid option duration: jwksrefreshinterval")
+		}
+	}
 
 	autoRedirectVal, ok := options["autoredirect"]
 	if ok {
@@ -224,6 +292,22 @@ func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 		}
 	}
 
+	if catalogFilterVal, ok := options["catalogfilter"]; ok {
+		catalogFilter, ok := catalogFilterVal.(string)
+		if !ok {
+			return opts, fmt.Errorf("token auth requires a valid option string: catalogfilter")
+		}
+		opts.catalogFilter = catalogFilter
+	}
+
+	if catalogAdminVal, ok := options["catalogadmin"]; ok {
+		catalogAdmin, ok := catalogAdminVal.(bool)
+		if !ok {
+			return opts, fmt.Errorf("token auth requires a valid option bool: catalogadmin")
+		}
+		opts.catalogAdmin = catalogAdmin
+	}
+
 	return opts, nil
 }
 
@@ -291,22 +375,24 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		jwks      *jose.JSONWebKeySet
 	)
 
-	if config.rootCertBundle != "" {
-		rootCerts, err = getRootCerts(config.rootCertBundle)
+	for _, bundle := range config.rootCertBundles {
+		certs, err := getRootCerts(bundle)
 		if err != nil {
 			return nil, err
 		}
+		rootCerts = append(rootCerts, certs...)
 	}
 
-	if config.jwks != "" {
+	if config.jwks != "" && !isJWKSURL(config.jwks) {
 		jwks, err = getJwks(config.jwks)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	if (len(rootCerts) == 0 && jwks == nil) || // no certs bundle and no jwks
-		(len(rootCerts) == 0 && jwks != nil && len(jwks.Keys) == 0) { // no certs bundle and empty jwks
+	if !isJWKSURL(config.jwks) &&
+		((len(rootCerts) == 0 && jwks == nil) || // no certs bundle and no jwks
+			(len(rootCerts) == 0 && jwks != nil && len(jwks.Keys) == 0)) { // no certs bundle and empty jwks
 		return nil, errors.New("token auth requires at least one token signing key")
 	}
 
@@ -322,6 +408,25 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		}
 	}
 
+	var catalogFilter auth.RepositoryScopeMatcher
+	if config.catalogFilter != "" {
+		catalogFilter, err = auth.GetRepositoryScopeMatcher(config.catalogFilter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var remoteKeys *remoteKeySet
+	if isJWKSURL(config.jwks) {
+		remoteKeys, err = newRemoteKeySet(context.Background(), config.jwks, config.jwksCache, config.jwksRefresh, nil, trustedKeys)
+		if err != nil {
+			return nil, err
+		}
+		if len(rootCerts) == 0 && len(remoteKeys.keys) == 0 {
+			return nil, errors.New("token auth requires at least one token signing key")
+		}
+	}
+
 	return &accessController{
 		realm:            config.realm,
 		autoRedirect:     config.autoRedirect,
@@ -330,6 +435,9 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		service:          config.service,
 		rootCerts:        rootPool,
 		trustedKeys:      trustedKeys,
+		remoteKeys:       remoteKeys,
+		catalogFilter:    catalogFilter,
+		catalogAdmin:     config.catalogAdmin,
 	}, nil
 }
 
@@ -356,11 +464,23 @@ func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Ac
 		return nil, challenge
 	}
 
+	trustedKeys := ac.trustedKeys
+	if ac.remoteKeys != nil {
+		trustedKeys = ac.remoteKeys.trustedKeys()
+		// The signing keys may have been rotated since they were last
+		// fetched.
+		if kid := token.keyID(); kid != "" {
+			if _, ok := trustedKeys[kid]; !ok {
+				trustedKeys = ac.remoteKeys.refreshForKeyID(req.Context(), kid)
+			}
+		}
+	}
+
 	verifyOpts := VerifyOptions{
 		TrustedIssuers:    []string{ac.issuer},
 		AcceptedAudiences: []string{ac.service},
 		Roots:             ac.rootCerts,
-		TrustedKeys:       ac.trustedKeys,
+		TrustedKeys:       trustedKeys,
 	}
 
 	claims, err := token.Verify(verifyOpts)
@@ -369,16 +489,27 @@ func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Ac
 		return nil, challenge
 	}
 
+	var filterCatalog bool
 	accessSet := claims.accessSet()
 	for _, access := range accessItems {
+		if ac.catalogFilter != nil && access == catalogAccess {
+			// The catalog is filtered rather than denied, unless the
+			// token may list it whole.
+			filterCatalog = !ac.catalogAdmin || !accessSet.contains(access)
+			continue
+		}
 		if !accessSet.contains(access) {
 			challenge.err = ErrInsufficientScope
 			return nil, challenge
 		}
 	}
 
-	return &auth.Grant{
+	grant := &auth.Grant{
 		User:      auth.UserInfo{Name: claims.Subject},
 		Resources: claims.resources(),
-	}, nil
+	}
+	if filterCatalog {
+		grant.RepositoryFilter = auth.RepositoryPullFilter(claims.accessItems(), ac.catalogFilter)
+	}
+	return grant, nil
 }
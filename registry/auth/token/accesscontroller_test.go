@@ -3,7 +3,9 @@ package token
 import (
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestBuildAutoRedirectURL(t *testing.T) {
@@ -87,3 +89,34 @@ func TestCheckOptions(t *testing.T) {
 		t.Fatal("autoredirectpath should be /auth/token")
 	}
 }
+
+func TestCheckOptionsRootCertBundles(t *testing.T) {
+	options := map[string]interface{}{
+		"realm":               "https://auth.example.com/token/",
+		"issuer":              "test-issuer.example.com",
+		"service":             "test-service.example.com",
+		"rootcertbundle":      []interface{}{"/certs/current.pem", "/certs/next.pem"},
+		"jwks":                "https://auth.example.com/jwks",
+		"jwkscache":           "/var/cache/jwks.json",
+		"jwksrefreshinterval": "10m",
+	}
+
+	ta, err := checkOptions(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ta.rootCertBundles, []string{"/certs/current.pem", "/certs/next.pem"}) {
+		t.Fatalf("unexpected root cert bundles: %v", ta.rootCertBundles)
+	}
+	if ta.jwksCache != "/var/cache/jwks.json" {
+		t.Fatalf("unexpected jwks cache: %q", ta.jwksCache)
+	}
+	if ta.jwksRefresh != 10*time.Minute {
+		t.Fatalf("unexpected jwks refresh interval: %v", ta.jwksRefresh)
+	}
+
+	options["rootcertbundle"] = []interface{}{"/certs/current.pem", 1}
+	if _, err := checkOptions(options); err == nil {
+		t.Fatal("expected an error for a non-string root cert bundle")
+	}
+}
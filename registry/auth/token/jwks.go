@@ -0,0 +1,224 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+)
+
+const (
+	// defaultJWKSRefreshInterval is how often keys fetched from a JWKS URL
+	// are refreshed if no interval is configured.
+	defaultJWKSRefreshInterval = time.Hour
+
+	// minJWKSRefreshInterval rate limits the refreshes triggered by tokens
+	// signed with an unknown key ID.
+	minJWKSRefreshInterval = 10 * time.Second
+
+	// jwksFetchTimeout bounds a single fetch of the JWKS URL.
+	jwksFetchTimeout = 30 * time.Second
+)
+
+// isJWKSURL reports whether the jwks option names an endpoint rather than a
+// local file.
+func isJWKSURL(jwks string) bool {
+	return strings.HasPrefix(jwks, "https://") || strings.HasPrefix(jwks, "http://")
+}
+
+// remoteKeySet holds the token signing keys published at a JWKS URL, merged
+// with the keys configured statically. The keys are refreshed periodically,
+// and when a token is signed by a key ID that is not known yet.
+type remoteKeySet struct {
+	url       string
+	cachePath string
+	interval  time.Duration
+	client    *http.Client
+
+	// static holds the keys which are always trusted, regardless of the
+	// content of the JWKS.
+	static map[string]crypto.PublicKey
+
+	mu         sync.Mutex
+	keys       map[string]crypto.PublicKey
+	fetched    time.Time
+	attempted  time.Time
+	refreshing bool
+}
+
+// newRemoteKeySet fetches the JWKS at url. If the fetch fails, the keys are
+// loaded from the copy cached at cachePath, if any. Otherwise an error is
+// returned.
+func newRemoteKeySet(ctx context.Context, url, cachePath string, interval time.Duration, client *http.Client, static map[string]crypto.PublicKey) (*remoteKeySet, error) {
+	if !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("token auth jwks url %q must use https", url)
+	}
+	if interval <= 0 {
+		interval = defaultJWKSRefreshInterval
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	ks := &remoteKeySet{
+		url:       url,
+		cachePath: cachePath,
+		interval:  interval,
+		client:    client,
+		static:    static,
+	}
+
+	jwks, err := ks.fetch(ctx)
+	if err != nil {
+		if cachePath == "" {
+			return nil, err
+		}
+		cached, cerr := getJwks(cachePath)
+		if cerr != nil {
+			return nil, fmt.Errorf("%v, and no cached jwks is available: %v", err, cerr)
+		}
+		dcontext.GetLogger(ctx).Warnf("token auth: %v, using jwks cached in %s", err, cachePath)
+		jwks = cached
+	} else {
+		ks.fetched = time.Now()
+	}
+	ks.attempted = time.Now()
+
+	ks.keys = ks.merge(jwks)
+
+	return ks, nil
+}
+
+// trustedKeys returns the keys currently trusted. If the keys are due to be
+// refreshed, a refresh is started in the background.
+func (ks *remoteKeySet) trustedKeys() map[string]crypto.PublicKey {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if !ks.refreshing && time.Since(ks.attempted) >= ks.interval {
+		ks.refreshing = true
+		go ks.refresh(context.Background())
+	}
+	return ks.keys
+}
+
+// refreshForKeyID refreshes the keys if kid is not known, unless they were
+// refreshed very recently. It returns the keys trusted afterwards.
+func (ks *remoteKeySet) refreshForKeyID(ctx context.Context, kid string) map[string]crypto.PublicKey {
+	ks.mu.Lock()
+	if _, ok := ks.keys[kid]; ok || ks.refreshing || time.Since(ks.attempted) < minJWKSRefreshInterval {
+		keys := ks.keys
+		ks.mu.Unlock()
+		return keys
+	}
+	ks.refreshing = true
+	ks.mu.Unlock()
+
+	ks.refresh(ctx)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.keys
+}
+
+// refresh fetches the JWKS and replaces the trusted keys. On failure the
+// previous keys are kept.
+func (ks *remoteKeySet) refresh(ctx context.Context) {
+	jwks, err := ks.fetch(ctx)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.refreshing = false
+	ks.attempted = time.Now()
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("token auth: %v, keeping keys fetched at %s", err, ks.fetched)
+		return
+	}
+	ks.fetched = ks.attempted
+	ks.keys = ks.merge(jwks)
+}
+
+// fetch downloads and parses the JWKS, and caches it on disk if a cache path
+// is configured.
+func (ks *remoteKeySet) fetch(ctx context.Context) (*jose.JSONWebKeySet, error) {
+	ctx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch jwks from %s: %v", ks.url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch jwks from %s: unexpected status %s", ks.url, resp.Status)
+	}
+
+	rawJWKS, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read jwks from %s: %v", ks.url, err)
+	}
+
+	var jwks jose.JSONWebKeySet
+	if err := json.Unmarshal(rawJWKS, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to parse jwks from %s: %v", ks.url, err)
+	}
+
+	if ks.cachePath != "" {
+		if err := writeFileAtomic(ks.cachePath, rawJWKS); err != nil {
+			dcontext.GetLogger(ctx).Warnf("token auth: unable to cache jwks in %s: %v", ks.cachePath, err)
+		}
+	}
+
+	return &jwks, nil
+}
+
+// merge returns the static keys together with the keys in jwks. The result
+// is never modified afterwards, so it can be shared without locking.
+func (ks *remoteKeySet) merge(jwks *jose.JSONWebKeySet) map[string]crypto.PublicKey {
+	keys := make(map[string]crypto.PublicKey, len(ks.static)+len(jwks.Keys))
+	for kid, key := range ks.static {
+		keys[kid] = key
+	}
+	for _, key := range jwks.Keys {
+		keys[key.KeyID] = key.Public()
+	}
+	return keys
+}
+
+// writeFileAtomic replaces the file at path with data, so that readers never
+// observe a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,155 @@
+package token
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+)
+
+// jwksServer serves the public keys of a mutable set of signing keys.
+type jwksServer struct {
+	mu   sync.Mutex
+	keys []*ecdsa.PrivateKey
+	fail bool
+	hits int
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hits++
+	if s.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var jwks jose.JSONWebKeySet
+	for _, key := range s.keys {
+		jwks.Keys = append(jwks.Keys, jose.JSONWebKey{
+			Key:       key.Public(),
+			KeyID:     key.X.String(),
+			Algorithm: string(jose.ES256),
+		})
+	}
+	_ = json.NewEncoder(w).Encode(jwks)
+}
+
+func (s *jwksServer) set(keys []*ecdsa.PrivateKey, fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+	s.fail = fail
+}
+
+func TestRemoteKeySetRotation(t *testing.T) {
+	rootKeys, err := makeRootKeys(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &jwksServer{keys: rootKeys[:1]}
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+
+	cachePath := filepath.Join(t.TempDir(), "jwks.json")
+	ks, err := newRemoteKeySet(context.Background(), server.URL, cachePath, time.Hour, server.Client(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldKID, newKID := rootKeys[0].X.String(), rootKeys[1].X.String()
+	if _, ok := ks.trustedKeys()[oldKID]; !ok {
+		t.Fatal("expected the published key to be trusted")
+	}
+
+	// Rotate the signing key. An unknown key ID triggers a refresh, but
+	// not more often than the minimum interval.
+	handler.set(rootKeys[1:], false)
+	if _, ok := ks.refreshForKeyID(context.Background(), newKID)[newKID]; ok {
+		t.Fatal("expected refreshes to be rate limited")
+	}
+
+	ks.mu.Lock()
+	ks.attempted = time.Now().Add(-minJWKSRefreshInterval)
+	ks.mu.Unlock()
+
+	keys := ks.refreshForKeyID(context.Background(), newKID)
+	if _, ok := keys[newKID]; !ok {
+		t.Fatal("expected the rotated key to be trusted after a refresh")
+	}
+	if _, ok := keys[oldKID]; ok {
+		t.Fatal("expected the retired key to be dropped")
+	}
+
+	// A failed refresh keeps the current keys.
+	handler.set(nil, true)
+	ks.mu.Lock()
+	ks.attempted = time.Now().Add(-minJWKSRefreshInterval)
+	ks.mu.Unlock()
+	if _, ok := ks.refreshForKeyID(context.Background(), oldKID)[newKID]; !ok {
+		t.Fatal("expected keys to be kept after a failed refresh")
+	}
+
+	// The last fetched keys were cached, and are used if the endpoint is
+	// unavailable at startup.
+	ks, err = newRemoteKeySet(context.Background(), server.URL, cachePath, time.Hour, server.Client(), nil)
+	if err != nil {
+		t.Fatalf("expected the cached jwks to be used: %v", err)
+	}
+	if _, ok := ks.trustedKeys()[newKID]; !ok {
+		t.Fatal("expected the cached key to be trusted")
+	}
+
+	// Without a cache, failing to fetch the keys at startup is fatal.
+	if _, err := newRemoteKeySet(context.Background(), server.URL, "", time.Hour, server.Client(), nil); err == nil {
+		t.Fatal("expected an error when the jwks cannot be fetched")
+	}
+}
+
+func TestRemoteKeySetPeriodicRefresh(t *testing.T) {
+	rootKeys, err := makeRootKeys(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &jwksServer{keys: rootKeys}
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+
+	ks, err := newRemoteKeySet(context.Background(), server.URL, "", time.Millisecond, server.Client(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	ks.trustedKeys()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		handler.mu.Lock()
+		hits := handler.hits
+		handler.mu.Unlock()
+		if hits >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the keys to be refreshed in the background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRemoteKeySetRequiresHTTPS(t *testing.T) {
+	if _, err := newRemoteKeySet(context.Background(), "http://auth.example.com/jwks", "", 0, nil, nil); err == nil {
+		t.Fatal("expected an error for a plain http jwks url")
+	}
+}
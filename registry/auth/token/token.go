@@ -140,6 +140,11 @@ func (t *Token) VerifySigningKey(verifyOpts VerifyOptions) (signingKey crypto.Pu
 	// verifying the first one in the list only at the moment.
 	header := t.JWT.Headers[0]
 
+	// A token naming a trusted key ID is verified with that key first.
+	if key, ok := verifyOpts.TrustedKeys[header.KeyID]; ok && header.KeyID != "" {
+		return key, nil
+	}
+
 	switch {
 	case header.JSONWebKey != nil:
 		signingKey, err = verifyJWK(header, verifyOpts)
@@ -155,6 +160,22 @@ func (t *Token) VerifySigningKey(verifyOpts VerifyOptions) (signingKey crypto.Pu
 	return
 }
 
+// keyID returns the ID of the key which signed the token, or an empty string
+// if the token does not name one.
+func (t *Token) keyID() string {
+	if len(t.JWT.Headers) == 0 {
+		return ""
+	}
+	header := t.JWT.Headers[0]
+	if header.KeyID != "" {
+		return header.KeyID
+	}
+	if header.JSONWebKey != nil && len(header.JSONWebKey.Certificates) == 0 {
+		return header.JSONWebKey.KeyID
+	}
+	return ""
+}
+
 func verifyCertChain(header jose.Header, roots *x509.CertPool) (signingKey crypto.PublicKey, err error) {
 	verifyOpts := x509.VerifyOptions{
 		Roots:     roots,
@@ -255,6 +276,25 @@ func (c *ClaimSet) accessSet() accessSet {
 	return accessSet
 }
 
+// accessItems returns the access items listed in the `access` section of
+// this token, an item per action.
+func (c *ClaimSet) accessItems() []auth.Access {
+	var items []auth.Access
+	for _, resourceActions := range c.Access {
+		for _, action := range resourceActions.Actions {
+			items = append(items, auth.Access{
+				Resource: auth.Resource{
+					Type:  resourceActions.Type,
+					Class: resourceActions.Class,
+					Name:  resourceActions.Name,
+				},
+				Action: action,
+			})
+		}
+	}
+	return items
+}
+
 func (c *ClaimSet) resources() []auth.Resource {
 	resourceSet := map[auth.Resource]struct{}{}
 
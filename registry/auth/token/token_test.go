@@ -593,6 +593,125 @@ func TestAccessController(t *testing.T) {
 	}
 }
 
+func TestAccessControllerCatalogFilter(t *testing.T) {
+	rootKeys, err := makeRootKeys(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCertBundleFilename, err := writeTempRootCerts(rootKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rootCertBundleFilename)
+	jwk, err := makeSigningKeyWithChain(rootKeys[0], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := "test-issuer.example.com"
+	service := "test-service.example.com"
+	newController := func(filter string, admin bool) auth.AccessController {
+		t.Helper()
+		accessController, err := newAccessController(map[string]interface{}{
+			"realm":          "https://auth.example.com/token/",
+			"issuer":         issuer,
+			"service":        service,
+			"rootcertbundle": rootCertBundleFilename,
+			"catalogfilter":  filter,
+			"catalogadmin":   admin,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return accessController
+	}
+	authorize := func(accessController auth.AccessController, access ...*ResourceActions) (*auth.Grant, error) {
+		t.Helper()
+		token, err := makeTestToken(jwk, issuer, service, access, time.Now(), time.Now().Add(5*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/v2/_catalog", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Raw))
+		return accessController.Authorized(req, catalogAccess)
+	}
+
+	repositories := []string{"team-a/app", "team-a/app/web", "team-b/app", "other"}
+	expectVisible := func(grant *auth.Grant, expected ...string) {
+		t.Helper()
+		var visible []string
+		for _, name := range repositories {
+			if grant.RepositoryFilter(name) {
+				visible = append(visible, name)
+			}
+		}
+		if fmt.Sprint(visible) != fmt.Sprint(expected) {
+			t.Fatalf("expected repositories %v to be visible, got %v", expected, visible)
+		}
+	}
+
+	teamA := &ResourceActions{Type: "repository", Name: "team-a/*", Actions: []string{"pull"}}
+	other := &ResourceActions{Type: "repository", Name: "other", Actions: []string{"*"}}
+	pushOnly := &ResourceActions{Type: "repository", Name: "team-b/app", Actions: []string{"push"}}
+	catalog := &ResourceActions{Type: "registry", Name: "catalog", Actions: []string{"*"}}
+
+	// Without filtering, the catalog requires its scope.
+	if _, err := authorize(newController("", false), teamA); err == nil {
+		t.Fatal("expected the catalog to require the registry:catalog:* scope")
+	}
+	grant, err := authorize(newController("", false), catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant.RepositoryFilter != nil {
+		t.Fatal("expected the catalog not to be filtered")
+	}
+
+	// With filtering, the catalog lists the repositories the token pulls.
+	grant, err = authorize(newController("glob", false), teamA, other, pushOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectVisible(grant, "team-a/app", "team-a/app/web", "other")
+	grant, err = authorize(newController("exact", false), teamA, other, pushOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectVisible(grant, "other")
+
+	// The catalog scope only lists the whole catalog for admins.
+	grant, err = authorize(newController("glob", false), teamA, catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectVisible(grant, "team-a/app", "team-a/app/web")
+	grant, err = authorize(newController("glob", true), teamA, catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant.RepositoryFilter != nil {
+		t.Fatal("expected the catalog not to be filtered for admins")
+	}
+	grant, err = authorize(newController("glob", true), teamA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectVisible(grant, "team-a/app", "team-a/app/web")
+
+	if _, err := newAccessController(map[string]interface{}{
+		"realm":          "https://auth.example.com/token/",
+		"issuer":         issuer,
+		"service":        service,
+		"rootcertbundle": rootCertBundleFilename,
+		"catalogfilter":  "unknown",
+	}); err == nil {
+		t.Fatal("expected an unknown catalog filter to be rejected")
+	}
+}
+
 // This tests that newAccessController can handle PEM blocks in the certificate
 // file other than certificates, for example a private key.
 func TestNewAccessControllerPemBlock(t *testing.T) {
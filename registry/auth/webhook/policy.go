@@ -0,0 +1,201 @@
+// Package webhook provides an auth.AccessPolicy which delegates authorization
+// decisions to an external HTTP service, such as an Open Policy Agent
+// sidecar.
+//
+// For every request the policy POSTs a JSON document describing the
+// requested access to the configured URL:
+//
+//	{
+//		"user": "alice",
+//		"method": "PUT",
+//		"path": "/v2/team/app/manifests/latest",
+//		"repository": "team/app",
+//		"actions": ["pull", "push"],
+//		"access": [{"type": "repository", "name": "team/app", "action": "pull"}, ...]
+//	}
+//
+// and expects a response of the form
+//
+//	{"allow": false, "message": "team/ is owned by another group"}
+//
+// If the service cannot be reached, times out or answers with anything other
+// than 200 OK, the request is denied unless failopen is set.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultTimeout bounds a policy request if no timeout is configured.
+const defaultTimeout = 5 * time.Second
+
+// init registers the webhook access policy.
+func init() {
+	if err := auth.RegisterPolicy("webhook", auth.PolicyInitFunc(newAccessPolicy)); err != nil {
+		logrus.Errorf("failed to register webhook access policy: %v", err)
+	}
+}
+
+// accessPolicy implements auth.AccessPolicy by consulting an HTTP endpoint.
+type accessPolicy struct {
+	url      string
+	timeout  time.Duration
+	failOpen bool
+	client   *http.Client
+}
+
+var _ auth.AccessPolicy = &accessPolicy{}
+
+// policyRequest is the document sent to the policy endpoint.
+type policyRequest struct {
+	User       string       `json:"user,omitempty"`
+	Method     string       `json:"method"`
+	Path       string       `json:"path"`
+	Repository string       `json:"repository,omitempty"`
+	Actions    []string     `json:"actions,omitempty"`
+	Access     []accessItem `json:"access"`
+}
+
+type accessItem struct {
+	Type   string `json:"type"`
+	Class  string `json:"class,omitempty"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// policyResponse is the decision returned by the policy endpoint.
+type policyResponse struct {
+	Allow   bool   `json:"allow"`
+	Message string `json:"message,omitempty"`
+}
+
+func newAccessPolicy(options map[string]interface{}) (auth.AccessPolicy, error) {
+	url, ok := options["url"].(string)
+	if !ok || url == "" {
+		return nil, errors.New(`"url" must be set for webhook access policy`)
+	}
+
+	timeout := defaultTimeout
+	if t, ok := options["timeout"]; ok {
+		switch t := t.(type) {
+		case time.Duration:
+			timeout = t
+		case string:
+			d, err := time.ParseDuration(t)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout for webhook access policy: %v", err)
+			}
+			timeout = d
+		default:
+			return nil, fmt.Errorf("invalid timeout for webhook access policy: %v", t)
+		}
+	}
+
+	var failOpen bool
+	if f, ok := options["failopen"]; ok {
+		if failOpen, ok = f.(bool); !ok {
+			return nil, fmt.Errorf("invalid failopen for webhook access policy: %v", f)
+		}
+	}
+
+	return &accessPolicy{
+		url:      url,
+		timeout:  timeout,
+		failOpen: failOpen,
+		client:   http.DefaultClient,
+	}, nil
+}
+
+// Authorized asks the policy endpoint whether the requested access is
+// allowed.
+func (ap *accessPolicy) Authorized(ctx context.Context, access []auth.Access, r *http.Request) error {
+	resp, err := ap.query(ctx, newPolicyRequest(ctx, access, r))
+	if err != nil {
+		if ap.failOpen {
+			dcontext.GetLogger(ctx).Warnf("webhook access policy unavailable, allowing request: %v", err)
+			return nil
+		}
+		dcontext.GetLogger(ctx).Errorf("webhook access policy unavailable, denying request: %v", err)
+		return &auth.PolicyDeniedError{Message: "access policy unavailable"}
+	}
+
+	if !resp.Allow {
+		return &auth.PolicyDeniedError{Message: resp.Message}
+	}
+	return nil
+}
+
+func (ap *accessPolicy) query(ctx context.Context, pr policyRequest) (*policyResponse, error) {
+	body, err := json.Marshal(pr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ap.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ap.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ap.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from %s: %s", ap.url, resp.Status)
+	}
+
+	var decision policyResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("invalid response from %s: %v", ap.url, err)
+	}
+	return &decision, nil
+}
+
+// newPolicyRequest describes the request and the access it requires.
+func newPolicyRequest(ctx context.Context, access []auth.Access, r *http.Request) policyRequest {
+	pr := policyRequest{
+		User:   dcontext.GetStringValue(ctx, "auth.user.name"),
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Access: make([]accessItem, 0, len(access)),
+	}
+
+	for _, a := range access {
+		pr.Access = append(pr.Access, accessItem{
+			Type:   a.Type,
+			Class:  a.Class,
+			Name:   a.Name,
+			Action: a.Action,
+		})
+		// The first repository is the one the request targets, any
+		// other is the source of a cross repository mount.
+		if a.Type != "repository" {
+			continue
+		}
+		if pr.Repository == "" {
+			pr.Repository = a.Name
+		}
+		if a.Name == pr.Repository {
+			pr.Actions = append(pr.Actions, a.Action)
+		}
+	}
+
+	return pr
+}
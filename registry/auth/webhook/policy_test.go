@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+func TestAccessPolicy(t *testing.T) {
+	var received policyRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("unexpected method %s", r.Method)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("error decoding policy request: %v", err)
+		}
+		resp := policyResponse{Allow: received.Repository == "team/app"}
+		if !resp.Allow {
+			resp.Message = received.Repository + " is owned by another team"
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	policy, err := newAccessPolicy(map[string]interface{}{"url": server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	access := func(repo string, actions ...string) []auth.Access {
+		var records []auth.Access
+		for _, action := range actions {
+			records = append(records, auth.Access{
+				Resource: auth.Resource{Type: "repository", Name: repo},
+				Action:   action,
+			})
+		}
+		return records
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/v2/team/app/manifests/latest", nil)
+	records := append(access("team/app", "pull", "push"), access("other/app", "pull")...)
+	if err := policy.Authorized(req.Context(), records, req); err != nil {
+		t.Fatalf("expected request to be allowed: %v", err)
+	}
+	if received.Method != http.MethodPut || received.Path != "/v2/team/app/manifests/latest" {
+		t.Fatalf("unexpected request description: %+v", received)
+	}
+	if received.Repository != "team/app" || !reflect.DeepEqual(received.Actions, []string{"pull", "push"}) {
+		t.Fatalf("unexpected repository or actions: %+v", received)
+	}
+	if len(received.Access) != 3 {
+		t.Fatalf("expected the full access list, got %+v", received.Access)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/v2/other/app/manifests/latest", nil)
+	err = policy.Authorized(req.Context(), access("other/app", "pull", "push"), req)
+	var denied *auth.PolicyDeniedError
+	if !errors.As(err, &denied) {
+		t.Fatalf("expected a denial, got %v", err)
+	}
+	if denied.Message != "other/app is owned by another team" {
+		t.Fatalf("unexpected denial message: %q", denied.Message)
+	}
+}
+
+func TestAccessPolicyUnavailable(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/team/app/tags/list", nil)
+	records := []auth.Access{{
+		Resource: auth.Resource{Type: "repository", Name: "team/app"},
+		Action:   "pull",
+	}}
+
+	for _, failOpen := range []bool{false, true} {
+		policy, err := newAccessPolicy(map[string]interface{}{
+			"url":      server.URL,
+			"timeout":  "10ms",
+			"failopen": failOpen,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if policy.(*accessPolicy).timeout != 10*time.Millisecond {
+			t.Fatalf("unexpected timeout: %v", policy.(*accessPolicy).timeout)
+		}
+
+		err = policy.Authorized(req.Context(), records, req)
+		var denied *auth.PolicyDeniedError
+		switch {
+		case failOpen && err != nil:
+			t.Fatalf("expected fail open policy to allow the request: %v", err)
+		case !failOpen && !errors.As(err, &denied):
+			t.Fatalf("expected fail closed policy to deny the request, got %v", err)
+		}
+	}
+}
+
+func TestNewAccessPolicyOptions(t *testing.T) {
+	for _, options := range []map[string]interface{}{
+		{},
+		{"url": "http://policy", "timeout": "soon"},
+		{"url": "http://policy", "failopen": "yes"},
+	} {
+		if _, err := newAccessPolicy(options); err == nil {
+			t.Fatalf("expected an error for options %v", options)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package coordination elects a leader among the registry instances sharing
+// a storage, so that the background maintenance runs on only one of them.
+// The instances compete for a lock held for a limited time, kept in redis or
+// written through the storage driver, and the leader renews it until it
+// stops or fails.
+package coordination
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+)
+
+// leaderGauge is 1 while the instance is the leader, 0 otherwise.
+var leaderGauge = prometheus.CoordinationNamespace.NewGauge("leader", "Whether the instance is the leader running the background maintenance", metrics.Total)
+
+func init() {
+	metrics.Register(prometheus.CoordinationNamespace)
+}
+
+// Lock is a lock held by an owner for a limited time.
+type Lock interface {
+	// TryLock acquires the lock for owner until ttl elapses if it is free or
+	// expired, or extends it if owner holds it. It reports whether owner
+	// holds the lock.
+	TryLock(ctx context.Context, owner string, ttl time.Duration) (bool, error)
+
+	// Unlock releases the lock if owner holds it.
+	Unlock(ctx context.Context, owner string) error
+}
+
+// Elector elects the instance holding a Lock as the leader, and renews its
+// leadership every third of the TTL.
+type Elector struct {
+	lock Lock
+	id   string
+	ttl  time.Duration
+
+	mu     sync.Mutex
+	leader bool
+	// expiry is when the leadership lapses unless renewed.
+	expiry time.Time
+	// err is the error of the last campaign.
+	err error
+}
+
+// NewElector returns an Elector competing for lock as the instance id, which
+// must be unique among the instances sharing the lock.
+func NewElector(lock Lock, id string, ttl time.Duration) *Elector {
+	return &Elector{
+		lock: lock,
+		id:   id,
+		ttl:  ttl,
+	}
+}
+
+// Campaign tries to acquire or renew the leadership once. The instance is
+// not the leader anymore if it fails.
+func (e *Elector) Campaign(ctx context.Context) error {
+	start := time.Now()
+	leader, err := e.lock.TryLock(ctx, e.id, e.ttl)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if leader && err == nil {
+		if !e.leader {
+			dcontext.GetLogger(ctx).Infof("instance %s elected leader", e.id)
+		}
+		// The lock may have been taken any time since the start of the
+		// attempt, so the leadership lapses a TTL after it.
+		e.expiry = start.Add(e.ttl)
+	} else if e.leader {
+		dcontext.GetLogger(ctx).Warnf("instance %s lost the leadership", e.id)
+	}
+	e.leader = leader && err == nil
+	e.err = err
+	e.setGauge()
+	return err
+}
+
+// Run campaigns every third of the TTL until ctx is done, then releases the
+// lock if the instance is the leader.
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		if err := e.Campaign(ctx); err != nil && ctx.Err() == nil {
+			dcontext.GetLogger(ctx).Errorf("leader election failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			e.Resign(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Resign releases the leadership, letting another instance take it over
+// without waiting for the TTL.
+func (e *Elector) Resign(ctx context.Context) {
+	e.mu.Lock()
+	leader := e.leader
+	e.leader = false
+	e.setGauge()
+	e.mu.Unlock()
+
+	if !leader {
+		return
+	}
+	if err := e.lock.Unlock(ctx, e.id); err != nil {
+		dcontext.GetLogger(ctx).Errorf("failed to release the leadership: %v", err)
+	}
+}
+
+// IsLeader reports whether the instance is the leader.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.leader && time.Now().Before(e.expiry)
+}
+
+// Check implements health.Checker. It fails while the lock cannot be
+// reached, when the instance can neither lead nor know whether another one
+// does.
+func (e *Elector) Check(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.err
+}
+
+// setGauge records the leadership of the instance. The caller must hold the
+// lock.
+func (e *Elector) setGauge() {
+	if e.leader {
+		leaderGauge.Set(1)
+	} else {
+		leaderGauge.Set(0)
+	}
+}
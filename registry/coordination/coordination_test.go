@@ -0,0 +1,94 @@
+package coordination
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestStorageLock(t *testing.T) {
+	checkLock(t, NewStorageLock(inmemory.New(), "/coordination/leader"))
+}
+
+// checkLock exercises a Lock implementation.
+func checkLock(t *testing.T, lock Lock) {
+	ctx := context.Background()
+	ttl := 200 * time.Millisecond
+
+	if held, err := lock.TryLock(ctx, "first", ttl); err != nil || !held {
+		t.Fatalf("expected first to take the free lock: %t, %v", held, err)
+	}
+	if held, err := lock.TryLock(ctx, "second", ttl); err != nil || held {
+		t.Fatalf("expected second not to take the held lock: %t, %v", held, err)
+	}
+	if held, err := lock.TryLock(ctx, "first", ttl); err != nil || !held {
+		t.Fatalf("expected first to renew the lock: %t, %v", held, err)
+	}
+
+	time.Sleep(ttl + 50*time.Millisecond)
+	if held, err := lock.TryLock(ctx, "second", ttl); err != nil || !held {
+		t.Fatalf("expected second to take the expired lock: %t, %v", held, err)
+	}
+
+	if err := lock.Unlock(ctx, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := lock.TryLock(ctx, "first", ttl); err != nil || held {
+		t.Fatalf("expected the lock of second to be left held: %t, %v", held, err)
+	}
+	if err := lock.Unlock(ctx, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := lock.TryLock(ctx, "first", ttl); err != nil || !held {
+		t.Fatalf("expected first to take the released lock: %t, %v", held, err)
+	}
+}
+
+func TestElectorFailover(t *testing.T) {
+	lock := NewStorageLock(inmemory.New(), "/coordination/leader")
+	ttl := 300 * time.Millisecond
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	first := NewElector(lock, "first", ttl)
+	firstDone := make(chan struct{})
+	go func() {
+		first.Run(firstCtx)
+		close(firstDone)
+	}()
+	waitFor(t, first.IsLeader, "first to be elected")
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	second := NewElector(lock, "second", ttl)
+	go second.Run(secondCtx)
+
+	time.Sleep(ttl)
+	if !first.IsLeader() || second.IsLeader() {
+		t.Fatalf("expected first to keep the leadership: first %t, second %t", first.IsLeader(), second.IsLeader())
+	}
+
+	stopFirst()
+	<-firstDone
+	if first.IsLeader() {
+		t.Fatal("expected first to resign")
+	}
+	waitFor(t, second.IsLeader, "second to take over")
+
+	if err := second.Check(context.Background()); err != nil {
+		t.Fatalf("unexpected health check failure: %v", err)
+	}
+}
+
+// waitFor waits for cond, failing after a few seconds.
+func waitFor(t *testing.T, cond func() bool, what string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
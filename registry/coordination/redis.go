@@ -0,0 +1,60 @@
+package coordination
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tryLockScript sets the key KEYS[1] to the owner ARGV[1] for ARGV[2]
+// milliseconds if it is not set, or extends it if it is already set to the
+// owner. It returns 1 if the owner holds the lock, 0 otherwise.
+var tryLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+// unlockScript deletes the key KEYS[1] if it is set to the owner ARGV[1].
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type redisLock struct {
+	pool *redis.Client
+	key  string
+}
+
+// NewRedisLock returns a Lock kept in redis under key, shared by the registry
+// instances using the same redis pool. The lock expires using the clock of
+// the redis server.
+func NewRedisLock(pool *redis.Client, key string) Lock {
+	return &redisLock{pool: pool, key: key}
+}
+
+// TryLock implements Lock.
+func (l *redisLock) TryLock(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	res, err := tryLockScript.Run(ctx, l.pool, []string{lockKey(l.key)}, owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// Unlock implements Lock.
+func (l *redisLock) Unlock(ctx context.Context, owner string) error {
+	return unlockScript.Run(ctx, l.pool, []string{lockKey(l.key)}, owner).Err()
+}
+
+func lockKey(key string) string {
+	return "coordination::" + key
+}
@@ -0,0 +1,43 @@
+package coordination
+
+import (
+	"context"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var redisAddr string
+
+func init() {
+	flag.StringVar(&redisAddr, "test.registry.coordination.redis.addr", "", "configure the address of a test instance of redis")
+}
+
+// TestRedisLock exercises a live redis instance using the lock
+// implementation.
+func TestRedisLock(t *testing.T) {
+	if redisAddr == "" {
+		// fallback to an environment variable
+		redisAddr = os.Getenv("TEST_REGISTRY_COORDINATION_REDIS_ADDR")
+	}
+
+	if redisAddr == "" {
+		// skip if still not set
+		t.Skip("please set -test.registry.coordination.redis.addr to test the lock against redis")
+	}
+
+	pool := redis.NewClient(&redis.Options{
+		Addr:       redisAddr,
+		MaxRetries: 3,
+		PoolSize:   2,
+	})
+
+	// Clear the database
+	if err := pool.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatalf("unexpected error flushing redis db: %v", err)
+	}
+
+	checkLock(t, NewRedisLock(pool, "leader"))
+}
@@ -0,0 +1,99 @@
+package coordination
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// lockRecord is the content of a storage lock file.
+type lockRecord struct {
+	Owner  string    `json:"owner"`
+	Expiry time.Time `json:"expiry"`
+}
+
+type storageLock struct {
+	driver driver.StorageDriver
+	path   string
+}
+
+// storageLockSettle is how long an instance taking a free storage lock waits
+// before reading it back. Storage drivers cannot write a file only if it is
+// absent, so the instances which found the lock free at the same time all
+// write it: the wait lets their writes land, so that only the last writer
+// holds the lock.
+var storageLockSettle = 50 * time.Millisecond
+
+// NewStorageLock returns a Lock written to the file at path through the
+// storage driver, shared by the registry instances using the same storage.
+// Taking a free lock waits for the writes of the instances competing for it
+// to settle, and only the last writer holds it. The instances must have
+// synchronized clocks.
+func NewStorageLock(driver driver.StorageDriver, path string) Lock {
+	return &storageLock{driver: driver, path: path}
+}
+
+// TryLock implements Lock.
+func (l *storageLock) TryLock(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	record, err := l.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if record.Owner != "" && record.Owner != owner && now.Before(record.Expiry) {
+		return false, nil
+	}
+
+	p, err := json.Marshal(lockRecord{Owner: owner, Expiry: now.Add(ttl)})
+	if err != nil {
+		return false, err
+	}
+	if err := l.driver.PutContent(ctx, l.path, p); err != nil {
+		return false, err
+	}
+
+	// Of the instances taking the lock at the same time, the last one to
+	// write it holds it.
+	if record.Owner != owner {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(storageLockSettle):
+		}
+	}
+	record, err = l.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	return record.Owner == owner, nil
+}
+
+// Unlock implements Lock.
+func (l *storageLock) Unlock(ctx context.Context, owner string) error {
+	record, err := l.read(ctx)
+	if err != nil || record.Owner != owner {
+		return err
+	}
+	err = l.driver.Delete(ctx, l.path)
+	if errors.As(err, &driver.PathNotFoundError{}) {
+		return nil
+	}
+	return err
+}
+
+// read returns the record of the lock file, which is empty if there is
+// none.
+func (l *storageLock) read(ctx context.Context) (lockRecord, error) {
+	var record lockRecord
+	p, err := l.driver.GetContent(ctx, l.path)
+	if errors.As(err, &driver.PathNotFoundError{}) {
+		return record, nil
+	} else if err != nil {
+		return record, err
+	}
+	err = json.Unmarshal(p, &record)
+	return record, err
+}
@@ -0,0 +1,173 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+var (
+	defaultCORSAllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+	defaultCORSAllowedHeaders = []string{"Accept", "Authorization", "Content-Type"}
+	defaultCORSExposedHeaders = []string{"Docker-Content-Digest", "Link", "WWW-Authenticate"}
+)
+
+// acmeChallengePrefix is the path prefix of the HTTP challenges of ACME
+// servers, such as Let's Encrypt, whose responses are left untouched.
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// corsOrigin is an origin allowed by the CORS configuration.
+type corsOrigin struct {
+	scheme, host string
+	// port is the port of the origin, "*" for any.
+	port string
+}
+
+// corsHandler adds the CORS headers to the responses to the requests from
+// the allowed origins, and answers their preflight requests before they
+// reach the registry, so that they are not denied authorization.
+type corsHandler struct {
+	handler    http.Handler
+	anyOrigin  bool
+	origins    []corsOrigin
+	methods    string
+	headers    string
+	exposed    string
+	credential bool
+	maxAge     string
+}
+
+// newCORSHandler wraps handler with the CORS configuration of the
+// Access-Control-* entries of headers, the http.headers configuration. It
+// returns handler if headers allow no origin.
+func newCORSHandler(headers http.Header, handler http.Handler) (http.Handler, error) {
+	cors, err := configuration.CORSHeaders(headers)
+	if err != nil || len(cors.Values("Access-Control-Allow-Origin")) == 0 {
+		return handler, err
+	}
+
+	ch := &corsHandler{
+		handler:    handler,
+		methods:    strings.Join(orDefault(headerList(cors, "Access-Control-Allow-Methods"), defaultCORSAllowedMethods), ", "),
+		headers:    strings.Join(orDefault(headerList(cors, "Access-Control-Allow-Headers"), defaultCORSAllowedHeaders), ", "),
+		exposed:    strings.Join(orDefault(headerList(cors, "Access-Control-Expose-Headers"), defaultCORSExposedHeaders), ", "),
+		credential: strings.EqualFold(cors.Get("Access-Control-Allow-Credentials"), "true"),
+		maxAge:     cors.Get("Access-Control-Max-Age"),
+	}
+	for _, origin := range headerList(cors, "Access-Control-Allow-Origin") {
+		if origin == "*" {
+			ch.anyOrigin = true
+			continue
+		}
+		parsed, err := parseCORSOrigin(origin)
+		if err != nil {
+			return nil, err
+		}
+		ch.origins = append(ch.origins, parsed)
+	}
+	return ch, nil
+}
+
+// headerList returns the comma separated values of the header name.
+func headerList(headers http.Header, name string) []string {
+	var values []string
+	for _, v := range headers.Values(name) {
+		for _, value := range strings.Split(v, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// orDefault returns values, or defaults if values is empty.
+func orDefault(values, defaults []string) []string {
+	if len(values) == 0 {
+		return defaults
+	}
+	return values
+}
+
+// parseCORSOrigin parses an allowed origin, such as https://example.com or
+// https://example.com:*.
+func parseCORSOrigin(origin string) (corsOrigin, error) {
+	anyPort := strings.HasSuffix(origin, ":*")
+	u, err := url.Parse(strings.TrimSuffix(origin, ":*"))
+	if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return corsOrigin{}, fmt.Errorf("invalid allowed origin %q: must be of the form scheme://host[:port]", origin)
+	}
+	parsed := corsOrigin{scheme: strings.ToLower(u.Scheme), host: strings.ToLower(u.Hostname()), port: u.Port()}
+	if anyPort {
+		if parsed.port != "" {
+			return corsOrigin{}, fmt.Errorf("invalid allowed origin %q: must be of the form scheme://host[:port]", origin)
+		}
+		parsed.port = "*"
+	}
+	return parsed, nil
+}
+
+// allowed reports whether the origin of a request is allowed.
+func (ch *corsHandler) allowed(origin string) bool {
+	if ch.anyOrigin {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	scheme, host, port := strings.ToLower(u.Scheme), strings.ToLower(u.Hostname()), u.Port()
+	for _, o := range ch.origins {
+		if o.scheme == scheme && o.host == host && (o.port == "*" || o.port == port) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ch *corsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || strings.HasPrefix(r.URL.Path, acmeChallengePrefix) {
+		ch.handler.ServeHTTP(w, r)
+		return
+	}
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	if !ch.allowed(origin) {
+		if preflight {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		ch.handler.ServeHTTP(w, r)
+		return
+	}
+
+	// configuration.CORSHeaders rejects any origin with credentials.
+	if ch.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if ch.credential {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		h.Set("Access-Control-Expose-Headers", ch.exposed)
+		ch.handler.ServeHTTP(w, r)
+		return
+	}
+
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	h.Set("Access-Control-Allow-Methods", ch.methods)
+	h.Set("Access-Control-Allow-Headers", ch.headers)
+	if ch.maxAge != "" {
+		h.Set("Access-Control-Max-Age", ch.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	_ "github.com/distribution/distribution/v3/registry/auth/silly"
+)
+
+func TestCORS(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	config.Auth = configuration.Auth{"silly": configuration.Parameters{"realm": "realm", "service": "service"}}
+	config.Log.AccessLog.Disabled = true
+	config.HTTP.Headers = http.Header{
+		"X-Content-Type-Options":           []string{"nosniff"},
+		"Access-Control-Allow-Origin":      []string{"https://ui.example.com", "http://localhost:*"},
+		"Access-Control-Allow-Methods":     []string{"GET, DELETE"},
+		"Access-Control-Max-Age":           []string{"600"},
+		"access-control-allow-credentials": []string{"true"},
+	}
+	registry, err := NewRegistry(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method, origin string, preflight bool) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, "/v2/", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if preflight {
+			req.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+			req.Header.Set("Access-Control-Request-Headers", "Authorization")
+		}
+		w := httptest.NewRecorder()
+		registry.server.Handler.ServeHTTP(w, req)
+		return w
+	}
+	expectHeaders := func(w *httptest.ResponseRecorder, expected map[string]string) {
+		t.Helper()
+		for name, value := range expected {
+			if got := w.Header().Get(name); got != value {
+				t.Errorf("expected %s header %q, got %q", name, value, got)
+			}
+		}
+	}
+
+	// Preflight requests are answered before authorization.
+	for _, origin := range []string{"https://ui.example.com", "http://localhost:3000", "http://localhost"} {
+		w := serve(http.MethodOptions, origin, true)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("preflight from %s: expected status %d, got %d", origin, http.StatusNoContent, w.Code)
+		}
+		expectHeaders(w, map[string]string{
+			"Access-Control-Allow-Origin":      origin,
+			"Access-Control-Allow-Methods":     "GET, DELETE",
+			"Access-Control-Allow-Headers":     "Accept, Authorization, Content-Type",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Max-Age":           "600",
+		})
+	}
+	for _, origin := range []string{"https://evil.example.com", "http://ui.example.com", "https://ui.example.com:8443", "https://localhost:3000"} {
+		w := serve(http.MethodOptions, origin, true)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("preflight from %s: expected status %d, got %d", origin, http.StatusForbidden, w.Code)
+		}
+		expectHeaders(w, map[string]string{"Access-Control-Allow-Origin": ""})
+	}
+
+	// Other requests are authorized as usual, with the CORS headers of
+	// allowed origins only.
+	w := serve(http.MethodGet, "https://ui.example.com", false)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	expectHeaders(w, map[string]string{
+		"Access-Control-Allow-Origin":   "https://ui.example.com",
+		"Access-Control-Expose-Headers": "Docker-Content-Digest, Link, WWW-Authenticate",
+	})
+	if got := w.Header().Values("Access-Control-Allow-Origin"); len(got) != 1 {
+		t.Fatalf("expected the origin of the request only, got %v", got)
+	}
+	w = serve(http.MethodGet, "https://evil.example.com", false)
+	expectHeaders(w, map[string]string{"Access-Control-Allow-Origin": ""})
+	w = serve(http.MethodGet, "", false)
+	expectHeaders(w, map[string]string{"Access-Control-Allow-Origin": "", "Vary": ""})
+}
+
+func TestCORSConfiguration(t *testing.T) {
+	for _, headers := range []http.Header{
+		{"Access-Control-Allow-Origin": []string{"ui.example.com"}},
+		{"Access-Control-Allow-Origin": []string{"https://ui.example.com/path"}},
+		{"Access-Control-Allow-Origin": []string{"https://ui.example.com:443:*"}},
+		{"Access-Control-Allow-Origin": []string{"*"}, "Access-Control-Allow-Credentials": []string{"true"}},
+		{"Access-Control-Allow-Origin": []string{"https://ui.example.com"}, "Access-Control-Max-Age": []string{"10m"}},
+	} {
+		if _, err := newCORSHandler(headers, http.NotFoundHandler()); err == nil {
+			t.Errorf("expected an error for headers %v", headers)
+		}
+	}
+
+	handler, err := newCORSHandler(http.Header{"Access-Control-Allow-Origin": []string{"*"}}, http.NotFoundHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+	req.Header.Set("Origin", "https://anywhere.example.com")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("expected any origin to be allowed, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Fatalf("expected no credentials to be allowed, got %q", got)
+	}
+}
@@ -3,6 +3,8 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,30 +17,35 @@ import (
 	"path"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/manifest"
 	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/manifest/schema2"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/storage"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
-	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/distribution/distribution/v3/testutil"
+	"github.com/distribution/distribution/v3/version"
 	"github.com/distribution/reference"
 	"github.com/gorilla/handlers"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
-var headerConfig = http.Header{
-	"X-Content-Type-Options": []string{"nosniff"},
-}
-
 const (
 	// digestSha256EmptyTar is the canonical sha256 digest of empty data
 	digestSha256EmptyTar = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
@@ -451,6 +458,271 @@ func TestCatalogAPI(t *testing.T) {
 }
 
 // TestTagsAPI tests the /v2/<name>/tags/list endpoint
+// TestCatalogAPIPublicPrefixes checks that anonymous requests may only pull
+// and list repositories under the public prefixes.
+func TestInfoAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"delete":   configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	infoURL, err := env.builder.BuildInfoURL()
+	if err != nil {
+		t.Fatalf("unexpected error building info url: %v", err)
+	}
+	resp, err := http.Get(infoURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching info: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "fetching info", resp, http.StatusOK)
+
+	var info infoAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("error decoding fetched info: %v", err)
+	}
+	expected := infoAPIResponse{
+		Version:  version.Version(),
+		Revision: version.Revision(),
+		Storage:  "inmemory",
+		Features: registryFeatures{
+			Delete:     true,
+			Redirect:   true,
+			Validation: true,
+			TagDelete:  true,
+		},
+	}
+	if info != expected {
+		t.Fatalf("unexpected info: %+v != %+v", info, expected)
+	}
+
+	// The route is removed once disabled.
+	config.HTTP.Info.Disabled = true
+	env = newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	infoURL, err = env.builder.BuildInfoURL()
+	if err != nil {
+		t.Fatalf("unexpected error building info url: %v", err)
+	}
+	resp, err = http.Get(infoURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching info: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "fetching disabled info", resp, http.StatusNotFound)
+}
+
+func TestCatalogAPIPrefixFilter(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	for _, image := range []string{"bar/a", "foo/a", "foo/b", "foo/c", "foobar/a"} {
+		createRepository(env, t, image, "sometag")
+	}
+
+	getCatalog := func(values url.Values) ([]string, string) {
+		catalogURL, err := env.builder.BuildCatalogURL(values)
+		if err != nil {
+			t.Fatalf("unexpected error building catalog url: %v", err)
+		}
+		resp, err := http.Get(catalogURL)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		defer resp.Body.Close()
+		checkResponse(t, "issuing filtered catalog api check", resp, http.StatusOK)
+
+		var ctlg struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&ctlg); err != nil {
+			t.Fatalf("error decoding catalog: %v", err)
+		}
+		return ctlg.Repositories, resp.Header.Get("Link")
+	}
+
+	repos, link := getCatalog(url.Values{"q": []string{"foo/"}, "n": []string{"2"}})
+	if !reflect.DeepEqual(repos, []string{"foo/a", "foo/b"}) {
+		t.Fatalf("unexpected repositories: %v", repos)
+	}
+	if link == "" {
+		t.Fatalf("expected a link to the next page")
+	}
+	values := checkLink(t, link, 2, "foo/b")
+	if values.Get("q") != "foo/" {
+		t.Fatalf("link does not keep the filter: %s", link)
+	}
+
+	repos, link = getCatalog(values)
+	if !reflect.DeepEqual(repos, []string{"foo/c"}) {
+		t.Fatalf("unexpected repositories: %v", repos)
+	}
+	if link != "" {
+		t.Fatalf("unexpected link on the last page: %s", link)
+	}
+
+	repos, link = getCatalog(url.Values{"q": []string{"foo"}})
+	if !reflect.DeepEqual(repos, []string{"foo/a", "foo/b", "foo/c", "foobar/a"}) || link != "" {
+		t.Fatalf("unexpected repositories: %v, link %q", repos, link)
+	}
+
+	repos, link = getCatalog(url.Values{"q": []string{"baz/"}})
+	if len(repos) != 0 || link != "" {
+		t.Fatalf("unexpected repositories: %v, link %q", repos, link)
+	}
+}
+
+func TestCountAPI(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	for _, image := range []string{"bar/a", "foo/a", "foo/b"} {
+		createRepository(env, t, image, "latest")
+	}
+	imageName, _ := reference.WithName("foo/a")
+	createRepository(env, t, imageName.Name(), "v1")
+	createRepository(env, t, imageName.Name(), "v2")
+
+	count := func(method, u string, expectedStatus int, expectedCount string) {
+		t.Helper()
+		req, err := http.NewRequest(method, u, nil)
+		checkErr(t, err, "creating request")
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "counting")
+		resp.Body.Close()
+		checkResponse(t, method+" "+u, resp, expectedStatus)
+		if c := resp.Header.Get("X-Total-Count"); c != expectedCount {
+			t.Fatalf("%s %s: unexpected X-Total-Count %q, expected %q", method, u, c, expectedCount)
+		}
+	}
+
+	tagsURL, err := env.builder.BuildTagsURL(imageName)
+	checkErr(t, err, "building tags url")
+	count(http.MethodHead, tagsURL, http.StatusOK, "3")
+	count(http.MethodGet, tagsURL+"?n=1", http.StatusOK, "3")
+
+	unknownName, _ := reference.WithName("foo/unknown")
+	unknownURL, err := env.builder.BuildTagsURL(unknownName)
+	checkErr(t, err, "building tags url")
+	count(http.MethodHead, unknownURL, http.StatusNotFound, "")
+
+	catalogURL, err := env.builder.BuildCatalogURL()
+	checkErr(t, err, "building catalog url")
+	count(http.MethodHead, catalogURL, http.StatusOK, "3")
+	count(http.MethodGet, catalogURL+"?n=1", http.StatusOK, "3")
+	prefixURL, err := env.builder.BuildCatalogURL(url.Values{"q": []string{"foo/"}})
+	checkErr(t, err, "building catalog url")
+	count(http.MethodHead, prefixURL, http.StatusOK, "2")
+}
+
+func TestCatalogAPIPublicPrefixes(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	for _, image := range []string{"library/alpine", "library2/alpine", "private/app", "library/ubuntu", "zeta/app"} {
+		createRepository(env, t, image, "latest")
+	}
+
+	accessController, err := auth.GetAccessController("silly", map[string]interface{}{
+		"realm":          "realm-test",
+		"service":        "service-test",
+		"publicprefixes": []interface{}{"library/*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.app.accessController = accessController
+
+	get := func(u string, authenticated bool) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authenticated {
+			req.Header.Set("Authorization", "Bearer sillytoken")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		return resp
+	}
+
+	for _, tc := range []struct {
+		repo   string
+		status int
+	}{
+		{"library/alpine", http.StatusOK},
+		{"library2/alpine", http.StatusUnauthorized},
+		{"private/app", http.StatusUnauthorized},
+	} {
+		named, _ := reference.WithName(tc.repo)
+		tagsURL, err := env.builder.BuildTagsURL(named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := get(tagsURL, false)
+		resp.Body.Close()
+		checkResponse(t, "anonymous tags list of "+tc.repo, resp, tc.status)
+	}
+
+	// Anonymous users page through the public repositories only.
+	var listed []string
+	values := url.Values{"n": []string{"1"}}
+	for {
+		catalogURL, err := env.builder.BuildCatalogURL(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := get(catalogURL, false)
+		checkResponse(t, "anonymous catalog", resp, http.StatusOK)
+
+		var ctlg struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&ctlg); err != nil {
+			t.Fatalf("error decoding catalog: %v", err)
+		}
+		resp.Body.Close()
+		listed = append(listed, ctlg.Repositories...)
+
+		link := resp.Header.Get("Link")
+		if link == "" {
+			break
+		}
+		values = checkLink(t, link, 1, ctlg.Repositories[len(ctlg.Repositories)-1])
+	}
+	if !reflect.DeepEqual(listed, []string{"library/alpine", "library/ubuntu"}) {
+		t.Fatalf("unexpected anonymous catalog: %v", listed)
+	}
+
+	// Authenticated users see every repository.
+	catalogURL, err := env.builder.BuildCatalogURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := get(catalogURL, true)
+	defer resp.Body.Close()
+	checkResponse(t, "authenticated catalog", resp, http.StatusOK)
+	var ctlg struct {
+		Repositories []string `json:"repositories"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ctlg); err != nil {
+		t.Fatalf("error decoding catalog: %v", err)
+	}
+	if len(ctlg.Repositories) != 5 {
+		t.Fatalf("unexpected authenticated catalog: %v", ctlg.Repositories)
+	}
+}
+
 func TestTagsAPI(t *testing.T) {
 	env := newTestEnv(t, false)
 	defer env.Shutdown()
@@ -647,7 +919,6 @@ func TestURLPrefix(t *testing.T) {
 		},
 	}
 	config.HTTP.Prefix = "/test/"
-	config.HTTP.Headers = headerConfig
 
 	env := newTestEnvWithConfig(t, &config)
 	defer env.Shutdown()
@@ -729,7 +1000,6 @@ func TestRelativeURL(t *testing.T) {
 			}},
 		},
 	}
-	config.HTTP.Headers = headerConfig
 	config.HTTP.RelativeURLs = false
 	env := newTestEnvWithConfig(t, &config)
 	defer env.Shutdown()
@@ -1393,6 +1663,36 @@ func TestManifestAPI_DeleteTag_ReadOnly(t *testing.T) {
 	checkResponse(t, msg, resp, http.StatusMethodNotAllowed)
 }
 
+func TestManifestAPI_PutSchema1Rejected(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/schema1")
+	checkErr(t, err, "building named object")
+
+	ref, err := reference.WithTag(imageName, "latest")
+	checkErr(t, err, "building tag reference")
+
+	u, err := env.builder.BuildManifestURL(ref)
+	checkErr(t, err, "building URL")
+
+	payload := map[string]interface{}{
+		"schemaVersion": 1,
+		"name":          imageName.Name(),
+		"tag":           "latest",
+	}
+
+	for _, mediaType := range []string{mediaTypeSchema1Manifest, mediaTypeSchema1SignedManifest} {
+		msg := "putting schema1 manifest as " + mediaType
+		resp := putManifest(t, msg, u, mediaType, payload)
+		defer resp.Body.Close()
+
+		checkResponse(t, msg, resp, http.StatusBadRequest)
+		// nolint:errcheck
+		checkBodyHasErrorCodes(t, msg, resp, errcode.ErrorCodeManifestSchemaV1Disabled)
+	}
+}
+
 // storageManifestErrDriverFactory implements the factory.StorageDriverFactory interface.
 type storageManifestErrDriverFactory struct{}
 
@@ -1463,7 +1763,6 @@ func TestGetManifestWithStorageError(t *testing.T) {
 			}},
 		},
 	}
-	config.HTTP.Headers = headerConfig
 	env1 := newTestEnvWithConfig(t, &config)
 	defer env1.Shutdown()
 
@@ -1480,131 +1779,2387 @@ func TestGetManifestWithStorageError(t *testing.T) {
 	testManifestWithStorageError(t, env1, repo, http.StatusInternalServerError, errcode.ErrorCodeUnknown)
 }
 
-func TestManifestDelete(t *testing.T) {
-	schema2Repo, _ := reference.WithName("foo/schema2")
-
-	deleteEnabled := true
-	env := newTestEnv(t, deleteEnabled)
-	defer env.Shutdown()
-	schema2Args := testManifestAPISchema2(t, env, schema2Repo, "schema2tag")
-	testManifestDelete(t, env, schema2Args)
+// uploadRedirectDriver redirects uploads to a server which, like a storage
+// backend, only accepts content matching the length and digest of the
+// redirected request.
+type uploadRedirectDriver struct {
+	storagedriver.StorageDriver
+	server  *httptest.Server
+	uploads int
 }
 
-func TestManifestDeleteDisabled(t *testing.T) {
-	schema2Repo, _ := reference.WithName("foo/schema2")
-	deleteEnabled := false
-	env := newTestEnv(t, deleteEnabled)
-	defer env.Shutdown()
-	testManifestDeleteDisabled(t, env, schema2Repo)
+func (d *uploadRedirectDriver) RedirectURL(r *http.Request, path string) (string, error) {
+	checksum := storagedriver.ContentDigestSHA256(r)
+	if r.Method != http.MethodPut || checksum == "" {
+		return "", nil
+	}
+	return d.server.URL + "?" + url.Values{
+		"path":   []string{path},
+		"length": []string{strconv.FormatInt(r.ContentLength, 10)},
+		"digest": []string{checksum},
+	}.Encode(), nil
 }
 
-func testManifestDeleteDisabled(t *testing.T, env *testEnv, imageName reference.Named) {
-	ref, _ := reference.WithDigest(imageName, digestSha256EmptyTar)
-	manifestURL, err := env.builder.BuildManifestURL(ref)
+func (d *uploadRedirectDriver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	content, err := io.ReadAll(r.Body)
 	if err != nil {
-		t.Fatalf("unexpected error getting manifest url: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
-
-	resp, err := httpDelete(manifestURL)
-	if err != nil {
-		t.Fatalf("unexpected error deleting manifest %v", err)
+	sum := sha256.Sum256(content)
+	query := r.URL.Query()
+	if strconv.Itoa(len(content)) != query.Get("length") || base64.StdEncoding.EncodeToString(sum[:]) != query.Get("digest") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
-	defer resp.Body.Close()
+	if err := d.PutContent(r.Context(), query.Get("path"), content); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	d.uploads++
+}
 
-	checkResponse(t, "status of disabled delete of manifest", resp, http.StatusMethodNotAllowed)
+type uploadRedirectDriverFactory struct {
+	driver *uploadRedirectDriver
 }
 
-func testManifestWithStorageError(t *testing.T, env *testEnv, imageName reference.Named, expectedStatusCode int, expectedErrorCode errcode.ErrorCode) {
-	tag := "latest"
-	tagRef, _ := reference.WithTag(imageName, tag)
-	manifestURL, err := env.builder.BuildManifestURL(tagRef)
-	if err != nil {
-		t.Fatalf("unexpected error getting manifest url: %v", err)
+func (factory *uploadRedirectDriverFactory) Create(ctx context.Context, parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return factory.driver, nil
+}
+
+func TestBlobUploadRedirect(t *testing.T) {
+	driver := &uploadRedirectDriver{StorageDriver: inmemory.New()}
+	driver.server = httptest.NewServer(driver)
+	defer driver.server.Close()
+	factory.Register("uploadredirect", &uploadRedirectDriverFactory{driver: driver})
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"uploadredirect": configuration.Parameters{},
+			"redirect":       configuration.Parameters{"uploads": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
 	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
 
-	// -----------------------------
-	// Attempt to fetch the manifest
-	resp, err := http.Get(manifestURL)
+	imageName, _ := reference.WithName("foo/redirected")
+	content := []byte("uploaded to the backend")
+	dgst := digest.FromBytes(content)
+
+	// Uploading content which does not match the digest is rejected by the
+	// backend.
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	resp, err := doPushLayer(t, env.builder, imageName, digest.FromString("other"), uploadURLBase, bytes.NewReader(content))
 	if err != nil {
-		t.Fatalf("unexpected error getting manifest: %v", err)
+		t.Fatalf("unexpected error pushing layer: %v", err)
 	}
-	defer resp.Body.Close()
-	checkResponse(t, "getting non-existent manifest", resp, expectedStatusCode)
-	// nolint:errcheck
-	checkBodyHasErrorCodes(t, "getting non-existent manifest", resp, expectedErrorCode)
-}
-
-func testManifestAPISchema2(t *testing.T, env *testEnv, imageName reference.Named, tag string) manifestArgs {
-	args := manifestArgs{
-		imageName: imageName,
-		mediaType: schema2.MediaTypeManifest,
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status uploading mismatched content: %s", resp.Status)
 	}
 
-	tagRef, _ := reference.WithTag(imageName, tag)
-	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	// The client follows the redirect, then completes the upload without
+	// content.
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	resp, err = doPushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(content))
 	if err != nil {
-		t.Fatalf("unexpected error getting manifest url: %v", err)
+		t.Fatalf("unexpected error pushing layer: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || driver.uploads != 1 {
+		t.Fatalf("unexpected status uploading to the backend: %s, %d uploads", resp.Status, driver.uploads)
 	}
 
-	// -----------------------------
-	// Attempt to fetch the manifest
-	resp, err := http.Get(manifestURL)
+	resp, err = doPushLayer(t, env.builder, imageName, dgst, uploadURLBase, nil)
 	if err != nil {
-		t.Fatalf("unexpected error getting manifest: %v", err)
+		t.Fatalf("unexpected error completing upload: %v", err)
 	}
 	defer resp.Body.Close()
+	checkResponse(t, "completing redirected upload", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
 
-	checkResponse(t, "getting non-existent manifest", resp, http.StatusNotFound)
-	// nolint:errcheck
-	checkBodyHasErrorCodes(t, "getting non-existent manifest", resp, errcode.ErrorCodeManifestUnknown)
-
-	tagsURL, err := env.builder.BuildTagsURL(imageName)
+	ref, _ := reference.WithDigest(imageName, dgst)
+	blobURL, err := env.builder.BuildBlobURL(ref)
 	if err != nil {
-		t.Fatalf("unexpected error building tags url: %v", err)
+		t.Fatalf("unexpected error building blob url: %v", err)
 	}
-
-	resp, err = http.Get(tagsURL)
+	resp, err = http.Get(blobURL)
 	if err != nil {
-		t.Fatalf("unexpected error getting unknown tags: %v", err)
+		t.Fatalf("unexpected error fetching blob: %v", err)
 	}
 	defer resp.Body.Close()
+	checkResponse(t, "fetching redirected blob", resp, http.StatusOK)
+	fetched, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error reading blob: %v", err)
+	}
+	if !bytes.Equal(fetched, content) {
+		t.Fatalf("unexpected blob content: %q", fetched)
+	}
+}
 
-	// Check that we get an unknown repository error when asking for tags
-	checkResponse(t, "getting unknown manifest tags", resp, http.StatusNotFound)
-	// nolint:errcheck
-	checkBodyHasErrorCodes(t, "getting unknown manifest tags", resp, errcode.ErrorCodeNameUnknown)
+func TestBlobRangeRequests(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
 
-	// --------------------------------
-	// Attempt to push manifest with missing config and missing layers
-	manifest := &schema2.Manifest{
-		Versioned: manifest.Versioned{
-			SchemaVersion: 2,
-			MediaType:     schema2.MediaTypeManifest,
-		},
-		Config: distribution.Descriptor{
-			Digest:    "sha256:1a9ec845ee94c202b2d5da74a24f0ed2058318bfa9879fa541efaecba272e86b",
-			Size:      3253,
-			MediaType: schema2.MediaTypeImageConfig,
-		},
-		Layers: []distribution.Descriptor{
-			{
-				Digest:    "sha256:463434349086340864309863409683460843608348608934092322395278926a",
-				Size:      6323,
-				MediaType: schema2.MediaTypeLayer,
-			},
-			{
-				Digest:    "sha256:630923423623623423352523525237238023652897356239852383652aaaaaaa",
-				Size:      6863,
-				MediaType: schema2.MediaTypeLayer,
-			},
-		},
+	imageName, _ := reference.WithName("foo/ranges")
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i)
 	}
+	dgst := digest.FromBytes(content)
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(content))
+
+	ref, _ := reference.WithDigest(imageName, dgst)
+	blobURL, err := env.builder.BuildBlobURL(ref)
+	if err != nil {
+		t.Fatalf("unexpected error building blob url: %v", err)
+	}
+	etag := fmt.Sprintf("%q", dgst)
+
+	for _, tc := range []struct {
+		name         string
+		header       http.Header
+		status       int
+		contentRange string
+		body         []byte
+	}{
+		{
+			name:         "resume from offset",
+			header:       http.Header{"Range": []string{"bytes=600-"}},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 600-999/1000",
+			body:         content[600:],
+		},
+		{
+			name:         "bounded range",
+			header:       http.Header{"Range": []string{"bytes=10-19"}},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 10-19/1000",
+			body:         content[10:20],
+		},
+		{
+			name:         "suffix range",
+			header:       http.Header{"Range": []string{"bytes=-10"}},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 990-999/1000",
+			body:         content[990:],
+		},
+		{
+			name:         "matching if-range",
+			header:       http.Header{"Range": []string{"bytes=600-"}, "If-Range": []string{etag}},
+			status:       http.StatusPartialContent,
+			contentRange: "bytes 600-999/1000",
+			body:         content[600:],
+		},
+		{
+			name:   "stale if-range",
+			header: http.Header{"Range": []string{"bytes=600-"}, "If-Range": []string{`"sha256:stale"`}},
+			status: http.StatusOK,
+			body:   content,
+		},
+		{
+			name:   "multiple ranges",
+			header: http.Header{"Range": []string{"bytes=0-9,20-29"}},
+			status: http.StatusOK,
+			body:   content,
+		},
+		{
+			name:         "unsatisfiable range",
+			header:       http.Header{"Range": []string{"bytes=1000-"}},
+			status:       http.StatusRequestedRangeNotSatisfiable,
+			contentRange: "bytes */1000",
+		},
+		{
+			name:   "invalid range",
+			header: http.Header{"Range": []string{"bytes=20-10"}},
+			status: http.StatusRequestedRangeNotSatisfiable,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, blobURL, nil)
+			if err != nil {
+				t.Fatalf("unexpected error creating request: %v", err)
+			}
+			req.Header = tc.header
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error fetching blob: %v", err)
+			}
+			defer resp.Body.Close()
+
+			checkResponse(t, "fetching blob range", resp, tc.status)
+			if got := resp.Header.Get("Content-Range"); got != tc.contentRange {
+				t.Fatalf("unexpected Content-Range: %q != %q", got, tc.contentRange)
+			}
+			if tc.body == nil {
+				return
+			}
+			if got := resp.Header.Get("Content-Length"); got != strconv.Itoa(len(tc.body)) {
+				t.Fatalf("unexpected Content-Length: %q != %d", got, len(tc.body))
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("unexpected error reading blob: %v", err)
+			}
+			if !bytes.Equal(body, tc.body) {
+				t.Fatalf("unexpected body of %d bytes, expected %d", len(body), len(tc.body))
+			}
+		})
+	}
+}
+
+// downloadRedirectDriver redirects every blob download to a fixed host.
+type downloadRedirectDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d *downloadRedirectDriver) RedirectURL(r *http.Request, path string) (string, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "", nil
+	}
+	return "https://backend.example.com" + path, nil
+}
+
+type downloadRedirectDriverFactory struct{}
+
+func (factory *downloadRedirectDriverFactory) Create(ctx context.Context, parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return &downloadRedirectDriver{StorageDriver: inmemory.New()}, nil
+}
+
+func TestBlobRedirectThreshold(t *testing.T) {
+	factory.Register("downloadredirect", &downloadRedirectDriverFactory{})
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	for _, tc := range []struct {
+		name       string
+		threshold  interface{}
+		size       int
+		redirected bool
+	}{
+		{name: "no threshold", size: 1, redirected: true},
+		{name: "zero threshold", threshold: 0, size: 1, redirected: true},
+		{name: "below threshold", threshold: 100, size: 99, redirected: false},
+		{name: "at threshold", threshold: 100, size: 100, redirected: true},
+		{name: "above threshold", threshold: 100, size: 101, redirected: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			redirect := configuration.Parameters{}
+			if tc.threshold != nil {
+				redirect["threshold"] = tc.threshold
+			}
+			config := configuration.Configuration{
+				Storage: configuration.Storage{
+					"downloadredirect": configuration.Parameters{},
+					"redirect":         redirect,
+					"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+						"enabled": false,
+					}},
+				},
+			}
+			env := newTestEnvWithConfig(t, &config)
+			defer env.Shutdown()
+
+			imageName, _ := reference.WithName("foo/threshold")
+			content := bytes.Repeat([]byte("a"), tc.size)
+			dgst := digest.FromBytes(content)
+			uploadURLBase, _ := startPushLayer(t, env, imageName)
+			pushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(content))
+
+			ref, _ := reference.WithDigest(imageName, dgst)
+			blobURL, err := env.builder.BuildBlobURL(ref)
+			if err != nil {
+				t.Fatalf("unexpected error building blob url: %v", err)
+			}
+			resp, err := client.Get(blobURL)
+			if err != nil {
+				t.Fatalf("unexpected error fetching blob: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if tc.redirected {
+				checkResponse(t, "fetching redirected blob", resp, http.StatusTemporaryRedirect)
+				if !strings.HasPrefix(resp.Header.Get("Location"), "https://backend.example.com/") {
+					t.Fatalf("unexpected Location header: %q", resp.Header.Get("Location"))
+				}
+				return
+			}
+			checkResponse(t, "fetching blob", resp, http.StatusOK)
+			if location := resp.Header.Get("Location"); location != "" {
+				t.Fatalf("unexpected Location header: %q", location)
+			}
+			fetched, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("unexpected error reading blob: %v", err)
+			}
+			if !bytes.Equal(fetched, content) {
+				t.Fatalf("unexpected blob content: %q", fetched)
+			}
+		})
+	}
+}
+
+func TestRequestBodyLimits(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.MaxRequestBodyBytes.Manifest = 1024
+	config.HTTP.MaxRequestBodyBytes.BlobChunk = 10
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/limits")
+	content := []byte("0123456789abcdefghijKLMNO")
+	dgst := digest.FromBytes(content)
+
+	// A blob larger than the limit is uploaded in chunks.
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	resp, err := doPushChunk(t, uploadURLBase, bytes.NewReader(content[:10]), chunkOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error pushing chunk: %v", err)
+	}
+	resp.Body.Close()
+	checkResponse(t, "pushing first chunk", resp, http.StatusAccepted)
+	uploadURLBase = resp.Header.Get("Location")
+
+	resp, err = doPushChunk(t, uploadURLBase, bytes.NewReader(content[10:21]), chunkOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error pushing chunk: %v", err)
+	}
+	checkResponse(t, "pushing chunk over the limit", resp, http.StatusRequestEntityTooLarge)
+	checkBodyHasErrorCodes(t, "pushing chunk over the limit", resp, errcode.ErrorCodeSizeExceeded)
+	resp.Body.Close()
+
+	resp, err = doPushChunk(t, uploadURLBase, bytes.NewReader(content[10:20]), chunkOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error pushing chunk: %v", err)
+	}
+	resp.Body.Close()
+	checkResponse(t, "pushing second chunk", resp, http.StatusAccepted)
+	checkHeaders(t, resp, http.Header{"Range": []string{"0-19"}})
+
+	resp, err = doPushLayer(t, env.builder, imageName, dgst, resp.Header.Get("Location"), bytes.NewReader(content[20:]))
+	if err != nil {
+		t.Fatalf("unexpected error completing upload: %v", err)
+	}
+	resp.Body.Close()
+	checkResponse(t, "completing upload", resp, http.StatusCreated)
+
+	// The limit also applies to chunks of unknown length, and to the data
+	// completing an upload.
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	resp, err = doPushChunk(t, uploadURLBase, io.MultiReader(bytes.NewReader(content)), chunkOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error pushing chunk: %v", err)
+	}
+	checkResponse(t, "pushing chunk of unknown length", resp, http.StatusRequestEntityTooLarge)
+	checkBodyHasErrorCodes(t, "pushing chunk of unknown length", resp, errcode.ErrorCodeSizeExceeded)
+	resp.Body.Close()
+
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	resp, err = doPushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("unexpected error pushing monolithic layer: %v", err)
+	}
+	checkResponse(t, "pushing monolithic layer", resp, http.StatusRequestEntityTooLarge)
+	checkBodyHasErrorCodes(t, "pushing monolithic layer", resp, errcode.ErrorCodeSizeExceeded)
+	resp.Body.Close()
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPut, manifestURL, bytes.NewReader(bytes.Repeat([]byte(" "), 1025)))
+	if err != nil {
+		t.Fatalf("unexpected error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", schema2.MediaTypeManifest)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+	checkResponse(t, "putting manifest over the limit", resp, http.StatusRequestEntityTooLarge)
+	checkBodyHasErrorCodes(t, "putting manifest over the limit", resp, errcode.ErrorCodeSizeExceeded)
+	resp.Body.Close()
+}
+
+// laggingUploadDriver simulates an eventually consistent storage shared by
+// registry instances: the next lag lookups of upload files miss.
+type laggingUploadDriver struct {
+	storagedriver.StorageDriver
+	lag atomic.Int32
+}
+
+func (d *laggingUploadDriver) lagging(path string) error {
+	if strings.Contains(path, "/_uploads/") && d.lag.Add(-1) >= 0 {
+		return storagedriver.PathNotFoundError{Path: path, DriverName: d.Name()}
+	}
+	return nil
+}
+
+func (d *laggingUploadDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if err := d.lagging(path); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *laggingUploadDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	if err := d.lagging(path); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.Stat(ctx, path)
+}
+
+func (d *laggingUploadDriver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	if err := d.lagging(path); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.Writer(ctx, path, append)
+}
+
+type laggingUploadDriverFactory struct {
+	driver *laggingUploadDriver
+}
+
+func (factory *laggingUploadDriverFactory) Create(ctx context.Context, parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return factory.driver, nil
+}
+
+// onInstance returns uploadURL on the registry instance of env.
+func onInstance(t *testing.T, env *testEnv, uploadURL string) string {
+	u, err := url.Parse(uploadURL)
+	if err != nil {
+		t.Fatalf("error parsing upload url: %v", err)
+	}
+	base, err := url.Parse(env.server.URL)
+	if err != nil {
+		t.Fatalf("error parsing server URL: %v", err)
+	}
+	u.Scheme, u.Host = base.Scheme, base.Host
+	return u.String()
+}
+
+// TestBlobUploadResumeOtherInstance pushes the chunks of an upload to
+// registry instances sharing a secret and an eventually consistent storage,
+// as a load balancer without session affinity does.
+func TestBlobUploadResumeOtherInstance(t *testing.T) {
+	driver := &laggingUploadDriver{StorageDriver: inmemory.New()}
+	factory.Register("laggingupload", &laggingUploadDriverFactory{driver: driver})
+
+	newInstance := func(resume configuration.UploadResume) *testEnv {
+		config := configuration.Configuration{
+			Storage: configuration.Storage{
+				"laggingupload": configuration.Parameters{},
+				"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+					"enabled": false,
+				}},
+			},
+		}
+		config.HTTP.Secret = "shared by the instances"
+		config.HTTP.UploadResume = resume
+		return newTestEnvWithConfig(t, &config)
+	}
+	first := newInstance(configuration.UploadResume{})
+	defer first.Shutdown()
+	second := newInstance(configuration.UploadResume{})
+	defer second.Shutdown()
+	disabled := newInstance(configuration.UploadResume{Disabled: true})
+	defer disabled.Shutdown()
+
+	imageName, _ := reference.WithName("foo/affinity")
+	chunks := [][]byte{[]byte("first chunk"), []byte("second chunk"), []byte("third chunk")}
+	dgst := digest.FromBytes(bytes.Join(chunks, nil))
+
+	uploadURLBase, _ := startPushLayer(t, first, imageName)
+	offset := int64(len(chunks[0]))
+	uploadURLBase, _ = pushChunk(t, first.builder, imageName, uploadURLBase, bytes.NewReader(chunks[0]), offset)
+
+	// The storage does not reflect the upload on the second instance yet,
+	// which trusts the upload state until it does.
+	driver.lag.Store(3)
+	offset += int64(len(chunks[1]))
+	uploadURLBase, _ = pushChunk(t, second.builder, imageName, onInstance(t, second, uploadURLBase), bytes.NewReader(chunks[1]), offset)
+
+	// Without upload resume, the upload is unknown until the storage
+	// reflects it.
+	driver.lag.Store(1)
+	resp, err := doPushChunk(t, onInstance(t, disabled, uploadURLBase), bytes.NewReader(chunks[2]), chunkOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error pushing chunk: %v", err)
+	}
+	checkResponse(t, "pushing chunk to an instance without upload resume", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "pushing chunk to an instance without upload resume", resp, errcode.ErrorCodeBlobUploadUnknown)
+	resp.Body.Close()
+
+	offset += int64(len(chunks[2]))
+	uploadURLBase, _ = pushChunk(t, disabled.builder, imageName, onInstance(t, disabled, uploadURLBase), bytes.NewReader(chunks[2]), offset)
+	finishUpload(t, first.builder, imageName, onInstance(t, first, uploadURLBase), dgst)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.RequestTimeout.Write = 500 * time.Millisecond
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/timeout")
+	content := []byte("0123456789abcdefghij")
+	dgst := digest.FromBytes(content)
+
+	// A client stalling in the middle of a chunk is cut off by the deadline
+	// of the request, and the upload kept with the data received.
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		_, _ = pw.Write(content[:10])
+	}()
+	resp, err := doPushChunk(t, uploadURLBase, pr, chunkOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error pushing chunk: %v", err)
+	}
+	checkResponse(t, "pushing stalled chunk", resp, http.StatusServiceUnavailable)
+	checkBodyHasErrorCodes(t, "pushing stalled chunk", resp, errcode.ErrorCodeUnavailable)
+	resp.Body.Close()
+	checkHeaders(t, resp, http.Header{"Range": []string{"0-9"}})
+
+	// The upload is resumed from the range returned.
+	uploadURLBase, _ = pushChunk(t, env.builder, imageName, resp.Header.Get("Location"), bytes.NewReader(content[10:]), int64(len(content)))
+	finishUpload(t, env.builder, imageName, uploadURLBase, dgst)
+}
+
+// readCountingDriver counts the reads of blob data.
+type readCountingDriver struct {
+	storagedriver.StorageDriver
+	reads atomic.Int64
+}
+
+func (d *readCountingDriver) count(path string) {
+	if strings.Contains(path, "/blobs/") && strings.HasSuffix(path, "/data") {
+		d.reads.Add(1)
+	}
+}
+
+func (d *readCountingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	d.count(path)
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *readCountingDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	d.count(path)
+	return d.StorageDriver.Reader(ctx, path, offset)
+}
+
+// TestManifestHeadReads ensures that HEAD requests for manifests are answered
+// without reading them.
+func TestManifestHeadReads(t *testing.T) {
+	driver := &readCountingDriver{StorageDriver: inmemory.New()}
+	factory.Register("readcountinginmemory", &clockedDriverFactory{driver: driver})
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"readcountinginmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/headreads")
+	dgst := createRepository(env, t, imageName.Name(), "latest")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+
+	for _, ref := range []reference.Reference{tagRef, digestRef} {
+		manifestURL, err := env.builder.BuildManifestURL(ref.(reference.Named))
+		checkErr(t, err, "building manifest url")
+
+		driver.reads.Store(0)
+		req, err := http.NewRequest(http.MethodHead, manifestURL, nil)
+		checkErr(t, err, "building request")
+		req.Header.Set("Accept", schema2.MediaTypeManifest)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "checking manifest")
+		resp.Body.Close()
+		checkResponse(t, "checking manifest", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Content-Type":          []string{schema2.MediaTypeManifest},
+			"Docker-Content-Digest": []string{dgst.String()},
+		})
+		if reads := driver.reads.Load(); reads != 0 {
+			t.Fatalf("%s: expected no manifest reads, got %d", ref, reads)
+		}
+
+		// GET requests still read the manifest.
+		req.Method = http.MethodGet
+		resp, err = http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching manifest")
+		resp.Body.Close()
+		checkResponse(t, "fetching manifest", resp, http.StatusOK)
+		if reads := driver.reads.Load(); reads == 0 {
+			t.Fatalf("%s: expected the manifest to be read", ref)
+		}
+	}
+}
+
+// stallingDriver stalls GetContent for stall, returning early with the error
+// of the context if it is done.
+type stallingDriver struct {
+	storagedriver.StorageDriver
+	stall atomic.Int64
+}
+
+func (d *stallingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	select {
+	case <-time.After(time.Duration(d.stall.Load())):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+// TestStorageTimeouts ensures that the earlier of the storage and request
+// deadlines cuts a request off, and that the failure tells which passed.
+func TestStorageTimeouts(t *testing.T) {
+	driver := &stallingDriver{StorageDriver: inmemory.New()}
+	factory.Register("stallinginmemory", &clockedDriverFactory{driver: driver})
+
+	newEnv := func(storageTimeout, requestTimeout time.Duration) *testEnv {
+		config := configuration.Configuration{
+			Storage: configuration.Storage{
+				"stallinginmemory": configuration.Parameters{},
+				"timeouts":         configuration.Parameters{"read": storageTimeout.String()},
+				"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+					"enabled": false,
+				}},
+			},
+		}
+		config.HTTP.RequestTimeout.Read = requestTimeout
+		return newTestEnvWithConfig(t, &config)
+	}
+	storageFirst := newEnv(100*time.Millisecond, time.Hour)
+	defer storageFirst.Shutdown()
+	requestFirst := newEnv(time.Hour, 100*time.Millisecond)
+	defer requestFirst.Shutdown()
+
+	imageName, _ := reference.WithName("foo/stalling")
+	createRepository(storageFirst, t, imageName.Name(), "latest")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+
+	driver.stall.Store(int64(5 * time.Second))
+	for _, tc := range []struct {
+		name   string
+		env    *testEnv
+		detail interface{}
+		header http.Header
+	}{
+		{
+			name:   "storage deadline first",
+			env:    storageFirst,
+			detail: map[string]interface{}{"category": "timeout"},
+			header: http.Header{"Retry-After": []string{"1"}},
+		},
+		{
+			name:   "request deadline first",
+			env:    requestFirst,
+			detail: "request deadline exceeded",
+		},
+	} {
+		manifestURL, err := tc.env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+		start := time.Now()
+		resp, err := http.Get(manifestURL)
+		checkErr(t, err, "fetching manifest")
+		defer resp.Body.Close()
+		if elapsed := time.Since(start); elapsed > 4*time.Second {
+			t.Fatalf("%s: request took %v", tc.name, elapsed)
+		}
+		checkResponse(t, tc.name, resp, http.StatusServiceUnavailable)
+		if tc.header != nil {
+			checkHeaders(t, resp, tc.header)
+		}
+		var errs errcode.Errors
+		if err := json.NewDecoder(resp.Body).Decode(&errs); err != nil {
+			t.Fatalf("%s: error decoding errors: %v", tc.name, err)
+		}
+		if len(errs) != 1 {
+			t.Fatalf("%s: unexpected errors: %v", tc.name, errs)
+		}
+		e, ok := errs[0].(errcode.Error)
+		if !ok || e.Code != errcode.ErrorCodeUnavailable || !reflect.DeepEqual(e.Detail, tc.detail) {
+			t.Fatalf("%s: unexpected error: %#v", tc.name, errs[0])
+		}
+	}
+}
+
+// putUploadComplete completes the upload at uploadURLBase with the final
+// chunk body, described by contentRange if it is not empty.
+func putUploadComplete(t *testing.T, uploadURLBase string, dgst digest.Digest, body []byte, contentRange string) *http.Response {
+	u, err := url.Parse(uploadURLBase)
+	if err != nil {
+		t.Fatalf("unexpected error parsing upload url: %v", err)
+	}
+	q := u.Query()
+	q.Set("digest", dgst.String())
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if contentRange != "" {
+		req.Header.Set("Content-Range", contentRange)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error completing upload: %v", err)
+	}
+	return resp
+}
+
+func TestBlobUploadChunkedConformance(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.ChunkMinLength = 4
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/chunked")
+	content := []byte("0123456789abcdefghijKLMNOPQRSTUVWXYZ")
+	dgst := digest.FromBytes(content)
+
+	pushChunk := func(msg, uploadURLBase string, chunk []byte, contentRange string, status int) *http.Response {
+		resp, err := doPushChunk(t, uploadURLBase, bytes.NewReader(chunk), chunkOptions{contentRange: contentRange})
+		if err != nil {
+			t.Fatalf("unexpected error %s: %v", msg, err)
+		}
+		resp.Body.Close()
+		checkResponse(t, msg, resp, status)
+		return resp
+	}
+
+	t.Run("chunk min length", func(t *testing.T) {
+		layerUploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+		if err != nil {
+			t.Fatalf("unexpected error building upload url: %v", err)
+		}
+		resp, err := http.Post(layerUploadURL, "", nil)
+		if err != nil {
+			t.Fatalf("unexpected error starting upload: %v", err)
+		}
+		resp.Body.Close()
+		checkResponse(t, "starting upload", resp, http.StatusAccepted)
+		checkHeaders(t, resp, http.Header{"OCI-Chunk-Min-Length": []string{"4"}})
+
+		resp = pushChunk("pushing chunk", resp.Header.Get("Location"), content[:10], "0-9", http.StatusAccepted)
+		checkHeaders(t, resp, http.Header{"OCI-Chunk-Min-Length": []string{"4"}})
+	})
+
+	t.Run("out of order chunks", func(t *testing.T) {
+		uploadURLBase, uploadUUID := startPushLayer(t, env, imageName)
+		resp := pushChunk("pushing first chunk", uploadURLBase, content[:10], "0-9", http.StatusAccepted)
+		uploadURLBase = resp.Header.Get("Location")
+
+		for _, contentRange := range []string{"20-29", "0-9", "5-14"} {
+			resp, err := doPushChunk(t, uploadURLBase, bytes.NewReader(content[10:20]), chunkOptions{contentRange: contentRange})
+			if err != nil {
+				t.Fatalf("unexpected error pushing out of order chunk: %v", err)
+			}
+			checkResponse(t, "pushing out of order chunk "+contentRange, resp, http.StatusRequestedRangeNotSatisfiable)
+			checkHeaders(t, resp, http.Header{
+				"Range":              []string{"0-9"},
+				"Docker-Upload-UUID": []string{uploadUUID},
+			})
+			checkBodyHasErrorCodes(t, "pushing out of order chunk", resp, errcode.ErrorCodeRangeInvalid)
+			resp.Body.Close()
+		}
+
+		// The client resumes from the range of the error response.
+		resp = pushChunk("pushing second chunk", uploadURLBase, content[10:20], "10-19", http.StatusAccepted)
+		checkHeaders(t, resp, http.Header{"Range": []string{"0-19"}})
+		uploadURLBase = resp.Header.Get("Location")
+
+		// The final chunk is checked as well.
+		resp = putUploadComplete(t, uploadURLBase, dgst, content[25:], "25-35")
+		checkResponse(t, "completing upload out of order", resp, http.StatusRequestedRangeNotSatisfiable)
+		checkHeaders(t, resp, http.Header{
+			"Range":              []string{"0-19"},
+			"Docker-Upload-UUID": []string{uploadUUID},
+		})
+		resp.Body.Close()
+	})
+
+	t.Run("complete with trailing data", func(t *testing.T) {
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		resp := pushChunk("pushing chunk", uploadURLBase, content[:20], "0-19", http.StatusAccepted)
+
+		resp = putUploadComplete(t, resp.Header.Get("Location"), dgst, content[20:], fmt.Sprintf("20-%d", len(content)-1))
+		resp.Body.Close()
+		checkResponse(t, "completing upload with trailing data", resp, http.StatusCreated)
+		checkHeaders(t, resp, http.Header{"Docker-Content-Digest": []string{dgst.String()}})
+	})
+
+	t.Run("complete with trailing data without range", func(t *testing.T) {
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		resp := pushChunk("pushing chunk", uploadURLBase, content[:20], "", http.StatusAccepted)
+
+		resp = putUploadComplete(t, resp.Header.Get("Location"), dgst, content[20:], "")
+		resp.Body.Close()
+		checkResponse(t, "completing upload with trailing data", resp, http.StatusCreated)
+	})
+
+	t.Run("complete with empty body", func(t *testing.T) {
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		resp := pushChunk("pushing chunk", uploadURLBase, content, "", http.StatusAccepted)
+
+		resp = putUploadComplete(t, resp.Header.Get("Location"), dgst, nil, "")
+		resp.Body.Close()
+		checkResponse(t, "completing upload with empty body", resp, http.StatusCreated)
+		checkHeaders(t, resp, http.Header{
+			"Content-Length":        []string{"0"},
+			"Docker-Content-Digest": []string{dgst.String()},
+		})
+	})
+}
+
+type failingAuditSink struct{}
+
+func (failingAuditSink) Write(ctx context.Context, record auditRecord) error {
+	return errors.New("audit sink unavailable")
+}
+
+func TestRepositoryNamePolicy(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Validation.Repositories.MaxComponents = 3
+	config.Validation.Repositories.AllowPatterns = []string{"team/*", "admin/*"}
+	config.Validation.Repositories.DenyPatterns = []string{"admin/*"}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	createRepository(env, t, "team/app", "latest")
+
+	for name, reason := range map[string]string{
+		"team/app/build/cache": "4 path components",
+		"admin/tools":          `denied pattern "admin/*"`,
+		"other/app":            "does not match any allowed pattern",
+	} {
+		imageName, _ := reference.WithName(name)
+		layerUploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+		checkErr(t, err, "building upload url")
+		resp, err := http.Post(layerUploadURL, "", nil)
+		checkErr(t, err, "starting upload")
+		checkResponse(t, "starting upload to "+name, resp, http.StatusBadRequest)
+
+		var errs errcode.Errors
+		err = json.NewDecoder(resp.Body).Decode(&errs)
+		resp.Body.Close()
+		checkErr(t, err, "decoding error response")
+		if len(errs) != 1 {
+			t.Fatalf("expected one error pushing to %s, got %v", name, errs)
+		}
+		nameErr, ok := errs[0].(errcode.Error)
+		if !ok || nameErr.Code != errcode.ErrorCodeNameInvalid || !strings.Contains(fmt.Sprint(nameErr.Detail), reason) {
+			t.Fatalf("unexpected error pushing to %s: %#v", name, errs[0])
+		}
+	}
+}
+
+func TestManifestCanonicalForm(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Validation.Manifests.Canonical.Repositories = []string{"strict/*"}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	// pushManifest pushes the blobs of a manifest to the repository and
+	// returns its indented and canonical payloads.
+	pushManifest := func(name string) (reference.Named, []byte, []byte) {
+		imageName, _ := reference.WithName(name)
+		configBlob := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers"}}`)
+		configDigest := digest.FromBytes(configBlob)
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		pushLayer(t, env.builder, imageName, configDigest, uploadURLBase, bytes.NewReader(configBlob))
+		rs, layerDigest, err := testutil.CreateRandomTarFile()
+		checkErr(t, err, "creating random layer")
+		uploadURLBase, _ = startPushLayer(t, env, imageName)
+		pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, rs)
+
+		m, err := schema2.FromStruct(schema2.Manifest{
+			Versioned: schema2.SchemaVersion,
+			Config:    distribution.Descriptor{MediaType: schema2.MediaTypeImageConfig, Digest: configDigest, Size: int64(len(configBlob))},
+			Layers:    []distribution.Descriptor{{MediaType: schema2.MediaTypeLayer, Digest: layerDigest, Size: 1}},
+		})
+		checkErr(t, err, "creating manifest")
+		_, indented, err := m.Payload()
+		checkErr(t, err, "getting manifest payload")
+		canonical, err := manifest.Canonicalize(indented)
+		checkErr(t, err, "canonicalizing manifest")
+		return imageName, indented, canonical
+	}
+	putPayload := func(msg string, imageName reference.Named, payload []byte) *http.Response {
+		tagRef, _ := reference.WithTag(imageName, "latest")
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+		req, err := http.NewRequest(http.MethodPut, manifestURL, bytes.NewReader(payload))
+		checkErr(t, err, "creating request")
+		req.Header.Set("Content-Type", schema2.MediaTypeManifest)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, msg)
+		return resp
+	}
+
+	t.Run("equivalent manifest warning", func(t *testing.T) {
+		imageName, indented, canonical := pushManifest("foo/equivalent")
+
+		resp := putPayload("putting indented manifest", imageName, indented)
+		resp.Body.Close()
+		checkResponse(t, "putting indented manifest", resp, http.StatusCreated)
+		if warning := resp.Header.Get("Warning"); warning != "" {
+			t.Fatalf("unexpected warning: %s", warning)
+		}
+
+		resp = putPayload("putting canonical manifest", imageName, canonical)
+		resp.Body.Close()
+		checkResponse(t, "putting canonical manifest", resp, http.StatusCreated)
+		checkHeaders(t, resp, http.Header{
+			"Docker-Content-Digest": []string{digest.FromBytes(canonical).String()},
+			"Warning":               []string{fmt.Sprintf(`299 - "manifest is identical to %s, previously tagged latest, but serialized differently"`, digest.FromBytes(indented))},
+		})
+
+		// Pushing the same payload again is not a duplicate.
+		resp = putPayload("putting canonical manifest again", imageName, canonical)
+		resp.Body.Close()
+		checkResponse(t, "putting canonical manifest again", resp, http.StatusCreated)
+		if warning := resp.Header.Get("Warning"); warning != "" {
+			t.Fatalf("unexpected warning: %s", warning)
+		}
+	})
+
+	t.Run("canonical form required", func(t *testing.T) {
+		imageName, indented, canonical := pushManifest("strict/app")
+
+		resp := putPayload("putting indented manifest", imageName, indented)
+		defer resp.Body.Close()
+		checkResponse(t, "putting indented manifest", resp, http.StatusBadRequest)
+		var errs errcode.Errors
+		checkErr(t, json.NewDecoder(resp.Body).Decode(&errs), "decoding error response")
+		if len(errs) != 1 {
+			t.Fatalf("expected one error, got %v", errs)
+		}
+		canonicalErr, ok := errs[0].(errcode.Error)
+		if !ok || canonicalErr.Code != errcode.ErrorCodeManifestInvalid || !strings.Contains(fmt.Sprint(canonicalErr.Detail), digest.FromBytes(canonical).String()) {
+			t.Fatalf("unexpected error: %#v", errs[0])
+		}
+
+		resp = putPayload("putting canonical manifest", imageName, canonical)
+		resp.Body.Close()
+		checkResponse(t, "putting canonical manifest", resp, http.StatusCreated)
+	})
+}
+
+func TestManifestMediaTypeRules(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Validation.Manifests.MediaTypes = map[string]configuration.ManifestMediaTypes{
+		"charts/*": {
+			Manifests: []string{v1.MediaTypeImageManifest},
+			Configs:   []string{"application/vnd.cncf.helm.config.v1+json"},
+		},
+		"images/*": {
+			Manifests: []string{v1.MediaTypeImageManifest, v1.MediaTypeImageIndex},
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	ociManifest, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+		Config:    distribution.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: digest.FromString("config"), Size: 6},
+		Layers:    []distribution.Descriptor{{MediaType: v1.MediaTypeImageLayerGzip, Digest: digest.FromString("layer"), Size: 5}},
+	})
+	checkErr(t, err, "creating manifest")
+	index, err := ocischema.FromDescriptors([]distribution.Descriptor{
+		{MediaType: v1.MediaTypeImageManifest, Digest: digest.FromString("child"), Size: 42},
+		{MediaType: schema2.MediaTypeManifest, Digest: digest.FromString("docker child"), Size: 42},
+	}, nil)
+	checkErr(t, err, "creating index")
+
+	for _, tc := range []struct {
+		repository string
+		manifest   distribution.Manifest
+		detail     string
+	}{
+		{
+			repository: "charts/nginx",
+			manifest:   ociManifest,
+			detail:     `config media type "application/vnd.oci.image.config.v1+json" is not allowed, allowed media types: application/vnd.cncf.helm.config.v1+json`,
+		},
+		{
+			repository: "charts/nginx",
+			manifest:   index,
+			detail:     `manifest media type "application/vnd.oci.image.index.v1+json" is not allowed, allowed media types: application/vnd.oci.image.manifest.v1+json`,
+		},
+		{
+			repository: "images/nginx",
+			manifest:   index,
+			detail:     `referenced manifest media type "application/vnd.docker.distribution.manifest.v2+json" is not allowed`,
+		},
+	} {
+		imageName, _ := reference.WithName(tc.repository)
+		tagRef, _ := reference.WithTag(imageName, "latest")
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+		mediaType, payload, err := tc.manifest.Payload()
+		checkErr(t, err, "getting manifest payload")
+
+		req, err := http.NewRequest(http.MethodPut, manifestURL, bytes.NewReader(payload))
+		checkErr(t, err, "creating request")
+		req.Header.Set("Content-Type", mediaType)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "putting manifest")
+		checkResponse(t, "putting "+mediaType+" to "+tc.repository, resp, http.StatusBadRequest)
+
+		var errs errcode.Errors
+		err = json.NewDecoder(resp.Body).Decode(&errs)
+		resp.Body.Close()
+		checkErr(t, err, "decoding error response")
+		if len(errs) != 1 {
+			t.Fatalf("expected one error putting %s to %s, got %v", mediaType, tc.repository, errs)
+		}
+		mediaTypeErr, ok := errs[0].(errcode.Error)
+		if !ok || mediaTypeErr.Code != errcode.ErrorCodeManifestInvalid || !strings.Contains(fmt.Sprint(mediaTypeErr.Detail), tc.detail) {
+			t.Fatalf("unexpected error putting %s to %s: %#v", mediaType, tc.repository, errs[0])
+		}
+	}
+}
+
+func TestLayerMediaTypes(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("images/zstd")
+	pushBlob := func(content []byte) distribution.Descriptor {
+		dgst := digest.FromBytes(content)
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		pushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(content))
+		return distribution.Descriptor{Digest: dgst, Size: int64(len(content))}
+	}
+	configDesc := pushBlob([]byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`))
+	configDesc.MediaType = v1.MediaTypeImageConfig
+	zstdLayer := pushBlob([]byte("\x28\xb5\x2f\xfd zstd compressed layer"))
+	zstdLayer.MediaType = storage.MediaTypeImageLayerZstd
+
+	putOCIManifest := func(tag string, layers ...distribution.Descriptor) *http.Response {
+		m, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+			Config:    configDesc,
+			Layers:    layers,
+		})
+		checkErr(t, err, "creating manifest")
+		tagRef, _ := reference.WithTag(imageName, tag)
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+		return putManifest(t, "putting manifest", manifestURL, v1.MediaTypeImageManifest, m)
+	}
+
+	// Layers compressed with zstd are accepted by default.
+	resp := putOCIManifest("zstd", zstdLayer)
+	defer resp.Body.Close()
+	checkResponse(t, "putting zstd manifest", resp, http.StatusCreated)
+
+	tagRef, _ := reference.WithTag(imageName, "zstd")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+	checkErr(t, err, "creating request")
+	req.Header.Set("Accept", v1.MediaTypeImageManifest)
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "getting manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "getting zstd manifest", resp, http.StatusOK)
+
+	// Unknown layer media types are rejected.
+	unknownLayer := zstdLayer
+	unknownLayer.MediaType = "application/vnd.example.layer"
+	resp = putOCIManifest("unknown", unknownLayer)
+	defer resp.Body.Close()
+	checkBodyHasErrorCodes(t, "putting manifest with unknown layer media type", resp, errcode.ErrorCodeManifestInvalid)
+}
+
+func TestManifestWarnings(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Warnings = configuration.Warnings{
+		Schema1:       "schema1 manifests are deprecated, upgrade your client to pull {{.Repository}}",
+		NoAnnotations: "manifest {{.Digest}} has no annotations",
+		TagPulls: []configuration.TagPullWarning{{
+			Repositories: []string{"library"},
+			Tags:         []string{"latest"},
+			Message:      `pull {{.Repository}} by digest rather than "{{.Tag}}"`,
+		}},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	libraryDigest := createRepository(env, t, "library/app", "latest")
+	createRepository(env, t, "other/app", "latest")
+
+	getManifest := func(msg, repository, tagOrDigest string, accept ...string) *http.Response {
+		named, _ := reference.WithName(repository)
+		var ref reference.Named
+		if dgst, err := digest.Parse(tagOrDigest); err == nil {
+			ref, _ = reference.WithDigest(named, dgst)
+		} else {
+			ref, _ = reference.WithTag(named, tagOrDigest)
+		}
+		manifestURL, err := env.builder.BuildManifestURL(ref)
+		checkErr(t, err, "building manifest url")
+		req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+		checkErr(t, err, "creating request")
+		for _, mediaType := range accept {
+			req.Header.Add("Accept", mediaType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, msg)
+		resp.Body.Close()
+		checkResponse(t, msg, resp, http.StatusOK)
+		return resp
+	}
+	checkWarnings := func(msg string, resp *http.Response, expected ...string) {
+		t.Helper()
+		if warnings := resp.Header.Values("Warning"); !reflect.DeepEqual(warnings, expected) {
+			t.Fatalf("%s: unexpected warnings: %q != %q", msg, warnings, expected)
+		}
+	}
+
+	tagWarning := `299 - "pull library/app by digest rather than \"latest\""`
+	resp := getManifest("getting by tag", "library/app", "latest", schema2.MediaTypeManifest)
+	checkWarnings("getting by tag", resp, tagWarning)
+	resp = getManifest("getting by digest", "library/app", libraryDigest.String(), schema2.MediaTypeManifest)
+	checkWarnings("getting by digest", resp)
+	resp = getManifest("getting by tag from another repository", "other/app", "latest", schema2.MediaTypeManifest)
+	checkWarnings("getting by tag from another repository", resp)
+
+	// Clients accepting only schema1 manifests are warned, without changing
+	// the response.
+	resp = getManifest("getting as schema1 client", "library/app", "latest", mediaTypeSchema1SignedManifest)
+	checkWarnings("getting as schema1 client", resp, tagWarning, `299 - "schema1 manifests are deprecated, upgrade your client to pull library/app"`)
+
+	named, _ := reference.WithName("other/app")
+	tagRef, _ := reference.WithTag(named, "schema1")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp = putManifest(t, "putting schema1 manifest", manifestURL, mediaTypeSchema1Manifest, map[string]interface{}{"schemaVersion": 1})
+	defer resp.Body.Close()
+	checkResponse(t, "putting schema1 manifest", resp, http.StatusBadRequest)
+	checkWarnings("putting schema1 manifest", resp, `299 - "schema1 manifests are deprecated, upgrade your client to pull other/app"`)
+
+	// OCI manifests pushed without annotations are warned.
+	configBlob := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`)
+	configDigest := digest.FromBytes(configBlob)
+	uploadURLBase, _ := startPushLayer(t, env, named)
+	pushLayer(t, env.builder, named, configDigest, uploadURLBase, bytes.NewReader(configBlob))
+	for _, annotations := range []map[string]string{nil, {"org.opencontainers.image.source": "https://example.com/app"}} {
+		m, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned:   manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+			Config:      distribution.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: configDigest, Size: int64(len(configBlob))},
+			Annotations: annotations,
+		})
+		checkErr(t, err, "creating manifest")
+		_, payload, err := m.Payload()
+		checkErr(t, err, "getting manifest payload")
+		tagRef, _ := reference.WithTag(named, "oci")
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building manifest url")
+		resp := putManifest(t, "putting oci manifest", manifestURL, v1.MediaTypeImageManifest, m)
+		defer resp.Body.Close()
+		checkResponse(t, "putting oci manifest", resp, http.StatusCreated)
+		if annotations == nil {
+			checkWarnings("putting oci manifest without annotations", resp, fmt.Sprintf(`299 - "manifest %s has no annotations"`, digest.FromBytes(payload)))
+		} else {
+			checkWarnings("putting oci manifest with annotations", resp)
+		}
+	}
+}
+
+func TestImmutableTags(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Validation.Enabled = true
+	config.Validation.Tags.Immutable = map[string][]string{
+		"prod/*": {"v*"},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("prod/app")
+	immutableDigest := createRepository(env, t, imageName.Name(), "v1")
+	otherDigest := createRepository(env, t, imageName.Name(), "latest")
+
+	tagRef, _ := reference.WithTag(imageName, "v1")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	putByTag := func(dgst digest.Digest) *http.Response {
+		digestRef, _ := reference.WithDigest(imageName, dgst)
+		digestURL, err := env.builder.BuildManifestURL(digestRef)
+		checkErr(t, err, "building manifest url")
+		req, err := http.NewRequest(http.MethodGet, digestURL, nil)
+		checkErr(t, err, "creating request")
+		req.Header.Set("Accept", schema2.MediaTypeManifest)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching manifest")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching manifest", resp, http.StatusOK)
+		payload, err := io.ReadAll(resp.Body)
+		checkErr(t, err, "reading manifest")
+
+		req, err = http.NewRequest(http.MethodPut, tagURL, bytes.NewReader(payload))
+		checkErr(t, err, "creating request")
+		req.Header.Set("Content-Type", schema2.MediaTypeManifest)
+		resp, err = http.DefaultClient.Do(req)
+		checkErr(t, err, "putting manifest")
+		return resp
+	}
+
+	// Pushing the same manifest again succeeds.
+	resp := putByTag(immutableDigest)
+	checkResponse(t, "pushing the same manifest to an immutable tag", resp, http.StatusCreated)
+	resp.Body.Close()
+
+	resp = putByTag(otherDigest)
+	checkResponse(t, "pushing another manifest to an immutable tag", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "pushing another manifest to an immutable tag", resp, errcode.ErrorCodeTagImmutable)
+	resp.Body.Close()
+
+	resp, err = http.Head(tagURL)
+	checkErr(t, err, "checking tag")
+	resp.Body.Close()
+	checkResponse(t, "checking tag", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{immutableDigest.String()},
+	})
+}
+
+func TestTagDelete(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		actions []string
+	)
+	listener := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var envelope struct {
+			Events []struct {
+				Action string `json:"action"`
+			} `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, event := range envelope.Events {
+			if strings.HasSuffix(event.Action, "delete") {
+				actions = append(actions, event.Action)
+			}
+		}
+	}))
+	defer listener.Close()
+
+	newEnv := func(tagDelete bool) *testEnv {
+		config := configuration.Configuration{
+			Storage: configuration.Storage{
+				"inmemory": configuration.Parameters{},
+				"delete":   configuration.Parameters{"enabled": true},
+				"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+					"enabled": false,
+				}},
+			},
+			Notifications: configuration.Notifications{
+				Endpoints: []configuration.Endpoint{
+					{Name: "listener", URL: listener.URL, Timeout: time.Second, Threshold: 1, Backoff: 100 * time.Millisecond},
+				},
+			},
+		}
+		config.HTTP.APIs.TagDelete.Disabled = !tagDelete
+		return newTestEnvWithConfig(t, &config)
+	}
+
+	imageName, _ := reference.WithName("foo/untagged")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+
+	env := newEnv(false)
+	createRepository(env, t, imageName.Name(), "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp, err := httpDelete(manifestURL)
+	checkErr(t, err, "deleting tag")
+	resp.Body.Close()
+	checkResponse(t, "deleting tag while disabled", resp, http.StatusMethodNotAllowed)
+	tagURL, err := env.builder.BuildTagURL(tagRef)
+	checkErr(t, err, "building tag url")
+	resp, err = httpDelete(tagURL)
+	checkErr(t, err, "deleting tag")
+	resp.Body.Close()
+	checkResponse(t, "deleting tag while disabled", resp, http.StatusNotFound)
+	env.Shutdown()
+
+	env = newEnv(true)
+	defer env.Shutdown()
+	manifestURL, err = env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	for _, build := range []func(reference.NamedTagged) (string, error){
+		env.builder.BuildTagURL,
+		func(ref reference.NamedTagged) (string, error) { return env.builder.BuildManifestURL(ref) },
+	} {
+		dgst := createRepository(env, t, imageName.Name(), "latest")
+		deleteURL, err := build(tagRef)
+		checkErr(t, err, "building url")
+		resp, err := httpDelete(deleteURL)
+		checkErr(t, err, "deleting tag")
+		resp.Body.Close()
+		checkResponse(t, "deleting tag", resp, http.StatusAccepted)
+
+		resp, err = http.Head(manifestURL)
+		checkErr(t, err, "checking deleted tag")
+		resp.Body.Close()
+		checkResponse(t, "checking deleted tag", resp, http.StatusNotFound)
+
+		// The manifest is left in place.
+		digestRef, _ := reference.WithDigest(imageName, dgst)
+		digestURL, err := env.builder.BuildManifestURL(digestRef)
+		checkErr(t, err, "building manifest url")
+		resp, err = http.Head(digestURL)
+		checkErr(t, err, "checking manifest")
+		resp.Body.Close()
+		checkResponse(t, "checking manifest", resp, http.StatusOK)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		got := append([]string(nil), actions...)
+		mu.Unlock()
+		if len(got) >= 2 || time.Now().After(deadline) {
+			if !reflect.DeepEqual(got, []string{"tag.delete", "tag.delete"}) {
+				t.Fatalf("expected tag.delete events, got %v", got)
+			}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The tag named list is deleted through the path of the tags listing,
+	// which is still served.
+	listRef, _ := reference.WithTag(imageName, "list")
+	createRepository(env, t, imageName.Name(), "list")
+	listURL, err := env.builder.BuildTagURL(listRef)
+	checkErr(t, err, "building tag url")
+	resp, err = httpDelete(listURL)
+	checkErr(t, err, "deleting tag list")
+	resp.Body.Close()
+	checkResponse(t, "deleting tag list", resp, http.StatusAccepted)
+	manifestURL, err = env.builder.BuildManifestURL(listRef)
+	checkErr(t, err, "building manifest url")
+	resp, err = http.Head(manifestURL)
+	checkErr(t, err, "checking deleted tag")
+	resp.Body.Close()
+	checkResponse(t, "checking deleted tag", resp, http.StatusNotFound)
+	resp, err = http.Get(listURL)
+	checkErr(t, err, "listing tags")
+	resp.Body.Close()
+	checkResponse(t, "listing tags", resp, http.StatusOK)
+}
+
+type clockedDriverFactory struct {
+	driver storagedriver.StorageDriver
+}
+
+func (factory *clockedDriverFactory) Create(ctx context.Context, parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return factory.driver, nil
+}
+
+func TestTagDetails(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		now = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	)
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	factory.Register("clockedinmemory", &clockedDriverFactory{driver: inmemory.NewWithParameters(inmemory.DriverParameters{Clock: clock})})
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"clockedinmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/described")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	tagDetailsURL, err := env.builder.BuildTagDetailsURL(tagRef)
+	checkErr(t, err, "building tag details url")
+
+	resp, err := http.Get(tagDetailsURL)
+	checkErr(t, err, "fetching tag details")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching unknown tag details", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching unknown tag details", resp, errcode.ErrorCodeManifestUnknown)
+
+	// Push two manifests under the tag, a day apart.
+	var expected []tagHistoryAPIEntry
+	for i := 0; i < 2; i++ {
+		mu.Lock()
+		now = now.Add(24 * time.Hour)
+		mu.Unlock()
+		dgst := createRepository(env, t, imageName.Name(), "latest")
+		expected = append(expected, tagHistoryAPIEntry{Digest: dgst, Tagged: clock()})
+	}
+
+	resp, err = http.Get(tagDetailsURL)
+	checkErr(t, err, "fetching tag details")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching tag details", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Type":  []string{"application/json"},
+		"Last-Modified": []string{clock().Format(http.TimeFormat)},
+	})
+	var details tagDetailsAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+		t.Fatalf("error decoding tag details: %v", err)
+	}
+	if details.Name != imageName.Name() || details.Tag != "latest" || details.Digest != expected[1].Digest || !details.LastModified.Equal(clock()) {
+		t.Fatalf("unexpected tag details: %+v", details)
+	}
+	if !reflect.DeepEqual(details.History, expected) {
+		t.Fatalf("unexpected tag history: %+v, expected %+v", details.History, expected)
+	}
+}
+
+func TestTagsResolve(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/resolved")
+	dgst := createRepository(env, t, imageName.Name(), "latest")
+
+	resolveURL, err := env.builder.BuildTagsResolveURL(imageName)
+	checkErr(t, err, "building tags resolve url")
+
+	resolve := func(body string) *http.Response {
+		resp, err := http.Post(resolveURL, "application/json", strings.NewReader(body))
+		checkErr(t, err, "resolving tags")
+		return resp
+	}
+
+	resp := resolve(`["latest", "missing", "latest"]`)
+	defer resp.Body.Close()
+	checkResponse(t, "resolving tags", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Type": []string{"application/json"},
+	})
+	var resolved tagsResolveAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&resolved); err != nil {
+		t.Fatalf("error decoding resolved tags: %v", err)
+	}
+	if resolved.Name != imageName.Name() {
+		t.Fatalf("unexpected name: %q", resolved.Name)
+	}
+	entry, ok := resolved.Tags["latest"]
+	if len(resolved.Tags) != 1 || !ok || entry.Digest != dgst || entry.MediaType != schema2.MediaTypeManifest || entry.Size == 0 {
+		t.Fatalf("unexpected resolved tags: %+v", resolved.Tags)
+	}
+	if !reflect.DeepEqual(resolved.Unknown, []string{"missing"}) {
+		t.Fatalf("unexpected unknown tags: %v", resolved.Unknown)
+	}
+
+	for _, body := range []string{`{"tags": ["latest"]}`, `["la:test"]`} {
+		resp := resolve(body)
+		defer resp.Body.Close()
+		checkResponse(t, "resolving invalid tags", resp, http.StatusBadRequest)
+		checkBodyHasErrorCodes(t, "resolving invalid tags", resp, errcode.ErrorCodeTagInvalid)
+	}
+
+	tags := make([]string, maxResolveTags+1)
+	for i := range tags {
+		tags[i] = fmt.Sprintf("t%d", i)
+	}
+	p, err := json.Marshal(tags)
+	checkErr(t, err, "marshaling tags")
+	resp = resolve(string(p))
+	defer resp.Body.Close()
+	checkResponse(t, "resolving too many tags", resp, http.StatusRequestEntityTooLarge)
+	checkBodyHasErrorCodes(t, "resolving too many tags", resp, errcode.ErrorCodeSizeExceeded)
+}
+
+func TestUploads(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/uploading")
+	uploadsURL, err := env.builder.BuildUploadsURL(imageName)
+	checkErr(t, err, "building uploads url")
+
+	getUploads := func() []distribution.BlobUpload {
+		t.Helper()
+		resp, err := http.Get(uploadsURL)
+		checkErr(t, err, "fetching uploads")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching uploads", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Content-Type": []string{"application/json"},
+		})
+		var listed uploadsAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+			t.Fatalf("error decoding uploads: %v", err)
+		}
+		if listed.Name != imageName.Name() {
+			t.Fatalf("unexpected name: %q", listed.Name)
+		}
+		return listed.Uploads
+	}
+
+	if uploads := getUploads(); len(uploads) != 0 {
+		t.Fatalf("expected no uploads, got %+v", uploads)
+	}
+
+	uploadURLBase, uploadUUID := startPushLayer(t, env, imageName)
+	layer := []byte("some layer data")
+	pushChunk(t, env.builder, imageName, uploadURLBase, bytes.NewReader(layer), int64(len(layer)))
+	_, idleUUID := startPushLayer(t, env, imageName)
+
+	uploads := getUploads()
+	if len(uploads) != 2 {
+		t.Fatalf("expected 2 uploads, got %+v", uploads)
+	}
+	for _, upload := range uploads {
+		size := int64(0)
+		if upload.ID == uploadUUID {
+			size = int64(len(layer))
+		} else if upload.ID != idleUUID {
+			t.Fatalf("unexpected upload: %+v", upload)
+		}
+		if upload.Size != size || upload.StartedAt.IsZero() || upload.LastActivity.Before(upload.StartedAt) {
+			t.Fatalf("unexpected upload: %+v", upload)
+		}
+	}
+
+	uploadURL, err := env.builder.BuildUploadURL(imageName, uploadUUID)
+	checkErr(t, err, "building upload url")
+	resp, err := httpDelete(uploadURL)
+	checkErr(t, err, "cancelling upload")
+	defer resp.Body.Close()
+	checkResponse(t, "cancelling upload", resp, http.StatusNoContent)
+
+	uploads = getUploads()
+	if len(uploads) != 1 || uploads[0].ID != idleUUID {
+		t.Fatalf("expected the idle upload only, got %+v", uploads)
+	}
+
+	resp, err = httpDelete(uploadURL)
+	checkErr(t, err, "cancelling cancelled upload")
+	defer resp.Body.Close()
+	checkResponse(t, "cancelling cancelled upload", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "cancelling cancelled upload", resp, errcode.ErrorCodeBlobUploadUnknown)
+}
+
+func TestReferrers(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Referrers: configuration.Referrers{
+			PageSize: 2,
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/referred")
+	subject := createRepository(env, t, imageName.Name(), "latest")
+	subjectRef, _ := reference.WithDigest(imageName, subject)
+
+	getReferrers := func(referrersURL string) (*http.Response, referrersAPIResponse) {
+		t.Helper()
+		resp, err := http.Get(referrersURL)
+		checkErr(t, err, "fetching referrers")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching referrers", resp, http.StatusOK)
+		var index referrersAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+			t.Fatalf("error decoding referrers: %v", err)
+		}
+		if index.SchemaVersion != 2 || index.MediaType != v1.MediaTypeImageIndex || resp.Header.Get("Content-Type") != v1.MediaTypeImageIndex {
+			t.Fatalf("unexpected referrers index: %+v", index)
+		}
+		return resp, index
+	}
+
+	referrersURL, err := env.builder.BuildReferrersURL(subjectRef)
+	checkErr(t, err, "building referrers url")
+	if _, index := getReferrers(referrersURL); len(index.Manifests) != 0 {
+		t.Fatalf("expected no referrers, got %+v", index.Manifests)
+	}
+
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	emptyConfig := []byte("{}")
+	configDesc := distribution.Descriptor{
+		MediaType: "application/vnd.oci.empty.v1+json",
+		Digest:    digest.FromBytes(emptyConfig),
+		Size:      int64(len(emptyConfig)),
+	}
+	pushLayer(t, env.builder, imageName, configDesc.Digest, uploadURLBase, bytes.NewReader(emptyConfig))
+
+	digests := []digest.Digest{}
+	for _, artifactType := range []string{"application/vnd.example.signature", "application/vnd.example.sbom", "application/vnd.example.signature"} {
+		m, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned:    manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+			ArtifactType: artifactType,
+			Config:       configDesc,
+			Layers:       []distribution.Descriptor{},
+			Subject:      &distribution.Descriptor{MediaType: schema2.MediaTypeManifest, Digest: subject, Size: 1},
+			Annotations:  map[string]string{"org.example.index": strconv.Itoa(len(digests))},
+		})
+		checkErr(t, err, "creating referrer")
+		_, payload, _ := m.Payload()
+		dgst := digest.FromBytes(payload)
+		ref, _ := reference.WithDigest(imageName, dgst)
+		manifestURL, err := env.builder.BuildManifestURL(ref)
+		checkErr(t, err, "building manifest url")
+		resp := putManifest(t, "putting referrer", manifestURL, v1.MediaTypeImageManifest, m)
+		defer resp.Body.Close()
+		checkResponse(t, "putting referrer", resp, http.StatusCreated)
+		checkHeaders(t, resp, http.Header{
+			"OCI-Subject": []string{subject.String()},
+		})
+		digests = append(digests, dgst)
+	}
+	sorted := append([]digest.Digest{}, digests...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	// The referrers are listed by digest, a page at a time.
+	resp, index := getReferrers(referrersURL)
+	if len(index.Manifests) != 2 || index.Manifests[0].Digest != sorted[0] || index.Manifests[1].Digest != sorted[1] {
+		t.Fatalf("unexpected first page of referrers: %+v", index.Manifests)
+	}
+	if resp.Header.Get("OCI-Filters-Applied") != "" {
+		t.Fatalf("unexpected filters applied: %q", resp.Header.Get("OCI-Filters-Applied"))
+	}
+	re := regexp.MustCompile("<(/v2/foo/referred/referrers/.*)>; rel=\"next\"")
+	matches := re.FindStringSubmatch(resp.Header.Get("Link"))
+	if len(matches) != 2 {
+		t.Fatalf("unexpected link header: %q", resp.Header.Get("Link"))
+	}
+	resp, index = getReferrers(env.server.URL + matches[1])
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != sorted[2] || resp.Header.Get("Link") != "" {
+		t.Fatalf("unexpected last page of referrers: %+v", index.Manifests)
+	}
+
+	// The referrers are filtered by artifact type.
+	referrersURL, err = env.builder.BuildReferrersURL(subjectRef, url.Values{"artifactType": []string{"application/vnd.example.sbom"}})
+	checkErr(t, err, "building referrers url")
+	resp, index = getReferrers(referrersURL)
+	checkHeaders(t, resp, http.Header{
+		"OCI-Filters-Applied": []string{"artifactType"},
+	})
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != digests[1] || index.Manifests[0].ArtifactType != "application/vnd.example.sbom" || index.Manifests[0].Annotations["org.example.index"] != "1" {
+		t.Fatalf("unexpected sbom referrers: %+v", index.Manifests)
+	}
+
+	// Invalid digests are rejected.
+	resp, err = http.Get(env.server.URL + "/v2/foo/referred/referrers/sha256:abc")
+	checkErr(t, err, "fetching referrers")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching referrers of an invalid digest", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "fetching referrers of an invalid digest", resp, errcode.ErrorCodeDigestInvalid)
+}
+
+func TestUploadLimits(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Limits.UploadsPerRepository = 1
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	limited, _ := reference.WithName("foo/limited")
+	other, _ := reference.WithName("foo/other")
+
+	// An upload resumed while the limit is reached.
+	pendingURLBase, _ := startPushLayer(t, env, limited)
+
+	// Hold a chunk upload open until its body is closed.
+	uploadURLBase, _ := startPushLayer(t, env, limited)
+	pr, pw := io.Pipe()
+	done := make(chan *http.Response)
+	go func() {
+		resp, err := doPushChunk(t, uploadURLBase, pr, chunkOptions{})
+		if err != nil {
+			t.Errorf("unexpected error pushing chunk: %v", err)
+		}
+		done <- resp
+	}()
+	// The write returns once the handler reads the body.
+	if _, err := pw.Write([]byte("some data")); err != nil {
+		t.Fatal(err)
+	}
+
+	uploadURL, err := env.builder.BuildBlobUploadURL(limited)
+	checkErr(t, err, "building upload url")
+	resp, err := http.Post(uploadURL, "", nil)
+	checkErr(t, err, "starting upload")
+	defer resp.Body.Close()
+	checkResponse(t, "starting a concurrent upload", resp, http.StatusTooManyRequests)
+	checkHeaders(t, resp, http.Header{"Retry-After": []string{"1"}})
+	checkBodyHasErrorCodes(t, "starting a concurrent upload", resp, errcode.ErrorCodeTooManyRequests)
+
+	// Rejecting an upload does not resume it.
+	resp, err = doPushChunk(t, pendingURLBase, strings.NewReader("pending data"), chunkOptions{})
+	checkErr(t, err, "resuming upload")
+	resp.Body.Close()
+	checkResponse(t, "resuming a concurrent upload", resp, http.StatusTooManyRequests)
+
+	// Other repositories are not limited.
+	startPushLayer(t, env, other)
+
+	pw.Close()
+	if resp := <-done; resp != nil {
+		resp.Body.Close()
+		checkResponse(t, "pushing chunk", resp, http.StatusAccepted)
+	}
+	startPushLayer(t, env, limited)
+	resp, err = doPushChunk(t, pendingURLBase, strings.NewReader("pending data"), chunkOptions{})
+	checkErr(t, err, "resuming upload")
+	resp.Body.Close()
+	checkResponse(t, "resuming the upload", resp, http.StatusAccepted)
+}
+
+func TestListingCache(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"cache":    configuration.Parameters{"listing": "inmemory", "listingttl": "1h"},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Catalog: configuration.Catalog{
+			MaxEntries: 5,
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/cached")
+	tagsURL, err := env.builder.BuildTagsURL(imageName)
+	checkErr(t, err, "building tags url")
+	catalogURL, err := env.builder.BuildCatalogURL()
+	checkErr(t, err, "building catalog url")
+
+	expectTags := func(cache string, expected ...string) {
+		t.Helper()
+		resp, err := http.Get(tagsURL)
+		checkErr(t, err, "listing tags")
+		defer resp.Body.Close()
+		checkResponse(t, "listing tags", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{"X-Cache": []string{cache}})
+		var body tagsAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("error decoding tags: %v", err)
+		}
+		if !reflect.DeepEqual(body.Tags, expected) {
+			t.Fatalf("unexpected tags: %v, expected %v", body.Tags, expected)
+		}
+	}
+	expectCatalog := func(cache string, expected ...string) {
+		t.Helper()
+		resp, err := http.Get(catalogURL)
+		checkErr(t, err, "listing repositories")
+		defer resp.Body.Close()
+		checkResponse(t, "listing repositories", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{"X-Cache": []string{cache}})
+		var body catalogAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("error decoding catalog: %v", err)
+		}
+		if !reflect.DeepEqual(body.Repositories, expected) {
+			t.Fatalf("unexpected repositories: %v, expected %v", body.Repositories, expected)
+		}
+	}
+
+	createRepository(env, t, imageName.Name(), "v1")
+	expectTags("miss", "v1")
+	expectTags("hit", "v1")
+	expectCatalog("miss", imageName.Name())
+	expectCatalog("hit", imageName.Name())
+
+	// Tagging invalidates the tags of the repository.
+	createRepository(env, t, imageName.Name(), "v2")
+	expectTags("miss", "v1", "v2")
+	expectTags("hit", "v1", "v2")
+
+	// So does deleting a tag.
+	tagRef, _ := reference.WithTag(imageName, "v1")
+	tagURL, err := env.builder.BuildTagURL(tagRef)
+	checkErr(t, err, "building tag url")
+	resp, err := httpDelete(tagURL)
+	checkErr(t, err, "deleting tag")
+	resp.Body.Close()
+	checkResponse(t, "deleting tag", resp, http.StatusAccepted)
+	expectTags("miss", "v2")
+
+	// A new repository invalidates the catalog.
+	createRepository(env, t, "foo/other", "latest")
+	expectCatalog("miss", imageName.Name(), "foo/other")
+	expectCatalog("hit", imageName.Name(), "foo/other")
+	expectTags("hit", "v2")
+}
+
+func TestAuditLog(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"delete":   configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Audit.Enabled = true
+	config.Audit.Sink = "storage"
+	config.Audit.Storage.RootDirectory = "/auditlog"
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/audited")
+	manifestDigest := createRepository(env, t, imageName.Name(), "latest")
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp, err := httpDelete(tagURL)
+	checkErr(t, err, "deleting tag")
+	resp.Body.Close()
+	checkResponse(t, "deleting tag", resp, http.StatusAccepted)
+
+	digestRef, _ := reference.WithDigest(imageName, manifestDigest)
+	manifestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+	resp, err = httpDelete(manifestURL)
+	checkErr(t, err, "deleting manifest")
+	resp.Body.Close()
+	checkResponse(t, "deleting manifest", resp, http.StatusAccepted)
+
+	content := []byte("audited blob")
+	blobDigest := digest.FromBytes(content)
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	blobURL := pushLayer(t, env.builder, imageName, blobDigest, uploadURLBase, bytes.NewReader(content))
+	resp, err = httpDelete(blobURL)
+	checkErr(t, err, "deleting blob")
+	resp.Body.Close()
+	checkResponse(t, "deleting blob", resp, http.StatusAccepted)
+
+	var paths []string
+	err = env.app.driver.Walk(env.ctx, "/auditlog", func(fi storagedriver.FileInfo) error {
+		if !fi.IsDir() {
+			paths = append(paths, fi.Path())
+		}
+		return nil
+	})
+	checkErr(t, err, "walking audit log")
+	sort.Strings(paths)
+
+	var records []auditRecord
+	for _, p := range paths {
+		content, err := env.app.driver.GetContent(env.ctx, p)
+		checkErr(t, err, "reading audit record")
+		var record auditRecord
+		checkErr(t, json.Unmarshal(content, &record), "decoding audit record")
+		records = append(records, record)
+	}
+
+	expected := []struct {
+		action string
+		digest digest.Digest
+		tag    string
+	}{
+		{action: auditActionBlobPut},
+		{action: auditActionBlobPut},
+		{action: auditActionManifestPut, digest: manifestDigest, tag: "latest"},
+		{action: auditActionTagDelete, tag: "latest"},
+		{action: auditActionManifestDelete, digest: manifestDigest},
+		{action: auditActionBlobPut, digest: blobDigest},
+		{action: auditActionBlobDelete, digest: blobDigest},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("unexpected audit records: %+v", records)
+	}
+	for i, e := range expected {
+		r := records[i]
+		if r.Action != e.action || (e.digest != "" && r.Digest != e.digest) || r.Tag != e.tag {
+			t.Errorf("unexpected audit record %d: %+v", i, r)
+		}
+		if r.Repository != imageName.Name() || r.ClientIP == "" || r.RequestID == "" || r.UserAgent == "" || r.Timestamp.IsZero() {
+			t.Errorf("incomplete audit record %d: %+v", i, r)
+		}
+	}
+
+	// A failure to write a record only fails the request if the audit log
+	// fails closed.
+	for _, failClosed := range []bool{false, true} {
+		env.app.auditor = &auditor{sink: failingAuditSink{}, failClosed: failClosed}
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		resp, err := doPushLayer(t, env.builder, imageName, blobDigest, uploadURLBase, bytes.NewReader(content))
+		checkErr(t, err, "pushing layer")
+		if failClosed {
+			checkResponse(t, "pushing layer with failing audit log", resp, http.StatusServiceUnavailable)
+			checkBodyHasErrorCodes(t, "pushing layer with failing audit log", resp, errcode.ErrorCodeUnavailable)
+		} else {
+			checkResponse(t, "pushing layer with failing audit log", resp, http.StatusCreated)
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestManifestConditionalGet(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/conditional")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+
+	getManifest := func(ifNoneMatch string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %v", err)
+		}
+		req.Header.Set("Accept", schema2.MediaTypeManifest)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error fetching manifest: %v", err)
+		}
+		return resp
+	}
+
+	dgst := createRepository(env, t, imageName.Name(), "latest")
+	etag := fmt.Sprintf(`"%s"`, dgst)
+
+	for _, tc := range []struct {
+		name        string
+		ifNoneMatch string
+		status      int
+	}{
+		{name: "match", ifNoneMatch: etag, status: http.StatusNotModified},
+		{name: "unquoted match", ifNoneMatch: dgst.String(), status: http.StatusNotModified},
+		{name: "weak match", ifNoneMatch: "W/" + etag, status: http.StatusNotModified},
+		{name: "list match", ifNoneMatch: `"sha256:other", ` + etag, status: http.StatusNotModified},
+		{name: "wildcard", ifNoneMatch: "*", status: http.StatusNotModified},
+		{name: "mismatch", ifNoneMatch: `"sha256:other"`, status: http.StatusOK},
+		{name: "weak mismatch", ifNoneMatch: `W/"sha256:other"`, status: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := getManifest(tc.ifNoneMatch)
+			defer resp.Body.Close()
+
+			checkResponse(t, "fetching manifest with If-None-Match", resp, tc.status)
+			checkHeaders(t, resp, http.Header{
+				"Docker-Content-Digest": []string{dgst.String()},
+				"ETag":                  []string{etag},
+			})
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("unexpected error reading body: %v", err)
+			}
+			if tc.status == http.StatusNotModified && len(body) != 0 {
+				t.Fatalf("unexpected body in not modified response: %q", body)
+			}
+			if tc.status == http.StatusOK && digest.FromBytes(body) != dgst {
+				t.Fatalf("unexpected manifest body")
+			}
+		})
+	}
+
+	// Moving the tag invalidates the copy held by the client.
+	movedDgst := createRepository(env, t, imageName.Name(), "latest")
+	resp := getManifest(etag)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching moved tag with stale etag", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{movedDgst.String()},
+		"ETag":                  []string{fmt.Sprintf(`"%s"`, movedDgst)},
+	})
+}
+
+func TestManifestDelete(t *testing.T) {
+	schema2Repo, _ := reference.WithName("foo/schema2")
+
+	deleteEnabled := true
+	env := newTestEnv(t, deleteEnabled)
+	defer env.Shutdown()
+	schema2Args := testManifestAPISchema2(t, env, schema2Repo, "schema2tag")
+	testManifestDelete(t, env, schema2Args)
+}
+
+func TestManifestSoftDelete(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"delete": configuration.Parameters{
+				"enabled":    true,
+				"softdelete": map[interface{}]interface{}{"enabled": true},
+			},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/softdelete")
+	dgst := createRepository(env, t, imageName.Name(), "latest")
+
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	manifestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+	resp, err := httpDelete(manifestURL)
+	checkErr(t, err, "deleting manifest")
+	resp.Body.Close()
+	checkResponse(t, "deleting manifest", resp, http.StatusAccepted)
+
+	resp, err = http.Head(manifestURL)
+	checkErr(t, err, "checking deleted manifest")
+	resp.Body.Close()
+	checkResponse(t, "checking deleted manifest", resp, http.StatusNotFound)
+
+	repo, err := env.app.registry.Repository(env.ctx, imageName)
+	checkErr(t, err, "getting repository")
+	checkErr(t, storage.Restore(env.ctx, repo, dgst.String()), "restoring manifest")
+	checkErr(t, storage.Restore(env.ctx, repo, "latest"), "restoring tag")
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp, err = http.Head(tagURL)
+	checkErr(t, err, "checking restored tag")
+	resp.Body.Close()
+	checkResponse(t, "checking restored tag", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{dgst.String()},
+	})
+}
+
+func TestVerifyOnRead(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":     configuration.Parameters{},
+			"verifyonread": configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	corrupt := func(dgst digest.Digest) {
+		p := path.Join("/docker/registry/v2/blobs", dgst.Algorithm().String(), dgst.Encoded()[:2], dgst.Encoded(), "data")
+		content, err := env.app.driver.GetContent(env.ctx, p)
+		checkErr(t, err, "reading blob")
+		checkErr(t, env.app.driver.PutContent(env.ctx, p, bytes.Repeat([]byte{'x'}, len(content))), "corrupting blob")
+	}
+
+	imageName, _ := reference.WithName("foo/verified")
+	manifestDigest := createRepository(env, t, imageName.Name(), "latest")
+	content := []byte("verified blob")
+	blobDigest := digest.FromBytes(content)
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	blobURL := pushLayer(t, env.builder, imageName, blobDigest, uploadURLBase, bytes.NewReader(content))
+
+	resp, err := http.Get(blobURL)
+	checkErr(t, err, "fetching blob")
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	checkErr(t, err, "reading blob")
+	checkResponse(t, "fetching blob", resp, http.StatusOK)
+	if !bytes.Equal(body, content) {
+		t.Fatalf("unexpected verified blob content: %q", body)
+	}
+
+	corrupt(manifestDigest)
+	corrupt(blobDigest)
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+	checkErr(t, err, "creating request")
+	req.Header.Set("Accept", schema2.MediaTypeManifest)
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching corrupt manifest")
+	checkResponse(t, "fetching corrupt manifest", resp, http.StatusInternalServerError)
+	checkBodyHasErrorCodes(t, "fetching corrupt manifest", resp, errcode.ErrorCodeContentCorrupt)
+	resp.Body.Close()
+
+	// The response of a corrupt blob is aborted once written. As the blob
+	// is small, nothing of the response is sent.
+	resp, err = http.Get(blobURL)
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		t.Fatal("expected an error fetching a corrupt blob")
+	}
+}
+
+func TestBlobHeaders(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"blobs":    configuration.Parameters{"cachemaxage": "1h", "attachments": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/headers")
+	content := []byte("attached blob")
+	blobDigest := digest.FromBytes(content)
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	blobURL := pushLayer(t, env.builder, imageName, blobDigest, uploadURLBase, bytes.NewReader(content))
+
+	resp, err := http.Get(blobURL)
+	checkErr(t, err, "fetching blob")
+	resp.Body.Close()
+	checkResponse(t, "fetching blob", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Cache-Control": []string{"max-age=3600"},
+	})
+	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
+		t.Fatalf("unexpected Content-Disposition: %q", cd)
+	}
+
+	resp, err = http.Get(blobURL + "?attach=true")
+	checkErr(t, err, "fetching blob attachment")
+	resp.Body.Close()
+	checkResponse(t, "fetching blob attachment", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Cache-Control":       []string{"max-age=3600"},
+		"Content-Disposition": []string{`attachment; filename="` + blobDigest.String() + `"`},
+	})
+}
+
+func TestManifestDeleteDisabled(t *testing.T) {
+	schema2Repo, _ := reference.WithName("foo/schema2")
+	deleteEnabled := false
+	env := newTestEnv(t, deleteEnabled)
+	defer env.Shutdown()
+	testManifestDeleteDisabled(t, env, schema2Repo)
+}
+
+func testManifestDeleteDisabled(t *testing.T, env *testEnv, imageName reference.Named) {
+	ref, _ := reference.WithDigest(imageName, digestSha256EmptyTar)
+	manifestURL, err := env.builder.BuildManifestURL(ref)
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest url: %v", err)
+	}
+
+	resp, err := httpDelete(manifestURL)
+	if err != nil {
+		t.Fatalf("unexpected error deleting manifest %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "status of disabled delete of manifest", resp, http.StatusMethodNotAllowed)
+}
+
+func testManifestWithStorageError(t *testing.T, env *testEnv, imageName reference.Named, expectedStatusCode int, expectedErrorCode errcode.ErrorCode) {
+	tag := "latest"
+	tagRef, _ := reference.WithTag(imageName, tag)
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest url: %v", err)
+	}
+
+	// -----------------------------
+	// Attempt to fetch the manifest
+	resp, err := http.Get(manifestURL)
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting non-existent manifest", resp, expectedStatusCode)
+	// nolint:errcheck
+	checkBodyHasErrorCodes(t, "getting non-existent manifest", resp, expectedErrorCode)
+}
+
+func testManifestAPISchema2(t *testing.T, env *testEnv, imageName reference.Named, tag string) manifestArgs {
+	args := manifestArgs{
+		imageName: imageName,
+		mediaType: schema2.MediaTypeManifest,
+	}
+
+	tagRef, _ := reference.WithTag(imageName, tag)
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest url: %v", err)
+	}
+
+	// -----------------------------
+	// Attempt to fetch the manifest
+	resp, err := http.Get(manifestURL)
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "getting non-existent manifest", resp, http.StatusNotFound)
+	// nolint:errcheck
+	checkBodyHasErrorCodes(t, "getting non-existent manifest", resp, errcode.ErrorCodeManifestUnknown)
+
+	tagsURL, err := env.builder.BuildTagsURL(imageName)
+	if err != nil {
+		t.Fatalf("unexpected error building tags url: %v", err)
+	}
+
+	resp, err = http.Get(tagsURL)
+	if err != nil {
+		t.Fatalf("unexpected error getting unknown tags: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Check that we get an unknown repository error when asking for tags
+	checkResponse(t, "getting unknown manifest tags", resp, http.StatusNotFound)
+	// nolint:errcheck
+	checkBodyHasErrorCodes(t, "getting unknown manifest tags", resp, errcode.ErrorCodeNameUnknown)
+
+	// --------------------------------
+	// Attempt to push manifest with missing config and missing layers
+	manifest := &schema2.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     schema2.MediaTypeManifest,
+		},
+		Config: distribution.Descriptor{
+			Digest:    "sha256:1a9ec845ee94c202b2d5da74a24f0ed2058318bfa9879fa541efaecba272e86b",
+			Size:      3253,
+			MediaType: schema2.MediaTypeImageConfig,
+		},
+		Layers: []distribution.Descriptor{
+			{
+				Digest:    "sha256:463434349086340864309863409683460843608348608934092322395278926a",
+				Size:      6323,
+				MediaType: schema2.MediaTypeLayer,
+			},
+			{
+				Digest:    "sha256:630923423623623423352523525237238023652897356239852383652aaaaaaa",
+				Size:      6863,
+				MediaType: schema2.MediaTypeLayer,
+			},
+		},
+	}
+
+	resp = putManifest(t, "putting missing config manifest", manifestURL, schema2.MediaTypeManifest, manifest)
+	defer resp.Body.Close()
+	checkResponse(t, "putting missing config manifest", resp, http.StatusBadRequest)
+	_, p, counts := checkBodyHasErrorCodes(t, "putting missing config manifest", resp, errcode.ErrorCodeManifestBlobUnknown)
 
-	resp = putManifest(t, "putting missing config manifest", manifestURL, schema2.MediaTypeManifest, manifest)
-	defer resp.Body.Close()
-	checkResponse(t, "putting missing config manifest", resp, http.StatusBadRequest)
-	_, p, counts := checkBodyHasErrorCodes(t, "putting missing config manifest", resp, errcode.ErrorCodeManifestBlobUnknown)
-
 	expectedCounts := map[errcode.ErrorCode]int{
 		errcode.ErrorCodeManifestBlobUnknown: 3,
 	}
@@ -2251,8 +4806,6 @@ func newTestEnv(t *testing.T, deleteEnabled bool) *testEnv {
 		},
 	}
 
-	config.HTTP.Headers = headerConfig
-
 	return newTestEnvWithConfig(t, &config)
 }
 
@@ -2518,15 +5071,6 @@ func checkResponse(t *testing.T, msg string, resp *http.Response, expectedStatus
 		maybeDumpResponse(t, resp)
 		t.FailNow()
 	}
-
-	// We expect the headers included in the configuration, unless the
-	// status code is 405 (Method Not Allowed), which means the handler
-	// doesn't even get called.
-	if resp.StatusCode != 405 && !reflect.DeepEqual(resp.Header["X-Content-Type-Options"], []string{"nosniff"}) {
-		t.Logf("missing or incorrect header X-Content-Type-Options %s", msg)
-		maybeDumpResponse(t, resp)
-		t.FailNow()
-	}
 }
 
 // checkBodyHasErrorCodes ensures the body is an error body and has the
@@ -2805,7 +5349,6 @@ func TestProxyManifestGetByTag(t *testing.T) {
 			}},
 		},
 	}
-	truthConfig.HTTP.Headers = headerConfig
 
 	imageName, _ := reference.WithName("foo/bar")
 	tag := "latest"
@@ -2823,7 +5366,6 @@ func TestProxyManifestGetByTag(t *testing.T) {
 			RemoteURL: truthEnv.server.URL,
 		},
 	}
-	proxyConfig.HTTP.Headers = headerConfig
 
 	proxyEnv := newTestEnvWithConfig(t, &proxyConfig)
 	defer proxyEnv.Shutdown()
@@ -2863,3 +5405,67 @@ func TestProxyManifestGetByTag(t *testing.T) {
 		"Docker-Content-Digest": []string{newDigest.String()},
 	})
 }
+
+func TestProxyManifestHead(t *testing.T) {
+	truthConfig := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+
+	imageName, _ := reference.WithName("foo/bar")
+	tag := "latest"
+
+	truthEnv := newTestEnvWithConfig(t, &truthConfig)
+	defer truthEnv.Shutdown()
+	dgst := createRepository(truthEnv, t, imageName.Name(), tag)
+
+	proxyConfig := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Proxy: configuration.Proxy{
+			RemoteURL: truthEnv.server.URL,
+		},
+	}
+
+	proxyEnv := newTestEnvWithConfig(t, &proxyConfig)
+	defer proxyEnv.Shutdown()
+
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	manifestDigestURL, err := proxyEnv.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+
+	req, err := http.NewRequest(http.MethodHead, manifestDigestURL, nil)
+	checkErr(t, err, "building manifest head request")
+	req.Header.Set("Accept", schema2.MediaTypeManifest)
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "heading manifest from proxy by digest")
+	defer resp.Body.Close()
+	checkResponse(t, "heading manifest from proxy by digest", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Type":          []string{schema2.MediaTypeManifest},
+		"Docker-Content-Digest": []string{dgst.String()},
+		"Etag":                  []string{fmt.Sprintf(`"%s"`, dgst)},
+	})
+	if resp.ContentLength <= 0 {
+		t.Fatalf("unexpected content length heading manifest: %d", resp.ContentLength)
+	}
+
+	// The manifest is described by the remote, not fetched into the cache.
+	revisionPath := fmt.Sprintf("/docker/registry/v2/repositories/%s/_manifests/revisions/%s/%s/link", imageName.Name(), dgst.Algorithm(), dgst.Encoded())
+	if _, err := proxyEnv.app.driver.Stat(proxyEnv.ctx, revisionPath); err == nil {
+		t.Fatal("expected manifest not to be cached by a HEAD request")
+	}
+
+	unknownRef, _ := reference.WithDigest(imageName, digest.FromString("unknown"))
+	unknownURL, err := proxyEnv.builder.BuildManifestURL(unknownRef)
+	checkErr(t, err, "building manifest url")
+	resp, err = http.Head(unknownURL)
+	checkErr(t, err, "heading unknown manifest from proxy")
+	defer resp.Body.Close()
+	checkResponse(t, "heading unknown manifest from proxy", resp, http.StatusNotFound)
+}
@@ -3,8 +3,10 @@ package handlers
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"expvar"
 	"fmt"
+	"io"
 	"math"
 	"math/big"
 	"net"
@@ -13,6 +15,7 @@ import (
 	"os"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,20 +25,26 @@ import (
 	"github.com/distribution/distribution/v3/health"
 	"github.com/distribution/distribution/v3/health/checks"
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/requestutil"
+	"github.com/distribution/distribution/v3/internal/workers"
 	prometheus "github.com/distribution/distribution/v3/metrics"
 	"github.com/distribution/distribution/v3/notifications"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/coordination"
 	registrymiddleware "github.com/distribution/distribution/v3/registry/middleware/registry"
 	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
 	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/distribution/distribution/v3/registry/ratelimit"
 	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
 	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
 	rediscache "github.com/distribution/distribution/v3/registry/storage/cache/redis"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	timeoutmiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware/timeout"
 	"github.com/distribution/distribution/v3/version"
 	"github.com/distribution/reference"
 	events "github.com/docker/go-events"
@@ -44,12 +53,33 @@ import (
 	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // randomSecretSize is the number of random bytes to generate if no secret
 // was specified.
 const randomSecretSize = 32
 
+// defaultUploadResumeTimeout is the default time spent waiting for the
+// storage to reflect the state of a resumed upload.
+const defaultUploadResumeTimeout = 5 * time.Second
+
+// storageRetryAfter is the delay advertised to clients retrying the requests
+// which failed because the storage backend throttled them or timed out.
+const storageRetryAfter = time.Second
+
+// defaultCoordinationTTL is the default time the leadership of the instance
+// running the background maintenance lasts unless renewed.
+const defaultCoordinationTTL = 30 * time.Second
+
+// coordinationLockPath is the storage lock file of the leader election.
+const coordinationLockPath = "/coordination-leader.json"
+
+// maxRequestIDLength bounds the length of the request ids of the trusted
+// request id header.
+const maxRequestIDLength = 128
+
 // defaultCheckInterval is the default time in between health checks
 const defaultCheckInterval = 10 * time.Second
 
@@ -66,35 +96,121 @@ type App struct {
 	registry         distribution.Namespace         // registry is the primary registry backend for the app instance.
 	repoRemover      distribution.RepositoryRemover // repoRemover provides ability to delete repos
 	accessController auth.AccessController          // main access controller for application
+	accessPolicy     auth.AccessPolicy              // policy consulted after authentication
 
 	// httpHost is a parsed representation of the http.host parameter from
 	// the configuration. Only the Scheme and Host fields are used.
 	httpHost url.URL
 
+	// trustedProxies is a parsed representation of the http.trustedproxies
+	// parameter from the configuration.
+	trustedProxies []net.IPNet
+
 	// events contains notification related configuration.
 	events struct {
 		sink   events.Sink
 		source notifications.SourceRecord
+		// checks holds the health checks of the enabled endpoints, by
+		// endpoint name.
+		checks map[string]health.Checker
 	}
 
 	redis *redis.Client
 
+	// elector elects the instance running the background maintenance, nil
+	// if coordination is disabled.
+	elector *coordination.Elector
+
+	// rateLimiter limits the requests of each client, nil if rate limiting
+	// is disabled.
+	rateLimiter *rateLimiter
+
+	// uploadLimiter caps the concurrent blob uploads, nil if they are not
+	// limited.
+	uploadLimiter *uploadLimiter
+
+	// workers limits the goroutines looking up tags and broadcasting
+	// notifications.
+	workers *workers.Semaphore
+
+	// maxManifestBodyBytes and maxBlobChunkBytes limit the size of manifest
+	// and blob upload request bodies. Zero disables the limit.
+	maxManifestBodyBytes int64
+	maxBlobChunkBytes    int64
+
+	// chunkMinLength is the minimum length of blob upload chunks advertised
+	// to clients.
+	chunkMinLength int64
+
+	// readTimeout and writeTimeout are the deadlines of read and write
+	// requests. Zero leaves the requests unbounded.
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// uploadResumeTimeout bounds the time spent waiting for the storage to
+	// reflect the signed state of a resumed upload. Zero fails the upload
+	// immediately.
+	uploadResumeTimeout time.Duration
+
+	// namePolicy restricts the repository names served by the registry, nil
+	// if names are not restricted.
+	namePolicy *storage.NamePolicy
+
+	// auditor records write operations, nil if the audit log is disabled.
+	auditor *auditor
+
+	// listingCache caches the tag lists and the catalog pages, nil if
+	// disabled.
+	listingCache cache.ListingCache
+
+	// warnings holds the Warning headers of manifest responses, nil if none
+	// is configured.
+	warnings *manifestWarnings
+
 	// isCache is true if this registry is configured as a pull through cache
 	isCache bool
 
 	// readOnly is true if the registry is in a read-only maintenance mode
 	readOnly bool
+
+	// features records the optional storage features enabled, described by
+	// the info route.
+	features registryFeatures
+
+	// healthRegistry holds the health checks of the app.
+	healthRegistry *health.Registry
 }
 
 // NewApp takes a configuration and returns a configured app, ready to serve
 // requests. The app only implements ServeHTTP and can be wrapped in other
 // handlers accordingly.
 func NewApp(ctx context.Context, config *configuration.Configuration) *App {
+	return newApp(ctx, config, nil)
+}
+
+// NewAppWithNamespace returns an app serving the repositories of namespace,
+// for embedding the registry in another program. The storage driver and the
+// registry are not constructed from the storage section of the
+// configuration, which only configures namespaces built by NewApp, such as
+// with caches, deletes or validation. Authentication, access policies,
+// notifications, and registry and repository middleware are still configured
+// from config. Registry middleware are given a nil storage driver.
+func NewAppWithNamespace(ctx context.Context, config *configuration.Configuration, namespace distribution.Namespace) *App {
+	if namespace == nil {
+		panic("NewAppWithNamespace called without a namespace")
+	}
+	return newApp(ctx, config, namespace)
+}
+
+// newApp returns an app serving namespace, or the registry configured by
+// config if namespace is nil.
+func newApp(ctx context.Context, config *configuration.Configuration, namespace distribution.Namespace) *App {
 	app := &App{
-		Config:  config,
-		Context: ctx,
-		router:  v2.RouterWithPrefix(config.HTTP.Prefix),
-		isCache: config.Proxy.RemoteURL != "",
+		Config:         config,
+		Context:        ctx,
+		router:         v2.RouterWithPrefix(config.HTTP.Prefix),
+		isCache:        config.Proxy.RemoteURL != "" && namespace == nil,
+		healthRegistry: health.NewRegistry(),
 	}
 
 	// Register the handler dispatchers.
@@ -104,27 +220,20 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	app.register(v2.RouteNameManifest, manifestDispatcher)
 	app.register(v2.RouteNameCatalog, catalogDispatcher)
 	app.register(v2.RouteNameTags, tagsDispatcher)
+	if !config.HTTP.APIs.TagDelete.Disabled {
+		app.register(v2.RouteNameTag, tagDispatcher)
+	}
 	app.register(v2.RouteNameBlob, blobDispatcher)
 	app.register(v2.RouteNameBlobUpload, blobUploadDispatcher)
 	app.register(v2.RouteNameBlobUploadChunk, blobUploadDispatcher)
-
-	// override the storage driver's UA string for registry outbound HTTP requests
-	storageParams := config.Storage.Parameters()
-	if storageParams == nil {
-		storageParams = make(configuration.Parameters)
-	}
-	if storageParams["useragent"] == "" {
-		storageParams["useragent"] = fmt.Sprintf("distribution/%s %s", version.Version(), runtime.Version())
-	}
-
-	var err error
-	app.driver, err = factory.Create(app, config.Storage.Type(), storageParams)
-	if err != nil {
-		// TODO(stevvooe): Move the creation of a service into a protected
-		// method, where this is created lazily. Its status can be queried via
-		// a health check.
-		panic(err)
+	if !config.HTTP.Info.Disabled {
+		app.register(v2.RouteNameInfo, infoDispatcher)
 	}
+	app.register(v2.RouteNameTagDetails, tagDetailsDispatcher)
+	app.register(v2.RouteNameTagsResolve, tagsResolveDispatcher)
+	app.register(v2.RouteNameReferrers, referrersDispatcher)
+	app.register(v2.RouteNameUploads, uploadsDispatcher)
+	app.register(v2.RouteNameUpload, uploadsDispatcher)
 
 	purgeConfig := uploadPurgeDefaultConfig()
 	if mc, ok := config.Storage["maintenance"]; ok {
@@ -148,24 +257,29 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
-	startUploadPurger(app, app.driver, dcontext.GetLogger(app), purgeConfig)
+	app.configureWorkers(config)
+	app.configureRedis(config)
 
-	app.driver, err = applyStorageMiddleware(app, app.driver, config.Middleware["storage"])
-	if err != nil {
-		panic(err)
+	if namespace == nil {
+		app.configureDriver(config, purgeConfig)
 	}
 
 	// Do not configure HTTP secret for a proxy registry as HTTP secret
-	// is only used for blob uploads and a proxy registry does not support blob uploads.
-	if !app.isCache {
+	// is only used for blob uploads and a proxy registry does not support
+	// blob uploads, unless pushes are allowed.
+	if !app.isCache || config.Proxy.AllowPush {
+		app.configureUploadResume(config)
 		app.configureSecret(config)
 	}
 	app.configureEvents(config)
-	app.configureRedis(config)
+	app.configureRateLimit(config)
+	app.configureRequestBodyLimits(config)
+	app.configureRequestTimeout(config)
+	app.configureUploadLimits(config)
+	app.configureAudit(config)
+	app.configureWarnings(config)
 	app.configureLogHook(config)
 
-	options := registrymiddleware.GetRegistryOptions()
-
 	if config.HTTP.Host != "" {
 		u, err := url.Parse(config.HTTP.Host)
 		if err != nil {
@@ -174,6 +288,120 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		app.httpHost = *u
 	}
 
+	trustedProxies, err := requestutil.ParseNetworks(config.HTTP.TrustedProxies)
+	if err != nil {
+		panic(fmt.Sprintf(`could not parse http "trustedproxies" parameter: %v`, err))
+	}
+	app.trustedProxies = trustedProxies
+
+	if namespace == nil {
+		app.configureRegistry(config)
+	} else {
+		var err error
+		app.registry, err = applyRegistryMiddleware(app, namespace, nil, config.Middleware["registry"])
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	authType := config.Auth.Type()
+
+	if authType != "" && !strings.EqualFold(authType, "none") {
+		accessController, err := auth.GetAccessController(config.Auth.Type(), config.Auth.Parameters())
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure authorization (%s): %v", authType, err))
+		}
+		app.accessController = accessController
+		dcontext.GetLogger(app).Debugf("configured %q access controller", authType)
+	}
+
+	if policyType := config.AccessPolicy.Type(); policyType != "" {
+		accessPolicy, err := auth.GetAccessPolicy(policyType, config.AccessPolicy.Parameters())
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure access policy (%s): %v", policyType, err))
+		}
+		app.accessPolicy = accessPolicy
+		dcontext.GetLogger(app).Debugf("configured %q access policy", policyType)
+	}
+
+	var ok bool
+	app.repoRemover, ok = app.registry.(distribution.RepositoryRemover)
+	if !ok {
+		dcontext.GetLogger(app).Warnf("Registry does not implement RepositoryRemover. Will not be able to delete repos and tags")
+	}
+
+	return app
+}
+
+// configureDriver creates the storage driver of the configuration, wrapped
+// with the configured storage middleware, starts the leader election and
+// starts purging its uploads.
+func (app *App) configureDriver(config *configuration.Configuration, purgeConfig map[interface{}]interface{}) {
+	// override the storage driver's UA string for registry outbound HTTP requests
+	storageParams := config.Storage.Parameters()
+	if storageParams == nil {
+		storageParams = make(configuration.Parameters)
+	}
+	if storageParams["useragent"] == "" {
+		storageParams["useragent"] = fmt.Sprintf("distribution/%s %s", version.Version(), runtime.Version())
+	}
+
+	var err error
+	app.driver, err = factory.Create(app, config.Storage.Type(), storageParams)
+	if err != nil {
+		// TODO(stevvooe): Move the creation of a service into a protected
+		// method, where this is created lazily. Its status can be queried via
+		// a health check.
+		panic(err)
+	}
+	// The timeouts bound each attempt of the operations retried by the
+	// storage middleware.
+	app.driver = timeoutmiddleware.New(app.driver, storageTimeouts(config.Storage["timeouts"]))
+
+	app.configureCoordination(config)
+	startUploadPurger(app, app.driver, dcontext.GetLogger(app), purgeConfig, app.isLeader)
+
+	app.driver, err = applyStorageMiddleware(app, app.driver, config.Middleware["storage"])
+	if err != nil {
+		panic(err)
+	}
+}
+
+// storageTimeouts returns the deadlines of the storage operations, as
+// configured by storage.timeouts. It panics if a deadline is not a
+// non-negative duration.
+func storageTimeouts(params configuration.Parameters) timeoutmiddleware.Timeouts {
+	var timeouts timeoutmiddleware.Timeouts
+	for name, dst := range map[string]*time.Duration{
+		"read":  &timeouts.Read,
+		"write": &timeouts.Write,
+		"list":  &timeouts.List,
+	} {
+		switch v := params[name].(type) {
+		case nil:
+		case time.Duration:
+			*dst = v
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				panic(fmt.Sprintf("timeouts' %s config key must have a duration value: %v", name, err))
+			}
+			*dst = d
+		default:
+			panic(fmt.Sprintf("timeouts' %s config key must have a duration value", name))
+		}
+		if *dst < 0 {
+			panic(fmt.Sprintf("timeouts' %s config key must have a non-negative duration value", name))
+		}
+	}
+	return timeouts
+}
+
+// configureRegistry creates the registry storing its content with the storage
+// driver, as configured by the storage section of the configuration.
+func (app *App) configureRegistry(config *configuration.Configuration) {
+	options := registrymiddleware.GetRegistryOptions()
+
 	if app.isCache {
 		options = append(options, storage.DisableDigestResumption)
 	}
@@ -184,10 +412,34 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		if ok {
 			if deleteEnabled, ok := e.(bool); ok && deleteEnabled {
 				options = append(options, storage.EnableDelete)
+				app.features.Delete = true
+
+				if sd, ok := d["softdelete"]; ok {
+					softDeleteConfig, ok := sd.(map[interface{}]interface{})
+					if !ok {
+						panic("softdelete config key must contain additional keys")
+					}
+					if softDeleteConfig["enabled"] == true {
+						options = append(options, storage.SoftDelete)
+						startTrashPurger(app, app.driver, dcontext.GetLogger(app), softDeleteConfig, app.isLeader)
+					}
+				}
 			}
 		}
 	}
 
+	// configure replication to secondary storage
+	replicator, err := NewReplicator(app, config, app.driver, app.isLeader)
+	if err != nil {
+		panic(fmt.Sprintf("storage replication: %v", err))
+	}
+	if replicator != nil {
+		options = append(options, storage.Replicate(replicator))
+		app.features.Replication = true
+	}
+
+	options = append(options, storage.TagLookupWorkers(workers.New("tag_lookup", app.workers)))
+
 	// configure tag lookup concurrency limit
 	if p := config.Storage.TagParameters(); p != nil {
 		l, ok := p["concurrencylimit"]
@@ -203,21 +455,117 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
-	// configure redirects
-	var redirectDisabled bool
-	if redirectConfig, ok := config.Storage["redirect"]; ok {
-		v := redirectConfig["disable"]
-		switch v := v.(type) {
+	// configure manifest verification concurrency limit
+	if manifestConfig, ok := config.Storage["manifest"]; ok {
+		if l, ok := manifestConfig["concurrencylimit"]; ok {
+			limit, ok := l.(int)
+			if !ok || limit < 0 {
+				panic("manifest verification concurrency limit config key must have a non-negative integer value")
+			}
+			options = append(options, storage.ManifestVerificationConcurrencyLimit(limit))
+		}
+	}
+
+	// configure walk parallelism
+	if walkConfig, ok := config.Storage["walk"]; ok {
+		if p, ok := walkConfig["parallelism"]; ok {
+			parallelism, ok := p.(int)
+			if !ok || parallelism < 0 {
+				panic("walk parallelism config key must have a non-negative integer value")
+			}
+			options = append(options, storage.WalkParallelism(parallelism))
+		}
+	}
+
+	// configure content verification on read
+	if verifyConfig, ok := config.Storage["verifyonread"]; ok {
+		enabled, ok := verifyConfig["enabled"].(bool)
+		if !ok && verifyConfig["enabled"] != nil {
+			panic("verifyonread's enabled config key must have a boolean value")
+		}
+		if enabled {
+			var maxSize int64
+			switch v := verifyConfig["maxsize"].(type) {
+			case int:
+				maxSize = int64(v)
+			case nil:
+			default:
+				panic("verifyonread's maxsize config key must have an integer value")
+			}
+			if maxSize < 0 {
+				panic("verifyonread's maxsize config key must be a non-negative integer value")
+			}
+			options = append(options, storage.VerifyOnRead(maxSize))
+		}
+	}
+
+	// configure the blob reference index
+	if refIndexConfig, ok := config.Storage["refindex"]; ok {
+		enabled, ok := refIndexConfig["enabled"].(bool)
+		if !ok && refIndexConfig["enabled"] != nil {
+			panic("refindex's enabled config key must have a boolean value")
+		}
+		if enabled {
+			options = append(options, storage.BlobReferenceIndex)
+		}
+	}
+	if config.Coordination.Lock == "redis" && app.redis != nil {
+		options = append(options, redisLocks(app.redis))
+	}
+
+	// configure the headers of the blobs served
+	if blobsConfig, ok := config.Storage["blobs"]; ok {
+		switch v := blobsConfig["cachemaxage"].(type) {
+		case string:
+			maxAge, err := time.ParseDuration(v)
+			if err != nil || maxAge < 0 {
+				panic(fmt.Sprintf("blobs cachemaxage must be a non-negative duration: %q", v))
+			}
+			options = append(options, storage.BlobCacheControlMaxAge(maxAge))
+		case nil:
+		default:
+			panic("blobs cachemaxage config key must have a duration value")
+		}
+		switch v := blobsConfig["attachments"].(type) {
 		case bool:
-			redirectDisabled = v
+			if v {
+				options = append(options, storage.EnableBlobAttachments)
+			}
+		case nil:
 		default:
-			panic(fmt.Sprintf("invalid type for redirect config: %#v", redirectConfig))
+			panic("blobs attachments config key must have a boolean value")
+		}
+	}
+
+	// configure redirects
+	var redirectDisabled, redirectUploads bool
+	if redirectConfig, ok := config.Storage["redirect"]; ok {
+		for key, dst := range map[string]*bool{"disable": &redirectDisabled, "uploads": &redirectUploads} {
+			switch v := redirectConfig[key].(type) {
+			case bool:
+				*dst = v
+			case nil:
+			default:
+				panic(fmt.Sprintf("invalid type for redirect config: %#v", redirectConfig))
+			}
 		}
 	}
 	if redirectDisabled {
 		dcontext.GetLogger(app).Infof("backend redirection disabled")
 	} else {
 		options = append(options, storage.EnableRedirect)
+		app.features.Redirect = true
+		if t, ok := config.Storage["redirect"]["threshold"]; ok && t != nil {
+			threshold, ok := t.(int)
+			if !ok || threshold < 0 {
+				panic("redirect threshold config key must have a non-negative integer value")
+			}
+			options = append(options, storage.RedirectThreshold(int64(threshold)))
+		}
+		if redirectUploads {
+			options = append(options, storage.EnableUploadRedirect)
+			app.features.UploadRedirect = true
+		}
 	}
 
 	if !config.Validation.Enabled {
@@ -230,35 +578,75 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 			// If Allow and Deny are empty, allow nothing.
 			options = append(options, storage.ManifestURLsAllowRegexp(regexp.MustCompile("^$")))
 		} else {
-			if len(config.Validation.Manifests.URLs.Allow) > 0 {
-				for i, s := range config.Validation.Manifests.URLs.Allow {
-					// Validate via compilation.
-					if _, err := regexp.Compile(s); err != nil {
-						panic(fmt.Sprintf("validation.manifests.urls.allow: %s", err))
-					}
-					// Wrap with non-capturing group.
-					config.Validation.Manifests.URLs.Allow[i] = fmt.Sprintf("(?:%s)", s)
-				}
-				re := regexp.MustCompile(strings.Join(config.Validation.Manifests.URLs.Allow, "|"))
+			if re := urlsRegexp("validation.manifests.urls.allow", config.Validation.Manifests.URLs.Allow); re != nil {
 				options = append(options, storage.ManifestURLsAllowRegexp(re))
 			}
-			if len(config.Validation.Manifests.URLs.Deny) > 0 {
-				for i, s := range config.Validation.Manifests.URLs.Deny {
-					// Validate via compilation.
-					if _, err := regexp.Compile(s); err != nil {
-						panic(fmt.Sprintf("validation.manifests.urls.deny: %s", err))
-					}
-					// Wrap with non-capturing group.
-					config.Validation.Manifests.URLs.Deny[i] = fmt.Sprintf("(?:%s)", s)
-				}
-				re := regexp.MustCompile(strings.Join(config.Validation.Manifests.URLs.Deny, "|"))
+			if re := urlsRegexp("validation.manifests.urls.deny", config.Validation.Manifests.URLs.Deny); re != nil {
 				options = append(options, storage.ManifestURLsDenyRegexp(re))
 			}
 		}
+
+		// Foreign layers fall back to the rules above unless they have
+		// their own.
+		if re := urlsRegexp("validation.manifests.foreignlayers.urls.allow", config.Validation.Manifests.ForeignLayers.URLs.Allow); re != nil {
+			options = append(options, storage.ForeignLayerURLsAllowRegexp(re))
+		}
+		if re := urlsRegexp("validation.manifests.foreignlayers.urls.deny", config.Validation.Manifests.ForeignLayers.URLs.Deny); re != nil {
+			options = append(options, storage.ForeignLayerURLsDenyRegexp(re))
+		}
+
+		layerMediaTypes := config.Validation.Manifests.Layers.MediaTypes
+		if len(layerMediaTypes) == 0 {
+			layerMediaTypes = storage.DefaultLayerMediaTypes
+		}
+		options = append(options, storage.LayerMediaTypes(layerMediaTypes))
+
+		if config.Validation.Manifests.ImageConfig {
+			options = append(options, storage.ValidateImageConfigs)
+		}
+
+		if canonical := config.Validation.Manifests.Canonical.Repositories; len(canonical) > 0 {
+			options = append(options, storage.RequireCanonicalManifests(canonical))
+		}
+
+		if mediaTypes := config.Validation.Manifests.MediaTypes; len(mediaTypes) > 0 {
+			rules := make([]storage.MediaTypeRule, 0, len(mediaTypes))
+			for repository, allowed := range mediaTypes {
+				rules = append(rules, storage.MediaTypeRule{
+					Repository: repository,
+					Manifests:  allowed.Manifests,
+					Configs:    allowed.Configs,
+				})
+			}
+			// Check rules in a stable order, so that errors are reproducible.
+			sort.Slice(rules, func(i, j int) bool { return rules[i].Repository < rules[j].Repository })
+			options = append(options, storage.ManifestMediaTypeRules(rules))
+		}
+
+		if immutable := config.Validation.Tags.Immutable; len(immutable) > 0 {
+			rules := make([]storage.ImmutableTagRule, 0, len(immutable))
+			for repository, tags := range immutable {
+				rules = append(rules, storage.ImmutableTagRule{
+					Repository: repository,
+					Tags:       tags,
+				})
+			}
+			options = append(options, storage.ImmutableTags(rules))
+		}
+
+		repositories := config.Validation.Repositories
+		if repositories.MaxComponents > 0 || len(repositories.AllowPatterns) > 0 || len(repositories.DenyPatterns) > 0 {
+			app.namePolicy, err = storage.NewNamePolicy(repositories.MaxComponents, repositories.AllowPatterns, repositories.DenyPatterns)
+			if err != nil {
+				panic(fmt.Sprintf("validation.repositories: %s", err))
+			}
+		}
 	}
 
 	// configure storage caches
 	if cc, ok := config.Storage["cache"]; ok {
+		app.configureListingCache(cc)
+
 		v, ok := cc["blobdescriptor"]
 		if !ok {
 			// Backwards compatible: "layerinfo" == "blobdescriptor"
@@ -318,52 +706,38 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		panic(err)
 	}
 
-	authType := config.Auth.Type()
-
-	if authType != "" && !strings.EqualFold(authType, "none") {
-		accessController, err := auth.GetAccessController(config.Auth.Type(), config.Auth.Parameters())
-		if err != nil {
-			panic(fmt.Sprintf("unable to configure authorization (%s): %v", authType, err))
-		}
-		app.accessController = accessController
-		dcontext.GetLogger(app).Debugf("configured %q access controller", authType)
-	}
-
 	// configure as a pull through cache
 	if config.Proxy.RemoteURL != "" {
-		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy)
+		var proxyOptions []proxy.Option
+		if app.elector != nil {
+			proxyOptions = append(proxyOptions, proxy.WithLeader(app.elector.IsLeader))
+		}
+		app.registry, err = proxy.NewRegistryPullThroughCache(app, app.registry, app.driver, config.Proxy, proxyOptions...)
 		if err != nil {
 			panic(err.Error())
 		}
 		app.isCache = true
 		dcontext.GetLogger(app).Info("Registry configured as a proxy cache to ", config.Proxy.RemoteURL)
 	}
-	var ok bool
-	app.repoRemover, ok = app.registry.(distribution.RepositoryRemover)
-	if !ok {
-		dcontext.GetLogger(app).Warnf("Registry does not implement RepositoryRemover. Will not be able to delete repos and tags")
-	}
-
-	return app
 }
 
-// RegisterHealthChecks is an awful hack to defer health check registration
-// control to callers. This should only ever be called once per registry
-// process, typically in a main function. The correct way would be register
-// health checks outside of app, since multiple apps may exist in the same
-// process. Because the configuration and app are tightly coupled,
-// implementing this properly will require a refactor. This method may panic
-// if called twice in the same process.
+// RegisterHealthChecks registers the health checks of the configuration in
+// the health registry of the app, returned by HealthRegistry, or in
+// healthRegistry if one is given. The checks are polled until the context of
+// the app is done. Registering the checks twice in the same health registry
+// panics.
 func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 	if len(healthRegistries) > 1 {
 		panic("RegisterHealthChecks called with more than one registry")
 	}
-	healthRegistry := health.DefaultRegistry
+	healthRegistry := app.healthRegistry
 	if len(healthRegistries) == 1 {
 		healthRegistry = healthRegistries[0]
 	}
 
-	if app.Config.Health.StorageDriver.Enabled {
+	if app.Config.Health.StorageDriver.Enabled && app.driver == nil {
+		dcontext.GetLogger(app).Warn("storage driver health check enabled, but the app has no storage driver")
+	} else if app.Config.Health.StorageDriver.Enabled {
 		interval := app.Config.Health.StorageDriver.Interval
 		if interval == 0 {
 			interval = defaultCheckInterval
@@ -385,6 +759,12 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 		go health.Poll(app, updater, storageDriverCheck, interval)
 	}
 
+	// The coordination check fails while the lock cannot be reached, which
+	// leaves the instance serving but unable to run the maintenance.
+	if app.elector != nil {
+		healthRegistry.RegisterNonCritical("coordination", app.elector)
+	}
+
 	for _, fileChecker := range app.Config.Health.FileCheckers {
 		interval := fileChecker.Interval
 		if interval == 0 {
@@ -428,13 +808,91 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 		healthRegistry.Register(tcpChecker.Addr, updater)
 		go health.Poll(app, updater, checker, interval)
 	}
+
+	app.registerDependencyChecks(healthRegistry)
+}
+
+// registerDependencyChecks registers the health checks of the services the
+// registry depends on, which are enabled in the configuration.
+func (app *App) registerDependencyChecks(healthRegistry *health.Registry) {
+	dependencies := app.Config.Health.Dependencies
+
+	if dependencies.Redis.Enabled {
+		if app.redis == nil {
+			panic("redis health check enabled, but redis is not configured")
+		}
+		timeout := dependencyTimeout(dependencies.Redis)
+		app.registerDependencyCheck(healthRegistry, "redis", dependencies.Redis, health.CheckFunc(func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			if err := app.redis.Ping(ctx).Err(); err != nil {
+				return fmt.Errorf("redis: ping failed: %w", err)
+			}
+			return nil
+		}))
+	}
+
+	if dependencies.Notifications.Enabled {
+		for _, endpoint := range app.Config.Notifications.Endpoints {
+			if endpoint.Disabled {
+				continue
+			}
+			checker, ok := app.events.checks[endpoint.Name]
+			if !ok {
+				dcontext.GetLogger(app).Warnf("endpoint %s: %s transport cannot be health checked", endpoint.Name, endpoint.Transport)
+				continue
+			}
+			app.registerDependencyCheck(healthRegistry, "notifications_"+endpoint.Name, dependencies.Notifications, checker)
+		}
+	}
+
+	if dependencies.TokenService.Enabled {
+		realm, ok := app.Config.Auth.Parameters()["realm"].(string)
+		if app.Config.Auth.Type() != "token" || !ok || realm == "" {
+			panic("token service health check enabled, but token authentication is not configured")
+		}
+		client := &http.Client{Timeout: dependencyTimeout(dependencies.TokenService)}
+		app.registerDependencyCheck(healthRegistry, "tokenservice", dependencies.TokenService, checks.ReachableHTTPChecker(realm, client))
+	}
+}
+
+// registerDependencyCheck polls checker as the health check name of a
+// dependency. Its failures make the registry unavailable, unless dependencies
+// are configured as only degrading it.
+func (app *App) registerDependencyCheck(healthRegistry *health.Registry, name string, config configuration.DependencyChecker, checker health.Checker) {
+	interval := config.Interval
+	if interval == 0 {
+		interval = defaultCheckInterval
+	}
+
+	dcontext.GetLogger(app).Infof("configuring %s health check, interval=%d, threshold=%d", name, interval/time.Second, config.Threshold)
+	updater := health.NewThresholdStatusUpdater(config.Threshold)
+	if app.Config.Health.Dependencies.Degraded {
+		healthRegistry.RegisterNonCritical(name, updater)
+	} else {
+		healthRegistry.Register(name, updater)
+	}
+	go health.Poll(app, updater, checker, interval)
+}
+
+// dependencyTimeout returns how long the health check configured by config
+// waits for the dependency, which is the check interval unless set.
+func dependencyTimeout(config configuration.DependencyChecker) time.Duration {
+	switch {
+	case config.Timeout > 0:
+		return config.Timeout
+	case config.Interval > 0:
+		return config.Interval
+	default:
+		return defaultCheckInterval
+	}
 }
 
 // register a handler with the application, by route name. The handler will be
 // passed through the application filters and context will be constructed at
 // request time.
 func (app *App) register(routeName string, dispatch dispatchFunc) {
-	handler := app.dispatcher(dispatch)
+	handler := traceRoute(routeName, app.dispatcher(dispatch))
 
 	// Chain the handler with prometheus instrumented handler
 	if app.Config.HTTP.Debug.Prometheus.Enabled {
@@ -453,6 +911,32 @@ func (app *App) register(routeName string, dispatch dispatchFunc) {
 	app.router.GetRoute(routeName).Handler(handler)
 }
 
+// traceRoute names the server spans of the requests served by handler, if
+// recording, after their method and route rather than their path, so that
+// the span names are few.
+func traceRoute(routeName string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if span := trace.SpanFromContext(r.Context()); span.IsRecording() {
+			span.SetName(r.Method + " " + routeName)
+			span.SetAttributes(semconv.HTTPRouteKey.String(routeName))
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// configureWorkers creates the semaphore limiting the workers shared by the
+// tag lookups and the notification broadcaster.
+func (app *App) configureWorkers(config *configuration.Configuration) {
+	limit := config.HTTP.Limits.Workers
+	if limit < 0 {
+		panic("workers config key must have a non-negative integer value")
+	}
+	if limit == 0 {
+		limit = workers.DefaultLimit
+	}
+	app.workers = workers.NewSemaphore(limit)
+}
+
 // configureEvents prepares the event sink for action.
 func (app *App) configureEvents(configuration *configuration.Configuration) {
 	// Configure all of the endpoint sinks.
@@ -462,19 +946,71 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 	// should have at the time the iteration starts
 	// nolint:prealloc
 	var sinks []events.Sink
+	app.events.checks = make(map[string]health.Checker)
 	for _, endpoint := range configuration.Notifications.Endpoints {
 		if endpoint.Disabled {
 			dcontext.GetLogger(app).Infof("endpoint %s disabled, skipping", endpoint.Name)
 			continue
 		}
 
-		dcontext.GetLogger(app).Infof("configuring endpoint %v (%v), timeout=%s, headers=%v", endpoint.Name, endpoint.URL, endpoint.Timeout, endpoint.Headers)
+		var deadLetter events.Sink
+		switch dl := endpoint.DeadLetter; {
+		case dl.URL != "" && dl.Path != "":
+			panic(fmt.Sprintf("endpoint %s: deadletter url and path are mutually exclusive", endpoint.Name))
+		case dl.URL != "":
+			deadLetter = notifications.NewHTTPDeadLetterSink(dl.URL, dl.Timeout, dl.Headers)
+		case dl.Path != "":
+			if app.driver == nil {
+				panic(fmt.Sprintf("endpoint %s: deadletter path requires a storage driver", endpoint.Name))
+			}
+			deadLetter = notifications.NewStorageDeadLetterSink(app.driver, dl.Path)
+		}
+
+		var transport *http.Transport
+		if tc := endpoint.TLS; tc.CAFile != "" || tc.CertFile != "" || tc.KeyFile != "" || tc.InsecureSkipVerify {
+			var err error
+			transport, err = notifications.NewTLSTransport(endpoint.TLS)
+			if err != nil {
+				panic(fmt.Sprintf("endpoint %s: unable to configure tls: %v", endpoint.Name, err))
+			}
+		}
+
+		var messageSink events.Sink
+		if endpoint.Transport != "" && endpoint.Transport != "http" {
+			var err error
+			messageSink, err = notifications.NewTransportSink(endpoint.Transport, endpoint.Options)
+			if err != nil {
+				panic(fmt.Sprintf("endpoint %s: unable to configure %s transport: %v", endpoint.Name, endpoint.Transport, err))
+			}
+			dcontext.GetLogger(app).Infof("configuring endpoint %v (%v transport)", endpoint.Name, endpoint.Transport)
+
+			// Message transports check their connection to the brokers.
+			if checker, ok := messageSink.(health.Checker); ok {
+				app.events.checks[endpoint.Name] = checker
+			}
+		} else {
+			dcontext.GetLogger(app).Infof("configuring endpoint %v (%v), timeout=%s, headers=%v", endpoint.Name, endpoint.URL, endpoint.Timeout, endpoint.Headers)
+
+			client := &http.Client{Timeout: dependencyTimeout(configuration.Health.Dependencies.Notifications)}
+			if transport != nil {
+				client.Transport = transport
+			}
+			app.events.checks[endpoint.Name] = checks.ReachableHTTPChecker(endpoint.URL, client)
+		}
+
 		endpoint := notifications.NewEndpoint(endpoint.Name, endpoint.URL, notifications.EndpointConfig{
 			Timeout:           endpoint.Timeout,
 			Threshold:         endpoint.Threshold,
 			Backoff:           endpoint.Backoff,
+			MaxBackoff:        endpoint.MaxBackoff,
+			MaxRetries:        endpoint.MaxRetries,
+			DeadLetter:        deadLetter,
+			MessageSink:       messageSink,
 			Headers:           endpoint.Headers,
+			Transport:         transport,
+			SigningSecret:     endpoint.SigningSecret,
 			IgnoredMediaTypes: endpoint.IgnoredMediaTypes,
+			Include:           endpoint.Include,
 			Ignore:            endpoint.Ignore,
 		})
 
@@ -485,7 +1021,7 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 	// replacing broadcaster with a rabbitmq implementation. It's recommended
 	// that the registry instances also act as the workers to keep deployment
 	// simple.
-	app.events.sink = events.NewBroadcaster(sinks...)
+	app.events.sink = notifications.NewBroadcaster(workers.New("notifications", app.workers), sinks...)
 
 	// Populate registry event source
 	hostname, err := os.Hostname()
@@ -505,6 +1041,67 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 	}
 }
 
+// configureCoordination starts the election of the instance running the
+// background maintenance among those sharing the storage, if coordination is
+// configured.
+func (app *App) configureCoordination(config *configuration.Configuration) {
+	var lock coordination.Lock
+	switch config.Coordination.Lock {
+	case "":
+		return
+	case "redis":
+		if app.redis == nil {
+			panic("redis coordination lock configured, but redis is not configured")
+		}
+		lock = coordination.NewRedisLock(app.redis, "leader")
+	case "storage":
+		lock = coordination.NewStorageLock(app.driver, coordinationLockPath)
+	default:
+		panic(fmt.Sprintf("unknown coordination lock %q", config.Coordination.Lock))
+	}
+
+	ttl := config.Coordination.TTL
+	if ttl < 0 {
+		panic("coordination ttl must be positive")
+	} else if ttl == 0 {
+		ttl = defaultCoordinationTTL
+	}
+
+	app.elector = coordination.NewElector(lock, dcontext.GetStringValue(app, "instance.id"), ttl)
+	// Campaign once before the maintenance starts, so that it runs from the
+	// start on the instance taking the free lock.
+	if err := app.elector.Campaign(app); err != nil {
+		dcontext.GetLogger(app).Errorf("leader election failed: %v", err)
+	}
+	go app.elector.Run(app)
+	dcontext.GetLogger(app).Infof("configured %s coordination lock, ttl=%s", config.Coordination.Lock, ttl)
+}
+
+// redisLocks returns the option sharing the storage locks of the registry
+// instances through the redis server of client.
+func redisLocks(client *redis.Client) storage.RegistryOption {
+	return storage.Locks(func(name string) coordination.Lock {
+		return coordination.NewRedisLock(client, "lock:"+name)
+	})
+}
+
+// SharedLocks returns the option of the storage locks shared with the
+// registry instances running with config, for the commands updating their
+// storage. It returns nil if the locks are files of the storage, the
+// default.
+func SharedLocks(config *configuration.Configuration) storage.RegistryOption {
+	if config.Coordination.Lock != "redis" || config.Redis.Addr == "" {
+		return nil
+	}
+	return redisLocks(newRedisClient(config.Redis))
+}
+
+// isLeader reports whether the instance runs the background maintenance,
+// which is always the case if coordination is disabled.
+func (app *App) isLeader() bool {
+	return app.elector == nil || app.elector.IsLeader()
+}
+
 func (app *App) configureRedis(cfg *configuration.Configuration) {
 	if cfg.Redis.Addr == "" {
 		dcontext.GetLogger(app).Infof("redis not configured")
@@ -534,6 +1131,11 @@ func (app *App) configureRedis(cfg *configuration.Configuration) {
 }
 
 func (app *App) createPool(cfg configuration.Redis) *redis.Client {
+	return newRedisClient(cfg)
+}
+
+// newRedisClient returns a client of the redis server of cfg.
+func newRedisClient(cfg configuration.Redis) *redis.Client {
 	return redis.NewClient(&redis.Options{
 		Addr: cfg.Addr,
 		OnConnect: func(ctx context.Context, cn *redis.Conn) error {
@@ -554,6 +1156,159 @@ func (app *App) createPool(cfg configuration.Redis) *redis.Client {
 	})
 }
 
+// configureRateLimit sets up the rate limiter, if enabled.
+func (app *App) configureRateLimit(cfg *configuration.Configuration) {
+	rlConfig := cfg.HTTP.RateLimit
+	if !rlConfig.Enabled {
+		return
+	}
+
+	backend := rlConfig.Backend
+	if backend == "" {
+		backend = "inmemory"
+	}
+
+	var limiter ratelimit.Limiter
+	switch backend {
+	case "inmemory":
+		limiter = ratelimit.NewInMemoryLimiter()
+	case "redis":
+		if app.redis == nil {
+			panic("redis configuration required to use for rate limiting")
+		}
+		limiter = ratelimit.NewRedisLimiter(app.redis)
+	default:
+		panic(fmt.Sprintf("unknown rate limit backend %q", backend))
+	}
+
+	var err error
+	app.rateLimiter, err = newRateLimiter(limiter, rlConfig)
+	if err != nil {
+		panic(fmt.Sprintf("invalid rate limit configuration: %v", err))
+	}
+	dcontext.GetLogger(app).Infof("using %s rate limiter", backend)
+}
+
+// configureRequestBodyLimits sets up the limits of request body sizes, and
+// the minimum length of blob upload chunks.
+func (app *App) configureRequestBodyLimits(cfg *configuration.Configuration) {
+	limits := cfg.HTTP.MaxRequestBodyBytes
+	if limits.Manifest < 0 || limits.BlobChunk < 0 {
+		panic("maxrequestbodybytes config keys must have non-negative integer values")
+	}
+
+	app.maxManifestBodyBytes = limits.Manifest
+	if app.maxManifestBodyBytes == 0 {
+		app.maxManifestBodyBytes = maxManifestBodySize
+	}
+	app.maxBlobChunkBytes = limits.BlobChunk
+
+	app.chunkMinLength = cfg.HTTP.ChunkMinLength
+	switch {
+	case app.chunkMinLength < 0:
+		panic("chunkminlength config key must have a non-negative integer value")
+	case app.chunkMinLength == 0:
+		app.chunkMinLength = 1
+	case app.maxBlobChunkBytes > 0 && app.chunkMinLength > app.maxBlobChunkBytes:
+		panic("chunkminlength config key must not be greater than maxrequestbodybytes.blobchunk")
+	}
+}
+
+// configureRequestTimeout sets up the deadlines of requests.
+func (app *App) configureRequestTimeout(cfg *configuration.Configuration) {
+	timeout := cfg.HTTP.RequestTimeout
+	if timeout.Read < 0 || timeout.Write < 0 {
+		panic("requesttimeout config keys must have non-negative duration values")
+	}
+	app.readTimeout = timeout.Read
+	app.writeTimeout = timeout.Write
+}
+
+// withRequestTimeout returns ctx bounded by the deadline of r, which depends
+// on whether r reads or writes. Storage operations serving r are canceled
+// once the deadline passes.
+func (app *App) withRequestTimeout(ctx context.Context, r *http.Request) (context.Context, context.CancelFunc) {
+	timeout := app.writeTimeout
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		timeout = app.readTimeout
+	}
+	if timeout == 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// requestTimedOut reports whether the deadline of the request served with ctx
+// passed.
+func requestTimedOut(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
+
+// sanitizeStorageErrors replaces the unknown errors caused by transient
+// failures of the storage backend, such as throttling or timeouts, with
+// UNAVAILABLE errors advising clients to retry. Only the category of the
+// failure is served: the details of the backend are left to the logs.
+func sanitizeStorageErrors(errs errcode.Errors) errcode.Errors {
+	var sanitized errcode.Errors
+	for i, err := range errs {
+		if e, ok := err.(errcode.Error); ok {
+			detail, ok := e.Detail.(error)
+			if e.Code != errcode.ErrorCodeUnknown || !ok {
+				continue
+			}
+			err = detail
+		}
+		category := storagedriver.CategoryOf(err)
+		if !category.Retriable() {
+			continue
+		}
+		if sanitized == nil {
+			sanitized = append(errcode.Errors(nil), errs...)
+		}
+		sanitized[i] = errcode.ErrorCodeUnavailable.WithDetail(map[string]string{
+			"category": category.String(),
+		}).WithRetryAfter(storageRetryAfter)
+	}
+	if sanitized == nil {
+		return errs
+	}
+	return sanitized
+}
+
+// urlsRegexp returns a regular expression matching the URLs matched by any
+// of patterns, or nil if there are none. It panics if a pattern of the
+// option name is invalid.
+func urlsRegexp(name string, patterns []string) *regexp.Regexp {
+	if len(patterns) == 0 {
+		return nil
+	}
+	groups := make([]string, len(patterns))
+	for i, s := range patterns {
+		// Validate via compilation.
+		if _, err := regexp.Compile(s); err != nil {
+			panic(fmt.Sprintf("%s: %s", name, err))
+		}
+		// Wrap with non-capturing group.
+		groups[i] = fmt.Sprintf("(?:%s)", s)
+	}
+	return regexp.MustCompile(strings.Join(groups, "|"))
+}
+
+// configureAudit sets up the audit log, if enabled.
+func (app *App) configureAudit(cfg *configuration.Configuration) {
+	if !cfg.Audit.Enabled {
+		return
+	}
+
+	var err error
+	app.auditor, err = newAuditor(cfg.Audit, app.driver)
+	if err != nil {
+		panic(fmt.Sprintf("unable to configure audit log: %v", err))
+	}
+	dcontext.GetLogger(app).Infof("writing audit log to %s", cfg.Audit.Sink)
+}
+
 // configureLogHook prepares logging hook parameters.
 func (app *App) configureLogHook(configuration *configuration.Configuration) {
 	entry, ok := dcontext.GetLogger(app).(*logrus.Entry)
@@ -598,10 +1353,44 @@ func (app *App) configureSecret(configuration *configuration.Configuration) {
 	}
 }
 
+// HealthRegistry returns the health registry of the app, in which
+// RegisterHealthChecks registers the health checks of the configuration by
+// default. Each app has its own, so that several apps can coexist in the same
+// process.
+func (app *App) HealthRegistry() *health.Registry {
+	return app.healthRegistry
+}
+
+// configureUploadResume enables waiting for the storage to reflect the state
+// of resumed uploads, when the state is signed with a configured secret shared
+// by the registry instances.
+func (app *App) configureUploadResume(cfg *configuration.Configuration) {
+	resume := cfg.HTTP.UploadResume
+	if resume.Timeout < 0 {
+		panic("uploadresume timeout config key must have a non-negative duration value")
+	}
+	if cfg.HTTP.Secret == "" || resume.Disabled {
+		return
+	}
+	app.uploadResumeTimeout = defaultUploadResumeTimeout
+	if resume.Timeout > 0 {
+		app.uploadResumeTimeout = resume.Timeout
+	}
+}
+
+// Shutdown releases resources held by the application. A pull through cache
+// stops its expiry scheduler, writing the scheduler state to storage.
+func (app *App) Shutdown() error {
+	if closer, ok := app.registry.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Prepare the context with our own little decorations.
-	ctx := r.Context()
-	ctx = dcontext.WithRequest(ctx, r)
+	ctx := dcontext.WithTraceContext(r.Context(), r)
+	ctx = dcontext.WithRequestID(ctx, r, app.trustedRequestID(r))
 	ctx, w = dcontext.WithResponseWriter(ctx, w)
 	ctx = dcontext.WithLogger(ctx, dcontext.GetRequestLogger(ctx))
 	r = r.WithContext(ctx)
@@ -611,6 +1400,26 @@ func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	app.router.ServeHTTP(w, r)
 }
 
+// trustedRequestID returns the id of r set in the trusted request id header,
+// if configured. As request ids are logged, only short ids of printable ASCII
+// characters are used.
+func (app *App) trustedRequestID(r *http.Request) string {
+	header := app.Config.HTTP.RequestID.TrustedHeader
+	if header == "" {
+		return ""
+	}
+	id := r.Header.Get(header)
+	if len(id) > maxRequestIDLength {
+		return ""
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return ""
+		}
+	}
+	return id
+}
+
 // dispatchFunc takes a context and request and returns a constructed handler
 // for the route. The dispatcher will use this to dynamically create request
 // specific handlers for each endpoint without creating a new router for each
@@ -624,20 +1433,25 @@ type dispatchFunc func(ctx *Context, r *http.Request) http.Handler
 // handler, using the dispatch factory function.
 func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		for headerName, headerValues := range app.Config.HTTP.Headers {
-			for _, value := range headerValues {
-				w.Header().Add(headerName, value)
-			}
-		}
-
 		context := app.context(w, r)
 
+		var cancel func()
+		context.Context, cancel = app.withRequestTimeout(context.Context, r)
+		defer cancel()
+
 		defer func() {
 			// Automated error response handling here. Handlers may return their
 			// own errors if they need different behavior (such as range errors
 			// for layer upload).
 			if context.Errors.Len() > 0 {
-				_ = errcode.ServeJSON(w, context.Errors)
+				errs := context.Errors
+				if requestTimedOut(context) {
+					// Whatever failed did so because the deadline passed.
+					errs = errcode.Errors{errcode.ErrorCodeUnavailable.WithDetail("request deadline exceeded")}
+				} else {
+					errs = sanitizeStorageErrors(errs)
+				}
+				_ = errcode.ServeJSON(w, errs)
 				app.logError(context, context.Errors)
 			} else if status, ok := context.Value("http.response.status").(int); ok && status >= 200 && status <= 399 {
 				dcontext.GetResponseLogger(context).Infof("response completed")
@@ -652,6 +1466,11 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		// Add username to request logging
 		context.Context = dcontext.WithLogger(context.Context, dcontext.GetLogger(context.Context, userNameKey))
 
+		if app.rateLimiter != nil && !app.rateLimiter.allow(context, w, r) {
+			context.Errors = append(context.Errors, errcode.ErrorCodeTooManyRequests)
+			return
+		}
+
 		// sync up context on the request.
 		r = r.WithContext(context)
 
@@ -668,6 +1487,11 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 				}
 				return
 			}
+			if err := app.namePolicy.Validate(nameRef.Name()); err != nil {
+				dcontext.GetLogger(context).Warnf("repository name rejected by policy: %v", err)
+				context.Errors = append(context.Errors, errcode.ErrorCodeNameInvalid.WithDetail(err))
+				return
+			}
 			repository, err := app.registry.Repository(context, nameRef)
 			if err != nil {
 				dcontext.GetLogger(context).Errorf("error resolving repository: %v", err)
@@ -768,6 +1592,8 @@ func (app *App) context(w http.ResponseWriter, r *http.Request) *Context {
 		// X-Forwarded-Proto and X-Forwarded-Host headers, and the
 		// hostname in the request.
 		context.urlBuilder = v2.NewURLBuilder(&app.httpHost, false)
+	} else if len(app.trustedProxies) > 0 {
+		context.urlBuilder = v2.NewURLBuilderFromTrustedRequest(r, app.Config.HTTP.RelativeURLs, app.trustedProxies)
 	} else {
 		context.urlBuilder = v2.NewURLBuilderFromRequest(r, app.Config.HTTP.RelativeURLs)
 	}
@@ -782,14 +1608,25 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 	dcontext.GetLogger(context).Debug("authorizing request")
 	repo := getName(context)
 
-	if app.accessController == nil {
-		return nil // access controller is not enabled.
+	if app.accessController == nil && app.accessPolicy == nil {
+		return nil // neither access controller nor policy is enabled.
 	}
 
 	var accessRecords []auth.Access
 
 	if repo != "" {
-		accessRecords = appendAccessRecords(accessRecords, r.Method, repo)
+		method := r.Method
+		if route := mux.CurrentRoute(r); route != nil {
+			switch route.GetName() {
+			case v2.RouteNameTagsResolve:
+				// Resolving tags only reads the repository.
+				method = http.MethodGet
+			case v2.RouteNameUploads:
+				// The uuids of the uploads let their holder write them.
+				method = http.MethodPost
+			}
+		}
+		accessRecords = appendAccessRecords(accessRecords, method, repo)
 		if fromRepo := r.FormValue("from"); fromRepo != "" {
 			// mounting a blob from one repository to another requires pull (GET)
 			// access to the source repository.
@@ -809,9 +1646,37 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 			}
 			return fmt.Errorf("forbidden: no repository name")
 		}
-		accessRecords = appendCatalogAccessRecord(accessRecords, r)
+		accessRecords = appendRegistryAccessRecord(accessRecords, r)
+	}
+
+	if app.accessController != nil {
+		if err := app.authenticate(w, r, context, accessRecords); err != nil {
+			return err
+		}
 	}
 
+	if app.accessPolicy != nil {
+		if err := app.accessPolicy.Authorized(context.Context, accessRecords, r); err != nil {
+			var denied *auth.PolicyDeniedError
+			if errors.As(err, &denied) {
+				err = errcode.ErrorCodeDenied.WithDetail(denied.Error())
+			} else {
+				dcontext.GetLogger(context).Errorf("error checking access policy: %v", err)
+				err = errcode.ErrorCodeDenied
+			}
+			if err := errcode.ServeJSON(w, err); err != nil {
+				dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// authenticate checks the request against the access controller, and records
+// the authenticated user in the context.
+func (app *App) authenticate(w http.ResponseWriter, r *http.Request, context *Context, accessRecords []auth.Access) error {
 	grant, err := app.accessController.Authorized(r.WithContext(context.Context), accessRecords...)
 	if err != nil {
 		switch err := err.(type) {
@@ -839,6 +1704,9 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 
 	ctx := withUser(context.Context, grant.User)
 	ctx = withResources(ctx, grant.Resources)
+	if grant.RepositoryFilter != nil {
+		ctx = withRepositoryFilter(ctx, grant.RepositoryFilter)
+	}
 
 	dcontext.GetLogger(ctx, userNameKey).Info("authorized request")
 	// TODO(stevvooe): This pattern needs to be cleaned up a bit. One context
@@ -856,7 +1724,8 @@ func (app *App) eventBridge(ctx *Context, r *http.Request) notifications.Listene
 	}
 	request := notifications.NewRequestRecord(dcontext.GetRequestID(ctx), r)
 
-	return notifications.NewBridge(ctx.urlBuilder, app.events.source, actor, request, app.events.sink, app.Config.Notifications.EventConfig.IncludeReferences)
+	eventConfig := app.Config.Notifications.EventConfig
+	return notifications.NewBridge(ctx.urlBuilder, app.events.source, actor, request, app.events.sink, eventConfig.IncludeReferences, eventConfig.MaxReferences)
 }
 
 // nameRequired returns true if the route requires a name.
@@ -866,7 +1735,7 @@ func (app *App) nameRequired(r *http.Request) bool {
 		return true
 	}
 	routeName := route.GetName()
-	return routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog
+	return routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog && routeName != v2.RouteNameInfo
 }
 
 // apiBase implements a simple yes-man for doing overall checks against the
@@ -914,15 +1783,16 @@ func appendAccessRecords(records []auth.Access, method string, repo string) []au
 	return records
 }
 
-// Add the access record for the catalog if it's our current route
-func appendCatalogAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
+// Add the access record for the catalog or the info route if it's our
+// current route
+func appendRegistryAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
 	route := mux.CurrentRoute(r)
 	routeName := route.GetName()
 
-	if routeName == v2.RouteNameCatalog {
+	if routeName == v2.RouteNameCatalog || routeName == v2.RouteNameInfo {
 		resource := auth.Resource{
 			Type: "registry",
-			Name: "catalog",
+			Name: routeName,
 		}
 
 		accessRecords = append(accessRecords,
@@ -987,8 +1857,9 @@ func badPurgeUploadConfig(reason string) {
 }
 
 // startUploadPurger schedules a goroutine which will periodically
-// check upload directories for old files and delete them
-func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}) {
+// check upload directories for old files and delete them, while leader
+// reports the instance as the leader
+func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}, leader func() bool) {
 	if config["enabled"] == false {
 		return
 	}
@@ -1048,9 +1919,55 @@ func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageD
 		time.Sleep(jitter)
 
 		for {
-			storage.PurgeUploads(ctx, storageDriver, time.Now().Add(-purgeAgeDuration), !dryRunBool)
+			if leader() {
+				storage.PurgeUploads(ctx, storageDriver, time.Now().Add(-purgeAgeDuration), !dryRunBool)
+			} else {
+				log.Infof("Skipping upload purge, another instance is the leader")
+			}
 			log.Infof("Starting upload purge in %s", intervalDuration)
 			time.Sleep(intervalDuration)
 		}
 	}()
 }
+
+// startTrashPurger schedules a goroutine which will periodically delete the
+// links deleted with soft deletion once they are older than the retention,
+// while leader reports the instance as the leader.
+func startTrashPurger(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}, leader func() bool) {
+	retention := 168 * time.Hour
+	interval := 24 * time.Hour
+	for key, dst := range map[string]*time.Duration{"retention": &retention, "interval": &interval} {
+		v, ok := config[key]
+		if !ok {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			panic(fmt.Sprintf("softdelete %s is not a string", key))
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			panic(fmt.Sprintf("softdelete %s must be a positive duration: %q", key, s))
+		}
+		*dst = d
+	}
+
+	go func() {
+		for {
+			if leader() {
+				storage.PurgeTrash(ctx, storageDriver, time.Now().Add(-retention), true)
+			} else {
+				log.Infof("Skipping trash purge, another instance is the leader")
+			}
+			log.Infof("Starting trash purge in %s", interval)
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// clientIP returns the IP of the client of r. The Forwarded and
+// X-Forwarded-For headers are only honored if r comes from one of the
+// trusted proxies.
+func (app *App) clientIP(r *http.Request) string {
+	return requestutil.TrustedRemoteIP(r, app.trustedProxies)
+}
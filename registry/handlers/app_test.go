@@ -1,22 +1,37 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/registry/auth"
 	_ "github.com/distribution/distribution/v3/registry/auth/silly"
+	_ "github.com/distribution/distribution/v3/registry/auth/token"
+	_ "github.com/distribution/distribution/v3/registry/auth/webhook"
+	"github.com/distribution/distribution/v3/registry/ratelimit"
 	"github.com/distribution/distribution/v3/registry/storage"
 	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // TestAppDispatcher builds an application with a test dispatcher and ensures
@@ -211,6 +226,451 @@ func TestNewApp(t *testing.T) {
 	}
 }
 
+// countingNamespace counts the repositories requested from its namespace.
+type countingNamespace struct {
+	distribution.Namespace
+	repositories atomic.Int32
+}
+
+func (n *countingNamespace) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+	n.repositories.Add(1)
+	return n.Namespace.Repository(ctx, name)
+}
+
+// TestNewAppWithNamespace ensures that an app serves the namespace it is
+// given, and that apps embedded in the same process keep their health checks
+// apart.
+func TestNewAppWithNamespace(t *testing.T) {
+	ctx, cancel := context.WithCancel(dcontext.Background())
+	defer cancel()
+
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	namespace := &countingNamespace{Namespace: registry}
+
+	newApp := func() *App {
+		config := &configuration.Configuration{}
+		config.Health.FileCheckers = []configuration.FileChecker{{File: t.TempDir(), Interval: time.Hour}}
+		app := NewAppWithNamespace(ctx, config, namespace)
+		app.RegisterHealthChecks()
+		return app
+	}
+	first, second := newApp(), newApp()
+	if first.HealthRegistry() == second.HealthRegistry() {
+		t.Fatal("expected the apps to have their own health registry")
+	}
+
+	server := httptest.NewServer(first)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v2/foo/embedded/manifests/latest")
+	if err != nil {
+		t.Fatalf("unexpected error during GET: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status code getting unknown manifest: %d", resp.StatusCode)
+	}
+	if namespace.repositories.Load() == 0 {
+		t.Fatal("expected the app to serve the repository of its namespace")
+	}
+}
+
+// TestNewAppAccessPolicy checks that requests denied by the access policy
+// are answered with DENIED and the policy's message.
+// TestNewAppInfoAccess ensures that the info route requires access to the
+// registry itself, like the catalog.
+func TestNewAppInfoAccess(t *testing.T) {
+	ctx := dcontext.Background()
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": nil,
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+	}
+
+	app := NewApp(ctx, &config)
+	server := httptest.NewServer(app)
+	defer server.Close()
+	builder, err := v2.NewURLBuilderFromString(server.URL, false)
+	if err != nil {
+		t.Fatalf("error creating urlbuilder: %v", err)
+	}
+
+	infoURL, err := builder.BuildInfoURL()
+	if err != nil {
+		t.Fatalf("error creating info url: %v", err)
+	}
+	resp, err := http.Get(infoURL)
+	if err != nil {
+		t.Fatalf("unexpected error during GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status code: %d != %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	expectedAuthHeader := `Bearer realm="realm-test",service="service-test",scope="registry:info:*"`
+	if e, a := expectedAuthHeader, resp.Header.Get("WWW-Authenticate"); e != a {
+		t.Fatalf("unexpected WWW-Authenticate header: %q != %q", e, a)
+	}
+}
+
+func TestNewAppAccessPolicy(t *testing.T) {
+	policy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"allow":   false,
+			"message": "namespace owned by another team",
+		})
+	}))
+	defer policy.Close()
+
+	ctx := dcontext.Background()
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": nil,
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+		AccessPolicy: configuration.AccessPolicy{
+			"webhook": {
+				"url": policy.URL,
+			},
+		},
+	}
+
+	server := httptest.NewServer(NewApp(ctx, &config))
+	defer server.Close()
+
+	tagsURL := server.URL + "/v2/team/app/tags/list"
+
+	req, err := http.NewRequest(http.MethodGet, tagsURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer sillytoken")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error during GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status code: %v != %v", resp.StatusCode, http.StatusForbidden)
+	}
+
+	var errs errcode.Errors
+	if err := json.NewDecoder(resp.Body).Decode(&errs); err != nil {
+		t.Fatalf("error decoding error response: %v", err)
+	}
+	e, ok := errs[0].(errcode.Error)
+	if !ok {
+		t.Fatalf("unexpected error type: %#v", errs[0])
+	}
+	if e.Code != errcode.ErrorCodeDenied {
+		t.Fatalf("unexpected error code: %v != %v", e.Code, errcode.ErrorCodeDenied)
+	}
+	if e.Detail != "namespace owned by another team" {
+		t.Fatalf("unexpected error detail: %v", e.Detail)
+	}
+}
+
+func TestNewAppRateLimit(t *testing.T) {
+	ctx := dcontext.Background()
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": nil,
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.RateLimit = configuration.RateLimit{
+		Enabled: true,
+		Read:    configuration.RateLimitBucket{Rate: 0.001, Burst: 2},
+		Repositories: []configuration.RateLimitOverride{
+			{Prefix: "library/", Read: &configuration.RateLimitBucket{Rate: 0.001, Burst: 4}},
+		},
+	}
+	config.HTTP.TrustedProxies = []string{"127.0.0.1"}
+
+	server := httptest.NewServer(NewApp(ctx, &config))
+	defer server.Close()
+
+	get := func(repo, forwardedFor string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/v2/"+repo+"/tags/list", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error during GET: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp := get("foo/bar", ""); resp.StatusCode == http.StatusTooManyRequests {
+			t.Fatalf("request %d unexpectedly throttled", i)
+		}
+	}
+
+	resp := get("foo/bar", "")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status code: %v != %v", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "1000" {
+		t.Fatalf("unexpected Retry-After header: %q", retryAfter)
+	}
+	var errs errcode.Errors
+	if err := json.NewDecoder(resp.Body).Decode(&errs); err != nil {
+		t.Fatalf("error decoding error response: %v", err)
+	}
+	if errs[0] != errcode.ErrorCodeTooManyRequests {
+		t.Fatalf("unexpected error: %#v", errs[0])
+	}
+
+	// Other repositories and clients have their own buckets.
+	if resp := get("foo/baz", ""); resp.StatusCode == http.StatusTooManyRequests {
+		t.Fatal("request to another repository throttled")
+	}
+	for i := 0; i < 2; i++ {
+		if resp := get("foo/bar", "192.0.2.1"); resp.StatusCode == http.StatusTooManyRequests {
+			t.Fatal("request from another client throttled")
+		}
+	}
+	// The client cannot escape its limit by prepending hops to the header
+	// of the trusted proxy.
+	if resp := get("foo/bar", "203.0.113.1, 192.0.2.1"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status code of a spoofed client: %v != %v", resp.StatusCode, http.StatusTooManyRequests)
+	}
+
+	// Writes are not limited.
+	for i := 0; i < 3; i++ {
+		resp, err := http.Post(server.URL+"/v2/foo/bar/blobs/uploads/", "", nil)
+		if err != nil {
+			t.Fatalf("unexpected error during POST: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusTooManyRequests {
+			t.Fatalf("write %d unexpectedly throttled", i)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		if resp := get("library/ubuntu", ""); resp.StatusCode == http.StatusTooManyRequests {
+			t.Fatalf("request %d to overridden repository unexpectedly throttled", i)
+		}
+	}
+	if resp := get("library/ubuntu", ""); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status code: %v != %v", resp.StatusCode, http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimiterLimits(t *testing.T) {
+	rl, err := newRateLimiter(nil, configuration.RateLimit{
+		Read:  configuration.RateLimitBucket{Rate: 10},
+		Write: configuration.RateLimitBucket{Rate: 1, Burst: 5},
+		Repositories: []configuration.RateLimitOverride{
+			{Prefix: "library/", Read: &configuration.RateLimitBucket{Rate: 100, Burst: 200}},
+			{Prefix: "library/internal/", Write: &configuration.RateLimitBucket{}},
+			{Prefix: "foo", Read: &configuration.RateLimitBucket{Rate: 50}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		repo        string
+		read, write *ratelimit.Limit
+	}{
+		{repo: "foo/bar", read: &ratelimit.Limit{Rate: 50, Burst: 50}, write: &ratelimit.Limit{Rate: 1, Burst: 5}},
+		{repo: "foobar", read: &ratelimit.Limit{Rate: 10, Burst: 10}, write: &ratelimit.Limit{Rate: 1, Burst: 5}},
+		{repo: "library", read: &ratelimit.Limit{Rate: 100, Burst: 200}, write: &ratelimit.Limit{Rate: 1, Burst: 5}},
+		{repo: "library/ubuntu", read: &ratelimit.Limit{Rate: 100, Burst: 200}, write: &ratelimit.Limit{Rate: 1, Burst: 5}},
+		{repo: "library/internal/base", read: &ratelimit.Limit{Rate: 10, Burst: 10}, write: nil},
+	} {
+		limits := rl.limits(tc.repo)
+		if !reflect.DeepEqual(limits.read, tc.read) || !reflect.DeepEqual(limits.write, tc.write) {
+			t.Errorf("unexpected limits for %s: %v, %v", tc.repo, limits.read, limits.write)
+		}
+	}
+
+	for _, config := range []configuration.RateLimit{
+		{Read: configuration.RateLimitBucket{Rate: -1}},
+		{Write: configuration.RateLimitBucket{Rate: 1, Burst: -1}},
+		{Repositories: []configuration.RateLimitOverride{{Read: &configuration.RateLimitBucket{Rate: 1}}}},
+		{Repositories: []configuration.RateLimitOverride{{Prefix: "/", Read: &configuration.RateLimitBucket{Rate: 1}}}},
+	} {
+		if _, err := newRateLimiter(nil, config); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+}
+
+func TestUploadLimiter(t *testing.T) {
+	if ul, err := newUploadLimiter(configuration.Limits{}); err != nil || ul != nil {
+		t.Fatalf("expected no limiter by default: %v, %v", ul, err)
+	}
+	if _, err := newUploadLimiter(configuration.Limits{UploadsPerClient: -1}); err == nil {
+		t.Fatal("expected an error for a negative limit")
+	}
+
+	ul, err := newUploadLimiter(configuration.Limits{UploadsPerClient: 2, UploadsPerRepository: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var releases []func()
+	acquire := func(client, repo, expectedExceeded string) {
+		t.Helper()
+		release, exceeded := ul.acquire(client, repo)
+		if exceeded != expectedExceeded {
+			t.Fatalf("%s uploading to %s: expected %q limit to be exceeded, got %q", client, repo, expectedExceeded, exceeded)
+		}
+		if release != nil {
+			releases = append(releases, release)
+		}
+	}
+	acquire("alice", "foo", "")
+	acquire("alice", "foo", "")
+	acquire("alice", "bar", "client")
+	acquire("bob", "foo", "")
+	acquire("carol", "foo", "repository")
+	acquire("carol", "bar", "")
+
+	for _, release := range releases {
+		release()
+	}
+	if len(ul.inflight) != 0 {
+		t.Fatalf("expected no upload in flight, got %v", ul.inflight)
+	}
+}
+
+func TestNewReplicator(t *testing.T) {
+	ctx := dcontext.Background()
+	newConfig := func(replication configuration.Parameters) *configuration.Configuration {
+		config := &configuration.Configuration{Storage: configuration.Storage{"inmemory": nil}}
+		if replication != nil {
+			config.Storage["replication"] = replication
+		}
+		return config
+	}
+
+	replicator, err := NewReplicator(ctx, newConfig(nil), inmemory.New(), nil)
+	if err != nil || replicator != nil {
+		t.Fatalf("unexpected replicator without configuration: %v %v", replicator, err)
+	}
+
+	replicator, err = NewReplicator(ctx, newConfig(configuration.Parameters{
+		"secondary":    map[interface{}]interface{}{"inmemory": nil},
+		"workers":      2,
+		"retrydelay":   "100ms",
+		"scaninterval": "30s",
+	}), inmemory.New(), nil)
+	if err != nil || replicator == nil {
+		t.Fatalf("unexpected error creating replicator: %v", err)
+	}
+	replicator.Close()
+
+	for _, params := range []configuration.Parameters{
+		{},
+		{"secondary": map[interface{}]interface{}{"inmemory": nil, "filesystem": nil}},
+		{"secondary": map[interface{}]interface{}{"unknown": nil}},
+		{"secondary": map[interface{}]interface{}{"inmemory": nil}, "workers": "2"},
+		{"secondary": map[interface{}]interface{}{"inmemory": nil}, "workers": -1},
+		{"secondary": map[interface{}]interface{}{"inmemory": nil}, "retrydelay": "soon"},
+	} {
+		if _, err := NewReplicator(ctx, newConfig(params), inmemory.New(), nil); err == nil {
+			t.Errorf("expected an error for %v", params)
+		}
+	}
+}
+
+func TestConfigureUploadResume(t *testing.T) {
+	for _, tc := range []struct {
+		secret   string
+		resume   configuration.UploadResume
+		expected time.Duration
+	}{
+		{"", configuration.UploadResume{}, 0},
+		{"secret", configuration.UploadResume{}, defaultUploadResumeTimeout},
+		{"secret", configuration.UploadResume{Timeout: time.Minute}, time.Minute},
+		{"secret", configuration.UploadResume{Disabled: true}, 0},
+	} {
+		config := &configuration.Configuration{}
+		config.HTTP.Secret = tc.secret
+		config.HTTP.UploadResume = tc.resume
+
+		app := &App{Context: dcontext.Background()}
+		app.configureUploadResume(config)
+		app.configureSecret(config)
+		if app.uploadResumeTimeout != tc.expected {
+			t.Errorf("unexpected upload resume timeout with secret %q and %+v: %v != %v", tc.secret, tc.resume, app.uploadResumeTimeout, tc.expected)
+		}
+	}
+}
+
+func TestConfigureCoordination(t *testing.T) {
+	ctx, cancel := context.WithCancel(dcontext.Background())
+	defer cancel()
+
+	app := &App{Context: ctx}
+	app.configureCoordination(&configuration.Configuration{})
+	if app.elector != nil || !app.isLeader() {
+		t.Fatal("expected the instance to lead without coordination")
+	}
+
+	first := &App{Context: ctx, driver: inmemory.New()}
+	config := &configuration.Configuration{Coordination: configuration.Coordination{Lock: "storage", TTL: time.Minute}}
+	first.configureCoordination(config)
+	if first.elector == nil || !first.isLeader() {
+		t.Fatal("expected the instance to be elected")
+	}
+	second := &App{Context: dcontext.WithValues(ctx, map[string]interface{}{"instance.id": "second"}), driver: first.driver}
+	second.configureCoordination(config)
+	if second.isLeader() {
+		t.Fatal("expected a single leader")
+	}
+
+	for _, coordination := range []configuration.Coordination{
+		{Lock: "redis"},
+		{Lock: "zookeeper"},
+		{Lock: "storage", TTL: -time.Second},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a panic for %+v", coordination)
+				}
+			}()
+			app := &App{Context: ctx, driver: inmemory.New()}
+			app.configureCoordination(&configuration.Configuration{Coordination: coordination})
+		}()
+	}
+}
+
 // Test the access record accumulator
 func TestAppendAccessRecords(t *testing.T) {
 	repo := "testRepo"
@@ -275,3 +735,134 @@ func TestAppendAccessRecords(t *testing.T) {
 		t.Fatalf("Actual access record differs from expected")
 	}
 }
+
+// TestRequestIDLogging ensures that the request ids set in the trusted
+// request id header and the trace ids of the traceparent header are logged
+// with the access and error logs of the requests.
+func TestRequestIDLogging(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	dcontext.SetDefaultLogger(logrus.NewEntry(logger))
+	defer dcontext.SetDefaultLogger(logrus.NewEntry(logrus.StandardLogger()))
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": nil,
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.RequestID.TrustedHeader = "X-Request-Id"
+	app := NewApp(dcontext.Background(), &config)
+
+	for _, tc := range []struct {
+		name     string
+		path     string
+		id       string
+		expected string
+		msg      string
+	}{
+		{name: "access log", path: "/v2/", id: "proxy-id-1", expected: "proxy-id-1", msg: "response completed"},
+		{name: "error log", path: "/v2/foo/bar/tags/list", id: "proxy-id-2", expected: "proxy-id-2", msg: "response completed with error"},
+		{name: "invalid id", path: "/v2/", id: "proxy id", msg: "response completed"},
+		{name: "long id", path: "/v2/", id: strings.Repeat("a", maxRequestIDLength+1), msg: "response completed"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set("X-Request-Id", tc.id)
+			req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+			app.ServeHTTP(httptest.NewRecorder(), req)
+
+			var entry map[string]interface{}
+			dec := json.NewDecoder(&buf)
+			for {
+				entry = nil
+				if err := dec.Decode(&entry); err != nil {
+					t.Fatalf("no %q log entry: %v", tc.msg, err)
+				}
+				if entry["msg"] == tc.msg {
+					break
+				}
+			}
+
+			id, _ := entry["http.request.id"].(string)
+			if tc.expected != "" && id != tc.expected {
+				t.Fatalf("unexpected request id: %q != %q", id, tc.expected)
+			} else if tc.expected == "" && (id == "" || id == tc.id) {
+				t.Fatalf("untrusted request id logged: %q", id)
+			}
+			if entry["otel.trace.id"] != traceID {
+				t.Fatalf("unexpected trace id: %v != %v", entry["otel.trace.id"], traceID)
+			}
+		})
+	}
+}
+
+// TestTraceRoute ensures that the server spans of the requests are named
+// after their route, rather than their path.
+func TestTraceRoute(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	handler := traceRoute(v2.RouteNameManifest, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/v2/foo/bar/manifests/latest", nil)
+	ctx, span := tp.Tracer("test").Start(req.Context(), http.MethodGet)
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, got %d", len(spans))
+	}
+	if expected := "GET " + v2.RouteNameManifest; spans[0].Name() != expected {
+		t.Fatalf("unexpected span name: %q != %q", spans[0].Name(), expected)
+	}
+
+	// Requests which are not traced are served as is.
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+// TestSanitizeStorageErrors ensures that the transient failures of the
+// storage backend are served as UNAVAILABLE errors advising a retry, without
+// their details.
+func TestSanitizeStorageErrors(t *testing.T) {
+	throttled := storagedriver.Error{
+		DriverName: "s3aws",
+		Detail:     storagedriver.WithCategory(errors.New("SlowDown: please reduce your request rate"), storagedriver.ErrorCategoryThrottled),
+	}
+	permanent := storagedriver.Error{DriverName: "s3aws", Detail: errors.New("AccessDenied")}
+
+	errs := errcode.Errors{errcode.ErrorCodeUnknown.WithDetail(throttled)}
+	w := httptest.NewRecorder()
+	if err := errcode.ServeJSON(w, sanitizeStorageErrors(errs)); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status: %d != %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Fatalf("unexpected Retry-After header: %q", retryAfter)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `"code":"UNAVAILABLE"`) || !strings.Contains(body, `"category":"throttled"`) {
+		t.Fatalf("unexpected body: %s", body)
+	}
+	if strings.Contains(body, "SlowDown") {
+		t.Fatalf("details of the backend served: %s", body)
+	}
+	if _, ok := errs[0].(errcode.Error).Detail.(storagedriver.Error); !ok {
+		t.Fatal("the logged errors were modified")
+	}
+
+	// Other errors are served as is.
+	errs = errcode.Errors{errcode.ErrorCodeUnknown.WithDetail(permanent), errcode.ErrorCodeManifestUnknown}
+	if sanitized := sanitizeStorageErrors(errs); !reflect.DeepEqual(sanitized, errs) {
+		t.Fatalf("unexpected errors: %v", sanitized)
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/requestutil"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/google/uuid"
+	"github.com/opencontainers/go-digest"
+)
+
+// Actions recorded in the audit log.
+const (
+	auditActionManifestPut    = "manifest.put"
+	auditActionManifestDelete = "manifest.delete"
+	auditActionTagDelete      = "tag.delete"
+	auditActionBlobPut        = "blob.put"
+	auditActionBlobMount      = "blob.mount"
+	auditActionBlobDelete     = "blob.delete"
+)
+
+const defaultAuditRootDirectory = "/audit"
+
+// auditRecord describes a write operation on a repository.
+type auditRecord struct {
+	Timestamp  time.Time     `json:"timestamp"`
+	Actor      string        `json:"actor,omitempty"`
+	Repository string        `json:"repository"`
+	Action     string        `json:"action"`
+	Digest     digest.Digest `json:"digest,omitempty"`
+	Tag        string        `json:"tag,omitempty"`
+	ClientIP   string        `json:"clientip"`
+	UserAgent  string        `json:"useragent,omitempty"`
+	RequestID  string        `json:"requestid"`
+}
+
+// auditSink writes audit records.
+type auditSink interface {
+	Write(ctx context.Context, record auditRecord) error
+}
+
+// auditor records write operations to its sink.
+type auditor struct {
+	sink       auditSink
+	failClosed bool
+}
+
+// newAuditor configures an auditor, writing records through driver for the
+// storage sink.
+func newAuditor(config configuration.Audit, driver storagedriver.StorageDriver) (*auditor, error) {
+	var sink auditSink
+	switch config.Sink {
+	case "storage":
+		if driver == nil {
+			return nil, fmt.Errorf("storage sink requires a storage driver")
+		}
+		root := config.Storage.RootDirectory
+		if root == "" {
+			root = defaultAuditRootDirectory
+		}
+		if !path.IsAbs(root) {
+			return nil, fmt.Errorf("storage rootdirectory must be an absolute path: %q", root)
+		}
+		sink = &storageAuditSink{driver: driver, root: path.Clean(root)}
+	case "syslog":
+		var err error
+		sink, err = newSyslogAuditSink(config.Syslog.Network, config.Syslog.Address, config.Syslog.Tag)
+		if err != nil {
+			return nil, err
+		}
+	case "":
+		return nil, fmt.Errorf("no sink provided")
+	default:
+		return nil, fmt.Errorf("unknown sink %q", config.Sink)
+	}
+	return &auditor{sink: sink, failClosed: config.FailClosed}, nil
+}
+
+// audit records the action on the repository of the request, for the
+// manifest or blob dgst and the tag, either of which may be empty. It must
+// be called once the action succeeded, before the response is written. It
+// returns an error to fail the request with if the record cannot be written
+// and the audit log fails closed.
+func (ctx *Context) audit(r *http.Request, action string, dgst digest.Digest, tag string) error {
+	if ctx.auditor == nil {
+		return nil
+	}
+
+	record := auditRecord{
+		Timestamp:  time.Now().UTC(),
+		Actor:      dcontext.GetStringValue(ctx, userNameKey),
+		Repository: ctx.Repository.Named().Name(),
+		Action:     action,
+		Digest:     dgst,
+		Tag:        tag,
+		ClientIP:   requestutil.RemoteIP(r),
+		UserAgent:  r.UserAgent(),
+		RequestID:  dcontext.GetRequestID(ctx),
+	}
+	if err := ctx.auditor.sink.Write(ctx, record); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error writing audit record for %s: %v", action, err)
+		if ctx.auditor.failClosed {
+			return errcode.ErrorCodeUnavailable.WithDetail("audit log unavailable")
+		}
+	}
+	return nil
+}
+
+// storageAuditSink writes each record to its own file through a storage
+// driver, under a directory per day. Files are never rewritten, and their
+// names sort in the order the records were written.
+type storageAuditSink struct {
+	driver storagedriver.StorageDriver
+	root   string
+}
+
+// Write implements auditSink.
+func (s *storageAuditSink) Write(ctx context.Context, record auditRecord) error {
+	p, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	name := record.Timestamp.Format("20060102T150405.000000000Z") + "-" + uuid.NewString() + ".json"
+	return s.driver.PutContent(ctx, path.Join(s.root, record.Timestamp.Format("2006/01/02"), name), append(p, '\n'))
+}
@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package handlers
+
+import "errors"
+
+func newSyslogAuditSink(network, address, tag string) (auditSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
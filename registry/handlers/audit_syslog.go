@@ -0,0 +1,37 @@
+//go:build !windows && !plan9
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/syslog"
+)
+
+const defaultAuditSyslogTag = "registry-audit"
+
+// syslogAuditSink writes records, encoded in JSON, to a syslog server.
+type syslogAuditSink struct {
+	writer *syslog.Writer
+}
+
+func newSyslogAuditSink(network, address, tag string) (auditSink, error) {
+	if tag == "" {
+		tag = defaultAuditSyslogTag
+	}
+	writer, err := syslog.Dial(network, address, syslog.LOG_NOTICE|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogAuditSink{writer: writer}, nil
+}
+
+// Write implements auditSink.
+func (s *syslogAuditSink) Write(ctx context.Context, record auditRecord) error {
+	p, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.writer.Write(p)
+	return err
+}
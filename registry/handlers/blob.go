@@ -66,6 +66,12 @@ func (bh *blobHandler) GetBlob(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := blobs.ServeBlob(bh, w, r, desc.Digest); err != nil {
+		if _, ok := err.(distribution.ErrContentCorrupt); ok {
+			// The blob was written before its digest was found not to
+			// match: abort the response, so that the client does not take
+			// it as complete.
+			panic(http.ErrAbortHandler)
+		}
 		dcontext.GetLogger(bh).Debugf("unexpected error getting blob HTTP handler: %v", err)
 		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
@@ -93,6 +99,11 @@ func (bh *blobHandler) DeleteBlob(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if err := bh.audit(r, auditActionBlobDelete, bh.Digest, ""); err != nil {
+		bh.Errors = append(bh.Errors, err)
+		return
+	}
+
 	w.Header().Set("Content-Length", "0")
 	w.WriteHeader(http.StatusAccepted)
 }
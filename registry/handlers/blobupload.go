@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/internal/dcontext"
@@ -15,9 +18,25 @@ import (
 	"github.com/opencontainers/go-digest"
 )
 
+const (
+	// uploadResumeInitialBackoff and uploadResumeMaxBackoff bound the wait
+	// between the attempts to resume an upload the storage does not reflect.
+	uploadResumeInitialBackoff = 50 * time.Millisecond
+	uploadResumeMaxBackoff     = time.Second
+)
+
 // blobUploadDispatcher constructs and returns the blob upload handler for the
 // given request context.
 func blobUploadDispatcher(ctx *Context, r *http.Request) http.Handler {
+	if ctx.App.uploadLimiter != nil {
+		return ctx.App.uploadLimiter.limit(ctx, r, dispatchBlobUpload)
+	}
+	return dispatchBlobUpload(ctx, r)
+}
+
+// dispatchBlobUpload constructs the blob upload handler, which is not
+// limited by the upload limits.
+func dispatchBlobUpload(ctx *Context, r *http.Request) http.Handler {
 	buh := &blobUploadHandler{
 		Context: ctx,
 		UUID:    getUploadUUID(ctx),
@@ -48,6 +67,12 @@ func blobUploadDispatcher(ctx *Context, r *http.Request) http.Handler {
 	return handler
 }
 
+// uploadRedirector is implemented by the blob writers which may redirect the
+// upload of a whole blob to the storage backend.
+type uploadRedirector interface {
+	RedirectURL(r *http.Request, dgst digest.Digest) (string, error)
+}
+
 // blobUploadHandler handles the http blob upload process.
 type blobUploadHandler struct {
 	*Context
@@ -80,7 +105,9 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 	upload, err := blobs.Create(buh, options...)
 	if err != nil {
 		if ebm, ok := err.(distribution.ErrBlobMounted); ok {
-			if err := buh.writeBlobCreatedHeaders(w, ebm.Descriptor); err != nil {
+			if err := buh.audit(r, auditActionBlobMount, ebm.Descriptor.Digest, ""); err != nil {
+				buh.Errors = append(buh.Errors, err)
+			} else if err := buh.writeBlobCreatedHeaders(w, ebm.Descriptor); err != nil {
 				buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			}
 		} else if err == distribution.ErrUnsupported {
@@ -99,6 +126,7 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 	}
 
 	w.Header().Set("Docker-Upload-UUID", buh.Upload.ID())
+	w.Header().Set("OCI-Chunk-Min-Length", strconv.FormatInt(buh.chunkMinLength, 10))
 	w.WriteHeader(http.StatusAccepted)
 }
 
@@ -141,32 +169,18 @@ func (buh *blobUploadHandler) PatchBlobData(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	cr := r.Header.Get("Content-Range")
-	cl := r.Header.Get("Content-Length")
-	if cr != "" && cl != "" {
-		start, end, err := parseContentRange(cr)
-		if err != nil {
-			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
-			return
-		}
-		if start > end || start != buh.Upload.Size() {
-			buh.Errors = append(buh.Errors, errcode.ErrorCodeRangeInvalid)
-			return
-		}
+	if err := buh.checkContentRange(w, r); err != nil {
+		buh.Errors = append(buh.Errors, err)
+		return
+	}
 
-		clInt, err := strconv.ParseInt(cl, 10, 64)
-		if err != nil {
+	if err := copyFullPayload(buh, w, r, buh.Upload, buh.maxBlobChunkBytes, "blob PATCH"); err != nil {
+		if e, ok := payloadTooLarge(err); ok {
+			buh.Errors = append(buh.Errors, e)
+		} else {
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
-			return
 		}
-		if clInt != (end-start)+1 {
-			buh.Errors = append(buh.Errors, errcode.ErrorCodeSizeInvalid)
-			return
-		}
-	}
-
-	if err := copyFullPayload(buh, w, r, buh.Upload, -1, "blob PATCH"); err != nil {
-		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
+		buh.keepInterruptedUpload(w, r, err)
 		return
 	}
 
@@ -175,9 +189,41 @@ func (buh *blobUploadHandler) PatchBlobData(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	w.Header().Set("OCI-Chunk-Min-Length", strconv.FormatInt(buh.chunkMinLength, 10))
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// checkContentRange checks that the chunk described by the Content-Range
+// header of r, if any, starts where the upload ends and matches the
+// Content-Length header. Out of order chunks are rejected with the current
+// range of the upload, for the client to resume from.
+func (buh *blobUploadHandler) checkContentRange(w http.ResponseWriter, r *http.Request) error {
+	cr := r.Header.Get("Content-Range")
+	if cr == "" {
+		return nil
+	}
+
+	start, end, err := parseContentRange(cr)
+	if err != nil {
+		return errcode.ErrorCodeRangeInvalid.WithDetail(err.Error())
+	}
+	if start > end || start != buh.Upload.Size() {
+		buh.setUploadRangeHeaders(w)
+		return errcode.ErrorCodeRangeInvalid
+	}
+
+	if cl := r.Header.Get("Content-Length"); cl != "" {
+		clInt, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil {
+			return errcode.ErrorCodeUnknown.WithDetail(err.Error())
+		}
+		if clInt != (end-start)+1 {
+			return errcode.ErrorCodeSizeInvalid
+		}
+	}
+	return nil
+}
+
 // PutBlobUploadComplete takes the final request of a blob upload. The
 // request may include all the blob data or no blob data. Any data
 // provided is received and verified. If successful, the blob is linked
@@ -205,8 +251,32 @@ func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *ht
 		return
 	}
 
-	if err := copyFullPayload(buh, w, r, buh.Upload, -1, "blob PUT"); err != nil {
-		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
+	// A monolithic upload may be redirected to the storage backend. The
+	// client then completes it by repeating this request without content.
+	if redirector, ok := buh.Upload.(uploadRedirector); ok && r.ContentLength > 0 {
+		redirectURL, err := redirector.RedirectURL(r, dgst)
+		if err != nil {
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		if redirectURL != "" {
+			http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
+	if err := buh.checkContentRange(w, r); err != nil {
+		buh.Errors = append(buh.Errors, err)
+		return
+	}
+
+	if err := copyFullPayload(buh, w, r, buh.Upload, buh.maxBlobChunkBytes, "blob PUT"); err != nil {
+		if e, ok := payloadTooLarge(err); ok {
+			buh.Errors = append(buh.Errors, e)
+		} else {
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
+		}
+		buh.keepInterruptedUpload(w, r, err)
 		return
 	}
 
@@ -238,14 +308,21 @@ func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *ht
 
 		}
 
-		// Clean up the backend blob data if there was an error.
-		if err := buh.Upload.Cancel(buh); err != nil {
-			// If the cleanup fails, all we can do is observe and report.
-			dcontext.GetLogger(buh).Errorf("error canceling upload after error: %v", err)
+		// Clean up the backend blob data if there was an error, unless the
+		// request was interrupted.
+		if !buh.keepInterruptedUpload(w, r, err) {
+			if err := buh.Upload.Cancel(buh); err != nil {
+				// If the cleanup fails, all we can do is observe and report.
+				dcontext.GetLogger(buh).Errorf("error canceling upload after error: %v", err)
+			}
 		}
 
 		return
 	}
+	if err := buh.audit(r, auditActionBlobPut, desc.Digest, ""); err != nil {
+		buh.Errors = append(buh.Errors, err)
+		return
+	}
 	if err := buh.writeBlobCreatedHeaders(w, desc); err != nil {
 		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
@@ -293,8 +370,7 @@ func (buh *blobUploadHandler) ResumeBlobUpload(ctx *Context, r *http.Request) ht
 		})
 	}
 
-	blobs := ctx.Repository.Blobs(buh)
-	upload, err := blobs.Resume(buh, buh.UUID)
+	upload, err := buh.resumeUpload(ctx.Repository.Blobs(buh))
 	if err != nil {
 		dcontext.GetLogger(ctx).Errorf("error resolving upload: %v", err)
 		if err == distribution.ErrBlobUploadUnknown {
@@ -312,12 +388,62 @@ func (buh *blobUploadHandler) ResumeBlobUpload(ctx *Context, r *http.Request) ht
 	if size := upload.Size(); size != buh.State.Offset {
 		dcontext.GetLogger(ctx).Errorf("upload resumed at wrong offset: %d != %d", size, buh.State.Offset)
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			buh.setUploadRangeHeaders(w)
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeRangeInvalid.WithDetail(err))
 		})
 	}
 	return nil
 }
 
+// resumeUpload resumes the upload of the signed upload state. The state is
+// trusted over the storage: while the upload is unknown, or shorter than the
+// offset of the state, such as when its previous chunk was written by another
+// registry instance and the storage is eventually consistent, the upload is
+// resumed again until the storage catches up or the upload resume timeout
+// passes.
+func (buh *blobUploadHandler) resumeUpload(blobs distribution.BlobStore) (distribution.BlobWriter, error) {
+	deadline := time.Now().Add(buh.App.uploadResumeTimeout)
+	backoff := uploadResumeInitialBackoff
+	for attempt := 1; ; attempt++ {
+		upload, err := blobs.Resume(buh, buh.UUID)
+		behind := err == distribution.ErrBlobUploadUnknown || (err == nil && upload.Size() < buh.State.Offset)
+		if !behind || time.Now().Add(backoff).After(deadline) {
+			if !behind && attempt > 1 {
+				dcontext.GetLogger(buh).Infof("storage caught up with upload state after %d attempts", attempt)
+			}
+			return upload, err
+		}
+		if err == nil {
+			if err := upload.Close(); err != nil {
+				return nil, err
+			}
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-buh.Done():
+			return nil, buh.Err()
+		}
+		backoff = min(2*backoff, uploadResumeMaxBackoff)
+	}
+}
+
+// keepInterruptedUpload keeps the upload if err is caused by the cancellation
+// of the request, such as when its deadline passes, reporting whether it did.
+// The upload is closed with the headers of its current range, for the client
+// to resume it from.
+func (buh *blobUploadHandler) keepInterruptedUpload(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if err := buh.blobUploadResponse(w, r); err != nil {
+		dcontext.GetLogger(buh).Errorf("error closing interrupted upload: %v", err)
+	}
+	// The response carries an error.
+	w.Header().Del("Content-Length")
+	return true
+}
+
 // blobUploadResponse provides a standard request for uploading blobs and
 // chunk responses. This sets the correct headers but the response status is
 // left to the caller.
@@ -345,18 +471,23 @@ func (buh *blobUploadHandler) blobUploadResponse(w http.ResponseWriter, r *http.
 		return err
 	}
 
+	buh.setUploadRangeHeaders(w)
+	w.Header().Set("Location", uploadURL)
+	w.Header().Set("Content-Length", "0")
+
+	return nil
+}
+
+// setUploadRangeHeaders sets the headers describing the current range of the
+// upload, which are also returned with range errors.
+func (buh *blobUploadHandler) setUploadRangeHeaders(w http.ResponseWriter) {
 	endRange := buh.Upload.Size()
 	if endRange > 0 {
 		endRange = endRange - 1
 	}
 
-	w.Header().Set("Docker-Upload-UUID", buh.UUID)
-	w.Header().Set("Location", uploadURL)
-
-	w.Header().Set("Content-Length", "0")
+	w.Header().Set("Docker-Upload-UUID", buh.Upload.ID())
 	w.Header().Set("Range", fmt.Sprintf("0-%d", endRange))
-
-	return nil
 }
 
 // mountBlob attempts to mount a blob from another repository by its digest. If
@@ -1,14 +1,19 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/gorilla/handlers"
 )
@@ -21,7 +26,8 @@ func catalogDispatcher(ctx *Context, r *http.Request) http.Handler {
 	}
 
 	return handlers.MethodHandler{
-		http.MethodGet: http.HandlerFunc(catalogHandler.GetCatalog),
+		http.MethodGet:  http.HandlerFunc(catalogHandler.GetCatalog),
+		http.MethodHead: http.HandlerFunc(catalogHandler.HeadCatalog),
 	}
 }
 
@@ -38,6 +44,7 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 
 	q := r.URL.Query()
 	lastEntry := q.Get("last")
+	prefix := q.Get("q")
 
 	entries := defaultReturnedEntries
 	maximumConfiguredEntries := ch.App.Config.Catalog.MaxEntries
@@ -71,17 +78,22 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	if entries == 0 {
 		moreEntries = false
 	} else {
-		returnedRepositories, err := ch.App.registry.Repositories(ch.Context, repos, lastEntry)
+		page, err := ch.catalogPage(w, repos, prefix, lastEntry)
 		if err != nil {
-			_, pathNotFound := err.(driver.PathNotFoundError)
-			if err != io.EOF && !pathNotFound {
-				ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
-				return
-			}
-			// err is either io.EOF or not PathNotFoundError
-			moreEntries = false
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		filled = copy(repos, page.Repositories)
+		moreEntries = page.More
+	}
+
+	if enumerator, ok := ch.App.registry.(distribution.RepositoryPrefixEnumerator); ok {
+		err := ch.countEntries(w, func(ctx context.Context) (int, error) {
+			return ch.countRepositories(ctx, enumerator, prefix)
+		})
+		if err != nil {
+			dcontext.GetLogger(ch).Warnf("error counting repositories: %v", err)
 		}
-		filled = returnedRepositories
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -106,8 +118,139 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// catalogPage returns the page of len(repos) repositories starting with
+// prefix and following last, filling repos as it walks them. The pages of
+// the requests which see every repository are cached if the listing cache is
+// enabled.
+func (ch *catalogHandler) catalogPage(w http.ResponseWriter, repos []string, prefix, last string) (cache.CatalogPage, error) {
+	listings := ch.App.listingCache
+	if repositoryFilter(ch) != nil {
+		listings = nil
+	}
+	key := url.Values{"n": []string{strconv.Itoa(len(repos))}, "last": []string{last}, "q": []string{prefix}}.Encode()
+	if listings != nil {
+		page, hit, err := listings.CatalogPage(ch, key)
+		if err != nil {
+			dcontext.GetLogger(ch).Warnf("error reading the cached catalog page: %v", err)
+		}
+		setCacheHeader(w, hit)
+		if hit {
+			return page, nil
+		}
+	}
+
+	page := cache.CatalogPage{More: true}
+	filled, err := ch.repositories(repos, prefix, last)
+	if err != nil {
+		_, pathNotFound := err.(driver.PathNotFoundError)
+		if err != io.EOF && !pathNotFound {
+			return cache.CatalogPage{}, err
+		}
+		// err is either io.EOF or not PathNotFoundError
+		page.More = false
+	}
+	page.Repositories = repos[:filled]
+
+	if listings != nil {
+		if err := listings.SetCatalogPage(ch, key, page); err != nil {
+			dcontext.GetLogger(ch).Warnf("error caching the catalog page: %v", err)
+		}
+	}
+	return page, nil
+}
+
+// HeadCatalog returns the number of repositories of the catalog, starting
+// with the prefix of the q parameter, in the X-Total-Count header.
+func (ch *catalogHandler) HeadCatalog(w http.ResponseWriter, r *http.Request) {
+	if enumerator, ok := ch.App.registry.(distribution.RepositoryPrefixEnumerator); ok {
+		err := ch.countEntries(w, func(ctx context.Context) (int, error) {
+			return ch.countRepositories(ctx, enumerator, r.URL.Query().Get("q"))
+		})
+		if err != nil {
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// countRepositories counts the repositories starting with prefix which are
+// visible to the request, as they are walked.
+func (ch *catalogHandler) countRepositories(ctx context.Context, enumerator distribution.RepositoryPrefixEnumerator, prefix string) (int, error) {
+	filter := repositoryFilter(ch)
+	n := 0
+	err := enumerator.EnumeratePrefix(ctx, prefix, "", func(name string) error {
+		if filter == nil || filter(name) {
+			n++
+		}
+		// Not every storage driver stops walking when ctx is done.
+		return ctx.Err()
+	})
+	return n, err
+}
+
+// repositories fills repos with the repositories starting with prefix and
+// following last which are visible to the request, in the same manner as
+// distribution.Namespace.Repositories.
+func (ch *catalogHandler) repositories(repos []string, prefix, last string) (int, error) {
+	filter := repositoryFilter(ch)
+
+	if enumerator, ok := ch.App.registry.(distribution.RepositoryPrefixEnumerator); ok {
+		// Consume the repositories as they are walked, stopping as soon
+		// as one more than requested is found.
+		filled := 0
+		more := false
+		err := enumerator.EnumeratePrefix(ch.Context, prefix, last, func(name string) error {
+			if filter != nil && !filter(name) {
+				return nil
+			}
+			if filled == len(repos) {
+				more = true
+				return driver.ErrFilledBuffer
+			}
+			repos[filled] = name
+			filled++
+			return nil
+		})
+		if err == nil && !more {
+			err = io.EOF
+		}
+		return filled, err
+	}
+
+	if filter == nil && prefix == "" {
+		return ch.App.registry.Repositories(ch.Context, repos, last)
+	}
+
+	filled := 0
+	page := make([]string, len(repos))
+	for filled < len(repos) {
+		n, err := ch.App.registry.Repositories(ch.Context, page, last)
+		for _, name := range page[:n] {
+			if filled == len(repos) {
+				// More repositories may follow, let the client
+				// ask for them.
+				return filled, nil
+			}
+			if strings.HasPrefix(name, prefix) && (filter == nil || filter(name)) {
+				repos[filled] = name
+				filled++
+			}
+		}
+		if err != nil {
+			return filled, err
+		}
+		if n == 0 {
+			return filled, io.EOF
+		}
+		last = page[n-1]
+	}
+
+	return filled, nil
+}
+
 // Use the original URL from the request to create a new URL for
-// the link header
+// the link header, keeping its repository name filter
 func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string, error) {
 	calledURL, err := url.Parse(origURL)
 	if err != nil {
@@ -117,6 +260,9 @@ func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string,
 	v := url.Values{}
 	v.Add("n", strconv.Itoa(maxEntries))
 	v.Add("last", lastEntry)
+	if prefix := calledURL.Query().Get("q"); prefix != "" {
+		v.Add("q", prefix)
+	}
 
 	calledURL.RawQuery = v.Encode()
 
@@ -160,3 +160,21 @@ func authorizedResources(ctx context.Context) []auth.Resource {
 
 	return nil
 }
+
+type repositoryFilterKey struct{}
+
+// withRepositoryFilter returns a context which limits the repositories
+// visible to the request to those accepted by filter.
+func withRepositoryFilter(ctx context.Context, filter func(name string) bool) context.Context {
+	return context.WithValue(ctx, repositoryFilterKey{}, filter)
+}
+
+// repositoryFilter returns the filter limiting the repositories visible to
+// this request, or nil if all repositories are visible.
+func repositoryFilter(ctx context.Context) func(name string) bool {
+	if filter, ok := ctx.Value(repositoryFilterKey{}).(func(name string) bool); ok {
+		return filter
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+)
+
+const (
+	// totalCountHeader is the header of the total number of entries of a
+	// list, regardless of its pagination.
+	totalCountHeader = "X-Total-Count"
+
+	// totalCountEstimateHeader is the header of the number of entries of a
+	// list counted before the count budget was exceeded, a lower bound of
+	// their total.
+	totalCountEstimateHeader = "X-Total-Count-Estimate"
+)
+
+// countEntries calls count, which returns the number of entries counted even
+// if it fails, and sets the count header of the response. The count is
+// canceled with the request, and once the count budget of the configuration
+// is exceeded: the number of entries counted so far is then returned as an
+// estimate, unless none were counted.
+func (ctx *Context) countEntries(w http.ResponseWriter, count func(context.Context) (int, error)) error {
+	countCtx := context.Context(ctx)
+	if budget := ctx.App.Config.Catalog.CountBudget; budget > 0 {
+		var cancel context.CancelFunc
+		countCtx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+
+	n, err := count(countCtx)
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(countCtx.Err(), context.DeadlineExceeded) {
+			dcontext.GetLogger(ctx).Warnf("count budget exceeded after %d entries", n)
+			if n > 0 {
+				w.Header().Set(totalCountEstimateHeader, strconv.Itoa(n))
+			}
+			return nil
+		}
+		return err
+	}
+	w.Header().Set(totalCountHeader, strconv.Itoa(n))
+	return nil
+}
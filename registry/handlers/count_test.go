@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestCountEntriesBudget(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.Catalog.CountBudget = 10 * time.Millisecond
+	ctx := &Context{App: &App{Config: config}, Context: context.Background()}
+
+	// The count is not done within the budget.
+	w := httptest.NewRecorder()
+	err := ctx.countEntries(w, func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 5, ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c, e := w.Header().Get(totalCountHeader), w.Header().Get(totalCountEstimateHeader); c != "" || e != "5" {
+		t.Fatalf("unexpected count %q and estimate %q", c, e)
+	}
+
+	// Counting nothing within the budget gives no estimate.
+	w = httptest.NewRecorder()
+	err = ctx.countEntries(w, func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	if err != nil || len(w.Header()) != 0 {
+		t.Fatalf("unexpected headers %v: %v", w.Header(), err)
+	}
+
+	// Other errors are returned.
+	countErr := errors.New("count failed")
+	err = ctx.countEntries(httptest.NewRecorder(), func(ctx context.Context) (int, error) {
+		return 1, countErr
+	})
+	if !errors.Is(err, countErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The count is canceled with the request.
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx.Context = canceled
+	err = ctx.countEntries(httptest.NewRecorder(), func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 5, ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the count to be canceled, got %v", err)
+	}
+}
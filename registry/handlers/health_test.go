@@ -1,17 +1,31 @@
 package handlers
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/health"
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 )
 
 func TestFileHealthCheck(t *testing.T) {
@@ -208,3 +222,196 @@ func TestHTTPHealthCheck(t *testing.T) {
 		t.Fatal("expected 0 items in health check results")
 	}
 }
+
+// writeRootCertBundle writes a self-signed certificate for token
+// authentication, returning its path.
+func writeRootCertBundle(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "token issuer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "root.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDependencyHealthChecks(t *testing.T) {
+	interval := 100 * time.Millisecond
+
+	var failing atomic.Bool
+	dependency := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("expected HEAD request, got %s", r.Method)
+		}
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		// A dependency refusing the unauthenticated check is still up.
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer dependency.Close()
+
+	// Nothing listens on the redis address.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not create listener: %v", err)
+	}
+	redisAddr := ln.Addr().String()
+	ln.Close()
+
+	checker := configuration.DependencyChecker{Enabled: true, Interval: interval}
+	for _, degraded := range []bool{true, false} {
+		failing.Store(false)
+		config := &configuration.Configuration{
+			Storage: configuration.Storage{
+				"inmemory": configuration.Parameters{},
+				"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+					"enabled": false,
+				}},
+			},
+			Auth: configuration.Auth{
+				"token": configuration.Parameters{
+					"realm":          dependency.URL + "/token",
+					"service":        "registry",
+					"issuer":         "issuer",
+					"rootcertbundle": writeRootCertBundle(t),
+				},
+			},
+			Redis: configuration.Redis{Addr: redisAddr},
+			Notifications: configuration.Notifications{
+				Endpoints: []configuration.Endpoint{
+					{Name: "listener", URL: dependency.URL + "/events"},
+					{Name: "disabled", URL: dependency.URL + "/disabled", Disabled: true},
+				},
+			},
+			Health: configuration.Health{
+				Dependencies: configuration.Dependencies{
+					Degraded:      degraded,
+					Redis:         checker,
+					Notifications: checker,
+					TokenService:  checker,
+				},
+			},
+		}
+
+		ctx, cancel := context.WithCancel(dcontext.Background())
+		app := NewApp(ctx, config)
+		healthRegistry := health.NewRegistry()
+		app.RegisterHealthChecks(healthRegistry)
+
+		<-time.After(3 * interval)
+		status := healthRegistry.CheckStatus(ctx)
+		if len(status) != 1 || status["redis"] == "" {
+			t.Fatalf("expected only redis to fail, got %v", status)
+		}
+		if healthRegistry.Unavailable(status) == degraded {
+			t.Fatalf("unexpected availability with degraded %t: %v", degraded, status)
+		}
+
+		failing.Store(true)
+		<-time.After(3 * interval)
+		status = healthRegistry.CheckStatus(ctx)
+		for _, name := range []string{"redis", "notifications_listener", "tokenservice"} {
+			if status[name] == "" {
+				t.Errorf("expected %s to fail, got %v", name, status)
+			}
+		}
+		if _, ok := status["notifications_disabled"]; ok {
+			t.Errorf("unexpected check of disabled endpoint: %v", status)
+		}
+		cancel()
+	}
+}
+
+// failingDriver fails to stat paths while failing is set.
+type failingDriver struct {
+	storagedriver.StorageDriver
+	failing atomic.Bool
+}
+
+func (d *failingDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	if d.failing.Load() {
+		return nil, errors.New("storage unreachable")
+	}
+	return d.StorageDriver.Stat(ctx, path)
+}
+
+// TestHealthChecksPerApp ensures that apps with different storage drivers in
+// the same process report their health independently.
+func TestHealthChecksPerApp(t *testing.T) {
+	ctx, cancel := context.WithCancel(dcontext.Background())
+	defer cancel()
+
+	driver := &failingDriver{StorageDriver: inmemory.New()}
+	factory.Register("failinginmemory", &clockedDriverFactory{driver: driver})
+
+	newApp := func(storage configuration.Storage) *App {
+		storage["maintenance"] = configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+			"enabled": false,
+		}}
+		config := &configuration.Configuration{Storage: storage}
+		config.Health.StorageDriver.Enabled = true
+		config.Health.StorageDriver.Interval = 10 * time.Millisecond
+		config.Health.StorageDriver.Threshold = 1
+		app := NewApp(ctx, config)
+		app.RegisterHealthChecks()
+		return app
+	}
+	internal := newApp(configuration.Storage{"failinginmemory": configuration.Parameters{}})
+	external := newApp(configuration.Storage{"inmemory": configuration.Parameters{}})
+
+	driver.failing.Store(true)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(internal.HealthRegistry().CheckStatus(ctx)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the storage driver check of the internal app to fail")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := internal.HealthRegistry().CheckStatus(ctx); status["storagedriver_failinginmemory"] == "" {
+		t.Fatalf("unexpected health status of the internal app: %v", status)
+	}
+	if status := external.HealthRegistry().CheckStatus(ctx); len(status) != 0 {
+		t.Fatalf("unexpected health status of the external app: %v", status)
+	}
+	if status := health.CheckStatus(ctx); len(status) != 0 {
+		t.Fatalf("unexpected health status of the default registry: %v", status)
+	}
+
+	for _, tc := range []struct {
+		app    *App
+		status int
+	}{
+		{app: internal, status: http.StatusServiceUnavailable},
+		{app: external, status: http.StatusOK},
+	} {
+		handler := tc.app.HealthRegistry().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v2/", nil))
+		if recorder.Code != tc.status {
+			t.Errorf("unexpected status code of %s app: %d != %d", tc.app.Config.Storage.Type(), recorder.Code, tc.status)
+		}
+	}
+
+	driver.failing.Store(false)
+	deadline = time.Now().Add(5 * time.Second)
+	for len(internal.HealthRegistry().CheckStatus(ctx)) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the internal app to recover")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
 )
 
 // closeResources closes all the provided resources after running the target
@@ -27,17 +28,41 @@ func closeResources(handler http.Handler, closers ...io.Closer) http.Handler {
 // receives less content than expected, and the client disconnected during the
 // upload, it avoids sending a 400 error to keep the logs cleaner.
 //
-// The copy will be limited to `limit` bytes, if limit is greater than zero.
+// The copy will be limited to `limit` bytes, if limit is greater than zero. A
+// larger payload is rejected with an *http.MaxBytesError, before anything is
+// copied if the request declares its content length.
+//
+// The copy stops once ctx is done. If the deadline of the request passes, the
+// error of ctx is returned.
 func copyFullPayload(ctx context.Context, responseWriter http.ResponseWriter, r *http.Request, destWriter io.Writer, limit int64, action string) error {
 	// Get a channel that tells us if the client disconnects
 	clientClosed := r.Context().Done()
 	body := r.Body
 	if limit > 0 {
+		if r.ContentLength > limit {
+			return &http.MaxBytesError{Limit: limit}
+		}
 		body = http.MaxBytesReader(responseWriter, body, limit)
 	}
 
+	if deadline, ok := ctx.Deadline(); ok {
+		// Unblock the reads from a stalled client once the deadline passes.
+		// Not every response writer supports it, in which case the copy only
+		// stops at the next read.
+		_ = http.NewResponseController(responseWriter).SetReadDeadline(deadline)
+	}
+
 	// Read in the data, if any.
-	copied, err := io.Copy(destWriter, body)
+	copied, err := io.Copy(destWriter, &contextReader{ctx: ctx, r: body})
+	if err != nil && requestTimedOut(ctx) {
+		dcontext.GetLoggerWithFields(ctx, map[interface{}]interface{}{
+			"copied":        copied,
+			"contentLength": r.ContentLength,
+		}, "copied", "contentLength").Error("request deadline exceeded during " + action)
+		// The failed read may cancel the request before the deadline of ctx
+		// is noticed.
+		return context.DeadlineExceeded
+	}
 	if clientClosed != nil && (err != nil || (r.ContentLength > 0 && copied < r.ContentLength)) {
 		// Didn't receive as much content as expected. Did the client
 		// disconnect during the request? If so, avoid returning a 400
@@ -61,13 +86,39 @@ func copyFullPayload(ctx context.Context, responseWriter http.ResponseWriter, r
 	}
 
 	if err != nil {
-		dcontext.GetLogger(ctx).Errorf("unknown error reading request payload: %v", err)
+		var maxBytesErr *http.MaxBytesError
+		if !errors.As(err, &maxBytesErr) {
+			dcontext.GetLogger(ctx).Errorf("unknown error reading request payload: %v", err)
+		}
 		return err
 	}
 
 	return nil
 }
 
+// contextReader reads from r until ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// payloadTooLarge returns the error reported to the client when err, returned
+// by copyFullPayload, is caused by a payload exceeding its limit.
+func payloadTooLarge(err error) (errcode.Error, bool) {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return errcode.Error{}, false
+	}
+	return errcode.ErrorCodeSizeExceeded.WithDetail(map[string]int64{"limit": maxBytesErr.Limit}), true
+}
+
 func parseContentRange(cr string) (start int64, end int64, err error) {
 	rStart, rEnd, ok := strings.Cut(cr, "-")
 	if !ok {
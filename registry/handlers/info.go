@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/version"
+	"github.com/gorilla/handlers"
+)
+
+// registryFeatures lists the optional features of the registry, and whether
+// they are enabled.
+type registryFeatures struct {
+	Delete         bool `json:"delete"`
+	Redirect       bool `json:"redirect"`
+	UploadRedirect bool `json:"uploadredirect"`
+	Replication    bool `json:"replication"`
+	ReadOnly       bool `json:"readonly"`
+	Validation     bool `json:"validation"`
+	Proxy          bool `json:"proxy"`
+	ProxyPush      bool `json:"proxypush"`
+	TagDelete      bool `json:"tagdelete"`
+}
+
+type infoAPIResponse struct {
+	Version  string           `json:"version"`
+	Revision string           `json:"revision"`
+	Storage  string           `json:"storage"`
+	Features registryFeatures `json:"features"`
+}
+
+func infoDispatcher(ctx *Context, r *http.Request) http.Handler {
+	infoHandler := &infoHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(infoHandler.GetInfo),
+	}
+}
+
+type infoHandler struct {
+	*Context
+}
+
+// GetInfo describes the build of the registry, and the features enabled by
+// its configuration.
+func (ih *infoHandler) GetInfo(w http.ResponseWriter, r *http.Request) {
+	features := ih.App.features
+	features.ReadOnly = ih.App.readOnly
+	features.Validation = ih.App.Config.Validation.Enabled
+	features.Proxy = ih.App.isCache
+	features.ProxyPush = ih.App.isCache && ih.App.Config.Proxy.AllowPush
+	features.TagDelete = !ih.App.Config.HTTP.APIs.TagDelete.Disabled
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(infoAPIResponse{
+		Version:  version.Version(),
+		Revision: version.Revision(),
+		Storage:  ih.App.Config.Storage.Type(),
+		Features: features,
+	}); err != nil {
+		ih.Errors = append(ih.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
+	rediscache "github.com/distribution/distribution/v3/registry/storage/cache/redis"
+)
+
+// cacheHeader reports whether a listing was served from the listing cache.
+const cacheHeader = "X-Cache"
+
+// configureListingCache sets up the cache of the tag lists and the catalog
+// pages, configured by the listing parameters of the storage cache section.
+func (app *App) configureListingCache(cc configuration.Parameters) {
+	ttl := cache.DefaultListingTTL
+	switch v := cc["listingttl"].(type) {
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			panic(fmt.Sprintf("cache listingttl must be a positive duration: %q", v))
+		}
+		ttl = d
+	case nil:
+	default:
+		panic("cache listingttl config key must have a duration value")
+	}
+
+	switch v := cc["listing"]; v {
+	case "redis":
+		if app.redis == nil {
+			panic("redis configuration required to use for listing cache")
+		}
+		app.listingCache = rediscache.NewRedisListingCache(app.redis, ttl)
+		dcontext.GetLogger(app).Infof("using redis listing cache")
+	case "inmemory":
+		size := memorycache.DefaultListingSize
+		if configuredSize, ok := cc["listingsize"]; ok {
+			var err error
+			// Since Parameters is not strongly typed, render to a string and convert back
+			size, err = strconv.Atoi(fmt.Sprint(configuredSize))
+			if err != nil || size <= 0 {
+				panic(fmt.Sprintf("invalid listingsize value %v", configuredSize))
+			}
+		}
+		app.listingCache = memorycache.NewInMemoryListingCache(size, ttl)
+		dcontext.GetLogger(app).Infof("using inmemory listing cache")
+	case nil, "":
+	default:
+		dcontext.GetLogger(app).Warnf("unknown listing cache type %q, listing caching disabled", v)
+	}
+}
+
+// setCacheHeader reports whether the listing was served from the cache.
+func setCacheHeader(w http.ResponseWriter, hit bool) {
+	if hit {
+		w.Header().Set(cacheHeader, "hit")
+	} else {
+		w.Header().Set(cacheHeader, "miss")
+	}
+}
+
+// invalidateListings drops the cached listings of the repository, after it
+// was written to.
+func (ctx *Context) invalidateListings() {
+	if ctx.App.listingCache == nil {
+		return
+	}
+	if err := ctx.App.listingCache.Invalidate(ctx, ctx.Repository.Named().Name()); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error invalidating the listings of %s: %v", ctx.Repository.Named().Name(), err)
+	}
+}
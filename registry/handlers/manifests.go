@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"mime"
 	"net/http"
@@ -10,6 +12,7 @@ import (
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest"
 	"github.com/distribution/distribution/v3/manifest/manifestlist"
 	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/manifest/schema2"
@@ -26,8 +29,13 @@ import (
 const (
 	defaultArch         = "amd64"
 	defaultOS           = "linux"
-	maxManifestBodySize = 4 * 1024 * 1024
+	maxManifestBodySize = 4 * 1024 * 1024 // default limit of manifest PUT bodies
 	imageClass          = "image"
+
+	// Schema1 media types are no longer supported, but are recognized so
+	// that pushes can be rejected with a dedicated error code.
+	mediaTypeSchema1Manifest       = "application/vnd.docker.distribution.manifest.v1+json"
+	mediaTypeSchema1SignedManifest = "application/vnd.docker.distribution.manifest.v1+prettyjws"
 )
 
 type storageType int
@@ -85,7 +93,10 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		imh.Errors = append(imh.Errors, err)
 		return
 	}
-	var supports [numStorageTypes]bool
+	var (
+		supports       [numStorageTypes]bool
+		acceptsSchema1 bool
+	)
 
 	// this parsing of Accept headers is not quite as full-featured as godoc.org's parser, but we don't care about "q=" values
 	// https://github.com/golang/gddo/blob/e91d4165076d7474d20abda83f92d15c7ebc3e81/httputil/header/header.go#L165-L202
@@ -112,6 +123,9 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 			if mediaType == v1.MediaTypeImageIndex {
 				supports[ociImageIndexSchema] = true
 			}
+			if isSchema1MediaType(mediaType) {
+				acceptsSchema1 = true
+			}
 		}
 	}
 
@@ -127,13 +141,25 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 			return
 		}
 		imh.Digest = desc.Digest
+		imh.warnTagPull(w)
+	}
+	if acceptsSchema1 && !supports[manifestSchema2] && !supports[ociSchema] {
+		imh.warnSchema1(w)
 	}
 
 	if etagMatch(r, imh.Digest.String()) {
+		w.Header().Set("Docker-Content-Digest", imh.Digest.String())
+		w.Header().Set("Etag", fmt.Sprintf(`"%s"`, imh.Digest))
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
+	if statter, ok := manifests.(manifestStatter); ok && r.Method == http.MethodHead {
+		if imh.statManifest(w, statter, supports) {
+			return
+		}
+	}
+
 	var options []distribution.ManifestServiceOption
 	if imh.Tag != "" {
 		options = append(options, distribution.WithTag(imh.Tag))
@@ -142,6 +168,8 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnknown.WithDetail(err))
+		} else if _, ok := err.(distribution.ErrContentCorrupt); ok {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeContentCorrupt.WithDetail(err))
 		} else {
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		}
@@ -192,6 +220,8 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		if err != nil {
 			if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
 				imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnknown.WithDetail(err))
+			} else if _, ok := err.(distribution.ErrContentCorrupt); ok {
+				imh.Errors = append(imh.Errors, errcode.ErrorCodeContentCorrupt.WithDetail(err))
 			} else {
 				imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			}
@@ -225,10 +255,66 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// manifestStatter is implemented by the manifest services which can describe
+// a manifest more cheaply than they get it, such as the one of a pull-through
+// cache, which stats manifests it has not cached on the remote. Services
+// wrapping others return distribution.ErrUnsupported when the wrapped one
+// cannot.
+type manifestStatter interface {
+	Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error)
+}
+
+// statManifest answers a HEAD request for the manifest imh.Digest from its
+// descriptor, and reports whether it did. Manifest lists requested by tag by
+// clients which do not support them are left to GetManifest, which rewrites
+// them.
+func (imh *manifestHandler) statManifest(w http.ResponseWriter, statter manifestStatter, supports [numStorageTypes]bool) bool {
+	desc, err := statter.Stat(imh, imh.Digest)
+	if errors.Is(err, distribution.ErrUnsupported) {
+		return false
+	}
+	if err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnknown.WithDetail(err))
+		} else {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return true
+	}
+
+	switch desc.MediaType {
+	case v1.MediaTypeImageManifest:
+		if !supports[ociSchema] {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnknown.WithMessage("OCI manifest found, but accept header does not support OCI manifests"))
+			return true
+		}
+	case v1.MediaTypeImageIndex:
+		if !supports[ociImageIndexSchema] {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnknown.WithMessage("OCI index found, but accept header does not support OCI indexes"))
+			return true
+		}
+	case manifestlist.MediaTypeManifestList:
+		if imh.Tag != "" && !supports[manifestlistSchema] {
+			return false
+		}
+	}
+
+	w.Header().Set("Content-Type", desc.MediaType)
+	w.Header().Set("Content-Length", fmt.Sprint(desc.Size))
+	w.Header().Set("Docker-Content-Digest", imh.Digest.String())
+	w.Header().Set("Etag", fmt.Sprintf(`"%s"`, imh.Digest))
+	return true
+}
+
+// etagMatch reports whether the If-None-Match header of r matches etag.
+// As If-None-Match uses the weak comparison, weak entity tags match too.
 func etagMatch(r *http.Request, etag string) bool {
 	for _, headerVal := range r.Header["If-None-Match"] {
-		if headerVal == etag || headerVal == fmt.Sprintf(`"%s"`, etag) { // allow quoted or unquoted
-			return true
+		for _, candidate := range strings.Split(headerVal, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag || candidate == fmt.Sprintf(`"%s"`, etag) { // allow quoted or unquoted
+				return true
+			}
 		}
 	}
 	return false
@@ -244,13 +330,23 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 	}
 
 	var jsonBuf bytes.Buffer
-	if err := copyFullPayload(imh, w, r, &jsonBuf, maxManifestBodySize, "image manifest PUT"); err != nil {
+	if err := copyFullPayload(imh, w, r, &jsonBuf, imh.maxManifestBodyBytes, "image manifest PUT"); err != nil {
 		// copyFullPayload reports the error if necessary
-		imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithDetail(err.Error()))
+		if e, ok := payloadTooLarge(err); ok {
+			imh.Errors = append(imh.Errors, e)
+		} else {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithDetail(err.Error()))
+		}
 		return
 	}
 
 	mediaType := r.Header.Get("Content-Type")
+	if isSchema1MediaType(mediaType) {
+		imh.warnSchema1(w)
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestSchemaV1Disabled)
+		return
+	}
+
 	manifest, desc, err := distribution.UnmarshalManifest(mediaType, jsonBuf.Bytes())
 	if err != nil {
 		imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithDetail(err))
@@ -288,6 +384,8 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	imh.warnNoAnnotations(w, manifest)
+
 	_, err = manifests.Put(imh, manifest, options...)
 	if err != nil {
 		// TODO(stevvooe): These error handling switches really need to be
@@ -310,6 +408,8 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 					imh.Errors = append(imh.Errors, errcode.ErrorCodeNameInvalid.WithDetail(err))
 				case distribution.ErrManifestUnverified:
 					imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnverified)
+				case distribution.ErrManifestNotCanonical, distribution.ErrManifestMediaTypeNotAllowed, distribution.ErrManifestImageConfigInvalid:
+					imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestInvalid.WithDetail(verificationError))
 				default:
 					if verificationError == digest.ErrDigestInvalidFormat {
 						imh.Errors = append(imh.Errors, errcode.ErrorCodeDigestInvalid)
@@ -328,13 +428,20 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 
 	// Tag this manifest
 	if imh.Tag != "" {
+		imh.warnEquivalentManifest(w, manifests, desc.Digest, jsonBuf.Bytes())
+
 		tags := imh.Repository.Tags(imh)
 		err = tags.Tag(imh, imh.Tag, desc)
-		if err != nil {
+	}
+	// The repository, or the tag, may be new.
+	imh.invalidateListings()
+	if err != nil {
+		if _, ok := err.(distribution.ErrTagImmutable); ok {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeTagImmutable.WithDetail(err))
+		} else {
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
-			return
 		}
-
+		return
 	}
 
 	// Construct a canonical url for the uploaded manifest.
@@ -352,6 +459,18 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		dcontext.GetLogger(imh).Errorf("error building manifest url from digest: %v", err)
 	}
 
+	if err := imh.audit(r, auditActionManifestPut, imh.Digest, imh.Tag); err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return
+	}
+
+	// The OCI-Subject header tells the client that the referrers of the
+	// subject are indexed, so that it need not maintain the referrers tag.
+	if subject := manifestSubject(manifest); subject != nil {
+		if _, ok := manifests.(distribution.ManifestReferrers); ok {
+			w.Header().Set("OCI-Subject", subject.Digest.String())
+		}
+	}
 	w.Header().Set("Location", location)
 	w.Header().Set("Docker-Content-Digest", imh.Digest.String())
 	w.WriteHeader(http.StatusCreated)
@@ -359,6 +478,41 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 	dcontext.GetLogger(imh).Debug("Succeeded in putting manifest!")
 }
 
+// warnEquivalentManifest warns the client, with a Warning header, if the
+// manifest currently tagged imh.Tag is semantically identical to payload but
+// serialized differently. Clients re-serializing manifests would otherwise
+// look them up by a digest the registry does not know.
+func (imh *manifestHandler) warnEquivalentManifest(w http.ResponseWriter, manifests distribution.ManifestService, dgst digest.Digest, payload []byte) {
+	current, err := imh.Repository.Tags(imh).Get(imh, imh.Tag)
+	if err != nil || current.Digest == dgst {
+		return
+	}
+	existing, err := manifests.Get(imh, current.Digest)
+	if err != nil {
+		return
+	}
+	_, existingPayload, err := existing.Payload()
+	if err != nil {
+		return
+	}
+	if equivalent, err := manifest.Equivalent(payload, existingPayload); err != nil || !equivalent {
+		return
+	}
+
+	dcontext.GetLogger(imh).Warnf("manifest %s pushed to tag %q is identical to the manifest %s it replaces but serialized differently", dgst, imh.Tag, current.Digest)
+	addWarning(w, fmt.Sprintf("manifest is identical to %s, previously tagged %s, but serialized differently", current.Digest, imh.Tag))
+}
+
+// isSchema1MediaType reports whether the Content-Type header of a manifest
+// PUT names a schema1 manifest.
+func isSchema1MediaType(ctHeader string) bool {
+	mediaType, _, err := mime.ParseMediaType(ctHeader)
+	if err != nil {
+		return false
+	}
+	return mediaType == mediaTypeSchema1Manifest || mediaType == mediaTypeSchema1SignedManifest
+}
+
 // applyResourcePolicy checks whether the resource class matches what has
 // been authorized and allowed by the policy configuration.
 func (imh *manifestHandler) applyResourcePolicy(manifest distribution.Manifest) error {
@@ -437,9 +591,15 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 	}
 
 	if imh.Tag != "" {
+		if imh.App.Config.HTTP.APIs.TagDelete.Disabled {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnsupported.WithMessage("tag delete is disabled"))
+			return
+		}
 		dcontext.GetLogger(imh).Debug("DeleteImageTag")
 		tagService := imh.Repository.Tags(imh.Context)
-		if err := tagService.Untag(imh.Context, imh.Tag); err != nil {
+		err := tagService.Untag(imh.Context, imh.Tag)
+		imh.invalidateListings()
+		if err != nil {
 			switch err.(type) {
 			case distribution.ErrTagUnknown, driver.PathNotFoundError:
 				imh.Errors = append(imh.Errors, errcode.ErrorCodeManifestUnknown.WithDetail(err))
@@ -448,6 +608,10 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 			}
 			return
 		}
+		if err := imh.audit(r, auditActionTagDelete, "", imh.Tag); err != nil {
+			imh.Errors = append(imh.Errors, err)
+			return
+		}
 		w.WriteHeader(http.StatusAccepted)
 		return
 	}
@@ -504,6 +668,12 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 	}
 	_ = g.Wait() // imh will record all errors, so ignore the error of Wait()
 	imh.Errors = errs
+	imh.invalidateListings()
+
+	if err := imh.audit(r, auditActionManifestDelete, imh.Digest, ""); err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return
+	}
 
 	w.WriteHeader(http.StatusAccepted)
 }
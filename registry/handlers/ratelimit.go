@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/ratelimit"
+	"github.com/docker/go-metrics"
+)
+
+// throttledRequests is the number of requests rejected by the rate limiter,
+// by operation class and by kind of client identity.
+var throttledRequests = prometheus.RateLimitNamespace.NewLabeledCounter("throttled_requests", "The number of requests rejected by the rate limiter", "operation", "client")
+
+func init() {
+	metrics.Register(prometheus.RateLimitNamespace)
+}
+
+// rateLimits holds the limits of each operation class.
+type rateLimits struct {
+	read, write *ratelimit.Limit
+}
+
+type rateLimitOverride struct {
+	prefix string
+	rateLimits
+}
+
+// rateLimiter limits the requests of each client to each repository, by
+// operation class.
+type rateLimiter struct {
+	limiter   ratelimit.Limiter
+	global    rateLimits
+	overrides []rateLimitOverride
+}
+
+// newRateLimiter configures a rateLimiter using limiter to keep the token
+// buckets.
+func newRateLimiter(limiter ratelimit.Limiter, config configuration.RateLimit) (*rateLimiter, error) {
+	rl := &rateLimiter{limiter: limiter}
+
+	var err error
+	if rl.global.read, err = parseRateLimitBucket("read", config.Read); err != nil {
+		return nil, err
+	}
+	if rl.global.write, err = parseRateLimitBucket("write", config.Write); err != nil {
+		return nil, err
+	}
+
+	for i, o := range config.Repositories {
+		prefix := strings.Trim(o.Prefix, "/")
+		if prefix == "" {
+			return nil, fmt.Errorf("repositories[%d]: no prefix provided", i)
+		}
+		override := rateLimitOverride{prefix: prefix, rateLimits: rl.global}
+		if o.Read != nil {
+			if override.read, err = parseRateLimitBucket(fmt.Sprintf("repositories[%d].read", i), *o.Read); err != nil {
+				return nil, err
+			}
+		}
+		if o.Write != nil {
+			if override.write, err = parseRateLimitBucket(fmt.Sprintf("repositories[%d].write", i), *o.Write); err != nil {
+				return nil, err
+			}
+		}
+		rl.overrides = append(rl.overrides, override)
+	}
+	// Try the longest prefixes first.
+	sort.SliceStable(rl.overrides, func(i, j int) bool {
+		return len(rl.overrides[i].prefix) > len(rl.overrides[j].prefix)
+	})
+
+	return rl, nil
+}
+
+// parseRateLimitBucket returns the limit configured by bucket, or nil if
+// the bucket does not limit requests.
+func parseRateLimitBucket(name string, bucket configuration.RateLimitBucket) (*ratelimit.Limit, error) {
+	if bucket.Rate < 0 || math.IsNaN(bucket.Rate) || math.IsInf(bucket.Rate, 0) {
+		return nil, fmt.Errorf("%s: invalid rate %v", name, bucket.Rate)
+	}
+	if bucket.Burst < 0 {
+		return nil, fmt.Errorf("%s: invalid burst %d", name, bucket.Burst)
+	}
+	if bucket.Rate == 0 {
+		return nil, nil
+	}
+	limit := &ratelimit.Limit{Rate: bucket.Rate, Burst: bucket.Burst}
+	if limit.Burst == 0 {
+		limit.Burst = int(math.Ceil(bucket.Rate))
+	}
+	return limit, nil
+}
+
+// limits returns the limits applying to the repository named repo. The
+// prefixes of the overrides match whole path components of repo.
+func (rl *rateLimiter) limits(repo string) rateLimits {
+	for _, o := range rl.overrides {
+		if repo == o.prefix || strings.HasPrefix(repo, o.prefix+"/") {
+			return o.rateLimits
+		}
+	}
+	return rl.global
+}
+
+// allow reports whether the request r may proceed, setting the Retry-After
+// header of w otherwise. Requests are allowed when the limiter fails.
+func (rl *rateLimiter) allow(ctx *Context, w http.ResponseWriter, r *http.Request) bool {
+	repo := getName(ctx)
+	limits := rl.limits(repo)
+
+	operation, limit := "write", limits.write
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		operation, limit = "read", limits.read
+	}
+	if limit == nil {
+		return true
+	}
+
+	// Only trust the user name of authenticated requests, clients could
+	// otherwise escape their limit by changing it.
+	client, identity := "user", dcontext.GetStringValue(ctx, userNameKey)
+	if identity == "" {
+		client, identity = "ip", ctx.App.clientIP(r)
+	}
+
+	key := strings.Join([]string{operation, client, identity, repo}, "|")
+	allowed, retryAfter, err := rl.limiter.Allow(ctx, key, *limit)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error checking rate limit: %v", err)
+		return true
+	}
+	if allowed {
+		return true
+	}
+
+	throttledRequests.WithValues(operation, client).Inc(1)
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	return false
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// defaultReferrersPageSize is the number of referrers listed by a response
+// if the page size is not configured.
+const defaultReferrersPageSize = 1000
+
+// referrersDispatcher constructs the handler of the referrers of a manifest.
+func referrersDispatcher(ctx *Context, r *http.Request) http.Handler {
+	dgst, err := getDigest(ctx)
+	if err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, errcode.ErrorCodeDigestInvalid.WithDetail(err))
+		})
+	}
+
+	referrersHandler := &referrersHandler{
+		Context: ctx,
+		Subject: dgst,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(referrersHandler.GetReferrers),
+	}
+}
+
+// referrersHandler lists the manifests referring to a manifest.
+type referrersHandler struct {
+	*Context
+
+	Subject digest.Digest
+}
+
+type referrersAPIResponse struct {
+	SchemaVersion int                     `json:"schemaVersion"`
+	MediaType     string                  `json:"mediaType"`
+	Manifests     []distribution.Referrer `json:"manifests"`
+}
+
+// GetReferrers returns the referrers of the subject as an image index, a
+// page at a time. They are filtered by the artifactType query parameter if
+// it is set.
+func (rh *referrersHandler) GetReferrers(w http.ResponseWriter, r *http.Request) {
+	manifests, err := rh.Repository.Manifests(rh)
+	if err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	lister, ok := manifests.(distribution.ManifestReferrers)
+	if !ok {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	q := r.URL.Query()
+	artifactType := q.Get("artifactType")
+	var last digest.Digest
+	if lastEntry := q.Get("last"); lastEntry != "" {
+		if last, err = digest.Parse(lastEntry); err != nil {
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeDigestInvalid.WithDetail(map[string]string{"last": lastEntry}))
+			return
+		}
+	}
+
+	pageSize := rh.App.Config.Referrers.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultReferrersPageSize
+	}
+	// One more referrer than a page tells whether there is a next one.
+	referrers, err := lister.Referrers(rh, rh.Subject, artifactType, last, pageSize+1)
+	if err != nil {
+		if errors.Is(err, distribution.ErrUnsupported) {
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+		} else {
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	if len(referrers) > pageSize {
+		referrers = referrers[:pageSize]
+		urlStr, err := createReferrersLinkEntry(r.URL.String(), referrers[pageSize-1].Digest)
+		if err != nil {
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		w.Header().Set("Link", urlStr)
+	}
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+
+	w.Header().Set("Content-Type", v1.MediaTypeImageIndex)
+	if err := json.NewEncoder(w).Encode(referrersAPIResponse{
+		SchemaVersion: 2,
+		MediaType:     v1.MediaTypeImageIndex,
+		Manifests:     referrers,
+	}); err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// createReferrersLinkEntry uses the original URL from the request to create
+// the link header of the page of referrers after lastEntry, keeping its
+// artifactType filter.
+func createReferrersLinkEntry(origURL string, lastEntry digest.Digest) (string, error) {
+	calledURL, err := url.Parse(origURL)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Add("last", lastEntry.String())
+	if artifactType := calledURL.Query().Get("artifactType"); artifactType != "" {
+		v.Add("artifactType", artifactType)
+	}
+
+	calledURL.RawQuery = v.Encode()
+
+	calledURL.Fragment = ""
+	return fmt.Sprintf("<%s>; rel=\"next\"", calledURL.String()), nil
+}
+
+// manifestSubject returns the subject of manifest, or nil if it has none.
+func manifestSubject(manifest distribution.Manifest) *distribution.Descriptor {
+	switch m := manifest.(type) {
+	case *ocischema.DeserializedManifest:
+		return m.Subject
+	case *ocischema.DeserializedImageIndex:
+		return m.Subject
+	}
+	return nil
+}
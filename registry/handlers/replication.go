@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+)
+
+// NewReplicator returns the Replicator configured by the replication
+// parameters of the storage configuration, copying from primary, or nil if
+// replication is not configured. If leader is not nil, the journal is only
+// scanned while it reports the instance as the leader.
+func NewReplicator(ctx context.Context, config *configuration.Configuration, primary storagedriver.StorageDriver, leader func() bool) (*storage.Replicator, error) {
+	params, ok := config.Storage["replication"]
+	if !ok {
+		return nil, nil
+	}
+
+	secondary, ok := params["secondary"].(map[interface{}]interface{})
+	if !ok || len(secondary) != 1 {
+		return nil, fmt.Errorf("replication secondary must configure exactly one storage driver")
+	}
+	var secondaryDriver storagedriver.StorageDriver
+	for name, v := range secondary {
+		driverParams := make(map[string]interface{})
+		switch v := v.(type) {
+		case map[interface{}]interface{}:
+			for k, param := range v {
+				driverParams[fmt.Sprint(k)] = param
+			}
+		case nil:
+		default:
+			return nil, fmt.Errorf("replication secondary %v parameters must be a map", name)
+		}
+		var err error
+		secondaryDriver, err = factory.Create(ctx, fmt.Sprint(name), driverParams)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct replication secondary %v driver: %v", name, err)
+		}
+	}
+
+	opts := storage.ReplicationOptions{Leader: leader}
+	for key, dst := range map[string]*int{"workers": &opts.Workers, "queuesize": &opts.QueueSize, "maxretries": &opts.MaxRetries} {
+		switch v := params[key].(type) {
+		case int:
+			*dst = v
+		case nil:
+		default:
+			return nil, fmt.Errorf("replication %s must be an integer", key)
+		}
+	}
+	for key, dst := range map[string]*time.Duration{"retrydelay": &opts.RetryDelay, "scaninterval": &opts.ScanInterval} {
+		switch v := params[key].(type) {
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("replication %s: %v", key, err)
+			}
+			*dst = d
+		case nil:
+		default:
+			return nil, fmt.Errorf("replication %s must be a duration", key)
+		}
+	}
+
+	return storage.NewReplicator(ctx, primary, secondaryDriver, opts)
+}
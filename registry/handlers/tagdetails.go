@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+// tagDetailsDispatcher constructs the handler of the tag details extension
+// route.
+func tagDetailsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	tagDetailsHandler := &tagDetailsHandler{
+		Context: ctx,
+		Tag:     getReference(ctx),
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(tagDetailsHandler.GetTagDetails),
+	}
+}
+
+// tagDetailsHandler describes a tag of a repository.
+type tagDetailsHandler struct {
+	*Context
+
+	Tag string
+}
+
+type tagHistoryAPIEntry struct {
+	Digest digest.Digest `json:"digest"`
+	Tagged time.Time     `json:"tagged"`
+}
+
+type tagDetailsAPIResponse struct {
+	Name         string               `json:"name"`
+	Tag          string               `json:"tag"`
+	Digest       digest.Digest        `json:"digest"`
+	LastModified time.Time            `json:"lastModified"`
+	History      []tagHistoryAPIEntry `json:"history"`
+}
+
+// GetTagDetails returns the manifest the tag points to, when the tag was
+// last changed, and the manifests it pointed to.
+func (th *tagDetailsHandler) GetTagDetails(w http.ResponseWriter, r *http.Request) {
+	describer, ok := th.Repository.Tags(th).(distribution.TagDescriber)
+	if !ok {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+	details, err := describer.Describe(th, th.Tag)
+	if err != nil {
+		var tagUnknown distribution.ErrTagUnknown
+		var repositoryUnknown distribution.ErrRepositoryUnknown
+		switch {
+		case errors.Is(err, distribution.ErrUnsupported):
+			th.Errors = append(th.Errors, errcode.ErrorCodeUnsupported)
+		case errors.As(err, &tagUnknown):
+			th.Errors = append(th.Errors, errcode.ErrorCodeManifestUnknown.WithDetail(err))
+		case errors.As(err, &repositoryUnknown):
+			th.Errors = append(th.Errors, errcode.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": th.Repository.Named().Name()}))
+		default:
+			th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	response := tagDetailsAPIResponse{
+		Name:         th.Repository.Named().Name(),
+		Tag:          th.Tag,
+		Digest:       details.Descriptor.Digest,
+		LastModified: details.LastModified.UTC(),
+		History:      make([]tagHistoryAPIEntry, 0, len(details.History)),
+	}
+	for _, entry := range details.History {
+		response.History = append(response.History, tagHistoryAPIEntry{Digest: entry.Digest, Tagged: entry.Tagged.UTC()})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Last-Modified", details.LastModified.UTC().Format(http.TimeFormat))
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/reference"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// maxResolveTags is the number of tags resolved at most by a request.
+	maxResolveTags = 1000
+
+	// maxResolveBodyBytes limits the body of tag resolution requests, which
+	// is large enough for maxResolveTags tags of the longest length.
+	maxResolveBodyBytes = maxResolveTags * 256
+)
+
+// tagsResolveDispatcher constructs the handler of the tags resolution
+// extension route.
+func tagsResolveDispatcher(ctx *Context, r *http.Request) http.Handler {
+	tagsResolveHandler := &tagsResolveHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodPost: http.HandlerFunc(tagsResolveHandler.ResolveTags),
+	}
+}
+
+// tagsResolveHandler resolves tags of a repository to the manifests they
+// point to.
+type tagsResolveHandler struct {
+	*Context
+}
+
+type resolvedTagAPIEntry struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType"`
+	Size      int64         `json:"size"`
+}
+
+type tagsResolveAPIResponse struct {
+	Name    string                         `json:"name"`
+	Tags    map[string]resolvedTagAPIEntry `json:"tags"`
+	Unknown []string                       `json:"unknown"`
+}
+
+// ResolveTags resolves the tags listed in the request body. The unknown
+// tags are listed apart, rather than failing the request.
+func (th *tagsResolveHandler) ResolveTags(w http.ResponseWriter, r *http.Request) {
+	var body bytes.Buffer
+	if err := copyFullPayload(th, w, r, &body, maxResolveBodyBytes, "tags resolution"); err != nil {
+		// copyFullPayload reports the error if necessary
+		if e, ok := payloadTooLarge(err); ok {
+			th.Errors = append(th.Errors, e)
+		} else {
+			th.Errors = append(th.Errors, errcode.ErrorCodeTagInvalid.WithDetail(err.Error()))
+		}
+		return
+	}
+
+	var tags []string
+	if err := json.Unmarshal(body.Bytes(), &tags); err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeTagInvalid.WithDetail(fmt.Sprintf("request body must be a list of tags: %v", err)))
+		return
+	}
+	if len(tags) > maxResolveTags {
+		th.Errors = append(th.Errors, errcode.ErrorCodeSizeExceeded.WithDetail(map[string]int{"maxTags": maxResolveTags}))
+		return
+	}
+	tags = uniqueTags(tags)
+	for _, tag := range tags {
+		if _, err := reference.WithTag(th.Repository.Named(), tag); err != nil {
+			th.Errors = append(th.Errors, errcode.ErrorCodeTagInvalid.WithDetail(fmt.Sprintf("invalid tag %q", tag)))
+			return
+		}
+	}
+
+	response := tagsResolveAPIResponse{
+		Name:    th.Repository.Named().Name(),
+		Tags:    make(map[string]resolvedTagAPIEntry, len(tags)),
+		Unknown: []string{},
+	}
+	manifests, err := th.Repository.Manifests(th)
+	if err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	tagService := th.Repository.Tags(th)
+
+	var mu sync.Mutex
+	g, ctx := errgroup.WithContext(th)
+	g.SetLimit(storage.DefaultConcurrencyLimit)
+	for _, tag := range tags {
+		tag := tag
+		g.Go(func() error {
+			entry, err := resolveTag(ctx, tagService, manifests, tag)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				response.Tags[tag] = entry
+			case isUnknownTag(err):
+				response.Unknown = append(response.Unknown, tag)
+			default:
+				return err
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	sort.Strings(response.Unknown)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// resolveTag returns the descriptor of the manifest tag points to.
+func resolveTag(ctx context.Context, tags distribution.TagService, manifests distribution.ManifestService, tag string) (resolvedTagAPIEntry, error) {
+	desc, err := tags.Get(ctx, tag)
+	if err != nil {
+		return resolvedTagAPIEntry{}, err
+	}
+	manifest, err := manifests.Get(ctx, desc.Digest)
+	if err != nil {
+		return resolvedTagAPIEntry{}, err
+	}
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return resolvedTagAPIEntry{}, err
+	}
+	return resolvedTagAPIEntry{Digest: desc.Digest, MediaType: mediaType, Size: int64(len(payload))}, nil
+}
+
+// isUnknownTag reports whether err is caused by a tag which is unknown, or
+// points to a manifest which is unknown.
+func isUnknownTag(err error) bool {
+	var (
+		tagUnknown        distribution.ErrTagUnknown
+		manifestUnknown   distribution.ErrManifestUnknownRevision
+		repositoryUnknown distribution.ErrRepositoryUnknown
+	)
+	return errors.As(err, &tagUnknown) || errors.As(err, &manifestUnknown) || errors.As(err, &repositoryUnknown)
+}
+
+// uniqueTags returns tags without duplicates, in their order.
+func uniqueTags(tags []string) []string {
+	seen := make(map[string]struct{}, len(tags))
+	unique := tags[:0]
+	for _, tag := range tags {
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		unique = append(unique, tag)
+	}
+	return unique
+}
@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"sort"
 	"strconv"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	"github.com/gorilla/handlers"
 )
@@ -17,9 +19,35 @@ func tagsDispatcher(ctx *Context, r *http.Request) http.Handler {
 		Context: ctx,
 	}
 
-	return handlers.MethodHandler{
-		http.MethodGet: http.HandlerFunc(tagsHandler.GetTags),
+	mhandler := handlers.MethodHandler{
+		http.MethodGet:  http.HandlerFunc(tagsHandler.GetTags),
+		http.MethodHead: http.HandlerFunc(tagsHandler.HeadTags),
 	}
+	// The path of the tag list is that of the tag named list, which is
+	// deleted through it.
+	if !ctx.Config.HTTP.APIs.TagDelete.Disabled && !ctx.readOnly {
+		manifestHandler := &manifestHandler{
+			Context: ctx,
+			Tag:     "list",
+		}
+		mhandler[http.MethodDelete] = http.HandlerFunc(manifestHandler.DeleteManifest)
+	}
+	return mhandler
+}
+
+// tagDispatcher constructs the handler of the tag deletes, which untag the
+// manifest as deletes of the manifest by tag do.
+func tagDispatcher(ctx *Context, r *http.Request) http.Handler {
+	manifestHandler := &manifestHandler{
+		Context: ctx,
+		Tag:     getReference(ctx),
+	}
+
+	mhandler := handlers.MethodHandler{}
+	if !ctx.readOnly {
+		mhandler[http.MethodDelete] = http.HandlerFunc(manifestHandler.DeleteManifest)
+	}
+	return mhandler
 }
 
 // tagsHandler handles requests for lists of tags under a repository name.
@@ -34,19 +62,12 @@ type tagsAPIResponse struct {
 
 // GetTags returns a json list of tags for a specific image name.
 func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
-	tagService := th.Repository.Tags(th)
-	tags, err := tagService.All(th)
+	tags, err := th.allTags(w)
 	if err != nil {
-		switch err := err.(type) {
-		case distribution.ErrRepositoryUnknown:
-			th.Errors = append(th.Errors, errcode.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": th.Repository.Named().Name()}))
-		case errcode.Error:
-			th.Errors = append(th.Errors, err)
-		default:
-			th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
-		}
+		th.appendTagsError(err)
 		return
 	}
+	w.Header().Set(totalCountHeader, strconv.Itoa(len(tags)))
 
 	// do pagination if requested
 	q := r.URL.Query()
@@ -104,3 +125,61 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// allTags returns the tags of the repository, from the listing cache if it
+// is enabled.
+func (th *tagsHandler) allTags(w http.ResponseWriter) ([]string, error) {
+	tagService := th.Repository.Tags(th)
+	listings := th.App.listingCache
+	if listings == nil {
+		return tagService.All(th)
+	}
+
+	name := th.Repository.Named().Name()
+	tags, hit, err := listings.Tags(th, name)
+	if err != nil {
+		dcontext.GetLogger(th).Warnf("error reading the cached tags of %s: %v", name, err)
+	}
+	setCacheHeader(w, hit)
+	if hit {
+		return tags, nil
+	}
+
+	tags, err = tagService.All(th)
+	if err != nil {
+		return nil, err
+	}
+	if err := listings.SetTags(th, name, tags); err != nil {
+		dcontext.GetLogger(th).Warnf("error caching the tags of %s: %v", name, err)
+	}
+	return tags, nil
+}
+
+// HeadTags returns the number of tags of a repository in the X-Total-Count
+// header, without listing them.
+func (th *tagsHandler) HeadTags(w http.ResponseWriter, r *http.Request) {
+	tagService := th.Repository.Tags(th)
+	err := th.countEntries(w, func(ctx context.Context) (int, error) {
+		if counter, ok := tagService.(distribution.TagCounter); ok {
+			return counter.Count(ctx)
+		}
+		tags, err := tagService.All(ctx)
+		return len(tags), err
+	})
+	if err != nil {
+		th.appendTagsError(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+}
+
+func (th *tagsHandler) appendTagsError(err error) {
+	switch err := err.(type) {
+	case distribution.ErrRepositoryUnknown:
+		th.Errors = append(th.Errors, errcode.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": th.Repository.Named().Name()}))
+	case errcode.Error:
+		th.Errors = append(th.Errors, err)
+	default:
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+	}
+}
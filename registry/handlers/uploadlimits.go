@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/docker/go-metrics"
+)
+
+// uploadLimitRetryAfter is the delay advised to the clients whose upload
+// requests are rejected by a concurrency limit.
+const uploadLimitRetryAfter = time.Second
+
+var (
+	// inflightUploads is the number of blob upload requests being served,
+	// by kind of limit counting them.
+	inflightUploads = prometheus.LimitsNamespace.NewLabeledGauge("inflight_uploads", "The number of blob upload requests being served", metrics.Total, "limit")
+
+	// rejectedUploads is the number of blob upload requests rejected by a
+	// concurrency limit, by kind of limit.
+	rejectedUploads = prometheus.LimitsNamespace.NewLabeledCounter("rejected_uploads", "The number of blob upload requests rejected by a concurrency limit", "limit")
+)
+
+func init() {
+	metrics.Register(prometheus.LimitsNamespace)
+}
+
+// uploadLimiter caps the blob upload requests served at once to each client
+// and to each repository.
+type uploadLimiter struct {
+	perClient, perRepository int
+
+	mu       sync.Mutex
+	inflight map[string]int
+}
+
+// newUploadLimiter returns the uploadLimiter of config, nil if uploads are
+// not limited.
+func newUploadLimiter(config configuration.Limits) (*uploadLimiter, error) {
+	if config.UploadsPerClient < 0 || config.UploadsPerRepository < 0 {
+		return nil, fmt.Errorf("upload limits must not be negative")
+	}
+	if config.UploadsPerClient == 0 && config.UploadsPerRepository == 0 {
+		return nil, nil
+	}
+	return &uploadLimiter{
+		perClient:     config.UploadsPerClient,
+		perRepository: config.UploadsPerRepository,
+		inflight:      make(map[string]int),
+	}, nil
+}
+
+// acquire counts an upload request of client to repo. It returns the
+// function ending the request, or the kind of limit the request exceeds.
+func (ul *uploadLimiter) acquire(client, repo string) (func(), string) {
+	type slot struct {
+		limit, key string
+		max        int
+	}
+	var slots []slot
+	if ul.perClient > 0 {
+		slots = append(slots, slot{"client", "client|" + client, ul.perClient})
+	}
+	if ul.perRepository > 0 {
+		slots = append(slots, slot{"repository", "repository|" + repo, ul.perRepository})
+	}
+
+	ul.mu.Lock()
+	defer ul.mu.Unlock()
+	for _, s := range slots {
+		if ul.inflight[s.key] >= s.max {
+			return nil, s.limit
+		}
+	}
+	for _, s := range slots {
+		ul.inflight[s.key]++
+		inflightUploads.WithValues(s.limit).Inc(1)
+	}
+
+	return func() {
+		ul.mu.Lock()
+		defer ul.mu.Unlock()
+		for _, s := range slots {
+			if ul.inflight[s.key]--; ul.inflight[s.key] == 0 {
+				delete(ul.inflight, s.key)
+			}
+			inflightUploads.WithValues(s.limit).Dec(1)
+		}
+	}, ""
+}
+
+// limit wraps the blob upload handler constructed by dispatch so that its
+// requests carrying data count against the limits while they are served.
+// The requests exceeding a limit are rejected with the TOOMANYREQUESTS error
+// code, before the handler is constructed, so that no upload is resumed for
+// them.
+func (ul *uploadLimiter) limit(ctx *Context, r *http.Request, dispatch dispatchFunc) http.Handler {
+	switch r.Method {
+	case http.MethodPost, http.MethodPatch, http.MethodPut:
+	default:
+		return dispatch(ctx, r)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only trust the user name of authenticated requests, as the rate
+		// limiter does.
+		client := dcontext.GetStringValue(ctx, userNameKey)
+		if client == "" {
+			client = ctx.App.clientIP(r)
+		}
+		release, exceeded := ul.acquire(client, getName(ctx))
+		if release == nil {
+			rejectedUploads.WithValues(exceeded).Inc(1)
+			ctx.Errors = append(ctx.Errors, errcode.ErrorCodeTooManyRequests.
+				WithDetail(fmt.Sprintf("too many concurrent uploads per %s", exceeded)).
+				WithRetryAfter(uploadLimitRetryAfter))
+			return
+		}
+		defer release()
+		dispatch(ctx, r).ServeHTTP(w, r)
+	})
+}
+
+// configureUploadLimits sets up the concurrency limits of blob uploads.
+func (app *App) configureUploadLimits(cfg *configuration.Configuration) {
+	limiter, err := newUploadLimiter(cfg.HTTP.Limits)
+	if err != nil {
+		panic(fmt.Sprintf("invalid limits configuration: %v", err))
+	}
+	app.uploadLimiter = limiter
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/gorilla/handlers"
+)
+
+// uploadsDispatcher constructs the handler of the uploads extension routes,
+// listing the uploads in progress and cancelling one of them.
+func uploadsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	uploadsHandler := &uploadsHandler{
+		Context: ctx,
+		UUID:    getUploadUUID(ctx),
+	}
+
+	if uploadsHandler.UUID == "" {
+		return handlers.MethodHandler{
+			http.MethodGet: http.HandlerFunc(uploadsHandler.GetUploads),
+		}
+	}
+
+	handler := handlers.MethodHandler{}
+	if !ctx.readOnly {
+		handler[http.MethodDelete] = http.HandlerFunc(uploadsHandler.CancelUpload)
+	}
+	return handler
+}
+
+// uploadsHandler lists and cancels the uploads in progress of a repository.
+type uploadsHandler struct {
+	*Context
+
+	UUID string
+}
+
+type uploadsAPIResponse struct {
+	Name    string                    `json:"name"`
+	Uploads []distribution.BlobUpload `json:"uploads"`
+}
+
+// GetUploads returns the uploads in progress of the repository.
+func (uh *uploadsHandler) GetUploads(w http.ResponseWriter, r *http.Request) {
+	lister, ok := uh.Repository.Blobs(uh).(distribution.BlobUploadLister)
+	if !ok {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+	uploads, err := lister.Uploads(uh)
+	if err != nil {
+		if errors.Is(err, distribution.ErrUnsupported) {
+			uh.Errors = append(uh.Errors, errcode.ErrorCodeUnsupported)
+		} else {
+			uh.Errors = append(uh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(uploadsAPIResponse{
+		Name:    uh.Repository.Named().Name(),
+		Uploads: uploads,
+	}); err != nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// CancelUpload cancels the upload identified by the request, without its
+// upload state.
+func (uh *uploadsHandler) CancelUpload(w http.ResponseWriter, r *http.Request) {
+	lister, ok := uh.Repository.Blobs(uh).(distribution.BlobUploadLister)
+	if !ok {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+	if err := lister.CancelUpload(uh, uh.UUID); err != nil {
+		switch {
+		case errors.Is(err, distribution.ErrUnsupported):
+			uh.Errors = append(uh.Errors, errcode.ErrorCodeUnsupported)
+		case errors.Is(err, distribution.ErrBlobUploadUnknown):
+			uh.Errors = append(uh.Errors, errcode.ErrorCodeBlobUploadUnknown)
+		default:
+			dcontext.GetLogger(uh).Errorf("error encountered canceling upload: %v", err)
+			uh.Errors = append(uh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
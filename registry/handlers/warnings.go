@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/opencontainers/go-digest"
+)
+
+// manifestWarnings holds the templates of the Warning headers added to
+// manifest responses, as configured in the warnings section.
+type manifestWarnings struct {
+	schema1       *template.Template
+	noAnnotations *template.Template
+	tagPulls      []tagPullWarning
+}
+
+// tagPullWarning is the warning of the pulls by tag matching tags from the
+// repositories allowed by repositories.
+type tagPullWarning struct {
+	repositories *storage.NamePolicy
+	tags         []string
+	message      *template.Template
+}
+
+// warningData is the data the warning templates are executed with.
+type warningData struct {
+	Repository string
+	Tag        string
+	Digest     digest.Digest
+}
+
+// newManifestWarnings parses the warnings of config. It returns nil if no
+// warning is configured.
+func newManifestWarnings(config configuration.Warnings) (*manifestWarnings, error) {
+	if config.Schema1 == "" && config.NoAnnotations == "" && len(config.TagPulls) == 0 {
+		return nil, nil
+	}
+
+	parse := func(name, message string) (*template.Template, error) {
+		if message == "" {
+			return nil, nil
+		}
+		return template.New(name).Parse(message)
+	}
+
+	var (
+		warnings manifestWarnings
+		err      error
+	)
+	if warnings.schema1, err = parse("schema1", config.Schema1); err != nil {
+		return nil, err
+	}
+	if warnings.noAnnotations, err = parse("noannotations", config.NoAnnotations); err != nil {
+		return nil, err
+	}
+	for _, rule := range config.TagPulls {
+		repositories, err := storage.NewNamePolicy(0, rule.Repositories, nil)
+		if err != nil {
+			return nil, err
+		}
+		message, err := parse("tagpulls", rule.Message)
+		if err != nil {
+			return nil, err
+		}
+		warnings.tagPulls = append(warnings.tagPulls, tagPullWarning{
+			repositories: repositories,
+			tags:         rule.Tags,
+			message:      message,
+		})
+	}
+	return &warnings, nil
+}
+
+// configureWarnings sets up the Warning headers of manifest responses.
+func (app *App) configureWarnings(cfg *configuration.Configuration) {
+	warnings, err := newManifestWarnings(cfg.Warnings)
+	if err != nil {
+		panic(fmt.Sprintf("unable to configure warnings: %v", err))
+	}
+	app.warnings = warnings
+}
+
+// warn adds the warning of tmpl, if configured, to the headers of the
+// response.
+func (imh *manifestHandler) warn(w http.ResponseWriter, tmpl *template.Template) {
+	if tmpl == nil {
+		return
+	}
+	var message strings.Builder
+	err := tmpl.Execute(&message, warningData{
+		Repository: imh.Repository.Named().Name(),
+		Tag:        imh.Tag,
+		Digest:     imh.Digest,
+	})
+	if err != nil {
+		dcontext.GetLogger(imh).WithError(err).Errorf("unable to execute warning template %s", tmpl.Name())
+		return
+	}
+	addWarning(w, message.String())
+}
+
+// warnSchema1 warns the client about its use of schema1 manifests.
+func (imh *manifestHandler) warnSchema1(w http.ResponseWriter) {
+	if imh.warnings != nil {
+		imh.warn(w, imh.warnings.schema1)
+	}
+}
+
+// warnNoAnnotations warns the client if the OCI manifest or index it pushes
+// has no annotations.
+func (imh *manifestHandler) warnNoAnnotations(w http.ResponseWriter, mfst distribution.Manifest) {
+	if imh.warnings == nil || imh.warnings.noAnnotations == nil {
+		return
+	}
+	switch m := mfst.(type) {
+	case *ocischema.DeserializedManifest:
+		if len(m.Annotations) > 0 {
+			return
+		}
+	case *ocischema.DeserializedImageIndex:
+		if len(m.Annotations) > 0 {
+			return
+		}
+	default:
+		return
+	}
+	imh.warn(w, imh.warnings.noAnnotations)
+}
+
+// warnTagPull warns the client pulling imh.Tag with the warnings of the
+// rules matching the repository and the tag.
+func (imh *manifestHandler) warnTagPull(w http.ResponseWriter) {
+	if imh.warnings == nil || imh.Tag == "" {
+		return
+	}
+	name := imh.Repository.Named().Name()
+	for _, rule := range imh.warnings.tagPulls {
+		if rule.repositories.Validate(name) != nil || !matchTag(rule.tags, imh.Tag) {
+			continue
+		}
+		imh.warn(w, rule.message)
+	}
+}
+
+// matchTag reports whether tag matches one of patterns, or patterns is
+// empty.
+func matchTag(patterns []string, tag string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// addWarning adds a Warning header with the 299 (miscellaneous persistent
+// warning) code and message to the response.
+func addWarning(w http.ResponseWriter, message string) {
+	w.Header().Add("Warning", fmt.Sprintf(`299 - "%s"`, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(message)))
+}
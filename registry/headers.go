@@ -0,0 +1,47 @@
+package registry
+
+import (
+	"net/http"
+	"strings"
+)
+
+// headersHandler adds the headers of the http.headers configuration, other
+// than the CORS headers, to every response of the registry, including the
+// ones which do not reach the app, such as health check failures and router
+// errors.
+type headersHandler struct {
+	handler http.Handler
+	headers http.Header
+}
+
+// newHeadersHandler wraps handler with the http.headers configuration: the
+// CORS handler of its Access-Control-* entries, and the other entries as
+// static response headers.
+func newHeadersHandler(headers http.Header, handler http.Handler) (http.Handler, error) {
+	handler, err := newCORSHandler(headers, handler)
+	if err != nil {
+		return nil, err
+	}
+
+	static := http.Header{}
+	for name, values := range headers {
+		// The CORS headers depend on the origin of the request.
+		if name = http.CanonicalHeaderKey(name); !strings.HasPrefix(name, "Access-Control-") {
+			static[name] = append(static[name], values...)
+		}
+	}
+	if len(static) == 0 {
+		return handler, nil
+	}
+	return &headersHandler{handler: handler, headers: static}, nil
+}
+
+func (hh *headersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	for name, values := range hh.headers {
+		for _, value := range values {
+			h.Add(name, value)
+		}
+	}
+	hh.handler.ServeHTTP(w, r)
+}
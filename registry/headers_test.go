@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestHeaders(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	config.Log.AccessLog.Disabled = true
+	config.HTTP.Headers = http.Header{
+		"X-Content-Type-Options":      []string{"nosniff"},
+		"strict-transport-security":   []string{"max-age=63072000"},
+		"Access-Control-Allow-Origin": []string{"https://ui.example.com"},
+	}
+	registry, err := NewRegistry(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/", http.StatusOK},
+		{http.MethodGet, "/v2/", http.StatusOK},
+		{http.MethodGet, "/v2/foo/unknown", http.StatusNotFound},
+		{http.MethodPatch, "/v2/foo/manifests/latest", http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		registry.server.Handler.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.status {
+			t.Fatalf("%s %s: expected status %d, got %d", tc.method, tc.path, tc.status, w.Code)
+		}
+		if got := w.Header().Values("X-Content-Type-Options"); len(got) != 1 || got[0] != "nosniff" {
+			t.Errorf("%s %s: expected X-Content-Type-Options header nosniff, got %v", tc.method, tc.path, got)
+		}
+		if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=63072000" {
+			t.Errorf("%s %s: expected Strict-Transport-Security header max-age=63072000, got %q", tc.method, tc.path, got)
+		}
+		// The CORS headers are only set for allowed origins.
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("%s %s: unexpected Access-Control-Allow-Origin header %q", tc.method, tc.path, got)
+		}
+	}
+}
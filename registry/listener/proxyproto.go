@@ -0,0 +1,226 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/requestutil"
+)
+
+// proxyHeaderTimeout bounds the time spent reading the PROXY protocol header
+// of a connection.
+const proxyHeaderTimeout = 10 * time.Second
+
+var (
+	// proxyV1Prefix starts the header of version 1 of the PROXY protocol.
+	proxyV1Prefix = []byte("PROXY ")
+	// proxyV2Signature starts the header of version 2 of the PROXY protocol.
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// ErrUntrustedProxyHeader is returned by the connections sending a PROXY
+// protocol header from a source which is not trusted to send one.
+var ErrUntrustedProxyHeader = errors.New("PROXY protocol header from an untrusted source")
+
+// proxyProtocolListener accepts connections which may start with a PROXY
+// protocol header, version 1 or 2, carrying the address of the client the
+// connection is proxied for.
+type proxyProtocolListener struct {
+	net.Listener
+	trusted []net.IPNet
+}
+
+// NewProxyProtocolListener wraps ln so that the connections it accepts
+// report the client address of their PROXY protocol header, if they have
+// one, as their remote address. The header is read on the first use of the
+// connection, before any TLS handshake when the returned listener is wrapped
+// by a TLS listener. Only the sources in the trusted networks may send a
+// header, none may if trusted is empty; the connections of other sources
+// sending one fail with ErrUntrustedProxyHeader.
+func NewProxyProtocolListener(ln net.Listener, trusted []net.IPNet) net.Listener {
+	return &proxyProtocolListener{Listener: ln, trusted: trusted}
+}
+
+func (ln *proxyProtocolListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: c, trusted: ln.isTrusted(c.RemoteAddr())}, nil
+}
+
+// isTrusted reports whether the source addr may send a PROXY protocol
+// header.
+func (ln *proxyProtocolListener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	return requestutil.NetworksContain(ln.trusted, tcpAddr.IP)
+}
+
+// proxyConn is a connection which may start with a PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	trusted bool
+
+	once   sync.Once
+	reader *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+// init reads the PROXY protocol header of the connection, if it has one.
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.reader = bufio.NewReader(c.Conn)
+		if err := c.SetReadDeadline(time.Now().Add(proxyHeaderTimeout)); err != nil {
+			c.err = err
+			return
+		}
+		c.remote, c.err = c.readHeader()
+		if c.err == nil {
+			c.err = c.SetReadDeadline(time.Time{})
+		}
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+// readHeader reads the PROXY protocol header, and returns the client address
+// it carries, or nil if the connection has no header or the header carries
+// no address.
+func (c *proxyConn) readHeader() (net.Addr, error) {
+	first, err := c.reader.Peek(1)
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var version int
+	switch {
+	case first[0] == proxyV1Prefix[0] && c.hasPrefix(proxyV1Prefix):
+		version = 1
+	case first[0] == proxyV2Signature[0] && c.hasPrefix(proxyV2Signature):
+		version = 2
+	default:
+		return nil, nil
+	}
+	if !c.trusted {
+		return nil, ErrUntrustedProxyHeader
+	}
+	if version == 1 {
+		return readProxyV1Header(c.reader)
+	}
+	return readProxyV2Header(c.reader)
+}
+
+// hasPrefix reports whether the connection starts with prefix.
+func (c *proxyConn) hasPrefix(prefix []byte) bool {
+	p, _ := c.reader.Peek(len(prefix))
+	return bytes.Equal(p, prefix)
+}
+
+// readProxyV1Header reads a version 1 header, such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyV1Header(r *bufio.Reader) (net.Addr, error) {
+	// The header is at most 107 bytes long.
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("invalid PROXY protocol v1 header: %w", err)
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("invalid PROXY protocol v1 header: too long")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2Header reads a version 2 header.
+func readProxyV2Header(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol v2 header: %w", err)
+	}
+	versionCommand, family := header[12], header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol v2 header: %w", err)
+	}
+
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("invalid PROXY protocol v2 header: version %d", versionCommand>>4)
+	}
+	switch versionCommand & 0xf {
+	case 0: // LOCAL, such as health checks of the proxy itself.
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, fmt.Errorf("invalid PROXY protocol v2 header: command %d", versionCommand&0xf)
+	}
+
+	var ipLen int
+	switch family >> 4 {
+	case 1: // AF_INET
+		ipLen = net.IPv4len
+	case 2: // AF_INET6
+		ipLen = net.IPv6len
+	default: // AF_UNSPEC, AF_UNIX
+		return nil, nil
+	}
+	// The source and destination addresses, then ports.
+	if len(payload) < 2*ipLen+4 {
+		return nil, errors.New("invalid PROXY protocol v2 header: short address block")
+	}
+	ip := net.IP(payload[:ipLen])
+	port := binary.BigEndian.Uint16(payload[2*ipLen:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr returns the client address of the PROXY protocol header, if
+// any, or else the address of the peer.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
@@ -0,0 +1,186 @@
+package listener
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3/internal/requestutil"
+)
+
+// proxyV2Header returns a version 2 PROXY header for the TCP connection of
+// src to dst.
+func proxyV2Header(src, dst *net.TCPAddr) []byte {
+	family, srcIP, dstIP := byte(0x11), src.IP.To4(), dst.IP.To4()
+	if srcIP == nil {
+		family, srcIP, dstIP = 0x21, src.IP.To16(), dst.IP.To16()
+	}
+	payload := append(append([]byte{}, srcIP...), dstIP...)
+	payload = binary.BigEndian.AppendUint16(payload, uint16(src.Port))
+	payload = binary.BigEndian.AppendUint16(payload, uint16(dst.Port))
+
+	header := append(append([]byte{}, proxyV2Signature...), 0x21, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		trusted  []string
+		header   []byte
+		expected string // the remote address, or "" for the peer's
+		err      error
+	}{
+		{
+			name:     "v1",
+			trusted:  []string{"127.0.0.1"},
+			header:   []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"),
+			expected: "192.0.2.1:56324",
+		},
+		{
+			name:     "v1 unknown",
+			trusted:  []string{"127.0.0.1"},
+			header:   []byte("PROXY UNKNOWN\r\n"),
+			expected: "",
+		},
+		{
+			name: "v2 IPv6",
+			header: proxyV2Header(
+				&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000},
+				&net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443},
+			),
+			trusted:  []string{"127.0.0.1"},
+			expected: "[2001:db8::1]:40000",
+		},
+		{
+			name: "v2 IPv4 from a trusted source",
+			header: proxyV2Header(
+				&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000},
+				&net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443},
+			),
+			trusted:  []string{"127.0.0.0/8"},
+			expected: "192.0.2.1:40000",
+		},
+		{
+			name:     "no header",
+			expected: "",
+		},
+		{
+			name:     "no header from an untrusted source",
+			trusted:  []string{"192.0.2.0/24"},
+			expected: "",
+		},
+		{
+			name:   "header with no trusted source",
+			header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"),
+			err:    ErrUntrustedProxyHeader,
+		},
+		{
+			name:    "header from an untrusted source",
+			trusted: []string{"192.0.2.0/24", "198.51.100.1"},
+			header:  []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"),
+			err:     ErrUntrustedProxyHeader,
+		},
+		{
+			name:    "invalid v1 header",
+			trusted: []string{"127.0.0.1"},
+			header:  []byte("PROXY TCP4 192.0.2.1\r\n"),
+			err:     errors.New("invalid"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			trusted, err := requestutil.ParseNetworks(tc.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tcp, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ln := NewProxyProtocolListener(tcp, trusted)
+			defer ln.Close()
+
+			client, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			go func() {
+				_, _ = client.Write(append(tc.header, "hello"...))
+			}()
+
+			conn, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			data := make([]byte, 5)
+			_, err = io.ReadFull(conn, data)
+			if tc.err != nil {
+				if err == nil || (errors.Is(tc.err, ErrUntrustedProxyHeader) && !errors.Is(err, ErrUntrustedProxyHeader)) {
+					t.Fatalf("expected error %v, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "hello" {
+				t.Fatalf("unexpected data %q", data)
+			}
+			expected := tc.expected
+			if expected == "" {
+				expected = client.LocalAddr().String()
+			}
+			if conn.RemoteAddr().String() != expected {
+				t.Fatalf("expected remote address %s, got %s", expected, conn.RemoteAddr())
+			}
+		})
+	}
+}
+
+func TestProxyProtocolListenerTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.RemoteAddr)
+	}))
+	trusted, err := requestutil.ParseNetworks([]string{"127.0.0.1", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Listener = NewProxyProtocolListener(server.Listener, trusted)
+	server.StartTLS()
+	defer server.Close()
+
+	// The proxy sends the header before the client's TLS handshake.
+	client := server.Client()
+	client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 51000 443\r\n")); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "[2001:db8::1]:51000" {
+		t.Fatalf("unexpected remote address %q", body)
+	}
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cachePolicy describes how long content fetched from the remote may be
+// kept in the local cache, as directed by the remote's response headers.
+type cachePolicy struct {
+	// noStore is set if the remote forbids caching the content.
+	noStore bool
+
+	// ttl is the lifetime advertised by the remote. It is only meaningful
+	// if hasTTL is set.
+	ttl    time.Duration
+	hasTTL bool
+}
+
+// parseCachePolicy derives a cachePolicy from the Cache-Control and Expires
+// headers of a response. Since the proxy is a shared cache, s-maxage takes
+// precedence over max-age, and both take precedence over Expires.
+func parseCachePolicy(h http.Header, now time.Time) cachePolicy {
+	var (
+		policy          cachePolicy
+		maxAge, sMaxAge = -1, -1
+	)
+
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			arg = strings.Trim(arg, `"`)
+			switch strings.ToLower(name) {
+			case "no-store":
+				policy.noStore = true
+			case "max-age":
+				if secs, err := strconv.Atoi(arg); err == nil && secs >= 0 {
+					maxAge = secs
+				}
+			case "s-maxage":
+				if secs, err := strconv.Atoi(arg); err == nil && secs >= 0 {
+					sMaxAge = secs
+				}
+			}
+		}
+	}
+
+	switch {
+	case sMaxAge >= 0:
+		policy.ttl, policy.hasTTL = time.Duration(sMaxAge)*time.Second, true
+	case maxAge >= 0:
+		policy.ttl, policy.hasTTL = time.Duration(maxAge)*time.Second, true
+	case h.Get("Expires") != "":
+		// An invalid Expires value means the content is already expired.
+		policy.hasTTL = true
+		expires, err := http.ParseTime(h.Get("Expires"))
+		if err != nil {
+			break
+		}
+		if date, err := http.ParseTime(h.Get("Date")); err == nil {
+			now = date
+		}
+		if ttl := expires.Sub(now); ttl > 0 {
+			policy.ttl = ttl
+		}
+	}
+
+	return policy
+}
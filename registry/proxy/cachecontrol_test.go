@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseCachePolicy(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		want   cachePolicy
+	}{
+		{
+			name:   "no headers",
+			header: http.Header{},
+			want:   cachePolicy{},
+		},
+		{
+			name:   "max-age",
+			header: http.Header{"Cache-Control": []string{"public, max-age=600"}},
+			want:   cachePolicy{ttl: 10 * time.Minute, hasTTL: true},
+		},
+		{
+			name:   "s-maxage takes precedence",
+			header: http.Header{"Cache-Control": []string{"max-age=600, s-maxage=60"}},
+			want:   cachePolicy{ttl: time.Minute, hasTTL: true},
+		},
+		{
+			name:   "quoted max-age",
+			header: http.Header{"Cache-Control": []string{`max-age="30"`}},
+			want:   cachePolicy{ttl: 30 * time.Second, hasTTL: true},
+		},
+		{
+			name:   "invalid max-age is ignored",
+			header: http.Header{"Cache-Control": []string{"max-age=soon"}},
+			want:   cachePolicy{},
+		},
+		{
+			name:   "no-store",
+			header: http.Header{"Cache-Control": []string{"No-Store"}},
+			want:   cachePolicy{noStore: true},
+		},
+		{
+			name: "max-age takes precedence over expires",
+			header: http.Header{
+				"Cache-Control": []string{"max-age=5"},
+				"Expires":       []string{now.Add(time.Hour).Format(http.TimeFormat)},
+			},
+			want: cachePolicy{ttl: 5 * time.Second, hasTTL: true},
+		},
+		{
+			name: "expires relative to date",
+			header: http.Header{
+				"Date":    []string{now.Add(-time.Minute).Format(http.TimeFormat)},
+				"Expires": []string{now.Add(time.Hour).Format(http.TimeFormat)},
+			},
+			want: cachePolicy{ttl: time.Hour + time.Minute, hasTTL: true},
+		},
+		{
+			name:   "expires relative to now",
+			header: http.Header{"Expires": []string{now.Add(time.Hour).Format(http.TimeFormat)}},
+			want:   cachePolicy{ttl: time.Hour, hasTTL: true},
+		},
+		{
+			name:   "invalid expires is already expired",
+			header: http.Header{"Expires": []string{"0"}},
+			want:   cachePolicy{hasTTL: true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := parseCachePolicy(tc.header, now)
+			if got != tc.want {
+				t.Errorf("unexpected policy: %+v != %+v", got, tc.want)
+			}
+		})
+	}
+}
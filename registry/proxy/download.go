@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/opencontainers/go-digest"
+)
+
+// inflight tracks the blobs being downloaded from the remotes.
+var inflight = make(map[digest.Digest]*download)
+
+// mu protects inflight
+var mu sync.Mutex
+
+// errDownloadUnavailable is returned by the downloads which cannot be
+// followed, because their temporary file could not be created.
+var errDownloadUnavailable = errors.New("download cannot be followed")
+
+// download is a blob being fetched from the remote into the local store. The
+// fetched content is also spooled to a temporary file, from which the other
+// requests for the blob are served while the download progresses, rather
+// than fetching the blob from the remote again.
+type download struct {
+	dgst digest.Digest
+	// file is the temporary file the content is spooled to, nil if it could
+	// not be created.
+	file *os.File
+
+	mu    sync.Mutex
+	state downloadState
+	// changed is closed, and replaced, whenever the progress changes.
+	changed chan struct{}
+}
+
+// downloadState is the progress of a download.
+type downloadState struct {
+	desc distribution.Descriptor
+	// started is set once the size of the blob is known.
+	started bool
+	written int64
+	// done is set once the blob is committed to the local store, or the
+	// download failed with err.
+	done bool
+	err  error
+}
+
+// startDownload registers the download of dgst. It must be called with mu
+// held.
+func startDownload(ctx context.Context, dgst digest.Digest) *download {
+	d := &download{dgst: dgst, changed: make(chan struct{})}
+	file, err := os.CreateTemp("", "registry-proxy-blob-")
+	if err != nil {
+		dcontext.GetLogger(ctx).Warnf("unable to create temporary file for blob %s, concurrent requests fetch it from the remote: %v", dgst, err)
+	} else {
+		d.file = file
+	}
+	inflight[dgst] = d
+	return d
+}
+
+// end unregisters the download, which failed with err if not nil, and removes
+// its temporary file. The followers having opened the file keep reading it.
+func (d *download) end(err error) {
+	mu.Lock()
+	delete(inflight, d.dgst)
+	mu.Unlock()
+
+	d.update(func() {
+		d.state.done = true
+		if d.state.err == nil {
+			d.state.err = err
+		}
+	})
+	if d.file != nil {
+		d.file.Close()
+		os.Remove(d.file.Name())
+	}
+}
+
+// update changes the progress of the download with fn and wakes the
+// followers up.
+func (d *download) update(fn func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn()
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// start records the descriptor of the blob fetched from the remote.
+func (d *download) start(desc distribution.Descriptor) {
+	d.update(func() {
+		d.state.desc = desc
+		d.state.started = true
+	})
+}
+
+// Write spools p to the temporary file. A failure to write it fails the
+// followers only, not the download: Write always succeeds.
+func (d *download) Write(p []byte) (int, error) {
+	if d.file == nil {
+		return len(p), nil
+	}
+	n, err := d.file.Write(p)
+	d.update(func() {
+		d.state.written += int64(n)
+		if err != nil && d.state.err == nil {
+			d.state.err = err
+		}
+	})
+	return len(p), nil
+}
+
+// open opens the temporary file for a follower. It must be called with mu
+// held, so that the file is not removed before it is opened.
+func (d *download) open() (*os.File, error) {
+	if d.file == nil {
+		return nil, errDownloadUnavailable
+	}
+	return os.Open(d.file.Name())
+}
+
+// progress returns the state of the download, and the channel closed when it
+// changes.
+func (d *download) progress() (downloadState, <-chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state, d.changed
+}
+
+// serve writes the blob to w from f, the temporary file of the download, as
+// the download progresses. The response has the length of the whole blob,
+// but its last byte is only written once the blob is verified and
+// committed: clients never get the whole of a blob failing verification.
+func (d *download) serve(ctx context.Context, w http.ResponseWriter, r *http.Request, f *os.File) error {
+	defer f.Close()
+
+	var (
+		offset  int64
+		headers bool
+	)
+	for {
+		state, changed := d.progress()
+		if state.err != nil {
+			return state.err
+		}
+		size := state.desc.Size
+		if state.started && !headers {
+			setResponseHeaders(w, size, state.desc.MediaType, d.dgst)
+			headers = true
+			if r.Method == http.MethodHead {
+				return nil
+			}
+		}
+
+		available := state.written
+		switch {
+		case state.done && !state.started:
+			return errDownloadUnavailable
+		case state.done:
+			available = size
+		case available >= size:
+			// Hold the last byte back until the blob is verified.
+			available = size - 1
+		}
+		if available > offset {
+			n, err := io.CopyN(w, f, available-offset)
+			offset += n
+			if err != nil {
+				return err
+			}
+		}
+		if state.started && offset == size {
+			proxyMetrics.BlobPush(uint64(size), true)
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// prefetchWorkers is the number of blobs prefetched concurrently.
+	prefetchWorkers = 4
+
+	// prefetchQueueSize bounds the number of blobs waiting to be
+	// prefetched. Blobs queued while the queue is full are dropped; they
+	// are still fetched when a client asks for them.
+	prefetchQueueSize = 1024
+)
+
+type prefetchJob struct {
+	blobs *proxyBlobStore
+	dgst  digest.Digest
+}
+
+// prefetcher fetches the blobs referenced by cached manifests into the local
+// store in the background, so that clients pulling the image find its layers
+// cached already.
+type prefetcher struct {
+	ctx   context.Context
+	queue chan prefetchJob
+
+	mu      sync.Mutex
+	pending map[digest.Digest]struct{}
+	closed  bool
+}
+
+func newPrefetcher(ctx context.Context, workers int) *prefetcher {
+	p := &prefetcher{
+		ctx:     ctx,
+		queue:   make(chan prefetchJob, prefetchQueueSize),
+		pending: make(map[digest.Digest]struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		go p.run()
+	}
+	return p
+}
+
+// enqueueManifest queues the blobs referenced by manifest to be fetched
+// through blobs. References to other manifests, as found in manifest lists,
+// are skipped.
+func (p *prefetcher) enqueueManifest(blobs *proxyBlobStore, manifest distribution.Manifest) {
+	manifestTypes := make(map[string]struct{})
+	for _, mt := range distribution.ManifestMediaTypes() {
+		manifestTypes[mt] = struct{}{}
+	}
+
+	for _, desc := range manifest.References() {
+		if _, ok := manifestTypes[desc.MediaType]; ok {
+			continue
+		}
+		p.enqueue(blobs, desc.Digest)
+	}
+}
+
+// enqueue queues dgst to be fetched through blobs, unless it is queued
+// already.
+func (p *prefetcher) enqueue(blobs *proxyBlobStore, dgst digest.Digest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	if _, ok := p.pending[dgst]; ok {
+		return
+	}
+
+	select {
+	case p.queue <- prefetchJob{blobs: blobs, dgst: dgst}:
+		p.pending[dgst] = struct{}{}
+		prefetchPending.Inc(1)
+	default:
+		dcontext.GetLogger(p.ctx).Warnf("prefetch queue full, dropping %s", dgst)
+	}
+}
+
+func (p *prefetcher) run() {
+	for job := range p.queue {
+		if err := job.blobs.prefetch(p.ctx, job.dgst); err != nil {
+			dcontext.GetLogger(p.ctx).Errorf("Error prefetching blob %s: %s", job.dgst, err)
+		}
+
+		p.mu.Lock()
+		delete(p.pending, job.dgst)
+		p.mu.Unlock()
+		prefetchPending.Dec(1)
+	}
+}
+
+// close stops accepting new blobs. Workers exit once the queue is drained.
+func (p *prefetcher) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+)
+
+func TestPrefetcher(t *testing.T) {
+	te := makeTestEnv(t, "foo/bar")
+	populate(t, te, 2, 10, 2)
+	remoteStats := te.RemoteStats()
+
+	config := te.inRemote[0]
+	config.MediaType = schema2.MediaTypeImageConfig
+	layer := te.inRemote[1]
+	layer.MediaType = schema2.MediaTypeLayer
+	m, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    config,
+		Layers:    []distribution.Descriptor{layer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without workers the queue can be inspected; duplicates are dropped.
+	p := newPrefetcher(te.ctx, 0)
+	p.enqueueManifest(&te.store, m)
+	p.enqueue(&te.store, layer.Digest)
+	if len(p.queue) != 2 {
+		t.Fatalf("Expected 2 queued blobs, got %d", len(p.queue))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.run()
+		close(done)
+	}()
+	p.close()
+	<-done
+
+	for _, desc := range []distribution.Descriptor{config, layer} {
+		if _, err := te.store.localStore.Stat(te.ctx, desc.Digest); err != nil {
+			t.Errorf("Expected %s to be prefetched: %v", desc.Digest, err)
+		}
+	}
+	if (*remoteStats)["open"] != 2 {
+		t.Errorf("Expected 2 remote fetches, got %d", (*remoteStats)["open"])
+	}
+	if len(p.pending) != 0 {
+		t.Errorf("Expected no pending prefetches, got %d", len(p.pending))
+	}
+
+	// A closed prefetcher ignores new blobs.
+	p.enqueue(&te.store, layer.Digest)
+	if len(p.pending) != 0 {
+		t.Errorf("Expected closed prefetcher to ignore new blobs")
+	}
+}
@@ -1,83 +1,249 @@
 package proxy
 
 import (
+	"context"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/distribution/distribution/v3/internal/client/auth"
 	"github.com/distribution/distribution/v3/internal/client/auth/challenge"
 	"github.com/distribution/distribution/v3/internal/dcontext"
 )
 
-const challengeHeader = "Docker-Distribution-Api-Version"
+// defaultChallengeTTL is how long the challenges of a remote are cached when
+// the configuration sets no TTL.
+const defaultChallengeTTL = time.Hour
 
 type userpass struct {
 	username string
 	password string
 }
 
+// credentials hands the configured credentials of a remote to the token
+// realms it advertises, and to the remote itself for basic authentication.
 type credentials struct {
-	creds map[string]userpass
+	userpass
+	host string
+
+	mu     sync.RWMutex
+	realms map[string]struct{}
+}
+
+func newCredentials(username, password string, remoteURL url.URL) *credentials {
+	return &credentials{
+		userpass: userpass{username: username, password: password},
+		host:     remoteURL.Host,
+		realms:   make(map[string]struct{}),
+	}
+}
+
+// configured reports whether credentials were configured for the remote.
+func (c *credentials) configured() bool {
+	return c.username != "" && c.password != ""
 }
 
-func (c credentials) Basic(u *url.URL) (string, string) {
-	up := c.creds[u.String()]
+// addRealms trusts the token realms of the bearer challenges with the
+// credentials.
+func (c *credentials) addRealms(ctx context.Context, challenges []challenge.Challenge) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ch := range challenges {
+		if !strings.EqualFold(ch.Scheme, "bearer") {
+			continue
+		}
+		realm := ch.Parameters["realm"]
+		if _, ok := c.realms[realm]; ok {
+			continue
+		}
+		dcontext.GetLogger(ctx).Infof("Discovered token authentication URL: %s", realm)
+		c.realms[realm] = struct{}{}
+	}
+}
 
-	return up.username, up.password
+func (c *credentials) Basic(u *url.URL) (string, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if _, ok := c.realms[u.String()]; ok || u.Host == c.host {
+		return c.username, c.password
+	}
+	return "", ""
 }
 
-func (c credentials) RefreshToken(u *url.URL, service string) string {
+func (c *credentials) RefreshToken(u *url.URL, service string) string {
 	return ""
 }
 
-func (c credentials) SetRefreshToken(u *url.URL, service, token string) {
+func (c *credentials) SetRefreshToken(u *url.URL, service, token string) {
 }
 
-// configureAuth stores credentials for challenge responses
-func configureAuth(username, password, remoteURL string) (auth.CredentialStore, error) {
-	creds := map[string]userpass{}
+// challengeCache shares the challenges of the remotes on the same host, so
+// that a host is pinged once per TTL rather than once per remote and
+// repository.
+type challengeCache struct {
+	ttl time.Duration
 
-	authURLs, err := getAuthURLs(remoteURL)
-	if err != nil {
-		return nil, err
+	mu    sync.Mutex
+	hosts map[string]*hostChallenges
+}
+
+func newChallengeCache(ttl time.Duration) *challengeCache {
+	if ttl <= 0 {
+		ttl = defaultChallengeTTL
 	}
+	return &challengeCache{
+		ttl:   ttl,
+		hosts: make(map[string]*hostChallenges),
+	}
+}
 
-	for _, url := range authURLs {
-		dcontext.GetLogger(dcontext.Background()).Infof("Discovered token authentication URL: %s", url)
-		creds[url] = userpass{
-			username: username,
-			password: password,
+// get returns the challenges of the host of remoteURL.
+func (cc *challengeCache) get(remoteURL url.URL) *hostChallenges {
+	pingURL := url.URL{Scheme: remoteURL.Scheme, Host: remoteURL.Host, Path: "/v2/"}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	hc, ok := cc.hosts[pingURL.String()]
+	if !ok {
+		hc = &hostChallenges{
+			pingURL: pingURL,
+			ttl:     cc.ttl,
+			cm:      challenge.NewSimpleManager(),
 		}
+		cc.hosts[pingURL.String()] = hc
+	}
+	return hc
+}
+
+// hostChallenges caches the challenges returned by the ping endpoint of a
+// host, including the absence of challenges of anonymous hosts.
+type hostChallenges struct {
+	pingURL url.URL
+	ttl     time.Duration
+	cm      challenge.Manager
+
+	mu sync.Mutex
+	// expires is when the challenges must be fetched again, zero until
+	// the host is pinged or once its challenges are found stale.
+	expires time.Time
+}
+
+// establish pings the host unless its challenges are cached. Inconclusive
+// pings are not cached, so that the host is pinged again by the next
+// request.
+func (hc *hostChallenges) establish(transport http.RoundTripper) (pinged bool, err error) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if time.Now().Before(hc.expires) {
+		return false, nil
+	}
+
+	conclusive, err := ping(transport, hc.cm, hc.pingURL.String())
+	proxyMetrics.Ping(err)
+	if err != nil {
+		return false, err
+	}
+	if conclusive {
+		hc.expires = time.Now().Add(hc.ttl)
+	}
+	return true, nil
+}
+
+// challenges returns the cached challenges of the host.
+func (hc *hostChallenges) challenges() []challenge.Challenge {
+	challenges, _ := hc.cm.GetChallenges(hc.pingURL)
+	return challenges
+}
+
+// observe marks the cached challenges stale when resp is a 401 of the host
+// carrying other challenges, so that the next request pings the host again.
+func (hc *hostChallenges) observe(resp *http.Response) {
+	if resp.StatusCode != http.StatusUnauthorized || resp.Request == nil || resp.Request.URL.Host != hc.pingURL.Host {
+		return
+	}
+	if challengeKeys(challenge.ResponseChallenges(resp)) == challengeKeys(hc.challenges()) {
+		return
 	}
 
-	return credentials{creds: creds}, nil
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.expires = time.Time{}
 }
 
-func getAuthURLs(remoteURL string) ([]string, error) {
-	authURLs := []string{}
+// challengeKeys identifies challenges by their scheme, realm and service,
+// ignoring the parameters which vary with the request, such as the scope.
+func challengeKeys(challenges []challenge.Challenge) string {
+	keys := make([]string, 0, len(challenges))
+	for _, c := range challenges {
+		keys = append(keys, strings.Join([]string{c.Scheme, c.Parameters["realm"], c.Parameters["service"]}, " "))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\n")
+}
 
-	resp, err := http.Get(remoteURL + "/v2/")
+// challengeTransport watches the responses of a remote for challenges other
+// than the cached ones.
+type challengeTransport struct {
+	base       http.RoundTripper
+	challenges *hostChallenges
+}
+
+func (t *challengeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		t.challenges.observe(resp)
+	}
+	return resp, err
+}
+
+// preferredChallenges narrows the challenges of a remote offering both bearer
+// and basic authentication to the scheme the proxy authenticates with: basic
+// when credentials are configured, sparing the token round trip, and bearer
+// otherwise, so that anonymous pulls get anonymous tokens.
+type preferredChallenges struct {
+	challenge.Manager
+	basic bool
+}
+
+func (m preferredChallenges) GetChallenges(endpoint url.URL) ([]challenge.Challenge, error) {
+	challenges, err := m.Manager.GetChallenges(endpoint)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	for _, c := range challenge.ResponseChallenges(resp) {
-		if strings.EqualFold(c.Scheme, "bearer") {
-			authURLs = append(authURLs, c.Parameters["realm"])
+	var basic, others []challenge.Challenge
+	for _, c := range challenges {
+		if strings.EqualFold(c.Scheme, "basic") {
+			basic = append(basic, c)
+		} else {
+			others = append(others, c)
 		}
 	}
-
-	return authURLs, nil
+	if m.basic && len(basic) > 0 {
+		return basic, nil
+	}
+	if len(others) > 0 {
+		return others, nil
+	}
+	return challenges, nil
 }
 
-func ping(manager challenge.Manager, endpoint, versionHeader string) error {
-	resp, err := http.Get(endpoint)
+// ping requests the ping endpoint of a remote, adding its challenges to
+// manager. It reports whether the response was conclusive, that is whether
+// the endpoint answered with its challenges or without any, rather than
+// failing.
+func ping(transport http.RoundTripper, manager challenge.Manager, endpoint string) (bool, error) {
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(endpoint)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer resp.Body.Close()
 
-	return manager.AddResponse(resp)
+	if err := manager.AddResponse(resp); err != nil {
+		return false, err
+	}
+	return resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusUnauthorized, nil
 }
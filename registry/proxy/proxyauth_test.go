@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// challengeUpstream is a remote requiring authentication for its manifests,
+// counting the requests to its ping endpoint.
+type challengeUpstream struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	challenges []string
+	reject     bool
+	pings      int
+	// schemes are the authorization schemes of the accepted requests.
+	schemes []string
+}
+
+func newChallengeUpstream(t *testing.T) *challengeUpstream {
+	u := &challengeUpstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(u.serveHTTP))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func (u *challengeUpstream) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	unauthorized := func() {
+		for _, c := range u.challenges {
+			w.Header().Add("WWW-Authenticate", c)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	switch {
+	case r.URL.Path == "/v2/":
+		u.pings++
+		unauthorized()
+	case r.URL.Path == "/token":
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"token":"token","expires_in":300}`)
+	case r.Header.Get("Authorization") == "" || u.reject:
+		unauthorized()
+	default:
+		scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		u.schemes = append(u.schemes, scheme)
+		w.Header().Set("Content-Type", v1.MediaTypeImageManifest)
+		w.Header().Set("Content-Length", "2")
+		w.Header().Set("Docker-Content-Digest", digest.FromString("{}").String())
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, "{}")
+		}
+	}
+}
+
+func (u *challengeUpstream) set(f func(u *challengeUpstream)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	f(u)
+}
+
+func (u *challengeUpstream) pingCount() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.pings
+}
+
+func newChallengeTestProxy(t *testing.T, config configuration.Proxy) *proxyingRegistry {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttl := time.Duration(0)
+	config.TTL = &ttl
+	pr, err := NewRegistryPullThroughCache(ctx, registry, inmemory.New(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pr.(*proxyingRegistry)
+}
+
+// getTag resolves the latest tag of the named repository through pr.
+func getTag(t *testing.T, pr *proxyingRegistry, name string) error {
+	ctx := context.Background()
+	named, err := reference.WithName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := pr.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.Tags(ctx).Get(ctx, "latest")
+	return err
+}
+
+func TestChallengeCache(t *testing.T) {
+	upstream := newChallengeUpstream(t)
+	upstream.challenges = []string{fmt.Sprintf(`Bearer realm="%s/token",service="upstream"`, upstream.URL)}
+
+	// The remotes on the same host ping it once, at startup.
+	pr := newChallengeTestProxy(t, configuration.Proxy{
+		RemoteURL: upstream.URL,
+		Remotes: []configuration.ProxyRemote{
+			{Namespace: "a", RemoteURL: upstream.URL},
+			{Namespace: "b", RemoteURL: upstream.URL},
+		},
+	})
+	if pings := upstream.pingCount(); pings != 1 {
+		t.Fatalf("expected 1 ping at startup, got %d", pings)
+	}
+	for _, name := range []string{"a/foo", "b/foo", "library/foo", "library/bar"} {
+		if err := getTag(t, pr, name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if pings := upstream.pingCount(); pings != 1 {
+		t.Fatalf("expected the challenges to be cached, got %d pings", pings)
+	}
+
+	// A 401 with the cached challenges does not refresh them.
+	upstream.set(func(u *challengeUpstream) { u.reject = true })
+	for i := 0; i < 2; i++ {
+		if err := getTag(t, pr, "library/rejected"); err == nil {
+			t.Fatal("expected the rejected request to fail")
+		}
+	}
+	if pings := upstream.pingCount(); pings != 1 {
+		t.Fatalf("expected matching challenges to stay cached, got %d pings", pings)
+	}
+
+	// A 401 with other challenges refreshes them on the next request.
+	upstream.set(func(u *challengeUpstream) {
+		u.challenges = []string{fmt.Sprintf(`Bearer realm="%s/token",service="rotated"`, u.URL)}
+	})
+	if err := getTag(t, pr, "library/rotated"); err == nil {
+		t.Fatal("expected the rejected request to fail")
+	}
+	if pings := upstream.pingCount(); pings != 1 {
+		t.Fatalf("expected the challenges to be refreshed lazily, got %d pings", pings)
+	}
+	upstream.set(func(u *challengeUpstream) { u.reject = false })
+	for _, name := range []string{"library/rotated", "a/rotated"} {
+		if err := getTag(t, pr, name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if pings := upstream.pingCount(); pings != 2 {
+		t.Fatalf("expected the challenges to be refreshed once, got %d pings", pings)
+	}
+}
+
+func TestChallengeCacheTTL(t *testing.T) {
+	upstream := newChallengeUpstream(t)
+	upstream.challenges = []string{fmt.Sprintf(`Bearer realm="%s/token",service="upstream"`, upstream.URL)}
+
+	pr := newChallengeTestProxy(t, configuration.Proxy{
+		RemoteURL:    upstream.URL,
+		ChallengeTTL: time.Millisecond,
+	})
+	time.Sleep(2 * time.Millisecond)
+	if err := getTag(t, pr, "library/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if pings := upstream.pingCount(); pings != 2 {
+		t.Fatalf("expected the expired challenges to be refreshed, got %d pings", pings)
+	}
+}
+
+func TestChallengeSchemes(t *testing.T) {
+	upstream := newChallengeUpstream(t)
+	upstream.challenges = []string{
+		fmt.Sprintf(`Bearer realm="%s/token",service="upstream"`, upstream.URL),
+		`Basic realm="upstream"`,
+	}
+
+	for _, tc := range []struct {
+		name     string
+		username string
+		password string
+		scheme   string
+	}{
+		{name: "anonymous", scheme: "Bearer"},
+		{name: "credentials", username: "user", password: "pass", scheme: "Basic"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream.set(func(u *challengeUpstream) { u.schemes = nil })
+			pr := newChallengeTestProxy(t, configuration.Proxy{
+				RemoteURL: upstream.URL,
+				Username:  tc.username,
+				Password:  tc.password,
+			})
+			if err := getTag(t, pr, "library/foo"); err != nil {
+				t.Fatal(err)
+			}
+			upstream.set(func(u *challengeUpstream) {
+				if expected := []string{tc.scheme}; !reflect.DeepEqual(u.schemes, expected) {
+					t.Errorf("unexpected authorization schemes: %v != %v", u.schemes, expected)
+				}
+			})
+		})
+	}
+}
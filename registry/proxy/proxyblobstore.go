@@ -4,8 +4,8 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"os"
 	"strconv"
-	"sync"
 	"time"
 
 	"github.com/opencontainers/go-digest"
@@ -23,15 +23,17 @@ type proxyBlobStore struct {
 	ttl            *time.Duration
 	repositoryName reference.Named
 	authChallenger authChallenger
-}
 
-var _ distribution.BlobStore = &proxyBlobStore{}
+	// remote is the URL of the remote blobs are fetched from. It is
+	// recorded with the scheduler entries of cached blobs.
+	remote string
 
-// inflight tracks currently downloading blobs
-var inflight = make(map[digest.Digest]struct{})
+	// allowPush enables blob uploads, which are written to the remote
+	// before they are cached locally.
+	allowPush bool
+}
 
-// mu protects inflight
-var mu sync.Mutex
+var _ distribution.BlobStore = &proxyBlobStore{}
 
 func setResponseHeaders(w http.ResponseWriter, length int64, mediaType string, digest digest.Digest) {
 	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
@@ -40,7 +42,13 @@ func setResponseHeaders(w http.ResponseWriter, length int64, mediaType string, d
 	w.Header().Set("Etag", digest.String())
 }
 
-func (pbs *proxyBlobStore) copyContent(ctx context.Context, dgst digest.Digest, writer io.Writer) (distribution.Descriptor, error) {
+// copyContent copies the blob dgst from the remote to writer. If writer is an
+// http.ResponseWriter, or started is not nil, they are told the descriptor of
+// the blob before the copy starts.
+func (pbs *proxyBlobStore) copyContent(ctx context.Context, dgst digest.Digest, writer io.Writer, started func(distribution.Descriptor)) (_ distribution.Descriptor, err error) {
+	ctx, span := startRemoteSpan(ctx, "proxy.FetchBlob", pbs.repositoryName, dgst.String())
+	defer func() { endRemoteSpan(span, err) }()
+
 	desc, err := pbs.remoteStore.Stat(ctx, dgst)
 	if err != nil {
 		return distribution.Descriptor{}, err
@@ -49,6 +57,9 @@ func (pbs *proxyBlobStore) copyContent(ctx context.Context, dgst digest.Digest,
 	if w, ok := writer.(http.ResponseWriter); ok {
 		setResponseHeaders(w, desc.Size, desc.MediaType, dgst)
 	}
+	if started != nil {
+		started(desc)
+	}
 
 	remoteReader, err := pbs.remoteStore.Open(ctx, dgst)
 	if err != nil {
@@ -77,6 +88,9 @@ func (pbs *proxyBlobStore) serveLocal(ctx context.Context, w http.ResponseWriter
 	}
 
 	proxyMetrics.BlobPush(uint64(localDesc.Size), true)
+	if pbs.scheduler != nil {
+		pbs.scheduler.Touch(pbs.repositoryName)
+	}
 	return true, pbs.localStore.ServeBlob(ctx, w, r, dgst)
 }
 
@@ -96,23 +110,57 @@ func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter,
 	}
 
 	mu.Lock()
-	_, ok := inflight[dgst]
-	if ok {
-		// If the blob has been serving in other requests.
-		// Will return the blob from the remote store directly.
-		// TODO Maybe we could reuse the these blobs are serving remotely and caching locally.
+	if d, ok := inflight[dgst]; ok {
+		// The blob is being downloaded by another request: it is served
+		// from the download as it progresses. Range requests, and the
+		// downloads which cannot be followed, are served from the remote.
+		var (
+			f   *os.File
+			err error = errDownloadUnavailable
+		)
+		if r.Header.Get("Range") == "" {
+			f, err = d.open()
+		}
 		mu.Unlock()
-		_, err := pbs.copyContent(ctx, dgst, w)
+		if err == nil {
+			return d.serve(ctx, w, r, f)
+		}
+		_, err = pbs.copyContent(ctx, dgst, w, nil)
 		return err
 	}
-	inflight[dgst] = struct{}{}
+	d := startDownload(ctx, dgst)
 	mu.Unlock()
 
-	defer func() {
-		mu.Lock()
-		delete(inflight, dgst)
+	return pbs.storeLocal(ctx, dgst, w, d)
+}
+
+// prefetch caches a blob locally, unless it is cached already or being
+// fetched by another request.
+func (pbs *proxyBlobStore) prefetch(ctx context.Context, dgst digest.Digest) error {
+	if _, err := pbs.localStore.Stat(ctx, dgst); err == nil {
+		return nil
+	}
+
+	if err := pbs.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	if _, ok := inflight[dgst]; ok {
 		mu.Unlock()
-	}()
+		return nil
+	}
+	d := startDownload(ctx, dgst)
+	mu.Unlock()
+
+	return pbs.storeLocal(ctx, dgst, io.Discard, d)
+}
+
+// storeLocal fetches a blob from the remote into the local store, copying
+// it to w and to the followers of d as it goes, and schedules it for
+// removal. It ends d.
+func (pbs *proxyBlobStore) storeLocal(ctx context.Context, dgst digest.Digest, w io.Writer, d *download) (err error) {
+	defer func() { d.end(err) }()
 
 	bw, err := pbs.localStore.Create(ctx)
 	if err != nil {
@@ -121,8 +169,13 @@ func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter,
 
 	// Serving client and storing locally over same fetching request.
 	// This can prevent a redundant blob fetching.
-	multiWriter := io.MultiWriter(w, bw)
-	desc, err := pbs.copyContent(ctx, dgst, multiWriter)
+	multiWriter := io.MultiWriter(w, bw, d)
+	desc, err := pbs.copyContent(ctx, dgst, multiWriter, func(desc distribution.Descriptor) {
+		if rw, ok := w.(http.ResponseWriter); ok {
+			setResponseHeaders(rw, desc.Size, desc.MediaType, dgst)
+		}
+		d.start(desc)
+	})
 	if err != nil {
 		return err
 	}
@@ -132,6 +185,11 @@ func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter,
 		return err
 	}
 
+	return pbs.scheduleBlob(ctx, dgst, desc.Size)
+}
+
+// scheduleBlob schedules a locally cached blob for removal.
+func (pbs *proxyBlobStore) scheduleBlob(ctx context.Context, dgst digest.Digest, size int64) error {
 	blobRef, err := reference.WithDigest(pbs.repositoryName, dgst)
 	if err != nil {
 		dcontext.GetLogger(ctx).Errorf("Error creating reference: %s", err)
@@ -139,7 +197,7 @@ func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter,
 	}
 
 	if pbs.scheduler != nil && pbs.ttl != nil {
-		if err := pbs.scheduler.AddBlob(blobRef, *pbs.ttl); err != nil {
+		if err := pbs.scheduler.AddBlobFromRemote(blobRef, pbs.remote, size, *pbs.ttl); err != nil {
 			dcontext.GetLogger(ctx).Errorf("Error adding blob: %s", err)
 			return err
 		}
@@ -175,7 +233,9 @@ func (pbs *proxyBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte,
 		return []byte{}, err
 	}
 
-	blob, err = pbs.remoteStore.Get(ctx, dgst)
+	remoteCtx, span := startRemoteSpan(ctx, "proxy.FetchBlob", pbs.repositoryName, dgst.String())
+	blob, err = pbs.remoteStore.Get(remoteCtx, dgst)
+	endRemoteSpan(span, err)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -187,19 +247,60 @@ func (pbs *proxyBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte,
 	return blob, nil
 }
 
-// Unsupported functions
+// Put writes a blob to the remote and then caches it locally. It is only
+// supported if pushes are allowed.
 func (pbs *proxyBlobStore) Put(ctx context.Context, mediaType string, p []byte) (distribution.Descriptor, error) {
-	return distribution.Descriptor{}, distribution.ErrUnsupported
+	if !pbs.allowPush {
+		return distribution.Descriptor{}, distribution.ErrUnsupported
+	}
+
+	if err := pbs.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	if _, err := pbs.remoteStore.Put(ctx, mediaType, p); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	desc, err := pbs.localStore.Put(ctx, mediaType, p)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	return desc, pbs.scheduleBlob(ctx, desc.Digest, desc.Size)
 }
 
+// Create starts a blob upload which is staged locally and forwarded to the
+// remote on commit. It is only supported if pushes are allowed. Cross
+// repository mounts are not forwarded; the client falls back to a regular
+// upload.
 func (pbs *proxyBlobStore) Create(ctx context.Context, options ...distribution.BlobCreateOption) (distribution.BlobWriter, error) {
-	return nil, distribution.ErrUnsupported
+	if !pbs.allowPush {
+		return nil, distribution.ErrUnsupported
+	}
+
+	bw, err := pbs.localStore.Create(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pushBlobWriter{BlobWriter: bw, blobs: pbs}, nil
 }
 
+// Resume resumes a blob upload started with Create.
 func (pbs *proxyBlobStore) Resume(ctx context.Context, id string) (distribution.BlobWriter, error) {
-	return nil, distribution.ErrUnsupported
+	if !pbs.allowPush {
+		return nil, distribution.ErrUnsupported
+	}
+
+	bw, err := pbs.localStore.Resume(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &pushBlobWriter{BlobWriter: bw, blobs: pbs}, nil
 }
 
+// Unsupported functions
+
 func (pbs *proxyBlobStore) Mount(ctx context.Context, sourceRepo reference.Named, dgst digest.Digest) (distribution.Descriptor, error) {
 	return distribution.Descriptor{}, distribution.ErrUnsupported
 }
@@ -1,12 +1,15 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -521,3 +524,117 @@ func testProxyStoreServe(t *testing.T, te *testEnv, numClients int) {
 		t.Fatalf("unexpected remote stats: %#v", remoteStats)
 	}
 }
+
+// gatedBlobs is a remote serving a single blob, whose content is held back
+// after its first half until release is closed.
+type gatedBlobs struct {
+	distribution.BlobService
+	desc    distribution.Descriptor
+	content []byte
+	release chan struct{}
+	opens   atomic.Int32
+}
+
+func (gb *gatedBlobs) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	return gb.desc, nil
+}
+
+func (gb *gatedBlobs) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	gb.opens.Add(1)
+	half := len(gb.content) / 2
+	return &gatedReader{Reader: io.MultiReader(bytes.NewReader(gb.content[:half]), &releasedReader{release: gb.release, r: bytes.NewReader(gb.content[half:])})}, nil
+}
+
+type releasedReader struct {
+	release chan struct{}
+	r       io.Reader
+}
+
+func (rr *releasedReader) Read(p []byte) (int, error) {
+	<-rr.release
+	return rr.r.Read(p)
+}
+
+type gatedReader struct {
+	io.Reader
+}
+
+func (gr *gatedReader) Seek(offset int64, whence int) (int64, error) {
+	return 0, distribution.ErrUnsupported
+}
+
+func (gr *gatedReader) Close() error {
+	return nil
+}
+
+func TestProxyStoreServeInflight(t *testing.T) {
+	content := makeBlob(1 << 20)
+	for _, corrupt := range []bool{false, true} {
+		te := makeTestEnv(t, "foo/bar")
+		remote := &gatedBlobs{
+			desc:    distribution.Descriptor{MediaType: "application/octet-stream", Digest: digest.FromBytes(content), Size: int64(len(content))},
+			content: append([]byte(nil), content...),
+			release: make(chan struct{}),
+		}
+		if corrupt {
+			remote.content[len(content)-1]++
+		}
+		te.store.remoteStore = remote
+		dgst := remote.desc.Digest
+
+		serve := func(w http.ResponseWriter, errs chan<- error) {
+			r, err := http.NewRequest(http.MethodGet, "", nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			errs <- te.store.ServeBlob(te.ctx, w, r, dgst)
+		}
+
+		leaderErr := make(chan error, 1)
+		go serve(httptest.NewRecorder(), leaderErr)
+
+		// Wait for the first half of the blob to be downloaded.
+		for {
+			mu.Lock()
+			d := inflight[dgst]
+			mu.Unlock()
+			if d != nil {
+				if state, _ := d.progress(); state.written == int64(len(content)/2) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		follower := httptest.NewRecorder()
+		followerErr := make(chan error, 1)
+		go serve(follower, followerErr)
+		time.Sleep(50 * time.Millisecond)
+		close(remote.release)
+
+		err := <-leaderErr
+		if corrupt != (err != nil) {
+			t.Fatalf("corrupt %t: unexpected error of the download: %v", corrupt, err)
+		}
+		err = <-followerErr
+		if corrupt {
+			if err == nil || follower.Body.Len() == len(content) {
+				t.Fatalf("expected the follower to abort before the end of the blob, got %v and %d bytes", err, follower.Body.Len())
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if follower.Header().Get("Content-Length") != strconv.Itoa(len(content)) {
+			t.Fatalf("unexpected Content-Length: %q", follower.Header().Get("Content-Length"))
+		}
+		if !bytes.Equal(follower.Body.Bytes(), content) {
+			t.Fatal("unexpected content served to the follower")
+		}
+		if opens := remote.opens.Load(); opens != 1 {
+			t.Fatalf("expected the blob to be fetched once, got %d", opens)
+		}
+	}
+}
@@ -2,16 +2,29 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/opencontainers/go-digest"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/client"
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
 )
 
+// The failovers of the manifest lookups failing locally.
+const (
+	// failoverRemote fetches the manifest from the remote.
+	failoverRemote = "remote"
+	// failoverError fails the lookup with the error of the local storage.
+	failoverError = "error"
+)
+
 type proxyManifestStore struct {
 	ctx             context.Context
 	localManifests  distribution.ManifestService
@@ -20,12 +33,60 @@ type proxyManifestStore struct {
 	scheduler       *scheduler.TTLExpirationScheduler
 	ttl             *time.Duration
 	authChallenger  authChallenger
+
+	// remote is the URL of the remote manifests are fetched from. It is
+	// recorded with the scheduler entries of cached manifests.
+	remote string
+
+	// prefetcher, if set, fetches the blobs referenced by cached manifests
+	// through blobStore in the background.
+	prefetcher *prefetcher
+	blobStore  *proxyBlobStore
+	// allowPush enables manifest writes, which are written to the remote
+	// before they are cached locally.
+	allowPush bool
+	// failover is what lookups do when the local storage keeps failing:
+	// failoverRemote or failoverError.
+	failover string
 }
 
 var _ distribution.ManifestService = &proxyManifestStore{}
 
+// lookupLocal runs lookup against the local storage, retrying it once if it
+// fails transiently. It returns nil if lookup succeeds, if the manifest is not
+// cached locally, or if the storage keeps failing and the failover is to the
+// remote: the caller then tells a miss, to look up on the remote, from the
+// zero value left by lookup. Otherwise it returns the error of the storage.
+func (pms proxyManifestStore) lookupLocal(ctx context.Context, lookup func() error) error {
+	err := lookup()
+	if err != nil && driver.CategoryOf(err).Retriable() && ctx.Err() == nil {
+		err = lookup()
+	}
+	switch {
+	case err == nil, isManifestUnknown(err):
+		return nil
+	case ctx.Err() != nil || pms.failover == failoverError:
+		return err
+	}
+	dcontext.GetLogger(ctx).WithError(err).Warnf("local lookup of manifest in %s failed, falling back to the remote", pms.repositoryName.Name())
+	return nil
+}
+
+// isManifestUnknown reports whether err is the error of a lookup of a
+// manifest which is not stored.
+func isManifestUnknown(err error) bool {
+	return errors.Is(err, distribution.ErrBlobUnknown) ||
+		errors.As(err, &distribution.ErrManifestUnknownRevision{}) ||
+		errors.As(err, &distribution.ErrManifestUnknown{}) ||
+		driver.CategoryOf(err) == driver.ErrorCategoryNotFound
+}
+
 func (pms proxyManifestStore) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
-	exists, err := pms.localManifests.Exists(ctx, dgst)
+	var exists bool
+	err := pms.lookupLocal(ctx, func() (err error) {
+		exists, err = pms.localManifests.Exists(ctx, dgst)
+		return err
+	})
 	if err != nil {
 		return false, err
 	}
@@ -35,20 +96,101 @@ func (pms proxyManifestStore) Exists(ctx context.Context, dgst digest.Digest) (b
 	if err := pms.authChallenger.tryEstablishChallenges(ctx); err != nil {
 		return false, err
 	}
+	if statter, ok := pms.remoteManifests.(client.ManifestStatter); ok {
+		_, err := statter.Stat(ctx, dgst)
+		if errors.As(err, &distribution.ErrManifestUnknownRevision{}) {
+			return false, nil
+		}
+		return err == nil, err
+	}
 	return pms.remoteManifests.Exists(ctx, dgst)
 }
 
+// manifestStatter is implemented by the local manifest services which can
+// describe a manifest without reading it.
+type manifestStatter interface {
+	Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error)
+}
+
+// Stat describes the manifest dgst. A manifest cached locally is described
+// without being read when the local storage records its media type. A
+// manifest which is not cached locally is described by the remote from a HEAD
+// request, without being fetched nor cached.
+func (pms proxyManifestStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	statter, ok := pms.remoteManifests.(client.ManifestStatter)
+	var desc distribution.Descriptor
+	err := pms.lookupLocal(ctx, func() (err error) {
+		desc, err = pms.statLocal(ctx, dgst)
+		return err
+	})
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	if desc.Digest != "" {
+		proxyMetrics.ManifestPush(uint64(desc.Size), true)
+		if pms.scheduler != nil {
+			pms.scheduler.Touch(pms.repositoryName)
+		}
+		return desc, nil
+	}
+	if !ok {
+		manifest, err := pms.Get(ctx, dgst)
+		if err != nil {
+			return distribution.Descriptor{}, err
+		}
+		mediaType, payload, err := manifest.Payload()
+		if err != nil {
+			return distribution.Descriptor{}, err
+		}
+		return distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}, nil
+	}
+
+	if err := pms.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return statter.Stat(ctx, dgst)
+}
+
+// statLocal describes the manifest dgst cached locally.
+func (pms proxyManifestStore) statLocal(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	if statter, ok := pms.localManifests.(manifestStatter); ok {
+		return statter.Stat(ctx, dgst)
+	}
+	manifest, err := pms.localManifests.Get(ctx, dgst)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}, nil
+}
+
 func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
 	// At this point `dgst` was either specified explicitly, or returned by the
 	// tagstore with the most recent association.
-	var fromRemote bool
-	manifest, err := pms.localManifests.Get(ctx, dgst, options...)
+	var (
+		manifest     distribution.Manifest
+		fromRemote   bool
+		remoteHeader http.Header
+	)
+	err := pms.lookupLocal(ctx, func() (err error) {
+		manifest, err = pms.localManifests.Get(ctx, dgst, options...)
+		return err
+	})
 	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
 		if err := pms.authChallenger.tryEstablishChallenges(ctx); err != nil {
 			return nil, err
 		}
 
-		manifest, err = pms.remoteManifests.Get(ctx, dgst, options...)
+		remoteOptions := append([]distribution.ManifestServiceOption{client.ReturnResponseHeader(&remoteHeader)}, options...)
+		remoteCtx, span := startRemoteSpan(ctx, "proxy.FetchManifest", pms.repositoryName, dgst.String())
+		manifest, err = pms.remoteManifests.Get(remoteCtx, dgst, remoteOptions...)
+		endRemoteSpan(span, err)
 		if err != nil {
 			return nil, err
 		}
@@ -61,39 +203,132 @@ func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, optio
 	}
 
 	proxyMetrics.ManifestPush(uint64(len(payload)), !fromRemote)
+	if !fromRemote && pms.scheduler != nil {
+		pms.scheduler.Touch(pms.repositoryName)
+	}
 	if fromRemote {
 		proxyMetrics.ManifestPull(uint64(len(payload)))
 
-		_, err = pms.localManifests.Put(ctx, manifest)
-		if err != nil {
+		if err := pms.cacheManifest(ctx, dgst, manifest, remoteHeader); err != nil {
 			return nil, err
 		}
+	}
 
-		// Schedule the manifest blob for removal
-		repoBlob, err := reference.WithDigest(pms.repositoryName, dgst)
-		if err != nil {
-			dcontext.GetLogger(ctx).Errorf("Error creating reference: %s", err)
-			return nil, err
-		}
+	return manifest, err
+}
 
-		if pms.scheduler != nil && pms.ttl != nil {
-			if err := pms.scheduler.AddManifest(repoBlob, *pms.ttl); err != nil {
-				dcontext.GetLogger(ctx).Errorf("Error adding manifest: %s", err)
-				return nil, err
-			}
+// revalidateTag resolves tag against the remote with a conditional request,
+// offering the digest of the locally cached manifest as its entity tag. If
+// the remote reports the manifest unchanged the cached descriptor is returned
+// as is, without storing or rescheduling anything. Otherwise the new manifest
+// is cached locally and its descriptor returned.
+func (pms proxyManifestStore) revalidateTag(ctx context.Context, tag string, cached distribution.Descriptor) (distribution.Descriptor, error) {
+	var (
+		remoteDgst   digest.Digest
+		remoteHeader http.Header
+	)
+	manifest, err := pms.remoteManifests.Get(ctx, "",
+		distribution.WithTag(tag),
+		client.AddEtagToTag(tag, cached.Digest.String()),
+		client.ReturnContentDigest(&remoteDgst),
+		client.ReturnResponseHeader(&remoteHeader))
+	if errors.Is(err, distribution.ErrManifestNotModified) {
+		if remoteDgst == "" || remoteDgst == cached.Digest {
+			return cached, nil
 		}
 
-		// Ensure the manifest blob is cleaned up
-		// pms.scheduler.AddBlob(blobRef, repositoryTTL)
+		// The remote claims our copy is current but reports a different
+		// digest for the tag; it has been rewritten upstream.
+		dcontext.GetLogger(ctx).Warnf("remote returned 304 for %s:%s but digest %s, expected %s", pms.repositoryName.Name(), tag, remoteDgst, cached.Digest)
+		manifest, err = pms.remoteManifests.Get(ctx, remoteDgst,
+			client.ReturnContentDigest(&remoteDgst),
+			client.ReturnResponseHeader(&remoteHeader))
+	}
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
 
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return distribution.Descriptor{}, err
 	}
 
-	return manifest, err
+	dgst := digest.FromBytes(payload)
+	if remoteDgst != "" && remoteDgst != dgst {
+		return distribution.Descriptor{}, fmt.Errorf("remote manifest for %s:%s has digest %s, but Docker-Content-Digest is %s", pms.repositoryName.Name(), tag, dgst, remoteDgst)
+	}
+
+	proxyMetrics.ManifestPull(uint64(len(payload)))
+	if err := pms.cacheManifest(ctx, dgst, manifest, remoteHeader); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	return distribution.Descriptor{
+		MediaType: mediaType,
+		Digest:    dgst,
+		Size:      int64(len(payload)),
+	}, nil
 }
 
+// cacheManifest stores a manifest fetched from the remote and schedules it
+// for expiry, honoring the cache policy in the remote's response header.
+func (pms proxyManifestStore) cacheManifest(ctx context.Context, dgst digest.Digest, manifest distribution.Manifest, remoteHeader http.Header) error {
+	policy := parseCachePolicy(remoteHeader, time.Now())
+	if policy.noStore {
+		return nil
+	}
+
+	if _, err := pms.localManifests.Put(ctx, manifest); err != nil {
+		return err
+	}
+
+	// Schedule the manifest blob for removal
+	repoBlob, err := reference.WithDigest(pms.repositoryName, dgst)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error creating reference: %s", err)
+		return err
+	}
+
+	ttl := pms.ttl
+	if policy.hasTTL {
+		ttl = &policy.ttl
+	}
+
+	if pms.scheduler != nil && ttl != nil {
+		if err := pms.scheduler.AddManifestFromRemote(repoBlob, pms.remote, *ttl); err != nil {
+			dcontext.GetLogger(ctx).Errorf("Error adding manifest: %s", err)
+			return err
+		}
+	}
+
+	if pms.prefetcher != nil {
+		pms.prefetcher.enqueueManifest(pms.blobStore, manifest)
+	}
+
+	return nil
+}
+
+// Put writes a manifest to the remote and then caches it locally. It is only
+// supported if pushes are allowed.
 func (pms proxyManifestStore) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
 	var d digest.Digest
-	return d, distribution.ErrUnsupported
+	if !pms.allowPush {
+		return d, distribution.ErrUnsupported
+	}
+
+	if err := pms.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return d, err
+	}
+
+	d, err := pms.remoteManifests.Put(ctx, manifest, options...)
+	if err != nil {
+		return d, err
+	}
+
+	if err := pms.cacheManifest(ctx, d, manifest, nil); err != nil {
+		return d, err
+	}
+	return d, nil
 }
 
 func (pms proxyManifestStore) Delete(ctx context.Context, dgst digest.Digest) error {
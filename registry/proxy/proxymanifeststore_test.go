@@ -3,16 +3,23 @@ package proxy
 import (
 	"bytes"
 	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/client"
 	"github.com/distribution/distribution/v3/internal/client/auth"
 	"github.com/distribution/distribution/v3/internal/client/auth/challenge"
 	"github.com/distribution/distribution/v3/manifest/schema2"
 	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/cache/memory"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/distribution/distribution/v3/testutil"
 	"github.com/distribution/reference"
@@ -331,3 +338,354 @@ func TestProxyManifestsMetrics(t *testing.T) {
 		t.Errorf("Expected manifestMetrics.BytesPushed %d but got %d", 514, proxyMetrics.manifestMetrics.BytesPushed)
 	}
 }
+
+// useRemoteServer replaces the remote manifest service of env with a client
+// talking to an HTTP server which serves the test manifest with the given
+// response headers.
+func useRemoteServer(t *testing.T, env *manifestStoreTestEnv, header http.Header) *httptest.Server {
+	ctx := context.Background()
+	m, err := env.manifests.remoteManifests.Get(ctx, env.manifestDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, payload, err := m.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Type", mediaType)
+		w.Header().Set("Docker-Content-Digest", env.manifestDigest.String())
+		_, _ = w.Write(payload)
+	}))
+	t.Cleanup(s.Close)
+
+	remoteRepo, err := client.NewRepository(env.manifests.repositoryName, s.URL, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteManifests, err := remoteRepo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.manifests.remoteManifests = remoteManifests
+	return s
+}
+
+func TestProxyManifestsNoStore(t *testing.T) {
+	env := newManifestStoreTestEnv(t, "foo/bar", "latest")
+	useRemoteServer(t, env, http.Header{"Cache-Control": []string{"no-store"}})
+	localStats := env.LocalStats()
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := env.manifests.Get(ctx, env.manifestDigest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if (*localStats)["put"] != 0 {
+		t.Errorf("Expected no local put for no-store manifest, got %d", (*localStats)["put"])
+	}
+	if (*localStats)["get"] != 2 {
+		t.Errorf("Expected every get to miss the local cache, got %d", (*localStats)["get"])
+	}
+}
+
+func TestProxyManifestsMaxAge(t *testing.T) {
+	env := newManifestStoreTestEnv(t, "foo/bar", "latest")
+	useRemoteServer(t, env, http.Header{"Cache-Control": []string{"max-age=0"}})
+	localStats := env.LocalStats()
+
+	expired := make(chan reference.Reference, 1)
+	env.manifests.scheduler.OnManifestExpire(func(ref reference.Reference) error {
+		expired <- ref
+		return nil
+	})
+	if err := env.manifests.scheduler.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer env.manifests.scheduler.Stop()
+
+	// The configured TTL is overridden by the upstream max-age.
+	ttl := 24 * time.Hour
+	env.manifests.ttl = &ttl
+
+	if _, err := env.manifests.Get(context.Background(), env.manifestDigest); err != nil {
+		t.Fatal(err)
+	}
+	if (*localStats)["put"] != 1 {
+		t.Errorf("Expected local put")
+	}
+
+	select {
+	case ref := <-expired:
+		if ref.(reference.Canonical).Digest() != env.manifestDigest {
+			t.Errorf("Unexpected manifest expired: %s", ref)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected manifest to expire according to max-age")
+	}
+}
+
+func TestProxyTagRevalidate(t *testing.T) {
+	env := newManifestStoreTestEnv(t, "foo/bar", "latest")
+	ctx := context.Background()
+	m, err := env.manifests.remoteManifests.Get(ctx, env.manifestDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, payload, err := m.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu          sync.Mutex
+		requests    int
+		bodyBytes   int
+		contentDgst = env.manifestDigest
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+
+		w.Header().Set("Docker-Content-Digest", contentDgst.String())
+		if r.Header.Get("If-None-Match") == `"`+env.manifestDigest.String()+`"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", mediaType)
+		n, _ := w.Write(payload)
+		bodyBytes += n
+	}))
+	defer s.Close()
+
+	remoteRepo, err := client.NewRepository(env.manifests.repositoryName, s.URL, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localStats := env.LocalStats()
+
+	newTagService := func(cached digest.Digest) *proxyTagService {
+		// The client remembers entity tags, so use a fresh one each time.
+		remoteManifests, err := remoteRepo.Manifests(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.manifests.remoteManifests = remoteManifests
+		return &proxyTagService{
+			localTags: &mockTagStore{mapping: map[string]distribution.Descriptor{
+				"latest": {MediaType: mediaType, Digest: cached, Size: int64(len(payload))},
+			}},
+			authChallenger: &mockChallenger{},
+			manifests:      &env.manifests,
+		}
+	}
+
+	// An unchanged tag is answered with 304 and nothing is stored.
+	desc, err := newTagService(env.manifestDigest).Get(ctx, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != env.manifestDigest {
+		t.Errorf("Unexpected digest %s", desc.Digest)
+	}
+	if requests != 1 || bodyBytes != 0 {
+		t.Errorf("Expected a single request without body, got %d requests and %d bytes", requests, bodyBytes)
+	}
+	if (*localStats)["put"] != 0 {
+		t.Errorf("Expected no local put for an unchanged tag")
+	}
+
+	// A stale local tag is replaced by the remote manifest.
+	desc, err = newTagService(digest.FromString("stale")).Get(ctx, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != env.manifestDigest || desc.Size != int64(len(payload)) {
+		t.Errorf("Unexpected descriptor %v", desc)
+	}
+	if bodyBytes != len(payload) {
+		t.Errorf("Expected the manifest body to be transferred, got %d bytes", bodyBytes)
+	}
+	if (*localStats)["put"] != 1 {
+		t.Errorf("Expected the new manifest to be stored locally")
+	}
+
+	// A 304 that contradicts the cached digest causes a refetch by the
+	// digest the remote reports; a body whose digest does not match that
+	// header is rejected and the local tag is served instead.
+	contentDgst = digest.FromString("rewritten")
+	pt := newTagService(env.manifestDigest)
+	if _, err := pt.manifests.revalidateTag(ctx, "latest", distribution.Descriptor{Digest: env.manifestDigest}); err == nil {
+		t.Fatal("Expected digest mismatch to be reported")
+	}
+	stale := digest.FromString("stale")
+	desc, err = newTagService(stale).Get(ctx, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != stale {
+		t.Errorf("Expected local tag to be served, got %s", desc.Digest)
+	}
+	if (*localStats)["put"] != 1 {
+		t.Errorf("Expected no local put for a mismatched manifest")
+	}
+}
+
+func TestProxyManifestsStat(t *testing.T) {
+	env := newManifestStoreTestEnv(t, "foo/bar", "latest")
+	m, err := env.manifests.remoteManifests.Get(context.Background(), env.manifestDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, payload, err := m.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var methods []string
+	s := useRemoteServer(t, env, nil)
+	handler := s.Config.Handler
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		handler.ServeHTTP(w, r)
+	})
+	localStats := env.LocalStats()
+
+	ctx := context.Background()
+	exists, err := env.manifests.Exists(ctx, env.manifestDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("Expected manifest to exist on the remote")
+	}
+	desc, err := env.manifests.Stat(ctx, env.manifestDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := distribution.Descriptor{MediaType: mediaType, Digest: env.manifestDigest, Size: int64(len(payload))}
+	if !reflect.DeepEqual(desc, expected) {
+		t.Fatalf("Unexpected descriptor: %v != %v", desc, expected)
+	}
+	if !reflect.DeepEqual(methods, []string{http.MethodHead, http.MethodHead}) {
+		t.Fatalf("Expected only HEAD requests to the remote, got %v", methods)
+	}
+	if (*localStats)["put"] != 0 {
+		t.Errorf("Expected stat not to cache the manifest, got %d puts", (*localStats)["put"])
+	}
+
+	// Once cached, the manifest is described without asking the remote.
+	if _, err := env.manifests.Get(ctx, env.manifestDigest); err != nil {
+		t.Fatal(err)
+	}
+	methods = nil
+	desc, err = env.manifests.Stat(ctx, env.manifestDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(desc, expected) {
+		t.Fatalf("Unexpected descriptor of cached manifest: %v != %v", desc, expected)
+	}
+	if len(methods) != 0 {
+		t.Fatalf("Expected no request to the remote for a cached manifest, got %v", methods)
+	}
+}
+
+// faultyManifests fails the lookups of manifests with err, failures times.
+type faultyManifests struct {
+	distribution.ManifestService
+	err      error
+	failures *int
+}
+
+func (fm faultyManifests) fail() error {
+	if *fm.failures == 0 {
+		return nil
+	}
+	*fm.failures--
+	return fm.err
+}
+
+func (fm faultyManifests) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	if err := fm.fail(); err != nil {
+		return false, err
+	}
+	return fm.ManifestService.Exists(ctx, dgst)
+}
+
+func (fm faultyManifests) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	if err := fm.fail(); err != nil {
+		return nil, err
+	}
+	return fm.ManifestService.Get(ctx, dgst, options...)
+}
+
+func TestProxyManifestsLocalFailures(t *testing.T) {
+	ctx := context.Background()
+	timeout := driver.WithCategory(errors.New("storage request timed out"), driver.ErrorCategoryTimeout)
+
+	for _, tc := range []struct {
+		name       string
+		failover   string
+		err        error
+		failures   int
+		expectErr  bool
+		fromRemote bool
+	}{
+		{name: "retried", failover: failoverError, err: timeout, failures: 1},
+		{name: "failover remote", failover: failoverRemote, err: timeout, failures: 2, fromRemote: true},
+		{name: "failover error", failover: failoverError, err: timeout, failures: 2, expectErr: true},
+		{name: "permanent failover remote", failover: failoverRemote, err: errors.New("corrupt"), failures: 1, fromRemote: true},
+		{name: "permanent failover error", failover: failoverError, err: errors.New("corrupt"), failures: 1, expectErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newManifestStoreTestEnv(t, "foo/bar", "latest")
+			env.manifests.failover = tc.failover
+			// Cache the manifest locally.
+			if _, err := env.manifests.Get(ctx, env.manifestDigest); err != nil {
+				t.Fatal(err)
+			}
+			remoteStats := env.RemoteStats()
+			remoteLookups := (*remoteStats)["get"] + (*remoteStats)["exists"]
+
+			failures := tc.failures
+			env.manifests.localManifests = faultyManifests{ManifestService: env.manifests.localManifests, err: tc.err, failures: &failures}
+			_, err := env.manifests.Get(ctx, env.manifestDigest)
+			if tc.expectErr {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expected the local error, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if fromRemote := (*remoteStats)["get"]+(*remoteStats)["exists"] > remoteLookups; fromRemote != tc.fromRemote {
+				t.Fatalf("expected lookup from remote %t, got %t", tc.fromRemote, fromRemote)
+			}
+
+			failures = tc.failures
+			exists, err := env.manifests.Exists(ctx, env.manifestDigest)
+			if tc.expectErr {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expected the local error, got %v", err)
+				}
+			} else if err != nil || !exists {
+				t.Fatalf("expected the manifest to exist, got %t, %v", exists, err)
+			}
+		})
+	}
+
+	// Manifests unknown locally are looked up on the remote whatever the
+	// failover.
+	env := newManifestStoreTestEnv(t, "foo/bar", "latest")
+	env.manifests.failover = failoverError
+	if _, err := env.manifests.Get(ctx, env.manifestDigest); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -19,6 +19,12 @@ var (
 	pulledBytes = prometheus.ProxyNamespace.NewLabeledCounter("pulled_bytes", "The size of total bytes pulled from the upstream", "type")
 	// pushedBytes is the size of total bytes pushed to the client for blob/manifest
 	pushedBytes = prometheus.ProxyNamespace.NewLabeledCounter("pushed_bytes", "The size of total bytes pushed to the client", "type")
+	// tokenRefreshes is the number of bearer tokens fetched from the upstream, by result
+	tokenRefreshes = prometheus.ProxyNamespace.NewLabeledCounter("token_refreshes", "The number of bearer tokens fetched from the upstream", "result")
+	// pings is the number of requests to the ping endpoint of the upstream, by result
+	pings = prometheus.ProxyNamespace.NewLabeledCounter("pings", "The number of requests to the ping endpoint of the upstream", "result")
+	// prefetchPending is the number of blobs waiting to be prefetched
+	prefetchPending = prometheus.ProxyNamespace.NewGauge("prefetch_pending", "The number of blobs waiting to be prefetched", metrics.Total)
 )
 
 // Metrics is used to hold metric counters
@@ -64,6 +70,10 @@ func init() {
 	metrics.Register(prometheus.ProxyNamespace)
 	initPrometheusMetrics("blob")
 	initPrometheusMetrics("manifest")
+	tokenRefreshes.WithValues("success").Inc(0)
+	tokenRefreshes.WithValues("failure").Inc(0)
+	pings.WithValues("success").Inc(0)
+	pings.WithValues("failure").Inc(0)
 }
 
 func initPrometheusMetrics(value string) {
@@ -121,3 +131,21 @@ func (pmc *proxyMetricsCollector) ManifestPush(bytesPushed uint64, isHit bool) {
 		hits.WithValues("manifest").Inc(1)
 	}
 }
+
+// TokenFetch tracks attempts to fetch a bearer token from the upstream
+func (pmc *proxyMetricsCollector) TokenFetch(err error) {
+	if err != nil {
+		tokenRefreshes.WithValues("failure").Inc(1)
+		return
+	}
+	tokenRefreshes.WithValues("success").Inc(1)
+}
+
+// Ping tracks requests to the ping endpoint of the upstream
+func (pmc *proxyMetricsCollector) Ping(err error) {
+	if err != nil {
+		pings.WithValues("failure").Inc(1)
+		return
+	}
+	pings.WithValues("success").Inc(1)
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/client/auth"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+)
+
+// pushCheckRepository is the repository, in the namespace of the configured
+// user, against which push access to a remote is checked at startup.
+// Registries such as Docker Hub grant users push access to their own
+// namespace.
+const pushCheckRepository = "push-check"
+
+// pushBlobWriter stages a pushed blob in the local store. On commit the
+// staged content is uploaded to the remote first, and the blob is only
+// committed locally if the remote accepted it.
+type pushBlobWriter struct {
+	distribution.BlobWriter
+	blobs *proxyBlobStore
+}
+
+// stagedReader is implemented by local blob writers which can read back the
+// content written so far.
+type stagedReader interface {
+	Reader() (io.ReadCloser, error)
+}
+
+func (bw *pushBlobWriter) Commit(ctx context.Context, desc distribution.Descriptor) (distribution.Descriptor, error) {
+	// Flush the staged content, then resume the upload to read it back.
+	if err := bw.BlobWriter.Close(); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	local, err := bw.blobs.localStore.Resume(ctx, bw.ID())
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	if err := bw.pushRemote(ctx, local, desc); err != nil {
+		if cerr := local.Cancel(ctx); cerr != nil {
+			dcontext.GetLogger(ctx).Errorf("Error cancelling local upload %s: %s", bw.ID(), cerr)
+		}
+		return distribution.Descriptor{}, err
+	}
+
+	canonical, err := local.Commit(ctx, desc)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	return canonical, bw.blobs.scheduleBlob(ctx, canonical.Digest, canonical.Size)
+}
+
+// pushRemote uploads the content staged in local to the remote.
+func (bw *pushBlobWriter) pushRemote(ctx context.Context, local distribution.BlobWriter, desc distribution.Descriptor) error {
+	staged, ok := local.(stagedReader)
+	if !ok {
+		return fmt.Errorf("local blob writer %T cannot read back staged content", local)
+	}
+	rc, err := staged.Reader()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := bw.blobs.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return err
+	}
+
+	remote, err := bw.blobs.remoteStore.Create(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := remote.ReadFrom(rc); err != nil {
+		_ = remote.Cancel(ctx)
+		return err
+	}
+	if _, err := remote.Commit(ctx, desc); err != nil {
+		_ = remote.Cancel(ctx)
+		return err
+	}
+	return nil
+}
+
+// checkPushAccess verifies that the credentials for r may push to
+// repository, by requesting a token for a push scope and inspecting the
+// access it grants. Remotes that do not use token authentication, or issue
+// opaque tokens, cannot be checked and are assumed to allow pushes.
+func checkPushAccess(ctx context.Context, r *remote, repository string) error {
+	if err := r.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return err
+	}
+
+	pingURL := r.url
+	pingURL.Path = "/v2/"
+	challenges, err := r.authChallenger.challengeManager().GetChallenges(pingURL)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range challenges {
+		if !strings.EqualFold(c.Scheme, "bearer") {
+			continue
+		}
+
+		th := auth.NewTokenHandlerWithOptions(auth.TokenHandlerOptions{
+			Transport:   r.transport,
+			Credentials: r.authChallenger.credentialStore(),
+			Scopes: []auth.Scope{
+				auth.RepositoryScope{
+					Repository: repository,
+					Actions:    []string{"pull", "push"},
+				},
+			},
+			Logger: dcontext.GetLogger(ctx),
+		})
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, pingURL.String(), nil)
+		if err != nil {
+			return err
+		}
+		if err := th.AuthorizeRequest(req, c.Parameters); err != nil {
+			return fmt.Errorf("proxy remote %s: unable to obtain push token: %w", r.url.String(), err)
+		}
+
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		actions, ok := grantedActions(token, repository)
+		if !ok {
+			dcontext.GetLogger(ctx).Warnf("proxy remote %s issued an opaque token, unable to verify push access", r.url.String())
+			return nil
+		}
+		for _, action := range actions {
+			if action == "push" || action == "*" {
+				return nil
+			}
+		}
+		return fmt.Errorf("proxy remote %s: credentials lack push access to %s", r.url.String(), repository)
+	}
+
+	return nil
+}
+
+// grantedActions returns the actions on repository granted by a JWT bearer
+// token. The second return value is false if the token is not a JWT.
+func grantedActions(token, repository string) ([]string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, false
+	}
+
+	var claims struct {
+		Access []struct {
+			Type    string   `json:"type"`
+			Name    string   `json:"name"`
+			Actions []string `json:"actions"`
+		} `json:"access"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, false
+	}
+
+	var actions []string
+	for _, a := range claims.Access {
+		if a.Type == "repository" && a.Name == repository {
+			actions = append(actions, a.Actions...)
+		}
+	}
+	return actions, true
+}
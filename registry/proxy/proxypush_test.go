@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
+)
+
+var errRemoteUnavailable = errors.New("remote unavailable")
+
+// failingBlobService rejects every upload.
+type failingBlobService struct {
+	distribution.BlobService
+}
+
+func (failingBlobService) Create(ctx context.Context, options ...distribution.BlobCreateOption) (distribution.BlobWriter, error) {
+	return nil, errRemoteUnavailable
+}
+
+// failingManifestService rejects every manifest write.
+type failingManifestService struct {
+	distribution.ManifestService
+}
+
+func (failingManifestService) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
+	return "", errRemoteUnavailable
+}
+
+func pushBlob(t *testing.T, te *testEnv, content []byte) (distribution.Descriptor, error) {
+	t.Helper()
+
+	bw, err := te.store.Create(te.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	return bw.Commit(te.ctx, distribution.Descriptor{Digest: digest.FromBytes(content)})
+}
+
+func TestProxyStorePush(t *testing.T) {
+	te := makeTestEnv(t, "foo/bar")
+
+	if _, err := te.store.Create(te.ctx); err != distribution.ErrUnsupported {
+		t.Fatalf("Expected pushes to be unsupported by default, got %v", err)
+	}
+
+	te.store.allowPush = true
+	content := makeBlob(100)
+	desc, err := pushBlob(t, te, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != digest.FromBytes(content) || desc.Size != int64(len(content)) {
+		t.Errorf("Unexpected descriptor %v", desc)
+	}
+
+	if _, err := te.store.remoteStore.Stat(te.ctx, desc.Digest); err != nil {
+		t.Errorf("Expected blob to be pushed to the remote: %v", err)
+	}
+	if _, err := te.store.localStore.Stat(te.ctx, desc.Digest); err != nil {
+		t.Errorf("Expected blob to be cached locally: %v", err)
+	}
+}
+
+func TestProxyStorePushRemoteFailure(t *testing.T) {
+	te := makeTestEnv(t, "foo/bar")
+	te.store.allowPush = true
+	te.store.remoteStore = failingBlobService{te.store.remoteStore}
+
+	content := makeBlob(100)
+	if _, err := pushBlob(t, te, content); !errors.Is(err, errRemoteUnavailable) {
+		t.Fatalf("Expected remote failure, got %v", err)
+	}
+
+	if _, err := te.store.localStore.Stat(te.ctx, digest.FromBytes(content)); err != distribution.ErrBlobUnknown {
+		t.Errorf("Expected no local blob after remote failure, got %v", err)
+	}
+}
+
+func TestProxyManifestsPush(t *testing.T) {
+	env := newManifestStoreTestEnv(t, "foo/bar", "latest")
+	ctx := context.Background()
+	m, err := env.manifests.remoteManifests.Get(ctx, env.manifestDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.manifests.Put(ctx, m); err != distribution.ErrUnsupported {
+		t.Fatalf("Expected pushes to be unsupported by default, got %v", err)
+	}
+
+	env.manifests.allowPush = true
+	remote := env.manifests.remoteManifests
+	env.manifests.remoteManifests = failingManifestService{remote}
+	if _, err := env.manifests.Put(ctx, m); !errors.Is(err, errRemoteUnavailable) {
+		t.Fatalf("Expected remote failure, got %v", err)
+	}
+	if (*env.LocalStats())["put"] != 0 {
+		t.Errorf("Expected no local put after remote failure")
+	}
+
+	env.manifests.remoteManifests = remote
+	dgst, err := env.manifests.Put(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dgst != env.manifestDigest {
+		t.Errorf("Unexpected digest %s", dgst)
+	}
+	if (*env.RemoteStats())["put"] != 1 {
+		t.Errorf("Expected manifest to be pushed to the remote")
+	}
+	if (*env.LocalStats())["put"] != 1 {
+		t.Errorf("Expected manifest to be cached locally")
+	}
+}
+
+func TestGrantedActions(t *testing.T) {
+	jwt := func(payload string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+	}
+
+	for _, tc := range []struct {
+		token   string
+		actions []string
+		ok      bool
+	}{
+		{
+			token:   jwt(`{"access":[{"type":"repository","name":"user/push-check","actions":["pull","push"]}]}`),
+			actions: []string{"pull", "push"},
+			ok:      true,
+		},
+		{
+			token:   jwt(`{"access":[{"type":"repository","name":"other/repo","actions":["push"]}]}`),
+			actions: nil,
+			ok:      true,
+		},
+		{
+			token: "opaque-token",
+			ok:    false,
+		},
+	} {
+		actions, ok := grantedActions(tc.token, "user/push-check")
+		if ok != tc.ok || !reflect.DeepEqual(actions, tc.actions) {
+			t.Errorf("grantedActions(%q) = %v, %v; expected %v, %v", tc.token, actions, ok, tc.actions, tc.ok)
+		}
+	}
+}
@@ -5,7 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
-	"sync"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/distribution/distribution/v3"
@@ -18,26 +19,192 @@ import (
 	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/tracing"
 	"github.com/distribution/reference"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var repositoryTTL = 24 * 7 * time.Hour
 
-// proxyingRegistry fetches content from a remote registry and caches it locally
+// tracer traces the fetches from the remote registries.
+var tracer = otel.Tracer("github.com/distribution/distribution/v3/registry/proxy")
+
+// startRemoteSpan starts a span named name around a fetch of ref, a digest or
+// a tag, of the repository from the remote.
+func startRemoteSpan(ctx context.Context, name string, repository reference.Named, ref string) (context.Context, trace.Span) {
+	ctx, span := tracing.StartSpan(ctx, tracer, name, trace.WithSpanKind(trace.SpanKindClient))
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String(tracing.AttributePrefix+"repository", repository.Name()),
+			attribute.String(tracing.AttributePrefix+"reference", ref),
+		)
+	}
+	return ctx, span
+}
+
+// endRemoteSpan ends span, recording err if the fetch failed.
+func endRemoteSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// proxyingRegistry fetches content from remote registries and caches it locally
 type proxyingRegistry struct {
-	embedded       distribution.Namespace // provides local registry functionality
-	scheduler      *scheduler.TTLExpirationScheduler
-	ttl            *time.Duration
-	remoteURL      url.URL
-	authChallenger authChallenger
+	embedded   distribution.Namespace // provides local registry functionality
+	scheduler  *scheduler.TTLExpirationScheduler
+	ttl        *time.Duration
+	remotes    []*remote // longest namespace first, the default remote last
+	maxRetries int
+	maxBackoff time.Duration
+	prefetcher *prefetcher
+	allowPush  bool
+	failover   string
+
+	// tokenRefreshBefore is how long before their expiry bearer tokens for
+	// the remotes are refreshed.
+	tokenRefreshBefore time.Duration
+}
+
+// remote is a registry content is pulled through from.
+type remote struct {
+	// namespace is the repository name prefix served by the remote. It is
+	// empty for the default remote, which serves all other repositories.
+	namespace      string
+	url            url.URL
+	username       string
+	authChallenger *remoteAuthChallenger
+	// transport carries all the requests to the remote, including those
+	// for tokens.
+	transport http.RoundTripper
+}
+
+func newRemote(ctx context.Context, namespace, remoteURL, username, password string, tc configuration.HTTPTransport, challenges *challengeCache) (*remote, error) {
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+
+	rt, err := transport.NewHTTPTransport(transport.HTTPOptions{
+		MaxIdleConns:        tc.MaxIdleConns,
+		MaxIdleConnsPerHost: tc.MaxIdleConnsPerHost,
+		IdleConnTimeout:     tc.IdleConnTimeout,
+		TLSHandshakeTimeout: tc.TLSHandshakeTimeout,
+		Proxy:               tc.HTTPProxy,
+		HTTP2:               transport.HTTP2Mode(tc.HTTP2),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("proxy remote %s: %w", remoteURL, err)
+	}
+
+	c := &remoteAuthChallenger{
+		remoteURL:  *u,
+		challenges: challenges.get(*u),
+		cs:         newCredentials(username, password, *u),
+		transport:  rt,
+	}
+	// The challenges are established at startup, discovering the token
+	// realms trusted with the credentials.
+	if err := c.tryEstablishChallenges(ctx); err != nil {
+		return nil, err
+	}
+
+	return &remote{
+		namespace:      namespace,
+		url:            *u,
+		username:       username,
+		authChallenger: c,
+		transport:      rt,
+	}, nil
+}
+
+// Option configures the registry created by NewRegistryPullThroughCache.
+type Option func(*options)
+
+type options struct {
+	isLeader func() bool
+}
+
+// WithLeader shares the expiry of the cached content with the other registry
+// instances using the same storage, isLeader reporting whether the instance
+// is their leader, which alone expires and evicts cached content.
+func WithLeader(isLeader func() bool) Option {
+	return func(o *options) {
+		o.isLeader = isLeader
+	}
 }
 
 // NewRegistryPullThroughCache creates a registry acting as a pull through cache
-func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy) (distribution.Namespace, error) {
-	remoteURL, err := url.Parse(config.RemoteURL)
+func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy, opts ...Option) (distribution.Namespace, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	failover := config.Failover
+	switch failover {
+	case "":
+		failover = failoverRemote
+	case failoverRemote, failoverError:
+	default:
+		return nil, fmt.Errorf("proxy: invalid failover %q, must be %q or %q", config.Failover, failoverRemote, failoverError)
+	}
+
+	// The remotes on the same host share its challenges.
+	challenges := newChallengeCache(config.ChallengeTTL)
+
+	var remotes []*remote
+	namespaces := make(map[string]struct{})
+	for _, rc := range config.Remotes {
+		namespace := strings.Trim(rc.Namespace, "/")
+		if namespace == "" {
+			return nil, fmt.Errorf("proxy remote %s: namespace is required", rc.RemoteURL)
+		}
+		if rc.RemoteURL == "" {
+			return nil, fmt.Errorf("proxy remote %s: remoteurl is required", namespace)
+		}
+		if _, ok := namespaces[namespace]; ok {
+			return nil, fmt.Errorf("proxy remote %s: duplicate namespace", namespace)
+		}
+		namespaces[namespace] = struct{}{}
+
+		tc := config.Transport
+		if rc.HTTPProxy != "" {
+			tc.HTTPProxy = rc.HTTPProxy
+		}
+		r, err := newRemote(ctx, namespace, rc.RemoteURL, rc.Username, rc.Password, tc, challenges)
+		if err != nil {
+			return nil, err
+		}
+		remotes = append(remotes, r)
+	}
+
+	// More specific namespaces take precedence.
+	sort.SliceStable(remotes, func(i, j int) bool {
+		return len(remotes[i].namespace) > len(remotes[j].namespace)
+	})
+
+	defaultRemote, err := newRemote(ctx, "", config.RemoteURL, config.Username, config.Password, config.Transport, challenges)
 	if err != nil {
 		return nil, err
 	}
+	remotes = append(remotes, defaultRemote)
+
+	if config.AllowPush {
+		for _, r := range remotes {
+			if r.username == "" {
+				return nil, fmt.Errorf("proxy remote %s: pushes require credentials", r.url.String())
+			}
+			if err := checkPushAccess(ctx, r, r.username+"/"+pushCheckRepository); err != nil {
+				return nil, err
+			}
+		}
+	}
 
 	v := storage.NewVacuum(ctx, driver)
 
@@ -55,6 +222,13 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 
 	if ttl != nil {
 		s = scheduler.New(ctx, driver, "/scheduler-state.json")
+		if config.CheckpointInterval > 0 {
+			s.SetCheckpointInterval(config.CheckpointInterval)
+		}
+		s.SetSizeLimit(config.CacheSizeLimit)
+		if o.isLeader != nil {
+			s.SetLeader(dcontext.GetStringValue(ctx, "instance.id"), o.isLeader)
+		}
 		s.OnBlobExpire(func(ref reference.Reference) error {
 			var r reference.Canonical
 			var ok bool
@@ -106,30 +280,55 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 			return nil
 		})
 
+		s.OnRepositoryEvict(func(ref reference.Reference) error {
+			r, ok := ref.(reference.Named)
+			if !ok {
+				return fmt.Errorf("unexpected reference type : %T", ref)
+			}
+
+			// The evicted entries removed the repository's cached content;
+			// remove what remains of the repository as well.
+			return v.RemoveRepository(r.Name())
+		})
+
 		err = s.Start()
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	cs, err := configureAuth(config.Username, config.Password, config.RemoteURL)
-	if err != nil {
-		return nil, err
+	var p *prefetcher
+	if config.PrefetchLayers {
+		p = newPrefetcher(ctx, prefetchWorkers)
 	}
 
 	return &proxyingRegistry{
-		embedded:  registry,
-		scheduler: s,
-		ttl:       ttl,
-		remoteURL: *remoteURL,
-		authChallenger: &remoteAuthChallenger{
-			remoteURL: *remoteURL,
-			cm:        challenge.NewSimpleManager(),
-			cs:        cs,
-		},
+		embedded:   registry,
+		scheduler:  s,
+		ttl:        ttl,
+		remotes:    remotes,
+		maxRetries: config.MaxRetries,
+		maxBackoff: config.MaxBackoff,
+		prefetcher: p,
+		allowPush:  config.AllowPush,
+		failover:   failover,
+
+		tokenRefreshBefore: config.TokenRefreshBefore,
 	}, nil
 }
 
+// Close stops the background prefetch of blobs and the cache expiry
+// scheduler, writing its state to storage.
+func (pr *proxyingRegistry) Close() error {
+	if pr.prefetcher != nil {
+		pr.prefetcher.close()
+	}
+	if pr.scheduler != nil {
+		pr.scheduler.Stop()
+	}
+	return nil
+}
+
 func (pr *proxyingRegistry) Scope() distribution.Scope {
 	return distribution.GlobalScope
 }
@@ -138,24 +337,71 @@ func (pr *proxyingRegistry) Repositories(ctx context.Context, repos []string, la
 	return pr.embedded.Repositories(ctx, repos, last)
 }
 
+// remoteFor returns the remote serving the named repository, along with the
+// name of the repository on that remote.
+func (pr *proxyingRegistry) remoteFor(name reference.Named) (*remote, reference.Named, error) {
+	for _, r := range pr.remotes {
+		if r.namespace == "" {
+			return r, name, nil
+		}
+		if rest, ok := strings.CutPrefix(name.Name(), r.namespace+"/"); ok {
+			remoteName, err := reference.WithName(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			return r, remoteName, nil
+		}
+	}
+	return nil, nil, distribution.ErrRepositoryUnknown{Name: name.Name()}
+}
+
 func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
-	c := pr.authChallenger
+	r, remoteName, err := pr.remoteFor(name)
+	if err != nil {
+		return nil, err
+	}
+	c := r.authChallenger
+
+	actions := []string{"pull"}
+	if pr.allowPush {
+		actions = append(actions, "push")
+	}
+
+	// The trace context of the requests pulling through is forwarded to
+	// the remote.
+	rt := tracing.NewTransport(r.transport)
 
 	tkopts := auth.TokenHandlerOptions{
-		Transport:   http.DefaultTransport,
+		Transport:   rt,
 		Credentials: c.credentialStore(),
 		Scopes: []auth.Scope{
 			auth.RepositoryScope{
-				Repository: name.Name(),
-				Actions:    []string{"pull"},
+				Repository: remoteName.Name(),
+				Actions:    actions,
 			},
 		},
-		Logger: dcontext.GetLogger(ctx),
+		Logger:        dcontext.GetLogger(ctx),
+		RefreshBefore: pr.tokenRefreshBefore,
+		OnTokenFetch:  proxyMetrics.TokenFetch,
+	}
+	th := auth.NewTokenHandlerWithOptions(tkopts)
+
+	handlers := []auth.AuthenticationHandler{th}
+	if c.basicAuth() {
+		handlers = append(handlers, auth.NewBasicHandler(c.credentialStore()))
+	}
+
+	base := rt
+	if pr.maxRetries > 0 {
+		base = transport.NewRetryTransport(base, pr.maxRetries, pr.maxBackoff)
 	}
+	base = c.observe(base)
 
-	tr := transport.NewTransport(http.DefaultTransport,
-		auth.NewAuthorizer(c.challengeManager(),
-			auth.NewTokenHandlerWithOptions(tkopts)))
+	// A token rejected before its expiry is discarded and the request
+	// retried once with a fresh one.
+	tr := auth.NewUnauthorizedRetryTransport(
+		transport.NewTransport(base, auth.NewAuthorizer(c.challengeManager(), handlers...)),
+		th)
 
 	localRepo, err := pr.embedded.Repository(ctx, name)
 	if err != nil {
@@ -166,7 +412,7 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 		return nil, err
 	}
 
-	remoteRepo, err := client.NewRepository(name, pr.remoteURL.String(), tr)
+	remoteRepo, err := client.NewRepository(remoteName, r.url.String(), tr)
 	if err != nil {
 		return nil, err
 	}
@@ -176,29 +422,43 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 		return nil, err
 	}
 
+	blobStore := &proxyBlobStore{
+		localStore:     localRepo.Blobs(ctx),
+		remoteStore:    remoteRepo.Blobs(ctx),
+		scheduler:      pr.scheduler,
+		ttl:            pr.ttl,
+		repositoryName: name,
+		authChallenger: c,
+		remote:         r.url.String(),
+		allowPush:      pr.allowPush,
+	}
+
+	manifestStore := &proxyManifestStore{
+		repositoryName:  name,
+		localManifests:  localManifests, // Options?
+		remoteManifests: remoteManifests,
+		ctx:             ctx,
+		scheduler:       pr.scheduler,
+		ttl:             pr.ttl,
+		authChallenger:  c,
+		remote:          r.url.String(),
+		prefetcher:      pr.prefetcher,
+		blobStore:       blobStore,
+		allowPush:       pr.allowPush,
+		failover:        pr.failover,
+	}
+
 	return &proxiedRepository{
-		blobStore: &proxyBlobStore{
-			localStore:     localRepo.Blobs(ctx),
-			remoteStore:    remoteRepo.Blobs(ctx),
-			scheduler:      pr.scheduler,
-			ttl:            pr.ttl,
-			repositoryName: name,
-			authChallenger: pr.authChallenger,
-		},
-		manifests: &proxyManifestStore{
-			repositoryName:  name,
-			localManifests:  localManifests, // Options?
-			remoteManifests: remoteManifests,
-			ctx:             ctx,
-			scheduler:       pr.scheduler,
-			ttl:             pr.ttl,
-			authChallenger:  pr.authChallenger,
-		},
-		name: name,
+		blobStore: blobStore,
+		manifests: manifestStore,
+		name:      name,
 		tags: &proxyTagService{
+			repositoryName: name,
 			localTags:      localRepo.Tags(ctx),
 			remoteTags:     remoteRepo.Tags(ctx),
-			authChallenger: pr.authChallenger,
+			authChallenger: c,
+			manifests:      manifestStore,
+			allowPush:      pr.allowPush,
 		},
 	}, nil
 }
@@ -219,42 +479,47 @@ type authChallenger interface {
 }
 
 type remoteAuthChallenger struct {
-	remoteURL url.URL
-	sync.Mutex
-	cm challenge.Manager
-	cs auth.CredentialStore
+	remoteURL  url.URL
+	challenges *hostChallenges
+	cs         *credentials
+	transport  http.RoundTripper
 }
 
 func (r *remoteAuthChallenger) credentialStore() auth.CredentialStore {
 	return r.cs
 }
 
+// challengeManager returns the challenges of the remote, narrowed to a single
+// scheme when the remote offers both bearer and basic authentication.
 func (r *remoteAuthChallenger) challengeManager() challenge.Manager {
-	return r.cm
+	return preferredChallenges{Manager: r.challenges.cm, basic: r.basicAuth()}
 }
 
-// tryEstablishChallenges will attempt to get a challenge type for the upstream if none currently exist
-func (r *remoteAuthChallenger) tryEstablishChallenges(ctx context.Context) error {
-	r.Lock()
-	defer r.Unlock()
+// basicAuth reports whether the requests to the remote may be authenticated
+// with basic authentication, which requires credentials.
+func (r *remoteAuthChallenger) basicAuth() bool {
+	return r.cs.configured()
+}
+
+// observe wraps rt, the transport of the requests to the remote, so that
+// unexpected challenges of the remote are refreshed by the next request.
+func (r *remoteAuthChallenger) observe(rt http.RoundTripper) http.RoundTripper {
+	return &challengeTransport{base: rt, challenges: r.challenges}
+}
 
-	remoteURL := r.remoteURL
-	remoteURL.Path = "/v2/"
-	challenges, err := r.cm.GetChallenges(remoteURL)
+// tryEstablishChallenges will attempt to get a challenge type for the upstream
+// unless the challenges of its host are cached
+func (r *remoteAuthChallenger) tryEstablishChallenges(ctx context.Context) error {
+	pinged, err := r.challenges.establish(r.transport)
 	if err != nil {
 		return err
 	}
 
-	if len(challenges) > 0 {
-		return nil
-	}
-
-	// establish challenge type with upstream
-	if err := ping(r.cm, remoteURL.String(), challengeHeader); err != nil {
-		return err
+	challenges := r.challenges.challenges()
+	r.cs.addRealms(ctx, challenges)
+	if pinged {
+		dcontext.GetLogger(ctx).Infof("Challenge established with upstream : %s %v", r.remoteURL.String(), challenges)
 	}
-
-	dcontext.GetLogger(ctx).Infof("Challenge established with upstream : %s %s", remoteURL, r.cm)
 	return nil
 }
 
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+)
+
+func TestRemoteFor(t *testing.T) {
+	pr := &proxyingRegistry{
+		remotes: []*remote{
+			{namespace: "quay.io/team"},
+			{namespace: "quay.io"},
+			{namespace: ""},
+		},
+	}
+
+	for _, tc := range []struct {
+		name       string
+		namespace  string
+		remoteName string
+	}{
+		{name: "library/ubuntu", namespace: "", remoteName: "library/ubuntu"},
+		{name: "quay.io/foo/bar", namespace: "quay.io", remoteName: "foo/bar"},
+		{name: "quay.io/team/app", namespace: "quay.io/team", remoteName: "app"},
+		{name: "quay.iox/foo", namespace: "", remoteName: "quay.iox/foo"},
+	} {
+		name, err := reference.WithName(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, remoteName, err := pr.remoteFor(name)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if r.namespace != tc.namespace {
+			t.Errorf("%s: expected namespace %q, got %q", tc.name, tc.namespace, r.namespace)
+		}
+		if remoteName.Name() != tc.remoteName {
+			t.Errorf("%s: expected remote name %q, got %q", tc.name, tc.remoteName, remoteName.Name())
+		}
+	}
+
+	// Without a default remote unmatched repositories are unknown.
+	pr.remotes = pr.remotes[:2]
+	name, _ := reference.WithName("library/ubuntu")
+	if _, _, err := pr.remoteFor(name); !errors.As(err, &distribution.ErrRepositoryUnknown{}) {
+		t.Errorf("Expected ErrRepositoryUnknown, got %v", err)
+	}
+}
+
+func TestNewRegistryPullThroughCacheTransport(t *testing.T) {
+	ctx := context.Background()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	var proxied []string
+	httpProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+	}))
+	defer httpProxy.Close()
+
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCache := func(config configuration.Proxy) *proxyingRegistry {
+		ttl := time.Duration(0)
+		config.TTL = &ttl
+		pr, err := NewRegistryPullThroughCache(ctx, registry, inmemory.New(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pr.(*proxyingRegistry)
+	}
+
+	// Without transport configuration the default transport is shared.
+	pr := newCache(configuration.Proxy{RemoteURL: upstream.URL})
+	if rt := pr.remotes[0].transport; rt != http.DefaultTransport {
+		t.Fatalf("expected http.DefaultTransport, got %#v", rt)
+	}
+
+	pr = newCache(configuration.Proxy{
+		RemoteURL: upstream.URL,
+		Transport: configuration.HTTPTransport{
+			MaxIdleConns:        200,
+			MaxIdleConnsPerHost: 50,
+			IdleConnTimeout:     time.Minute,
+			TLSHandshakeTimeout: 3 * time.Second,
+			HTTP2:               "disable",
+		},
+		Remotes: []configuration.ProxyRemote{
+			{Namespace: "quay.io", RemoteURL: "http://quay.example.com", HTTPProxy: httpProxy.URL},
+		},
+	})
+	if len(pr.remotes) != 2 {
+		t.Fatalf("unexpected number of remotes: %d", len(pr.remotes))
+	}
+	for _, r := range pr.remotes {
+		tr, ok := r.transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("remote %q: unexpected transport type %T", r.namespace, r.transport)
+		}
+		if tr.MaxIdleConns != 200 || tr.MaxIdleConnsPerHost != 50 || tr.IdleConnTimeout != time.Minute || tr.TLSHandshakeTimeout != 3*time.Second {
+			t.Errorf("remote %q: unexpected transport settings %d, %d, %v, %v", r.namespace, tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout, tr.TLSHandshakeTimeout)
+		}
+		if tr.ForceAttemptHTTP2 || tr.TLSNextProto == nil {
+			t.Errorf("remote %q: expected HTTP/2 to be disabled", r.namespace)
+		}
+		if r.authChallenger.transport != r.transport {
+			t.Errorf("remote %q: expected challenges to be established through the remote transport", r.namespace)
+		}
+	}
+
+	// Only the quay.io remote is reached through the HTTP proxy.
+	if expected := []string{"http://quay.example.com/v2/"}; !reflect.DeepEqual(proxied, expected) {
+		t.Fatalf("unexpected proxied requests: %v != %v", proxied, expected)
+	}
+	// The challenges are cached, until they expire.
+	for _, r := range pr.remotes {
+		if err := r.authChallenger.tryEstablishChallenges(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(proxied) != 1 {
+		t.Fatalf("unexpected proxied requests: %v", proxied)
+	}
+	for _, r := range pr.remotes {
+		r.authChallenger.challenges.expires = time.Time{}
+		if err := r.authChallenger.tryEstablishChallenges(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(proxied) != 2 || proxied[1] != "http://quay.example.com/v2/" {
+		t.Fatalf("unexpected proxied requests: %v", proxied)
+	}
+}
@@ -4,13 +4,22 @@ import (
 	"context"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
 )
 
 // proxyTagService supports local and remote lookup of tags.
 type proxyTagService struct {
+	repositoryName reference.Named
 	localTags      distribution.TagService
 	remoteTags     distribution.TagService
 	authChallenger authChallenger
+
+	// manifests, if set, is used to revalidate tags that are already cached
+	// locally with a conditional request.
+	manifests *proxyManifestStore
+	// allowPush enables tagging. Pushed manifests are tagged on the remote
+	// as they are written, so tags are only recorded locally.
+	allowPush bool
 }
 
 var _ distribution.TagService = proxyTagService{}
@@ -21,7 +30,7 @@ var _ distribution.TagService = proxyTagService{}
 func (pt proxyTagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
 	err := pt.authChallenger.tryEstablishChallenges(ctx)
 	if err == nil {
-		desc, err := pt.remoteTags.Get(ctx, tag)
+		desc, err := pt.remoteGet(ctx, tag)
 		if err == nil {
 			err := pt.localTags.Tag(ctx, tag, desc)
 			if err != nil {
@@ -38,8 +47,26 @@ func (pt proxyTagService) Get(ctx context.Context, tag string) (distribution.Des
 	return desc, nil
 }
 
+// remoteGet resolves tag against the remote. A tag which is already cached
+// locally is revalidated through the manifest store, so that an unchanged tag
+// costs the remote no manifest body.
+func (pt proxyTagService) remoteGet(ctx context.Context, tag string) (desc distribution.Descriptor, err error) {
+	ctx, span := startRemoteSpan(ctx, "proxy.ResolveTag", pt.repositoryName, tag)
+	defer func() { endRemoteSpan(span, err) }()
+
+	if pt.manifests != nil {
+		if cached, err := pt.localTags.Get(ctx, tag); err == nil {
+			return pt.manifests.revalidateTag(ctx, tag, cached)
+		}
+	}
+	return pt.remoteTags.Get(ctx, tag)
+}
+
 func (pt proxyTagService) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
-	return distribution.ErrUnsupported
+	if !pt.allowPush {
+		return distribution.ErrUnsupported
+	}
+	return pt.localTags.Tag(ctx, tag, desc)
 }
 
 func (pt proxyTagService) Untag(ctx context.Context, tag string) error {
@@ -3,7 +3,11 @@ package scheduler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"path"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,19 +32,38 @@ type schedulerEntry struct {
 	Expiry    time.Time `json:"ExpiryData"`
 	EntryType int       `json:"EntryType"`
 
-	timer *time.Timer
+	// Remote is the URL of the remote the entry was fetched from. It is
+	// empty for entries written before remotes were recorded.
+	Remote string `json:"Remote,omitempty"`
+
+	// Size is the size in bytes of a cached blob.
+	Size int64 `json:"Size,omitempty"`
+
+	// Accessed is when the entry's repository was last served from the
+	// cache. It orders repositories for size based eviction.
+	Accessed time.Time `json:"Accessed,omitempty"`
+
+	// expires is set if the instance runs the expiry of the entry, rather
+	// than dropping it and leaving its expiry to the leader. It is decided
+	// when the entry is scheduled, so that a change of leader while the
+	// entry is scheduled does not expire it twice.
+	expires bool
+	timer   *time.Timer
 }
 
 // New returns a new instance of the scheduler
 func New(ctx context.Context, driver driver.StorageDriver, path string) *TTLExpirationScheduler {
 	return &TTLExpirationScheduler{
 		entries:         make(map[string]*schedulerEntry),
+		repositories:    make(map[string]map[string]*schedulerEntry),
+		merged:          make(map[string]time.Time),
 		driver:          driver,
 		pathToStateFile: path,
 		ctx:             ctx,
 		stopped:         true,
 		doneChan:        make(chan struct{}),
 		saveTimer:       time.NewTicker(indexSaveFrequency),
+		now:             time.Now,
 	}
 }
 
@@ -50,6 +73,10 @@ type TTLExpirationScheduler struct {
 	sync.Mutex
 
 	entries map[string]*schedulerEntry
+	// repositories indexes the entries by the name of their repository, and
+	// size is the total size of their blobs.
+	repositories map[string]map[string]*schedulerEntry
+	size         int64
 
 	driver          driver.StorageDriver
 	ctx             context.Context
@@ -57,15 +84,40 @@ type TTLExpirationScheduler struct {
 
 	stopped bool
 
-	onBlobExpire     expiryFunc
-	onManifestExpire expiryFunc
+	onBlobExpire      expiryFunc
+	onManifestExpire  expiryFunc
+	onRepositoryEvict expiryFunc
+
+	// sizeLimit is the total size of cached blobs above which repositories
+	// are evicted before their TTL expires. Zero disables the limit.
+	sizeLimit int64
+
+	// isLeader reports whether the instance leads the registry instances
+	// sharing the cache, see SetLeader, and leading whether it did at the
+	// last checkpoint. instance names the instance among them.
+	isLeader func() bool
+	leading  bool
+	instance string
+	// merged holds the expiry of the entries merged from the state of the
+	// other instances, so that they are not scheduled again once expired
+	// or evicted.
+	merged map[string]time.Time
 
 	indexDirty bool
 	saveTimer  *time.Ticker
 	doneChan   chan struct{}
+
+	// evictions tracks the size based evictions running in the background.
+	evictions sync.WaitGroup
+
+	// now returns the time expiries and accesses are computed from. The
+	// timers measure the remaining time from it, and run on the wall clock.
+	now func() time.Time
 }
 
-// OnBlobExpire is called when a scheduled blob's TTL expires
+// OnBlobExpire is called when a scheduled blob's TTL expires. The expiry
+// functions are called without the scheduler lock held, and may run
+// concurrently.
 func (ttles *TTLExpirationScheduler) OnBlobExpire(f expiryFunc) {
 	ttles.Lock()
 	defer ttles.Unlock()
@@ -81,8 +133,70 @@ func (ttles *TTLExpirationScheduler) OnManifestExpire(f expiryFunc) {
 	ttles.onManifestExpire = f
 }
 
+// OnRepositoryEvict is called once all entries of a repository have been
+// evicted to keep the cache within its size limit.
+func (ttles *TTLExpirationScheduler) OnRepositoryEvict(f expiryFunc) {
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	ttles.onRepositoryEvict = f
+}
+
+// SetCheckpointInterval sets how often the scheduler writes its entries
+// through the storage driver. Entries are also written on Stop.
+func (ttles *TTLExpirationScheduler) SetCheckpointInterval(d time.Duration) {
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	ttles.saveTimer.Reset(d)
+}
+
+// SetSizeLimit sets the total size in bytes of cached blobs above which
+// whole repositories are evicted, least recently used first, before their
+// TTL expires. A limit of zero disables size based eviction.
+func (ttles *TTLExpirationScheduler) SetSizeLimit(limit int64) {
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	ttles.sizeLimit = limit
+}
+
+// SetLeader makes the scheduler share the expiry of the cached content with
+// the other registry instances using the same storage, isLeader reporting
+// whether the instance, named instance among them, is their leader. Only the
+// leader expires entries and evicts repositories, taking over the state of
+// the previous leader once elected. The other instances write the entries
+// they schedule to a state file of their own, which the leader merges into
+// its schedule at each checkpoint, and drop them once expired. It must be
+// called before Start.
+func (ttles *TTLExpirationScheduler) SetLeader(instance string, isLeader func() bool) {
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	ttles.instance = instance
+	ttles.isLeader = isLeader
+}
+
+// Touch records that the named repository was served from the cache.
+func (ttles *TTLExpirationScheduler) Touch(name reference.Named) {
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	now := ttles.now()
+	for _, entry := range ttles.repositories[name.Name()] {
+		entry.Accessed = now
+		ttles.indexDirty = true
+	}
+}
+
 // AddBlob schedules a blob cleanup after ttl expires
 func (ttles *TTLExpirationScheduler) AddBlob(blobRef reference.Canonical, ttl time.Duration) error {
+	return ttles.AddBlobFromRemote(blobRef, "", 0, ttl)
+}
+
+// AddBlobFromRemote schedules a cleanup, after ttl expires, of a blob of
+// the given size that was fetched from remote.
+func (ttles *TTLExpirationScheduler) AddBlobFromRemote(blobRef reference.Canonical, remote string, size int64, ttl time.Duration) error {
 	ttles.Lock()
 	defer ttles.Unlock()
 
@@ -90,12 +204,19 @@ func (ttles *TTLExpirationScheduler) AddBlob(blobRef reference.Canonical, ttl ti
 		return fmt.Errorf("scheduler not started")
 	}
 
-	ttles.add(blobRef, ttl, entryTypeBlob)
+	ttles.add(blobRef, remote, size, ttl, entryTypeBlob)
+	ttles.enforceSizeLimit()
 	return nil
 }
 
 // AddManifest schedules a manifest cleanup after ttl expires
 func (ttles *TTLExpirationScheduler) AddManifest(manifestRef reference.Canonical, ttl time.Duration) error {
+	return ttles.AddManifestFromRemote(manifestRef, "", ttl)
+}
+
+// AddManifestFromRemote schedules a cleanup, after ttl expires, of a
+// manifest that was fetched from remote.
+func (ttles *TTLExpirationScheduler) AddManifestFromRemote(manifestRef reference.Canonical, remote string, ttl time.Duration) error {
 	ttles.Lock()
 	defer ttles.Unlock()
 
@@ -103,7 +224,7 @@ func (ttles *TTLExpirationScheduler) AddManifest(manifestRef reference.Canonical
 		return fmt.Errorf("scheduler not started")
 	}
 
-	ttles.add(manifestRef, ttl, entryTypeManifest)
+	ttles.add(manifestRef, remote, 0, ttl, entryTypeManifest)
 	return nil
 }
 
@@ -112,9 +233,12 @@ func (ttles *TTLExpirationScheduler) Start() error {
 	ttles.Lock()
 	defer ttles.Unlock()
 
-	err := ttles.readState()
-	if err != nil {
-		return err
+	if ttles.leader() {
+		err := ttles.readState()
+		if err != nil {
+			return err
+		}
+		ttles.leading = true
 	}
 
 	if !ttles.stopped {
@@ -124,10 +248,13 @@ func (ttles *TTLExpirationScheduler) Start() error {
 	dcontext.GetLogger(ttles.ctx).Infof("Starting cached object TTL expiration scheduler...")
 	ttles.stopped = false
 
-	// Start timer for each deserialized entry
+	// Start timer for each deserialized entry. Entries that expired while
+	// the scheduler was stopped fire immediately.
 	for _, entry := range ttles.entries {
-		entry.timer = ttles.startTimer(entry, time.Until(entry.Expiry))
+		entry.expires = ttles.leading
+		entry.timer = ttles.startTimer(entry, entry.Expiry.Sub(ttles.now()))
 	}
+	ttles.enforceSizeLimit()
 
 	// Start a ticker to periodically save the entries index
 
@@ -136,17 +263,7 @@ func (ttles *TTLExpirationScheduler) Start() error {
 			select {
 			case <-ttles.saveTimer.C:
 				ttles.Lock()
-				if !ttles.indexDirty {
-					ttles.Unlock()
-					continue
-				}
-
-				err := ttles.writeState()
-				if err != nil {
-					dcontext.GetLogger(ttles.ctx).Errorf("Error writing scheduler state: %s", err)
-				} else {
-					ttles.indexDirty = false
-				}
+				ttles.checkpoint()
 				ttles.Unlock()
 
 			case <-ttles.doneChan:
@@ -158,55 +275,313 @@ func (ttles *TTLExpirationScheduler) Start() error {
 	return nil
 }
 
-func (ttles *TTLExpirationScheduler) add(r reference.Reference, ttl time.Duration, eType int) {
+// checkpoint writes the entries if they changed. The leader first takes over
+// the entries of the other instances. The caller must hold the lock.
+func (ttles *TTLExpirationScheduler) checkpoint() {
+	if ttles.isLeader != nil {
+		if !ttles.leader() {
+			if ttles.leading {
+				// The new leader takes over the entries.
+				for _, entry := range ttles.entries {
+					entry.expires = false
+				}
+			}
+			ttles.leading = false
+		} else {
+			if !ttles.leading {
+				dcontext.GetLogger(ttles.ctx).Infof("Taking over the scheduler state as the leader")
+				if err := ttles.merge(ttles.pathToStateFile, true); err != nil {
+					dcontext.GetLogger(ttles.ctx).Errorf("Error merging scheduler state: %s", err)
+				}
+				// The entries scheduled by the instance which are not in
+				// the state of the previous leader were not merged by it
+				// yet, unless they expired already.
+				now := ttles.now()
+				for _, entry := range ttles.entries {
+					if !entry.expires && entry.Expiry.After(now) {
+						entry.expires = true
+					}
+				}
+				ttles.leading = true
+			}
+			ttles.mergeInstances()
+			ttles.enforceSizeLimit()
+		}
+	}
+
+	if !ttles.indexDirty {
+		return
+	}
+	if err := ttles.writeState(); err != nil {
+		dcontext.GetLogger(ttles.ctx).Errorf("Error writing scheduler state: %s", err)
+	} else {
+		ttles.indexDirty = false
+	}
+}
+
+// leader reports whether the instance expires the entries.
+func (ttles *TTLExpirationScheduler) leader() bool {
+	return ttles.isLeader == nil || ttles.isLeader()
+}
+
+// mergeInstances merges the state files of the other instances, and removes
+// those holding no entry to expire anymore. The caller must hold the lock.
+func (ttles *TTLExpirationScheduler) mergeInstances() {
+	files, err := ttles.driver.List(ttles.ctx, ttles.instancesPath())
+	if errors.As(err, &driver.PathNotFoundError{}) {
+		return
+	} else if err != nil {
+		dcontext.GetLogger(ttles.ctx).Errorf("Error listing scheduler instance states: %s", err)
+		return
+	}
+
+	now := ttles.now()
+	for key, expiry := range ttles.merged {
+		if expiry.Before(now) {
+			delete(ttles.merged, key)
+		}
+	}
+
+	for _, file := range files {
+		if file == ttles.instanceStatePath() {
+			continue
+		}
+		if err := ttles.merge(file, false); errors.Is(err, errNothingToMerge) {
+			if err := ttles.driver.Delete(ttles.ctx, file); err != nil && !errors.As(err, &driver.PathNotFoundError{}) {
+				dcontext.GetLogger(ttles.ctx).Errorf("Error removing scheduler instance state %s: %s", file, err)
+			}
+		} else if err != nil {
+			dcontext.GetLogger(ttles.ctx).Errorf("Error merging scheduler instance state %s: %s", file, err)
+		}
+	}
+}
+
+// errNothingToMerge is returned by merge for a state file holding no entry
+// to expire.
+var errNothingToMerge = errors.New("no entry to merge")
+
+// merge schedules the entries of the state file at p which are unknown, or
+// expire later than known. Expired entries are only merged if expired is
+// set. The caller must hold the lock.
+func (ttles *TTLExpirationScheduler) merge(p string, expired bool) error {
+	entries, err := ttles.readEntries(p)
+	if err != nil {
+		return err
+	}
+
+	now := ttles.now()
+	live := false
+	for key, entry := range entries {
+		if !expired && !entry.Expiry.After(now) {
+			continue
+		}
+		live = true
+		if known, ok := ttles.entries[key]; ok && known.expires && !entry.Expiry.After(known.Expiry) {
+			continue
+		}
+		if merged, ok := ttles.merged[key]; ok && merged.Equal(entry.Expiry) {
+			continue
+		}
+
+		if known, ok := ttles.entries[key]; ok && known.timer != nil {
+			known.timer.Stop()
+		}
+		entry.expires = true
+		ttles.setEntry(entry)
+		ttles.merged[key] = entry.Expiry
+		entry.timer = ttles.startTimer(entry, entry.Expiry.Sub(ttles.now()))
+	}
+	if !live {
+		return errNothingToMerge
+	}
+	return nil
+}
+
+func (ttles *TTLExpirationScheduler) add(r reference.Reference, remote string, size int64, ttl time.Duration, eType int) *schedulerEntry {
+	now := ttles.now()
 	entry := &schedulerEntry{
 		Key:       r.String(),
-		Expiry:    time.Now().Add(ttl),
+		Expiry:    now.Add(ttl),
 		EntryType: eType,
+		Remote:    remote,
+		Size:      size,
+		Accessed:  now,
+		expires:   ttles.leading,
 	}
-	dcontext.GetLogger(ttles.ctx).Infof("Adding new scheduler entry for %s with ttl=%s", entry.Key, time.Until(entry.Expiry))
+	dcontext.GetLogger(ttles.ctx).Infof("Adding new scheduler entry for %s with ttl=%s", entry.Key, ttl)
 	if oldEntry, present := ttles.entries[entry.Key]; present && oldEntry.timer != nil {
 		oldEntry.timer.Stop()
 	}
-	ttles.entries[entry.Key] = entry
+	ttles.setEntry(entry)
 	entry.timer = ttles.startTimer(entry, ttl)
+	return entry
+}
+
+// setEntry records entry, replacing the entry of the same key if any. The
+// caller must hold the lock.
+func (ttles *TTLExpirationScheduler) setEntry(entry *schedulerEntry) {
+	if old, ok := ttles.entries[entry.Key]; ok {
+		ttles.deleteEntry(old)
+	}
+	ttles.entries[entry.Key] = entry
+	name := repositoryOf(entry)
+	if ttles.repositories[name] == nil {
+		ttles.repositories[name] = make(map[string]*schedulerEntry)
+	}
+	ttles.repositories[name][entry.Key] = entry
+	ttles.size += entry.Size
+	ttles.indexDirty = true
+}
+
+// deleteEntry removes entry from the schedule. The caller must hold the
+// lock.
+func (ttles *TTLExpirationScheduler) deleteEntry(entry *schedulerEntry) {
+	delete(ttles.entries, entry.Key)
+	name := repositoryOf(entry)
+	delete(ttles.repositories[name], entry.Key)
+	if len(ttles.repositories[name]) == 0 {
+		delete(ttles.repositories, name)
+	}
+	ttles.size -= entry.Size
 	ttles.indexDirty = true
 }
 
+// enforceSizeLimit evicts whole repositories, least recently used first,
+// until the cached blobs fit within the size limit. The most recently used
+// repository is never evicted. The repositories are chosen and removed from
+// the schedule under the lock, and their content is removed in the
+// background, so that requests do not wait for the storage. The caller must
+// hold the lock.
+func (ttles *TTLExpirationScheduler) enforceSizeLimit() {
+	if ttles.sizeLimit <= 0 || ttles.size <= ttles.sizeLimit || !ttles.leader() {
+		return
+	}
+
+	type usage struct {
+		name     string
+		accessed time.Time
+	}
+	repos := make([]usage, 0, len(ttles.repositories))
+	for name, entries := range ttles.repositories {
+		u := usage{name: name}
+		for _, entry := range entries {
+			if entry.Accessed.After(u.accessed) {
+				u.accessed = entry.Accessed
+			}
+		}
+		repos = append(repos, u)
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].accessed.Before(repos[j].accessed)
+	})
+
+	var expiries []func()
+	var evicted []string
+	for _, u := range repos[:len(repos)-1] {
+		if ttles.size <= ttles.sizeLimit {
+			break
+		}
+
+		dcontext.GetLogger(ttles.ctx).Infof("Evicting repository %s to keep cache within %d bytes", u.name, ttles.sizeLimit)
+		for _, entry := range ttles.repositories[u.name] {
+			expiries = append(expiries, ttles.remove(entry))
+		}
+		evicted = append(evicted, u.name)
+	}
+
+	onRepositoryEvict := ttles.onRepositoryEvict
+	ttles.evictions.Add(1)
+	go func() {
+		defer ttles.evictions.Done()
+
+		for _, expire := range expiries {
+			expire()
+		}
+		if onRepositoryEvict == nil {
+			return
+		}
+		for _, name := range evicted {
+			named, err := reference.WithName(name)
+			if err != nil {
+				dcontext.GetLogger(ttles.ctx).Errorf("Error unpacking repository name %s: %s", name, err)
+				continue
+			}
+			if err := onRepositoryEvict(named); err != nil {
+				dcontext.GetLogger(ttles.ctx).Errorf("Scheduler error returned from OnRepositoryEvict(%s): %s", name, err)
+			}
+		}
+	}()
+}
+
+// repositoryOf returns the name of the repository an entry belongs to.
+func repositoryOf(entry *schedulerEntry) string {
+	name, _, _ := strings.Cut(entry.Key, "@")
+	return name
+}
+
 func (ttles *TTLExpirationScheduler) startTimer(entry *schedulerEntry, ttl time.Duration) *time.Timer {
 	return time.AfterFunc(ttl, func() {
 		ttles.Lock()
-		defer ttles.Unlock()
-
-		var f expiryFunc
-
-		switch entry.EntryType {
-		case entryTypeBlob:
-			f = ttles.onBlobExpire
-		case entryTypeManifest:
-			f = ttles.onManifestExpire
-		default:
-			f = func(reference.Reference) error {
-				return fmt.Errorf("scheduler entry type")
-			}
+
+		// The entry may have been evicted or replaced in the meantime.
+		if ttles.entries[entry.Key] != entry {
+			ttles.Unlock()
+			return
+		}
+		if !entry.expires || !ttles.leader() {
+			// The leader, which merged the entry, expires it.
+			ttles.deleteEntry(entry)
+			ttles.Unlock()
+			return
+		}
+		expire := ttles.remove(entry)
+		ttles.Unlock()
+
+		expire()
+	})
+}
+
+// remove removes entry from the schedule, and returns the function running
+// its expiry function, to be called once the lock is released so that the
+// other operations do not wait for the storage. The caller must hold the
+// lock.
+func (ttles *TTLExpirationScheduler) remove(entry *schedulerEntry) func() {
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+
+	var f expiryFunc
+
+	switch entry.EntryType {
+	case entryTypeBlob:
+		f = ttles.onBlobExpire
+	case entryTypeManifest:
+		f = ttles.onManifestExpire
+	default:
+		f = func(reference.Reference) error {
+			return fmt.Errorf("scheduler entry type")
 		}
+	}
+
+	ttles.deleteEntry(entry)
 
+	return func() {
 		ref, err := reference.Parse(entry.Key)
-		if err == nil {
-			if err := f(ref); err != nil {
-				dcontext.GetLogger(ttles.ctx).Errorf("Scheduler error returned from OnExpire(%s): %s", entry.Key, err)
-			}
-		} else {
+		if err != nil {
 			dcontext.GetLogger(ttles.ctx).Errorf("Error unpacking reference: %s", err)
+			return
 		}
-
-		delete(ttles.entries, entry.Key)
-		ttles.indexDirty = true
-	})
+		if err := f(ref); err != nil {
+			dcontext.GetLogger(ttles.ctx).Errorf("Scheduler error returned from OnExpire(%s) for remote %q: %s", entry.Key, entry.Remote, err)
+		}
+	}
 }
 
-// Stop stops the scheduler.
+// Stop stops the scheduler, once the evictions running in the background
+// are done.
 func (ttles *TTLExpirationScheduler) Stop() {
+	defer ttles.evictions.Wait()
+
 	ttles.Lock()
 	defer ttles.Unlock()
 
@@ -223,13 +598,20 @@ func (ttles *TTLExpirationScheduler) Stop() {
 	ttles.stopped = true
 }
 
+// writeState writes the entries to the state file of the scheduler, or to
+// the state file of the instance if it is not the leader. The caller must
+// hold the lock.
 func (ttles *TTLExpirationScheduler) writeState() error {
 	jsonBytes, err := json.Marshal(ttles.entries)
 	if err != nil {
 		return err
 	}
 
-	err = ttles.driver.PutContent(ttles.ctx, ttles.pathToStateFile, jsonBytes)
+	p := ttles.pathToStateFile
+	if !ttles.leading {
+		p = ttles.instanceStatePath()
+	}
+	err = ttles.driver.PutContent(ttles.ctx, p, jsonBytes)
 	if err != nil {
 		return err
 	}
@@ -238,23 +620,40 @@ func (ttles *TTLExpirationScheduler) writeState() error {
 }
 
 func (ttles *TTLExpirationScheduler) readState() error {
-	if _, err := ttles.driver.Stat(ttles.ctx, ttles.pathToStateFile); err != nil {
-		switch err := err.(type) {
-		case driver.PathNotFoundError:
-			return nil
-		default:
-			return err
-		}
-	}
-
-	bytes, err := ttles.driver.GetContent(ttles.ctx, ttles.pathToStateFile)
+	entries, err := ttles.readEntries(ttles.pathToStateFile)
 	if err != nil {
 		return err
 	}
-
-	err = json.Unmarshal(bytes, &ttles.entries)
-	if err != nil {
-		return err
+	for _, entry := range entries {
+		ttles.setEntry(entry)
 	}
+	ttles.indexDirty = false
 	return nil
 }
+
+// readEntries reads the entries of the state file at p, if it exists.
+func (ttles *TTLExpirationScheduler) readEntries(p string) (map[string]*schedulerEntry, error) {
+	bytes, err := ttles.driver.GetContent(ttles.ctx, p)
+	if errors.As(err, &driver.PathNotFoundError{}) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]*schedulerEntry
+	if err := json.Unmarshal(bytes, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// instancesPath is the directory of the state files of the instances which
+// are not the leader.
+func (ttles *TTLExpirationScheduler) instancesPath() string {
+	return ttles.pathToStateFile + ".instances"
+}
+
+// instanceStatePath is the state file of the instance.
+func (ttles *TTLExpirationScheduler) instanceStatePath() string {
+	return path.Join(ttles.instancesPath(), ttles.instance+".json")
+}
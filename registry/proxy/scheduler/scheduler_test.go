@@ -3,6 +3,7 @@ package scheduler
 import (
 	"encoding/json"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -11,6 +12,23 @@ import (
 	"github.com/distribution/reference"
 )
 
+type fakeClock struct {
+	sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
 func testRefs(t *testing.T) (reference.Reference, reference.Reference, reference.Reference) {
 	ref1, err := reference.Parse("testrepo@sha256:aaaaeaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
 	if err != nil {
@@ -42,6 +60,8 @@ func TestSchedule(t *testing.T) {
 	var mu sync.Mutex
 	s := New(dcontext.Background(), inmemory.New(), "/ttl")
 	deleteFunc := func(repoName reference.Reference) error {
+		mu.Lock()
+		defer mu.Unlock()
 		if len(remainingRepos) == 0 {
 			t.Fatalf("Incorrect expiry count")
 		}
@@ -50,9 +70,7 @@ func TestSchedule(t *testing.T) {
 			t.Fatalf("Trying to remove nonexistent repo: %s", repoName)
 		}
 		t.Log("removing", repoName)
-		mu.Lock()
 		delete(remainingRepos, repoName.String())
-		mu.Unlock()
 
 		return nil
 	}
@@ -62,12 +80,12 @@ func TestSchedule(t *testing.T) {
 		t.Fatalf("Error starting ttlExpirationScheduler: %s", err)
 	}
 
-	s.add(ref1, 3*timeUnit, entryTypeBlob)
-	s.add(ref2, 1*timeUnit, entryTypeBlob)
+	s.add(ref1, "", 0, 3*timeUnit, entryTypeBlob)
+	s.add(ref2, "", 0, 1*timeUnit, entryTypeBlob)
 
 	func() {
 		s.Lock()
-		s.add(ref3, 1*timeUnit, entryTypeBlob)
+		s.add(ref3, "", 0, 1*timeUnit, entryTypeBlob)
 		s.Unlock()
 	}()
 
@@ -106,15 +124,17 @@ func TestRestoreOld(t *testing.T) {
 		return nil
 	}
 
+	// The state is restored against the clock of the scheduler.
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
 	timeUnit := time.Millisecond
 	serialized, err := json.Marshal(&map[string]schedulerEntry{
 		ref1.String(): {
-			Expiry:    time.Now().Add(10 * timeUnit),
+			Expiry:    clock.Now().Add(10 * timeUnit),
 			Key:       ref1.String(),
 			EntryType: 0,
 		},
 		ref2.String(): {
-			Expiry:    time.Now().Add(-3 * timeUnit), // TTL passed, should be removed first
+			Expiry:    clock.Now().Add(-3 * timeUnit), // TTL passed, should be removed first
 			Key:       ref2.String(),
 			EntryType: 0,
 		},
@@ -131,6 +151,7 @@ func TestRestoreOld(t *testing.T) {
 		t.Fatal("Unable to write serialized data to fs")
 	}
 	s := New(dcontext.Background(), fs, "/ttl")
+	s.now = clock.Now
 	s.OnBlobExpire(deleteFunc)
 	err = s.Start()
 	if err != nil {
@@ -172,8 +193,8 @@ func TestStopRestore(t *testing.T) {
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	s.add(ref1, 300*timeUnit, entryTypeBlob)
-	s.add(ref2, 100*timeUnit, entryTypeBlob)
+	s.add(ref1, "https://quay.io", 0, 300*timeUnit, entryTypeBlob)
+	s.add(ref2, "", 0, 100*timeUnit, entryTypeBlob)
 
 	// Start and stop before all operations complete
 	// state will be written to fs
@@ -188,6 +209,12 @@ func TestStopRestore(t *testing.T) {
 		t.Fatalf("Error starting v2: %s", err.Error())
 	}
 
+	s2.Lock()
+	if entry, ok := s2.entries[ref1.String()]; !ok || entry.Remote != "https://quay.io" {
+		t.Errorf("Expected restored entry to record its remote, got %#v", entry)
+	}
+	s2.Unlock()
+
 	<-time.After(500 * timeUnit)
 	mu.Lock()
 	defer mu.Unlock()
@@ -207,3 +234,133 @@ func TestDoubleStart(t *testing.T) {
 		t.Fatalf("Scheduler started twice without error")
 	}
 }
+
+func TestSizeLimitEviction(t *testing.T) {
+	refs := make(map[string]reference.Canonical)
+	for _, name := range []string{"a", "b", "c"} {
+		ref, err := reference.Parse(name + "@sha256:aaaaeaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+		if err != nil {
+			t.Fatalf("could not parse reference: %v", err)
+		}
+		refs[name] = ref.(reference.Canonical)
+	}
+
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	s := New(dcontext.Background(), inmemory.New(), "/ttl")
+	s.now = clock.Now
+	var expired, evicted []string
+	release := make(chan struct{})
+	s.OnBlobExpire(func(ref reference.Reference) error {
+		// Evicting does not hold back the requests adding entries.
+		<-release
+		expired = append(expired, ref.String())
+		return nil
+	})
+	s.OnRepositoryEvict(func(ref reference.Reference) error {
+		evicted = append(evicted, ref.String())
+		return nil
+	})
+	s.SetSizeLimit(100)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	for _, name := range []string{"a", "b"} {
+		if err := s.AddBlobFromRemote(refs[name], "", 30, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Second)
+	}
+
+	// Serving a makes b the least recently used repository.
+	s.Touch(refs["a"])
+	clock.Advance(time.Second)
+
+	if err := s.AddBlobFromRemote(refs["c"], "", 60, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	s.Lock()
+	if len(s.entries) != 2 || s.size != 90 {
+		t.Errorf("Expected 2 remaining entries of 90 bytes, got %d of %d bytes", len(s.entries), s.size)
+	}
+	s.Unlock()
+	close(release)
+	s.evictions.Wait()
+
+	if len(expired) != 1 || expired[0] != refs["b"].String() {
+		t.Errorf("Expected only the blob of b to be evicted, got %v", expired)
+	}
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Errorf("Expected repository b to be evicted, got %v", evicted)
+	}
+}
+
+func TestLeaderExpiry(t *testing.T) {
+	ref1, ref2, _ := testRefs(t)
+	fs := inmemory.New()
+	var firstLeads atomic.Bool
+	firstLeads.Store(true)
+
+	expired := make(chan string, 10)
+	start := func(instance string, isLeader func() bool) *TTLExpirationScheduler {
+		s := New(dcontext.Background(), fs, "/ttl")
+		s.SetCheckpointInterval(10 * time.Millisecond)
+		s.SetLeader(instance, isLeader)
+		s.OnBlobExpire(func(ref reference.Reference) error {
+			expired <- instance + " " + ref.String()
+			return nil
+		})
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	expect := func(expected string) {
+		t.Helper()
+		select {
+		case actual := <-expired:
+			if actual != expected {
+				t.Fatalf("unexpected expiry %q, expected %q", actual, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for expiry %q", expected)
+		}
+	}
+
+	first := start("first", firstLeads.Load)
+	second := start("second", func() bool { return !firstLeads.Load() })
+	defer second.Stop()
+
+	// The leader expires the entries scheduled by the other instances.
+	if err := second.AddBlob(ref1.(reference.Canonical), 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	expect("first " + ref1.String())
+	// The instance which scheduled the entry drops it at its expiry.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		second.Lock()
+		_, scheduled := second.entries[ref1.String()]
+		second.Unlock()
+		if !scheduled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s to be dropped", ref1)
+		}
+	}
+
+	// A new leader takes over the entries of the previous one.
+	if err := first.AddBlob(ref2.(reference.Canonical), 300*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	first.Stop()
+	firstLeads.Store(false)
+	expect("second " + ref2.String())
+
+	select {
+	case actual := <-expired:
+		t.Fatalf("unexpected expiry %q", actual)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestInMemoryLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	l := newInMemoryLimiter(clock.Now)
+	checkLimiter(t, l, clock.Advance)
+}
+
+func TestInMemoryLimiterSweep(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	l := newInMemoryLimiter(clock.Now)
+
+	slow := Limit{Rate: 0.001, Burst: 1}
+	fast := Limit{Rate: 10, Burst: 10}
+	_, _, err := l.Allow(ctx, "slow", slow)
+	require.NoError(t, err)
+	_, _, err = l.Allow(ctx, "fast", fast)
+	require.NoError(t, err)
+	require.Len(t, l.buckets, 2)
+
+	// The fast bucket is full again after a second, the slow one is not.
+	clock.Advance(sweepInterval)
+	_, _, err = l.Allow(ctx, "other", fast)
+	require.NoError(t, err)
+	require.Contains(t, l.buckets, "slow")
+	require.NotContains(t, l.buckets, "fast")
+	require.Contains(t, l.buckets, "other")
+}
+
+// checkLimiter exercises a limiter whose buckets are refilled as advance is
+// called.
+func checkLimiter(t *testing.T, l Limiter, advance func(time.Duration)) {
+	ctx := context.Background()
+	limit := Limit{Rate: 2, Burst: 3}
+
+	for i := 0; i < limit.Burst; i++ {
+		allowed, _, err := l.Allow(ctx, "alice", limit)
+		require.NoError(t, err)
+		require.True(t, allowed, "request %d", i)
+	}
+	allowed, retryAfter, err := l.Allow(ctx, "alice", limit)
+	require.NoError(t, err)
+	require.False(t, allowed)
+	require.InDelta(t, 500*time.Millisecond, retryAfter, float64(50*time.Millisecond))
+
+	// Buckets are independent.
+	allowed, _, err = l.Allow(ctx, "bob", limit)
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	advance(500 * time.Millisecond)
+	allowed, _, err = l.Allow(ctx, "alice", limit)
+	require.NoError(t, err)
+	require.True(t, allowed)
+	allowed, _, err = l.Allow(ctx, "alice", limit)
+	require.NoError(t, err)
+	require.False(t, allowed)
+
+	// A bucket does not fill beyond its burst.
+	advance(time.Hour)
+	for i := 0; i < limit.Burst; i++ {
+		allowed, _, err := l.Allow(ctx, "alice", limit)
+		require.NoError(t, err)
+		require.True(t, allowed, "request %d", i)
+	}
+	allowed, _, err = l.Allow(ctx, "alice", limit)
+	require.NoError(t, err)
+	require.False(t, allowed)
+}
@@ -0,0 +1,31 @@
+// Package ratelimit provides token bucket rate limiters, keeping the buckets
+// in memory for a single registry instance or in redis for a fleet of
+// registry instances.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket: it holds at most Burst tokens and is
+// refilled with Rate tokens per second. Each request takes a token.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Limiter takes tokens from token buckets identified by a key.
+type Limiter interface {
+	// Allow takes a token from the bucket identified by key, creating a
+	// full bucket described by limit if it does not exist. If the bucket is
+	// empty, Allow returns false and how long to wait for a token.
+	Allow(ctx context.Context, key string, limit Limit) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// idleTimeout returns the time it takes for an empty bucket to be full
+// again. A bucket idle for longer is equivalent to a new one.
+func (l Limit) idleTimeout() time.Duration {
+	return time.Duration(math.Ceil(float64(l.Burst) / l.Rate * float64(time.Second)))
+}
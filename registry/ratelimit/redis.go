@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// allowScript atomically refills and takes a token from the bucket stored in
+// the hash KEYS[1], using the clock of the redis server so that registry
+// instances agree on time. It returns whether a token was taken and, if not,
+// the number of milliseconds to wait for one.
+var allowScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(bucket[1]) or burst
+local last = tonumber(bucket[2]) or now
+if now > last then
+	tokens = math.min(burst, tokens + (now - last) * rate)
+end
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "last", tostring(now))
+redis.call("PEXPIRE", KEYS[1], ttl)
+return {allowed, wait}
+`)
+
+type redisLimiter struct {
+	pool *redis.Client
+}
+
+// NewRedisLimiter returns a Limiter keeping the token buckets in redis,
+// shared by the registry instances using the same redis pool.
+func NewRedisLimiter(pool *redis.Client) Limiter {
+	return &redisLimiter{pool: pool}
+}
+
+// Allow implements Limiter.
+func (l *redisLimiter) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	ttl := limit.idleTimeout().Milliseconds() + 1
+	res, err := allowScript.Run(ctx, l.pool, []string{bucketKey(key)}, limit.Rate, limit.Burst, ttl).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit script result: %v", res)
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
+
+func bucketKey(key string) string {
+	return "ratelimit::" + key
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"context"
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var redisAddr string
+
+func init() {
+	flag.StringVar(&redisAddr, "test.registry.ratelimit.redis.addr", "", "configure the address of a test instance of redis")
+}
+
+// TestRedisLimiter exercises a live redis instance using the limiter
+// implementation.
+func TestRedisLimiter(t *testing.T) {
+	if redisAddr == "" {
+		// fallback to an environment variable
+		redisAddr = os.Getenv("TEST_REGISTRY_RATELIMIT_REDIS_ADDR")
+	}
+
+	if redisAddr == "" {
+		// skip if still not set
+		t.Skip("please set -test.registry.ratelimit.redis.addr to test the rate limiter against redis")
+	}
+
+	pool := redis.NewClient(&redis.Options{
+		Addr:       redisAddr,
+		MaxRetries: 3,
+		PoolSize:   2,
+	})
+
+	// Clear the database
+	if err := pool.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatalf("unexpected error flushing redis db: %v", err)
+	}
+
+	// The limiter uses the clock of the redis server, so time really passes.
+	checkLimiter(t, NewRedisLimiter(pool), time.Sleep)
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/health"
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/requestutil"
 	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/distribution/distribution/v3/registry/listener"
 	"github.com/distribution/distribution/v3/tracing"
@@ -112,7 +113,7 @@ var ServeCmd = &cobra.Command{
 			logrus.Fatalln(err)
 		}
 
-		configureDebugServer(config)
+		configureDebugServer(config, registry.app.HealthRegistry())
 
 		if err = registry.ListenAndServe(); err != nil {
 			logrus.Fatalln(err)
@@ -139,12 +140,14 @@ func NewRegistry(ctx context.Context, config *configuration.Configuration) (*Reg
 	}
 
 	app := handlers.NewApp(ctx, config)
-	// TODO(aaronl): The global scope of the health checks means NewRegistry
-	// can only be called once per process.
 	app.RegisterHealthChecks()
 	var handler http.Handler = app
 	handler = alive("/", handler)
-	handler = health.Handler(handler)
+	handler = app.HealthRegistry().Handler(handler)
+	handler, err = newHeadersHandler(config.HTTP.Headers, handler)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring http.headers: %v", err)
+	}
 	handler = panicHandler(handler)
 	if !config.Log.AccessLog.Disabled {
 		handler = gorhandlers.CombinedLoggingHandler(os.Stdout, handler)
@@ -154,14 +157,16 @@ func NewRegistry(ctx context.Context, config *configuration.Configuration) (*Reg
 		handler = applyHandlerMiddleware(config, handler)
 	}
 
-	err = tracing.InitOpenTelemetry(app.Context)
+	err = tracing.InitOpenTelemetry(app.Context, config.Reporting.OTel)
 	if err != nil {
 		return nil, fmt.Errorf("error during open telemetry initialization: %v", err)
 	}
 	if config.HTTP.H2C.Enabled {
 		handler = h2c.NewHandler(handler, &http2.Server{})
 	}
-	handler = otelHandler(handler)
+	if tracing.Enabled() {
+		handler = otelHandler(handler)
+	}
 
 	server := &http.Server{
 		Handler: handler,
@@ -176,10 +181,11 @@ func NewRegistry(ctx context.Context, config *configuration.Configuration) (*Reg
 }
 
 // otelHandler returns an http.Handler that wraps the provided `next` handler with OpenTelemetry instrumentation.
-// This instrumentation tracks each HTTP request, creating spans with names derived from the request method and URL path.
+// This instrumentation tracks each HTTP request, creating spans named after the request method, which the
+// application renames after the route serving the request.
 func otelHandler(next http.Handler) http.Handler {
 	return otelhttp.NewHandler(next, "",
-		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string { return r.Method + " " + r.URL.Path }))
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string { return r.Method }))
 }
 
 // takes a list of cipher suites and converts it to a list of respective tls constants
@@ -228,6 +234,21 @@ func (registry *Registry) ListenAndServe() error {
 		return err
 	}
 
+	if config.HTTP.ProxyProtocol {
+		// Clients could otherwise spoof their address.
+		if len(config.HTTP.ProxyProtocolSources) == 0 {
+			return fmt.Errorf("http.proxyprotocol requires http.proxyprotocolsources to list the load balancers")
+		}
+		sources, err := requestutil.ParseNetworks(config.HTTP.ProxyProtocolSources)
+		if err != nil {
+			return fmt.Errorf("invalid http.proxyprotocolsources: %v", err)
+		}
+		// The header precedes the TLS handshake, so the PROXY protocol
+		// listener is wrapped by the TLS listener.
+		ln = listener.NewProxyProtocolListener(ln, sources)
+		dcontext.GetLogger(registry.app).Info("accepting PROXY protocol headers")
+	}
+
 	if config.HTTP.TLS.Certificate != "" || config.HTTP.TLS.LetsEncrypt.CacheFile != "" {
 		if config.HTTP.TLS.MinimumTLS == "" {
 			config.HTTP.TLS.MinimumTLS = defaultTLSVersionStr
@@ -332,16 +353,30 @@ func (registry *Registry) ListenAndServe() error {
 	}
 }
 
-// Shutdown gracefully shuts down the registry's HTTP server.
+// Shutdown gracefully shuts down the registry's HTTP server and releases
+// the resources held by the application.
 func (registry *Registry) Shutdown(ctx context.Context) error {
-	return registry.server.Shutdown(ctx)
+	err := registry.server.Shutdown(ctx)
+	if appErr := registry.app.Shutdown(); appErr != nil {
+		dcontext.GetLogger(registry.app).Errorf("error shutting down application: %v", appErr)
+	}
+	if traceErr := tracing.Shutdown(ctx); traceErr != nil {
+		dcontext.GetLogger(registry.app).Errorf("error exporting traces: %v", traceErr)
+	}
+	return err
 }
 
-func configureDebugServer(config *configuration.Configuration) {
+// configureDebugServer serves the handlers registered with the default
+// ServeMux on the debug address, with the status of the checks of
+// healthRegistry on /debug/health.
+func configureDebugServer(config *configuration.Configuration, healthRegistry *health.Registry) {
 	if config.HTTP.Debug.Addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/", http.DefaultServeMux)
+		mux.HandleFunc("/debug/health", healthRegistry.StatusHandler)
 		go func(addr string) {
 			logrus.Infof("debug server listening %v", addr)
-			if err := http.ListenAndServe(addr, nil); err != nil {
+			if err := http.ListenAndServe(addr, mux); err != nil {
 				logrus.Fatalf("error listening on debug interface: %v", err)
 			}
 		}(config.HTTP.Debug.Addr)
@@ -422,6 +457,10 @@ func panicHandler(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					// The response was aborted on purpose.
+					panic(err)
+				}
 				logrus.Panic(fmt.Sprintf("%v", err))
 			}
 		}()
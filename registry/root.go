@@ -1,13 +1,23 @@
 package registry
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	"github.com/distribution/distribution/v3/version"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
 	"github.com/spf13/cobra"
 )
 
@@ -18,6 +28,30 @@ func init() {
 	RootCmd.AddCommand(GCCmd)
 	GCCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do everything except remove the blobs")
 	GCCmd.Flags().BoolVarP(&removeUntagged, "delete-untagged", "m", false, "delete manifests that are not currently referenced via tag")
+	GCCmd.Flags().StringSliceVar(&gcRepositories, "repository", nil, "collect only this repository, may be repeated")
+	GCCmd.Flags().IntVar(&gcWorkers, "workers", 1, "number of repositories walked concurrently")
+	GCCmd.Flags().StringVar(&gcFormat, "format", "text", "report format, text or json")
+	GCCmd.Flags().BoolVar(&gcIncremental, "incremental", false, "delete the blobs the blob reference index marks unreferenced instead of walking the repositories")
+	RootCmd.AddCommand(GCIndexCmd)
+	GCIndexCmd.AddCommand(GCIndexRebuildCmd)
+	GCIndexRebuildCmd.Flags().BoolVar(&gcIndexVerifyOnly, "verify-only", false, "compare the index with the references counted without writing it")
+	GCIndexRebuildCmd.Flags().IntVar(&gcWorkers, "workers", 1, "number of repositories walked concurrently")
+	GCIndexRebuildCmd.Flags().StringVar(&gcFormat, "format", "text", "report format, text or json")
+	RootCmd.AddCommand(FsckCmd)
+	FsckCmd.Flags().BoolVar(&fsckRepair, "repair", false, "remove dangling and malformed tag and manifest links, never blob data")
+	FsckCmd.Flags().StringVar(&fsckFormat, "format", "text", "report format, text or json")
+	RootCmd.AddCommand(ExportCmd)
+	RootCmd.AddCommand(ImportCmd)
+	RootCmd.AddCommand(RestoreCmd)
+	RootCmd.AddCommand(ReplicateBackfillCmd)
+	RootCmd.AddCommand(MigrateLayoutCmd)
+	MigrateLayoutCmd.Flags().BoolVar(&removeLegacy, "remove-legacy", false, "remove the legacy manifest links once migrated")
+	RootCmd.AddCommand(TagCmd)
+	TagCmd.AddCommand(TagListCmd)
+	TagCmd.AddCommand(TagRemoveCmd)
+	TagCmd.AddCommand(TagSetCmd)
+	RootCmd.AddCommand(RepoInfoCmd)
+	RepoInfoCmd.Flags().StringVar(&repoInfoFormat, "format", "text", "report format, text or json")
 	RootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "show the version and exit")
 }
 
@@ -39,6 +73,10 @@ var RootCmd = &cobra.Command{
 var (
 	dryRun         bool
 	removeUntagged bool
+	gcRepositories []string
+	gcWorkers      int
+	gcFormat       string
+	gcIncremental  bool
 )
 
 // GCCmd is the cobra command that corresponds to the garbage-collect subcommand
@@ -47,6 +85,13 @@ var GCCmd = &cobra.Command{
 	Short: "`garbage-collect` deletes layers not referenced by any manifests",
 	Long:  "`garbage-collect` deletes layers not referenced by any manifests",
 	Run: func(cmd *cobra.Command, args []string) {
+		if gcFormat != "text" && gcFormat != "json" {
+			fmt.Fprintf(os.Stderr, "unknown report format %q\n", gcFormat)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
 		config, err := resolveConfiguration(args)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
@@ -68,19 +113,667 @@ var GCCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		registry, err := storage.NewRegistry(ctx, driver)
+		var options []storage.RegistryOption
+		if parallelism, ok := config.Storage["walk"]["parallelism"].(int); ok {
+			options = append(options, storage.WalkParallelism(parallelism))
+		}
+		// Incremental collection removes blobs under the lock of the blob
+		// reference index shared with the running registry instances.
+		if locks := handlers.SharedLocks(config); locks != nil {
+			options = append(options, locks)
+		}
+		registry, err := storage.NewRegistry(ctx, driver, options...)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
 			os.Exit(1)
 		}
 
-		err = storage.MarkAndSweep(ctx, driver, registry, storage.GCOpts{
+		// The progress is written along the text report, and kept out of the
+		// JSON one.
+		output := os.Stdout
+		if gcFormat == "json" {
+			output = os.Stderr
+		}
+		report, err := storage.MarkAndSweep(ctx, driver, registry, storage.GCOpts{
 			DryRun:         dryRun,
 			RemoveUntagged: removeUntagged,
+			Repositories:   gcRepositories,
+			Workers:        gcWorkers,
+			Output:         output,
+			Incremental:    gcIncremental,
 		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to garbage collect: %v", err)
 			os.Exit(1)
 		}
+		if gcFormat == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write report: %v", err)
+				os.Exit(1)
+			}
+		}
 	},
 }
+
+var gcIndexVerifyOnly bool
+
+// GCIndexCmd is the cobra command that corresponds to the gc-index
+// subcommand
+var GCIndexCmd = &cobra.Command{
+	Use:   "gc-index",
+	Short: "`gc-index` manages the blob reference index of incremental garbage collection",
+	Long:  "`gc-index` manages the blob reference index of incremental garbage collection",
+}
+
+// GCIndexRebuildCmd is the cobra command that corresponds to the gc-index
+// rebuild subcommand
+var GCIndexRebuildCmd = &cobra.Command{
+	Use:   "rebuild <config>",
+	Short: "`rebuild` counts the references to blobs and rebuilds the blob reference index",
+	Long: "`rebuild` counts the references to blobs by walking the repositories and replaces the blob reference index " +
+		"with the counts, or only compares them with --verify-only, exiting with status 2 if they differ.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if gcFormat != "text" && gcFormat != "json" {
+			fmt.Fprintf(os.Stderr, "unknown report format %q\n", gcFormat)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		var options []storage.RegistryOption
+		if parallelism, ok := config.Storage["walk"]["parallelism"].(int); ok {
+			options = append(options, storage.WalkParallelism(parallelism))
+		}
+		registry, err := storage.NewRegistry(ctx, driver, options...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		report, err := storage.RebuildBlobReferenceIndex(ctx, driver, registry, storage.RefIndexOpts{
+			VerifyOnly: gcIndexVerifyOnly,
+			Workers:    gcWorkers,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rebuild blob reference index: %v", err)
+			os.Exit(1)
+		}
+
+		if gcFormat == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write report: %v", err)
+				os.Exit(1)
+			}
+		} else {
+			for _, m := range report.Mismatches {
+				fmt.Printf("%s: indexed %d times, referenced %d times\n", m.Blob, m.Indexed, m.Counted)
+			}
+			fmt.Printf("\n%d repositories, %d manifests and %d blobs counted, %d blobs indexed with a wrong count\n",
+				report.Repositories, report.Manifests, report.Blobs, len(report.Mismatches))
+		}
+		if gcIndexVerifyOnly && len(report.Mismatches) > 0 {
+			os.Exit(2)
+		}
+	},
+}
+
+var (
+	fsckRepair bool
+	fsckFormat string
+)
+
+// FsckCmd is the cobra command that corresponds to the fsck subcommand
+var FsckCmd = &cobra.Command{
+	Use:   "fsck <config>",
+	Short: "`fsck` verifies the references between tags, manifests and blobs",
+	Long: "`fsck` verifies that tags link to existing manifests, that the blobs referenced by manifests exist " +
+		"and that link files contain well-formed digests. It exits with status 2 if problems remain unrepaired.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if fsckFormat != "text" && fsckFormat != "json" {
+			fmt.Fprintf(os.Stderr, "unknown report format %q\n", fsckFormat)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		report, err := storage.Fsck(ctx, registry, storage.FsckOpts{Repair: fsckRepair})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to check registry: %v", err)
+			os.Exit(1)
+		}
+
+		unrepaired := 0
+		for _, p := range report.Problems {
+			if !p.Repaired {
+				unrepaired++
+			}
+		}
+		if fsckFormat == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write report: %v", err)
+				os.Exit(1)
+			}
+		} else {
+			for _, p := range report.Problems {
+				fmt.Println(p)
+			}
+			fmt.Printf("\n%d repositories, %d tags and %d manifests checked, %d problems found, %d repaired\n",
+				report.Repositories, report.Tags, report.Manifests, len(report.Problems), len(report.Problems)-unrepaired)
+		}
+		if unrepaired > 0 {
+			os.Exit(2)
+		}
+	},
+}
+
+var removeLegacy bool
+
+// MigrateLayoutCmd is the cobra command that corresponds to the
+// migrate-layout subcommand
+var MigrateLayoutCmd = &cobra.Command{
+	Use:   "migrate-layout <config>",
+	Short: "`migrate-layout` moves manifest links from the legacy layers location to the manifest revisions",
+	Long: "`migrate-layout` copies the manifest links written by registry 2.1 with the layers of a repository " +
+		"to the manifest revisions, where they are looked up, and verifies them. Legacy links are removed with --remove-legacy. " +
+		"It exits with status 2 if manifests failed to migrate.",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		report, err := storage.MigrateLayout(ctx, registry, storage.MigrateLayoutOpts{RemoveLegacy: removeLegacy})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to migrate registry: %v", err)
+			os.Exit(1)
+		}
+
+		for _, repo := range report.Legacy {
+			fmt.Printf("%s: manifests still linked in the legacy location only\n", repo)
+		}
+		fmt.Printf("%d repositories checked, %d manifest links migrated, %d legacy links removed\n",
+			report.Repositories, report.Migrated, report.Removed)
+		if len(report.Legacy) > 0 {
+			os.Exit(2)
+		}
+	},
+}
+
+// ExportCmd is the cobra command that corresponds to the export subcommand
+var ExportCmd = &cobra.Command{
+	Use:   "export <config> <repository> <output.tar>",
+	Short: "`export` writes the tagged images of a repository to an OCI image layout archive",
+	Long: "`export` writes the tagged manifests of a repository, the manifests they reference and their blobs " +
+		"to a tar archive of an OCI image layout. The digest of each blob is verified as it is read.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 3 {
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+		ctx, repo := layoutRepository(cmd, args)
+
+		fp, err := os.Create(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create archive: %v", err)
+			os.Exit(1)
+		}
+		err = storage.ExportOCILayout(ctx, repo, fp)
+		if closeErr := fp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to export %s: %v", args[1], err)
+			// nolint:errcheck
+			os.Remove(args[2])
+			os.Exit(1)
+		}
+	},
+}
+
+// ImportCmd is the cobra command that corresponds to the import subcommand
+var ImportCmd = &cobra.Command{
+	Use:   "import <config> <repository> <input.tar>",
+	Short: "`import` pushes the images of an OCI image layout archive to a repository",
+	Long: "`import` pushes the blobs and manifests of a tar archive of an OCI image layout to a repository, " +
+		"and tags the manifests annotated with a reference name. The digest of each blob is verified before it is committed.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 3 {
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+		ctx, repo := layoutRepository(cmd, args)
+
+		fp, err := os.Open(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open archive: %v", err)
+			os.Exit(1)
+		}
+		defer fp.Close()
+		if err := storage.ImportOCILayout(ctx, repo, fp); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to import %s: %v", args[1], err)
+			os.Exit(1)
+		}
+	},
+}
+
+// RestoreCmd is the cobra command that corresponds to the restore subcommand
+var RestoreCmd = &cobra.Command{
+	Use:   "restore <config> <repository> <digest|tag>",
+	Short: "`restore` restores a manifest or tag deleted with soft deletion enabled",
+	Long: "`restore` moves the links of a manifest or tag of a repository back from the trash, where they are kept " +
+		"once deleted while soft deletion is enabled. A tag can only be restored once the manifest it references is.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 3 {
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+		ctx, repo := layoutRepository(cmd, args)
+
+		if err := storage.Restore(ctx, repo, args[2]); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to restore %s in %s: %v", args[2], args[1], err)
+			os.Exit(1)
+		}
+	},
+}
+
+// ReplicateBackfillCmd is the cobra command that corresponds to the
+// replicate-backfill subcommand
+var ReplicateBackfillCmd = &cobra.Command{
+	Use:   "replicate-backfill <config>",
+	Short: "`replicate-backfill` copies existing content to the replication secondary storage",
+	Long: "`replicate-backfill` copies the blob data and links of the storage which are missing from, " +
+		"or differ in, the secondary storage configured for replication, such as the content written before replication was enabled.",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		replicator, err := handlers.NewReplicator(ctx, config, driver, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to configure replication: %v", err)
+			os.Exit(1)
+		}
+		if replicator == nil {
+			fmt.Fprintf(os.Stderr, "replication is not configured")
+			os.Exit(1)
+		}
+		defer replicator.Close()
+
+		copied, err := replicator.Backfill(ctx)
+		fmt.Printf("%d files copied\n", copied)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to backfill: %v", err)
+			replicator.Close()
+			os.Exit(1)
+		}
+	},
+}
+
+// TagCmd is the cobra command that corresponds to the tag subcommand
+var TagCmd = &cobra.Command{
+	Use:   "tag",
+	Short: "`tag` lists and changes the tags of a repository directly in the storage",
+	Long:  "`tag` lists and changes the tags of a repository directly in the storage",
+}
+
+// TagListCmd is the cobra command that corresponds to the tag ls subcommand
+var TagListCmd = &cobra.Command{
+	Use:     "ls <config> <repository>",
+	Aliases: []string{"list"},
+	Short:   "`ls` lists the tags of a repository with the digests of their manifests",
+	Long:    "`ls` lists the tags of a repository with the digests of their manifests",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+		ctx, repo := tagRepository(cmd, args, false)
+
+		tags, err := storage.ListTags(ctx, repo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list tags of %s: %v", args[1], err)
+			os.Exit(1)
+		}
+		for _, tag := range tags {
+			fmt.Printf("%s\t%s\n", tag.Tag, tag.Digest)
+		}
+	},
+}
+
+// TagRemoveCmd is the cobra command that corresponds to the tag rm
+// subcommand
+var TagRemoveCmd = &cobra.Command{
+	Use:   "rm <config> <repository> <tag>",
+	Short: "`rm` removes a tag from a repository",
+	Long: "`rm` removes a tag from a repository, leaving the manifest it references in place. " +
+		"It refuses to run while the storage is in read-only maintenance mode.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 3 {
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+		ctx, repo := tagRepository(cmd, args, true)
+
+		if err := storage.RemoveTag(ctx, repo, args[2]); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to remove tag %s from %s: %v", args[2], args[1], err)
+			os.Exit(1)
+		}
+	},
+}
+
+// TagSetCmd is the cobra command that corresponds to the tag set subcommand
+var TagSetCmd = &cobra.Command{
+	Use:     "set <config> <repository> <tag> <digest>",
+	Aliases: []string{"retag"},
+	Short:   "`set` links a tag of a repository to a manifest",
+	Long: "`set` links a tag of a repository to a manifest, which must already be a manifest revision of the repository, " +
+		"moving the tag if it exists. It refuses to run while the storage is in read-only maintenance mode.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 4 {
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+		dgst, err := digest.Parse(args[3])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid digest %s: %v", args[3], err)
+			os.Exit(1)
+		}
+		ctx, repo := tagRepository(cmd, args, true)
+
+		if err := storage.SetTag(ctx, repo, args[2], dgst); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to tag %s in %s: %v", dgst, args[1], err)
+			os.Exit(1)
+		}
+	},
+}
+
+var repoInfoFormat string
+
+// RepoInfoCmd is the cobra command that corresponds to the repo-info
+// subcommand
+var RepoInfoCmd = &cobra.Command{
+	Use:   "repo-info <config> <repository>",
+	Short: "`repo-info` lists the tags, manifests and blobs of a repository",
+	Long: "`repo-info` lists the tags of a repository with the digests and modification times of their links, " +
+		"then its manifest revisions with their media types and tags, and the blobs each of them references " +
+		"with their presence and size in the blob store. The json format writes a JSON object per line. " +
+		"It exits with status 2 if a referenced blob is missing.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+		if repoInfoFormat != "text" && repoInfoFormat != "json" {
+			fmt.Fprintf(os.Stderr, "unknown report format %q\n", repoInfoFormat)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+		ctx, repo := tagRepository(cmd, args, false)
+
+		out := bufio.NewWriter(os.Stdout)
+		enc := json.NewEncoder(out)
+		tagFn := func(tag storage.RepositoryTag) error {
+			if repoInfoFormat == "json" {
+				return enc.Encode(map[string]storage.RepositoryTag{"tag": tag})
+			}
+			_, err := fmt.Fprintf(out, "tag\t%s\t%s\t%s\n", tag.Tag, tag.Digest, tag.Modified.UTC().Format(time.RFC3339))
+			return err
+		}
+		manifestFn := func(m storage.RepositoryManifest) error {
+			if repoInfoFormat == "json" {
+				if err := enc.Encode(map[string]storage.RepositoryManifest{"manifest": m}); err != nil {
+					return err
+				}
+				return out.Flush()
+			}
+			tags := "untagged"
+			if len(m.Tags) > 0 {
+				tags = "tags=" + strings.Join(m.Tags, ",")
+			}
+			fmt.Fprintf(out, "manifest\t%s\t%s\t%d\t%s\n", m.Digest, m.MediaType, m.Size, tags)
+			if m.Error != "" {
+				fmt.Fprintf(out, "\terror\t%s\n", m.Error)
+			}
+			for _, blob := range m.Blobs {
+				state := fmt.Sprint(blob.Size)
+				switch {
+				case blob.Exists:
+				case blob.Foreign:
+					state = "foreign"
+				default:
+					state = "missing"
+				}
+				link := "linked"
+				if !blob.Linked {
+					link = "unlinked"
+				}
+				fmt.Fprintf(out, "\tblob\t%s\t%s\t%s\t%s\n", blob.Digest, blob.MediaType, state, link)
+			}
+			return out.Flush()
+		}
+
+		totals, err := storage.DescribeRepository(ctx, repo, tagFn, manifestFn)
+		if err == nil {
+			if repoInfoFormat == "json" {
+				err = enc.Encode(map[string]storage.RepositoryTotals{"totals": totals})
+			} else {
+				_, err = fmt.Fprintf(out, "\n%d tags, %d manifests (%d untagged), %d blobs of %d bytes, %d missing\n",
+					totals.Tags, totals.Manifests, totals.UntaggedManifests, totals.Blobs, totals.BlobSize, totals.MissingBlobs)
+			}
+		}
+		if flushErr := out.Flush(); err == nil {
+			err = flushErr
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to describe %s: %v", args[1], err)
+			os.Exit(1)
+		}
+		if totals.MissingBlobs > 0 {
+			os.Exit(2)
+		}
+	},
+}
+
+// tagRepository returns the repository named by args[1] of the registry
+// configured by args[0], constructed as for garbage collection, exiting on
+// error. Commands which write refuse to run in read-only maintenance mode.
+func tagRepository(cmd *cobra.Command, args []string, write bool) (context.Context, distribution.Repository) {
+	config, err := resolveConfiguration(args[:1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		// nolint:errcheck
+		cmd.Usage()
+		os.Exit(1)
+	}
+	if write && readOnlyMaintenance(config) {
+		fmt.Fprintf(os.Stderr, "storage is in read-only maintenance mode, refusing to modify tags")
+		os.Exit(1)
+	}
+
+	ctx := dcontext.Background()
+	ctx, err = configureLogging(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+		os.Exit(1)
+	}
+
+	driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+		os.Exit(1)
+	}
+
+	var options []storage.RegistryOption
+	if parallelism, ok := config.Storage["walk"]["parallelism"].(int); ok {
+		options = append(options, storage.WalkParallelism(parallelism))
+	}
+	registry, err := storage.NewRegistry(ctx, driver, options...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+		os.Exit(1)
+	}
+
+	named, err := reference.WithName(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid repository name %s: %v", args[1], err)
+		os.Exit(1)
+	}
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct repository: %v", err)
+		os.Exit(1)
+	}
+	return ctx, repo
+}
+
+// readOnlyMaintenance reports whether the storage of config is in read-only
+// maintenance mode.
+func readOnlyMaintenance(config *configuration.Configuration) bool {
+	readOnly, _ := config.Storage["maintenance"]["readonly"].(map[interface{}]interface{})
+	enabled, _ := readOnly["enabled"].(bool)
+	return enabled
+}
+
+// layoutRepository returns the repository named by args[1] of the registry
+// configured by args[0], exiting on error.
+func layoutRepository(cmd *cobra.Command, args []string) (context.Context, distribution.Repository) {
+	config, err := resolveConfiguration(args[:1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		// nolint:errcheck
+		cmd.Usage()
+		os.Exit(1)
+	}
+
+	ctx := dcontext.Background()
+	ctx, err = configureLogging(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+		os.Exit(1)
+	}
+
+	driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+		os.Exit(1)
+	}
+
+	registry, err := storage.NewRegistry(ctx, driver)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+		os.Exit(1)
+	}
+
+	named, err := reference.WithName(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid repository name %s: %v", args[1], err)
+		os.Exit(1)
+	}
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct repository: %v", err)
+		os.Exit(1)
+	}
+	return ctx, repo
+}
@@ -4,14 +4,22 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"path"
 	"reflect"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/storage/cache/memory"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
 	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/distribution/distribution/v3/testutil"
 	"github.com/distribution/reference"
@@ -529,6 +537,241 @@ func TestLayerUploadZeroLength(t *testing.T) {
 	simpleUpload(t, bs, []byte{}, digestSha256Empty)
 }
 
+func TestBlobUploadCommitCanceled(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+	driver := filesystem.New(filesystem.DriverParameters{
+		RootDirectory: t.TempDir(),
+		MaxThreads:    100,
+	})
+	registry, err := NewRegistry(ctx, driver, BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	content := bytes.Repeat([]byte("canceled upload "), 4096)
+	dgst := digest.FromBytes(content)
+	goroutines := runtime.NumGoroutine()
+
+	// The upload is written by a request canceled mid-stream, before it is
+	// committed.
+	requestCtx, cancel := context.WithCancel(ctx)
+	repository, err := registry.Repository(requestCtx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	blobUpload, err := repository.Blobs(requestCtx).Create(requestCtx)
+	if err != nil {
+		t.Fatalf("unexpected error starting layer upload: %s", err)
+	}
+	half := int64(len(content) / 2)
+	if _, err := io.Copy(blobUpload, bytes.NewReader(content[:half])); err != nil {
+		t.Fatalf("unexpected error writing upload: %v", err)
+	}
+	cancel()
+
+	if _, err := blobUpload.Commit(requestCtx, distribution.Descriptor{Digest: dgst}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the commit to be canceled, got %v", err)
+	}
+	if err := blobUpload.Close(); err != nil {
+		t.Fatalf("unexpected error closing canceled upload: %v", err)
+	}
+
+	// The upload is resumed where it was left.
+	repository, err = registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	bs := repository.Blobs(ctx)
+	blobUpload, err = bs.Resume(ctx, blobUpload.ID())
+	if err != nil {
+		t.Fatalf("unexpected error resuming upload: %v", err)
+	}
+	if blobUpload.Size() != half {
+		t.Fatalf("unexpected size of resumed upload: %d != %d", blobUpload.Size(), half)
+	}
+	if _, err := io.Copy(blobUpload, bytes.NewReader(content[half:])); err != nil {
+		t.Fatalf("unexpected error writing upload: %v", err)
+	}
+	desc, err := blobUpload.Commit(ctx, distribution.Descriptor{Digest: dgst})
+	if err != nil {
+		t.Fatalf("unexpected error committing resumed upload: %v", err)
+	}
+	if desc.Digest != dgst || desc.Size != int64(len(content)) {
+		t.Fatalf("unexpected descriptor: %v", desc)
+	}
+	if _, err := bs.Stat(ctx, dgst); err != nil {
+		t.Fatalf("unexpected error stating committed blob: %v", err)
+	}
+
+	checkNoGoroutineLeak(t, goroutines)
+}
+
+// checkNoGoroutineLeak fails the test if more goroutines than expected are
+// still running once the goroutines ending in the background had the time to.
+func checkNoGoroutineLeak(t *testing.T, expected int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if runtime.NumGoroutine() <= expected {
+			return
+		}
+	}
+	buf := make([]byte, 1<<16)
+	t.Fatalf("leaked goroutines: %d > %d\n%s", runtime.NumGoroutine(), expected, buf[:runtime.Stack(buf, true)])
+}
+
+// uploadRedirectDriver redirects uploads to URLs naming the path and digest
+// of the content to upload.
+type uploadRedirectDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d uploadRedirectDriver) RedirectURL(r *http.Request, path string) (string, error) {
+	if r.Method != http.MethodPut {
+		return "", nil
+	}
+	return fmt.Sprintf("upload://%s?length=%d&digest=%s", path, r.ContentLength, storagedriver.ContentDigestSHA256(r)), nil
+}
+
+func TestBlobUploadRedirect(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+	driver := uploadRedirectDriver{inmemory.New()}
+	content := []byte("redirected content")
+	dgst := digest.FromBytes(content)
+	sum := sha256.Sum256(content)
+
+	newUpload := func(t *testing.T, options ...RegistryOption) distribution.BlobWriter {
+		options = append(options, BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)))
+		registry, err := NewRegistry(ctx, driver, options...)
+		if err != nil {
+			t.Fatalf("error creating registry: %v", err)
+		}
+		repository, err := registry.Repository(ctx, imageName)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+		upload, err := repository.Blobs(ctx).Create(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error starting upload: %v", err)
+		}
+		return upload
+	}
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(content))
+		r.ContentLength = int64(len(content))
+		return r
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		upload := newUpload(t)
+		if u, err := upload.(*blobWriter).RedirectURL(newRequest(), dgst); err != nil || u != "" {
+			t.Fatalf("expected no redirect, got %q, %v", u, err)
+		}
+	})
+
+	t.Run("Commit", func(t *testing.T) {
+		upload := newUpload(t, EnableUploadRedirect)
+		u, err := upload.(*blobWriter).RedirectURL(newRequest(), dgst)
+		if err != nil {
+			t.Fatalf("unexpected error getting redirect url: %v", err)
+		}
+		stagedPath, err := pathFor(uploadStagedPathSpec{name: imageName.Name(), id: upload.ID(), digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := fmt.Sprintf("upload://%s?length=%d&digest=%s", stagedPath, len(content), base64.StdEncoding.EncodeToString(sum[:]))
+		if u != expected {
+			t.Fatalf("unexpected redirect url: %q != %q", u, expected)
+		}
+
+		// The client uploads the content to the backend.
+		if err := driver.PutContent(ctx, stagedPath, content); err != nil {
+			t.Fatal(err)
+		}
+
+		desc, err := upload.Commit(ctx, distribution.Descriptor{Digest: dgst})
+		if err != nil {
+			t.Fatalf("unexpected error committing upload: %v", err)
+		}
+		if desc.Digest != dgst || desc.Size != int64(len(content)) {
+			t.Fatalf("unexpected descriptor: %v", desc)
+		}
+
+		blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := driver.GetContent(ctx, blobPath)
+		if err != nil {
+			t.Fatalf("unexpected error reading blob: %v", err)
+		}
+		if !bytes.Equal(stored, content) {
+			t.Fatalf("unexpected blob content: %q", stored)
+		}
+		if _, err := driver.Stat(ctx, path.Dir(path.Dir(path.Dir(stagedPath)))); err == nil {
+			t.Fatal("expected the upload resources to be removed")
+		}
+	})
+
+	t.Run("OtherDigest", func(t *testing.T) {
+		upload := newUpload(t, EnableUploadRedirect)
+		stagedPath, err := pathFor(uploadStagedPathSpec{name: imageName.Name(), id: upload.ID(), digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := driver.PutContent(ctx, stagedPath, content); err != nil {
+			t.Fatal(err)
+		}
+
+		// Content staged for another digest must not be committed.
+		_, err = upload.Commit(ctx, distribution.Descriptor{Digest: digest.FromString("other content")})
+		if _, ok := err.(distribution.ErrBlobInvalidDigest); !ok {
+			t.Fatalf("expected an invalid digest error, got %v", err)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		upload := newUpload(t, EnableUploadRedirect)
+		stagedPath, err := pathFor(uploadStagedPathSpec{name: imageName.Name(), id: upload.ID(), digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The backend stored content which does not match the digest.
+		tampered := bytes.ToUpper(content)
+		if err := driver.PutContent(ctx, stagedPath, tampered); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = upload.Commit(ctx, distribution.Descriptor{Digest: dgst})
+		if _, ok := err.(distribution.ErrBlobInvalidDigest); !ok {
+			t.Fatalf("expected an invalid digest error, got %v", err)
+		}
+		if _, err := driver.Stat(ctx, stagedPath); err == nil {
+			t.Fatal("expected the staged content to be deleted")
+		}
+		blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored, err := driver.GetContent(ctx, blobPath); err == nil && !bytes.Equal(stored, content) {
+			t.Fatalf("unexpected blob content: %q", stored)
+		}
+	})
+
+	t.Run("Written", func(t *testing.T) {
+		upload := newUpload(t, EnableUploadRedirect)
+		if _, err := upload.Write(content[:1]); err != nil {
+			t.Fatal(err)
+		}
+		if err := upload.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if u, err := upload.(*blobWriter).RedirectURL(newRequest(), dgst); err != nil || u != "" {
+			t.Fatalf("expected no redirect, got %q, %v", u, err)
+		}
+	})
+}
+
 func simpleUpload(t *testing.T, bs distribution.BlobIngester, blob []byte, expectedDigest digest.Digest) {
 	ctx := context.Background()
 	wr, err := bs.Create(ctx)
@@ -3,15 +3,21 @@ package storage
 import (
 	"context"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/opencontainers/go-digest"
 )
 
-// TODO(stevvooe): This should configurable in the future.
+// blobCacheControlMaxAge is the default max-age of the blobs served, which
+// are immutable.
 const blobCacheControlMaxAge = 365 * 24 * time.Hour
 
 // blobServer simply serves blobs from a driver instance using a path function
@@ -21,6 +27,21 @@ type blobServer struct {
 	statter  distribution.BlobStatter
 	pathFn   func(dgst digest.Digest) (string, error)
 	redirect bool // allows disabling RedirectURL redirects
+	// redirectThreshold is the size below which blobs are served directly
+	// rather than redirected.
+	redirectThreshold int64
+	// verifyOnRead digests the blobs served whole, up to verifyMaxSize bytes
+	// unless it is zero.
+	verifyOnRead  bool
+	verifyMaxSize int64
+	// cacheControl is the Cache-Control header of the blobs served, also
+	// requested from the backend serving redirects. When empty, blobs
+	// served directly get the default max-age, and the headers of
+	// redirected blobs are left to the backend.
+	cacheControl string
+	// attachments serves the blobs requested with the attach query
+	// parameter as attachments, named after their digest.
+	attachments bool
 }
 
 func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
@@ -34,8 +55,17 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 		return err
 	}
 
-	if bs.redirect {
-		redirectURL, err := bs.driver.RedirectURL(r, path)
+	contentType := w.Header().Get("Content-Type")
+	if contentType == "" {
+		contentType = desc.MediaType
+	}
+	headers := driver.RedirectHeaders{CacheControl: bs.cacheControl}
+	if bs.attachments && attachRequested(r) {
+		headers.ContentDisposition = contentDisposition(desc.Digest, contentType)
+	}
+
+	if bs.redirect && desc.Size >= bs.redirectThreshold {
+		redirectURL, err := bs.driver.RedirectURL(driver.WithRedirectHeaders(r, headers), path)
 		if err != nil {
 			return err
 		}
@@ -54,15 +84,23 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 	defer br.Close()
 
 	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, desc.Digest)) // If-None-Match handled by ServeContent
-	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%.f", blobCacheControlMaxAge.Seconds()))
+	if headers.CacheControl == "" {
+		headers.CacheControl = fmt.Sprintf("max-age=%.f", blobCacheControlMaxAge.Seconds())
+	}
+	w.Header().Set("Cache-Control", headers.CacheControl)
+	if headers.ContentDisposition != "" {
+		w.Header().Set("Content-Disposition", headers.ContentDisposition)
+	}
 
 	if w.Header().Get("Docker-Content-Digest") == "" {
 		w.Header().Set("Docker-Content-Digest", desc.Digest.String())
 	}
 
-	if w.Header().Get("Content-Type") == "" {
-		// Set the content type if not already set.
-		w.Header().Set("Content-Type", desc.MediaType)
+	// Set the content type if not already set.
+	w.Header().Set("Content-Type", contentType)
+
+	if bs.verifyOnRead && (bs.verifyMaxSize == 0 || desc.Size <= bs.verifyMaxSize) && servedWhole(r) {
+		return serveVerified(ctx, w, desc, br)
 	}
 
 	if w.Header().Get("Content-Length") == "" {
@@ -70,6 +108,89 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 		w.Header().Set("Content-Length", fmt.Sprint(desc.Size))
 	}
 
+	// http.ServeContent answers single ranges with a 206 and reads from the
+	// requested offset, honoring If-Range against the ETag. Requests for
+	// multiple ranges would get a multipart response, which download
+	// clients resuming a blob do not expect: serve the whole blob instead.
+	if strings.Contains(r.Header.Get("Range"), ",") {
+		r = r.Clone(r.Context())
+		r.Header.Del("Range")
+	}
+
 	http.ServeContent(w, r, desc.Digest.String(), time.Time{}, br)
 	return nil
 }
+
+// attachRequested reports whether r requests the blob as an attachment, with
+// the attach query parameter.
+func attachRequested(r *http.Request) bool {
+	attach, err := strconv.ParseBool(r.URL.Query().Get("attach"))
+	return err == nil && attach
+}
+
+// blobExtensions maps the suffixes of media types to the extension of the
+// files holding blobs of the type.
+var blobExtensions = []struct {
+	suffix    string
+	extension string
+}{
+	{"tar+gzip", ".tar.gz"},
+	{"tar.gzip", ".tar.gz"},
+	{"tar+zstd", ".tar.zst"},
+	{"tar", ".tar"},
+	{"+json", ".json"},
+	{"/json", ".json"},
+}
+
+// contentDisposition returns the Content-Disposition header serving the blob
+// dgst of type mediaType as an attachment, named after its digest with the
+// extension of its type.
+func contentDisposition(dgst digest.Digest, mediaType string) string {
+	filename := dgst.String()
+	if mt, _, err := mime.ParseMediaType(mediaType); err == nil {
+		for _, e := range blobExtensions {
+			if strings.HasSuffix(mt, e.suffix) {
+				filename += e.extension
+				break
+			}
+		}
+	}
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
+
+// servedWhole reports whether r requests the whole content of a blob,
+// unconditionally.
+func servedWhole(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	for _, header := range []string{"Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		if r.Header.Get(header) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// serveVerified serves the blob desc read from br, and verifies its digest
+// as it is written. The response is chunked rather than sent with a
+// Content-Length, so that a client can tell a response aborted because of a
+// mismatch, which is only found once the whole blob is written, from a
+// complete one: an ErrContentCorrupt error is then returned, and the caller
+// must abort the response.
+func serveVerified(ctx context.Context, w http.ResponseWriter, desc distribution.Descriptor, br io.Reader) error {
+	digester := desc.Digest.Algorithm().Digester()
+
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, io.TeeReader(br, digester.Hash())); err != nil {
+		// As with http.ServeContent, errors writing the response are
+		// left to the client to notice.
+		dcontext.GetLogger(ctx).Debugf("error serving blob %s: %v", desc.Digest, err)
+		return nil
+	}
+	if actual := digester.Digest(); actual != desc.Digest {
+		return contentCorrupt(ctx, "blob", desc.Digest, actual)
+	}
+	return nil
+}
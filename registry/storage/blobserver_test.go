@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+// headersRedirectDriver redirects reads to URLs holding the headers requested
+// for the response of the backend.
+type headersRedirectDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d headersRedirectDriver) RedirectURL(r *http.Request, path string) (string, error) {
+	headers := storagedriver.GetRedirectHeaders(r)
+	return "backend://" + path + "?" + url.Values{
+		"cache-control":       {headers.CacheControl},
+		"content-disposition": {headers.ContentDisposition},
+	}.Encode(), nil
+}
+
+func TestBlobServerHeaders(t *testing.T) {
+	ctx := context.Background()
+	content := []byte("blob content")
+
+	serve := func(t *testing.T, target string, options ...RegistryOption) *httptest.ResponseRecorder {
+		registry := createRegistry(t, headersRedirectDriver{inmemory.New()}, options...)
+		blobs := makeRepository(t, registry, "headers").Blobs(ctx)
+		desc, err := blobs.Put(ctx, "application/octet-stream", content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		if err := blobs.ServeBlob(ctx, w, httptest.NewRequest(http.MethodGet, target, nil), desc.Digest); err != nil {
+			t.Fatalf("unexpected error serving blob: %v", err)
+		}
+		return w
+	}
+	// The media type of the blobs stored is unknown, so their filename has
+	// no extension.
+	disposition := `attachment; filename="` + digest.FromBytes(content).String() + `"`
+
+	t.Run("Default", func(t *testing.T) {
+		w := serve(t, "/?attach=true")
+		if cc := w.Header().Get("Cache-Control"); cc != "max-age=31536000" {
+			t.Errorf("unexpected Cache-Control: %q", cc)
+		}
+		if cd := w.Header().Get("Content-Disposition"); cd != "" {
+			t.Errorf("unexpected Content-Disposition: %q", cd)
+		}
+	})
+
+	t.Run("Configured", func(t *testing.T) {
+		w := serve(t, "/?attach=true", BlobCacheControlMaxAge(24*time.Hour), EnableBlobAttachments)
+		if cc := w.Header().Get("Cache-Control"); cc != "max-age=86400" {
+			t.Errorf("unexpected Cache-Control: %q", cc)
+		}
+		if cd := w.Header().Get("Content-Disposition"); cd != disposition {
+			t.Errorf("unexpected Content-Disposition: %q", cd)
+		}
+
+		w = serve(t, "/", EnableBlobAttachments)
+		if cd := w.Header().Get("Content-Disposition"); cd != "" {
+			t.Errorf("unexpected Content-Disposition without attach: %q", cd)
+		}
+	})
+
+	t.Run("Redirect", func(t *testing.T) {
+		w := serve(t, "/", EnableRedirect)
+		u, err := url.Parse(w.Header().Get("Location"))
+		if err != nil || u.Query().Get("cache-control") != "" || u.Query().Get("content-disposition") != "" {
+			t.Fatalf("unexpected headers requested by default: %q, %v", w.Header().Get("Location"), err)
+		}
+
+		w = serve(t, "/?attach=1", EnableRedirect, BlobCacheControlMaxAge(time.Hour), EnableBlobAttachments)
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("unexpected status: %d", w.Code)
+		}
+		u, err = url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cc := u.Query().Get("cache-control"); cc != "max-age=3600" {
+			t.Errorf("unexpected Cache-Control requested: %q", cc)
+		}
+		if cd := u.Query().Get("content-disposition"); cd != disposition {
+			t.Errorf("unexpected Content-Disposition requested: %q", cd)
+		}
+	})
+}
+
+func TestContentDisposition(t *testing.T) {
+	dgst := digest.FromString("content")
+	for mediaType, extension := range map[string]string{
+		"application/vnd.oci.image.layer.v1.tar":            ".tar",
+		"application/vnd.oci.image.layer.v1.tar+zstd":       ".tar.zst",
+		"application/vnd.docker.image.rootfs.diff.tar.gzip": ".tar.gz",
+		"application/vnd.oci.image.config.v1+json":          ".json",
+		"application/json; charset=utf-8":                   ".json",
+		"application/octet-stream":                          "",
+		"invalid/":                                          "",
+	} {
+		expected := `attachment; filename="` + dgst.String() + extension + `"`
+		if cd := contentDisposition(dgst, mediaType); cd != expected {
+			t.Errorf("unexpected Content-Disposition for %s: %q != %q", mediaType, cd, expected)
+		}
+	}
+}
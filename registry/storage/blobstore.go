@@ -18,6 +18,12 @@ import (
 type blobStore struct {
 	driver  driver.StorageDriver
 	statter distribution.BlobStatter
+	// replicator, if set, copies the blob data and links written to
+	// secondary storage.
+	replicator *Replicator
+	// walkParallelism is the number of directories listed concurrently by
+	// the walks enumerating the content of the storage.
+	walkParallelism int
 }
 
 var _ distribution.BlobProvider = &blobStore{}
@@ -76,6 +82,10 @@ func (bs *blobStore) Put(ctx context.Context, mediaType string, p []byte) (distr
 		return distribution.Descriptor{}, err
 	}
 
+	if err := bs.driver.PutContent(ctx, bp, p); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
 	// TODO(stevvooe): Write out mediatype here, as well.
 	return distribution.Descriptor{
 		Size: int64(len(p)),
@@ -85,7 +95,7 @@ func (bs *blobStore) Put(ctx context.Context, mediaType string, p []byte) (distr
 		// for the specific repository.
 		MediaType: "application/octet-stream",
 		Digest:    dgst,
-	}, bs.driver.PutContent(ctx, bp, p)
+	}, bs.replicate(ctx, bp)
 }
 
 func (bs *blobStore) Enumerate(ctx context.Context, ingester func(dgst digest.Digest) error) error {
@@ -113,7 +123,7 @@ func (bs *blobStore) Enumerate(ctx context.Context, ingester func(dgst digest.Di
 		}
 
 		return ingester(digest)
-	})
+	}, driver.WithParallelism(bs.walkParallelism))
 }
 
 // path returns the canonical path for the blob identified by digest. The blob
@@ -134,7 +144,19 @@ func (bs *blobStore) path(dgst digest.Digest) (string, error) {
 func (bs *blobStore) link(ctx context.Context, path string, dgst digest.Digest) error {
 	// The contents of the "link" file are the exact string contents of the
 	// digest, which is specified in that package.
-	return bs.driver.PutContent(ctx, path, []byte(dgst))
+	if err := bs.driver.PutContent(ctx, path, []byte(dgst)); err != nil {
+		return err
+	}
+	return bs.replicate(ctx, path)
+}
+
+// replicate queues the copy of path to secondary storage, if replication is
+// enabled.
+func (bs *blobStore) replicate(ctx context.Context, path string) error {
+	if bs.replicator == nil {
+		return nil
+	}
+	return bs.replicator.Enqueue(ctx, path)
 }
 
 // readlink returns the linked digest at path.
@@ -2,9 +2,12 @@ package storage
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"path"
 	"time"
 
@@ -38,6 +41,7 @@ type blobWriter struct {
 	path       string
 
 	resumableDigestEnabled bool
+	uploadRedirect         bool
 	committed              bool
 }
 
@@ -54,23 +58,39 @@ func (bw *blobWriter) StartedAt() time.Time {
 
 // Commit marks the upload as completed, returning a valid descriptor. The
 // final size and digest are checked against the first descriptor provided.
+//
+// If ctx is canceled before the content is moved into place, the upload is
+// left as is, to be resumed or committed again. From then on, an interrupted
+// commit could neither be resumed nor retried, so it is completed regardless
+// of ctx.
 func (bw *blobWriter) Commit(ctx context.Context, desc distribution.Descriptor) (distribution.Descriptor, error) {
 	dcontext.GetLogger(ctx).Debug("(*blobWriter).Commit")
 
-	if err := bw.fileWriter.Commit(ctx); err != nil {
+	canonical, staged, err := bw.commitStaged(ctx, desc)
+	if err != nil {
 		return distribution.Descriptor{}, err
 	}
 
-	bw.Close()
-	desc.Size = bw.Size()
+	if !staged {
+		if err := bw.fileWriter.Commit(ctx); err != nil {
+			return distribution.Descriptor{}, err
+		}
 
-	canonical, err := bw.validateBlob(ctx, desc)
-	if err != nil {
-		return distribution.Descriptor{}, err
-	}
+		bw.Close()
+		desc.Size = bw.Size()
 
-	if err := bw.moveBlob(ctx, canonical); err != nil {
-		return distribution.Descriptor{}, err
+		canonical, err = bw.validateBlob(ctx, desc)
+		if err != nil {
+			return distribution.Descriptor{}, err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return distribution.Descriptor{}, err
+		}
+		ctx = context.WithoutCancel(ctx)
+		if err := bw.moveBlob(ctx, canonical, bw.path); err != nil {
+			return distribution.Descriptor{}, err
+		}
 	}
 
 	if err := bw.blobStore.linkBlob(ctx, canonical, desc.Digest); err != nil {
@@ -90,6 +110,122 @@ func (bw *blobWriter) Commit(ctx context.Context, desc distribution.Descriptor)
 	return canonical, nil
 }
 
+// RedirectURL returns a URL to which the client of the request r may upload
+// the whole content of the blob with digest dgst, bypassing the registry. The
+// client signals the completion of the upload by committing it without
+// writing any content. The empty string is returned if upload redirection is
+// disabled, if content was already written, or if the storage driver does
+// not support redirecting the upload.
+func (bw *blobWriter) RedirectURL(r *http.Request, dgst digest.Digest) (string, error) {
+	if !bw.uploadRedirect || bw.Size() != 0 || r.ContentLength <= 0 {
+		return "", nil
+	}
+	// The digest is enforced by the storage backend, which only supports
+	// SHA-256.
+	if dgst.Algorithm() != digest.SHA256 || dgst.Validate() != nil {
+		return "", nil
+	}
+	sum, err := hex.DecodeString(dgst.Encoded())
+	if err != nil {
+		return "", err
+	}
+
+	stagedPath, err := pathFor(uploadStagedPathSpec{
+		name:   bw.blobStore.repository.pathName(),
+		id:     bw.id,
+		digest: dgst,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req := r.Clone(r.Context())
+	req.Header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum)+":")
+	return bw.driver.RedirectURL(req, stagedPath)
+}
+
+// commitStaged commits the content uploaded by the client at the URL returned
+// by RedirectURL, if any. Not every storage backend checks the content
+// against the digest sent along with it, so it is hashed again here: content
+// which does not match is deleted.
+func (bw *blobWriter) commitStaged(ctx context.Context, desc distribution.Descriptor) (distribution.Descriptor, bool, error) {
+	if !bw.uploadRedirect || bw.Size() != 0 || desc.Digest.Algorithm() != digest.SHA256 || desc.Digest.Validate() != nil {
+		return distribution.Descriptor{}, false, nil
+	}
+
+	stagedPath, err := pathFor(uploadStagedPathSpec{
+		name:   bw.blobStore.repository.pathName(),
+		id:     bw.id,
+		digest: desc.Digest,
+	})
+	if err != nil {
+		return distribution.Descriptor{}, false, err
+	}
+
+	fi, err := bw.driver.Stat(ctx, stagedPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return distribution.Descriptor{}, false, nil
+		}
+		return distribution.Descriptor{}, false, err
+	}
+	if fi.IsDir() {
+		return distribution.Descriptor{}, false, fmt.Errorf("unexpected directory at upload location %q", stagedPath)
+	}
+	if desc.Size > 0 && desc.Size != fi.Size() {
+		return distribution.Descriptor{}, false, distribution.ErrBlobInvalidLength
+	}
+	if err := bw.verifyStaged(ctx, desc.Digest, stagedPath, fi.Size()); err != nil {
+		return distribution.Descriptor{}, false, err
+	}
+
+	// Nothing was written through the registry, so the upload session holds
+	// no data.
+	if err := ctx.Err(); err != nil {
+		return distribution.Descriptor{}, false, err
+	}
+	ctx = context.WithoutCancel(ctx)
+	if err := bw.fileWriter.Cancel(ctx); err != nil {
+		return distribution.Descriptor{}, false, err
+	}
+
+	desc.Size = fi.Size()
+	if desc.MediaType == "" {
+		desc.MediaType = "application/octet-stream"
+	}
+	if err := bw.moveBlob(ctx, desc, stagedPath); err != nil {
+		return distribution.Descriptor{}, false, err
+	}
+	return desc, true, nil
+}
+
+// verifyStaged checks the content staged at stagedPath against dgst, deleting
+// it if it does not match.
+func (bw *blobWriter) verifyStaged(ctx context.Context, dgst digest.Digest, stagedPath string, size int64) error {
+	fr, err := newFileReader(ctx, bw.driver, stagedPath, size)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+
+	verifier := dgst.Verifier()
+	if _, err := io.Copy(verifier, fr); err != nil {
+		return err
+	}
+	if verifier.Verified() {
+		return nil
+	}
+
+	dcontext.GetLogger(ctx).Errorf("content staged for %s does not match its digest, deleting it", dgst)
+	if err := bw.driver.Delete(ctx, stagedPath); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error deleting content staged for %s: %v", dgst, err)
+	}
+	return distribution.ErrBlobInvalidDigest{
+		Digest: dgst,
+		Reason: fmt.Errorf("content does not match digest"),
+	}
+}
+
 // Cancel the blob upload process, releasing any resources associated with
 // the writer and canceling the operation.
 func (bw *blobWriter) Cancel(ctx context.Context) error {
@@ -151,7 +287,9 @@ func (bw *blobWriter) Close() error {
 		return errors.New("blobwriter close after commit")
 	}
 
-	if err := bw.storeHashState(bw.blobStore.ctx); err != nil && err != errResumableDigestNotAvailable {
+	// The hash state is stored even if the request was canceled, for the
+	// upload to be resumed.
+	if err := bw.storeHashState(context.WithoutCancel(bw.blobStore.ctx)); err != nil && err != errResumableDigestNotAvailable {
 		return err
 	}
 
@@ -287,10 +425,10 @@ func (bw *blobWriter) validateBlob(ctx context.Context, desc distribution.Descri
 	return desc, nil
 }
 
-// moveBlob moves the data into its final, hash-qualified destination,
-// identified by dgst. The layer should be validated before commencing the
-// move.
-func (bw *blobWriter) moveBlob(ctx context.Context, desc distribution.Descriptor) error {
+// moveBlob moves the data at sourcePath into its final, hash-qualified
+// destination, identified by dgst. The layer should be validated before
+// commencing the move.
+func (bw *blobWriter) moveBlob(ctx context.Context, desc distribution.Descriptor, sourcePath string) error {
 	blobPath, err := pathFor(blobDataPathSpec{
 		digest: desc.Digest,
 	})
@@ -318,7 +456,7 @@ func (bw *blobWriter) moveBlob(ctx context.Context, desc distribution.Descriptor
 	// the size here and write a zero-length file to blobPath if this is the
 	// case. For the most part, this should only ever happen with zero-length
 	// blobs.
-	if _, err := bw.blobStore.driver.Stat(ctx, bw.path); err != nil {
+	if _, err := bw.blobStore.driver.Stat(ctx, sourcePath); err != nil {
 		switch err := err.(type) {
 		case storagedriver.PathNotFoundError:
 			// HACK(stevvooe): This is slightly dangerous: if we verify above,
@@ -327,7 +465,10 @@ func (bw *blobWriter) moveBlob(ctx context.Context, desc distribution.Descriptor
 			// prevent this horrid thing, we employ the hack of only allowing
 			// to this happen for the digest of an empty blob.
 			if desc.Digest == digestSha256Empty {
-				return bw.blobStore.driver.PutContent(ctx, blobPath, []byte{})
+				if err := bw.blobStore.driver.PutContent(ctx, blobPath, []byte{}); err != nil {
+					return err
+				}
+				return bw.blobStore.replicate(ctx, blobPath)
 			}
 
 			// We let this fail during the move below.
@@ -341,7 +482,10 @@ func (bw *blobWriter) moveBlob(ctx context.Context, desc distribution.Descriptor
 
 	// TODO(stevvooe): We should also write the mediatype when executing this move.
 
-	return bw.blobStore.driver.Move(ctx, bw.path, blobPath)
+	if err := bw.blobStore.driver.Move(ctx, sourcePath, blobPath); err != nil {
+		return err
+	}
+	return bw.blobStore.replicate(ctx, blobPath)
 }
 
 // removeResources should clean up all resources associated with the upload
@@ -349,7 +493,7 @@ func (bw *blobWriter) moveBlob(ctx context.Context, desc distribution.Descriptor
 // resources are already not present, no error will be returned.
 func (bw *blobWriter) removeResources(ctx context.Context) error {
 	dataPath, err := pathFor(uploadDataPathSpec{
-		name: bw.blobStore.repository.Named().Name(),
+		name: bw.blobStore.repository.pathName(),
 		id:   bw.id,
 	})
 	if err != nil {
@@ -0,0 +1,61 @@
+package cachecheck
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+)
+
+// CheckListingCache takes a listing cache implementation through a common
+// set of operations. This should be used for unit tests.
+func CheckListingCache(t *testing.T, listings cache.ListingCache) {
+	ctx := context.Background()
+
+	if _, ok, err := listings.Tags(ctx, "foo/bar"); err != nil || ok {
+		t.Fatalf("expected a miss with an empty cache: %t, %v", ok, err)
+	}
+	if _, ok, err := listings.CatalogPage(ctx, "n=10"); err != nil || ok {
+		t.Fatalf("expected a miss with an empty cache: %t, %v", ok, err)
+	}
+
+	tags := []string{"latest", "v1"}
+	page := cache.CatalogPage{Repositories: []string{"foo/bar", "foo/baz"}, More: true}
+	if err := listings.SetTags(ctx, "foo/bar", tags); err != nil {
+		t.Fatalf("unexpected error setting tags: %v", err)
+	}
+	if err := listings.SetTags(ctx, "foo/baz", tags); err != nil {
+		t.Fatalf("unexpected error setting tags: %v", err)
+	}
+	if err := listings.SetCatalogPage(ctx, "n=10", page); err != nil {
+		t.Fatalf("unexpected error setting catalog page: %v", err)
+	}
+
+	checkTags := func(repo string, expected []string) {
+		t.Helper()
+		cached, ok, err := listings.Tags(ctx, repo)
+		if err != nil {
+			t.Fatalf("unexpected error getting tags: %v", err)
+		}
+		if ok != (expected != nil) || (ok && !reflect.DeepEqual(cached, expected)) {
+			t.Fatalf("unexpected tags of %s: %v, %t", repo, cached, ok)
+		}
+	}
+	checkTags("foo/bar", tags)
+	cached, ok, err := listings.CatalogPage(ctx, "n=10")
+	if err != nil || !ok || !reflect.DeepEqual(cached, page) {
+		t.Fatalf("unexpected catalog page: %v, %t, %v", cached, ok, err)
+	}
+
+	// Invalidating a repository drops its tags, and the catalog pages, but
+	// not the tags of the other repositories.
+	if err := listings.Invalidate(ctx, "foo/bar"); err != nil {
+		t.Fatalf("unexpected error invalidating: %v", err)
+	}
+	checkTags("foo/bar", nil)
+	checkTags("foo/baz", tags)
+	if _, ok, err := listings.CatalogPage(ctx, "n=10"); err != nil || ok {
+		t.Fatalf("expected the catalog page to be invalidated: %t, %v", ok, err)
+	}
+}
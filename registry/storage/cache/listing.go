@@ -0,0 +1,40 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultListingTTL is how long listings are cached unless configured
+// otherwise.
+const DefaultListingTTL = 30 * time.Second
+
+// ListingCache caches the tags of repositories and the pages of the catalog,
+// for the time to live of the cache. The listings of a repository must be
+// invalidated when it is written to. A listing computed while the repository
+// is written to may still be cached stale, for the time to live at most.
+type ListingCache interface {
+	// Tags returns the cached tags of repo, and whether they were cached.
+	Tags(ctx context.Context, repo string) ([]string, bool, error)
+
+	// SetTags caches the tags of repo.
+	SetTags(ctx context.Context, repo string, tags []string) error
+
+	// CatalogPage returns the cached catalog page identified by key, and
+	// whether it was cached.
+	CatalogPage(ctx context.Context, key string) (CatalogPage, bool, error)
+
+	// SetCatalogPage caches the catalog page identified by key.
+	SetCatalogPage(ctx context.Context, key string, page CatalogPage) error
+
+	// Invalidate drops the cached tags of repo, and the cached catalog
+	// pages, which may list it.
+	Invalidate(ctx context.Context, repo string) error
+}
+
+// CatalogPage is a page of the catalog.
+type CatalogPage struct {
+	Repositories []string `json:"repositories"`
+	// More is set if more repositories follow the page.
+	More bool `json:"more,omitempty"`
+}
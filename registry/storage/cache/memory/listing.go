@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+	"github.com/hashicorp/golang-lru/arc/v2"
+)
+
+// DefaultListingSize is the default number of listings cached if no size is
+// explicitly configured.
+const DefaultListingSize = 1000
+
+type listingCacheEntry struct {
+	tags    []string
+	page    cache.CatalogPage
+	expires time.Time
+}
+
+// inMemoryListingCache caches listings in an ARC cache. The catalog pages
+// are keyed by a generation, incremented to invalidate them all: the pages of
+// the previous generations are never read again, and are evicted in time.
+type inMemoryListingCache struct {
+	lru        *arc.ARCCache[string, listingCacheEntry]
+	ttl        time.Duration
+	generation atomic.Uint64
+}
+
+// NewInMemoryListingCache returns a new cache of at most size listings, each
+// cached for ttl.
+func NewInMemoryListingCache(size int, ttl time.Duration) cache.ListingCache {
+	if size <= 0 {
+		size = DefaultListingSize
+	}
+	if ttl <= 0 {
+		ttl = cache.DefaultListingTTL
+	}
+	lruCache, err := arc.NewARC[string, listingCacheEntry](size)
+	if err != nil {
+		// NewARC can only fail if size is <= 0, so this unreachable
+		panic(err)
+	}
+	return &inMemoryListingCache{
+		lru: lruCache,
+		ttl: ttl,
+	}
+}
+
+func (imlc *inMemoryListingCache) get(key string) (listingCacheEntry, bool) {
+	entry, ok := imlc.lru.Get(key)
+	if !ok {
+		return listingCacheEntry{}, false
+	}
+	if time.Now().After(entry.expires) {
+		imlc.lru.Remove(key)
+		return listingCacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (imlc *inMemoryListingCache) tagsKey(repo string) string {
+	return "tags::" + repo
+}
+
+func (imlc *inMemoryListingCache) catalogKey(key string) string {
+	return "catalog::" + strconv.FormatUint(imlc.generation.Load(), 10) + "::" + key
+}
+
+func (imlc *inMemoryListingCache) Tags(ctx context.Context, repo string) ([]string, bool, error) {
+	entry, ok := imlc.get(imlc.tagsKey(repo))
+	return entry.tags, ok, nil
+}
+
+func (imlc *inMemoryListingCache) SetTags(ctx context.Context, repo string, tags []string) error {
+	imlc.lru.Add(imlc.tagsKey(repo), listingCacheEntry{tags: tags, expires: time.Now().Add(imlc.ttl)})
+	return nil
+}
+
+func (imlc *inMemoryListingCache) CatalogPage(ctx context.Context, key string) (cache.CatalogPage, bool, error) {
+	entry, ok := imlc.get(imlc.catalogKey(key))
+	return entry.page, ok, nil
+}
+
+func (imlc *inMemoryListingCache) SetCatalogPage(ctx context.Context, key string, page cache.CatalogPage) error {
+	imlc.lru.Add(imlc.catalogKey(key), listingCacheEntry{page: page, expires: time.Now().Add(imlc.ttl)})
+	return nil
+}
+
+func (imlc *inMemoryListingCache) Invalidate(ctx context.Context, repo string) error {
+	imlc.lru.Remove(imlc.tagsKey(repo))
+	imlc.generation.Add(1)
+	return nil
+}
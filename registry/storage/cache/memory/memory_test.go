@@ -1,7 +1,9 @@
 package memory
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3/registry/storage/cache/cachecheck"
 )
@@ -11,3 +13,18 @@ import (
 func TestInMemoryBlobInfoCache(t *testing.T) {
 	cachecheck.CheckBlobDescriptorCache(t, NewInMemoryBlobDescriptorCacheProvider(UnlimitedSize))
 }
+
+// TestInMemoryListingCache checks the in memory listing cache is working
+// correctly.
+func TestInMemoryListingCache(t *testing.T) {
+	cachecheck.CheckListingCache(t, NewInMemoryListingCache(DefaultListingSize, time.Minute))
+
+	listings := NewInMemoryListingCache(DefaultListingSize, time.Millisecond)
+	if err := listings.SetTags(context.Background(), "foo/bar", []string{"latest"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := listings.Tags(context.Background(), "foo/bar"); ok {
+		t.Fatal("expected the tags to expire")
+	}
+}
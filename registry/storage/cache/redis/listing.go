@@ -0,0 +1,106 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisListingCache caches listings in redis, as JSON values expiring after
+// the time to live of the cache. The keys of the catalog pages include a
+// generation, incremented to invalidate them all, so that the registries
+// sharing the redis instance agree on the valid pages.
+type redisListingCache struct {
+	pool *redis.Client
+	ttl  time.Duration
+}
+
+// NewRedisListingCache returns a new redis-based ListingCache using the
+// provided redis connection pool, caching listings for ttl.
+func NewRedisListingCache(pool *redis.Client, ttl time.Duration) cache.ListingCache {
+	if ttl <= 0 {
+		ttl = cache.DefaultListingTTL
+	}
+	return &redisListingCache{
+		pool: pool,
+		ttl:  ttl,
+	}
+}
+
+const catalogGenerationKey = "listing::catalog::generation"
+
+func (rlc *redisListingCache) tagsKey(repo string) string {
+	return "listing::tags::" + repo
+}
+
+func (rlc *redisListingCache) catalogKey(ctx context.Context, key string) (string, error) {
+	generation, err := rlc.pool.Get(ctx, catalogGenerationKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", err
+	}
+	return "listing::catalog::" + generation + "::" + key, nil
+}
+
+// get decodes the value of key into v, and reports whether it was found.
+func (rlc *redisListingCache) get(ctx context.Context, key string, v interface{}) (bool, error) {
+	p, err := rlc.pool.Get(ctx, key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := json.Unmarshal(p, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (rlc *redisListingCache) set(ctx context.Context, key string, v interface{}) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return rlc.pool.Set(ctx, key, p, rlc.ttl).Err()
+}
+
+func (rlc *redisListingCache) Tags(ctx context.Context, repo string) ([]string, bool, error) {
+	var tags []string
+	ok, err := rlc.get(ctx, rlc.tagsKey(repo), &tags)
+	return tags, ok, err
+}
+
+func (rlc *redisListingCache) SetTags(ctx context.Context, repo string, tags []string) error {
+	return rlc.set(ctx, rlc.tagsKey(repo), tags)
+}
+
+func (rlc *redisListingCache) CatalogPage(ctx context.Context, key string) (cache.CatalogPage, bool, error) {
+	var page cache.CatalogPage
+	catalogKey, err := rlc.catalogKey(ctx, key)
+	if err != nil {
+		return page, false, err
+	}
+	ok, err := rlc.get(ctx, catalogKey, &page)
+	return page, ok, err
+}
+
+func (rlc *redisListingCache) SetCatalogPage(ctx context.Context, key string, page cache.CatalogPage) error {
+	catalogKey, err := rlc.catalogKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	return rlc.set(ctx, catalogKey, page)
+}
+
+func (rlc *redisListingCache) Invalidate(ctx context.Context, repo string) error {
+	_, err := rlc.pool.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, rlc.tagsKey(repo))
+		pipe.Incr(ctx, catalogGenerationKey)
+		return nil
+	})
+	return err
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3/registry/storage/cache/cachecheck"
 	"github.com/redis/go-redis/v9"
@@ -48,3 +49,22 @@ func TestRedisBlobDescriptorCacheProvider(t *testing.T) {
 
 	cachecheck.CheckBlobDescriptorCache(t, NewRedisBlobDescriptorCacheProvider(pool))
 }
+
+// TestRedisListingCache exercises the listing cache against a live redis
+// instance.
+func TestRedisListingCache(t *testing.T) {
+	if redisAddr == "" {
+		redisAddr = os.Getenv("TEST_REGISTRY_STORAGE_CACHE_REDIS_ADDR")
+	}
+	if redisAddr == "" {
+		t.Skip("please set -test.registry.storage.cache.redis.addr to test the listing cache against redis")
+	}
+
+	pool := redis.NewClient(&redis.Options{Addr: redisAddr})
+	ctx := context.Background()
+	if err := pool.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("unexpected error flushing redis db: %v", err)
+	}
+
+	cachecheck.CheckListingCache(t, NewRedisListingCache(pool, time.Minute))
+}
@@ -5,8 +5,10 @@ import (
 	"errors"
 	"io"
 	"path"
+	"regexp"
 	"strings"
 
+	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
 )
@@ -22,14 +24,14 @@ func (reg *registry) Repositories(ctx context.Context, repos []string, last stri
 		return 0, errors.New("Attempted to list 0 repositories")
 	}
 
-	root, err := pathFor(repositoriesRootPathSpec{})
+	root, err := reg.repositoriesRoot()
 	if err != nil {
 		return 0, err
 	}
 
 	startAfter := ""
 	if last != "" {
-		startAfter, err = pathFor(manifestsPathSpec{name: last})
+		startAfter, err = pathFor(manifestsPathSpec{name: reg.repositoryPath(last)})
 		if err != nil {
 			return 0, err
 		}
@@ -69,21 +71,91 @@ func (reg *registry) Repositories(ctx context.Context, repos []string, last stri
 
 // Enumerate applies ingester to each repository
 func (reg *registry) Enumerate(ctx context.Context, ingester func(string) error) error {
-	root, err := pathFor(repositoriesRootPathSpec{})
+	err := reg.EnumerateFrom(ctx, "", ingester)
+	var interrupted distribution.ErrRepositoryEnumerationInterrupted
+	if errors.As(err, &interrupted) {
+		return interrupted.Err
+	}
+	return err
+}
+
+// EnumerateFrom applies ingester to each repository sorting lexically after
+// start. Errors are returned as an ErrRepositoryEnumerationInterrupted so
+// that the caller can resume the enumeration after the last repository
+// ingested.
+func (reg *registry) EnumerateFrom(ctx context.Context, start string, ingester func(string) error) error {
+	root, err := reg.repositoriesRoot()
 	if err != nil {
 		return err
 	}
 
+	options := []func(*driver.WalkOptions){driver.WithParallelism(reg.blobStore.walkParallelism)}
+	if start != "" {
+		startAfter, err := pathFor(manifestsPathSpec{name: reg.repositoryPath(start)})
+		if err != nil {
+			return err
+		}
+		options = append(options, driver.WithStartAfterHint(startAfter))
+	}
+
+	last := start
 	err = reg.blobStore.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
-		return handleRepository(fileInfo, root, "", ingester)
-	})
+		return handleRepository(fileInfo, root, start, func(repoPath string) error {
+			if err := ingester(repoPath); err != nil {
+				return err
+			}
+			last = repoPath
+			return nil
+		})
+	}, options...)
+	if err != nil {
+		return distribution.ErrRepositoryEnumerationInterrupted{Last: last, Err: err}
+	}
+	return nil
+}
+
+// EnumeratePrefix applies ingester to each repository whose name starts with
+// prefix and sorts after last. Only the directories which may hold such
+// repositories are walked.
+func (reg *registry) EnumeratePrefix(ctx context.Context, prefix, last string, ingester func(string) error) error {
+	root, err := reg.repositoriesRoot()
+	if err != nil {
+		return err
+	}
+
+	walkRoot := root
+	if dir, _ := path.Split(prefix); dir != "" {
+		walkRoot = path.Join(root, dir)
+	}
 
+	options := []func(*driver.WalkOptions){driver.WithParallelism(reg.blobStore.walkParallelism)}
+	if last != "" {
+		startAfter, err := pathFor(manifestsPathSpec{name: reg.repositoryPath(last)})
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(startAfter, walkRoot+"/") {
+			options = append(options, driver.WithStartAfterHint(startAfter))
+		}
+	}
+
+	err = reg.blobStore.driver.Walk(ctx, walkRoot, func(fileInfo driver.FileInfo) error {
+		repo := fileInfo.Path()[len(root)+1:]
+		if !strings.HasPrefix(repo, prefix) && !strings.HasPrefix(prefix, repo+"/") {
+			// Neither this path nor any path below it can match.
+			return driver.ErrSkipDir
+		}
+		return handleRepository(fileInfo, root, last, ingester)
+	}, options...)
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
 	return err
 }
 
 // Remove removes a repository from storage
 func (reg *registry) Remove(ctx context.Context, name reference.Named) error {
-	root, err := pathFor(repositoriesRootPathSpec{})
+	root, err := reg.repositoriesRoot()
 	if err != nil {
 		return err
 	}
@@ -91,6 +163,16 @@ func (reg *registry) Remove(ctx context.Context, name reference.Named) error {
 	return reg.driver.Delete(ctx, repoDir)
 }
 
+// repositoriesRoot returns the directory holding the repositories of the
+// registry namespace.
+func (reg *registry) repositoriesRoot() (string, error) {
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return "", err
+	}
+	return path.Join(root, reg.namespace), nil
+}
+
 // lessPath returns true if one path a is less than path b.
 //
 // A component-wise comparison is done, rather than the lexical comparison of
@@ -142,6 +224,12 @@ func compareReplaceInline(s1, s2 string, old, new byte) int {
 	return 0
 }
 
+// repositoryComponentRegexp matches the directory names which may be part
+// of a repository name: path components, and registry hosts with an
+// optional port. Other directories, such as the .snapshot directories of
+// some network filesystems, are not walked.
+var repositoryComponentRegexp = regexp.MustCompile(`^(?:\[[a-fA-F0-9:]+\]|[a-zA-Z0-9][a-zA-Z0-9._-]*)(?::[0-9]+)?$`)
+
 // handleRepository calls function fn with a repository path if fileInfo
 // has a path of a repository under root and that it is lexographically
 // after last. Otherwise, it will return ErrSkipDir or ErrFilledBuffer.
@@ -154,6 +242,9 @@ func handleRepository(fileInfo driver.FileInfo, root, last string, fn func(repoP
 	repo := filePath[len(root)+1:]
 
 	_, file := path.Split(repo)
+	if !fileInfo.IsDir() {
+		return nil
+	}
 	if file == "_manifests" {
 		repo = strings.TrimSuffix(repo, "/_manifests")
 		if lessPath(last, repo) {
@@ -162,7 +253,7 @@ func handleRepository(fileInfo driver.FileInfo, root, last string, fn func(repoP
 			}
 		}
 		return driver.ErrSkipDir
-	} else if strings.HasPrefix(file, "_") {
+	} else if strings.HasPrefix(file, "_") || !repositoryComponentRegexp.MatchString(file) {
 		return driver.ErrSkipDir
 	}
 
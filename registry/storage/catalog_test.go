@@ -2,9 +2,13 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
+	"path"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/distribution/distribution/v3"
@@ -197,6 +201,332 @@ func TestCatalogEnumerate(t *testing.T) {
 	}
 }
 
+func TestCatalogEnumerateWalkParallelism(t *testing.T) {
+	env := setupFS(t)
+	registry, err := NewRegistry(env.ctx, env.driver, WalkParallelism(4))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	var repos []string
+	err = registry.(distribution.RepositoryEnumerator).Enumerate(env.ctx, func(repoName string) error {
+		repos = append(repos, repoName)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error enumerating: %v", err)
+	}
+	if len(repos) != len(env.expected) || !testEq(repos, env.expected, len(env.expected)) {
+		t.Fatalf("unexpected repositories enumerated: %v != %v", repos, env.expected)
+	}
+}
+
+// listRecordingDriver records the directories listed by walks.
+type listRecordingDriver struct {
+	driver.StorageDriver
+	listed []string
+}
+
+func (d *listRecordingDriver) List(ctx context.Context, path string) ([]string, error) {
+	d.listed = append(d.listed, path)
+	return d.StorageDriver.List(ctx, path)
+}
+
+func (d *listRecordingDriver) Walk(ctx context.Context, path string, f driver.WalkFn, options ...func(*driver.WalkOptions)) error {
+	return driver.WalkFallback(ctx, d, path, f, options...)
+}
+
+func TestCatalogEnumeratePrefix(t *testing.T) {
+	env := setupFS(t)
+	enumerator := env.registry.(distribution.RepositoryPrefixEnumerator)
+
+	for _, tc := range []struct {
+		prefix   string
+		last     string
+		expected []string
+	}{
+		{prefix: "", expected: env.expected},
+		{prefix: "foo", expected: []string{"foo/a", "foo/b", "foo/d/in", "foo-bar/a", "foo-bar/b"}},
+		{prefix: "foo/", expected: []string{"foo/a", "foo/b", "foo/d/in"}},
+		{prefix: "foo/d", expected: []string{"foo/d/in"}},
+		{prefix: "bar/d", expected: []string{"bar/d"}},
+		{prefix: "foo-", last: "foo-bar/a", expected: []string{"foo-bar/b"}},
+		{prefix: "foo/", last: "bar/c", expected: []string{"foo/a", "foo/b", "foo/d/in"}},
+		{prefix: "missing/", expected: nil},
+	} {
+		var repos []string
+		err := enumerator.EnumeratePrefix(env.ctx, tc.prefix, tc.last, func(repoName string) error {
+			repos = append(repos, repoName)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error enumerating %q: %v", tc.prefix, err)
+		}
+		if !reflect.DeepEqual(repos, tc.expected) {
+			t.Errorf("unexpected repositories for prefix %q after %q: %v != %v", tc.prefix, tc.last, repos, tc.expected)
+		}
+	}
+
+	// The enumeration stops once the ingester has enough entries.
+	var repos []string
+	err := enumerator.EnumeratePrefix(env.ctx, "bar/", "", func(repoName string) error {
+		repos = append(repos, repoName)
+		if len(repos) == 2 {
+			return driver.ErrFilledBuffer
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error enumerating: %v", err)
+	}
+	if !reflect.DeepEqual(repos, []string{"bar/c", "bar/d"}) {
+		t.Errorf("unexpected repositories: %v", repos)
+	}
+}
+
+func TestCatalogEnumeratePrefixWalksPrefixOnly(t *testing.T) {
+	ctx := context.Background()
+	d := &listRecordingDriver{StorageDriver: inmemory.New()}
+	registry, err := NewRegistry(ctx, d, BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)), EnableRedirect)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	for _, repo := range []string{"bar/a", "foo/a", "foo/b", "foobar/a"} {
+		makeRepo(ctx, t, repo, registry)
+	}
+
+	d.listed = nil
+	var repos []string
+	err = registry.(distribution.RepositoryPrefixEnumerator).EnumeratePrefix(ctx, "foo/", "", func(repoName string) error {
+		repos = append(repos, repoName)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error enumerating: %v", err)
+	}
+	if !reflect.DeepEqual(repos, []string{"foo/a", "foo/b"}) {
+		t.Errorf("unexpected repositories: %v", repos)
+	}
+	for _, listed := range d.listed {
+		if strings.Contains(listed, "/repositories/bar") || strings.Contains(listed, "/repositories/foobar") {
+			t.Errorf("unexpected listing of %s", listed)
+		}
+	}
+}
+
+// flakyListDriver fails the first listing of the directories in failures.
+type flakyListDriver struct {
+	driver.StorageDriver
+	failures map[string]bool
+	listed   []string
+}
+
+func (d *flakyListDriver) List(ctx context.Context, path string) ([]string, error) {
+	d.listed = append(d.listed, path)
+	if d.failures[path] {
+		delete(d.failures, path)
+		return nil, fmt.Errorf("transient error listing %s", path)
+	}
+	return d.StorageDriver.List(ctx, path)
+}
+
+func (d *flakyListDriver) Walk(ctx context.Context, path string, f driver.WalkFn, options ...func(*driver.WalkOptions)) error {
+	return driver.WalkFallback(ctx, d, path, f, options...)
+}
+
+func setupFlakyEnv(t *testing.T) (*setupEnv, *flakyListDriver) {
+	env := setupFS(t)
+	d := &flakyListDriver{StorageDriver: env.driver, failures: map[string]bool{}}
+	registry, err := NewRegistry(env.ctx, d, BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)), EnableRedirect)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	env.registry = registry
+	return env, d
+}
+
+func TestCatalogEnumerateFromResumes(t *testing.T) {
+	env, d := setupFlakyEnv(t)
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.failures[path.Join(root, "foo")] = true
+	d.failures[path.Join(root, "foo-bar")] = true
+	enumerator := env.registry.(distribution.RepositoryEnumerator)
+
+	var repos []string
+	ingester := func(repoName string) error {
+		repos = append(repos, repoName)
+		return nil
+	}
+
+	start := ""
+	var checkpoints []string
+	for {
+		err := enumerator.EnumerateFrom(env.ctx, start, ingester)
+		if err == nil {
+			break
+		}
+		var interrupted distribution.ErrRepositoryEnumerationInterrupted
+		if !errors.As(err, &interrupted) {
+			t.Fatalf("unexpected error type %T: %v", err, err)
+		}
+		if len(checkpoints) > 2 {
+			t.Fatalf("enumeration does not make progress: %v", checkpoints)
+		}
+		checkpoints = append(checkpoints, interrupted.Last)
+		start = interrupted.Last
+	}
+
+	if !reflect.DeepEqual(checkpoints, []string{"bar/e", "foo/d/in"}) {
+		t.Errorf("unexpected checkpoints: %v", checkpoints)
+	}
+	if !reflect.DeepEqual(repos, env.expected) {
+		t.Errorf("unexpected repositories: %v != %v", repos, env.expected)
+	}
+}
+
+func TestCatalogEnumerateFromIngesterError(t *testing.T) {
+	env := setupFS(t)
+	enumerator := env.registry.(distribution.RepositoryEnumerator)
+
+	failure := errors.New("ingester failure")
+	err := enumerator.EnumerateFrom(env.ctx, "bar/c", func(repoName string) error {
+		if repoName == "foo/a" {
+			return failure
+		}
+		return nil
+	})
+	var interrupted distribution.ErrRepositoryEnumerationInterrupted
+	if !errors.As(err, &interrupted) || !strings.Contains(err.Error(), failure.Error()) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if interrupted.Last != "bar/e" {
+		t.Errorf("unexpected last repository: %q", interrupted.Last)
+	}
+
+	// Enumerate does not wrap the error.
+	err = enumerator.Enumerate(env.ctx, func(repoName string) error {
+		return failure
+	})
+	if err == nil || errors.As(err, &interrupted) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCatalogSkipsForeignDirectories(t *testing.T) {
+	env, d := setupFlakyEnv(t)
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A copy of a repository in a snapshot directory must not be walked.
+	snapshot := path.Join(root, ".snapshot", "foo")
+	if err := env.driver.PutContent(env.ctx, path.Join(snapshot, "_manifests", "tags", "latest", "current", "link"), []byte("sha256:abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	var repos []string
+	err = env.registry.(distribution.RepositoryEnumerator).Enumerate(env.ctx, func(repoName string) error {
+		repos = append(repos, repoName)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error enumerating: %v", err)
+	}
+	if !reflect.DeepEqual(repos, env.expected) {
+		t.Errorf("unexpected repositories: %v", repos)
+	}
+	for _, listed := range d.listed {
+		if strings.Contains(listed, ".snapshot") {
+			t.Errorf("unexpected listing of %s", listed)
+		}
+	}
+}
+
+func TestCatalogNamespacePrefix(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	newRegistry := func(options ...RegistryOption) distribution.Namespace {
+		options = append(options, EnableDelete, EnableRedirect)
+		reg, err := NewRegistry(ctx, d, options...)
+		if err != nil {
+			t.Fatalf("error creating registry: %v", err)
+		}
+		return reg
+	}
+	teamA := newRegistry(WithNamespacePrefix("team-a/"))
+	teamB := newRegistry(WithNamespacePrefix("team-b"))
+	global := newRegistry()
+
+	makeRepo(ctx, t, "foo", teamA)
+	makeRepo(ctx, t, "bar/baz", teamA)
+	makeRepo(ctx, t, "foo", teamB)
+
+	for _, tc := range []struct {
+		reg      distribution.Namespace
+		expected []string
+	}{
+		{reg: teamA, expected: []string{"bar/baz", "foo"}},
+		{reg: teamB, expected: []string{"foo"}},
+		{reg: global, expected: []string{"team-a/bar/baz", "team-a/foo", "team-b/foo"}},
+	} {
+		var repos []string
+		err := tc.reg.(distribution.RepositoryEnumerator).Enumerate(ctx, func(repoName string) error {
+			repos = append(repos, repoName)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error enumerating repositories: %v", err)
+		}
+		if !reflect.DeepEqual(repos, tc.expected) {
+			t.Errorf("unexpected repositories: %v != %v", repos, tc.expected)
+		}
+
+		p := make([]string, 10)
+		n, err := tc.reg.Repositories(ctx, p, "")
+		if err != io.EOF {
+			t.Fatalf("unexpected error listing repositories: %v", err)
+		}
+		if !reflect.DeepEqual(p[:n], tc.expected) {
+			t.Errorf("unexpected repositories: %v != %v", p[:n], tc.expected)
+		}
+	}
+
+	// Repositories of other namespaces are isolated but blobs are shared.
+	named, _ := reference.WithName("foo")
+	repoA, err := teamA.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoB, err := teamB.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("shared layer")
+	desc, err := repoA.Blobs(ctx).Put(ctx, "application/octet-stream", content)
+	if err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+	if _, err := repoB.Blobs(ctx).Stat(ctx, desc.Digest); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected blob to be unknown in other namespace, got %v", err)
+	}
+	if _, err := repoB.Blobs(ctx).Put(ctx, "application/octet-stream", content); err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+	if _, err := global.BlobStatter().Stat(ctx, desc.Digest); err != nil {
+		t.Fatalf("unexpected error stating shared blob: %v", err)
+	}
+
+	if err := teamA.(distribution.RepositoryRemover).Remove(ctx, named); err != nil {
+		t.Fatalf("unexpected error removing repository: %v", err)
+	}
+	if _, err := repoB.Blobs(ctx).Stat(ctx, desc.Digest); err != nil {
+		t.Fatalf("unexpected error stating blob in other namespace: %v", err)
+	}
+}
+
 func testEq(a, b []string, size int) bool {
 	for cnt := 0; cnt < size-1; cnt++ {
 		if a[cnt] != b[cnt] {
@@ -6,6 +6,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -16,6 +17,7 @@ import (
 	"github.com/distribution/distribution/v3/registry/storage/driver/base"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
@@ -303,7 +305,7 @@ func (d *driver) List(ctx context.Context, path string) ([]string, error) {
 // Move moves an object stored at sourcePath to destPath, removing the original
 // object.
 func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
-	sourceBlobURL, err := d.signBlobURL(ctx, sourcePath)
+	sourceBlobURL, err := d.signBlobURL(ctx, sourcePath, storagedriver.RedirectHeaders{})
 	if err != nil {
 		return err
 	}
@@ -391,14 +393,21 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 // for specified duration by making use of Azure Storage Shared Access Signatures (SAS).
 // See https://msdn.microsoft.com/en-us/library/azure/ee395415.aspx for more info.
 func (d *driver) RedirectURL(req *http.Request, path string) (string, error) {
-	return d.signBlobURL(req.Context(), path)
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return "", nil
+	}
+	var headers storagedriver.RedirectHeaders
+	if req.Method == http.MethodGet {
+		headers = storagedriver.GetRedirectHeaders(req)
+	}
+	return d.signBlobURL(req.Context(), path, headers)
 }
 
-func (d *driver) signBlobURL(ctx context.Context, path string) (string, error) {
+func (d *driver) signBlobURL(ctx context.Context, path string, headers storagedriver.RedirectHeaders) (string, error) {
 	expiresTime := time.Now().UTC().Add(20 * time.Minute) // default expiration
 	blobName := d.blobName(path)
 	blobRef := d.client.NewBlobClient(blobName)
-	return d.azClient.SignBlobURL(ctx, blobRef.URL(), expiresTime)
+	return d.azClient.SignBlobURL(ctx, blobRef.URL(), expiresTime, headers)
 }
 
 // Walk traverses a filesystem defined within driver, starting
@@ -501,6 +510,30 @@ func is404(err error) bool {
 	)
 }
 
+// CategorizeError implements storagedriver.ErrorCategorizer, telling the
+// requests Azure throttled, such as with a ServerBusy error, which timed
+// out, or which failed with a server error.
+func (d *driver) CategorizeError(err error) storagedriver.ErrorCategory {
+	switch {
+	case bloberror.HasCode(err, bloberror.ServerBusy):
+		return storagedriver.ErrorCategoryThrottled
+	case bloberror.HasCode(err, bloberror.OperationTimedOut):
+		return storagedriver.ErrorCategoryTimeout
+	}
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return storagedriver.ErrorCategoryThrottled
+		case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+			return storagedriver.ErrorCategoryTimeout
+		case http.StatusInternalServerError, http.StatusBadGateway:
+			return storagedriver.ErrorCategoryUnavailable
+		}
+	}
+	return storagedriver.ErrorCategoryPermanent
+}
+
 var _ storagedriver.FileWriter = &writer{}
 
 type writer struct {
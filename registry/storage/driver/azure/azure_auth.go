@@ -2,6 +2,7 @@ package azure
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 )
 
 const (
@@ -65,14 +67,8 @@ func newAzureClient(params *Parameters) (*azureClient, error) {
 		}, nil
 	}
 
-	var cred azcore.TokenCredential
-	var err error
-	if params.Credentials.Type == "client_secret" {
-		creds := &params.Credentials
-		if cred, err = azidentity.NewClientSecretCredential(creds.TenantID, creds.ClientID, creds.Secret, nil); err != nil {
-			return nil, err
-		}
-	} else if cred, err = azidentity.NewDefaultAzureCredential(nil); err != nil {
+	cred, err := newTokenCredential(&params.Credentials)
+	if err != nil {
 		return nil, err
 	}
 
@@ -91,11 +87,39 @@ func newAzureClient(params *Parameters) (*azureClient, error) {
 	}, nil
 }
 
+// newTokenCredential constructs the Azure AD credential of the given type.
+// The tokens it issues are refreshed by the client before they expire, so
+// long running uploads and downloads keep being authorized.
+func newTokenCredential(creds *Credentials) (azcore.TokenCredential, error) {
+	switch creds.Type {
+	case "", credentialsDefault:
+		return azidentity.NewDefaultAzureCredential(nil)
+	case credentialsClientSecret, credentialsClientSecretLegacy:
+		return azidentity.NewClientSecretCredential(creds.TenantID, creds.ClientID, creds.Secret, nil)
+	case credentialsManagedIdentity:
+		var options azidentity.ManagedIdentityCredentialOptions
+		if creds.ClientID != "" {
+			options.ID = azidentity.ClientID(creds.ClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(&options)
+	case credentialsWorkloadIdentity:
+		// Unset fields default to the environment set up by the AKS workload
+		// identity webhook.
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientID:      creds.ClientID,
+			TenantID:      creds.TenantID,
+			TokenFilePath: creds.TokenFile,
+		})
+	default:
+		return nil, fmt.Errorf("unsupported credentials type: %s", creds.Type)
+	}
+}
+
 func (a *azureClient) ContainerClient() *container.Client {
 	return a.client.ServiceClient().NewContainerClient(a.container)
 }
 
-func (a *azureClient) SignBlobURL(ctx context.Context, blobURL string, expires time.Time) (string, error) {
+func (a *azureClient) SignBlobURL(ctx context.Context, blobURL string, expires time.Time, headers storagedriver.RedirectHeaders) (string, error) {
 	urlParts, err := sas.ParseURL(blobURL)
 	if err != nil {
 		return "", err
@@ -108,6 +132,9 @@ func (a *azureClient) SignBlobURL(ctx context.Context, blobURL string, expires t
 		Permissions:   perms.String(),
 		ContainerName: urlParts.ContainerName,
 		BlobName:      urlParts.BlobName,
+
+		CacheControl:       headers.CacheControl,
+		ContentDisposition: headers.ContentDisposition,
 	}
 	urlParts.SAS, err = a.signer.Sign(ctx, &signatureValues)
 	if err != nil {
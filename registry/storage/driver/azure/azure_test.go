@@ -2,11 +2,17 @@ package azure
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
 )
@@ -154,6 +160,8 @@ func TestParamParsing(t *testing.T) {
 	expectErrors := []map[string]interface{}{
 		{},
 		{"accountname": "acc1"},
+		{"accountname": "acc1", "container": "c1", "credentials": map[string]interface{}{"type": "certificate"}},
+		{"accountname": "acc1", "container": "c1", "credentials": map[string]interface{}{"type": "client-secret", "clientid": "c1", "tenantid": "t1"}},
 	}
 	for _, parameters := range expectErrors {
 		if _, err := NewParameters(parameters); err == nil {
@@ -164,6 +172,7 @@ func TestParamParsing(t *testing.T) {
 		{"accountname": "acc1", "accountkey": "k1", "container": "c1", "copy_status_poll_max_retry": 1, "copy_status_poll_delay": "10ms"},
 		{"accountname": "acc1", "container": "c1", "credentials": map[string]interface{}{"type": "default"}},
 		{"accountname": "acc1", "container": "c1", "credentials": map[string]interface{}{"type": "client_secret", "clientid": "c1", "tenantid": "t1", "secret": "s1"}},
+		{"accountname": "acc1", "container": "c1", "credentials": map[string]interface{}{"type": "workload-identity", "clientid": "c1", "tenantid": "t1", "tokenfile": "/token"}},
 	}
 	expecteds := []Parameters{
 		{
@@ -182,6 +191,12 @@ func TestParamParsing(t *testing.T) {
 			Realm:       "core.windows.net", ServiceURL: "https://acc1.blob.core.windows.net",
 			CopyStatusPollMaxRetry: 5, CopyStatusPollDelay: "100ms",
 		},
+		{
+			Container: "c1", AccountName: "acc1",
+			Credentials: Credentials{Type: "workload-identity", ClientID: "c1", TenantID: "t1", TokenFile: "/token"},
+			Realm:       "core.windows.net", ServiceURL: "https://acc1.blob.core.windows.net",
+			CopyStatusPollMaxRetry: 5, CopyStatusPollDelay: "100ms",
+		},
 	}
 	for i, expected := range expecteds {
 		actual, err := NewParameters(input[i])
@@ -193,3 +208,63 @@ func TestParamParsing(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenCredential(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		credentials Credentials
+		expected    interface{}
+	}{
+		{Credentials{}, &azidentity.DefaultAzureCredential{}},
+		{Credentials{Type: "default"}, &azidentity.DefaultAzureCredential{}},
+		{Credentials{Type: "client-secret", ClientID: "c1", TenantID: "t1", Secret: "s1"}, &azidentity.ClientSecretCredential{}},
+		{Credentials{Type: "client_secret", ClientID: "c1", TenantID: "t1", Secret: "s1"}, &azidentity.ClientSecretCredential{}},
+		{Credentials{Type: "managed-identity"}, &azidentity.ManagedIdentityCredential{}},
+		{Credentials{Type: "managed-identity", ClientID: "c1"}, &azidentity.ManagedIdentityCredential{}},
+		{Credentials{Type: "workload-identity", ClientID: "c1", TenantID: "t1", TokenFile: tokenFile}, &azidentity.WorkloadIdentityCredential{}},
+	} {
+		cred, err := newTokenCredential(&tc.credentials)
+		if err != nil {
+			t.Fatalf("%+v: unexpected error: %v", tc.credentials, err)
+		}
+		if actual, expected := fmt.Sprintf("%T", cred), fmt.Sprintf("%T", tc.expected); actual != expected {
+			t.Errorf("%+v: expected a %s, got a %s", tc.credentials, expected, actual)
+		}
+	}
+
+	// The workload identity defaults to the environment set up by the AKS
+	// webhook.
+	t.Setenv("AZURE_CLIENT_ID", "c1")
+	t.Setenv("AZURE_TENANT_ID", "t1")
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", tokenFile)
+	if _, err := newTokenCredential(&Credentials{Type: "workload-identity"}); err != nil {
+		t.Fatalf("unexpected error creating workload identity from the environment: %v", err)
+	}
+
+	if _, err := newTokenCredential(&Credentials{Type: "certificate"}); err == nil {
+		t.Fatal("expected an error for an unsupported credentials type")
+	}
+}
+
+func TestCategorizeError(t *testing.T) {
+	d := &driver{}
+	for i, tc := range []struct {
+		err      error
+		expected storagedriver.ErrorCategory
+	}{
+		{&azcore.ResponseError{StatusCode: http.StatusServiceUnavailable, ErrorCode: string(bloberror.ServerBusy)}, storagedriver.ErrorCategoryThrottled},
+		{&azcore.ResponseError{StatusCode: http.StatusTooManyRequests}, storagedriver.ErrorCategoryThrottled},
+		{&azcore.ResponseError{StatusCode: http.StatusInternalServerError, ErrorCode: string(bloberror.OperationTimedOut)}, storagedriver.ErrorCategoryTimeout},
+		{&azcore.ResponseError{StatusCode: http.StatusInternalServerError, ErrorCode: string(bloberror.InternalError)}, storagedriver.ErrorCategoryUnavailable},
+		{&azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: string(bloberror.BlobNotFound)}, storagedriver.ErrorCategoryPermanent},
+		{fmt.Errorf("error"), storagedriver.ErrorCategoryPermanent},
+	} {
+		if category := d.CategorizeError(tc.err); category != tc.expected {
+			t.Errorf("case %d: unexpected category: %v != %v", i, category, tc.expected)
+		}
+	}
+}
@@ -13,11 +13,21 @@ const (
 	defaultCopyStatusPollDelay    = "100ms"
 )
 
+// Types of the Azure AD credentials used when no account key is provided.
+const (
+	credentialsDefault            = "default"
+	credentialsClientSecret       = "client-secret"
+	credentialsClientSecretLegacy = "client_secret"
+	credentialsManagedIdentity    = "managed-identity"
+	credentialsWorkloadIdentity   = "workload-identity"
+)
+
 type Credentials struct {
-	Type     string `mapstructure:"type"`
-	ClientID string `mapstructure:"clientid"`
-	TenantID string `mapstructure:"tenantid"`
-	Secret   string `mapstructure:"secret"`
+	Type      string `mapstructure:"type"`
+	ClientID  string `mapstructure:"clientid"`
+	TenantID  string `mapstructure:"tenantid"`
+	Secret    string `mapstructure:"secret"`
+	TokenFile string `mapstructure:"tokenfile"`
 }
 
 type Parameters struct {
@@ -46,6 +56,15 @@ func NewParameters(parameters map[string]interface{}) (*Parameters, error) {
 	if params.Container == "" {
 		return nil, errors.New("no container parameter provider")
 	}
+	switch params.Credentials.Type {
+	case "", credentialsDefault, credentialsManagedIdentity, credentialsWorkloadIdentity:
+	case credentialsClientSecret, credentialsClientSecretLegacy:
+		if params.Credentials.ClientID == "" || params.Credentials.TenantID == "" || params.Credentials.Secret == "" {
+			return nil, errors.New("client-secret credentials require clientid, tenantid and secret")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported credentials type: %s", params.Credentials.Type)
+	}
 	if params.ServiceURL == "" {
 		params.ServiceURL = fmt.Sprintf("https://%s.blob.%s", params.AccountName, params.Realm)
 	}
@@ -50,12 +50,15 @@ import (
 	"github.com/docker/go-metrics"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/trace"
 )
 
 // storageAction is the metrics of blob related operations
 var storageAction = prometheus.StorageNamespace.NewLabeledTimer("action", "The number of seconds that the storage action takes", "driver", "action")
 
+// storageErrors counts the errors of the storage actions by category, telling
+// the throttling of the backend from other failures.
+var storageErrors = prometheus.StorageNamespace.NewLabeledCounter("errors", "The number of errors of the storage actions", "driver", "action", "category")
+
 // tracer is the OpenTelemetry tracer utilized for tracing operations within
 // this package's code.
 var tracer = otel.Tracer("github.com/distribution/distribution/v3/registry/storage/driver/base")
@@ -70,11 +73,21 @@ type Base struct {
 	storagedriver.StorageDriver
 }
 
-// Format errors received from the storage driver
-func (base *Base) setDriverName(e error) error {
-	switch actual := e.(type) {
-	case nil:
+// Format errors received from the storage driver for action, annotating the
+// errors of its backend with their category, and count them by category.
+func (base *Base) setDriverName(action string, e error) error {
+	if e == nil {
 		return nil
+	}
+	err := base.formatError(e)
+	storageErrors.WithValues(base.Name(), action, storagedriver.CategoryOf(err).String()).Inc(1)
+	return err
+}
+
+// formatError sets the driver name of e, wrapping the errors of the backend
+// in a storagedriver.Error.
+func (base *Base) formatError(e error) error {
+	switch actual := e.(type) {
 	case storagedriver.ErrUnsupportedMethod:
 		actual.DriverName = base.StorageDriver.Name()
 		return actual
@@ -87,7 +100,18 @@ func (base *Base) setDriverName(e error) error {
 	case storagedriver.InvalidOffsetError:
 		actual.DriverName = base.StorageDriver.Name()
 		return actual
+	case storagedriver.QuotaExceededError:
+		actual.DriverName = base.StorageDriver.Name()
+		return actual
+	case storagedriver.ReadOnlyError:
+		actual.DriverName = base.StorageDriver.Name()
+		return actual
 	default:
+		if categorizer, ok := base.StorageDriver.(storagedriver.ErrorCategorizer); ok {
+			if category := categorizer.CategorizeError(e); category != storagedriver.ErrorCategoryPermanent {
+				e = storagedriver.WithCategory(e, category)
+			}
+		}
 		return storagedriver.Error{
 			DriverName: base.StorageDriver.Name(),
 			Detail:     e,
@@ -97,16 +121,14 @@ func (base *Base) setDriverName(e error) error {
 
 // GetContent wraps GetContent of underlying storage driver.
 func (base *Base) GetContent(ctx context.Context, path string) ([]byte, error) {
-	attrs := []attribute.KeyValue{
-		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
-		attribute.String(tracing.AttributePrefix+"storage.path", path),
-	}
-	ctx, span := tracer.Start(
-		ctx,
-		"GetContent",
-		trace.WithAttributes(attrs...))
-
+	ctx, span := tracing.StartSpan(ctx, tracer, "GetContent")
 	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+			attribute.String(tracing.AttributePrefix+"storage.path", path),
+		)
+	}
 
 	if !storagedriver.PathRegexp.MatchString(path) {
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
@@ -115,46 +137,42 @@ func (base *Base) GetContent(ctx context.Context, path string) ([]byte, error) {
 	start := time.Now()
 	b, e := base.StorageDriver.GetContent(ctx, path)
 	storageAction.WithValues(base.Name(), "GetContent").UpdateSince(start)
-	return b, base.setDriverName(e)
+	return b, base.setDriverName("GetContent", e)
 }
 
 // PutContent wraps PutContent of underlying storage driver.
 func (base *Base) PutContent(ctx context.Context, path string, content []byte) error {
-	attrs := []attribute.KeyValue{
-		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
-		attribute.String(tracing.AttributePrefix+"storage.path", path),
-		attribute.Int(tracing.AttributePrefix+"storage.content.length", len(content)),
-	}
-	ctx, span := tracer.Start(
-		ctx,
-		"PutContent",
-		trace.WithAttributes(attrs...))
-
+	ctx, span := tracing.StartSpan(ctx, tracer, "PutContent")
 	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+			attribute.String(tracing.AttributePrefix+"storage.path", path),
+			attribute.Int(tracing.AttributePrefix+"storage.content.length", len(content)),
+		)
+	}
 
 	if !storagedriver.PathRegexp.MatchString(path) {
 		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
 	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.PutContent(ctx, path, content))
+	err := base.setDriverName("PutContent", base.StorageDriver.PutContent(ctx, path, content))
 	storageAction.WithValues(base.Name(), "PutContent").UpdateSince(start)
 	return err
 }
 
 // Reader wraps Reader of underlying storage driver.
 func (base *Base) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
-	attrs := []attribute.KeyValue{
-		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
-		attribute.String(tracing.AttributePrefix+"storage.path", path),
-		attribute.Int64(tracing.AttributePrefix+"storage.offset", offset),
-	}
-	ctx, span := tracer.Start(
-		ctx,
-		"Reader",
-		trace.WithAttributes(attrs...))
-
+	ctx, span := tracing.StartSpan(ctx, tracer, "Reader")
 	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+			attribute.String(tracing.AttributePrefix+"storage.path", path),
+			attribute.Int64(tracing.AttributePrefix+"storage.offset", offset),
+		)
+	}
 
 	if offset < 0 {
 		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: base.StorageDriver.Name()}
@@ -165,43 +183,39 @@ func (base *Base) Reader(ctx context.Context, path string, offset int64) (io.Rea
 	}
 
 	rc, e := base.StorageDriver.Reader(ctx, path, offset)
-	return rc, base.setDriverName(e)
+	return rc, base.setDriverName("Reader", e)
 }
 
 // Writer wraps Writer of underlying storage driver.
 func (base *Base) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
-	attrs := []attribute.KeyValue{
-		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
-		attribute.String(tracing.AttributePrefix+"storage.path", path),
-		attribute.Bool(tracing.AttributePrefix+"storage.append", append),
-	}
-	ctx, span := tracer.Start(
-		ctx,
-		"Writer",
-		trace.WithAttributes(attrs...))
-
+	ctx, span := tracing.StartSpan(ctx, tracer, "Writer")
 	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+			attribute.String(tracing.AttributePrefix+"storage.path", path),
+			attribute.Bool(tracing.AttributePrefix+"storage.append", append),
+		)
+	}
 
 	if !storagedriver.PathRegexp.MatchString(path) {
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
 	writer, e := base.StorageDriver.Writer(ctx, path, append)
-	return writer, base.setDriverName(e)
+	return writer, base.setDriverName("Writer", e)
 }
 
 // Stat wraps Stat of underlying storage driver.
 func (base *Base) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
-	attrs := []attribute.KeyValue{
-		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
-		attribute.String(tracing.AttributePrefix+"storage.path", path),
-	}
-	ctx, span := tracer.Start(
-		ctx,
-		"Stat",
-		trace.WithAttributes(attrs...))
-
+	ctx, span := tracing.StartSpan(ctx, tracer, "Stat")
 	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+			attribute.String(tracing.AttributePrefix+"storage.path", path),
+		)
+	}
 
 	if !storagedriver.PathRegexp.MatchString(path) && path != "/" {
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
@@ -210,21 +224,19 @@ func (base *Base) Stat(ctx context.Context, path string) (storagedriver.FileInfo
 	start := time.Now()
 	fi, e := base.StorageDriver.Stat(ctx, path)
 	storageAction.WithValues(base.Name(), "Stat").UpdateSince(start)
-	return fi, base.setDriverName(e)
+	return fi, base.setDriverName("Stat", e)
 }
 
 // List wraps List of underlying storage driver.
 func (base *Base) List(ctx context.Context, path string) ([]string, error) {
-	attrs := []attribute.KeyValue{
-		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
-		attribute.String(tracing.AttributePrefix+"storage.path", path),
-	}
-	ctx, span := tracer.Start(
-		ctx,
-		"List",
-		trace.WithAttributes(attrs...))
-
+	ctx, span := tracing.StartSpan(ctx, tracer, "List")
 	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+			attribute.String(tracing.AttributePrefix+"storage.path", path),
+		)
+	}
 
 	if !storagedriver.PathRegexp.MatchString(path) && path != "/" {
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
@@ -233,22 +245,20 @@ func (base *Base) List(ctx context.Context, path string) ([]string, error) {
 	start := time.Now()
 	str, e := base.StorageDriver.List(ctx, path)
 	storageAction.WithValues(base.Name(), "List").UpdateSince(start)
-	return str, base.setDriverName(e)
+	return str, base.setDriverName("List", e)
 }
 
 // Move wraps Move of underlying storage driver.
 func (base *Base) Move(ctx context.Context, sourcePath string, destPath string) error {
-	attrs := []attribute.KeyValue{
-		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
-		attribute.String(tracing.AttributePrefix+"storage.source.path", sourcePath),
-		attribute.String(tracing.AttributePrefix+"storage.dest.path", destPath),
-	}
-	ctx, span := tracer.Start(
-		ctx,
-		"Move",
-		trace.WithAttributes(attrs...))
-
+	ctx, span := tracing.StartSpan(ctx, tracer, "Move")
 	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+			attribute.String(tracing.AttributePrefix+"storage.source.path", sourcePath),
+			attribute.String(tracing.AttributePrefix+"storage.dest.path", destPath),
+		)
+	}
 
 	ctx, done := dcontext.WithTrace(ctx)
 	defer done("%s.Move(%q, %q", base.Name(), sourcePath, destPath)
@@ -260,46 +270,42 @@ func (base *Base) Move(ctx context.Context, sourcePath string, destPath string)
 	}
 
 	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.Move(ctx, sourcePath, destPath))
+	err := base.setDriverName("Move", base.StorageDriver.Move(ctx, sourcePath, destPath))
 	storageAction.WithValues(base.Name(), "Move").UpdateSince(start)
 	return err
 }
 
 // Delete wraps Delete of underlying storage driver.
 func (base *Base) Delete(ctx context.Context, path string) error {
-	attrs := []attribute.KeyValue{
-		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
-		attribute.String(tracing.AttributePrefix+"storage.path", path),
-	}
-	ctx, span := tracer.Start(
-		ctx,
-		"Delete",
-		trace.WithAttributes(attrs...))
-
+	ctx, span := tracing.StartSpan(ctx, tracer, "Delete")
 	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+			attribute.String(tracing.AttributePrefix+"storage.path", path),
+		)
+	}
 
 	if !storagedriver.PathRegexp.MatchString(path) {
 		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
 	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.Delete(ctx, path))
+	err := base.setDriverName("Delete", base.StorageDriver.Delete(ctx, path))
 	storageAction.WithValues(base.Name(), "Delete").UpdateSince(start)
 	return err
 }
 
 // RedirectURL wraps RedirectURL of the underlying storage driver.
 func (base *Base) RedirectURL(r *http.Request, path string) (string, error) {
-	attrs := []attribute.KeyValue{
-		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
-		attribute.String(tracing.AttributePrefix+"storage.path", path),
-	}
-	ctx, span := tracer.Start(
-		r.Context(),
-		"RedirectURL",
-		trace.WithAttributes(attrs...))
-
+	ctx, span := tracing.StartSpan(r.Context(), tracer, "RedirectURL")
 	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+			attribute.String(tracing.AttributePrefix+"storage.path", path),
+		)
+	}
 
 	if !storagedriver.PathRegexp.MatchString(path) {
 		return "", storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
@@ -308,25 +314,23 @@ func (base *Base) RedirectURL(r *http.Request, path string) (string, error) {
 	start := time.Now()
 	str, e := base.StorageDriver.RedirectURL(r.WithContext(ctx), path)
 	storageAction.WithValues(base.Name(), "RedirectURL").UpdateSince(start)
-	return str, base.setDriverName(e)
+	return str, base.setDriverName("RedirectURL", e)
 }
 
 // Walk wraps Walk of underlying storage driver.
 func (base *Base) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
-	attrs := []attribute.KeyValue{
-		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
-		attribute.String(tracing.AttributePrefix+"storage.path", path),
-	}
-	ctx, span := tracer.Start(
-		ctx,
-		"Walk",
-		trace.WithAttributes(attrs...))
-
+	ctx, span := tracing.StartSpan(ctx, tracer, "Walk")
 	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+			attribute.String(tracing.AttributePrefix+"storage.path", path),
+		)
+	}
 
 	if !storagedriver.PathRegexp.MatchString(path) && path != "/" {
 		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
-	return base.setDriverName(base.StorageDriver.Walk(ctx, path, f, options...))
+	return base.setDriverName("Walk", base.StorageDriver.Walk(ctx, path, f, options...))
 }
@@ -0,0 +1,105 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// nopDriver serves empty files, so that the cost of Base itself can be
+// measured.
+type nopDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (nopDriver) Name() string {
+	return "nop"
+}
+
+func (nopDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (nopDriver) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return nil
+}
+
+// TestTracingDisabledAllocs ensures that the spans around the calls of the
+// storage driver add no allocations while tracing is disabled.
+func TestTracingDisabledAllocs(t *testing.T) {
+	base := &Base{StorageDriver: nopDriver{}}
+	ctx := context.Background()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := base.Reader(ctx, "/path/to/file", 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := base.Walk(ctx, "/path/to", nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, got %v", allocs)
+	}
+}
+
+func BenchmarkReaderTracingDisabled(b *testing.B) {
+	base := &Base{StorageDriver: nopDriver{}}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := base.Reader(ctx, "/path/to/file", 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// throttledDriver fails the reads as if its backend throttled them.
+type throttledDriver struct {
+	nopDriver
+}
+
+var errSlowDown = errors.New("slow down")
+
+func (throttledDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	return nil, errSlowDown
+}
+
+func (throttledDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	return nil, storagedriver.PathNotFoundError{Path: path}
+}
+
+func (throttledDriver) CategorizeError(err error) storagedriver.ErrorCategory {
+	if err == errSlowDown {
+		return storagedriver.ErrorCategoryThrottled
+	}
+	return storagedriver.ErrorCategoryPermanent
+}
+
+// TestErrorCategories ensures that the errors of the backend are annotated
+// with the category told by the driver.
+func TestErrorCategories(t *testing.T) {
+	base := &Base{StorageDriver: throttledDriver{}}
+	ctx := context.Background()
+
+	_, err := base.GetContent(ctx, "/path/to/file")
+	var driverErr storagedriver.Error
+	if !errors.As(err, &driverErr) || driverErr.DriverName != "nop" {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	if !errors.Is(err, errSlowDown) {
+		t.Fatalf("error of the backend not wrapped: %v", err)
+	}
+	if category := storagedriver.CategoryOf(err); category != storagedriver.ErrorCategoryThrottled {
+		t.Fatalf("unexpected category: %v != %v", category, storagedriver.ErrorCategoryThrottled)
+	}
+
+	_, err = base.Stat(ctx, "/path/to/file")
+	if category := storagedriver.CategoryOf(err); category != storagedriver.ErrorCategoryNotFound {
+		t.Fatalf("unexpected category: %v != %v", category, storagedriver.ErrorCategoryNotFound)
+	}
+}
@@ -1,6 +1,7 @@
 package driver
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -79,3 +80,37 @@ func TestErrors(t *testing.T) {
 		})
 	}
 }
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestCategoryOf(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected ErrorCategory
+	}{
+		{"unclassified", errors.New("unexpected error"), ErrorCategoryPermanent},
+		{"annotated", WithCategory(errors.New("slow down"), ErrorCategoryThrottled), ErrorCategoryThrottled},
+		{"wrapped annotated", Error{DriverName: "foo", Detail: WithCategory(errors.New("slow down"), ErrorCategoryThrottled)}, ErrorCategoryThrottled},
+		{"path not found", PathNotFoundError{Path: "/foo", DriverName: "foo"}, ErrorCategoryNotFound},
+		{"deadline exceeded", Error{DriverName: "foo", Detail: context.DeadlineExceeded}, ErrorCategoryTimeout},
+		{"network timeout", fmt.Errorf("reading: %w", timeoutError{}), ErrorCategoryTimeout},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if category := CategoryOf(tc.err); category != tc.expected {
+				t.Fatalf("unexpected category: %v != %v", category, tc.expected)
+			}
+		})
+	}
+
+	if WithCategory(nil, ErrorCategoryTimeout) != nil {
+		t.Fatal("expected no error annotating a nil error")
+	}
+	if !ErrorCategoryThrottled.Retriable() || !ErrorCategoryTimeout.Retriable() || !ErrorCategoryUnavailable.Retriable() || ErrorCategoryNotFound.Retriable() || ErrorCategoryPermanent.Retriable() {
+		t.Fatal("only throttling, timeouts and server errors should be retriable")
+	}
+}
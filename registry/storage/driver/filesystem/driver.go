@@ -4,12 +4,14 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
@@ -22,17 +24,48 @@ const (
 	defaultRootDirectory = "/var/lib/registry"
 	defaultMaxThreads    = uint64(100)
 
+	// defaultFilePerm and defaultDirPerm are the permissions of the files
+	// and directories created by the driver when no mode is configured,
+	// before the umask is applied.
+	defaultFilePerm = os.FileMode(0o666)
+	defaultDirPerm  = os.FileMode(0o777)
+
 	// minThreads is the minimum value for the maxthreads configuration
 	// parameter. If the driver's parameters are less than this we set
 	// the parameters to minThreads
 	minThreads = uint64(25)
 )
 
+// FsyncPolicy controls when the filesystem driver flushes files and
+// directories to stable storage.
+type FsyncPolicy string
+
+const (
+	// FsyncAlways syncs every file written by the driver, and the
+	// directories in which files are created, renamed or moved.
+	FsyncAlways FsyncPolicy = "always"
+
+	// FsyncMetadataCriticalOnly syncs the files written by PutContent, such
+	// as links, and the directories in which files are created, renamed or
+	// moved, but not the blob data written by upload writers.
+	FsyncMetadataCriticalOnly FsyncPolicy = "metadata-critical-only"
+
+	// FsyncNever never syncs. Files written by PutContent are still replaced
+	// atomically, but may be lost or empty after a power loss.
+	FsyncNever FsyncPolicy = "never"
+)
+
 // DriverParameters represents all configuration options available for the
 // filesystem driver
 type DriverParameters struct {
 	RootDirectory string
 	MaxThreads    uint64
+	Fsync         FsyncPolicy
+	// FileMode and DirMode are the exact permissions of the files and
+	// directories created by the driver. If zero, the default permissions
+	// are used, restricted by the umask.
+	FileMode os.FileMode
+	DirMode  os.FileMode
 }
 
 func init() {
@@ -48,6 +81,10 @@ func (factory *filesystemDriverFactory) Create(ctx context.Context, parameters m
 
 type driver struct {
 	rootDirectory string
+	fsync         FsyncPolicy
+	fileMode      os.FileMode
+	dirMode       os.FileMode
+	fs            fileSystem
 }
 
 type baseEmbed struct {
@@ -64,6 +101,9 @@ type Driver struct {
 // Optional Parameters:
 // - rootdirectory
 // - maxthreads
+// - fsync
+// - filemode
+// - dirmode
 func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 	params, err := fromParametersImpl(parameters)
 	if err != nil || params == nil {
@@ -77,6 +117,9 @@ func fromParametersImpl(parameters map[string]interface{}) (*DriverParameters, e
 		err           error
 		maxThreads    = defaultMaxThreads
 		rootDirectory = defaultRootDirectory
+		fsync         = FsyncAlways
+		fileMode      os.FileMode
+		dirMode       os.FileMode
 	)
 
 	if parameters != nil {
@@ -88,18 +131,89 @@ func fromParametersImpl(parameters map[string]interface{}) (*DriverParameters, e
 		if err != nil {
 			return nil, fmt.Errorf("maxthreads config error: %s", err.Error())
 		}
+
+		if policy, ok := parameters["fsync"]; ok && policy != nil {
+			switch p := FsyncPolicy(strings.ToLower(fmt.Sprint(policy))); p {
+			case FsyncAlways, FsyncMetadataCriticalOnly, FsyncNever:
+				fsync = p
+			default:
+				return nil, fmt.Errorf("fsync config error: invalid policy %q, must be one of %q, %q or %q", policy, FsyncAlways, FsyncMetadataCriticalOnly, FsyncNever)
+			}
+		}
+
+		// The registry must be able to read and write its own files, and
+		// to traverse its own directories.
+		fileMode, err = getModeFromParameter(parameters["filemode"], 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("filemode config error: %s", err.Error())
+		}
+		dirMode, err = getModeFromParameter(parameters["dirmode"], 0o700)
+		if err != nil {
+			return nil, fmt.Errorf("dirmode config error: %s", err.Error())
+		}
 	}
 
 	params := &DriverParameters{
 		RootDirectory: rootDirectory,
 		MaxThreads:    maxThreads,
+		Fsync:         fsync,
+		FileMode:      fileMode,
+		DirMode:       dirMode,
 	}
 	return params, nil
 }
 
+// getModeFromParameter parses a permission mode, given either as an integer
+// or as an octal string such as "0640". The mode must include the required
+// bits. A nil parameter yields a zero mode.
+func getModeFromParameter(param interface{}, required os.FileMode) (os.FileMode, error) {
+	var mode uint64
+	switch v := param.(type) {
+	case nil:
+		return 0, nil
+	case string:
+		m, err := strconv.ParseUint(strings.TrimPrefix(v, "0o"), 8, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid octal mode %q", v)
+		}
+		mode = m
+	case int:
+		if v < 0 {
+			return 0, fmt.Errorf("invalid mode %d", v)
+		}
+		mode = uint64(v)
+	case uint:
+		mode = uint64(v)
+	default:
+		return 0, fmt.Errorf("invalid mode %v of type %T", param, param)
+	}
+
+	if mode&^uint64(os.ModePerm) != 0 {
+		return 0, fmt.Errorf("mode %#o has bits other than the permissions set", mode)
+	}
+	if os.FileMode(mode)&required != required {
+		return 0, fmt.Errorf("mode %#o must include %#o", mode, required)
+	}
+	return os.FileMode(mode), nil
+}
+
 // New constructs a new Driver with a given rootDirectory
 func New(params DriverParameters) *Driver {
-	fsDriver := &driver{rootDirectory: params.RootDirectory}
+	return newDriver(params, osFileSystem{})
+}
+
+func newDriver(params DriverParameters, fs fileSystem) *Driver {
+	fsync := params.Fsync
+	if fsync == "" {
+		fsync = FsyncAlways
+	}
+	fsDriver := &driver{
+		rootDirectory: params.RootDirectory,
+		fsync:         fsync,
+		fileMode:      params.FileMode,
+		dirMode:       params.DirMode,
+		fs:            fs,
+	}
 
 	return &Driver{
 		baseEmbed: baseEmbed{
@@ -133,25 +247,159 @@ func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
 }
 
 // PutContent stores the []byte content at a location designated by "path".
+// The content is written to a temporary file in the same directory, which
+// then replaces the destination, so that readers and crashes never observe a
+// partially written file.
 func (d *driver) PutContent(ctx context.Context, subPath string, contents []byte) error {
-	writer, err := d.Writer(ctx, subPath, false)
-	if err != nil {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fullPath := d.fullPath(subPath)
+	dir := path.Dir(fullPath)
+	if err := d.mkdirAll(dir); err != nil {
 		return err
 	}
-	defer writer.Close()
-	_, err = io.Copy(writer, bytes.NewReader(contents))
+
+	tmp, err := d.createTemp(dir, path.Base(fullPath))
 	if err != nil {
-		if cErr := writer.Cancel(ctx); cErr != nil {
-			return errors.Join(err, cErr)
+		return err
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			os.Remove(tmp.Name())
 		}
+	}()
+
+	if _, err := io.Copy(tmp, bytes.NewReader(contents)); err != nil {
+		tmp.Close()
+		return err
+	}
+	// The destination is only replaced if the write was not canceled
+	// meanwhile.
+	if err := ctx.Err(); err != nil {
+		tmp.Close()
 		return err
 	}
-	return writer.Commit(ctx)
+	if d.fsync != FsyncNever {
+		if err := d.fs.Sync(tmp); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := d.fs.Rename(tmp.Name(), fullPath); err != nil {
+		return err
+	}
+	renamed = true
+
+	return d.syncDir(dir)
+}
+
+// createTemp creates a new temporary file in dir for replacing the file named
+// base. Temporary files are hidden, and never collide with the names used by
+// the registry.
+func (d *driver) createTemp(dir, base string) (*os.File, error) {
+	for i := 0; i < 10000; i++ {
+		name := path.Join(dir, "."+base+".tmp-"+strconv.FormatUint(uint64(rand.Uint32()), 36))
+		fp, err := d.createFile(name, os.O_RDWR)
+		if os.IsExist(err) {
+			continue
+		}
+		return fp, err
+	}
+	return nil, fmt.Errorf("unable to create a temporary file in %s", dir)
+}
+
+// createFile creates the file at name, which must not exist, opened with the
+// given flag. The file is given the configured mode, if any.
+func (d *driver) createFile(name string, flag int) (*os.File, error) {
+	perm := defaultFilePerm
+	if d.fileMode != 0 {
+		perm = d.fileMode
+	}
+	fp, err := d.fs.OpenFile(name, flag|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return nil, err
+	}
+	// The mode is set explicitly, as the umask applies on creation.
+	if d.fileMode != 0 {
+		if err := fp.Chmod(d.fileMode); err != nil {
+			fp.Close()
+			os.Remove(name)
+			return nil, err
+		}
+	}
+	return fp, nil
+}
+
+// mkdirAll creates dir along with any missing parents. The created
+// directories are given the configured mode, if any, while existing
+// directories are left alone. Unless the fsync policy is never, the parent of
+// each created directory is synced, so that the new directories survive a
+// crash.
+func (d *driver) mkdirAll(dir string) error {
+	var created []string
+	for p := dir; ; p = path.Dir(p) {
+		if _, err := os.Stat(p); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		created = append(created, p)
+		if path.Dir(p) == p {
+			break
+		}
+	}
+	if len(created) == 0 {
+		return nil
+	}
+
+	perm := defaultDirPerm
+	if d.dirMode != 0 {
+		perm = d.dirMode
+	}
+	for i := len(created) - 1; i >= 0; i-- {
+		if err := os.Mkdir(created[i], perm); err != nil {
+			// The directory may have been created concurrently.
+			if os.IsExist(err) {
+				continue
+			}
+			return err
+		}
+		if d.dirMode != 0 {
+			if err := os.Chmod(created[i], d.dirMode); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, p := range created {
+		if err := d.syncDir(path.Dir(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDir syncs the directory entries of dir, unless the fsync policy is
+// never.
+func (d *driver) syncDir(dir string) error {
+	if d.fsync == FsyncNever {
+		return nil
+	}
+	return d.fs.SyncDir(dir)
 }
 
 // Reader retrieves an io.ReadCloser for the content stored at "path" with a
 // given byte offset.
 func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	file, err := os.OpenFile(d.fullPath(path), os.O_RDONLY, 0o644)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -170,17 +418,36 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
 	}
 
-	return file, nil
+	return &fileReader{File: file, ctx: ctx}, nil
+}
+
+// fileReader reads a file until the context it was opened with is canceled.
+type fileReader struct {
+	*os.File
+	ctx context.Context
+}
+
+func (fr *fileReader) Read(p []byte) (int, error) {
+	if err := fr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return fr.File.Read(p)
 }
 
 func (d *driver) Writer(ctx context.Context, subPath string, append bool) (storagedriver.FileWriter, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	fullPath := d.fullPath(subPath)
 	parentDir := path.Dir(fullPath)
-	if err := os.MkdirAll(parentDir, 0o777); err != nil {
+	if err := d.mkdirAll(parentDir); err != nil {
 		return nil, err
 	}
 
-	fp, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE, 0o666)
+	fp, err := d.createFile(fullPath, os.O_WRONLY)
+	if os.IsExist(err) {
+		fp, err = d.fs.OpenFile(fullPath, os.O_WRONLY, 0)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -202,12 +469,15 @@ func (d *driver) Writer(ctx context.Context, subPath string, append bool) (stora
 		offset = n
 	}
 
-	return newFileWriter(fp, offset), nil
+	return newFileWriter(fp, offset, d.fs, d.fsync == FsyncAlways), nil
 }
 
 // Stat retrieves the FileInfo for the given path, including the current size
 // in bytes and the creation time.
 func (d *driver) Stat(ctx context.Context, subPath string) (storagedriver.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	fullPath := d.fullPath(subPath)
 
 	fi, err := os.Stat(fullPath)
@@ -228,6 +498,9 @@ func (d *driver) Stat(ctx context.Context, subPath string) (storagedriver.FileIn
 // List returns a list of the objects that are direct descendants of the given
 // path.
 func (d *driver) List(ctx context.Context, subPath string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	fullPath := d.fullPath(subPath)
 
 	dir, err := os.Open(fullPath)
@@ -254,8 +527,12 @@ func (d *driver) List(ctx context.Context, subPath string) ([]string, error) {
 }
 
 // Move moves an object stored at sourcePath to destPath, removing the original
-// object.
+// object. Unless the fsync policy is never, the directory containing destPath
+// is synced, so that the move survives a crash.
 func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	source := d.fullPath(sourcePath)
 	dest := d.fullPath(destPath)
 
@@ -263,16 +540,21 @@ func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) e
 		return storagedriver.PathNotFoundError{Path: sourcePath}
 	}
 
-	if err := os.MkdirAll(path.Dir(dest), 0o777); err != nil {
+	if err := d.mkdirAll(path.Dir(dest)); err != nil {
 		return err
 	}
 
-	err := os.Rename(source, dest)
-	return err
+	if err := d.fs.Rename(source, dest); err != nil {
+		return err
+	}
+	return d.syncDir(path.Dir(dest))
 }
 
 // Delete recursively deletes all objects stored at "path" and its subpaths.
 func (d *driver) Delete(ctx context.Context, subPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	fullPath := d.fullPath(subPath)
 
 	_, err := os.Stat(fullPath)
@@ -338,6 +620,8 @@ func (fi fileInfo) IsDir() bool {
 
 type fileWriter struct {
 	file      *os.File
+	fs        fileSystem
+	sync      bool
 	size      int64
 	bw        *bufio.Writer
 	closed    bool
@@ -345,9 +629,11 @@ type fileWriter struct {
 	cancelled bool
 }
 
-func newFileWriter(file *os.File, size int64) *fileWriter {
+func newFileWriter(file *os.File, size int64, fs fileSystem, sync bool) *fileWriter {
 	return &fileWriter{
 		file: file,
+		fs:   fs,
+		sync: sync,
 		size: size,
 		bw:   bufio.NewWriter(file),
 	}
@@ -379,8 +665,10 @@ func (fw *fileWriter) Close() error {
 		return err
 	}
 
-	if err := fw.file.Sync(); err != nil {
-		return err
+	if fw.sync {
+		if err := fw.fs.Sync(fw.file); err != nil {
+			return err
+		}
 	}
 
 	if err := fw.file.Close(); err != nil {
@@ -401,6 +689,9 @@ func (fw *fileWriter) Cancel(ctx context.Context) error {
 }
 
 func (fw *fileWriter) Commit(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if fw.closed {
 		return fmt.Errorf("already closed")
 	} else if fw.committed {
@@ -413,8 +704,10 @@ func (fw *fileWriter) Commit(ctx context.Context) error {
 		return err
 	}
 
-	if err := fw.file.Sync(); err != nil {
-		return err
+	if fw.sync {
+		if err := fw.fs.Sync(fw.file); err != nil {
+			return err
+		}
 	}
 
 	fw.committed = true
@@ -1,7 +1,13 @@
 package filesystem
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
@@ -38,6 +44,7 @@ func TestFromParametersImpl(t *testing.T) {
 			expected: DriverParameters{
 				RootDirectory: defaultRootDirectory,
 				MaxThreads:    defaultMaxThreads,
+				Fsync:         FsyncAlways,
 			},
 			pass: true,
 		},
@@ -56,6 +63,7 @@ func TestFromParametersImpl(t *testing.T) {
 			expected: DriverParameters{
 				RootDirectory: defaultRootDirectory,
 				MaxThreads:    uint64(100),
+				Fsync:         FsyncAlways,
 			},
 			pass: true,
 		},
@@ -66,6 +74,7 @@ func TestFromParametersImpl(t *testing.T) {
 			expected: DriverParameters{
 				RootDirectory: defaultRootDirectory,
 				MaxThreads:    uint64(100),
+				Fsync:         FsyncAlways,
 			},
 			pass: true,
 		},
@@ -77,9 +86,102 @@ func TestFromParametersImpl(t *testing.T) {
 			expected: DriverParameters{
 				RootDirectory: defaultRootDirectory,
 				MaxThreads:    minThreads,
+				Fsync:         FsyncAlways,
 			},
 			pass: true,
 		},
+		{
+			params: map[string]interface{}{
+				"fsync": "metadata-critical-only",
+			},
+			expected: DriverParameters{
+				RootDirectory: defaultRootDirectory,
+				MaxThreads:    defaultMaxThreads,
+				Fsync:         FsyncMetadataCriticalOnly,
+			},
+			pass: true,
+		},
+		{
+			params: map[string]interface{}{
+				"fsync": "Never",
+			},
+			expected: DriverParameters{
+				RootDirectory: defaultRootDirectory,
+				MaxThreads:    defaultMaxThreads,
+				Fsync:         FsyncNever,
+			},
+			pass: true,
+		},
+		{
+			params: map[string]interface{}{
+				"fsync": "sometimes",
+			},
+			expected: DriverParameters{},
+			pass:     false,
+		},
+		{
+			params: map[string]interface{}{
+				"filemode": "0640",
+				"dirmode":  0o750,
+			},
+			expected: DriverParameters{
+				RootDirectory: defaultRootDirectory,
+				MaxThreads:    defaultMaxThreads,
+				Fsync:         FsyncAlways,
+				FileMode:      0o640,
+				DirMode:       0o750,
+			},
+			pass: true,
+		},
+		{
+			params: map[string]interface{}{
+				"filemode": "0o600",
+			},
+			expected: DriverParameters{
+				RootDirectory: defaultRootDirectory,
+				MaxThreads:    defaultMaxThreads,
+				Fsync:         FsyncAlways,
+				FileMode:      0o600,
+			},
+			pass: true,
+		},
+		// modes must be octal permissions
+		{
+			params: map[string]interface{}{
+				"filemode": "0680",
+			},
+			expected: DriverParameters{},
+			pass:     false,
+		},
+		{
+			params: map[string]interface{}{
+				"dirmode": 0o4750,
+			},
+			expected: DriverParameters{},
+			pass:     false,
+		},
+		{
+			params: map[string]interface{}{
+				"filemode": true,
+			},
+			expected: DriverParameters{},
+			pass:     false,
+		},
+		// modes must allow the registry to use its own files
+		{
+			params: map[string]interface{}{
+				"filemode": "0440",
+			},
+			expected: DriverParameters{},
+			pass:     false,
+		},
+		{
+			params: map[string]interface{}{
+				"dirmode": "0640",
+			},
+			expected: DriverParameters{},
+			pass:     false,
+		},
 	}
 
 	for _, item := range tests {
@@ -102,3 +204,329 @@ func TestFromParametersImpl(t *testing.T) {
 		}
 	}
 }
+
+// recordingFileSystem records the operations made through it, with paths
+// relative to root and temporary file names replaced by "tmp".
+type recordingFileSystem struct {
+	osFileSystem
+	root      string
+	ops       []string
+	renameErr error
+}
+
+func (fs *recordingFileSystem) name(p string) string {
+	p = strings.TrimPrefix(p, fs.root)
+	if strings.Contains(path.Base(p), ".tmp-") {
+		p = path.Join(path.Dir(p), "tmp")
+	}
+	if p == "" {
+		return "/"
+	}
+	return p
+}
+
+func (fs *recordingFileSystem) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	fs.ops = append(fs.ops, "open "+fs.name(name))
+	return fs.osFileSystem.OpenFile(name, flag, perm)
+}
+
+func (fs *recordingFileSystem) Rename(oldpath, newpath string) error {
+	fs.ops = append(fs.ops, "rename "+fs.name(oldpath)+" "+fs.name(newpath))
+	if fs.renameErr != nil {
+		return fs.renameErr
+	}
+	return fs.osFileSystem.Rename(oldpath, newpath)
+}
+
+func (fs *recordingFileSystem) Sync(file *os.File) error {
+	fs.ops = append(fs.ops, "sync "+fs.name(file.Name()))
+	return fs.osFileSystem.Sync(file)
+}
+
+func (fs *recordingFileSystem) SyncDir(dir string) error {
+	fs.ops = append(fs.ops, "syncdir "+fs.name(dir))
+	return fs.osFileSystem.SyncDir(dir)
+}
+
+func newRecordingDriver(t *testing.T, fsync FsyncPolicy) (*Driver, *recordingFileSystem) {
+	root := t.TempDir()
+	fs := &recordingFileSystem{root: root}
+	return newDriver(DriverParameters{RootDirectory: root, MaxThreads: defaultMaxThreads, Fsync: fsync}, fs), fs
+}
+
+func checkOps(t *testing.T, fs *recordingFileSystem, expected ...string) {
+	t.Helper()
+	if !reflect.DeepEqual(fs.ops, expected) {
+		t.Fatalf("unexpected operations:\n got: %q\nwant: %q", fs.ops, expected)
+	}
+	fs.ops = nil
+}
+
+func checkNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Fatalf("temporary file left behind: %s", entry.Name())
+		}
+	}
+}
+
+func TestPutContentReplacesAtomically(t *testing.T) {
+	ctx := context.Background()
+	d, fs := newRecordingDriver(t, FsyncAlways)
+
+	if err := d.PutContent(ctx, "/a/b/link", []byte("first")); err != nil {
+		t.Fatalf("unexpected error putting content: %v", err)
+	}
+	// The new directories are synced into their parents before the content
+	// is written to a temporary file, synced, and renamed over the
+	// destination, whose directory is synced last.
+	checkOps(t, fs,
+		"syncdir /a",
+		"syncdir /",
+		"open /a/b/tmp",
+		"sync /a/b/tmp",
+		"rename /a/b/tmp /a/b/link",
+		"syncdir /a/b",
+	)
+
+	if err := d.PutContent(ctx, "/a/b/link", []byte("second")); err != nil {
+		t.Fatalf("unexpected error putting content: %v", err)
+	}
+	checkOps(t, fs,
+		"open /a/b/tmp",
+		"sync /a/b/tmp",
+		"rename /a/b/tmp /a/b/link",
+		"syncdir /a/b",
+	)
+
+	content, err := d.GetContent(ctx, "/a/b/link")
+	if err != nil {
+		t.Fatalf("unexpected error getting content: %v", err)
+	}
+	if string(content) != "second" {
+		t.Fatalf("unexpected content: %q", content)
+	}
+	checkNoTempFiles(t, path.Join(fs.root, "a/b"))
+}
+
+func TestPutContentFailedRename(t *testing.T) {
+	ctx := context.Background()
+	d, fs := newRecordingDriver(t, FsyncAlways)
+
+	if err := d.PutContent(ctx, "/link", []byte("first")); err != nil {
+		t.Fatalf("unexpected error putting content: %v", err)
+	}
+
+	// A crash before the rename leaves the previous content in place.
+	fs.renameErr = errors.New("rename failed")
+	if err := d.PutContent(ctx, "/link", []byte("second")); err == nil {
+		t.Fatal("expected an error putting content")
+	}
+
+	content, err := d.GetContent(ctx, "/link")
+	if err != nil {
+		t.Fatalf("unexpected error getting content: %v", err)
+	}
+	if string(content) != "first" {
+		t.Fatalf("unexpected content: %q", content)
+	}
+	checkNoTempFiles(t, fs.root)
+}
+
+func TestFsyncPolicies(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		fsync  FsyncPolicy
+		put    []string
+		write  []string
+		commit []string
+		move   []string
+	}{
+		{
+			fsync:  FsyncAlways,
+			put:    []string{"open /tmp", "sync /tmp", "rename /tmp /link", "syncdir /"},
+			write:  []string{"open /data"},
+			commit: []string{"sync /data"},
+			move:   []string{"rename /data /blob", "syncdir /"},
+		},
+		{
+			fsync:  FsyncMetadataCriticalOnly,
+			put:    []string{"open /tmp", "sync /tmp", "rename /tmp /link", "syncdir /"},
+			write:  []string{"open /data"},
+			commit: nil,
+			move:   []string{"rename /data /blob", "syncdir /"},
+		},
+		{
+			fsync:  FsyncNever,
+			put:    []string{"open /tmp", "rename /tmp /link"},
+			write:  []string{"open /data"},
+			commit: nil,
+			move:   []string{"rename /data /blob"},
+		},
+	} {
+		t.Run(string(tc.fsync), func(t *testing.T) {
+			d, fs := newRecordingDriver(t, tc.fsync)
+
+			if err := d.PutContent(ctx, "/link", []byte("content")); err != nil {
+				t.Fatalf("unexpected error putting content: %v", err)
+			}
+			checkOps(t, fs, tc.put...)
+
+			w, err := d.Writer(ctx, "/data", false)
+			if err != nil {
+				t.Fatalf("unexpected error creating writer: %v", err)
+			}
+			checkOps(t, fs, tc.write...)
+			if _, err := w.Write([]byte("content")); err != nil {
+				t.Fatalf("unexpected error writing: %v", err)
+			}
+			if err := w.Commit(ctx); err != nil {
+				t.Fatalf("unexpected error committing: %v", err)
+			}
+			checkOps(t, fs, tc.commit...)
+			if err := w.Close(); err != nil {
+				t.Fatalf("unexpected error closing: %v", err)
+			}
+			checkOps(t, fs, tc.commit...)
+
+			if err := d.Move(ctx, "/data", "/blob"); err != nil {
+				t.Fatalf("unexpected error moving: %v", err)
+			}
+			checkOps(t, fs, tc.move...)
+		})
+	}
+}
+
+func TestFileModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on windows")
+	}
+
+	ctx := context.Background()
+	root := t.TempDir()
+
+	// Pre-existing directories keep their mode.
+	existing := path.Join(root, "existing")
+	if err := os.Mkdir(existing, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(existing, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := FromParameters(map[string]interface{}{
+		"rootdirectory": root,
+		"filemode":      "0640",
+		"dirmode":       "0750",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+
+	if err := d.PutContent(ctx, "/existing/new/link", []byte("content")); err != nil {
+		t.Fatalf("unexpected error putting content: %v", err)
+	}
+
+	w, err := d.Writer(ctx, "/uploads/id/data", false)
+	if err != nil {
+		t.Fatalf("unexpected error creating writer: %v", err)
+	}
+	if _, err := w.Write([]byte("content")); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if err := w.Commit(ctx); err != nil {
+		t.Fatalf("unexpected error committing: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+
+	if err := d.Move(ctx, "/uploads/id/data", "/blobs/digest/data"); err != nil {
+		t.Fatalf("unexpected error moving: %v", err)
+	}
+
+	for p, expected := range map[string]os.FileMode{
+		"existing":          0o755,
+		"existing/new":      0o750,
+		"existing/new/link": 0o640,
+		"uploads":           0o750,
+		"uploads/id":        0o750,
+		"blobs":             0o750,
+		"blobs/digest":      0o750,
+		"blobs/digest/data": 0o640,
+	} {
+		fi, err := os.Stat(path.Join(root, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := fi.Mode().Perm(); mode != expected {
+			t.Errorf("unexpected mode of %s: %#o != %#o", p, mode, expected)
+		}
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	d, err := FromParameters(map[string]interface{}{
+		"rootdirectory": t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := d.PutContent(ctx, "/a", []byte("content")); err != nil {
+		t.Fatalf("unexpected error putting content: %v", err)
+	}
+	rd, err := d.Reader(ctx, "/a", 0)
+	if err != nil {
+		t.Fatalf("unexpected error opening reader: %v", err)
+	}
+	defer rd.Close()
+	fw, err := d.Writer(ctx, "/b", false)
+	if err != nil {
+		t.Fatalf("unexpected error opening writer: %v", err)
+	}
+	if _, err := fw.Write([]byte("partial")); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+
+	cancel()
+
+	if _, err := rd.Read(make([]byte, 1)); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected reading to be canceled, got %v", err)
+	}
+	if err := fw.Commit(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected commit to be canceled, got %v", err)
+	}
+	for name, op := range map[string]func() error{
+		"PutContent": func() error { return d.PutContent(ctx, "/a", []byte("other")) },
+		"Stat":       func() error { _, err := d.Stat(ctx, "/a"); return err },
+		"List":       func() error { _, err := d.List(ctx, "/"); return err },
+		"Move":       func() error { return d.Move(ctx, "/a", "/c") },
+		"Delete":     func() error { return d.Delete(ctx, "/a") },
+	} {
+		if err := op(); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %s to be canceled, got %v", name, err)
+		}
+	}
+
+	// The canceled writer is kept, to be resumed.
+	if err := fw.Close(); err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+	for p, expected := range map[string]string{"/a": "content", "/b": "partial"} {
+		content, err := d.GetContent(context.Background(), p)
+		if err != nil {
+			t.Fatalf("unexpected error getting content: %v", err)
+		}
+		if string(content) != expected {
+			t.Errorf("unexpected content at %s: %q != %q", p, content, expected)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package filesystem
+
+import (
+	"os"
+	"runtime"
+)
+
+// fileSystem abstracts the operations through which the driver makes its
+// writes durable, so that their sequence can be observed.
+type fileSystem interface {
+	OpenFile(name string, flag int, perm os.FileMode) (*os.File, error)
+	Rename(oldpath, newpath string) error
+	Sync(file *os.File) error
+	SyncDir(dir string) error
+}
+
+// osFileSystem implements fileSystem with the os package.
+type osFileSystem struct{}
+
+func (osFileSystem) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (osFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFileSystem) Sync(file *os.File) error {
+	return file.Sync()
+}
+
+func (osFileSystem) SyncDir(dir string) error {
+	// Directories cannot be synced on Windows, where renames are made
+	// durable by the filesystem itself.
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	fp, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	return fp.Sync()
+}
@@ -580,6 +580,27 @@ func (w *writer) init(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
+	} else {
+		// The session URI and offset are recorded when the writer is closed,
+		// so any registry instance can resume the upload. The session may
+		// have persisted more content than recorded if the instance which
+		// wrote it went away before closing the writer: resume from the
+		// offset persisted by the session, dropping the buffered content it
+		// already holds.
+		persisted, err := w.sessionOffset(ctx)
+		if err != nil {
+			return err
+		}
+		if persisted < offset {
+			return fmt.Errorf("upload session of %s holds %d bytes, %d expected", w.object.ObjectName(), persisted, offset)
+		}
+		if skip := persisted - offset; skip > 0 {
+			if skip > int64(w.buffSize) {
+				skip = int64(w.buffSize)
+			}
+			w.buffSize = copy(w.buffer, w.buffer[skip:w.buffSize])
+			offset = persisted
+		}
 	}
 	w.offset = offset
 	w.size = offset + int64(w.buffSize)
@@ -610,6 +631,24 @@ func retry(req request) error {
 	return err
 }
 
+// CategorizeError implements storagedriver.ErrorCategorizer, telling the
+// requests GCS throttled, which timed out, or which failed with a server
+// error, once retries are exhausted.
+func (d *driver) CategorizeError(err error) storagedriver.ErrorCategory {
+	var status *googleapi.Error
+	if errors.As(err, &status) {
+		switch status.Code {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return storagedriver.ErrorCategoryThrottled
+		case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+			return storagedriver.ErrorCategoryTimeout
+		case http.StatusInternalServerError, http.StatusBadGateway:
+			return storagedriver.ErrorCategoryUnavailable
+		}
+	}
+	return storagedriver.ErrorCategoryPermanent
+}
+
 // Stat retrieves the FileInfo for the given path, including the current
 // size in bytes and the creation time.
 func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
@@ -795,13 +834,101 @@ func (d *driver) RedirectURL(r *http.Request, path string) (string, error) {
 		Method:         r.Method,
 		Expires:        time.Now().Add(20 * time.Minute),
 	}
+	// Cloud Storage overrides the Content-Disposition of responses, but
+	// not their Cache-Control.
+	if headers := storagedriver.GetRedirectHeaders(r); r.Method == http.MethodGet && headers.ContentDisposition != "" {
+		opts.QueryParameters = url.Values{"response-content-disposition": {headers.ContentDisposition}}
+	}
 	return d.bucket.SignedURL(d.pathToKey(path), opts)
 }
 
 // Walk traverses a filesystem defined within driver, starting
-// from the given path, calling f on each file
+// from the given path, calling f on each file.
+// The objects under path are listed recursively, without delimiter, in a
+// single sorted listing, and the directories are inferred from their names,
+// which spares the List and Stat calls of each directory.
 func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
-	return storagedriver.WalkFallback(ctx, d, path, f, options...)
+	walkOptions := &storagedriver.WalkOptions{}
+	for _, o := range options {
+		o(walkOptions)
+	}
+
+	prefix := d.pathToKey(path)
+	if prefix != "" {
+		prefix += "/"
+	}
+	query := &storage.Query{Prefix: prefix}
+	if walkOptions.StartAfterHint != "" {
+		// The start offset is inclusive.
+		query.StartOffset = d.pathToKey(walkOptions.StartAfterHint) + "\x00"
+	}
+	objects := d.bucket.Objects(ctx, query)
+
+	var (
+		found bool
+		// the most recent directory walked
+		prevDir = path
+		// the most recent directory skipped
+		skipDir string
+	)
+	for {
+		object, err := objects.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// Filter out deleted objects, upload sessions and directory
+		// placeholders, as List does.
+		if !object.Deleted.IsZero() || object.ContentType == uploadSessionContentType || strings.HasSuffix(object.Name, "/") {
+			continue
+		}
+		found = true
+
+		filePath := d.keyToPath(object.Name)
+		var walkInfos []storagedriver.FileInfoInternal
+		for _, dir := range storagedriver.DirectoryDiff(prevDir, filePath) {
+			walkInfos = append(walkInfos, storagedriver.FileInfoInternal{
+				FileInfoFields: storagedriver.FileInfoFields{
+					IsDir: true,
+					Path:  dir,
+				},
+			})
+			prevDir = dir
+		}
+		walkInfos = append(walkInfos, storagedriver.FileInfoInternal{
+			FileInfoFields: storagedriver.FileInfoFields{
+				Size:    object.Size,
+				ModTime: object.Updated,
+				Path:    filePath,
+			},
+		})
+
+		for _, walkInfo := range walkInfos {
+			if skipDir != "" && strings.HasPrefix(walkInfo.Path(), skipDir+"/") {
+				continue
+			}
+			switch err := f(walkInfo); err {
+			case nil:
+			case storagedriver.ErrSkipDir:
+				if walkInfo.IsDir() {
+					skipDir = walkInfo.Path()
+				}
+			case storagedriver.ErrFilledBuffer:
+				return nil
+			default:
+				return err
+			}
+		}
+	}
+
+	if !found && path != "/" && walkOptions.StartAfterHint == "" {
+		// Treat empty response as missing directory, since we don't actually
+		// have directories in Google Cloud Storage.
+		return storagedriver.PathNotFoundError{Path: path}
+	}
+	return nil
 }
 
 func (w *writer) newSession() (uri string, err error) {
@@ -834,6 +961,46 @@ func (w *writer) newSession() (uri string, err error) {
 	return uri, err
 }
 
+// sessionOffset returns the number of bytes persisted by the upload session.
+func (w *writer) sessionOffset(ctx context.Context) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, w.sessionURI, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Range", "bytes */*")
+	req.Header.Set("Content-Length", "0")
+
+	var persisted int64
+	err = retry(func() error {
+		resp, err := w.driver.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusPermanentRedirect {
+			if err := googleapi.CheckMediaResponse(resp); err != nil {
+				return err
+			}
+			return fmt.Errorf("upload session of %s is already complete", w.object.ObjectName())
+		}
+		// No range means that nothing was persisted yet.
+		persisted = 0
+		if r := resp.Header.Get("Range"); r != "" {
+			groups := rangeHeader.FindStringSubmatch(r)
+			if groups == nil {
+				return fmt.Errorf("invalid range in upload session status: %q", r)
+			}
+			end, err := strconv.ParseInt(groups[2], 10, 64)
+			if err != nil {
+				return err
+			}
+			persisted = end + 1
+		}
+		return nil
+	})
+	return persisted, err
+}
+
 func (w *writer) putChunk(ctx context.Context, sessionURI string, chunk []byte, from int64, totalSize int64) (int64, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodPut, sessionURI, bytes.NewReader(chunk))
 	if err != nil {
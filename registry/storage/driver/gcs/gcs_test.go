@@ -1,9 +1,20 @@
 package gcs
 
 import (
+	"bytes"
 	"context"
+	crand "crypto/rand"
+	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"cloud.google.com/go/storage"
@@ -222,6 +233,25 @@ func TestRetry(t *testing.T) {
 	assertError("error", err)
 }
 
+func TestCategorizeError(t *testing.T) {
+	d := &driver{}
+	for _, tc := range []struct {
+		err      error
+		expected storagedriver.ErrorCategory
+	}{
+		{&googleapi.Error{Code: http.StatusTooManyRequests}, storagedriver.ErrorCategoryThrottled},
+		{fmt.Errorf("writing: %w", &googleapi.Error{Code: http.StatusServiceUnavailable}), storagedriver.ErrorCategoryThrottled},
+		{&googleapi.Error{Code: http.StatusGatewayTimeout}, storagedriver.ErrorCategoryTimeout},
+		{&googleapi.Error{Code: http.StatusBadGateway}, storagedriver.ErrorCategoryUnavailable},
+		{&googleapi.Error{Code: http.StatusForbidden}, storagedriver.ErrorCategoryPermanent},
+		{fmt.Errorf("error"), storagedriver.ErrorCategoryPermanent},
+	} {
+		if category := d.CategorizeError(tc.err); category != tc.expected {
+			t.Errorf("%v: unexpected category: %v != %v", tc.err, category, tc.expected)
+		}
+	}
+}
+
 func TestEmptyRootList(t *testing.T) {
 	skipCheck(t)
 
@@ -306,3 +336,337 @@ func TestMoveDirectory(t *testing.T) {
 		t.Fatalf("Moving directory /parent/dir /parent/other should have return a non-nil error\n")
 	}
 }
+
+// fakeGCS is a minimal GCS server, holding the objects and the resumable
+// upload sessions of a single bucket, which outlives the drivers using it.
+type fakeGCS struct {
+	url string
+
+	mu       sync.Mutex
+	objects  map[string]*fakeObject
+	sessions map[string]*fakeSession
+	nextID   int
+}
+
+type fakeObject struct {
+	content     []byte
+	contentType string
+	metadata    map[string]string
+}
+
+type fakeSession struct {
+	name        string
+	contentType string
+	content     []byte
+}
+
+func newFakeGCS(t *testing.T) *fakeGCS {
+	f := &fakeGCS{
+		objects:  make(map[string]*fakeObject),
+		sessions: make(map[string]*fakeSession),
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	f.url = server.URL
+	return f
+}
+
+// newDriver returns a new driver using the fake server, as if the registry
+// had been restarted.
+func (f *fakeGCS) newDriver(t *testing.T) storagedriver.StorageDriver {
+	t.Helper()
+
+	gcs, err := storage.NewClient(context.Background(), option.WithEndpoint(f.url+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("unexpected error creating client: %v", err)
+	}
+	d, err := New(context.Background(), driverParameters{
+		bucket:         "bucket",
+		client:         &http.Client{Transport: f},
+		chunkSize:      minChunkSize,
+		gcs:            gcs,
+		maxConcurrency: 8,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+	return d
+}
+
+// RoundTrip sends the requests of the driver, which target the GCS API, to
+// the fake server.
+func (f *fakeGCS) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if req.URL.Host == "www.googleapis.com" {
+		req.URL.Scheme = "http"
+		req.URL.Host = strings.TrimPrefix(f.url, "http://")
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/bucket/o" && r.URL.Query().Get("uploadType") == "resumable":
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.sessions[id] = &fakeSession{
+			name:        r.URL.Query().Get("name"),
+			contentType: r.Header.Get("X-Upload-Content-Type"),
+		}
+		w.Header().Set("Location", f.url+"/session/"+id)
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/bucket/o":
+		f.insert(w, r)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/session/"):
+		f.putChunk(w, r, strings.TrimPrefix(r.URL.Path, "/session/"))
+	case strings.HasPrefix(r.URL.Path, "/storage/v1/b/bucket/o/"):
+		name := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bucket/o/")
+		obj, ok := f.objects[name]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(f.objects, name)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		f.writeObject(w, name, obj)
+	case strings.HasPrefix(r.URL.Path, "/bucket/"):
+		obj, ok := f.objects[strings.TrimPrefix(r.URL.Path, "/bucket/")]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.content)))
+		w.Write(obj.content)
+	default:
+		http.Error(w, "unsupported request", http.StatusNotImplemented)
+	}
+}
+
+// insert handles the multipart uploads made by the client library.
+func (f *fakeGCS) insert(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+
+	var attrs struct {
+		Name        string            `json:"name"`
+		ContentType string            `json:"contentType"`
+		Metadata    map[string]string `json:"metadata"`
+	}
+	part, err := mr.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&attrs)
+	}
+	if err == nil {
+		part, err = mr.NextPart()
+	}
+	var content []byte
+	if err == nil {
+		content, err = io.ReadAll(part)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	obj := &fakeObject{content: content, contentType: attrs.ContentType, metadata: attrs.Metadata}
+	f.objects[attrs.Name] = obj
+	f.writeObject(w, attrs.Name, obj)
+}
+
+// putChunk handles the requests made to a resumable upload session.
+func (f *fakeGCS) putChunk(w http.ResponseWriter, r *http.Request, id string) {
+	session, ok := f.sessions[id]
+	if !ok {
+		http.Error(w, "no such upload session", http.StatusNotFound)
+		return
+	}
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var first, last, total int64 = 0, -1, -1
+	rangeSpec, totalSpec, _ := strings.Cut(strings.TrimPrefix(r.Header.Get("Content-Range"), "bytes "), "/")
+	if rangeSpec != "*" {
+		fmt.Sscanf(rangeSpec, "%d-%d", &first, &last)
+	}
+	if totalSpec != "*" {
+		total, _ = strconv.ParseInt(totalSpec, 10, 64)
+	}
+	if last >= 0 {
+		if first > int64(len(session.content)) {
+			http.Error(w, "content is missing", http.StatusBadRequest)
+			return
+		}
+		session.content = append(session.content[:first], content...)
+	}
+
+	if total >= 0 && total == int64(len(session.content)) {
+		delete(f.sessions, id)
+		obj := &fakeObject{content: session.content, contentType: session.contentType}
+		f.objects[session.name] = obj
+		f.writeObject(w, session.name, obj)
+		return
+	}
+	if len(session.content) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(session.content)-1))
+	}
+	w.WriteHeader(http.StatusPermanentRedirect)
+}
+
+func (f *fakeGCS) writeObject(w http.ResponseWriter, name string, obj *fakeObject) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"bucket":      "bucket",
+		"name":        name,
+		"size":        strconv.Itoa(len(obj.content)),
+		"contentType": obj.contentType,
+		"metadata":    obj.metadata,
+		"generation":  "1",
+	})
+}
+
+// TestResumeAfterRestart checks that an upload started by a registry
+// instance can be continued by another instance.
+func TestResumeAfterRestart(t *testing.T) {
+	f := newFakeGCS(t)
+	ctx := context.Background()
+	filename := "/upload/data"
+	content := make([]byte, 3*minChunkSize+100)
+	if _, err := crand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first PATCH uploads a chunk and buffers the rest.
+	first := minChunkSize + 100
+	writer, err := f.newDriver(t).Writer(ctx, filename, false)
+	if err != nil {
+		t.Fatalf("unexpected error creating writer: %v", err)
+	}
+	if _, err := writer.Write(content[:first]); err != nil {
+		t.Fatalf("unexpected error writing content: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	// The second PATCH is handled after a restart.
+	d := f.newDriver(t)
+	writer, err = d.Writer(ctx, filename, true)
+	if err != nil {
+		t.Fatalf("unexpected error resuming writer: %v", err)
+	}
+	if writer.Size() != int64(first) {
+		t.Fatalf("unexpected size of resumed writer: %d != %d", writer.Size(), first)
+	}
+	if _, err := writer.Write(content[first:]); err != nil {
+		t.Fatalf("unexpected error writing content: %v", err)
+	}
+	if err := writer.Commit(ctx); err != nil {
+		t.Fatalf("unexpected error committing writer: %v", err)
+	}
+
+	received, err := d.GetContent(ctx, filename)
+	if err != nil {
+		t.Fatalf("unexpected error reading content: %v", err)
+	}
+	if !bytes.Equal(received, content) {
+		t.Fatal("unexpected content after restart")
+	}
+}
+
+// TestResumeAfterCrash checks that an upload is resumed from the offset
+// persisted by the upload session when the registry instance writing it
+// went away without closing the writer.
+func TestResumeAfterCrash(t *testing.T) {
+	f := newFakeGCS(t)
+	ctx := context.Background()
+	filename := "/upload/data"
+	content := make([]byte, 3*minChunkSize+100)
+	if _, err := crand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+
+	d := f.newDriver(t)
+	first := minChunkSize + 100
+	writer, err := d.Writer(ctx, filename, false)
+	if err != nil {
+		t.Fatalf("unexpected error creating writer: %v", err)
+	}
+	if _, err := writer.Write(content[:first]); err != nil {
+		t.Fatalf("unexpected error writing content: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	// The second PATCH uploads another chunk, then the instance goes away
+	// before closing the writer.
+	writer, err = d.Writer(ctx, filename, true)
+	if err != nil {
+		t.Fatalf("unexpected error resuming writer: %v", err)
+	}
+	if _, err := writer.Write(content[first : 2*minChunkSize+50]); err != nil {
+		t.Fatalf("unexpected error writing content: %v", err)
+	}
+
+	d = f.newDriver(t)
+	writer, err = d.Writer(ctx, filename, true)
+	if err != nil {
+		t.Fatalf("unexpected error resuming writer: %v", err)
+	}
+	if writer.Size() != 2*minChunkSize {
+		t.Fatalf("unexpected size of resumed writer: %d != %d", writer.Size(), 2*minChunkSize)
+	}
+	if _, err := writer.Write(content[2*minChunkSize:]); err != nil {
+		t.Fatalf("unexpected error writing content: %v", err)
+	}
+	if err := writer.Commit(ctx); err != nil {
+		t.Fatalf("unexpected error committing writer: %v", err)
+	}
+
+	received, err := d.GetContent(ctx, filename)
+	if err != nil {
+		t.Fatalf("unexpected error reading content: %v", err)
+	}
+	if !bytes.Equal(received, content) {
+		t.Fatal("unexpected content after crash")
+	}
+}
+
+func TestResumeExpiredSession(t *testing.T) {
+	f := newFakeGCS(t)
+	ctx := context.Background()
+	filename := "/upload/data"
+
+	d := f.newDriver(t)
+	writer, err := d.Writer(ctx, filename, false)
+	if err != nil {
+		t.Fatalf("unexpected error creating writer: %v", err)
+	}
+	if _, err := writer.Write(make([]byte, minChunkSize+100)); err != nil {
+		t.Fatalf("unexpected error writing content: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	f.mu.Lock()
+	f.sessions = make(map[string]*fakeSession)
+	f.mu.Unlock()
+
+	if _, err := d.Writer(ctx, filename, true); err == nil {
+		t.Fatal("expected an error resuming an upload whose session expired")
+	}
+}
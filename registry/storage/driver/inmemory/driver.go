@@ -23,12 +23,30 @@ func init() {
 type inMemoryDriverFactory struct{}
 
 func (factory *inMemoryDriverFactory) Create(ctx context.Context, parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
-	return New(), nil
+	return FromParameters(parameters)
+}
+
+// DriverParameters represents all configuration options available for the
+// inmemory driver.
+type DriverParameters struct {
+	// MaxSize is the capacity, in bytes, of the driver. Writes which would
+	// grow the stored content beyond it fail with a
+	// storagedriver.QuotaExceededError. Zero means unlimited.
+	MaxSize int64
+	// Clock returns the time recorded as the modification time of written
+	// paths. It defaults to time.Now, and allows tests to control the
+	// modification times read back through Stat, Walk and List. It does not
+	// affect the times stored in file contents, such as upload startedat
+	// files.
+	Clock func() time.Time
 }
 
 type driver struct {
-	root  *dir
-	mutex sync.RWMutex
+	root    *dir
+	mutex   sync.RWMutex
+	maxSize int64
+	size    int64
+	now     func() time.Time
 }
 
 // baseEmbed allows us to hide the Base embed.
@@ -44,8 +62,28 @@ type Driver struct {
 
 var _ storagedriver.StorageDriver = &Driver{}
 
-// New constructs a new Driver.
+// FromParameters constructs a new Driver with a given parameters map.
+// Optional Parameters:
+// - maxsize
+func FromParameters(parameters map[string]interface{}) (*Driver, error) {
+	maxSize, err := base.GetLimitFromParameter(parameters["maxsize"], 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("maxsize config error: %s", err.Error())
+	}
+	return NewWithParameters(DriverParameters{MaxSize: int64(maxSize)}), nil
+}
+
+// New constructs a new Driver, without capacity limit.
 func New() *Driver {
+	return NewWithParameters(DriverParameters{})
+}
+
+// NewWithParameters constructs a new Driver with the given parameters.
+func NewWithParameters(params DriverParameters) *Driver {
+	now := params.Clock
+	if now == nil {
+		now = time.Now
+	}
 	return &Driver{
 		baseEmbed: baseEmbed{
 			Base: base.Base{
@@ -53,9 +91,11 @@ func New() *Driver {
 					root: &dir{
 						common: common{
 							p:   "/",
-							mod: time.Now(),
+							mod: now(),
 						},
 					},
+					maxSize: params.MaxSize,
+					now:     now,
 				},
 			},
 		},
@@ -89,8 +129,18 @@ func (d *driver) PutContent(ctx context.Context, p string, contents []byte) erro
 
 	normalized := normalize(p)
 
-	f, err := d.root.mkfile(normalized)
+	var existing int64
+	if found := d.root.find(normalized); found.path() == normalized && !found.isdir() {
+		existing = size(found)
+	}
+	if err := d.reserve(p, int64(len(contents))-existing); err != nil {
+		return err
+	}
+
+	now := d.now()
+	f, err := d.root.mkfile(normalized, now)
 	if err != nil {
+		d.size -= int64(len(contents)) - existing
 		// TODO(stevvooe): Again, we need to clarify when this is not a
 		// directory in StorageDriver API.
 		return fmt.Errorf("not a file")
@@ -100,7 +150,18 @@ func (d *driver) PutContent(ctx context.Context, p string, contents []byte) erro
 	if _, err := f.WriteAt(contents, 0); err != nil {
 		return err
 	}
+	f.mod = now
+
+	return nil
+}
 
+// reserve accounts for n more bytes stored at path, failing if they would
+// exceed the capacity of the driver. It must be called with the mutex held.
+func (d *driver) reserve(path string, n int64) error {
+	if d.maxSize > 0 && n > 0 && d.size+n > d.maxSize {
+		return storagedriver.QuotaExceededError{Path: path, Limit: d.maxSize, DriverName: driverName}
+	}
+	d.size += n
 	return nil
 }
 
@@ -140,13 +201,16 @@ func (d *driver) Writer(ctx context.Context, path string, append bool) (storaged
 
 	normalized := normalize(path)
 
-	f, err := d.root.mkfile(normalized)
+	now := d.now()
+	f, err := d.root.mkfile(normalized, now)
 	if err != nil {
 		return nil, fmt.Errorf("not a file")
 	}
 
 	if !append {
+		d.size -= size(f)
 		f.truncate()
+		f.mod = now
 	}
 
 	return d.newWriter(f), nil
@@ -214,8 +278,17 @@ func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) e
 
 	normalizedSrc, normalizedDst := normalize(sourcePath), normalize(destPath)
 
-	err := d.root.move(normalizedSrc, normalizedDst)
+	// The content at destPath, if any, is replaced.
+	var replaced int64
+	if found := d.root.find(normalizedDst); found.path() == normalizedDst && normalizedSrc != normalizedDst {
+		replaced = size(found)
+	}
+
+	err := d.root.move(normalizedSrc, normalizedDst, d.now())
 	switch err {
+	case nil:
+		d.size -= replaced
+		return nil
 	case errNotExists:
 		return storagedriver.PathNotFoundError{Path: destPath}
 	default:
@@ -230,8 +303,16 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 
 	normalized := normalize(path)
 
+	var deleted int64
+	if found := d.root.find(normalized); found.path() == normalized {
+		deleted = size(found)
+	}
+
 	err := d.root.delete(normalized)
 	switch err {
+	case nil:
+		d.size -= deleted
+		return nil
 	case errNotExists:
 		return storagedriver.PathNotFoundError{Path: path}
 	default:
@@ -278,6 +359,9 @@ func (w *writer) Write(p []byte) (int, error) {
 
 	w.d.mutex.Lock()
 	defer w.d.mutex.Unlock()
+	if w.d.maxSize > 0 && w.d.size+int64(w.buffSize+len(p)) > w.d.maxSize {
+		return 0, storagedriver.QuotaExceededError{Path: w.f.path(), Limit: w.d.maxSize, DriverName: driverName}
+	}
 	if cap(w.buffer) < len(p)+w.buffSize {
 		data := make([]byte, len(w.buffer), len(p)+w.buffSize)
 		copy(data, w.buffer)
@@ -322,7 +406,12 @@ func (w *writer) Cancel(ctx context.Context) error {
 	w.d.mutex.Lock()
 	defer w.d.mutex.Unlock()
 
-	return w.d.root.delete(w.f.path())
+	found := w.d.root.find(w.f.path())
+	if err := w.d.root.delete(w.f.path()); err != nil {
+		return err
+	}
+	w.d.size -= size(found)
+	return nil
 }
 
 func (w *writer) Commit(ctx context.Context) error {
@@ -346,9 +435,18 @@ func (w *writer) flush() error {
 	w.d.mutex.Lock()
 	defer w.d.mutex.Unlock()
 
+	// The file no longer counts toward the capacity once it is deleted.
+	if w.d.root.find(w.f.path()) == node(w.f) {
+		if err := w.d.reserve(w.f.path(), int64(w.buffSize)); err != nil {
+			return err
+		}
+	}
 	if _, err := w.f.WriteAt(w.buffer, int64(len(w.f.data))); err != nil {
 		return err
 	}
+	if w.buffSize > 0 {
+		w.f.mod = w.d.now()
+	}
 	w.buffer = []byte{}
 	w.buffSize = 0
 
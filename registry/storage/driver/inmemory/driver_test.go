@@ -1,7 +1,11 @@
 package inmemory
 
 import (
+	"context"
+	"errors"
+	"reflect"
 	"testing"
+	"time"
 
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
@@ -18,3 +22,141 @@ func TestInMemoryDriverSuite(t *testing.T) {
 func BenchmarkInMemoryDriverSuite(b *testing.B) {
 	testsuites.BenchDriver(b, newDriverConstructor)
 }
+
+func TestFromParameters(t *testing.T) {
+	for _, tc := range []struct {
+		maxSize  interface{}
+		expected int64
+		pass     bool
+	}{
+		{maxSize: nil, expected: 0, pass: true},
+		{maxSize: 1024, expected: 1024, pass: true},
+		{maxSize: "2048", expected: 2048, pass: true},
+		{maxSize: "fail", pass: false},
+	} {
+		d, err := FromParameters(map[string]interface{}{"maxsize": tc.maxSize})
+		if !tc.pass {
+			if err == nil {
+				t.Errorf("expected error for maxsize %v", tc.maxSize)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for maxsize %v: %v", tc.maxSize, err)
+		}
+		if maxSize := d.StorageDriver.(*driver).maxSize; maxSize != tc.expected {
+			t.Errorf("unexpected maxsize for %v: %d != %d", tc.maxSize, maxSize, tc.expected)
+		}
+	}
+}
+
+func TestInMemoryDriverQuota(t *testing.T) {
+	ctx := context.Background()
+	d := NewWithParameters(DriverParameters{MaxSize: 10})
+
+	expectQuotaExceeded := func(err error) {
+		t.Helper()
+		var quotaErr storagedriver.QuotaExceededError
+		if !errors.As(err, &quotaErr) {
+			t.Fatalf("expected quota exceeded error, got %v", err)
+		}
+		if quotaErr.Limit != 10 || quotaErr.DriverName != driverName {
+			t.Fatalf("unexpected quota exceeded error: %#v", quotaErr)
+		}
+	}
+
+	if err := d.PutContent(ctx, "/a", make([]byte, 8)); err != nil {
+		t.Fatal(err)
+	}
+	expectQuotaExceeded(d.PutContent(ctx, "/b", make([]byte, 4)))
+	if _, err := d.Stat(ctx, "/b"); !errors.As(err, &storagedriver.PathNotFoundError{}) {
+		t.Fatalf("expected /b not to be written, got %v", err)
+	}
+
+	// Overwriting content frees the space of the previous content.
+	if err := d.PutContent(ctx, "/a", make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, "/b", make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := d.Writer(ctx, "/c", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(make([]byte, 1))
+	expectQuotaExceeded(err)
+	if err := w.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moving over a file and deleting free its space.
+	if err := d.Move(ctx, "/c", "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, "/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, "/d", make([]byte, 6)); err != nil {
+		t.Fatal(err)
+	}
+	expectQuotaExceeded(d.PutContent(ctx, "/e", make([]byte, 1)))
+}
+
+func TestInMemoryDriverModTime(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := NewWithParameters(DriverParameters{Clock: func() time.Time { return now }})
+
+	written := now
+	for _, p := range []string{"/dir/b", "/dir/a", "/dir/sub/c"} {
+		if err := d.PutContent(ctx, p, []byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now = now.Add(time.Hour)
+	w, err := d.Writer(ctx, "/dir/a", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("appended")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for p, expected := range map[string]time.Time{"/dir/a": now, "/dir/b": written, "/dir/sub": written} {
+		fi, err := d.Stat(ctx, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.ModTime().Equal(expected) {
+			t.Errorf("unexpected modtime of %s: %v != %v", p, fi.ModTime(), expected)
+		}
+	}
+
+	var walked []string
+	err = d.Walk(ctx, "/", func(fi storagedriver.FileInfo) error {
+		walked = append(walked, fi.Path())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/dir", "/dir/a", "/dir/b", "/dir/sub", "/dir/sub/c"}
+	if !reflect.DeepEqual(walked, expected) {
+		t.Fatalf("unexpected walk order: %v != %v", walked, expected)
+	}
+}
@@ -38,14 +38,14 @@ func (d *dir) isdir() bool {
 	return true
 }
 
-// add places the node n into dir d.
-func (d *dir) add(n node) {
+// add places the node n into dir d, modified at now.
+func (d *dir) add(n node, now time.Time) {
 	if d.children == nil {
 		d.children = make(map[string]node)
 	}
 
 	d.children[n.name()] = n
-	d.mod = time.Now()
+	d.mod = now
 }
 
 // find searches for the node, given path q in dir. If the node is found, it
@@ -112,7 +112,7 @@ func (d *dir) list(p string) ([]string, error) {
 
 // mkfile or return the existing one. returns an error if it exists and is a
 // directory. Essentially, this is open or create.
-func (d *dir) mkfile(p string) (*file, error) {
+func (d *dir) mkfile(p string, now time.Time) (*file, error) {
 	n := d.find(p)
 	if n.path() == p {
 		if n.isdir() {
@@ -124,7 +124,7 @@ func (d *dir) mkfile(p string) (*file, error) {
 
 	dirpath, filename := path.Split(p)
 	// Make any non-existent directories
-	n, err := d.mkdirs(dirpath)
+	n, err := d.mkdirs(dirpath, now)
 	if err != nil {
 		return nil, err
 	}
@@ -133,16 +133,16 @@ func (d *dir) mkfile(p string) (*file, error) {
 	n = &file{
 		common: common{
 			p:   path.Join(dd.path(), filename),
-			mod: time.Now(),
+			mod: now,
 		},
 	}
 
-	dd.add(n)
+	dd.add(n, now)
 	return n.(*file), nil
 }
 
 // mkdirs creates any missing directory entries in p and returns the result.
-func (d *dir) mkdirs(p string) (*dir, error) {
+func (d *dir) mkdirs(p string, now time.Time) (*dir, error) {
 	p = normalize(p)
 
 	n := d.find(p)
@@ -166,7 +166,7 @@ func (d *dir) mkdirs(p string) (*dir, error) {
 
 	components := strings.Split(relative, "/")
 	for _, component := range components {
-		d, err := dd.mkdir(component)
+		d, err := dd.mkdir(component, now)
 		if err != nil {
 			// This should actually never happen, since there are no children.
 			return nil, err
@@ -178,7 +178,7 @@ func (d *dir) mkdirs(p string) (*dir, error) {
 }
 
 // mkdir creates a child directory under d with the given name.
-func (d *dir) mkdir(name string) (*dir, error) {
+func (d *dir) mkdir(name string, now time.Time) (*dir, error) {
 	if name == "" {
 		return nil, fmt.Errorf("invalid dirname")
 	}
@@ -191,19 +191,18 @@ func (d *dir) mkdir(name string) (*dir, error) {
 	child := &dir{
 		common: common{
 			p:   path.Join(d.path(), name),
-			mod: time.Now(),
+			mod: now,
 		},
 	}
-	d.add(child)
-	d.mod = time.Now()
+	d.add(child, now)
 
 	return child, nil
 }
 
-func (d *dir) move(src, dst string) error {
+func (d *dir) move(src, dst string, now time.Time) error {
 	dstDirname, _ := path.Split(dst)
 
-	dp, err := d.mkdirs(dstDirname)
+	dp, err := d.mkdirs(dstDirname, now)
 	if err != nil {
 		return err
 	}
@@ -234,7 +233,7 @@ func (d *dir) move(src, dst string) error {
 		n.p = dst
 	}
 
-	dp.add(s)
+	dp.add(s, now)
 
 	return nil
 }
@@ -311,7 +310,6 @@ func (f *file) WriteAt(p []byte, offset int64) (n int, err error) {
 		f.data = data
 	}
 
-	f.mod = time.Now()
 	f.data = f.data[:newLen]
 
 	return copy(f.data[offset:newLen], p), nil
@@ -340,6 +338,21 @@ func (c *common) modtime() time.Time {
 	return c.mod
 }
 
+// size returns the size of the data stored in the tree of n.
+func size(n node) int64 {
+	switch n := n.(type) {
+	case *file:
+		return int64(len(n.data))
+	case *dir:
+		var total int64
+		for _, child := range n.children {
+			total += size(child)
+		}
+		return total
+	}
+	return 0
+}
+
 func normalize(p string) string {
 	return "/" + strings.Trim(p, "/")
 }
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudfront/sign"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/internal/requestutil"
+)
+
+// distribution is a CloudFront distribution other than the default one,
+// used for the clients whose IP address it matches.
+type distribution struct {
+	baseURL   string
+	urlSigner *sign.URLSigner
+	// vpcRanges and awsIPs select the clients served by this distribution.
+	vpcRanges []net.IPNet
+	awsIPs    *awsIPs
+}
+
+// newDistribution parses an entry of the distributions option. An entry has
+// the baseurl, privatekey and keypairid options of the default distribution,
+// and selects clients with vpcranges, ipfilteredby and awsregion.
+func newDistribution(ctx context.Context, entry interface{}, ipRangesURL string, updateFrequency time.Duration) (*distribution, error) {
+	var options map[string]interface{}
+	switch e := entry.(type) {
+	case map[string]interface{}:
+		options = e
+	case map[interface{}]interface{}:
+		options = make(map[string]interface{}, len(e))
+		for k, v := range e {
+			options[fmt.Sprint(k)] = v
+		}
+	default:
+		return nil, fmt.Errorf("must be a map")
+	}
+
+	baseURL, urlSigner, err := parseDistribution(options)
+	if err != nil {
+		return nil, err
+	}
+	dist := &distribution{baseURL: baseURL, urlSigner: urlSigner}
+
+	if r, ok := options["vpcranges"]; ok {
+		dist.vpcRanges, err = parseNetworks(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vpcranges: %v", err)
+		}
+	}
+	dist.awsIPs, err = parseIPFilter(ctx, options, ipRangesURL, updateFrequency)
+	if err != nil {
+		return nil, err
+	}
+	if len(dist.vpcRanges) == 0 && dist.awsIPs == nil {
+		return nil, fmt.Errorf("no vpcranges or ipfilteredby provided")
+	}
+	return dist, nil
+}
+
+// matches reports whether the distribution serves the client at ip.
+func (d *distribution) matches(ip net.IP) bool {
+	if requestutil.NetworksContain(d.vpcRanges, ip) {
+		return true
+	}
+	return d.awsIPs != nil && d.awsIPs.initialized && d.awsIPs.contains(ip)
+}
+
+// selectDistribution returns the first distribution matching the client of
+// r, or nil if the default distribution should be used.
+func (lh *cloudFrontStorageMiddleware) selectDistribution(r *http.Request) *distribution {
+	if len(lh.distributions) == 0 {
+		return nil
+	}
+	ip := net.ParseIP(requestutil.TrustedRemoteIP(r, lh.trustedProxies))
+	if ip == nil {
+		dcontext.GetLogger(r.Context()).Warn("failed to parse client ip address, using the default CloudFront distribution")
+		return nil
+	}
+	for _, dist := range lh.distributions {
+		if dist.matches(ip) {
+			return dist
+		}
+	}
+	return nil
+}
+
+// parseNetworks parses a list, or a comma separated string, of CIDR
+// networks and IP addresses.
+func parseNetworks(v interface{}) ([]net.IPNet, error) {
+	var values []string
+	switch v := v.(type) {
+	case string:
+		values = strings.Split(v, ",")
+	case []string:
+		values = v
+	case []interface{}:
+		for _, s := range v {
+			str, ok := s.(string)
+			if !ok {
+				return nil, fmt.Errorf("%#v is not a string", s)
+			}
+			values = append(values, str)
+		}
+	default:
+		return nil, fmt.Errorf("must be a list of networks")
+	}
+	return requestutil.ParseNetworks(values)
+}
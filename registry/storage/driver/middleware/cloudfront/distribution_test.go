@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/stretchr/testify/require"
+)
+
+type keyerDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (keyerDriver) S3BucketKey(path string) string {
+	return strings.TrimPrefix(path, "/")
+}
+
+func newRequest(remoteAddr string, forwardedFor ...string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remoteAddr
+	for _, f := range forwardedFor {
+		r.Header.Add("X-Forwarded-For", f)
+	}
+	return r
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := parseNetworks("10.0.0.0/8, 192.0.2.1,2001:db8::/32, 2001:db8::1")
+	require.NoError(t, err)
+	require.Len(t, networks, 4)
+	require.Equal(t, "192.0.2.1/32", networks[1].String())
+	require.Equal(t, "2001:db8::1/128", networks[3].String())
+
+	_, err = parseNetworks([]interface{}{"10.0.0.0/33"})
+	require.Error(t, err)
+	_, err = parseNetworks([]interface{}{"example.com"})
+	require.ErrorContains(t, err, "invalid ip address")
+	_, err = parseNetworks(42)
+	require.ErrorContains(t, err, "must be a list of networks")
+}
+
+func TestDistributionSelection(t *testing.T) {
+	pk := writePrivateKey(t)
+	options := map[string]interface{}{
+		"baseurl":        "https://public.example.com",
+		"privatekey":     pk,
+		"keypairid":      "public",
+		"trustedproxies": "10.0.0.1",
+		"distributions": []interface{}{
+			map[interface{}]interface{}{
+				"baseurl":    "https://internal.example.com",
+				"privatekey": pk,
+				"keypairid":  "internal",
+				"vpcranges":  []interface{}{"172.16.0.0/12", "fd00::/8"},
+			},
+			map[interface{}]interface{}{
+				"baseurl":    "https://office.example.com",
+				"privatekey": pk,
+				"keypairid":  "office",
+				"vpcranges":  "198.51.100.0/24",
+			},
+		},
+	}
+	middleware, err := newCloudFrontStorageMiddleware(context.Background(), keyerDriver{}, options)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		request *http.Request
+		want    string
+	}{
+		{request: newRequest("172.16.4.2:1234"), want: "https://internal.example.com/"},
+		{request: newRequest("[fd12::5]:1234"), want: "https://internal.example.com/"},
+		{request: newRequest("198.51.100.9:1234"), want: "https://office.example.com/"},
+		{request: newRequest("10.0.0.1:1234", "172.20.0.1"), want: "https://internal.example.com/"},
+		{request: newRequest("203.0.113.7:1234", "172.20.0.1"), want: "https://public.example.com/"},
+		{request: newRequest("[2001:db8::1]:1234"), want: "https://public.example.com/"},
+	} {
+		u, err := middleware.RedirectURL(tc.request, "/rick/data")
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(u, tc.want+"rick/data?"), "%s from %s", u, tc.request.RemoteAddr)
+	}
+}
+
+func TestDistributionInvalidConfig(t *testing.T) {
+	pk := writePrivateKey(t)
+	for _, tc := range []struct {
+		options map[string]interface{}
+		err     string
+	}{
+		{
+			options: map[string]interface{}{"distributions": "internal"},
+			err:     "distributions must be a list",
+		},
+		{
+			options: map[string]interface{}{"distributions": []interface{}{
+				map[string]interface{}{"baseurl": "https://internal.example.com", "privatekey": pk, "keypairid": "internal"},
+			}},
+			err: "distributions[0]: no vpcranges or ipfilteredby provided",
+		},
+		{
+			options: map[string]interface{}{"distributions": []interface{}{
+				map[string]interface{}{"baseurl": "https://internal.example.com", "vpcranges": "10.0.0.0/8"},
+			}},
+			err: "distributions[0]: no privatekey provided",
+		},
+		{
+			options: map[string]interface{}{"distributions": []interface{}{
+				map[string]interface{}{"baseurl": "https://internal.example.com", "privatekey": pk, "keypairid": "internal", "vpcranges": "10.0.0.0/40"},
+			}},
+			err: "distributions[0]: invalid vpcranges",
+		},
+		{
+			options: map[string]interface{}{"trustedproxies": []interface{}{"proxy"}},
+			err:     "invalid trustedproxies",
+		},
+	} {
+		tc.options["baseurl"] = "https://public.example.com"
+		tc.options["privatekey"] = pk
+		tc.options["keypairid"] = "public"
+		_, err := newCloudFrontStorageMiddleware(context.Background(), keyerDriver{}, tc.options)
+		require.ErrorContains(t, err, tc.err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -36,6 +37,10 @@ type cloudFrontStorageMiddleware struct {
 	urlSigner *sign.URLSigner
 	baseURL   string
 	duration  time.Duration
+	// distributions are tried in order against the client IP before
+	// falling back to the default distribution at baseURL.
+	distributions  []*distribution
+	trustedProxies []net.IPNet
 }
 
 var _ storagedriver.StorageDriver = &cloudFrontStorageMiddleware{}
@@ -57,61 +62,16 @@ var _ storagedriver.StorageDriver = &cloudFrontStorageMiddleware{}
 //     default value. "aws", only aws IP goes to S3 directly. "awsregion", only
 //     regions listed in awsregion options goes to S3 directly
 //   - awsregion: a comma separated string of AWS regions.
+//   - distributions: a list of additional distributions, each with its own
+//     baseurl, privatekey and keypairid, selected for clients whose IP is in
+//     its vpcranges or matches its ipfilteredby and awsregion options.
+//   - trustedproxies: a list of proxy networks whose Forwarded and
+//     X-Forwarded-For headers are trusted when selecting a distribution.
 func newCloudFrontStorageMiddleware(ctx context.Context, storageDriver storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
-	// parse baseurl
-	base, ok := options["baseurl"]
-	if !ok {
-		return nil, fmt.Errorf("no baseurl provided")
-	}
-	baseURL, ok := base.(string)
-	if !ok {
-		return nil, fmt.Errorf("baseurl must be a string")
-	}
-	if !strings.Contains(baseURL, "://") {
-		baseURL = "https://" + baseURL
-	}
-	if !strings.HasSuffix(baseURL, "/") {
-		baseURL += "/"
-	}
-	if _, err := url.Parse(baseURL); err != nil {
-		return nil, fmt.Errorf("invalid baseurl: %v", err)
-	}
-
-	// parse privatekey to get pkPath
-	pk, ok := options["privatekey"]
-	if !ok {
-		return nil, fmt.Errorf("no privatekey provided")
-	}
-	pkPath, ok := pk.(string)
-	if !ok {
-		return nil, fmt.Errorf("privatekey must be a string")
-	}
-
-	// parse keypairid
-	kpid, ok := options["keypairid"]
-	if !ok {
-		return nil, fmt.Errorf("no keypairid provided")
-	}
-	keypairID, ok := kpid.(string)
-	if !ok {
-		return nil, fmt.Errorf("keypairid must be a string")
-	}
-
-	// get urlSigner from the file specified in pkPath
-	pkBytes, err := os.ReadFile(pkPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read privatekey file: %s", err)
-	}
-
-	block, _ := pem.Decode(pkBytes)
-	if block == nil {
-		return nil, fmt.Errorf("failed to decode private key as an rsa private key")
-	}
-	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	baseURL, urlSigner, err := parseDistribution(options)
 	if err != nil {
 		return nil, err
 	}
-	urlSigner := sign.NewURLSigner(keypairID, privateKey)
 
 	// parse duration
 	duration := 20 * time.Minute
@@ -159,51 +119,140 @@ func newCloudFrontStorageMiddleware(ctx context.Context, storageDriver storagedr
 	}
 
 	// parse ipfilteredby
-	var awsIPs *awsIPs
-	if i, ok := options["ipfilteredby"]; ok {
-		if ipFilteredBy, ok := i.(string); ok {
-			switch strings.ToLower(strings.TrimSpace(ipFilteredBy)) {
-			case "", "none":
-				awsIPs = nil
-			case "aws":
-				awsIPs, err = newAWSIPs(ctx, ipRangesURL, updateFrequency, nil)
-				if err != nil {
-					return nil, err
-				}
-			case "awsregion":
-				var awsRegion []string
-				if i, ok := options["awsregion"]; ok {
-					if regions, ok := i.(string); ok {
-						for _, awsRegions := range strings.Split(regions, ",") {
-							awsRegion = append(awsRegion, strings.ToLower(strings.TrimSpace(awsRegions)))
-						}
-						awsIPs, err = newAWSIPs(ctx, ipRangesURL, updateFrequency, awsRegion)
-						if err != nil {
-							return nil, err
-						}
-					} else {
-						return nil, fmt.Errorf("awsRegion must be a comma separated string of valid aws regions")
-					}
-				} else {
-					return nil, fmt.Errorf("awsRegion is not defined")
-				}
-			default:
-				return nil, fmt.Errorf("ipfilteredby only allows a string the following value: none|aws|awsregion")
+	awsIPs, err := parseIPFilter(ctx, options, ipRangesURL, updateFrequency)
+	if err != nil {
+		return nil, err
+	}
+
+	// parse trustedproxies
+	var trustedProxies []net.IPNet
+	if t, ok := options["trustedproxies"]; ok {
+		trustedProxies, err = parseNetworks(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trustedproxies: %v", err)
+		}
+	}
+
+	// parse distributions
+	var distributions []*distribution
+	if d, ok := options["distributions"]; ok {
+		entries, ok := d.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("distributions must be a list")
+		}
+		for i, entry := range entries {
+			dist, err := newDistribution(ctx, entry, ipRangesURL, updateFrequency)
+			if err != nil {
+				return nil, fmt.Errorf("distributions[%d]: %v", i, err)
 			}
-		} else {
-			return nil, fmt.Errorf("ipfilteredby only allows a string with the following value: none|aws|awsregion")
+			distributions = append(distributions, dist)
 		}
 	}
 
 	return &cloudFrontStorageMiddleware{
-		StorageDriver: storageDriver,
-		urlSigner:     urlSigner,
-		baseURL:       baseURL,
-		duration:      duration,
-		awsIPs:        awsIPs,
+		StorageDriver:  storageDriver,
+		urlSigner:      urlSigner,
+		baseURL:        baseURL,
+		duration:       duration,
+		awsIPs:         awsIPs,
+		distributions:  distributions,
+		trustedProxies: trustedProxies,
 	}, nil
 }
 
+// parseDistribution parses the baseurl, privatekey and keypairid options of
+// a CloudFront distribution.
+func parseDistribution(options map[string]interface{}) (string, *sign.URLSigner, error) {
+	// parse baseurl
+	base, ok := options["baseurl"]
+	if !ok {
+		return "", nil, fmt.Errorf("no baseurl provided")
+	}
+	baseURL, ok := base.(string)
+	if !ok {
+		return "", nil, fmt.Errorf("baseurl must be a string")
+	}
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "https://" + baseURL
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	if _, err := url.Parse(baseURL); err != nil {
+		return "", nil, fmt.Errorf("invalid baseurl: %v", err)
+	}
+
+	// parse privatekey to get pkPath
+	pk, ok := options["privatekey"]
+	if !ok {
+		return "", nil, fmt.Errorf("no privatekey provided")
+	}
+	pkPath, ok := pk.(string)
+	if !ok {
+		return "", nil, fmt.Errorf("privatekey must be a string")
+	}
+
+	// parse keypairid
+	kpid, ok := options["keypairid"]
+	if !ok {
+		return "", nil, fmt.Errorf("no keypairid provided")
+	}
+	keypairID, ok := kpid.(string)
+	if !ok {
+		return "", nil, fmt.Errorf("keypairid must be a string")
+	}
+
+	// get urlSigner from the file specified in pkPath
+	pkBytes, err := os.ReadFile(pkPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read privatekey file: %s", err)
+	}
+
+	block, _ := pem.Decode(pkBytes)
+	if block == nil {
+		return "", nil, fmt.Errorf("failed to decode private key as an rsa private key")
+	}
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return "", nil, err
+	}
+	return baseURL, sign.NewURLSigner(keypairID, privateKey), nil
+}
+
+// parseIPFilter parses the ipfilteredby and awsregion options.
+func parseIPFilter(ctx context.Context, options map[string]interface{}, ipRangesURL string, updateFrequency time.Duration) (*awsIPs, error) {
+	i, ok := options["ipfilteredby"]
+	if !ok {
+		return nil, nil
+	}
+	ipFilteredBy, ok := i.(string)
+	if !ok {
+		return nil, fmt.Errorf("ipfilteredby only allows a string with the following value: none|aws|awsregion")
+	}
+	switch strings.ToLower(strings.TrimSpace(ipFilteredBy)) {
+	case "", "none":
+		return nil, nil
+	case "aws":
+		return newAWSIPs(ctx, ipRangesURL, updateFrequency, nil)
+	case "awsregion":
+		i, ok := options["awsregion"]
+		if !ok {
+			return nil, fmt.Errorf("awsRegion is not defined")
+		}
+		regions, ok := i.(string)
+		if !ok {
+			return nil, fmt.Errorf("awsRegion must be a comma separated string of valid aws regions")
+		}
+		var awsRegion []string
+		for _, awsRegions := range strings.Split(regions, ",") {
+			awsRegion = append(awsRegion, strings.ToLower(strings.TrimSpace(awsRegions)))
+		}
+		return newAWSIPs(ctx, ipRangesURL, updateFrequency, awsRegion)
+	default:
+		return nil, fmt.Errorf("ipfilteredby only allows a string the following value: none|aws|awsregion")
+	}
+}
+
 // S3BucketKeyer is any type that is capable of returning the S3 bucket key
 // which should be cached by AWS CloudFront.
 type S3BucketKeyer interface {
@@ -213,6 +262,11 @@ type S3BucketKeyer interface {
 // RedirectURL attempts to find a url which may be used to retrieve the file at the given path.
 // Returns an error if the file cannot be found.
 func (lh *cloudFrontStorageMiddleware) RedirectURL(r *http.Request, path string) (string, error) {
+	// CloudFront only serves the content, uploads go to the storage backend.
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return lh.StorageDriver.RedirectURL(r, path)
+	}
+
 	// TODO(endophage): currently only supports S3
 	keyer, ok := lh.StorageDriver.(S3BucketKeyer)
 	if !ok {
@@ -225,7 +279,11 @@ func (lh *cloudFrontStorageMiddleware) RedirectURL(r *http.Request, path string)
 	}
 
 	// Get signed cloudfront url.
-	cfURL, err := lh.urlSigner.Sign(lh.baseURL+keyer.S3BucketKey(path), time.Now().Add(lh.duration))
+	baseURL, urlSigner := lh.baseURL, lh.urlSigner
+	if dist := lh.selectDistribution(r); dist != nil {
+		baseURL, urlSigner = dist.baseURL, dist.urlSigner
+	}
+	cfURL, err := urlSigner.Sign(baseURL+keyer.S3BucketKey(path), time.Now().Add(lh.duration))
 	if err != nil {
 		return "", err
 	}
@@ -2,10 +2,15 @@ package middleware
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
+	"time"
 
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
@@ -18,11 +23,34 @@ func init() {
 	}
 }
 
+const (
+	// defaultSignerTTL is how long a signed URL stays valid when the
+	// urlsigner options do not set a ttl.
+	defaultSignerTTL = 20 * time.Minute
+	// defaultExpiresParam and defaultSignatureParam are the query
+	// parameters carrying the expiry and signature of a signed URL.
+	defaultExpiresParam   = "expires"
+	defaultSignatureParam = "signature"
+)
+
 type redirectStorageMiddleware struct {
 	storagedriver.StorageDriver
-	scheme   string
-	host     string
-	basePath string
+	scheme      string
+	host        string
+	basePath    string
+	queryParams url.Values
+	signer      *urlSigner
+}
+
+// urlSigner appends an expiry and an HMAC-SHA256 signature over the expiry
+// and the URL path to redirected URLs, in the form expected by nginx
+// secure_link style verification: base64url(HMAC(secret, expires + path)).
+type urlSigner struct {
+	secret         []byte
+	ttl            time.Duration
+	expiresParam   string
+	signatureParam string
+	now            func() time.Time
 }
 
 var _ storagedriver.StorageDriver = &redirectStorageMiddleware{}
@@ -47,13 +75,136 @@ func newRedirectStorageMiddleware(ctx context.Context, sd storagedriver.StorageD
 		return nil, fmt.Errorf("no host specified for redirect baseurl")
 	}
 
-	return &redirectStorageMiddleware{StorageDriver: sd, scheme: u.Scheme, host: u.Host, basePath: u.Path}, nil
+	m := &redirectStorageMiddleware{StorageDriver: sd, scheme: u.Scheme, host: u.Host, basePath: u.Path}
+
+	if p, ok := options["addqueryparams"]; ok && p != nil {
+		params, err := stringMap(p)
+		if err != nil {
+			return nil, fmt.Errorf("addqueryparams: %v", err)
+		}
+		m.queryParams = url.Values{}
+		for k, v := range params {
+			m.queryParams.Set(k, fmt.Sprint(v))
+		}
+	}
+
+	if s, ok := options["urlsigner"]; ok && s != nil {
+		signer, err := newURLSigner(s)
+		if err != nil {
+			return nil, fmt.Errorf("urlsigner: %v", err)
+		}
+		m.signer = signer
+	}
+
+	return m, nil
+}
+
+func newURLSigner(options interface{}) (*urlSigner, error) {
+	params, err := stringMap(options)
+	if err != nil {
+		return nil, err
+	}
+	s := &urlSigner{
+		ttl:            defaultSignerTTL,
+		expiresParam:   defaultExpiresParam,
+		signatureParam: defaultSignatureParam,
+		now:            time.Now,
+	}
+
+	secret, ok := params["secret"].(string)
+	if !ok || secret == "" {
+		return nil, fmt.Errorf("no secret provided")
+	}
+	s.secret = []byte(secret)
+
+	if t, ok := params["ttl"]; ok && t != nil {
+		switch t := t.(type) {
+		case time.Duration:
+			s.ttl = t
+		case string:
+			ttl, err := time.ParseDuration(t)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ttl: %s", err)
+			}
+			s.ttl = ttl
+		default:
+			return nil, fmt.Errorf("invalid ttl: %#v", t)
+		}
+		if s.ttl <= 0 {
+			return nil, fmt.Errorf("ttl must be positive")
+		}
+	}
+
+	for name, dst := range map[string]*string{"expiresparam": &s.expiresParam, "signatureparam": &s.signatureParam} {
+		if v, ok := params[name]; ok && v != nil {
+			str, ok := v.(string)
+			if !ok || str == "" {
+				return nil, fmt.Errorf("%s must be a non-empty string", name)
+			}
+			*dst = str
+		}
+	}
+	if s.expiresParam == s.signatureParam {
+		return nil, fmt.Errorf("expiresparam and signatureparam must differ")
+	}
+
+	return s, nil
 }
 
-func (r *redirectStorageMiddleware) RedirectURL(_ *http.Request, urlPath string) (string, error) {
+// sign adds the expiry and signature of urlPath to query.
+func (s *urlSigner) sign(urlPath string, query url.Values) {
+	expires := strconv.FormatInt(s.now().Add(s.ttl).Unix(), 10)
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(expires + urlPath))
+	query.Set(s.expiresParam, expires)
+	query.Set(s.signatureParam, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// stringMap converts a map decoded from the configuration, whose keys may
+// not be typed as strings, to a map[string]interface{}.
+func stringMap(v interface{}) (map[string]interface{}, error) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, nil
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid key %#v", k)
+			}
+			res[key] = v
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("must be a map, got %T", v)
+	}
+}
+
+// RedirectURL returns the URL of the content at urlPath under the configured
+// base URL, with the configured query parameters and, if signing is enabled,
+// an expiry and signature computed for this call. Uploads are never redirected, since the base URL is not expected
+// to accept them.
+func (r *redirectStorageMiddleware) RedirectURL(req *http.Request, urlPath string) (string, error) {
+	if req != nil && req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return "", nil
+	}
 	if r.basePath != "" {
 		urlPath = path.Join(r.basePath, urlPath)
 	}
+	if !path.IsAbs(urlPath) {
+		urlPath = "/" + urlPath
+	}
 	u := &url.URL{Scheme: r.scheme, Host: r.host, Path: urlPath}
+	if r.queryParams != nil || r.signer != nil {
+		query := url.Values{}
+		for k, v := range r.queryParams {
+			query[k] = v
+		}
+		if r.signer != nil {
+			r.signer.sign(urlPath, query)
+		}
+		u.RawQuery = query.Encode()
+	}
 	return u.String(), nil
 }
@@ -2,7 +2,14 @@ package middleware
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -94,3 +101,111 @@ func TestPath(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "https://example.com/path/morty/data", url)
 }
+
+func TestUploadNotRedirected(t *testing.T) {
+	options := make(map[string]interface{})
+	options["baseurl"] = "https://example.com"
+	middleware, err := newRedirectStorageMiddleware(context.Background(), nil, options)
+	require.NoError(t, err)
+
+	url, err := middleware.RedirectURL(httptest.NewRequest(http.MethodPut, "/", nil), "/rick/data")
+	require.NoError(t, err)
+	require.Empty(t, url)
+}
+
+func TestAddQueryParams(t *testing.T) {
+	options := make(map[string]interface{})
+	options["baseurl"] = "https://example.com/path"
+	options["addqueryparams"] = map[interface{}]interface{}{"token": "abc", "v": 2}
+	middleware, err := newRedirectStorageMiddleware(context.Background(), nil, options)
+	require.NoError(t, err)
+
+	url, err := middleware.RedirectURL(nil, "/rick/data")
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/path/rick/data?token=abc&v=2", url)
+
+	options["addqueryparams"] = "token=abc"
+	_, err = newRedirectStorageMiddleware(context.Background(), nil, options)
+	require.ErrorContains(t, err, "addqueryparams: must be a map")
+}
+
+func TestURLSigner(t *testing.T) {
+	options := make(map[string]interface{})
+	options["baseurl"] = "https://example.com/path"
+	options["addqueryparams"] = map[string]interface{}{"token": "abc"}
+	options["urlsigner"] = map[interface{}]interface{}{
+		"secret": "s3cr3t",
+		"ttl":    "10m",
+	}
+	middleware, err := newRedirectStorageMiddleware(context.Background(), nil, options)
+	require.NoError(t, err)
+
+	m, ok := middleware.(*redirectStorageMiddleware)
+	require.True(t, ok)
+	now := time.Unix(1700000000, 0)
+	m.signer.now = func() time.Time { return now }
+
+	redirect, err := middleware.RedirectURL(nil, "/rick/data")
+	require.NoError(t, err)
+	u, err := url.Parse(redirect)
+	require.NoError(t, err)
+	require.Equal(t, "/path/rick/data", u.Path)
+
+	query := u.Query()
+	require.Equal(t, "abc", query.Get("token"))
+	require.Equal(t, "1700000600", query.Get("expires"))
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte("1700000600/path/rick/data"))
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), query.Get("signature"))
+
+	// The expiry is computed on every call.
+	now = now.Add(time.Minute)
+	redirect, err = middleware.RedirectURL(nil, "/rick/data")
+	require.NoError(t, err)
+	u, err = url.Parse(redirect)
+	require.NoError(t, err)
+	require.Equal(t, "1700000660", u.Query().Get("expires"))
+}
+
+func TestURLSignerParams(t *testing.T) {
+	options := make(map[string]interface{})
+	options["baseurl"] = "https://example.com"
+	options["urlsigner"] = map[string]interface{}{
+		"secret":         "s3cr3t",
+		"expiresparam":   "e",
+		"signatureparam": "st",
+	}
+	middleware, err := newRedirectStorageMiddleware(context.Background(), nil, options)
+	require.NoError(t, err)
+
+	m, ok := middleware.(*redirectStorageMiddleware)
+	require.True(t, ok)
+	require.Equal(t, defaultSignerTTL, m.signer.ttl)
+
+	redirect, err := middleware.RedirectURL(nil, "/rick/data")
+	require.NoError(t, err)
+	u, err := url.Parse(redirect)
+	require.NoError(t, err)
+	require.NotEmpty(t, u.Query().Get("e"))
+	require.NotEmpty(t, u.Query().Get("st"))
+}
+
+func TestURLSignerInvalidConfig(t *testing.T) {
+	for _, tc := range []struct {
+		signer interface{}
+		err    string
+	}{
+		{signer: "s3cr3t", err: "urlsigner: must be a map"},
+		{signer: map[string]interface{}{"ttl": "10m"}, err: "urlsigner: no secret provided"},
+		{signer: map[string]interface{}{"secret": ""}, err: "urlsigner: no secret provided"},
+		{signer: map[string]interface{}{"secret": "s3cr3t", "ttl": "soon"}, err: "urlsigner: invalid ttl"},
+		{signer: map[string]interface{}{"secret": "s3cr3t", "ttl": "-1m"}, err: "urlsigner: ttl must be positive"},
+		{signer: map[string]interface{}{"secret": "s3cr3t", "expiresparam": "signature"}, err: "must differ"},
+	} {
+		options := make(map[string]interface{})
+		options["baseurl"] = "https://example.com"
+		options["urlsigner"] = tc.signer
+		_, err := newRedirectStorageMiddleware(context.Background(), nil, options)
+		require.ErrorContains(t, err, tc.err)
+	}
+}
@@ -0,0 +1,190 @@
+// Package middleware provides a storage middleware that retries the
+// idempotent operations of the wrapped driver when they fail transiently.
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultMaxAttempts is the default number of attempts of an operation,
+	// including the first one.
+	defaultMaxAttempts = 3
+	// defaultInitialBackoff and defaultMaxBackoff bound the delays between
+	// the attempts, doubled after each one.
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 2 * time.Second
+	// defaultMaxDuration is the default time budget of an operation and its
+	// retries.
+	defaultMaxDuration = 10 * time.Second
+)
+
+// retries counts the operations retried, by action.
+var retries = prometheus.StorageNamespace.NewLabeledCounter("retries", "The number of storage actions retried after a transient error", "action")
+
+func init() {
+	if err := storagemiddleware.Register("retry", newRetryStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register retry storage middleware: %v", err)
+	}
+}
+
+// retryStorageMiddleware retries the operations of the wrapped driver which
+// only read from the backend, GetContent, Stat, List, opening a Reader and
+// RedirectURL, when they fail with a retriable error, as classified by
+// storagedriver.CategoryOf. Writes, moves and deletes are passed straight
+// through: retrying them is only safe if the driver knows the first attempt
+// had no effect.
+type retryStorageMiddleware struct {
+	storagedriver.StorageDriver
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	maxDuration    time.Duration
+}
+
+var _ storagedriver.StorageDriver = &retryStorageMiddleware{}
+
+func newRetryStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	maxAttempts, err := base.GetLimitFromParameter(options["maxattempts"], 1, defaultMaxAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("maxattempts config error: %v", err)
+	}
+	m := &retryStorageMiddleware{
+		StorageDriver:  sd,
+		maxAttempts:    int(maxAttempts),
+		initialBackoff: defaultInitialBackoff,
+		maxBackoff:     defaultMaxBackoff,
+		maxDuration:    defaultMaxDuration,
+	}
+	for name, dst := range map[string]*time.Duration{
+		"initialbackoff": &m.initialBackoff,
+		"maxbackoff":     &m.maxBackoff,
+		"maxduration":    &m.maxDuration,
+	} {
+		if err := parseDuration(options[name], dst); err != nil {
+			return nil, fmt.Errorf("%s config error: %v", name, err)
+		}
+	}
+	if m.maxBackoff < m.initialBackoff {
+		return nil, fmt.Errorf("maxbackoff must not be less than initialbackoff")
+	}
+	return m, nil
+}
+
+// parseDuration sets dst to the positive duration of param, a string or a
+// time.Duration, leaving it unchanged if param is nil.
+func parseDuration(param interface{}, dst *time.Duration) error {
+	var d time.Duration
+	switch v := param.(type) {
+	case nil:
+		return nil
+	case time.Duration:
+		d = v
+	case string:
+		var err error
+		if d, err = time.ParseDuration(v); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid duration %#v", param)
+	}
+	if d <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	*dst = d
+	return nil
+}
+
+// retry calls op until it succeeds, fails with an error which is not
+// retriable, or the attempts or the time budget are exhausted, backing off
+// exponentially between the attempts. It stops waiting as soon as ctx is
+// done. An operation which is given up on returns its last error, annotated
+// with the number of attempts.
+func retry[T any](ctx context.Context, m *retryStorageMiddleware, action string, op func() (T, error)) (T, error) {
+	deadline := time.Now().Add(m.maxDuration)
+	backoff := m.initialBackoff
+	for attempt := 1; ; attempt++ {
+		res, err := op()
+		if err == nil || !storagedriver.CategoryOf(err).Retriable() {
+			return res, err
+		}
+		if attempt >= m.maxAttempts {
+			return res, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		// Full jitter keeps the registry instances which failed together
+		// from retrying together.
+		delay := time.Duration(rand.Int63n(int64(backoff))) + 1
+		if time.Until(deadline) < delay {
+			return res, fmt.Errorf("giving up after %d attempts, out of time: %w", attempt, err)
+		}
+		dcontext.GetLogger(ctx).WithError(err).Debugf("retrying storage %s in %v", action, delay)
+		retries.WithValues(action).Inc(1)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, fmt.Errorf("giving up after %d attempts, %v: %w", attempt, ctx.Err(), err)
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, m.maxBackoff)
+	}
+}
+
+// GetContent wraps GetContent of the underlying storage driver, retrying it
+// on transient errors.
+func (m *retryStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	return retry(ctx, m, "GetContent", func() ([]byte, error) {
+		return m.StorageDriver.GetContent(ctx, path)
+	})
+}
+
+// Reader wraps Reader of the underlying storage driver, retrying the opening
+// of the reader on transient errors. The errors of the reads themselves are
+// returned as is.
+func (m *retryStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	return retry(ctx, m, "Reader", func() (io.ReadCloser, error) {
+		return m.StorageDriver.Reader(ctx, path, offset)
+	})
+}
+
+// Stat wraps Stat of the underlying storage driver, retrying it on transient
+// errors.
+func (m *retryStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	return retry(ctx, m, "Stat", func() (storagedriver.FileInfo, error) {
+		return m.StorageDriver.Stat(ctx, path)
+	})
+}
+
+// List wraps List of the underlying storage driver, retrying it on transient
+// errors.
+func (m *retryStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	return retry(ctx, m, "List", func() ([]string, error) {
+		return m.StorageDriver.List(ctx, path)
+	})
+}
+
+// RedirectURL wraps RedirectURL of the underlying storage driver, retrying it
+// on transient errors.
+func (m *retryStorageMiddleware) RedirectURL(r *http.Request, path string) (string, error) {
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+	return retry(ctx, m, "RedirectURL", func() (string, error) {
+		return m.StorageDriver.RedirectURL(r, path)
+	})
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyDriver fails the calls of its methods with err until failures is
+// exhausted, counting the calls.
+type flakyDriver struct {
+	storagedriver.StorageDriver
+	err      error
+	failures int
+	calls    int
+}
+
+func (d *flakyDriver) fail() error {
+	d.calls++
+	if d.failures > 0 {
+		d.failures--
+		return d.err
+	}
+	return nil
+}
+
+func (d *flakyDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if err := d.fail(); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *flakyDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	if err := d.fail(); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.Stat(ctx, path)
+}
+
+func (d *flakyDriver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	if err := d.fail(); err != nil {
+		return err
+	}
+	return d.StorageDriver.Move(ctx, sourcePath, destPath)
+}
+
+func newTestMiddleware(t *testing.T, d storagedriver.StorageDriver, options map[string]interface{}) *retryStorageMiddleware {
+	t.Helper()
+	opts := map[string]interface{}{"initialbackoff": "1ms", "maxbackoff": "2ms"}
+	for k, v := range options {
+		opts[k] = v
+	}
+	m, err := newRetryStorageMiddleware(context.Background(), d, opts)
+	require.NoError(t, err)
+	return m.(*retryStorageMiddleware)
+}
+
+func TestOptions(t *testing.T) {
+	m, err := newRetryStorageMiddleware(context.Background(), nil, map[string]interface{}{})
+	require.NoError(t, err)
+	r := m.(*retryStorageMiddleware)
+	require.Equal(t, defaultMaxAttempts, r.maxAttempts)
+	require.Equal(t, defaultInitialBackoff, r.initialBackoff)
+	require.Equal(t, defaultMaxBackoff, r.maxBackoff)
+	require.Equal(t, defaultMaxDuration, r.maxDuration)
+
+	m, err = newRetryStorageMiddleware(context.Background(), nil, map[string]interface{}{
+		"maxattempts":    5,
+		"initialbackoff": "50ms",
+		"maxbackoff":     time.Second,
+		"maxduration":    "30s",
+	})
+	require.NoError(t, err)
+	r = m.(*retryStorageMiddleware)
+	require.Equal(t, 5, r.maxAttempts)
+	require.Equal(t, 50*time.Millisecond, r.initialBackoff)
+	require.Equal(t, time.Second, r.maxBackoff)
+	require.Equal(t, 30*time.Second, r.maxDuration)
+
+	for _, options := range []map[string]interface{}{
+		{"maxattempts": "many"},
+		{"initialbackoff": "soon"},
+		{"maxduration": "-1s"},
+		{"maxbackoff": 10},
+		{"initialbackoff": "1s", "maxbackoff": "100ms"},
+	} {
+		_, err := newRetryStorageMiddleware(context.Background(), nil, options)
+		require.Error(t, err, "%v", options)
+	}
+}
+
+func TestRetryTransientErrors(t *testing.T) {
+	ctx := context.Background()
+	d := &flakyDriver{
+		StorageDriver: inmemory.New(),
+		err:           storagedriver.WithCategory(errors.New("internal error"), storagedriver.ErrorCategoryUnavailable),
+	}
+	require.NoError(t, d.PutContent(ctx, "/a", []byte("content")))
+	m := newTestMiddleware(t, d, nil)
+
+	d.failures = 2
+	content, err := m.GetContent(ctx, "/a")
+	require.NoError(t, err)
+	require.Equal(t, []byte("content"), content)
+	require.Equal(t, 3, d.calls)
+
+	// Once the attempts are exhausted, the last error is returned with the
+	// number of attempts.
+	d.calls, d.failures = 0, 3
+	_, err = m.Stat(ctx, "/a")
+	require.ErrorIs(t, err, d.err)
+	require.Contains(t, err.Error(), "after 3 attempts")
+	require.Equal(t, storagedriver.ErrorCategoryUnavailable, storagedriver.CategoryOf(err))
+	require.Equal(t, 3, d.calls)
+}
+
+func TestNoRetry(t *testing.T) {
+	ctx := context.Background()
+	d := &flakyDriver{StorageDriver: inmemory.New()}
+	require.NoError(t, d.PutContent(ctx, "/a", []byte("content")))
+	m := newTestMiddleware(t, d, nil)
+
+	// Errors which are not transient are returned as is.
+	d.err, d.failures = errors.New("access denied"), 1
+	_, err := m.GetContent(ctx, "/a")
+	require.Equal(t, d.err, err)
+	require.Equal(t, 1, d.calls)
+
+	d.calls = 0
+	_, err = m.Stat(ctx, "/nonexistent")
+	require.IsType(t, storagedriver.PathNotFoundError{}, err)
+	require.Equal(t, 1, d.calls)
+
+	// Moves are not retried, even on transient errors.
+	d.calls, d.failures = 0, 1
+	d.err = storagedriver.WithCategory(errors.New("slow down"), storagedriver.ErrorCategoryThrottled)
+	require.ErrorIs(t, m.Move(ctx, "/a", "/b"), d.err)
+	require.Equal(t, 1, d.calls)
+}
+
+func TestRetryBudget(t *testing.T) {
+	d := &flakyDriver{
+		StorageDriver: inmemory.New(),
+		err:           storagedriver.WithCategory(errors.New("slow down"), storagedriver.ErrorCategoryThrottled),
+		failures:      100,
+	}
+
+	// The retries stop as soon as the context is done.
+	m := newTestMiddleware(t, d, map[string]interface{}{"maxattempts": 100, "initialbackoff": "1h", "maxbackoff": "1h", "maxduration": "2h"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := m.GetContent(ctx, "/a")
+	require.ErrorIs(t, err, d.err)
+	require.True(t, strings.Contains(err.Error(), "after 1 attempts"), err.Error())
+	require.Less(t, time.Since(start), time.Minute)
+
+	// They are not attempted past the time budget.
+	d.calls = 0
+	m = newTestMiddleware(t, d, map[string]interface{}{"maxattempts": 100, "initialbackoff": "1h", "maxbackoff": "1h", "maxduration": "1ms"})
+	_, err = m.GetContent(context.Background(), "/a")
+	require.ErrorIs(t, err, d.err)
+	require.Equal(t, 1, d.calls)
+}
@@ -0,0 +1,576 @@
+// Package middleware provides a storage middleware that keeps a local
+// filesystem copy of blob data read from the wrapped (remote) driver.
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/docker/go-metrics"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultMaxSize is the default upper bound, in bytes, of the local tier.
+	defaultMaxSize = uint64(10 << 30)
+
+	// minMaxSize is the smallest accepted value for the maxsize option.
+	minMaxSize = uint64(1 << 20)
+)
+
+var (
+	// cacheHits is the number of blob reads served from the local tier
+	cacheHits = prometheus.StorageNamespace.NewCounter("tiered_cache_hits", "The number of blob reads served from the local cache tier")
+	// cacheMisses is the number of blob reads forwarded to the remote tier
+	cacheMisses = prometheus.StorageNamespace.NewCounter("tiered_cache_misses", "The number of blob reads forwarded to the remote storage tier")
+	// cacheEvictions is the number of blobs evicted from the local tier
+	cacheEvictions = prometheus.StorageNamespace.NewCounter("tiered_cache_evictions", "The number of blobs evicted from the local cache tier")
+	// cacheSize is the number of bytes held by the local tier
+	cacheSize = prometheus.StorageNamespace.NewGauge("tiered_cache_size", "The number of bytes held by the local cache tier", metrics.Bytes)
+)
+
+func init() {
+	if err := storagemiddleware.Register("tiered", newTieredStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register tiered storage middleware: %v", err)
+	}
+}
+
+// tieredStorageMiddleware serves blob data from a local directory, falling
+// back to the wrapped driver on a miss. Only content addressed blob data is
+// cached: its path names the digest, so a copy can be verified before it is
+// kept and never goes stale. Every other path is passed straight through.
+type tieredStorageMiddleware struct {
+	storagedriver.StorageDriver
+	root    string
+	maxSize int64
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+	size    int64
+	// fetches holds the paths being fetched from the remote tier, so that
+	// a copy fetched concurrently with a write or delete is not kept.
+	fetches map[string]*fetch
+	// below indexes the cached and fetched paths by the directories above
+	// them, so that invalidating a directory finds them without a scan.
+	below map[string]map[string]struct{}
+}
+
+type cacheEntry struct {
+	path string
+	size int64
+}
+
+// fetch is a path being fetched from the remote tier by refs readers. Its
+// generation is incremented when the path is invalidated.
+type fetch struct {
+	refs       int
+	generation uint64
+}
+
+var _ storagedriver.StorageDriver = &tieredStorageMiddleware{}
+
+func newTieredStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	o, ok := options["rootdirectory"]
+	if !ok {
+		return nil, fmt.Errorf("no rootdirectory provided")
+	}
+	root, ok := o.(string)
+	if !ok || root == "" {
+		return nil, fmt.Errorf("rootdirectory must be a non-empty string")
+	}
+	maxSize, err := base.GetLimitFromParameter(options["maxsize"], minMaxSize, defaultMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("maxsize config error: %v", err)
+	}
+
+	t := &tieredStorageMiddleware{
+		StorageDriver: sd,
+		root:          root,
+		maxSize:       int64(maxSize),
+		lru:           list.New(),
+		entries:       make(map[string]*list.Element),
+		fetches:       make(map[string]*fetch),
+		below:         make(map[string]map[string]struct{}),
+	}
+	if err := t.load(ctx); err != nil {
+		return nil, fmt.Errorf("unable to load cache directory %s: %v", root, err)
+	}
+	return t, nil
+}
+
+// load indexes the blobs left in the cache directory by a previous run,
+// least recently modified first, and discards incomplete downloads.
+func (t *tieredStorageMiddleware) load(ctx context.Context) error {
+	if err := os.RemoveAll(t.tempDir()); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(t.tempDir(), 0o755); err != nil {
+		return err
+	}
+
+	type found struct {
+		entry   *cacheEntry
+		modTime int64
+	}
+	var files []found
+	err := filepath.WalkDir(t.dataDir(), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(t.dataDir(), p)
+		if err != nil {
+			return err
+		}
+		subPath := "/" + filepath.ToSlash(rel)
+		if _, ok := blobDigest(subPath); !ok {
+			return os.Remove(p)
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, found{entry: &cacheEntry{path: subPath, size: fi.Size()}, modTime: fi.ModTime().UnixNano()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slices.SortFunc(files, func(a, b found) int {
+		switch {
+		case a.modTime > b.modTime:
+			return -1
+		case a.modTime < b.modTime:
+			return 1
+		}
+		return 0
+	})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, f := range files {
+		t.entries[f.entry.path] = t.lru.PushBack(f.entry)
+		t.index(f.entry.path)
+		t.size += f.entry.size
+	}
+	t.evict(ctx)
+	cacheSize.Set(float64(t.size))
+	return nil
+}
+
+// GetContent returns the content at path, from the local tier if present.
+func (t *tieredStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	dgst, ok := blobDigest(path)
+	if !ok {
+		return t.StorageDriver.GetContent(ctx, path)
+	}
+
+	if t.touch(path) {
+		content, err := os.ReadFile(t.localPath(path))
+		if err == nil {
+			cacheHits.Inc(1)
+			return content, nil
+		}
+		t.invalidate(path)
+	}
+	cacheMisses.Inc(1)
+
+	generation := t.startFetch(path)
+	defer t.endFetch(path)
+	content, err := t.StorageDriver.GetContent(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if dgst.Algorithm().FromBytes(content) != dgst {
+		dcontext.GetLogger(ctx).Warnf("tiered: content at %s does not match its digest, not caching", path)
+		return content, nil
+	}
+
+	f, err := os.CreateTemp(t.tempDir(), "blob")
+	if err != nil {
+		dcontext.GetLogger(ctx).Warnf("tiered: unable to cache %s: %v", path, err)
+		return content, nil
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = t.commit(ctx, f.Name(), path, int64(len(content)), generation)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		dcontext.GetLogger(ctx).Warnf("tiered: unable to cache %s: %v", path, err)
+	}
+	return content, nil
+}
+
+// Reader returns a reader of the content at path starting at offset, from
+// the local tier if present. A miss read from the start populates the local
+// tier once the whole blob has been read and verified.
+func (t *tieredStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	dgst, ok := blobDigest(path)
+	if !ok || offset < 0 {
+		return t.StorageDriver.Reader(ctx, path, offset)
+	}
+
+	if t.touch(path) {
+		if rc, err := t.openLocal(path, offset); err == nil {
+			cacheHits.Inc(1)
+			return rc, nil
+		}
+	}
+	cacheMisses.Inc(1)
+	if offset != 0 {
+		return t.StorageDriver.Reader(ctx, path, offset)
+	}
+
+	generation := t.startFetch(path)
+	rc, err := t.StorageDriver.Reader(ctx, path, offset)
+	if err != nil {
+		t.endFetch(path)
+		return rc, err
+	}
+
+	f, err := os.CreateTemp(t.tempDir(), "blob")
+	if err != nil {
+		t.endFetch(path)
+		dcontext.GetLogger(ctx).Warnf("tiered: unable to cache %s: %v", path, err)
+		return rc, nil
+	}
+	return &populatingReader{
+		ctx:        ctx,
+		t:          t,
+		path:       path,
+		generation: generation,
+		remote:     rc,
+		file:       f,
+		verifier:   dgst.Verifier(),
+	}, nil
+}
+
+// openLocal opens the local copy of path at offset. Offsets past the end of
+// the copy are left to the remote driver so that it reports the error.
+func (t *tieredStorageMiddleware) openLocal(path string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(t.localPath(path))
+	if err != nil {
+		t.invalidate(path)
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil || offset > fi.Size() {
+		f.Close()
+		return nil, fmt.Errorf("offset %d beyond cached copy of %s", offset, path)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// PutContent stores content at path in the remote tier and drops any local copy.
+func (t *tieredStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	t.invalidate(path)
+	return t.StorageDriver.PutContent(ctx, path, content)
+}
+
+// Writer returns a writer to path in the remote tier and drops any local copy.
+func (t *tieredStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	t.invalidate(path)
+	return t.StorageDriver.Writer(ctx, path, append)
+}
+
+// Move moves sourcePath to destPath in the remote tier and drops local
+// copies of both.
+func (t *tieredStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	t.invalidate(sourcePath)
+	t.invalidate(destPath)
+	return t.StorageDriver.Move(ctx, sourcePath, destPath)
+}
+
+// Delete deletes path and everything below it from the remote tier and
+// drops the corresponding local copies.
+func (t *tieredStorageMiddleware) Delete(ctx context.Context, path string) error {
+	t.invalidate(path)
+	return t.StorageDriver.Delete(ctx, path)
+}
+
+// touch reports whether path is held by the local tier and, if so, marks it
+// as the most recently used entry.
+func (t *tieredStorageMiddleware) touch(path string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[path]
+	if ok {
+		t.lru.MoveToFront(e)
+	}
+	return ok
+}
+
+// startFetch registers a fetch of path from the remote tier, and returns the
+// generation of path to commit its copy with. The fetch must be ended with
+// endFetch.
+func (t *tieredStorageMiddleware) startFetch(path string) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.fetches[path]
+	if !ok {
+		f = &fetch{}
+		t.fetches[path] = f
+		t.index(path)
+	}
+	f.refs++
+	return f.generation
+}
+
+// endFetch ends a fetch registered by startFetch.
+func (t *tieredStorageMiddleware) endFetch(path string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if f := t.fetches[path]; f != nil {
+		if f.refs--; f.refs == 0 {
+			delete(t.fetches, path)
+			t.unindex(path)
+		}
+	}
+}
+
+// commit moves the verified download at tempPath into the local tier as path,
+// unless the path was invalidated since generation or the blob is too big.
+func (t *tieredStorageMiddleware) commit(ctx context.Context, tempPath, path string, size int64, generation uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if f := t.fetches[path]; f == nil || f.generation != generation || size > t.maxSize {
+		return os.Remove(tempPath)
+	}
+	local := t.localPath(path)
+	if err := os.MkdirAll(filepath.Dir(local), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, local); err != nil {
+		return err
+	}
+	if e, ok := t.entries[path]; ok {
+		t.size -= e.Value.(*cacheEntry).size
+		t.lru.Remove(e)
+	}
+	t.entries[path] = t.lru.PushFront(&cacheEntry{path: path, size: size})
+	t.index(path)
+	t.size += size
+	t.evict(ctx)
+	cacheSize.Set(float64(t.size))
+	return nil
+}
+
+// evict removes the least recently used entries until the local tier fits
+// within maxSize. It must be called with t.mu held.
+func (t *tieredStorageMiddleware) evict(ctx context.Context) {
+	for t.size > t.maxSize {
+		e := t.lru.Back()
+		entry := e.Value.(*cacheEntry)
+		t.lru.Remove(e)
+		delete(t.entries, entry.path)
+		t.unindex(entry.path)
+		t.size -= entry.size
+		if err := os.Remove(t.localPath(entry.path)); err != nil && !os.IsNotExist(err) {
+			dcontext.GetLogger(ctx).Warnf("tiered: unable to evict %s: %v", entry.path, err)
+		}
+		cacheEvictions.Inc(1)
+	}
+}
+
+// invalidate drops the local copies of path and of everything below it,
+// and the copies being fetched.
+func (t *tieredStorageMiddleware) invalidate(path string) {
+	if path != "/" {
+		path = strings.TrimSuffix(path, "/")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	paths := []string{path}
+	for p := range t.below[path] {
+		paths = append(paths, p)
+	}
+	for _, p := range paths {
+		if f, ok := t.fetches[p]; ok {
+			f.generation++
+		}
+		e, ok := t.entries[p]
+		if !ok {
+			continue
+		}
+		t.size -= e.Value.(*cacheEntry).size
+		t.lru.Remove(e)
+		delete(t.entries, p)
+		t.unindex(p)
+		os.Remove(t.localPath(p))
+	}
+	cacheSize.Set(float64(t.size))
+}
+
+// index adds path below its directories. It must be called with t.mu held.
+func (t *tieredStorageMiddleware) index(path string) {
+	for _, dir := range ancestors(path) {
+		paths, ok := t.below[dir]
+		if !ok {
+			paths = make(map[string]struct{})
+			t.below[dir] = paths
+		}
+		paths[path] = struct{}{}
+	}
+}
+
+// unindex removes path from below its directories, once it is neither
+// cached nor fetched. It must be called with t.mu held.
+func (t *tieredStorageMiddleware) unindex(path string) {
+	if _, ok := t.entries[path]; ok {
+		return
+	}
+	if _, ok := t.fetches[path]; ok {
+		return
+	}
+	for _, dir := range ancestors(path) {
+		if paths, ok := t.below[dir]; ok {
+			delete(paths, path)
+			if len(paths) == 0 {
+				delete(t.below, dir)
+			}
+		}
+	}
+}
+
+// ancestors returns the directories above the absolute slash separated path.
+func ancestors(path string) []string {
+	var dirs []string
+	for i := strings.LastIndex(path, "/"); i > 0; i = strings.LastIndex(path[:i], "/") {
+		dirs = append(dirs, path[:i])
+	}
+	return append(dirs, "/")
+}
+
+func (t *tieredStorageMiddleware) dataDir() string {
+	return filepath.Join(t.root, "data")
+}
+
+func (t *tieredStorageMiddleware) tempDir() string {
+	return filepath.Join(t.root, "tmp")
+}
+
+func (t *tieredStorageMiddleware) localPath(path string) string {
+	return filepath.Join(t.dataDir(), filepath.FromSlash(path))
+}
+
+// blobDigest returns the digest named by a blob data path of the form
+// <root>/blobs/<algorithm>/<first two hex bytes>/<hex digest>/data, and
+// whether path is such a path.
+func blobDigest(path string) (digest.Digest, bool) {
+	if !storagedriver.PathRegexp.MatchString(path) {
+		return "", false
+	}
+	parts := strings.Split(path, "/")
+	if slices.Contains(parts, "..") {
+		return "", false
+	}
+	n := len(parts)
+	if n < 6 || parts[n-1] != "data" || parts[n-5] != "blobs" {
+		return "", false
+	}
+	alg, prefix, encoded := parts[n-4], parts[n-3], parts[n-2]
+	if len(prefix) != 2 || !strings.HasPrefix(encoded, prefix) {
+		return "", false
+	}
+	dgst := digest.NewDigestFromEncoded(digest.Algorithm(alg), encoded)
+	if dgst.Validate() != nil {
+		return "", false
+	}
+	return dgst, true
+}
+
+// populatingReader copies what is read from the remote tier into a
+// temporary file, and commits it to the local tier if the whole blob was
+// read and matches its digest.
+type populatingReader struct {
+	ctx        context.Context
+	t          *tieredStorageMiddleware
+	path       string
+	generation uint64
+	remote     io.ReadCloser
+	file       *os.File
+	verifier   digest.Verifier
+	size       int64
+	failed     bool
+	done       bool
+	closed     bool
+}
+
+func (r *populatingReader) Read(p []byte) (int, error) {
+	n, err := r.remote.Read(p)
+	if n > 0 && !r.failed && !r.done {
+		if _, werr := r.file.Write(p[:n]); werr != nil {
+			dcontext.GetLogger(r.ctx).Warnf("tiered: unable to cache %s: %v", r.path, werr)
+			r.failed = true
+		}
+		r.verifier.Write(p[:n])
+		r.size += int64(n)
+	}
+	if err == io.EOF && !r.failed && !r.done {
+		r.done = true
+		r.finish()
+	}
+	return n, err
+}
+
+// finish commits the downloaded copy if it matches the digest.
+func (r *populatingReader) finish() {
+	if err := r.file.Close(); err != nil {
+		r.failed = true
+		return
+	}
+	if !r.verifier.Verified() {
+		dcontext.GetLogger(r.ctx).Warnf("tiered: content at %s does not match its digest, not caching", r.path)
+		r.failed = true
+		return
+	}
+	if err := r.t.commit(r.ctx, r.file.Name(), r.path, r.size, r.generation); err != nil {
+		dcontext.GetLogger(r.ctx).Warnf("tiered: unable to cache %s: %v", r.path, err)
+		r.failed = true
+	}
+}
+
+func (r *populatingReader) Close() error {
+	if !r.done || r.failed {
+		r.file.Close()
+		os.Remove(r.file.Name())
+	}
+	r.done = true
+	if !r.closed {
+		r.closed = true
+		r.t.endFetch(r.path)
+	}
+	return r.remote.Close()
+}
@@ -0,0 +1,248 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieredDriverSuite(t *testing.T) {
+	root := t.TempDir()
+	testsuites.Driver(t, func() (storagedriver.StorageDriver, error) {
+		return newTieredStorageMiddleware(context.Background(), inmemory.New(), map[string]interface{}{
+			"rootdirectory": root,
+		})
+	})
+}
+
+func TestNoConfig(t *testing.T) {
+	_, err := newTieredStorageMiddleware(context.Background(), inmemory.New(), map[string]interface{}{})
+	require.ErrorContains(t, err, "no rootdirectory provided")
+
+	_, err = newTieredStorageMiddleware(context.Background(), inmemory.New(), map[string]interface{}{
+		"rootdirectory": t.TempDir(),
+		"maxsize":       "lots",
+	})
+	require.ErrorContains(t, err, "maxsize config error")
+}
+
+func TestBlobDigest(t *testing.T) {
+	dgst := digest.FromString("blob")
+	blobPath := blobDataPath(dgst)
+
+	got, ok := blobDigest(blobPath)
+	require.True(t, ok)
+	require.Equal(t, dgst, got)
+
+	for _, p := range []string{
+		"/docker/registry/v2/repositories/foo/_layers/sha256/" + dgst.Encoded() + "/link",
+		"/docker/registry/v2/blobs/sha256/zz/" + dgst.Encoded() + "/data",
+		"/docker/registry/v2/blobs/sha256/" + dgst.Encoded()[:2] + "/abc/data",
+		"/docker/registry/v2/blobs/md5/" + dgst.Encoded()[:2] + "/" + dgst.Encoded() + "/data",
+		"/docker/registry/v2/../blobs/sha256/" + dgst.Encoded()[:2] + "/" + dgst.Encoded() + "/data",
+	} {
+		_, ok := blobDigest(p)
+		require.False(t, ok, p)
+	}
+}
+
+func TestReadPopulatesCache(t *testing.T) {
+	ctx := context.Background()
+	remote := inmemory.New()
+	d := newTestMiddleware(t, remote, t.TempDir(), 0)
+
+	content := []byte("some blob content")
+	blobPath := blobDataPath(digest.FromBytes(content))
+	require.NoError(t, d.PutContent(ctx, blobPath, content))
+
+	got, err := d.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.Equal(t, content, got)
+	require.FileExists(t, d.localPath(blobPath))
+
+	// Reads are now served locally, even if the remote tier is unavailable.
+	require.NoError(t, remote.Delete(ctx, blobPath))
+	got, err = d.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.Equal(t, content, got)
+
+	rc, err := d.Reader(ctx, blobPath, 5)
+	require.NoError(t, err)
+	got, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.Equal(t, content[5:], got)
+}
+
+func TestReaderPopulatesCache(t *testing.T) {
+	ctx := context.Background()
+	remote := inmemory.New()
+	d := newTestMiddleware(t, remote, t.TempDir(), 0)
+
+	content := bytes.Repeat([]byte("layer"), 4096)
+	blobPath := blobDataPath(digest.FromBytes(content))
+	require.NoError(t, remote.PutContent(ctx, blobPath, content))
+
+	// A partial read does not populate the local tier.
+	rc, err := d.Reader(ctx, blobPath, 0)
+	require.NoError(t, err)
+	_, err = io.ReadFull(rc, make([]byte, 10))
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.NoFileExists(t, d.localPath(blobPath))
+
+	rc, err = d.Reader(ctx, blobPath, 0)
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.Equal(t, content, got)
+	require.FileExists(t, d.localPath(blobPath))
+
+	entries, err := os.ReadDir(d.tempDir())
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestCorruptContentNotCached(t *testing.T) {
+	ctx := context.Background()
+	remote := inmemory.New()
+	d := newTestMiddleware(t, remote, t.TempDir(), 0)
+
+	blobPath := blobDataPath(digest.FromString("expected"))
+	require.NoError(t, remote.PutContent(ctx, blobPath, []byte("corrupted")))
+
+	got, err := d.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.Equal(t, []byte("corrupted"), got)
+	require.NoFileExists(t, d.localPath(blobPath))
+
+	rc, err := d.Reader(ctx, blobPath, 0)
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.NoFileExists(t, d.localPath(blobPath))
+}
+
+func TestWritesInvalidateCache(t *testing.T) {
+	ctx := context.Background()
+	remote := inmemory.New()
+	d := newTestMiddleware(t, remote, t.TempDir(), 0)
+
+	content := []byte("some blob content")
+	dgst := digest.FromBytes(content)
+	blobPath := blobDataPath(dgst)
+	require.NoError(t, remote.PutContent(ctx, blobPath, content))
+
+	_, err := d.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.FileExists(t, d.localPath(blobPath))
+
+	require.NoError(t, d.Delete(ctx, path.Dir(path.Dir(blobPath))))
+	require.NoFileExists(t, d.localPath(blobPath))
+	_, err = d.GetContent(ctx, blobPath)
+	require.ErrorAs(t, err, &storagedriver.PathNotFoundError{})
+
+	require.NoError(t, remote.PutContent(ctx, blobPath, content))
+	_, err = d.GetContent(ctx, blobPath)
+	require.NoError(t, err)
+	require.FileExists(t, d.localPath(blobPath))
+
+	require.NoError(t, d.Move(ctx, blobPath, "/elsewhere"))
+	require.NoFileExists(t, d.localPath(blobPath))
+	require.Zero(t, d.size)
+}
+
+func TestInvalidationIsScoped(t *testing.T) {
+	ctx := context.Background()
+	remote := inmemory.New()
+	d := newTestMiddleware(t, remote, t.TempDir(), 0)
+
+	blob := func(content string) string {
+		p := blobDataPath(digest.FromString(content))
+		require.NoError(t, remote.PutContent(ctx, p, []byte(content)))
+		return p
+	}
+	a, b := blob("a"), blob("b")
+	_, err := d.GetContent(ctx, a)
+	require.NoError(t, err)
+
+	// Writes elsewhere leave the cached blobs.
+	require.NoError(t, d.PutContent(ctx, "/docker/registry/v2/repositories/foo/_layers/link", []byte("link")))
+	require.FileExists(t, d.localPath(a))
+
+	// Invalidating a blob being fetched drops its copy, not the others.
+	rc, err := d.Reader(ctx, b, 0)
+	require.NoError(t, err)
+	require.NoError(t, d.Delete(ctx, path.Dir(b)))
+	_, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.NoFileExists(t, d.localPath(b))
+	require.FileExists(t, d.localPath(a))
+	require.Empty(t, d.fetches)
+
+	require.NoError(t, d.Delete(ctx, "/docker/registry/v2/blobs"))
+	require.NoFileExists(t, d.localPath(a))
+	require.Empty(t, d.entries)
+	require.Empty(t, d.below)
+}
+
+func TestEviction(t *testing.T) {
+	ctx := context.Background()
+	remote := inmemory.New()
+	root := t.TempDir()
+	d := newTestMiddleware(t, remote, root, minMaxSize)
+
+	blob := func(b byte) string {
+		content := bytes.Repeat([]byte{b}, int(minMaxSize/2))
+		p := blobDataPath(digest.FromBytes(content))
+		require.NoError(t, remote.PutContent(ctx, p, content))
+		return p
+	}
+	a, b, c := blob('a'), blob('b'), blob('c')
+
+	_, err := d.GetContent(ctx, a)
+	require.NoError(t, err)
+	_, err = d.GetContent(ctx, b)
+	require.NoError(t, err)
+	// a is now more recently used than b.
+	_, err = d.GetContent(ctx, a)
+	require.NoError(t, err)
+	_, err = d.GetContent(ctx, c)
+	require.NoError(t, err)
+
+	require.FileExists(t, d.localPath(a))
+	require.NoFileExists(t, d.localPath(b))
+	require.FileExists(t, d.localPath(c))
+	require.Equal(t, int64(minMaxSize), d.size)
+
+	// The local tier survives a restart.
+	d = newTestMiddleware(t, remote, root, minMaxSize)
+	require.Len(t, d.entries, 2)
+	require.Equal(t, int64(minMaxSize), d.size)
+}
+
+func newTestMiddleware(t *testing.T, remote storagedriver.StorageDriver, root string, maxSize uint64) *tieredStorageMiddleware {
+	options := map[string]interface{}{"rootdirectory": root}
+	if maxSize != 0 {
+		options["maxsize"] = maxSize
+	}
+	d, err := newTieredStorageMiddleware(context.Background(), remote, options)
+	require.NoError(t, err)
+	return d.(*tieredStorageMiddleware)
+}
+
+func blobDataPath(dgst digest.Digest) string {
+	return path.Join("/docker/registry/v2/blobs", dgst.Algorithm().String(), dgst.Encoded()[:2], dgst.Encoded(), "data")
+}
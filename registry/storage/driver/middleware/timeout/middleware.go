@@ -0,0 +1,328 @@
+// Package middleware provides a storage middleware bounding the time spent in
+// the operations of the wrapped driver.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// Timeouts holds the deadlines of the operations of a storage driver, by
+// kind. A zero duration leaves the operations of its kind unbounded.
+type Timeouts struct {
+	// Read bounds GetContent, Stat, RedirectURL and the opening of readers.
+	// Each read from a reader is bounded separately.
+	Read time.Duration
+
+	// Write bounds PutContent, Move, Delete and the opening of writers.
+	// Each write to a writer, and its Commit, Cancel and Close, are bounded
+	// separately.
+	Write time.Duration
+
+	// List bounds List. Walk is bounded by the time spent waiting on the
+	// backend between two files, not counting the time spent in the walk
+	// function.
+	List time.Duration
+}
+
+// timeoutError is the cause of the contexts canceled by the middleware.
+type timeoutError struct {
+	action  string
+	timeout time.Duration
+}
+
+func (err *timeoutError) Error() string {
+	return fmt.Sprintf("storage %s timed out after %v", err.action, err.timeout)
+}
+
+// timeoutStorageMiddleware bounds the operations of the wrapped driver with
+// the deadlines of Timeouts. An operation cut off by its deadline fails with
+// an error of storagedriver.ErrorCategoryTimeout. The operations of a context
+// which is done, or whose deadline passes first, fail as they would without
+// the middleware.
+type timeoutStorageMiddleware struct {
+	storagedriver.StorageDriver
+	timeouts Timeouts
+}
+
+var _ storagedriver.StorageDriver = &timeoutStorageMiddleware{}
+
+// New returns sd with its operations bounded by timeouts.
+func New(sd storagedriver.StorageDriver, timeouts Timeouts) storagedriver.StorageDriver {
+	if timeouts == (Timeouts{}) {
+		return sd
+	}
+	return &timeoutStorageMiddleware{
+		StorageDriver: sd,
+		timeouts:      timeouts,
+	}
+}
+
+// annotate classifies err as a timeout if ctx was canceled by the middleware.
+func annotate(ctx context.Context, err error) error {
+	var (
+		timeout     *timeoutError
+		categorized storagedriver.CategorizedError
+	)
+	if err == nil || !errors.As(context.Cause(ctx), &timeout) || errors.As(err, &categorized) {
+		return err
+	}
+	return storagedriver.WithCategory(fmt.Errorf("%v: %w", timeout, err), storagedriver.ErrorCategoryTimeout)
+}
+
+// bound calls op with ctx bounded by timeout, if any.
+func bound[T any](ctx context.Context, action string, timeout time.Duration, op func(context.Context) (T, error)) (T, error) {
+	if timeout == 0 {
+		return op(ctx)
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, &timeoutError{action: action, timeout: timeout})
+	defer cancel()
+	res, err := op(ctx)
+	return res, annotate(ctx, err)
+}
+
+// GetContent wraps GetContent of the underlying storage driver, bounded by
+// the read timeout.
+func (m *timeoutStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	return bound(ctx, "GetContent", m.timeouts.Read, func(ctx context.Context) ([]byte, error) {
+		return m.StorageDriver.GetContent(ctx, path)
+	})
+}
+
+// PutContent wraps PutContent of the underlying storage driver, bounded by
+// the write timeout.
+func (m *timeoutStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	_, err := bound(ctx, "PutContent", m.timeouts.Write, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, m.StorageDriver.PutContent(ctx, path, content)
+	})
+	return err
+}
+
+// Stat wraps Stat of the underlying storage driver, bounded by the read
+// timeout.
+func (m *timeoutStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	return bound(ctx, "Stat", m.timeouts.Read, func(ctx context.Context) (storagedriver.FileInfo, error) {
+		return m.StorageDriver.Stat(ctx, path)
+	})
+}
+
+// List wraps List of the underlying storage driver, bounded by the list
+// timeout.
+func (m *timeoutStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	return bound(ctx, "List", m.timeouts.List, func(ctx context.Context) ([]string, error) {
+		return m.StorageDriver.List(ctx, path)
+	})
+}
+
+// Move wraps Move of the underlying storage driver, bounded by the write
+// timeout.
+func (m *timeoutStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	_, err := bound(ctx, "Move", m.timeouts.Write, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, m.StorageDriver.Move(ctx, sourcePath, destPath)
+	})
+	return err
+}
+
+// Delete wraps Delete of the underlying storage driver, bounded by the write
+// timeout.
+func (m *timeoutStorageMiddleware) Delete(ctx context.Context, path string) error {
+	_, err := bound(ctx, "Delete", m.timeouts.Write, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, m.StorageDriver.Delete(ctx, path)
+	})
+	return err
+}
+
+// RedirectURL wraps RedirectURL of the underlying storage driver, bounded by
+// the read timeout.
+func (m *timeoutStorageMiddleware) RedirectURL(r *http.Request, path string) (string, error) {
+	if r == nil {
+		return m.StorageDriver.RedirectURL(r, path)
+	}
+	return bound(r.Context(), "RedirectURL", m.timeouts.Read, func(ctx context.Context) (string, error) {
+		return m.StorageDriver.RedirectURL(r.WithContext(ctx), path)
+	})
+}
+
+// watchdog cancels the context of a stream once an operation on the stream
+// outlasts the timeout. Unlike a deadline, it leaves a stream which makes
+// progress open for as long as it takes.
+type watchdog struct {
+	timer  *time.Timer
+	cancel context.CancelCauseFunc
+}
+
+// newWatchdog returns a context derived from parent, canceled once the
+// watchdog is armed for longer than timeout, and the disarmed watchdog.
+func newWatchdog(parent context.Context, action string, timeout time.Duration) (context.Context, *watchdog) {
+	ctx, cancel := context.WithCancelCause(parent)
+	cause := &timeoutError{action: action, timeout: timeout}
+	timer := time.AfterFunc(timeout, func() { cancel(cause) })
+	timer.Stop()
+	return ctx, &watchdog{timer: timer, cancel: cancel}
+}
+
+// arm starts the countdown of the watchdog over, before an operation.
+func (w *watchdog) arm(timeout time.Duration) {
+	w.timer.Reset(timeout)
+}
+
+// disarm stops the countdown of the watchdog, after an operation.
+func (w *watchdog) disarm() {
+	w.timer.Stop()
+}
+
+// stop disarms the watchdog and releases its context, once the stream is
+// closed.
+func (w *watchdog) stop() {
+	w.timer.Stop()
+	w.cancel(nil)
+}
+
+// Reader wraps Reader of the underlying storage driver. The opening of the
+// reader, and each read from it, are bounded by the read timeout, so that
+// large files may take as long as needed as long as they make progress.
+func (m *timeoutStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	timeout := m.timeouts.Read
+	if timeout == 0 {
+		return m.StorageDriver.Reader(ctx, path, offset)
+	}
+	ctx, w := newWatchdog(ctx, "Reader", timeout)
+	w.arm(timeout)
+	rc, err := m.StorageDriver.Reader(ctx, path, offset)
+	w.disarm()
+	if err != nil {
+		w.stop()
+		return nil, annotate(ctx, err)
+	}
+	return &timeoutReader{ReadCloser: rc, ctx: ctx, watchdog: w, timeout: timeout}, nil
+}
+
+type timeoutReader struct {
+	io.ReadCloser
+	ctx      context.Context
+	watchdog *watchdog
+	timeout  time.Duration
+}
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	r.watchdog.arm(r.timeout)
+	n, err := r.ReadCloser.Read(p)
+	r.watchdog.disarm()
+	if err == io.EOF {
+		return n, err
+	}
+	return n, annotate(r.ctx, err)
+}
+
+func (r *timeoutReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.watchdog.stop()
+	return err
+}
+
+// Writer wraps Writer of the underlying storage driver. The opening of the
+// writer, each write to it, and its Commit, Cancel and Close, are bounded by
+// the write timeout.
+func (m *timeoutStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	timeout := m.timeouts.Write
+	if timeout == 0 {
+		return m.StorageDriver.Writer(ctx, path, append)
+	}
+	ctx, w := newWatchdog(ctx, "Writer", timeout)
+	w.arm(timeout)
+	fw, err := m.StorageDriver.Writer(ctx, path, append)
+	w.disarm()
+	if err != nil {
+		w.stop()
+		return nil, annotate(ctx, err)
+	}
+	return &timeoutWriter{FileWriter: fw, ctx: ctx, watchdog: w, timeout: timeout}, nil
+}
+
+type timeoutWriter struct {
+	storagedriver.FileWriter
+	ctx      context.Context
+	watchdog *watchdog
+	timeout  time.Duration
+}
+
+func (w *timeoutWriter) Write(p []byte) (int, error) {
+	w.watchdog.arm(w.timeout)
+	n, err := w.FileWriter.Write(p)
+	w.watchdog.disarm()
+	return n, annotate(w.ctx, err)
+}
+
+// finish calls op, which ends the writer, with ctx bounded by the write
+// timeout. The watchdog also bounds the operation, for the drivers which
+// finish with the context of the writer.
+func (w *timeoutWriter) finish(ctx context.Context, action string, op func(context.Context) error) error {
+	w.watchdog.arm(w.timeout)
+	defer w.watchdog.disarm()
+	_, err := bound(ctx, action, w.timeout, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, op(ctx)
+	})
+	return annotate(w.ctx, err)
+}
+
+func (w *timeoutWriter) Commit(ctx context.Context) error {
+	return w.finish(ctx, "Commit", w.FileWriter.Commit)
+}
+
+func (w *timeoutWriter) Cancel(ctx context.Context) error {
+	return w.finish(ctx, "Cancel", w.FileWriter.Cancel)
+}
+
+func (w *timeoutWriter) Close() error {
+	defer w.watchdog.stop()
+	return w.finish(w.ctx, "Close", func(context.Context) error {
+		return w.FileWriter.Close()
+	})
+}
+
+// Walk wraps Walk of the underlying storage driver. The time spent waiting on
+// the backend between two calls of f is bounded by the list timeout.
+func (m *timeoutStorageMiddleware) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	timeout := m.timeouts.List
+	if timeout == 0 {
+		return m.StorageDriver.Walk(ctx, path, f, options...)
+	}
+	ctx, w := newWatchdog(ctx, "Walk", timeout)
+	defer w.stop()
+
+	// The watchdog is disarmed while f runs, which some drivers do
+	// concurrently.
+	var (
+		mu      sync.Mutex
+		running int
+	)
+	walkFn := func(fileInfo storagedriver.FileInfo) error {
+		mu.Lock()
+		if running == 0 {
+			w.disarm()
+		}
+		running++
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			if running == 0 {
+				w.arm(timeout)
+			}
+			mu.Unlock()
+		}()
+		return f(fileInfo)
+	}
+
+	w.arm(timeout)
+	err := m.StorageDriver.Walk(ctx, path, walkFn, options...)
+	w.disarm()
+	return annotate(ctx, err)
+}
@@ -0,0 +1,222 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/stretchr/testify/require"
+)
+
+// slowDriver delays its operations, and each read and write of its streams,
+// by delay, returning early with the error of the context if it is done.
+type slowDriver struct {
+	storagedriver.StorageDriver
+	delay time.Duration
+}
+
+func wait(ctx context.Context, delay time.Duration) error {
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *slowDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if err := wait(ctx, d.delay); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *slowDriver) List(ctx context.Context, path string) ([]string, error) {
+	if err := wait(ctx, d.delay); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.List(ctx, path)
+}
+
+func (d *slowDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	rc, err := d.StorageDriver.Reader(ctx, path, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &slowReader{ReadCloser: rc, ctx: ctx, driver: d}, nil
+}
+
+func (d *slowDriver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	fw, err := d.StorageDriver.Writer(ctx, path, append)
+	if err != nil {
+		return nil, err
+	}
+	return &slowWriter{FileWriter: fw, ctx: ctx, driver: d}, nil
+}
+
+type slowReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	driver *slowDriver
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if err := wait(r.ctx, r.driver.delay); err != nil {
+		return 0, err
+	}
+	// Read a byte at a time, so that the content takes several reads.
+	return r.ReadCloser.Read(p[:min(len(p), 1)])
+}
+
+type slowWriter struct {
+	storagedriver.FileWriter
+	ctx    context.Context
+	driver *slowDriver
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	if err := wait(w.ctx, w.driver.delay); err != nil {
+		return 0, err
+	}
+	return w.FileWriter.Write(p)
+}
+
+func requireTimeout(t *testing.T, err error) {
+	t.Helper()
+	require.Error(t, err)
+	require.Equal(t, storagedriver.ErrorCategoryTimeout, storagedriver.CategoryOf(err))
+	var categorized storagedriver.CategorizedError
+	require.ErrorAs(t, err, &categorized, "the timeout is not classified by the middleware")
+	require.Contains(t, err.Error(), "timed out after")
+}
+
+func TestNoTimeouts(t *testing.T) {
+	d := inmemory.New()
+	require.Equal(t, storagedriver.StorageDriver(d), New(d, Timeouts{}))
+}
+
+func TestTimeouts(t *testing.T) {
+	ctx := context.Background()
+	d := &slowDriver{StorageDriver: inmemory.New()}
+	require.NoError(t, d.PutContent(ctx, "/dir/file", []byte("content")))
+	m := New(d, Timeouts{Read: 50 * time.Millisecond, Write: time.Hour, List: 50 * time.Millisecond})
+
+	d.delay = 10 * time.Millisecond
+	content, err := m.GetContent(ctx, "/dir/file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("content"), content)
+
+	d.delay = time.Second
+	_, err = m.GetContent(ctx, "/dir/file")
+	requireTimeout(t, err)
+	_, err = m.List(ctx, "/dir")
+	requireTimeout(t, err)
+
+	// The timeouts are set by kind of operation.
+	require.NoError(t, m.PutContent(ctx, "/dir/other", []byte("content")))
+}
+
+// TestTimeoutsParentContext ensures that the operations failing because of
+// their context, rather than the middleware, are left as they are.
+func TestTimeoutsParentContext(t *testing.T) {
+	d := &slowDriver{StorageDriver: inmemory.New(), delay: time.Second}
+	m := New(d, Timeouts{Read: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := m.GetContent(ctx, "/file")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	var categorized storagedriver.CategorizedError
+	require.False(t, errors.As(err, &categorized), "unexpected classification: %v", err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = m.GetContent(ctx, "/file")
+	require.ErrorIs(t, err, context.Canceled)
+	require.False(t, storagedriver.CategoryOf(err).Retriable())
+}
+
+// TestTimeoutsStreams ensures that the streams are bounded by the time spent
+// in each read or write, not by their total duration.
+func TestTimeoutsStreams(t *testing.T) {
+	ctx := context.Background()
+	d := &slowDriver{StorageDriver: inmemory.New()}
+	timeout := 100 * time.Millisecond
+	m := New(d, Timeouts{Read: timeout, Write: timeout})
+
+	// Each write takes a fraction of the timeout, and all of them longer.
+	d.delay = 20 * time.Millisecond
+	fw, err := m.Writer(ctx, "/file", false)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := fw.Write([]byte{'a' + byte(i)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, fw.Commit(ctx))
+	require.NoError(t, fw.Close())
+
+	rc, err := m.Reader(ctx, "/file", 0)
+	require.NoError(t, err)
+	content, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, []byte("abcdefghij"), content)
+	require.NoError(t, rc.Close())
+
+	// The stream is left alone while it is not used.
+	rc, err = m.Reader(ctx, "/file", 0)
+	require.NoError(t, err)
+	time.Sleep(2 * timeout)
+	_, err = rc.Read(make([]byte, 1))
+	require.NoError(t, err)
+	d.delay = time.Second
+	_, err = rc.Read(make([]byte, 1))
+	requireTimeout(t, err)
+	require.NoError(t, rc.Close())
+
+	fw, err = m.Writer(ctx, "/other", false)
+	require.NoError(t, err)
+	_, err = fw.Write([]byte("content"))
+	requireTimeout(t, err)
+	require.NoError(t, fw.Close())
+}
+
+func TestTimeoutsWalk(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	for _, path := range []string{"/dir/a", "/dir/b", "/dir/c"} {
+		require.NoError(t, d.PutContent(ctx, path, []byte("content")))
+	}
+	timeout := 50 * time.Millisecond
+	m := New(d, Timeouts{List: timeout})
+
+	// The time spent in the walk function does not count.
+	var walked []string
+	err := m.Walk(ctx, "/dir", func(fileInfo storagedriver.FileInfo) error {
+		time.Sleep(2 * timeout)
+		walked = append(walked, fileInfo.Path())
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/dir/a", "/dir/b", "/dir/c"}, walked)
+
+	sd := &slowDriver{StorageDriver: d, delay: time.Second}
+	m = New(&walkingDriver{slowDriver: sd}, Timeouts{List: timeout})
+	err = m.Walk(ctx, "/dir", func(storagedriver.FileInfo) error { return nil })
+	requireTimeout(t, err)
+}
+
+// walkingDriver walks with List, so that the walk waits on the slow driver.
+type walkingDriver struct {
+	*slowDriver
+}
+
+func (d *walkingDriver) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	if _, err := d.List(ctx, path); err != nil {
+		return err
+	}
+	return d.slowDriver.Walk(ctx, path, f, options...)
+}
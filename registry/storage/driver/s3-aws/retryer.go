@@ -0,0 +1,76 @@
+package s3
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// maxThrottleScale bounds the factor by which the delays before retrying
+// throttled requests are scaled.
+const maxThrottleScale = 16
+
+// adaptiveRetryer retries failed requests with exponential backoff. Throttled
+// requests, such as those answered with 503 SlowDown, are retried after a
+// delay scaled by the number of requests throttled recently across the
+// driver, so that concurrent uploads back off together instead of retrying in
+// lock step. Every successful request lowers the scale again.
+type adaptiveRetryer struct {
+	client.DefaultRetryer
+
+	// throttled counts the requests throttled recently.
+	throttled atomic.Int64
+}
+
+func newAdaptiveRetryer(maxRetries int) *adaptiveRetryer {
+	return &adaptiveRetryer{
+		DefaultRetryer: client.DefaultRetryer{
+			NumMaxRetries:    maxRetries,
+			MinRetryDelay:    client.DefaultRetryerMinRetryDelay,
+			MinThrottleDelay: client.DefaultRetryerMinThrottleDelay,
+			MaxRetryDelay:    client.DefaultRetryerMaxRetryDelay,
+			MaxThrottleDelay: client.DefaultRetryerMaxThrottleDelay,
+		},
+	}
+}
+
+// RetryRules returns the delay before retrying r.
+func (a *adaptiveRetryer) RetryRules(r *request.Request) time.Duration {
+	delay := a.DefaultRetryer.RetryRules(r)
+	if !r.IsErrorThrottle() {
+		return delay
+	}
+
+	var scale int64
+	for {
+		throttled := a.throttled.Load()
+		scale = throttled + 1
+		if scale > maxThrottleScale {
+			scale = maxThrottleScale
+		}
+		if a.throttled.CompareAndSwap(throttled, scale) {
+			break
+		}
+	}
+	delay *= time.Duration(scale)
+	if delay > a.MaxThrottleDelay {
+		delay = a.MaxThrottleDelay
+	}
+	return delay
+}
+
+// complete is a request handler lowering the scale of the throttling delays
+// when a request succeeds.
+func (a *adaptiveRetryer) complete(r *request.Request) {
+	if r.Error != nil {
+		return
+	}
+	for {
+		throttled := a.throttled.Load()
+		if throttled == 0 || a.throttled.CompareAndSwap(throttled, throttled-1) {
+			return
+		}
+	}
+}
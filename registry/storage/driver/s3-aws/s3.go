@@ -20,7 +20,7 @@ import (
 	"io"
 	"math"
 	"net/http"
-	"path/filepath"
+	"net/url"
 	"reflect"
 	"sort"
 	"strconv"
@@ -30,6 +30,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/request"
@@ -54,6 +55,10 @@ const maxChunkSize = 5 * 1024 * 1024 * 1024
 
 const defaultChunkSize = 2 * minChunkSize
 
+// defaultMultipartConcurrency defines the default maximum number of parts
+// uploaded concurrently by a writer.
+const defaultMultipartConcurrency = 1
+
 const (
 	// defaultMultipartCopyChunkSize defines the default chunk size for all
 	// but the last Upload Part - Copy operation of a multipart copy.
@@ -103,11 +108,15 @@ type DriverParameters struct {
 	RegionEndpoint              string
 	ForcePathStyle              bool
 	Encrypt                     bool
+	EncryptKMS                  bool
 	KeyID                       string
+	BucketKeyEnabled            bool
 	Secure                      bool
 	SkipVerify                  bool
+	Insecure                    bool
 	V4Auth                      bool
 	ChunkSize                   int64
+	MultipartConcurrency        int64
 	MultipartCopyChunkSize      int64
 	MultipartCopyMaxConcurrency int64
 	MultipartCopyThresholdSize  int64
@@ -118,7 +127,9 @@ type DriverParameters struct {
 	SessionToken                string
 	UseDualStack                bool
 	Accelerate                  bool
+	DisableChecksums            bool
 	LogLevel                    aws.LogLevelType
+	MaxRetries                  int64
 }
 
 func init() {
@@ -159,14 +170,18 @@ type driver struct {
 	S3                          *s3.S3
 	Bucket                      string
 	ChunkSize                   int64
+	MultipartConcurrency        int64
 	Encrypt                     bool
+	EncryptKMS                  bool
 	KeyID                       string
+	BucketKeyEnabled            bool
 	MultipartCopyChunkSize      int64
 	MultipartCopyMaxConcurrency int64
 	MultipartCopyThresholdSize  int64
 	RootDirectory               string
 	StorageClass                string
 	ObjectACL                   string
+	DisableChecksums            bool
 	pool                        *sync.Pool
 }
 
@@ -240,12 +255,18 @@ func FromParameters(ctx context.Context, parameters map[string]interface{}) (*Dr
 	}
 
 	encryptBool := false
+	encryptKMSBool := false
 	encrypt := parameters["encrypt"]
 	switch encrypt := encrypt.(type) {
 	case string:
+		if strings.EqualFold(encrypt, "kms") {
+			encryptBool = true
+			encryptKMSBool = true
+			break
+		}
 		b, err := strconv.ParseBool(encrypt)
 		if err != nil {
-			return nil, fmt.Errorf("the encrypt parameter should be a boolean")
+			return nil, fmt.Errorf("the encrypt parameter should be a boolean or kms")
 		}
 		encryptBool = b
 	case bool:
@@ -253,7 +274,7 @@ func FromParameters(ctx context.Context, parameters map[string]interface{}) (*Dr
 	case nil:
 		// do nothing
 	default:
-		return nil, fmt.Errorf("the encrypt parameter should be a boolean")
+		return nil, fmt.Errorf("the encrypt parameter should be a boolean or kms")
 	}
 
 	secureBool := true
@@ -290,6 +311,23 @@ func FromParameters(ctx context.Context, parameters map[string]interface{}) (*Dr
 		return nil, fmt.Errorf("the skipVerify parameter should be a boolean")
 	}
 
+	insecureBool := false
+	insecure := parameters["insecure"]
+	switch insecure := insecure.(type) {
+	case string:
+		b, err := strconv.ParseBool(insecure)
+		if err != nil {
+			return nil, fmt.Errorf("the insecure parameter should be a boolean")
+		}
+		insecureBool = b
+	case bool:
+		insecureBool = insecure
+	case nil:
+		// do nothing
+	default:
+		return nil, fmt.Errorf("the insecure parameter should be a boolean")
+	}
+
 	v4Bool := true
 	v4auth := parameters["v4auth"]
 	switch v4auth := v4auth.(type) {
@@ -307,16 +345,44 @@ func FromParameters(ctx context.Context, parameters map[string]interface{}) (*Dr
 		return nil, fmt.Errorf("the v4auth parameter should be a boolean")
 	}
 
-	keyID := parameters["keyid"]
+	// kmskeyid supersedes keyid, which is kept for compatibility.
+	keyID := parameters["kmskeyid"]
+	if keyID == nil || fmt.Sprint(keyID) == "" {
+		keyID = parameters["keyid"]
+	} else if legacyKeyID := parameters["keyid"]; legacyKeyID != nil && fmt.Sprint(legacyKeyID) != "" && fmt.Sprint(legacyKeyID) != fmt.Sprint(keyID) {
+		return nil, fmt.Errorf("the kmskeyid and keyid parameters are set to different keys")
+	}
 	if keyID == nil {
 		keyID = ""
 	}
 
+	bucketKeyEnabledBool := false
+	bucketKeyEnabled := parameters["bucketkeyenabled"]
+	switch bucketKeyEnabled := bucketKeyEnabled.(type) {
+	case string:
+		b, err := strconv.ParseBool(bucketKeyEnabled)
+		if err != nil {
+			return nil, fmt.Errorf("the bucketkeyenabled parameter should be a boolean")
+		}
+		bucketKeyEnabledBool = b
+	case bool:
+		bucketKeyEnabledBool = bucketKeyEnabled
+	case nil:
+		// do nothing
+	default:
+		return nil, fmt.Errorf("the bucketkeyenabled parameter should be a boolean")
+	}
+
 	chunkSize, err := getParameterAsInt64(parameters, "chunksize", defaultChunkSize, minChunkSize, maxChunkSize)
 	if err != nil {
 		return nil, err
 	}
 
+	multipartConcurrency, err := getParameterAsInt64(parameters, "multipartconcurrency", defaultMultipartConcurrency, 1, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+
 	multipartCopyChunkSize, err := getParameterAsInt64(parameters, "multipartcopychunksize", defaultMultipartCopyChunkSize, minChunkSize, maxChunkSize)
 	if err != nil {
 		return nil, err
@@ -332,6 +398,11 @@ func FromParameters(ctx context.Context, parameters map[string]interface{}) (*Dr
 		return nil, err
 	}
 
+	maxRetries, err := getParameterAsInt64(parameters, "maxretries", client.DefaultRetryerMaxNumRetries, 0, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+
 	rootDirectory := parameters["rootdirectory"]
 	if rootDirectory == nil {
 		rootDirectory = ""
@@ -422,6 +493,23 @@ func FromParameters(ctx context.Context, parameters map[string]interface{}) (*Dr
 		return nil, fmt.Errorf("the accelerate parameter should be a boolean")
 	}
 
+	disableChecksumsBool := false
+	disableChecksums := parameters["disablechecksums"]
+	switch disableChecksums := disableChecksums.(type) {
+	case string:
+		b, err := strconv.ParseBool(disableChecksums)
+		if err != nil {
+			return nil, fmt.Errorf("the disablechecksums parameter should be a boolean")
+		}
+		disableChecksumsBool = b
+	case bool:
+		disableChecksumsBool = disableChecksums
+	case nil:
+		// do nothing
+	default:
+		return nil, fmt.Errorf("the disablechecksums parameter should be a boolean")
+	}
+
 	params := DriverParameters{
 		fmt.Sprint(accessKey),
 		fmt.Sprint(secretKey),
@@ -430,11 +518,15 @@ func FromParameters(ctx context.Context, parameters map[string]interface{}) (*Dr
 		fmt.Sprint(regionEndpoint),
 		forcePathStyleBool,
 		encryptBool,
+		encryptKMSBool,
 		fmt.Sprint(keyID),
+		bucketKeyEnabledBool,
 		secureBool,
 		skipVerifyBool,
+		insecureBool,
 		v4Bool,
 		chunkSize,
+		multipartConcurrency,
 		multipartCopyChunkSize,
 		multipartCopyMaxConcurrency,
 		multipartCopyThresholdSize,
@@ -445,7 +537,9 @@ func FromParameters(ctx context.Context, parameters map[string]interface{}) (*Dr
 		fmt.Sprint(sessionToken),
 		useDualStackBool,
 		accelerateBool,
+		disableChecksumsBool,
 		getS3LogLevelFromParam(parameters["loglevel"]),
+		maxRetries,
 	}
 
 	return New(ctx, params)
@@ -507,6 +601,34 @@ func getParameterAsInt64(parameters map[string]interface{}, name string, default
 	return rv, nil
 }
 
+// validateRegionEndpoint checks that endpoint is the URL of an S3 compatible
+// service, which is only reached over plain HTTP when insecure is set. An
+// endpoint without a scheme uses HTTPS unless secure is unset.
+func validateRegionEndpoint(endpoint string, secure, insecure bool) error {
+	scheme := "https"
+	if !secure {
+		scheme = "http"
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = scheme + "://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid regionendpoint: %v", err)
+	}
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https":
+		return fmt.Errorf("invalid regionendpoint %q: the scheme must be http or https", endpoint)
+	case u.Host == "":
+		return fmt.Errorf("invalid regionendpoint %q: no host", endpoint)
+	case u.User != nil || u.RawQuery != "" || u.Fragment != "":
+		return fmt.Errorf("invalid regionendpoint %q: only a scheme, a host and a path are allowed", endpoint)
+	case u.Scheme == "http" && !insecure:
+		return fmt.Errorf("regionendpoint %q uses plain HTTP, which requires the insecure parameter", endpoint)
+	}
+	return nil
+}
+
 // New constructs a new Driver with the given AWS credentials, region, encryption flag, and
 // bucketName
 func New(ctx context.Context, params DriverParameters) (*Driver, error) {
@@ -516,8 +638,19 @@ func New(ctx context.Context, params DriverParameters) (*Driver, error) {
 		return nil, fmt.Errorf("on Amazon S3 this storage driver can only be used with v4 authentication")
 	}
 
+	kms := params.Encrypt && (params.EncryptKMS || params.KeyID != "")
+	if kms && !params.V4Auth {
+		return nil, fmt.Errorf("encryption with KMS keys requires v4 authentication")
+	}
+	if params.BucketKeyEnabled && !kms {
+		return nil, fmt.Errorf("bucket keys can only be enabled with encryption with KMS keys")
+	}
+
 	awsConfig := aws.NewConfig().WithLogLevel(params.LogLevel)
 
+	retryer := newAdaptiveRetryer(int(params.MaxRetries))
+	awsConfig = request.WithRetryer(awsConfig, retryer)
+
 	if params.AccessKey != "" && params.SecretKey != "" {
 		creds := credentials.NewStaticCredentials(
 			params.AccessKey,
@@ -528,6 +661,9 @@ func New(ctx context.Context, params DriverParameters) (*Driver, error) {
 	}
 
 	if params.RegionEndpoint != "" {
+		if err := validateRegionEndpoint(params.RegionEndpoint, params.Secure, params.Insecure); err != nil {
+			return nil, err
+		}
 		awsConfig.WithEndpoint(params.RegionEndpoint)
 	}
 
@@ -535,6 +671,12 @@ func New(ctx context.Context, params DriverParameters) (*Driver, error) {
 	awsConfig.WithS3UseAccelerate(params.Accelerate)
 	awsConfig.WithRegion(params.Region)
 	awsConfig.WithDisableSSL(!params.Secure)
+	if params.DisableChecksums {
+		// Some S3 compatible services reject the checksum headers the SDK
+		// adds to uploads.
+		awsConfig.WithS3DisableContentMD5Validation(true)
+		awsConfig.WithDisableComputeChecksums(true)
+	}
 	if params.UseDualStack {
 		awsConfig.UseDualStackEndpoint = endpoints.DualStackEndpointStateEnabled
 	}
@@ -557,6 +699,7 @@ func New(ctx context.Context, params DriverParameters) (*Driver, error) {
 	}
 
 	s3obj := s3.New(sess)
+	s3obj.Handlers.Complete.PushBack(retryer.complete)
 
 	// enable S3 compatible signature v2 signing instead
 	if !params.V4Auth {
@@ -582,14 +725,18 @@ func New(ctx context.Context, params DriverParameters) (*Driver, error) {
 		S3:                          s3obj,
 		Bucket:                      params.Bucket,
 		ChunkSize:                   params.ChunkSize,
+		MultipartConcurrency:        params.MultipartConcurrency,
 		Encrypt:                     params.Encrypt,
+		EncryptKMS:                  params.EncryptKMS,
 		KeyID:                       params.KeyID,
+		BucketKeyEnabled:            params.BucketKeyEnabled,
 		MultipartCopyChunkSize:      params.MultipartCopyChunkSize,
 		MultipartCopyMaxConcurrency: params.MultipartCopyMaxConcurrency,
 		MultipartCopyThresholdSize:  params.MultipartCopyThresholdSize,
 		RootDirectory:               params.RootDirectory,
 		StorageClass:                params.StorageClass,
 		ObjectACL:                   params.ObjectACL,
+		DisableChecksums:            params.DisableChecksums,
 		pool: &sync.Pool{
 			New: func() interface{} {
 				return &buffer{
@@ -632,10 +779,11 @@ func (d *driver) PutContent(ctx context.Context, path string, contents []byte) e
 		ACL:                  d.getACL(),
 		ServerSideEncryption: d.getEncryptionMode(),
 		SSEKMSKeyId:          d.getSSEKMSKeyID(),
+		BucketKeyEnabled:     d.getBucketKeyEnabled(),
 		StorageClass:         d.getStorageClass(),
 		Body:                 bytes.NewReader(contents),
 	})
-	return parseError(path, err)
+	return d.parseWriteError(path, err)
 }
 
 // Reader retrieves an io.ReadCloser for the content stored at "path" with a
@@ -673,10 +821,11 @@ func (d *driver) Writer(ctx context.Context, path string, appendMode bool) (stor
 			ACL:                  d.getACL(),
 			ServerSideEncryption: d.getEncryptionMode(),
 			SSEKMSKeyId:          d.getSSEKMSKeyID(),
+			BucketKeyEnabled:     d.getBucketKeyEnabled(),
 			StorageClass:         d.getStorageClass(),
 		})
 		if err != nil {
-			return nil, err
+			return nil, d.parseWriteError(path, err)
 		}
 		return d.newWriter(ctx, key, *resp.UploadId, nil), nil
 	}
@@ -708,10 +857,11 @@ func (d *driver) Writer(ctx context.Context, path string, appendMode bool) (stor
 					ACL:                  d.getACL(),
 					ServerSideEncryption: d.getEncryptionMode(),
 					SSEKMSKeyId:          d.getSSEKMSKeyID(),
+					BucketKeyEnabled:     d.getBucketKeyEnabled(),
 					StorageClass:         d.getStorageClass(),
 				})
 				if err != nil {
-					return nil, err
+					return nil, d.parseWriteError(path, err)
 				}
 				return d.newWriter(ctx, key, *resp.UploadId, nil), nil
 			}
@@ -892,11 +1042,12 @@ func (d *driver) copy(ctx context.Context, sourcePath string, destPath string) e
 			ACL:                  d.getACL(),
 			ServerSideEncryption: d.getEncryptionMode(),
 			SSEKMSKeyId:          d.getSSEKMSKeyID(),
+			BucketKeyEnabled:     d.getBucketKeyEnabled(),
 			StorageClass:         d.getStorageClass(),
 			CopySource:           aws.String(d.Bucket + "/" + d.s3Path(sourcePath)),
 		})
 		if err != nil {
-			return parseError(sourcePath, err)
+			return d.parseWriteError(sourcePath, err)
 		}
 		return nil
 	}
@@ -908,10 +1059,11 @@ func (d *driver) copy(ctx context.Context, sourcePath string, destPath string) e
 		ACL:                  d.getACL(),
 		SSEKMSKeyId:          d.getSSEKMSKeyID(),
 		ServerSideEncryption: d.getEncryptionMode(),
+		BucketKeyEnabled:     d.getBucketKeyEnabled(),
 		StorageClass:         d.getStorageClass(),
 	})
 	if err != nil {
-		return err
+		return d.parseWriteError(destPath, err)
 	}
 
 	numParts := (fileInfo.Size() + d.MultipartCopyChunkSize - 1) / d.MultipartCopyChunkSize
@@ -1049,15 +1201,39 @@ func (d *driver) RedirectURL(r *http.Request, path string) (string, error) {
 
 	switch r.Method {
 	case http.MethodGet:
-		req, _ = d.S3.GetObjectRequest(&s3.GetObjectInput{
+		headers := storagedriver.GetRedirectHeaders(r)
+		input := &s3.GetObjectInput{
 			Bucket: aws.String(d.Bucket),
 			Key:    aws.String(d.s3Path(path)),
-		})
+		}
+		if headers.CacheControl != "" {
+			input.ResponseCacheControl = aws.String(headers.CacheControl)
+		}
+		if headers.ContentDisposition != "" {
+			input.ResponseContentDisposition = aws.String(headers.ContentDisposition)
+		}
+		req, _ = d.S3.GetObjectRequest(input)
 	case http.MethodHead:
 		req, _ = d.S3.HeadObjectRequest(&s3.HeadObjectInput{
 			Bucket: aws.String(d.Bucket),
 			Key:    aws.String(d.s3Path(path)),
 		})
+	case http.MethodPut:
+		// The length and checksum are signed, so S3 rejects any other
+		// content. The encryption, ACL and storage class are left out: the
+		// uploaded object is moved into place by the registry, which copies
+		// it with the configured settings. Without checksums, the content
+		// could not be verified, so uploads go through the registry.
+		checksum := storagedriver.ContentDigestSHA256(r)
+		if checksum == "" || r.ContentLength <= 0 || d.DisableChecksums {
+			return "", nil
+		}
+		req, _ = d.S3.PutObjectRequest(&s3.PutObjectInput{
+			Bucket:         aws.String(d.Bucket),
+			Key:            aws.String(d.s3Path(path)),
+			ContentLength:  aws.Int64(r.ContentLength),
+			ChecksumSHA256: aws.String(checksum),
+		})
 	default:
 		return "", nil
 	}
@@ -1127,7 +1303,7 @@ func (d *driver) doWalk(parentCtx context.Context, objectCount *int64, from stri
 			filePath := strings.Replace(*file.Key, d.s3Path(""), prefix, 1)
 
 			// get a list of all inferred directories between the previous directory and this file
-			dirs := directoryDiff(prevDir, filePath)
+			dirs := storagedriver.DirectoryDiff(prevDir, filePath)
 			for _, dir := range dirs {
 				walkInfos = append(walkInfos, storagedriver.FileInfoInternal{
 					FileInfoFields: storagedriver.FileInfoFields{
@@ -1183,50 +1359,6 @@ func (d *driver) doWalk(parentCtx context.Context, objectCount *int64, from stri
 	return nil
 }
 
-// directoryDiff finds all directories that are not in common between
-// the previous and current paths in sorted order.
-//
-// # Examples
-//
-//	directoryDiff("/path/to/folder", "/path/to/folder/folder/file")
-//	// => [ "/path/to/folder/folder" ]
-//
-//	directoryDiff("/path/to/folder/folder1", "/path/to/folder/folder2/file")
-//	// => [ "/path/to/folder/folder2" ]
-//
-//	directoryDiff("/path/to/folder/folder1/file", "/path/to/folder/folder2/file")
-//	// => [ "/path/to/folder/folder2" ]
-//
-//	directoryDiff("/path/to/folder/folder1/file", "/path/to/folder/folder2/folder1/file")
-//	// => [ "/path/to/folder/folder2", "/path/to/folder/folder2/folder1" ]
-//
-//	directoryDiff("/", "/path/to/folder/folder/file")
-//	// => [ "/path", "/path/to", "/path/to/folder", "/path/to/folder/folder" ]
-func directoryDiff(prev, current string) []string {
-	var paths []string
-
-	if prev == "" || current == "" {
-		return paths
-	}
-
-	parent := current
-	for {
-		parent = filepath.Dir(parent)
-		if parent == "/" || parent == prev || strings.HasPrefix(prev+"/", parent+"/") {
-			break
-		}
-		paths = append(paths, parent)
-	}
-	reverse(paths)
-	return paths
-}
-
-func reverse(s []string) {
-	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
-		s[i], s[j] = s[j], s[i]
-	}
-}
-
 func (d *driver) s3Path(path string) string {
 	return strings.TrimLeft(strings.TrimRight(d.RootDirectory, "/")+path, "/")
 }
@@ -1244,23 +1376,98 @@ func parseError(path string, err error) error {
 	return err
 }
 
+// CategorizeError implements storagedriver.ErrorCategorizer, telling the
+// requests S3 throttled, such as with a SlowDown error, which timed out, or
+// which failed with an internal error.
+func (d *driver) CategorizeError(err error) storagedriver.ErrorCategory {
+	var s3Err awserr.Error
+	if !errors.As(err, &s3Err) {
+		return storagedriver.ErrorCategoryPermanent
+	}
+	switch s3Err.Code() {
+	case "SlowDown", "ServiceUnavailable":
+		return storagedriver.ErrorCategoryThrottled
+	case "RequestTimeout", request.ErrCodeResponseTimeout:
+		return storagedriver.ErrorCategoryTimeout
+	case "InternalError":
+		return storagedriver.ErrorCategoryUnavailable
+	}
+	if request.IsErrorThrottle(s3Err) {
+		return storagedriver.ErrorCategoryThrottled
+	}
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		switch reqErr.StatusCode() {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return storagedriver.ErrorCategoryThrottled
+		case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+			return storagedriver.ErrorCategoryTimeout
+		case http.StatusInternalServerError, http.StatusBadGateway:
+			return storagedriver.ErrorCategoryUnavailable
+		}
+	}
+	return storagedriver.ErrorCategoryPermanent
+}
+
+// parseWriteError returns a driver error explaining the failure if err was
+// caused by the encryption of the object at path with KMS, for instance
+// because the key does not exist or may not be used by the registry.
+func (d *driver) parseWriteError(path string, err error) error {
+	if s3Err, ok := err.(awserr.Error); ok && d.isKMS() && isKMSError(s3Err) {
+		key := d.KeyID
+		if key == "" {
+			key = "aws/s3"
+		}
+		return storagedriver.Error{
+			DriverName: driverName,
+			Detail:     fmt.Errorf("unable to encrypt %s with KMS key %s: %v", path, key, err),
+		}
+	}
+	return parseError(path, err)
+}
+
+// isKMSError reports whether err was returned by S3 because of a failure to
+// use a KMS key.
+func isKMSError(err awserr.Error) bool {
+	if strings.HasPrefix(err.Code(), "KMS.") {
+		return true
+	}
+	switch err.Code() {
+	case "AccessDenied", "InvalidArgument":
+		return strings.Contains(strings.ToLower(err.Message()), "kms")
+	}
+	return false
+}
+
+// isKMS reports whether objects are encrypted with KMS keys.
+func (d *driver) isKMS() bool {
+	return d.Encrypt && (d.EncryptKMS || d.KeyID != "")
+}
+
 func (d *driver) getEncryptionMode() *string {
 	if !d.Encrypt {
 		return nil
 	}
-	if d.KeyID == "" {
-		return aws.String("AES256")
+	if !d.isKMS() {
+		return aws.String(s3.ServerSideEncryptionAes256)
 	}
-	return aws.String("aws:kms")
+	return aws.String(s3.ServerSideEncryptionAwsKms)
 }
 
 func (d *driver) getSSEKMSKeyID() *string {
-	if d.KeyID != "" {
+	if d.isKMS() && d.KeyID != "" {
 		return aws.String(d.KeyID)
 	}
 	return nil
 }
 
+func (d *driver) getBucketKeyEnabled() *bool {
+	if d.isKMS() && d.BucketKeyEnabled {
+		return aws.Bool(true)
+	}
+	return nil
+}
+
 func (d *driver) getContentType() *string {
 	return aws.String("application/octet-stream")
 }
@@ -1323,6 +1530,11 @@ func (b *buffer) Clear() {
 // part is at least as large as the chunksize, so the multipart upload could be
 // cleanly resumed in the future. This is violated if Close is called after less
 // than a full chunk is written.
+//
+// Up to MultipartConcurrency full parts are uploaded in the background while
+// the following parts are written. A writer holds at most
+// MultipartConcurrency+1 buffers, so its memory use is bounded by
+// ChunkSize*(MultipartConcurrency+1).
 type writer struct {
 	ctx       context.Context
 	driver    *driver
@@ -1335,6 +1547,14 @@ type writer struct {
 	closed    bool
 	committed bool
 	cancelled bool
+
+	// buffers holds a token for each buffer held by the writer, including
+	// the buffers of the parts being uploaded.
+	buffers chan struct{}
+	uploads sync.WaitGroup
+
+	mu        sync.Mutex
+	uploadErr error
 }
 
 func (d *driver) newWriter(ctx context.Context, key, uploadID string, parts []*s3.Part) storagedriver.FileWriter {
@@ -1342,7 +1562,7 @@ func (d *driver) newWriter(ctx context.Context, key, uploadID string, parts []*s
 	for _, part := range parts {
 		size += *part.Size
 	}
-	return &writer{
+	w := &writer{
 		ctx:      ctx,
 		driver:   d,
 		key:      key,
@@ -1351,7 +1571,12 @@ func (d *driver) newWriter(ctx context.Context, key, uploadID string, parts []*s
 		size:     size,
 		ready:    d.NewBuffer(),
 		pending:  d.NewBuffer(),
+		buffers:  make(chan struct{}, d.MultipartConcurrency+1),
 	}
+	// Account for the ready and pending buffers.
+	w.buffers <- struct{}{}
+	w.buffers <- struct{}{}
+	return w
 }
 
 type completedParts []*s3.CompletedPart
@@ -1372,6 +1597,10 @@ func (w *writer) Write(p []byte) (int, error) {
 	// If the last written part is smaller than minChunkSize, we need to make a
 	// new multipart upload :sadface:
 	if len(w.parts) > 0 && int(*w.parts[len(w.parts)-1].Size) < minChunkSize {
+		if err := w.wait(); err != nil {
+			return 0, err
+		}
+
 		completedUploadedParts := make(completedParts, len(w.parts))
 		for i, part := range w.parts {
 			completedUploadedParts[i] = &s3.CompletedPart{
@@ -1407,6 +1636,8 @@ func (w *writer) Write(p []byte) (int, error) {
 			ContentType:          w.driver.getContentType(),
 			ACL:                  w.driver.getACL(),
 			ServerSideEncryption: w.driver.getEncryptionMode(),
+			SSEKMSKeyId:          w.driver.getSSEKMSKeyID(),
+			BucketKeyEnabled:     w.driver.getBucketKeyEnabled(),
 			StorageClass:         w.driver.getStorageClass(),
 		})
 		if err != nil {
@@ -1487,9 +1718,9 @@ func (w *writer) Write(p []byte) (int, error) {
 			return n, err
 		}
 
-		// we filled up pending buffer, flush
+		// we filled up pending buffer, upload the ready buffer
 		if w.pending.Len() == w.pending.Cap() {
-			if err := w.flush(); err != nil {
+			if err := w.flushReady(); err != nil {
 				return n, err
 			}
 		}
@@ -1524,6 +1755,9 @@ func (w *writer) Cancel(ctx context.Context) error {
 		return fmt.Errorf("already committed")
 	}
 	w.cancelled = true
+	// Parts still being uploaded would not be removed by the abort. Their
+	// errors are irrelevant once the upload is cancelled.
+	_ = w.wait()
 	_, err := w.driver.S3.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
 		Bucket:   aws.String(w.driver.Bucket),
 		Key:      aws.String(w.key),
@@ -1604,9 +1838,76 @@ func (w *writer) Commit(ctx context.Context) error {
 	return nil
 }
 
-// flush flushes all buffers to write a part to S3.
-// flush is only called by Write (with both buffers full) and Close/Commit (always)
+// flushReady starts uploading the ready buffer as a part in the background,
+// and makes the pending buffer the ready buffer. It is only called by Write
+// with both buffers full, and blocks until a buffer is available to be the
+// pending buffer.
+func (w *writer) flushReady() error {
+	w.mu.Lock()
+	err := w.uploadErr
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	part := &s3.Part{
+		PartNumber: aws.Int64(int64(len(w.parts) + 1)),
+		Size:       aws.Int64(int64(w.ready.Len())),
+	}
+	w.parts = append(w.parts, part)
+
+	buf := w.ready
+	w.uploads.Add(1)
+	go func() {
+		defer w.uploads.Done()
+
+		resp, err := w.driver.S3.UploadPartWithContext(w.ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(w.driver.Bucket),
+			Key:        aws.String(w.key),
+			PartNumber: part.PartNumber,
+			UploadId:   aws.String(w.uploadID),
+			Body:       bytes.NewReader(buf.data),
+		})
+
+		w.mu.Lock()
+		if err != nil {
+			if w.uploadErr == nil {
+				w.uploadErr = err
+			}
+		} else {
+			part.ETag = resp.ETag
+		}
+		w.mu.Unlock()
+
+		buf.Clear()
+		w.driver.pool.Put(buf)
+		<-w.buffers
+	}()
+
+	w.ready = w.pending
+	w.buffers <- struct{}{}
+	w.pending = w.driver.NewBuffer()
+
+	return nil
+}
+
+// wait waits for the parts being uploaded in the background, and returns the
+// first error encountered uploading them.
+func (w *writer) wait() error {
+	w.uploads.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.uploadErr
+}
+
+// flush waits for the parts being uploaded in the background, then flushes
+// all buffers to write a part to S3. flush is only called by Close/Commit.
 func (w *writer) flush() error {
+	if err := w.wait(); err != nil {
+		return err
+	}
+
 	if w.ready.Len() == 0 && w.pending.Len() == 0 {
 		return nil
 	}
@@ -2,9 +2,13 @@ package driver
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -39,6 +43,10 @@ type WalkOptions struct {
 	// If StartAfterHint is set, the walk may start with the first item lexographically
 	// after the hint, but it is not guaranteed and drivers may start the walk from the path.
 	StartAfterHint string
+	// If Parallelism is greater than one, walks driven by List may list up to
+	// Parallelism directories concurrently. The walk function is still called
+	// sequentially, in the same order.
+	Parallelism int
 }
 
 func WithStartAfterHint(startAfterHint string) func(*WalkOptions) {
@@ -47,6 +55,14 @@ func WithStartAfterHint(startAfterHint string) func(*WalkOptions) {
 	}
 }
 
+// WithParallelism sets the number of directories a walk may list
+// concurrently.
+func WithParallelism(parallelism int) func(*WalkOptions) {
+	return func(s *WalkOptions) {
+		s.Parallelism = parallelism
+	}
+}
+
 // StorageDriver defines methods that a Storage Driver must implement for a
 // filesystem-like key/value object storage. Storage Drivers are automatically
 // registered via an internal registration mechanism, and generally created
@@ -101,6 +117,15 @@ type StorageDriver interface {
 	// RedirectURL returns a URL which the client of the request r may use
 	// to retrieve the content stored at path. Returning the empty string
 	// signals that the request may not be redirected.
+	//
+	// For PUT requests, the URL may be used to upload the content to be
+	// stored at path. Drivers must only return a URL if the backend enforces
+	// that the uploaded content is exactly r.ContentLength bytes long and
+	// matches the digest returned by ContentDigestSHA256(r), and that the URL
+	// expires. Otherwise, the upload is streamed through the registry.
+	//
+	// For GET requests, drivers should have the backend set the headers
+	// returned by GetRedirectHeaders(r) on its response, if it can.
 	RedirectURL(r *http.Request, path string) (string, error)
 
 	// Walk traverses a filesystem defined within driver, starting
@@ -179,6 +204,28 @@ func (err InvalidOffsetError) Error() string {
 	return fmt.Sprintf("%s: invalid offset: %d for path: %s", err.DriverName, err.Offset, err.Path)
 }
 
+// QuotaExceededError is returned when a write would grow the content stored
+// by a driver beyond its capacity.
+type QuotaExceededError struct {
+	Path       string
+	Limit      int64
+	DriverName string
+}
+
+func (err QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s: quota of %d bytes exceeded writing path: %s", err.DriverName, err.Limit, err.Path)
+}
+
+// ReadOnlyError is returned when writing to a read-only driver.
+type ReadOnlyError struct {
+	Path       string
+	DriverName string
+}
+
+func (err ReadOnlyError) Error() string {
+	return fmt.Sprintf("%s: read-only driver, cannot write path: %s", err.DriverName, err.Path)
+}
+
 // Error is a catch-all error type which captures an error string and
 // the driver type on which it occurred.
 type Error struct {
@@ -190,6 +237,12 @@ func (err Error) Error() string {
 	return fmt.Sprintf("%s: %s", err.DriverName, err.Detail)
 }
 
+// Unwrap returns the underlying error, so that errors such as the
+// cancellation of a context can be matched through errors.Is.
+func (err Error) Unwrap() error {
+	return err.Detail
+}
+
 func (err Error) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		DriverName string `json:"driver"`
@@ -245,3 +298,152 @@ func (e Errors) MarshalJSON() ([]byte, error) {
 
 	return json.Marshal(tmpErrs)
 }
+
+// ErrorCategory classifies the errors of storage drivers, telling the
+// transient failures of a backend, worth retrying, from the others.
+type ErrorCategory int
+
+const (
+	// ErrorCategoryPermanent is the category of the errors which retrying
+	// does not fix, and of the errors which are not classified.
+	ErrorCategoryPermanent ErrorCategory = iota
+
+	// ErrorCategoryNotFound is the category of the operations on
+	// nonexistent paths.
+	ErrorCategoryNotFound
+
+	// ErrorCategoryThrottled is the category of the requests the backend
+	// refused because it is overloaded or rate limited.
+	ErrorCategoryThrottled
+
+	// ErrorCategoryTimeout is the category of the requests to the backend
+	// which timed out.
+	ErrorCategoryTimeout
+
+	// ErrorCategoryUnavailable is the category of the requests which failed
+	// with a transient server error of the backend, such as an internal
+	// error or a bad gateway.
+	ErrorCategoryUnavailable
+)
+
+// String returns the name of the category, as labeled in metrics.
+func (c ErrorCategory) String() string {
+	switch c {
+	case ErrorCategoryNotFound:
+		return "not_found"
+	case ErrorCategoryThrottled:
+		return "throttled"
+	case ErrorCategoryTimeout:
+		return "timeout"
+	case ErrorCategoryUnavailable:
+		return "unavailable"
+	default:
+		return "permanent"
+	}
+}
+
+// Retriable reports whether the errors of the category are transient, so
+// that the request may succeed if retried later.
+func (c ErrorCategory) Retriable() bool {
+	return c == ErrorCategoryThrottled || c == ErrorCategoryTimeout || c == ErrorCategoryUnavailable
+}
+
+// CategorizedError annotates an error of a storage backend with its
+// category.
+type CategorizedError struct {
+	Category ErrorCategory
+	Err      error
+}
+
+func (err CategorizedError) Error() string {
+	return err.Err.Error()
+}
+
+// Unwrap returns the annotated error.
+func (err CategorizedError) Unwrap() error {
+	return err.Err
+}
+
+// WithCategory annotates err with category. It returns nil if err is nil.
+func WithCategory(err error, category ErrorCategory) error {
+	if err == nil {
+		return nil
+	}
+	return CategorizedError{Category: category, Err: err}
+}
+
+// ErrorCategorizer is implemented by storage drivers telling the category of
+// the errors of their backend, such as throttling, which CategoryOf cannot
+// tell from the errors themselves. CategorizeError returns
+// ErrorCategoryPermanent for the errors it does not classify.
+type ErrorCategorizer interface {
+	CategorizeError(err error) ErrorCategory
+}
+
+// CategoryOf returns the category of err: the category it is annotated with,
+// ErrorCategoryNotFound for a PathNotFoundError, ErrorCategoryTimeout for an
+// exceeded deadline or a network timeout, and ErrorCategoryPermanent
+// otherwise.
+func CategoryOf(err error) ErrorCategory {
+	var categorized CategorizedError
+	if errors.As(err, &categorized) {
+		return categorized.Category
+	}
+	if errors.As(err, &PathNotFoundError{}) {
+		return ErrorCategoryNotFound
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorCategoryTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorCategoryTimeout
+	}
+	return ErrorCategoryPermanent
+}
+
+// ContentDigestSHA256 returns the base64 encoded SHA-256 digest declared by
+// the Content-Digest header (RFC 9530) of the upload request r, or the empty
+// string if r declares no valid SHA-256 digest.
+func ContentDigestSHA256(r *http.Request) string {
+	for _, field := range strings.Split(r.Header.Get("Content-Digest"), ",") {
+		alg, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || alg != "sha-256" {
+			continue
+		}
+		if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return ""
+		}
+		value = value[1 : len(value)-1]
+		if sum, err := base64.StdEncoding.DecodeString(value); err != nil || len(sum) != sha256.Size {
+			return ""
+		}
+		return value
+	}
+	return ""
+}
+
+// RedirectHeaders are headers requested for the response of the backend to a
+// redirected request. Empty headers are left to the backend.
+type RedirectHeaders struct {
+	CacheControl       string
+	ContentDisposition string
+}
+
+type redirectHeadersKey struct{}
+
+// WithRedirectHeaders returns a shallow copy of r whose redirect requests
+// headers for the response of the backend.
+func WithRedirectHeaders(r *http.Request, headers RedirectHeaders) *http.Request {
+	if headers == (RedirectHeaders{}) {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), redirectHeadersKey{}, headers))
+}
+
+// GetRedirectHeaders returns the headers requested by WithRedirectHeaders
+// for the response of the backend to the redirect of r.
+func GetRedirectHeaders(r *http.Request) RedirectHeaders {
+	headers, _ := r.Context().Value(redirectHeadersKey{}).(RedirectHeaders)
+	return headers
+}
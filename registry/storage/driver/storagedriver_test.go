@@ -0,0 +1,32 @@
+package driver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContentDigestSHA256(t *testing.T) {
+	// base64 of the SHA-256 digest of the empty string
+	const empty = "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+
+	for _, tc := range []struct {
+		header   string
+		expected string
+	}{
+		{header: "", expected: ""},
+		{header: "sha-256=:" + empty + ":", expected: empty},
+		{header: "sha-512=:abcd:, sha-256=:" + empty + ":", expected: empty},
+		{header: "sha-256=" + empty, expected: ""},
+		{header: "sha-256=:abcd:", expected: ""},
+		{header: "sha-256=:not base64:", expected: ""},
+	} {
+		r := httptest.NewRequest(http.MethodPut, "/", nil)
+		if tc.header != "" {
+			r.Header.Set("Content-Digest", tc.header)
+		}
+		if actual := ContentDigestSHA256(r); actual != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.header, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+// Package tarfile provides a read-only storage driver serving the files of a
+// single uninterrupted tar archive, such as a tarball of the storage of a
+// registry or an OCI image layout, without unpacking it. The offsets of the
+// files in the archive are indexed when the driver starts, and the index is
+// persisted next to the archive so that later starts do not read the archive
+// again.
+package tarfile
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+)
+
+const (
+	driverName = "tarfile"
+
+	// LayoutRegistry is the layout of an archive holding the storage tree
+	// of a registry, as written by another storage driver.
+	LayoutRegistry = "registry"
+
+	// LayoutOCI is the layout of an archive holding an OCI image layout,
+	// served as a single repository.
+	LayoutOCI = "oci"
+)
+
+// DriverParameters represents all configuration options available for the
+// tarfile driver.
+type DriverParameters struct {
+	// Path is the path of the tar archive.
+	Path string
+
+	// IndexPath is the path of the offset index of the archive, Path with
+	// the .index suffix by default.
+	IndexPath string
+
+	// Layout is the layout of the archive, LayoutRegistry by default.
+	Layout string
+
+	// RootDirectory is the directory of the archive served as the root of
+	// the driver, with the registry layout. By default, the whole archive is
+	// served.
+	RootDirectory string
+
+	// Repository is the name of the repository serving an OCI image layout.
+	Repository string
+}
+
+func init() {
+	factory.Register(driverName, &tarfileDriverFactory{})
+}
+
+// tarfileDriverFactory implements the factory.StorageDriverFactory interface
+type tarfileDriverFactory struct{}
+
+func (factory *tarfileDriverFactory) Create(ctx context.Context, parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return FromParameters(ctx, parameters)
+}
+
+// file is a file served by the driver.
+type file struct {
+	// offset is the offset of the content of the file in the archive.
+	offset  int64
+	size    int64
+	modTime time.Time
+	// content holds the content of the files which are not in the archive,
+	// such as the links of an OCI image layout.
+	content []byte
+}
+
+type driver struct {
+	archive *os.File
+	modTime time.Time
+	files   map[string]*file
+	// dirs holds the direct descendants of each directory.
+	dirs map[string][]string
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a read-only storagedriver.StorageDriver implementation serving
+// the files of a tar archive.
+type Driver struct {
+	baseEmbed
+}
+
+// FromParameters constructs a new Driver with a given parameters map
+// Required parameters:
+// - path
+// Optional Parameters:
+// - indexpath
+// - layout
+// - rootdirectory
+// - repository
+func FromParameters(ctx context.Context, parameters map[string]interface{}) (*Driver, error) {
+	var params DriverParameters
+	for key, dst := range map[string]*string{
+		"path":          &params.Path,
+		"indexpath":     &params.IndexPath,
+		"layout":        &params.Layout,
+		"rootdirectory": &params.RootDirectory,
+		"repository":    &params.Repository,
+	} {
+		if v, ok := parameters[key]; ok && v != nil {
+			*dst = fmt.Sprint(v)
+		}
+	}
+	return New(ctx, params)
+}
+
+// New constructs a new Driver serving the archive described by params. The
+// offset index is read from params.IndexPath if it is up to date with the
+// archive, and written there otherwise.
+func New(ctx context.Context, params DriverParameters) (*Driver, error) {
+	if params.Path == "" {
+		return nil, errors.New("no path parameter provided")
+	}
+	if params.IndexPath == "" {
+		params.IndexPath = params.Path + ".index"
+	}
+
+	archive, err := os.Open(params.Path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := archive.Stat()
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+
+	idx, err := loadIndex(ctx, archive, fi, params.IndexPath)
+	if err != nil {
+		archive.Close()
+		return nil, fmt.Errorf("failed to index %s: %w", params.Path, err)
+	}
+
+	d := &driver{
+		archive: archive,
+		modTime: fi.ModTime(),
+		files:   make(map[string]*file),
+		dirs:    make(map[string][]string),
+	}
+	switch params.Layout {
+	case "", LayoutRegistry:
+		if params.Repository != "" {
+			err = errors.New("repository parameter requires the oci layout")
+		} else {
+			d.addRegistryLayout(idx, params.RootDirectory)
+		}
+	case LayoutOCI:
+		if params.RootDirectory != "" {
+			err = errors.New("rootdirectory parameter requires the registry layout")
+		} else {
+			err = d.addOCILayout(idx, params.Repository)
+		}
+	default:
+		err = fmt.Errorf("invalid layout %q, must be %q or %q", params.Layout, LayoutRegistry, LayoutOCI)
+	}
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+	d.indexDirs()
+
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: d,
+			},
+		},
+	}, nil
+}
+
+// index locates the regular files of an archive.
+type index struct {
+	// Size and ModTime identify the archive indexed.
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+
+	// Files holds the files by their name in the archive, without leading
+	// slash.
+	Files map[string]indexEntry `json:"files"`
+}
+
+type indexEntry struct {
+	Offset  int64     `json:"offset"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+}
+
+// loadIndex reads the index of the archive at indexPath, or indexes the
+// archive if the index is missing or out of date, and then tries to write it
+// to indexPath.
+func loadIndex(ctx context.Context, archive *os.File, fi os.FileInfo, indexPath string) (*index, error) {
+	if p, err := os.ReadFile(indexPath); err == nil {
+		var idx index
+		if err := json.Unmarshal(p, &idx); err == nil && idx.Size == fi.Size() && idx.ModTime.Equal(fi.ModTime()) {
+			return &idx, nil
+		}
+		dcontext.GetLogger(ctx).Infof("tarfile: index %s is out of date, indexing %s", indexPath, archive.Name())
+	} else if !os.IsNotExist(err) {
+		dcontext.GetLogger(ctx).Warnf("tarfile: failed to read index %s: %v", indexPath, err)
+	}
+
+	idx, err := buildIndex(archive)
+	if err != nil {
+		return nil, err
+	}
+	idx.Size = fi.Size()
+	idx.ModTime = fi.ModTime()
+
+	if err := writeIndex(idx, indexPath); err != nil {
+		dcontext.GetLogger(ctx).Warnf("tarfile: failed to write index %s, the archive will be indexed again on the next start: %v", indexPath, err)
+	}
+	return idx, nil
+}
+
+// buildIndex reads the headers of the archive, seeking over the content of
+// the files.
+func buildIndex(archive *os.File) (*index, error) {
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	idx := &index{Files: make(map[string]indexEntry)}
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return idx, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+		case tar.TypeGNUSparse:
+			return nil, fmt.Errorf("sparse file %s is not supported", hdr.Name)
+		default:
+			// Directories are implied by the files, and links are not
+			// followed.
+			continue
+		}
+		if hdr.PAXRecords != nil {
+			if _, ok := hdr.PAXRecords["GNU.sparse.major"]; ok {
+				return nil, fmt.Errorf("sparse file %s is not supported", hdr.Name)
+			}
+		}
+
+		// The reader stops at the start of the content of the file it
+		// returns the header of.
+		offset, err := archive.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		idx.Files[strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")] = indexEntry{
+			Offset:  offset,
+			Size:    hdr.Size,
+			ModTime: hdr.ModTime,
+		}
+	}
+}
+
+// writeIndex writes idx to indexPath, replacing it atomically.
+func writeIndex(idx *index, indexPath string) error {
+	p, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(path.Dir(indexPath), "."+path.Base(indexPath)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(p); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), indexPath)
+}
+
+// addRegistryLayout serves the files of the archive below rootDirectory.
+func (d *driver) addRegistryLayout(idx *index, rootDirectory string) {
+	prefix := strings.TrimPrefix(path.Clean("/"+rootDirectory), "/")
+	for name, entry := range idx.Files {
+		if prefix != "" {
+			if !strings.HasPrefix(name, prefix+"/") {
+				continue
+			}
+			name = strings.TrimPrefix(name, prefix)
+		}
+		d.files[path.Clean("/"+name)] = &file{offset: entry.Offset, size: entry.Size, modTime: entry.ModTime}
+	}
+}
+
+// indexDirs records the direct descendants of the directories of the files.
+func (d *driver) indexDirs() {
+	children := map[string]map[string]struct{}{"/": {}}
+	for p := range d.files {
+		for p != "/" {
+			parent := path.Dir(p)
+			if children[parent] == nil {
+				children[parent] = make(map[string]struct{})
+			}
+			if _, ok := children[parent][p]; ok {
+				break
+			}
+			children[parent][p] = struct{}{}
+			p = parent
+		}
+	}
+	for dir, set := range children {
+		list := make([]string, 0, len(set))
+		for p := range set {
+			list = append(list, p)
+		}
+		sort.Strings(list)
+		d.dirs[dir] = list
+	}
+}
+
+// Implement the storagedriver.StorageDriver interface
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	rc, err := d.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// PutContent fails, as the driver is read-only.
+func (d *driver) PutContent(ctx context.Context, path string, contents []byte) error {
+	return storagedriver.ReadOnlyError{Path: path}
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset, reading the archive from the offset of the content.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, ok := d.files[path]
+	if !ok {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+	if offset > f.size {
+		offset = f.size
+	}
+
+	if f.content != nil {
+		return io.NopCloser(bytes.NewReader(f.content[offset:])), nil
+	}
+	return io.NopCloser(io.NewSectionReader(d.archive, f.offset+offset, f.size-offset)), nil
+}
+
+// Writer fails, as the driver is read-only.
+func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	return nil, storagedriver.ReadOnlyError{Path: path}
+}
+
+// Stat returns info about the provided path.
+func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	if f, ok := d.files[path]; ok {
+		return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+			Path:    path,
+			Size:    f.size,
+			ModTime: f.modTime,
+		}}, nil
+	}
+	if _, ok := d.dirs[path]; ok {
+		return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+			Path:    path,
+			ModTime: d.modTime,
+			IsDir:   true,
+		}}, nil
+	}
+	return nil, storagedriver.PathNotFoundError{Path: path}
+}
+
+// List returns a list of the objects that are direct descendants of the given
+// path.
+func (d *driver) List(ctx context.Context, path string) ([]string, error) {
+	children, ok := d.dirs[path]
+	if !ok {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+	return append([]string(nil), children...), nil
+}
+
+// Move fails, as the driver is read-only.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	return storagedriver.ReadOnlyError{Path: destPath}
+}
+
+// Delete fails, as the driver is read-only.
+func (d *driver) Delete(ctx context.Context, path string) error {
+	return storagedriver.ReadOnlyError{Path: path}
+}
+
+// RedirectURL returns a URL which may be used to retrieve the content stored
+// at the given path.
+func (d *driver) RedirectURL(*http.Request, string) (string, error) {
+	return "", nil
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file and directory
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return storagedriver.WalkFallback(ctx, d, path, f, options...)
+}
@@ -0,0 +1,241 @@
+package tarfile
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// writeArchive writes files, by name, to a new tar archive in dir.
+func writeArchive(t testing.TB, dir string, files map[string][]byte) string {
+	archivePath := filepath.Join(dir, "registry.tar")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tar.NewWriter(f)
+	for _, name := range names {
+		// Directories and links are skipped.
+		dir := strings.TrimPrefix(filepath.Dir(name), "/")
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0o755}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: dir + "/symlink", Linkname: name}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "./" + strings.TrimPrefix(name, "/"), Size: int64(len(files[name])), Mode: 0o644, ModTime: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archivePath
+}
+
+func TestTarfileDriverSuite(t *testing.T) {
+	dir := t.TempDir()
+	testsuites.ReadOnlyDriver(t, func(files map[string][]byte) (storagedriver.StorageDriver, error) {
+		// The symlinks written along the files are not served.
+		for name := range files {
+			if filepath.Base(name) == "symlink" {
+				delete(files, name)
+			}
+		}
+		return FromParameters(context.Background(), map[string]interface{}{
+			"path": writeArchive(t, dir, files),
+		})
+	})
+}
+
+func TestIndex(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	archivePath := writeArchive(t, dir, map[string][]byte{
+		"/registry/a": []byte("a"),
+		"/registry/b": []byte("b"),
+		"/other/c":    []byte("c"),
+	})
+	indexPath := filepath.Join(dir, "registry.index")
+
+	d, err := New(ctx, DriverParameters{Path: archivePath, IndexPath: indexPath, RootDirectory: "registry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, err := d.List(ctx, "/"); err != nil || len(files) != 2 {
+		t.Fatalf("unexpected files below the root directory: %v, %v", files, err)
+	}
+
+	// The index persisted is used as long as the archive is unchanged.
+	p, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatalf("expected the index to be persisted: %v", err)
+	}
+	var idx index
+	if err := json.Unmarshal(p, &idx); err != nil {
+		t.Fatal(err)
+	}
+	idx.Files["registry/copy"] = idx.Files["registry/a"]
+	if p, err = json.Marshal(idx); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(indexPath, p, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err = New(ctx, DriverParameters{Path: archivePath, IndexPath: indexPath, RootDirectory: "registry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := d.GetContent(ctx, "/copy"); err != nil || string(content) != "a" {
+		t.Fatalf("expected the persisted index to be used: %q, %v", content, err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(archivePath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	d, err = New(ctx, DriverParameters{Path: archivePath, IndexPath: indexPath, RootDirectory: "registry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, "/copy"); !errors.As(err, &storagedriver.PathNotFoundError{}) {
+		t.Fatalf("expected the archive to be indexed again, got %v", err)
+	}
+}
+
+func TestFromParameters(t *testing.T) {
+	ctx := context.Background()
+	archivePath := writeArchive(t, t.TempDir(), map[string][]byte{"/a": []byte("a")})
+
+	for _, params := range []map[string]interface{}{
+		{},
+		{"path": archivePath + ".missing"},
+		{"path": archivePath, "layout": "zip"},
+		{"path": archivePath, "repository": "foo"},
+		{"path": archivePath, "layout": "oci", "repository": "foo"},
+		{"path": archivePath, "layout": "oci", "repository": "Invalid"},
+	} {
+		if _, err := FromParameters(ctx, params); err == nil {
+			t.Errorf("expected an error for %v", params)
+		}
+	}
+}
+
+func TestOCILayout(t *testing.T) {
+	ctx := dcontext.Background()
+	files := make(map[string][]byte)
+	blob := func(mediaType string, content []byte) v1.Descriptor {
+		dgst := digest.FromBytes(content)
+		files["/blobs/sha256/"+dgst.Encoded()] = content
+		return v1.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(content))}
+	}
+	marshal := func(v interface{}) []byte {
+		p, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	config := blob(v1.MediaTypeImageConfig, []byte(`{"architecture":"amd64","os":"linux"}`))
+	layer := blob(v1.MediaTypeImageLayerGzip, []byte("layer"))
+	image := blob(v1.MediaTypeImageManifest, marshal(v1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []v1.Descriptor{layer},
+	}))
+	image.Platform = &v1.Platform{Architecture: "amd64", OS: "linux"}
+	imageIndex := blob(v1.MediaTypeImageIndex, marshal(v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageIndex,
+		Manifests: []v1.Descriptor{image},
+	}))
+	imageIndex.Annotations = map[string]string{v1.AnnotationRefName: "docker.io/ci/app:v1"}
+	files["/"+v1.ImageLayoutFile] = []byte(`{"imageLayoutVersion":"1.0.0"}`)
+	files["/index.json"] = marshal(v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageIndex,
+		Manifests: []v1.Descriptor{imageIndex},
+	})
+
+	d, err := FromParameters(ctx, map[string]interface{}{
+		"path":       writeArchive(t, t.TempDir(), files),
+		"layout":     "oci",
+		"repository": "ci/app",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := storage.NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, err := reference.WithName("ci/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc, err := repo.Tags(ctx).Get(ctx, "v1")
+	if err != nil {
+		t.Fatalf("failed getting tag: %v", err)
+	}
+	if desc.Digest != imageIndex.Digest {
+		t.Fatalf("unexpected tagged manifest %s, expected %s", desc.Digest, imageIndex.Digest)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dgst := range []digest.Digest{imageIndex.Digest, image.Digest} {
+		if _, err := manifests.Get(ctx, dgst); err != nil {
+			t.Fatalf("failed getting manifest %s: %v", dgst, err)
+		}
+	}
+
+	rc, err := repo.Blobs(ctx).Open(ctx, layer.Digest)
+	if err != nil {
+		t.Fatalf("failed opening layer: %v", err)
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil || string(content) != "layer" {
+		t.Fatalf("unexpected layer content %q: %v", content, err)
+	}
+
+	if err := manifests.Delete(ctx, image.Digest); err == nil {
+		t.Fatal("expected deleting from the archive to fail")
+	}
+}
@@ -0,0 +1,140 @@
+package tarfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// registryRoot is the directory of the storage tree of a registry.
+const registryRoot = "/docker/registry/v2"
+
+// blobsDir and indexFile are the directory of the blobs and the file of the
+// image index of an OCI image layout.
+const (
+	blobsDir  = "blobs"
+	indexFile = "index.json"
+)
+
+// maxIndexSize bounds the size of the image indexes read from the archive.
+const maxIndexSize = 4 << 20
+
+// addOCILayout serves the OCI image layout of the archive as the storage tree
+// of a registry holding a single repository. Its blobs are served as the
+// blobs of the registry, linked as layers of the repository. The manifests
+// listed by the index of the layout, and those of the image indexes they
+// reference, are linked as manifests of the repository, tagged with their
+// org.opencontainers.image.ref.name annotation.
+func (d *driver) addOCILayout(idx *index, repository string) error {
+	named, err := reference.WithName(repository)
+	if err != nil {
+		return fmt.Errorf("invalid repository parameter %q: %w", repository, err)
+	}
+	if _, ok := idx.Files[v1.ImageLayoutFile]; !ok {
+		return fmt.Errorf("no %s file, the archive is not an OCI image layout", v1.ImageLayoutFile)
+	}
+	repoPath := path.Join(registryRoot, "repositories", named.Name())
+
+	for name, entry := range idx.Files {
+		dir, encoded := path.Split(name)
+		if !strings.HasPrefix(dir, blobsDir+"/") {
+			continue
+		}
+		dgst := digest.NewDigestFromEncoded(digest.Algorithm(path.Base(dir)), encoded)
+		if err := dgst.Validate(); err != nil {
+			return fmt.Errorf("invalid blob %s: %w", name, err)
+		}
+		d.files[path.Join(registryRoot, "blobs", dgst.Algorithm().String(), encoded[:2], encoded, "data")] = &file{
+			offset:  entry.Offset,
+			size:    entry.Size,
+			modTime: entry.ModTime,
+		}
+		d.addLink(path.Join(repoPath, "_layers", dgst.Algorithm().String(), encoded, "link"), dgst)
+	}
+
+	root, err := d.readIndex(idx, indexFile)
+	if err != nil {
+		return err
+	}
+	for _, desc := range root.Manifests {
+		if name := desc.Annotations[v1.AnnotationRefName]; name != "" {
+			tag := name
+			if i := strings.LastIndex(name, ":"); i >= 0 && !strings.Contains(name[i:], "/") {
+				tag = name[i+1:]
+			}
+			if _, err := reference.WithTag(named, tag); err != nil {
+				return fmt.Errorf("invalid tag of %s: %w", desc.Digest, err)
+			}
+			tagPath := path.Join(repoPath, "_manifests", "tags", tag)
+			d.addLink(path.Join(tagPath, "current", "link"), desc.Digest)
+			d.addLink(path.Join(tagPath, "index", desc.Digest.Algorithm().String(), desc.Digest.Encoded(), "link"), desc.Digest)
+		}
+		if err := d.addManifests(idx, repoPath, desc, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addManifests links the manifest described by desc in the repository, and
+// the manifests it references if it is an image index.
+func (d *driver) addManifests(idx *index, repoPath string, desc v1.Descriptor, depth int) error {
+	if err := desc.Digest.Validate(); err != nil {
+		return err
+	}
+	d.addLink(path.Join(repoPath, "_manifests", "revisions", desc.Digest.Algorithm().String(), desc.Digest.Encoded(), "link"), desc.Digest)
+
+	switch desc.MediaType {
+	case v1.MediaTypeImageIndex, "application/vnd.docker.distribution.manifest.list.v2+json":
+	default:
+		return nil
+	}
+	if depth > 8 {
+		return fmt.Errorf("image index %s nested too deeply", desc.Digest)
+	}
+	imageIndex, err := d.readIndex(idx, path.Join(blobsDir, desc.Digest.Algorithm().String(), desc.Digest.Encoded()))
+	if err != nil {
+		return err
+	}
+	for _, child := range imageIndex.Manifests {
+		if err := d.addManifests(idx, repoPath, child, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readIndex reads the image index at name in the archive.
+func (d *driver) readIndex(idx *index, name string) (*v1.Index, error) {
+	entry, ok := idx.Files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in the OCI image layout", name)
+	}
+	if entry.Size > maxIndexSize {
+		return nil, fmt.Errorf("image index %s is too large", name)
+	}
+	p, err := io.ReadAll(io.NewSectionReader(d.archive, entry.Offset, entry.Size))
+	if err != nil {
+		return nil, err
+	}
+	var imageIndex v1.Index
+	if err := json.Unmarshal(p, &imageIndex); err != nil {
+		return nil, fmt.Errorf("invalid image index %s: %w", name, err)
+	}
+	return &imageIndex, nil
+}
+
+// addLink serves a link file to dgst at p.
+func (d *driver) addLink(p string, dgst digest.Digest) {
+	d.files[p] = &file{
+		size:    int64(len(dgst.String())),
+		modTime: d.modTime,
+		content: []byte(dgst.String()),
+	}
+}
@@ -0,0 +1,273 @@
+package testsuites
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/stretchr/testify/suite"
+)
+
+// ReadOnlyDriverConstructor is a function which returns a new read-only
+// storagedriver.StorageDriver holding files, by path.
+type ReadOnlyDriverConstructor func(files map[string][]byte) (storagedriver.StorageDriver, error)
+
+// ReadOnlyDriverSuite is a [suite.Suite] test suite designed to test the
+// reads of a read-only storagedriver.StorageDriver, and that its writes fail
+// with a storagedriver.ReadOnlyError.
+type ReadOnlyDriverSuite struct {
+	suite.Suite
+	Constructor ReadOnlyDriverConstructor
+	Teardown    DriverTeardown
+	storagedriver.StorageDriver
+	ctx context.Context
+
+	// root is the directory holding the files of the driver.
+	root  string
+	files map[string][]byte
+}
+
+// ReadOnlyDriver runs [ReadOnlyDriverSuite] for the given
+// [ReadOnlyDriverConstructor].
+func ReadOnlyDriver(t *testing.T, driverConstructor ReadOnlyDriverConstructor) {
+	suite.Run(t, &ReadOnlyDriverSuite{
+		Constructor: driverConstructor,
+		ctx:         context.Background(),
+	})
+}
+
+// SetupSuite implements [suite.SetupAllSuite] interface.
+func (suite *ReadOnlyDriverSuite) SetupSuite() {
+	suite.root = "/" + randomFilename(int64(8+rand.Intn(8)))
+	suite.files = map[string][]byte{
+		path.Join(suite.root, "empty"):         {},
+		path.Join(suite.root, "small"):         randomContents(32),
+		path.Join(suite.root, "nonutf8"):       []byte("\xc3\x28"),
+		path.Join(suite.root, "large", "data"): randomContents(4 << 20),
+	}
+	for i := 0; i < 20; i++ {
+		suite.files[path.Join(suite.root, "list", randomFilename(int64(8+rand.Intn(8))))] = randomContents(32)
+	}
+
+	d, err := suite.Constructor(suite.files)
+	suite.Require().NoError(err)
+	suite.StorageDriver = d
+}
+
+// TearDownSuite implements [suite.TearDownAllSuite].
+func (suite *ReadOnlyDriverSuite) TearDownSuite() {
+	if suite.Teardown != nil {
+		suite.Require().NoError(suite.Teardown())
+	}
+}
+
+// TestRootExists ensures that the root lists the directory holding the
+// files.
+func (suite *ReadOnlyDriverSuite) TestRootExists() {
+	keys, err := suite.StorageDriver.List(suite.ctx, "/")
+	suite.Require().NoError(err)
+	suite.Require().Equal([]string{suite.root}, keys)
+}
+
+// TestGetContent checks the content of each file.
+func (suite *ReadOnlyDriverSuite) TestGetContent() {
+	for filename, contents := range suite.files {
+		readContents, err := suite.StorageDriver.GetContent(suite.ctx, filename)
+		suite.Require().NoError(err)
+		suite.Require().Equal(contents, readContents, filename)
+	}
+}
+
+// TestReadNonexistent tests reading content from a nonexistent path.
+func (suite *ReadOnlyDriverSuite) TestReadNonexistent() {
+	filename := path.Join(suite.root, "nonexistent")
+	_, err := suite.StorageDriver.GetContent(suite.ctx, filename)
+	suite.Require().IsType(err, storagedriver.PathNotFoundError{})
+	suite.Require().Contains(err.Error(), suite.Name())
+
+	_, err = suite.StorageDriver.Reader(suite.ctx, filename, 0)
+	suite.Require().IsType(err, storagedriver.PathNotFoundError{})
+	suite.Require().Contains(err.Error(), suite.Name())
+
+	// Directories have no content.
+	_, err = suite.StorageDriver.GetContent(suite.ctx, path.Join(suite.root, "list"))
+	suite.Require().IsType(err, storagedriver.PathNotFoundError{})
+}
+
+// TestReaderWithOffset tests that the appropriate data is streamed when
+// reading with a given offset.
+func (suite *ReadOnlyDriverSuite) TestReaderWithOffset() {
+	filename := path.Join(suite.root, "large", "data")
+	contents := suite.files[filename]
+	size := int64(len(contents))
+
+	for _, offset := range []int64{0, 1, size / 2, size - 1, size} {
+		reader, err := suite.StorageDriver.Reader(suite.ctx, filename, offset)
+		suite.Require().NoError(err)
+		readContents, err := io.ReadAll(reader)
+		reader.Close()
+		suite.Require().NoError(err)
+		suite.Require().Equal(contents[offset:], readContents, "offset %d", offset)
+	}
+
+	// Reading past the end of the content returns io.EOF.
+	reader, err := suite.StorageDriver.Reader(suite.ctx, filename, size+1)
+	suite.Require().NoError(err)
+	defer reader.Close()
+	n, err := reader.Read(make([]byte, 32))
+	suite.Require().ErrorIs(err, io.EOF)
+	suite.Require().Equal(0, n)
+
+	// Ensure we get invalid offset for negative offsets.
+	_, err = suite.StorageDriver.Reader(suite.ctx, filename, -1)
+	suite.Require().IsType(err, storagedriver.InvalidOffsetError{})
+	suite.Require().Contains(err.Error(), suite.Name())
+}
+
+// TestList checks the direct descendants listed for each directory.
+func (suite *ReadOnlyDriverSuite) TestList() {
+	doesnotexist := path.Join(suite.root, "nonexistent")
+	_, err := suite.StorageDriver.List(suite.ctx, doesnotexist)
+	suite.Require().Equal(err, storagedriver.PathNotFoundError{
+		Path:       doesnotexist,
+		DriverName: suite.StorageDriver.Name(),
+	})
+
+	keys, err := suite.StorageDriver.List(suite.ctx, suite.root)
+	suite.Require().NoError(err)
+	sort.Strings(keys)
+	suite.Require().Equal([]string{
+		path.Join(suite.root, "empty"),
+		path.Join(suite.root, "large"),
+		path.Join(suite.root, "list"),
+		path.Join(suite.root, "nonutf8"),
+		path.Join(suite.root, "small"),
+	}, keys)
+
+	var childFiles []string
+	for filename := range suite.files {
+		if path.Dir(filename) == path.Join(suite.root, "list") {
+			childFiles = append(childFiles, filename)
+		}
+	}
+	sort.Strings(childFiles)
+	keys, err = suite.StorageDriver.List(suite.ctx, path.Join(suite.root, "list"))
+	suite.Require().NoError(err)
+	sort.Strings(keys)
+	suite.Require().Equal(childFiles, keys)
+}
+
+// TestStatCall verifies the information returned by Stat for files and
+// directories.
+func (suite *ReadOnlyDriverSuite) TestStatCall() {
+	fi, err := suite.StorageDriver.Stat(suite.ctx, path.Join(suite.root, "nonexistent"))
+	suite.Require().IsType(err, storagedriver.PathNotFoundError{})
+	suite.Require().Contains(err.Error(), suite.Name())
+	suite.Require().Nil(fi)
+
+	for filename, contents := range suite.files {
+		fi, err := suite.StorageDriver.Stat(suite.ctx, filename)
+		suite.Require().NoError(err)
+		suite.Require().Equal(filename, fi.Path())
+		suite.Require().Equal(int64(len(contents)), fi.Size())
+		suite.Require().False(fi.IsDir())
+	}
+
+	dirPath := path.Join(suite.root, "large")
+	fi, err = suite.StorageDriver.Stat(suite.ctx, dirPath)
+	suite.Require().NoError(err)
+	suite.Require().Equal(dirPath, fi.Path())
+	suite.Require().True(fi.IsDir())
+}
+
+// TestWalk checks that walking the root visits every file.
+func (suite *ReadOnlyDriverSuite) TestWalk() {
+	var walked []string
+	err := suite.StorageDriver.Walk(suite.ctx, "/", func(fi storagedriver.FileInfo) error {
+		if !fi.IsDir() {
+			walked = append(walked, fi.Path())
+		}
+		return nil
+	})
+	suite.Require().NoError(err)
+
+	var expected []string
+	for filename := range suite.files {
+		expected = append(expected, filename)
+	}
+	sort.Strings(expected)
+	sort.Strings(walked)
+	suite.Require().Equal(expected, walked)
+}
+
+// TestWritesFail checks that the writes fail with a ReadOnlyError, leaving
+// the files unchanged.
+func (suite *ReadOnlyDriverSuite) TestWritesFail() {
+	existing := path.Join(suite.root, "small")
+	created := path.Join(suite.root, "created")
+
+	expectReadOnly := func(err error) {
+		suite.T().Helper()
+		var readOnlyErr storagedriver.ReadOnlyError
+		suite.Require().True(errors.As(err, &readOnlyErr), "expected a read-only error, got %v", err)
+		suite.Require().Contains(err.Error(), suite.Name())
+	}
+
+	expectReadOnly(suite.StorageDriver.PutContent(suite.ctx, existing, randomContents(32)))
+	expectReadOnly(suite.StorageDriver.PutContent(suite.ctx, created, randomContents(32)))
+	_, err := suite.StorageDriver.Writer(suite.ctx, created, false)
+	expectReadOnly(err)
+	_, err = suite.StorageDriver.Writer(suite.ctx, existing, true)
+	expectReadOnly(err)
+	expectReadOnly(suite.StorageDriver.Move(suite.ctx, existing, created))
+	expectReadOnly(suite.StorageDriver.Delete(suite.ctx, existing))
+	expectReadOnly(suite.StorageDriver.Delete(suite.ctx, suite.root))
+
+	readContents, err := suite.StorageDriver.GetContent(suite.ctx, existing)
+	suite.Require().NoError(err)
+	suite.Require().Equal(suite.files[existing], readContents)
+	_, err = suite.StorageDriver.Stat(suite.ctx, created)
+	suite.Require().IsType(err, storagedriver.PathNotFoundError{})
+}
+
+// TestConcurrentStreamReads checks that multiple clients can safely read from
+// the same file simultaneously with various offsets.
+func (suite *ReadOnlyDriverSuite) TestConcurrentStreamReads() {
+	filename := path.Join(suite.root, "large", "data")
+	contents := suite.files[filename]
+
+	var wg sync.WaitGroup
+	readContents := func() {
+		defer wg.Done()
+		offset := rand.Int63n(int64(len(contents)))
+		reader, err := suite.StorageDriver.Reader(suite.ctx, filename, offset)
+		suite.Require().NoError(err)
+		defer reader.Close()
+
+		readContents, err := io.ReadAll(reader)
+		suite.Require().NoError(err)
+		suite.Require().True(bytes.Equal(contents[offset:], readContents), "unexpected content read at offset %d", offset)
+	}
+
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		go readContents()
+	}
+	wg.Wait()
+}
+
+// TestInvalidPaths checks that invalid paths are rejected.
+func (suite *ReadOnlyDriverSuite) TestInvalidPaths() {
+	for _, filename := range []string{"", "/", "//bad", "/bad/", strings.Repeat("a", 8)} {
+		_, err := suite.StorageDriver.GetContent(suite.ctx, filename)
+		suite.Require().IsType(err, storagedriver.InvalidPathError{}, filename)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	crand "crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"io"
 	"math/rand"
 	"net/http"
@@ -763,6 +764,46 @@ func (suite *DriverSuite) TestRedirectURL() {
 	suite.Require().Equal(int64(32), response.ContentLength)
 }
 
+// TestRedirectURLUpload checks that content may be uploaded to the URL
+// returned by RedirectURL for PUT requests, and that content which does not
+// match the declared digest is rejected, but only if it is implemented
+func (suite *DriverSuite) TestRedirectURLUpload() {
+	filename := randomPath(32)
+	contents := randomContents(32)
+	sum := sha256.Sum256(contents)
+
+	defer suite.deletePath(firstPart(filename))
+
+	r := httptest.NewRequest(http.MethodPut, filename, nil)
+	r.ContentLength = int64(len(contents))
+	r.Header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+
+	url, err := suite.StorageDriver.RedirectURL(r, filename)
+	if url == "" && err == nil {
+		return
+	}
+	suite.Require().NoError(err)
+
+	upload := func(contents []byte) int {
+		req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(contents))
+		suite.Require().NoError(err)
+		response, err := http.DefaultClient.Do(req)
+		suite.Require().NoError(err)
+		defer response.Body.Close()
+		return response.StatusCode
+	}
+
+	tampered := randomContents(32)
+	suite.Require().GreaterOrEqual(upload(tampered), 400)
+	_, err = suite.StorageDriver.Stat(suite.ctx, filename)
+	suite.Require().ErrorAs(err, new(storagedriver.PathNotFoundError))
+
+	suite.Require().Equal(http.StatusOK, upload(contents))
+	received, err := suite.StorageDriver.GetContent(suite.ctx, filename)
+	suite.Require().NoError(err)
+	suite.Require().Equal(contents, received)
+}
+
 // TestDeleteNonexistent checks that removing a nonexistent key fails.
 func (suite *DriverSuite) TestDeleteNonexistent() {
 	filename := randomPath(32)
@@ -27,13 +27,22 @@ type WalkFn func(fileInfo FileInfo) error
 // from the given path, calling f on each file. It uses the List method and Stat to drive itself.
 // If the returned error from the WalkFn is ErrSkipDir the directory will not be entered and Walk
 // will continue the traversal. If the returned error from the WalkFn is ErrFilledBuffer, the walk
-// stops.
+// stops. If the Parallelism option is greater than one, the directories following the one being
+// walked are listed ahead, concurrently, while f is still called sequentially in the same order.
 func WalkFallback(ctx context.Context, driver StorageDriver, from string, f WalkFn, options ...func(*WalkOptions)) error {
 	walkOptions := &WalkOptions{}
 	for _, o := range options {
 		o(walkOptions)
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := &fallbackWalker{driver: driver, f: f}
+	if walkOptions.Parallelism > 1 {
+		w.parallelism = walkOptions.Parallelism
+		w.sem = make(chan struct{}, walkOptions.Parallelism)
+	}
+
 	startAfterHint := walkOptions.StartAfterHint
 	// Ensure that we are checking the hint is contained within from by adding a "/".
 	// Add to both in case the hint and form are the same, which would still count.
@@ -42,18 +51,18 @@ func WalkFallback(ctx context.Context, driver StorageDriver, from string, f Walk
 		// The startAfterHint is outside from, so check if we even need to walk anything
 		// Replace any path separators with \x00 so that the sort works in a depth-first way
 		if strings.ReplaceAll(startAfterHint, "/", "\x00") < strings.ReplaceAll(from, "/", "\x00") {
-			_, err := doWalkFallback(ctx, driver, from, "", f)
+			_, err := w.walk(ctx, from, "", nil)
 			return err
 		}
 	} else {
 		// The startAfterHint is within from.
 		// Walk up the tree until we hit from - we know it is contained.
 		// Ensure startAfterHint is never deeper than a child of the base
-		// directory so that doWalkFallback doesn't have to worry about
+		// directory so that walk doesn't have to worry about
 		// depth-first comparisons
 		base := startAfterHint
 		for strings.HasPrefix(base, from) {
-			_, err = doWalkFallback(ctx, driver, base, startAfterHint, f)
+			_, err = w.walk(ctx, base, startAfterHint, nil)
 			switch err.(type) {
 			case nil:
 				// No error
@@ -76,28 +85,53 @@ func WalkFallback(ctx context.Context, driver StorageDriver, from string, f Walk
 	return nil
 }
 
-// doWalkFallback performs a depth first walk using recursion.
-// from is the directory that this iteration of the function should walk.
-// startAfterHint is the child within from to start the walk after. It should only ever be a child of from, or the empty string.
-func doWalkFallback(ctx context.Context, driver StorageDriver, from string, startAfterHint string, f WalkFn) (bool, error) {
-	children, err := driver.List(ctx, from)
+// fallbackWalker walks a driver with its List and Stat methods.
+type fallbackWalker struct {
+	driver StorageDriver
+	f      WalkFn
+	// parallelism is the number of directories listed ahead of the walk, if
+	// greater than one.
+	parallelism int
+	// sem limits the number of directories listed concurrently.
+	sem chan struct{}
+}
+
+// dirListing holds the sorted children of a directory. Directories listed
+// ahead of the walk also hold the FileInfo of their children, which is nil
+// for children removed before they could be stated.
+type dirListing struct {
+	done     chan struct{}
+	children []string
+	infos    []FileInfo
+	err      error
+}
+
+// list lists the children of from after startAfterHint, and states them if
+// the walk is parallel.
+func (w *fallbackWalker) list(ctx context.Context, from string, startAfterHint string) *dirListing {
+	listing := &dirListing{done: make(chan struct{})}
+	defer close(listing.done)
+
+	children, err := w.driver.List(ctx, from)
 	if err != nil {
-		return false, err
+		listing.err = err
+		return listing
 	}
 	sort.Strings(children)
 	for _, child := range children {
 		// The startAfterHint has been sanitised in WalkFallback and will either be
 		// empty, or be suitable for an <= check for this _from_.
-		if child <= startAfterHint {
-			continue
+		if child > startAfterHint {
+			listing.children = append(listing.children, child)
 		}
+	}
+	if w.parallelism == 0 {
+		return listing
+	}
 
-		// TODO(stevvooe): Calling driver.Stat for every entry is quite
-		// expensive when running against backends with a slow Stat
-		// implementation, such as GCS. This is very likely a serious
-		// performance bottleneck.
-		// Those backends should have custom walk functions. See S3.
-		fileInfo, err := driver.Stat(ctx, child)
+	listing.infos = make([]FileInfo, len(listing.children))
+	for i, child := range listing.children {
+		fileInfo, err := w.driver.Stat(ctx, child)
 		if err != nil {
 			switch err.(type) {
 			case PathNotFoundError:
@@ -105,12 +139,93 @@ func doWalkFallback(ctx context.Context, driver StorageDriver, from string, star
 				logrus.WithField("path", child).Infof("ignoring deleted path")
 				continue
 			default:
-				return false, err
+				listing.err = err
+				return listing
 			}
 		}
-		err = f(fileInfo)
+		listing.infos[i] = fileInfo
+	}
+	return listing
+}
+
+// listAhead lists from in the background, once fewer than parallelism
+// directories are being listed.
+func (w *fallbackWalker) listAhead(ctx context.Context, from string, startAfterHint string) *dirListing {
+	listing := &dirListing{done: make(chan struct{})}
+	go func() {
+		select {
+		case w.sem <- struct{}{}:
+		case <-ctx.Done():
+			listing.err = ctx.Err()
+			close(listing.done)
+			return
+		}
+		defer func() { <-w.sem }()
+
+		l := w.list(ctx, from, startAfterHint)
+		listing.children, listing.infos, listing.err = l.children, l.infos, l.err
+		close(listing.done)
+	}()
+	return listing
+}
+
+// walk performs a depth first walk using recursion.
+// from is the directory that this iteration of the function should walk, and
+// listing its children, if they were listed ahead.
+// startAfterHint is the child within from to start the walk after. It should only ever be a child of from, or the empty string.
+func (w *fallbackWalker) walk(ctx context.Context, from string, startAfterHint string, listing *dirListing) (bool, error) {
+	if listing == nil {
+		listing = w.list(ctx, from, startAfterHint)
+	}
+	<-listing.done
+	if listing.err != nil {
+		return false, listing.err
+	}
+
+	// The next directories among the children are listed ahead, while the
+	// ones before them are walked.
+	ahead := make(map[string]*dirListing)
+	next := 0
+	for i, child := range listing.children {
+		var fileInfo FileInfo
+		if listing.infos != nil {
+			if next < i {
+				next = i
+			}
+			for ; next < len(listing.children) && len(ahead) < w.parallelism; next++ {
+				if fi := listing.infos[next]; fi != nil && fi.IsDir() {
+					dir := listing.children[next]
+					ahead[dir] = w.listAhead(ctx, dir, startAfterHint)
+				}
+			}
+
+			fileInfo = listing.infos[i]
+			if fileInfo == nil {
+				continue
+			}
+		} else {
+			// TODO(stevvooe): Calling driver.Stat for every entry is quite
+			// expensive when running against backends with a slow Stat
+			// implementation, such as GCS. This is very likely a serious
+			// performance bottleneck.
+			// Those backends should have custom walk functions. See S3.
+			var err error
+			fileInfo, err = w.driver.Stat(ctx, child)
+			if err != nil {
+				switch err.(type) {
+				case PathNotFoundError:
+					// repository was removed in between listing and enumeration. Ignore it.
+					logrus.WithField("path", child).Infof("ignoring deleted path")
+					continue
+				default:
+					return false, err
+				}
+			}
+		}
+
+		err := w.f(fileInfo)
 		if err == nil && fileInfo.IsDir() {
-			if ok, err := doWalkFallback(ctx, driver, child, startAfterHint, f); err != nil || !ok {
+			if ok, err := w.walk(ctx, child, startAfterHint, ahead[child]); err != nil || !ok {
 				return ok, err
 			}
 		} else if err == ErrSkipDir {
@@ -120,6 +235,51 @@ func doWalkFallback(ctx context.Context, driver StorageDriver, from string, star
 		} else if err != nil {
 			return false, err
 		}
+		delete(ahead, child)
 	}
 	return true, nil
 }
+
+// DirectoryDiff finds all directories that are not in common between
+// the previous and current paths in sorted order.
+//
+// # Examples
+//
+//	DirectoryDiff("/path/to/folder", "/path/to/folder/folder/file")
+//	// => [ "/path/to/folder/folder" ]
+//
+//	DirectoryDiff("/path/to/folder/folder1", "/path/to/folder/folder2/file")
+//	// => [ "/path/to/folder/folder2" ]
+//
+//	DirectoryDiff("/path/to/folder/folder1/file", "/path/to/folder/folder2/file")
+//	// => [ "/path/to/folder/folder2" ]
+//
+//	DirectoryDiff("/path/to/folder/folder1/file", "/path/to/folder/folder2/folder1/file")
+//	// => [ "/path/to/folder/folder2", "/path/to/folder/folder2/folder1" ]
+//
+//	DirectoryDiff("/", "/path/to/folder/folder/file")
+//	// => [ "/path", "/path/to", "/path/to/folder", "/path/to/folder/folder" ]
+func DirectoryDiff(prev, current string) []string {
+	var paths []string
+
+	if prev == "" || current == "" {
+		return paths
+	}
+
+	parent := current
+	for {
+		parent = filepath.Dir(parent)
+		if parent == "/" || parent == prev || strings.HasPrefix(prev+"/", parent+"/") {
+			break
+		}
+		paths = append(paths, parent)
+	}
+	reverse(paths)
+	return paths
+}
+
+func reverse(s []string) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 type changingFileSystem struct {
@@ -290,26 +293,114 @@ func TestWalkFallback(t *testing.T) {
 		},
 	}
 
-	for _, tc := range tcs {
-		var walked []string
-		if tc.from == "" {
-			tc.from = "/"
+	for _, parallelism := range []int{0, 4} {
+		for _, tc := range tcs {
+			var walked []string
+			if tc.from == "" {
+				tc.from = "/"
+			}
+			options := append(tc.options, WithParallelism(parallelism))
+			t.Run(fmt.Sprintf("%s parallelism %d", tc.name, parallelism), func(t *testing.T) {
+				err := WalkFallback(context.Background(), d, tc.from, func(fileInfo FileInfo) error {
+					walked = append(walked, fileInfo.Path())
+					if fileInfo.IsDir() != d.isDir(fileInfo.Path()) {
+						t.Fatalf("fileInfo isDir not matching file system: expected %t actual %t", d.isDir(fileInfo.Path()), fileInfo.IsDir())
+					}
+					return tc.fn(fileInfo)
+				}, options...)
+				if tc.err && err == nil {
+					t.Fatalf("expected err")
+				}
+				if !tc.err && err != nil {
+					t.Fatalf(err.Error())
+				}
+				compareWalked(t, tc.expected, walked)
+			})
 		}
-		t.Run(tc.name, func(t *testing.T) {
-			err := WalkFallback(context.Background(), d, tc.from, func(fileInfo FileInfo) error {
-				walked = append(walked, fileInfo.Path())
-				if fileInfo.IsDir() != d.isDir(fileInfo.Path()) {
-					t.Fatalf("fileInfo isDir not matching file system: expected %t actual %t", d.isDir(fileInfo.Path()), fileInfo.IsDir())
+	}
+}
+
+// slowFileSystem is a fileSystem whose List takes some time, and which
+// records the largest number of concurrent List calls.
+type slowFileSystem struct {
+	fileSystem
+	latency time.Duration
+
+	mu             sync.Mutex
+	listing        int
+	maxConcurrency int
+}
+
+func (cfs *slowFileSystem) List(ctx context.Context, path string) ([]string, error) {
+	cfs.mu.Lock()
+	cfs.listing++
+	if cfs.listing > cfs.maxConcurrency {
+		cfs.maxConcurrency = cfs.listing
+	}
+	cfs.mu.Unlock()
+	defer func() {
+		cfs.mu.Lock()
+		cfs.listing--
+		cfs.mu.Unlock()
+	}()
+
+	time.Sleep(cfs.latency)
+	return cfs.fileSystem.List(ctx, path)
+}
+
+func (cfs *slowFileSystem) Stat(ctx context.Context, path string) (FileInfo, error) {
+	return cfs.fileSystem.Stat(ctx, path)
+}
+
+// newSlowFileSystem returns a slowFileSystem with dirs directories of files
+// files each.
+func newSlowFileSystem(dirs, files int, latency time.Duration) *slowFileSystem {
+	fileset := map[string][]string{"/": {}}
+	for i := 0; i < dirs; i++ {
+		dir := fmt.Sprintf("/dir%04d", i)
+		fileset["/"] = append(fileset["/"], dir)
+		for j := 0; j < files; j++ {
+			fileset[dir] = append(fileset[dir], fmt.Sprintf("%s/file%04d", dir, j))
+		}
+	}
+	return &slowFileSystem{fileSystem: fileSystem{fileset: fileset}, latency: latency}
+}
+
+func TestWalkFallbackParallelism(t *testing.T) {
+	d := newSlowFileSystem(20, 2, 10*time.Millisecond)
+
+	var walked []string
+	err := WalkFallback(context.Background(), d, "/", func(fileInfo FileInfo) error {
+		walked = append(walked, fileInfo.Path())
+		return nil
+	}, WithParallelism(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected []string
+	for i := 0; i < 20; i++ {
+		dir := fmt.Sprintf("/dir%04d", i)
+		expected = append(expected, dir, dir+"/file0000", dir+"/file0001")
+	}
+	compareWalked(t, expected, walked)
+	if d.maxConcurrency < 2 || d.maxConcurrency > 4 {
+		t.Fatalf("unexpected number of concurrent listings: %d", d.maxConcurrency)
+	}
+}
+
+func BenchmarkWalkFallback(b *testing.B) {
+	d := newSlowFileSystem(100, 10, time.Millisecond)
+	for _, parallelism := range []int{0, 8, 32} {
+		b.Run(fmt.Sprintf("parallelism %d", parallelism), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				err := WalkFallback(context.Background(), d, "/", func(fileInfo FileInfo) error {
+					return nil
+				}, WithParallelism(parallelism))
+				if err != nil {
+					b.Fatal(err)
 				}
-				return tc.fn(fileInfo)
-			}, tc.options...)
-			if tc.err && err == nil {
-				t.Fatalf("expected err")
 			}
-			if !tc.err && err != nil {
-				t.Fatalf(err.Error())
-			}
-			compareWalked(t, tc.expected, walked)
 		})
 	}
 }
@@ -324,3 +415,21 @@ func compareWalked(t *testing.T, expected, walked []string) {
 		}
 	}
 }
+
+func TestDirectoryDiff(t *testing.T) {
+	for _, tc := range []struct {
+		prev, current string
+		expected      []string
+	}{
+		{"/path/to/folder", "/path/to/folder/folder/file", []string{"/path/to/folder/folder"}},
+		{"/path/to/folder/folder1", "/path/to/folder/folder2/file", []string{"/path/to/folder/folder2"}},
+		{"/path/to/folder/folder1/file", "/path/to/folder/folder2/file", []string{"/path/to/folder/folder2"}},
+		{"/path/to/folder/folder1/file", "/path/to/folder/folder2/folder1/file", []string{"/path/to/folder/folder2", "/path/to/folder/folder2/folder1"}},
+		{"/", "/path/to/folder/folder/file", []string{"/path", "/path/to", "/path/to/folder", "/path/to/folder/folder"}},
+		{"/path/to/folder", "/path/to/folder/file", nil},
+	} {
+		if actual := DirectoryDiff(tc.prev, tc.current); !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("unexpected directories between %s and %s: %v != %v", tc.prev, tc.current, actual, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// Kinds of the problems found by Fsck.
+const (
+	// FsckMalformedTagLink is a tag whose current link does not contain a
+	// digest.
+	FsckMalformedTagLink = "malformed_tag_link"
+	// FsckDanglingTag is a tag linking to a manifest revision which does not
+	// exist.
+	FsckDanglingTag = "dangling_tag"
+	// FsckMalformedManifestLink is a manifest revision link which does not
+	// contain a digest.
+	FsckMalformedManifestLink = "malformed_manifest_link"
+	// FsckDanglingManifest is a manifest revision link to a blob which does
+	// not exist.
+	FsckDanglingManifest = "dangling_manifest"
+	// FsckInvalidManifest is a manifest revision which cannot be read.
+	FsckInvalidManifest = "invalid_manifest"
+	// FsckMissingBlob is a config or layer blob referenced by a manifest
+	// which does not exist.
+	FsckMissingBlob = "missing_blob"
+	// FsckMissingManifest is a manifest referenced by an index or manifest
+	// list which is not a revision of the repository.
+	FsckMissingManifest = "missing_manifest"
+	// FsckMalformedLayerLink is a layer link which does not contain a
+	// digest.
+	FsckMalformedLayerLink = "malformed_layer_link"
+)
+
+// FsckOpts contains options for Fsck.
+type FsckOpts struct {
+	// Repair removes the malformed and dangling tag and manifest links
+	// found. Blob data is never removed.
+	Repair bool
+}
+
+// FsckProblem is a broken reference found by Fsck.
+type FsckProblem struct {
+	Repository string `json:"repository"`
+	// Kind is one of the Fsck* problem kinds.
+	Kind     string        `json:"kind"`
+	Tag      string        `json:"tag,omitempty"`
+	Manifest digest.Digest `json:"manifest,omitempty"`
+	Blob     digest.Digest `json:"blob,omitempty"`
+	Detail   string        `json:"detail,omitempty"`
+	// Repaired is true if the broken link was removed.
+	Repaired bool `json:"repaired"`
+}
+
+func (p FsckProblem) String() string {
+	s := fmt.Sprintf("%s: %s", p.Repository, p.Kind)
+	if p.Tag != "" {
+		s += " tag=" + p.Tag
+	}
+	if p.Manifest != "" {
+		s += " manifest=" + p.Manifest.String()
+	}
+	if p.Blob != "" {
+		s += " blob=" + p.Blob.String()
+	}
+	if p.Detail != "" {
+		s += ": " + p.Detail
+	}
+	if p.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+// FsckReport is the result of Fsck.
+type FsckReport struct {
+	Repositories int           `json:"repositories"`
+	Tags         int           `json:"tags"`
+	Manifests    int           `json:"manifests"`
+	Problems     []FsckProblem `json:"problems"`
+}
+
+// Fsck verifies the repositories of namespace: that tags link to existing
+// manifest revisions, that the blobs referenced by manifests exist and that
+// link files contain well-formed digests. It returns the problems found and,
+// if opts.Repair is set, removes the broken tag and manifest links.
+func Fsck(ctx context.Context, namespace distribution.Namespace, opts FsckOpts) (FsckReport, error) {
+	report := FsckReport{Problems: []FsckProblem{}}
+	reg, ok := namespace.(*registry)
+	if !ok {
+		return report, fmt.Errorf("unable to check registry of type %T", namespace)
+	}
+
+	err := reg.Enumerate(ctx, func(repoName string) error {
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		r, err := reg.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+		report.Repositories++
+
+		f := &fsck{
+			ctx:    ctx,
+			repo:   r.(*repository),
+			opts:   opts,
+			report: &report,
+		}
+		f.manifestStatter = &linkedBlobStatter{
+			blobStore:  reg.blobStore,
+			repository: f.repo,
+			linkPath:   manifestRevisionLinkPath,
+		}
+		if err := f.checkManifests(); err != nil {
+			return fmt.Errorf("failed to check manifests of %s: %v", repoName, err)
+		}
+		if err := f.checkTags(); err != nil {
+			return fmt.Errorf("failed to check tags of %s: %v", repoName, err)
+		}
+		if err := f.checkLayerLinks(); err != nil {
+			return fmt.Errorf("failed to check layers of %s: %v", repoName, err)
+		}
+		return nil
+	})
+	return report, err
+}
+
+// fsck checks a repository.
+type fsck struct {
+	ctx             context.Context
+	repo            *repository
+	opts            FsckOpts
+	report          *FsckReport
+	manifestStatter *linkedBlobStatter
+}
+
+// problem records p, removing the broken link with repair if repairs are
+// enabled.
+func (f *fsck) problem(p FsckProblem, repair func() error) error {
+	p.Repository = f.repo.Named().Name()
+	if f.opts.Repair && repair != nil {
+		if err := repair(); err != nil {
+			return fmt.Errorf("failed to repair %s: %v", p, err)
+		}
+		p.Repaired = true
+	}
+	f.report.Problems = append(f.report.Problems, p)
+	return nil
+}
+
+// walkLinks calls fn with the digest of each link found below the directory
+// of spec, as given by the link path.
+func walkLinks(ctx context.Context, d driver.StorageDriver, spec pathSpec, fn func(dgst digest.Digest) error) error {
+	root, err := pathFor(spec)
+	if err != nil {
+		return err
+	}
+	var dgsts []digest.Digest
+	err = d.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" {
+			return nil
+		}
+		dgst, err := digestFromPath(path.Dir(fileInfo.Path()))
+		if err != nil {
+			// Not a link to a blob, such as the link of a tag.
+			return nil
+		}
+		dgsts = append(dgsts, dgst)
+		return nil
+	})
+	if errors.As(err, &driver.PathNotFoundError{}) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Links are checked, and possibly removed, once the walk is over.
+	for _, dgst := range dgsts {
+		if err := fn(dgst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fsck) checkManifests() error {
+	manifests, err := f.repo.Manifests(f.ctx)
+	if err != nil {
+		return err
+	}
+
+	return walkLinks(f.ctx, f.repo.driver, manifestRevisionsPathSpec{name: f.repo.pathName()}, func(dgst digest.Digest) error {
+		f.report.Manifests++
+		clear := func() error { return f.manifestStatter.Clear(f.ctx, dgst) }
+
+		_, err := f.manifestStatter.Stat(f.ctx, dgst)
+		switch {
+		case err == distribution.ErrBlobUnknown:
+			return f.problem(FsckProblem{Kind: FsckDanglingManifest, Manifest: dgst}, clear)
+		case err != nil && isMalformedLink(err):
+			return f.problem(FsckProblem{Kind: FsckMalformedManifestLink, Manifest: dgst, Detail: err.Error()}, clear)
+		case err != nil:
+			return err
+		}
+
+		m, err := manifests.Get(f.ctx, dgst)
+		if err != nil {
+			return f.problem(FsckProblem{Kind: FsckInvalidManifest, Manifest: dgst, Detail: err.Error()}, nil)
+		}
+		return f.checkReferences(dgst, m)
+	})
+}
+
+// checkReferences checks that the manifests referenced by indexes exist in
+// the repository, and that the blobs referenced by other manifests exist.
+func (f *fsck) checkReferences(dgst digest.Digest, m distribution.Manifest) error {
+	var isIndex bool
+	switch m.(type) {
+	case *ocischema.DeserializedImageIndex, *manifestlist.DeserializedManifestList:
+		isIndex = true
+	}
+
+	for _, ref := range m.References() {
+		if isIndex {
+			if _, err := f.manifestStatter.Stat(f.ctx, ref.Digest); err == distribution.ErrBlobUnknown || isMalformedLink(err) {
+				if err := f.problem(FsckProblem{Kind: FsckMissingManifest, Manifest: dgst, Blob: ref.Digest}, nil); err != nil {
+					return err
+				}
+			} else if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(ref.URLs) > 0 {
+			// Foreign layers are not expected to be stored.
+			continue
+		}
+		if _, err := f.repo.blobStore.statter.Stat(f.ctx, ref.Digest); err == distribution.ErrBlobUnknown {
+			if err := f.problem(FsckProblem{Kind: FsckMissingBlob, Manifest: dgst, Blob: ref.Digest}, nil); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fsck) checkTags() error {
+	tags := f.repo.Tags(f.ctx)
+	all, err := tags.All(f.ctx)
+	if errors.As(err, &distribution.ErrRepositoryUnknown{}) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range all {
+		f.report.Tags++
+		untag := func() error { return tags.Untag(f.ctx, tag) }
+
+		desc, err := tags.Get(f.ctx, tag)
+		switch {
+		case errors.As(err, &distribution.ErrTagUnknown{}):
+			err = f.problem(FsckProblem{Kind: FsckMalformedTagLink, Tag: tag, Detail: "missing current link"}, untag)
+		case err != nil && isMalformedLink(err):
+			err = f.problem(FsckProblem{Kind: FsckMalformedTagLink, Tag: tag, Detail: err.Error()}, untag)
+		case err == nil:
+			_, err = f.manifestStatter.Stat(f.ctx, desc.Digest)
+			if err == distribution.ErrBlobUnknown || isMalformedLink(err) {
+				err = f.problem(FsckProblem{Kind: FsckDanglingTag, Tag: tag, Manifest: desc.Digest}, untag)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLayerLinks checks that the layer links of the repository contain
+// well-formed digests. Broken layer links are only reported: they are
+// unreachable from manifests, and are removed along with the repository.
+func (f *fsck) checkLayerLinks() error {
+	statter := &linkedBlobStatter{
+		blobStore:  f.repo.blobStore,
+		repository: f.repo,
+		linkPath:   blobLinkPath,
+	}
+	return walkLinks(f.ctx, f.repo.driver, layersPathSpec{name: f.repo.pathName()}, func(dgst digest.Digest) error {
+		if _, err := statter.Stat(f.ctx, dgst); isMalformedLink(err) {
+			return f.problem(FsckProblem{Kind: FsckMalformedLayerLink, Blob: dgst, Detail: err.Error()}, nil)
+		}
+		return nil
+	})
+}
+
+// isMalformedLink reports whether err was returned reading a link which does
+// not contain a digest.
+func isMalformedLink(err error) bool {
+	return errors.Is(err, digest.ErrDigestInvalidFormat) ||
+		errors.Is(err, digest.ErrDigestInvalidLength) ||
+		errors.Is(err, digest.ErrDigestUnsupported)
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// pushTaggedImage pushes an image with one layer to the repository and tags
+// it. It returns the digests of the manifest and of the layer.
+func pushTaggedImage(ctx context.Context, t *testing.T, repo distribution.Repository, tag string) (digest.Digest, digest.Digest) {
+	layers, err := testutil.CreateRandomLayers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.UploadBlobs(repo, layers); err != nil {
+		t.Fatalf("failed to upload layers: %v", err)
+	}
+	var layer digest.Digest
+	for dgst := range layers {
+		layer = dgst
+	}
+	m, err := testutil.MakeSchema2Manifest(repo, []digest.Digest{layer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := manifests.Put(ctx, m)
+	if err != nil {
+		t.Fatalf("manifest upload failed: %v", err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, tag, distribution.Descriptor{Digest: dgst}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+	return dgst, layer
+}
+
+func TestFsck(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := NewRegistry(ctx, d, EnableDelete)
+	if err != nil {
+		t.Fatalf("failed to construct registry: %v", err)
+	}
+	named, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	healthy, _ := pushTaggedImage(ctx, t, repo, "healthy")
+	_, missingLayer := pushTaggedImage(ctx, t, repo, "missing-layer")
+	deletedManifest, _ := pushTaggedImage(ctx, t, repo, "deleted-manifest")
+	malformed, _ := pushTaggedImage(ctx, t, repo, "malformed")
+	index, err := ocischema.FromDescriptors([]distribution.Descriptor{
+		{MediaType: v1.MediaTypeImageManifest, Digest: healthy, Size: 1},
+		{MediaType: v1.MediaTypeImageManifest, Digest: deletedManifest, Size: 1},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDigest, err := manifests.Put(ctx, index)
+	if err != nil {
+		t.Fatalf("index upload failed: %v", err)
+	}
+
+	// A lifecycle rule removes a layer and a manifest blob, and link files
+	// are truncated.
+	deletePath := func(spec pathSpec) {
+		p, err := pathFor(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Delete(ctx, p); err != nil {
+			t.Fatalf("failed to delete %s: %v", p, err)
+		}
+	}
+	putContent := func(spec pathSpec, content string) {
+		p, err := pathFor(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.PutContent(ctx, p, []byte(content)); err != nil {
+			t.Fatalf("failed to write %s: %v", p, err)
+		}
+	}
+	deletePath(blobDataPathSpec{digest: missingLayer})
+	deletePath(blobDataPathSpec{digest: deletedManifest})
+	putContent(manifestRevisionLinkPathSpec{name: "foo/bar", revision: malformed}, "")
+	putContent(manifestTagCurrentPathSpec{name: "foo/bar", tag: "truncated"}, "sha256:")
+	putContent(layerLinkPathSpec{name: "foo/bar", digest: missingLayer}, "garbage")
+
+	expected := []FsckProblem{
+		{Kind: FsckDanglingManifest, Manifest: deletedManifest},
+		{Kind: FsckDanglingTag, Tag: "deleted-manifest", Manifest: deletedManifest},
+		{Kind: FsckDanglingTag, Tag: "malformed", Manifest: malformed},
+		{Kind: FsckMalformedLayerLink, Blob: missingLayer},
+		{Kind: FsckMalformedManifestLink, Manifest: malformed},
+		{Kind: FsckMalformedTagLink, Tag: "truncated"},
+		{Kind: FsckMissingBlob, Blob: missingLayer},
+		{Kind: FsckMissingManifest, Manifest: indexDigest, Blob: deletedManifest},
+	}
+	check := func(report FsckReport, expected []FsckProblem, repaired bool) {
+		t.Helper()
+		var got []FsckProblem
+		for _, p := range report.Problems {
+			if p.Repository != "foo/bar" || p.Repaired != (repaired && p.Kind != FsckMissingBlob && p.Kind != FsckMissingManifest && p.Kind != FsckMalformedLayerLink) {
+				t.Errorf("unexpected problem: %v", p)
+			}
+			// Details and the manifests referencing missing blobs are
+			// not compared.
+			p.Repository, p.Detail, p.Repaired = "", "", false
+			if p.Kind == FsckMissingBlob {
+				p.Manifest = ""
+			}
+			got = append(got, p)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i].String() < got[j].String() })
+		sort.Slice(expected, func(i, j int) bool { return expected[i].String() < expected[j].String() })
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("unexpected problems:\n%v\nexpected:\n%v", got, expected)
+		}
+	}
+
+	report, err := Fsck(ctx, registry, FsckOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error checking registry: %v", err)
+	}
+	if report.Repositories != 1 || report.Tags != 5 || report.Manifests != 5 {
+		t.Errorf("unexpected counts: %+v", report)
+	}
+	check(report, expected, false)
+
+	report, err = Fsck(ctx, registry, FsckOpts{Repair: true})
+	if err != nil {
+		t.Fatalf("unexpected error repairing registry: %v", err)
+	}
+	check(report, expected, true)
+
+	// Only the problems which are not repaired remain.
+	report, err = Fsck(ctx, registry, FsckOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error checking registry: %v", err)
+	}
+	check(report, []FsckProblem{
+		{Kind: FsckMalformedLayerLink, Blob: missingLayer},
+		{Kind: FsckMissingBlob, Blob: missingLayer},
+		{Kind: FsckMissingManifest, Manifest: indexDigest, Blob: deletedManifest},
+	}, false)
+	if report.Tags != 2 || report.Manifests != 3 {
+		t.Errorf("unexpected counts: %+v", report)
+	}
+
+	// Blob data is never removed.
+	if _, err := registry.BlobStatter().Stat(ctx, malformed); err != nil {
+		t.Fatalf("unexpected error stating manifest blob: %v", err)
+	}
+	if _, err := repo.Tags(ctx).Get(ctx, "healthy"); err != nil {
+		t.Fatalf("unexpected error getting healthy tag: %v", err)
+	}
+}
@@ -2,161 +2,475 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
+	"sync"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
+	"golang.org/x/sync/errgroup"
 )
 
-func emit(format string, a ...interface{}) {
-	fmt.Printf(format+"\n", a...)
-}
-
 // GCOpts contains options for garbage collector
 type GCOpts struct {
 	DryRun         bool
 	RemoveUntagged bool
+	// Repositories restricts the collection to the named repositories: only
+	// their manifests are removed, and only the blobs they reference that no
+	// other repository references are removed. All the repositories are
+	// collected if empty.
+	Repositories []string
+	// Workers is the number of repositories walked concurrently, 1 if not
+	// positive.
+	Workers int
+	// Output receives the progress of the collection, discarded if nil.
+	Output io.Writer
+	// Incremental removes the blobs marked unreferenced by the blob
+	// reference index, built with RebuildBlobReferenceIndex, instead of
+	// walking the repositories. It cannot remove untagged manifests or be
+	// restricted to some repositories.
+	Incremental bool
 }
 
 // ManifestDel contains manifest structure which will be deleted
 type ManifestDel struct {
-	Name   string
-	Digest digest.Digest
-	Tags   []string
+	Name   string        `json:"name"`
+	Digest digest.Digest `json:"digest"`
+	Tags   []string      `json:"tags,omitempty"`
+}
+
+// GCReport is the result of MarkAndSweep.
+type GCReport struct {
+	// Repositories is the number of repositories marked.
+	Repositories int `json:"repositories"`
+	// Marked is the number of manifests and blobs marked as in use.
+	Marked int `json:"marked"`
+	// Manifests and Blobs are eligible for deletion, and deleted unless
+	// DryRun is set.
+	Manifests []ManifestDel   `json:"manifests"`
+	Blobs     []digest.Digest `json:"blobs"`
+	DryRun    bool            `json:"dryrun"`
+}
+
+// garbageCollector holds the state of a mark and sweep, shared by the
+// workers walking the repositories.
+type garbageCollector struct {
+	registry distribution.Namespace
+	opts     GCOpts
+
+	mu          sync.Mutex
+	markSet     map[digest.Digest]struct{}
+	manifestArr []ManifestDel
+	// candidates are the blobs referenced by the collected repositories and
+	// referenced the blobs referenced by the others, both only tracked if
+	// opts.Repositories is set.
+	candidates map[digest.Digest]struct{}
+	referenced map[digest.Digest]struct{}
+}
+
+// emit writes a line of progress to opts.Output.
+func (gc *garbageCollector) emit(format string, a ...interface{}) {
+	if gc.opts.Output != nil {
+		fmt.Fprintf(gc.opts.Output, format+"\n", a...)
+	}
 }
 
-// MarkAndSweep performs a mark and sweep of registry data
-func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts GCOpts) error {
+// MarkAndSweep performs a mark and sweep of registry data. It returns the
+// manifests and blobs eligible for deletion, deleted unless opts.DryRun is
+// set.
+func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts GCOpts) (GCReport, error) {
+	report := GCReport{DryRun: opts.DryRun}
 	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
 	if !ok {
-		return fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+		return report, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
 	}
 
-	// mark
-	markSet := make(map[digest.Digest]struct{})
-	manifestArr := make([]ManifestDel, 0)
-	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
-		emit(repoName)
-
-		var err error
-		named, err := reference.WithName(repoName)
-		if err != nil {
-			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
-		}
-		repository, err := registry.Repository(ctx, named)
-		if err != nil {
-			return fmt.Errorf("failed to construct repository: %v", err)
-		}
-
-		manifestService, err := repository.Manifests(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to construct manifest service: %v", err)
+	gc := &garbageCollector{
+		registry: registry,
+		opts:     opts,
+		markSet:  make(map[digest.Digest]struct{}),
+	}
+	if opts.Incremental {
+		if opts.RemoveUntagged || len(opts.Repositories) > 0 {
+			return report, fmt.Errorf("incremental collection cannot remove untagged manifests or be restricted to repositories")
 		}
+		return gc.sweepUnreferenced(ctx, storageDriver)
+	}
 
-		manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
-		if !ok {
-			return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	// Collecting some repositories only requires a reference count pass over
+	// the others, so that the blobs they share are kept.
+	scoped := len(opts.Repositories) > 0
+	var repositories, others []string
+	if scoped {
+		inScope := make(map[string]struct{}, len(opts.Repositories))
+		for _, name := range opts.Repositories {
+			if _, err := reference.WithName(name); err != nil {
+				return report, fmt.Errorf("failed to parse repo name %s: %v", name, err)
+			}
+			if _, ok := inScope[name]; !ok {
+				inScope[name] = struct{}{}
+				repositories = append(repositories, name)
+			}
 		}
-
-		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
-			if opts.RemoveUntagged {
-				// fetch all tags where this manifest is the latest one
-				tags, err := repository.Tags(ctx).Lookup(ctx, distribution.Descriptor{Digest: dgst})
-				if err != nil {
-					return fmt.Errorf("failed to retrieve tags for digest %v: %v", dgst, err)
-				}
-				if len(tags) == 0 {
-					// fetch all tags from repository
-					// all of these tags could contain manifest in history
-					// which means that we need check (and delete) those references when deleting manifest
-					allTags, err := repository.Tags(ctx).All(ctx)
-					if err != nil {
-						if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
-							emit("manifest tags path of repository %s does not exist", repoName)
-							return nil
-						}
-						return fmt.Errorf("failed to retrieve tags %v", err)
-					}
-					manifestArr = append(manifestArr, ManifestDel{Name: repoName, Digest: dgst, Tags: allTags})
-					return nil
-				}
+		err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+			if _, ok := inScope[repoName]; !ok {
+				others = append(others, repoName)
 			}
-			// Mark the manifest's blob
-			emit("%s: marking manifest %s ", repoName, dgst)
-			markSet[dgst] = struct{}{}
-
-			return markManifestReferences(dgst, manifestService, ctx, func(d digest.Digest) bool {
-				_, marked := markSet[d]
-				if !marked {
-					markSet[d] = struct{}{}
-					emit("%s: marking blob %s", repoName, d)
-				}
-				return marked
-			})
+			return nil
 		})
-
-		// In certain situations such as unfinished uploads, deleting all
-		// tags in S3 or removing the _manifests folder manually, this
-		// error may be of type PathNotFound.
-		//
-		// In these cases we can continue marking other manifests safely.
-		if _, ok := err.(driver.PathNotFoundError); ok {
+		if err != nil {
+			return report, fmt.Errorf("failed to enumerate repositories: %v", err)
+		}
+		gc.candidates = make(map[digest.Digest]struct{})
+		gc.referenced = make(map[digest.Digest]struct{})
+	} else {
+		err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+			repositories = append(repositories, repoName)
 			return nil
+		})
+		if err != nil {
+			return report, fmt.Errorf("failed to enumerate repositories: %v", err)
 		}
+	}
 
-		return err
-	})
+	// mark
+	err := gc.forEach(ctx, repositories, gc.markRepository)
 	if err != nil {
-		return fmt.Errorf("failed to mark: %v", err)
+		return report, fmt.Errorf("failed to mark: %v", err)
+	}
+	if scoped {
+		err = gc.forEach(ctx, repositories, gc.collectReferences(gc.candidates))
+		if err == nil {
+			err = gc.forEach(ctx, others, gc.collectReferences(gc.referenced))
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to count references: %v", err)
+		}
 	}
+	report.Repositories = len(repositories)
+	report.Marked = len(gc.markSet)
 
-	manifestArr = unmarkReferencedManifest(manifestArr, markSet)
+	manifestArr := gc.unmarkReferencedManifest()
+	sort.Slice(manifestArr, func(i, j int) bool {
+		if manifestArr[i].Name != manifestArr[j].Name {
+			return manifestArr[i].Name < manifestArr[j].Name
+		}
+		return manifestArr[i].Digest < manifestArr[j].Digest
+	})
+	report.Manifests = manifestArr
 
 	// sweep
 	vacuum := NewVacuum(ctx, storageDriver)
-	if !opts.DryRun {
+	if !opts.DryRun && len(manifestArr) > 0 {
+		// The manifests are removed around the blob reference index, which
+		// needs a rebuild to be used again.
+		idx := &blobRefIndex{driver: storageDriver}
+		if idx.built(ctx) == nil {
+			if err := idx.invalidate(ctx); err != nil {
+				return report, fmt.Errorf("failed to invalidate blob reference index: %v", err)
+			}
+			gc.emit("blob reference index invalidated, rebuild it to collect incrementally")
+		}
 		for _, obj := range manifestArr {
 			err = vacuum.RemoveManifest(obj.Name, obj.Digest, obj.Tags)
 			if err != nil {
-				return fmt.Errorf("failed to delete manifest %s: %v", obj.Digest, err)
+				return report, fmt.Errorf("failed to delete manifest %s: %v", obj.Digest, err)
 			}
 		}
 	}
-	blobService := registry.Blobs()
 	deleteSet := make(map[digest.Digest]struct{})
-	err = blobService.Enumerate(ctx, func(dgst digest.Digest) error {
-		// check if digest is in markSet. If not, delete it!
-		if _, ok := markSet[dgst]; !ok {
+	if scoped {
+		for dgst := range gc.candidates {
+			_, marked := gc.markSet[dgst]
+			_, referenced := gc.referenced[dgst]
+			if marked || referenced {
+				continue
+			}
+			// The links of a repository may outlive the blobs they point to.
+			if _, err := registry.BlobStatter().Stat(ctx, dgst); err != nil {
+				if err == distribution.ErrBlobUnknown {
+					continue
+				}
+				return report, fmt.Errorf("failed to stat blob %s: %v", dgst, err)
+			}
 			deleteSet[dgst] = struct{}{}
 		}
-		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("error enumerating blobs: %v", err)
+	} else {
+		blobService := registry.Blobs()
+		err = blobService.Enumerate(ctx, func(dgst digest.Digest) error {
+			// check if digest is in markSet. If not, delete it!
+			if _, ok := gc.markSet[dgst]; !ok {
+				deleteSet[dgst] = struct{}{}
+			}
+			return nil
+		})
+		if err != nil {
+			return report, fmt.Errorf("error enumerating blobs: %v", err)
+		}
 	}
-	emit("\n%d blobs marked, %d blobs and %d manifests eligible for deletion", len(markSet), len(deleteSet), len(manifestArr))
 	for dgst := range deleteSet {
-		emit("blob eligible for deletion: %s", dgst)
+		report.Blobs = append(report.Blobs, dgst)
+	}
+	sort.Slice(report.Blobs, func(i, j int) bool { return report.Blobs[i] < report.Blobs[j] })
+
+	gc.emit("\n%d blobs marked, %d blobs and %d manifests eligible for deletion", len(gc.markSet), len(deleteSet), len(manifestArr))
+	for _, dgst := range report.Blobs {
+		gc.emit("blob eligible for deletion: %s", dgst)
 		if opts.DryRun {
 			continue
 		}
 		err = vacuum.RemoveBlob(string(dgst))
 		if err != nil {
-			return fmt.Errorf("failed to delete blob %s: %v", dgst, err)
+			return report, fmt.Errorf("failed to delete blob %s: %v", dgst, err)
 		}
 	}
 
+	return report, nil
+}
+
+// sweepUnreferenced removes the blobs marked unreferenced by the blob
+// reference index. Each blob is removed under the lock of the index, so that
+// the registry may keep serving writes: a blob referenced again since it was
+// marked is kept.
+func (gc *garbageCollector) sweepUnreferenced(ctx context.Context, storageDriver driver.StorageDriver) (GCReport, error) {
+	report := GCReport{DryRun: gc.opts.DryRun}
+	locks := storageLocks(storageDriver)
+	if reg, ok := gc.registry.(*registry); ok {
+		locks = reg.locks
+	}
+	idx := &blobRefIndex{driver: storageDriver, lock: newSharedLock(locks(refIndexLockName))}
+	if err := idx.built(ctx); err != nil {
+		return report, err
+	}
+	if err := idx.recover(ctx); err != nil {
+		return report, fmt.Errorf("failed to recover blob reference index: %v", err)
+	}
+
+	markersPath, err := pathFor(refUnreferencedRootPathSpec{})
+	if err != nil {
+		return report, err
+	}
+	var unreferenced []digest.Digest
+	err = storageDriver.Walk(ctx, markersPath, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+		p, err := storageDriver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		dgst, err := digest.Parse(string(p))
+		if err != nil {
+			return fmt.Errorf("invalid unreferenced blob marker %s: %v", fileInfo.Path(), err)
+		}
+		unreferenced = append(unreferenced, dgst)
+		return nil
+	})
+	if err != nil && !errors.As(err, &driver.PathNotFoundError{}) {
+		return report, fmt.Errorf("failed to read blob reference index: %v", err)
+	}
+
+	vacuum := NewVacuum(ctx, storageDriver)
+	for _, dgst := range unreferenced {
+		if err := gc.sweepUnreferencedBlob(ctx, idx, vacuum, dgst, &report); err != nil {
+			return report, err
+		}
+	}
+	sort.Slice(report.Blobs, func(i, j int) bool { return report.Blobs[i] < report.Blobs[j] })
+	gc.emit("\n%d blobs eligible for deletion", len(report.Blobs))
+	return report, nil
+}
+
+// sweepUnreferencedBlob removes the blob dgst if it is still unreferenced,
+// holding the lock of the index so that no manifest references it meanwhile.
+func (gc *garbageCollector) sweepUnreferencedBlob(ctx context.Context, idx *blobRefIndex, vacuum Vacuum, dgst digest.Digest, report *GCReport) error {
+	release, err := idx.lock.acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to lock blob reference index: %v", err)
+	}
+	defer release()
+
+	// The marker is stale if the blob was referenced again since.
+	count, _, err := idx.count(ctx, dgst)
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return nil
+	}
+	report.Blobs = append(report.Blobs, dgst)
+	gc.emit("blob eligible for deletion: %s", dgst)
+	if gc.opts.DryRun {
+		return nil
+	}
+	err = vacuum.RemoveBlob(string(dgst))
+	if err != nil && !errors.As(err, &driver.PathNotFoundError{}) {
+		return fmt.Errorf("failed to delete blob %s: %v", dgst, err)
+	}
+	if err := idx.forget(ctx, dgst); err != nil {
+		return fmt.Errorf("failed to remove blob %s from the blob reference index: %v", dgst, err)
+	}
+	return nil
+}
+
+// forEach calls fn with each of the repositories, with up to opts.Workers
+// calls running concurrently.
+func (gc *garbageCollector) forEach(ctx context.Context, repositories []string, fn func(context.Context, string) error) error {
+	workers := gc.opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(workers)
+	for _, repoName := range repositories {
+		repoName := repoName
+		g.Go(func() error {
+			return fn(ctx, repoName)
+		})
+	}
+	return g.Wait()
+}
+
+// repository returns the repository named repoName and its manifest
+// enumerator.
+func (gc *garbageCollector) repository(ctx context.Context, repoName string) (distribution.Repository, distribution.ManifestService, distribution.ManifestEnumerator, error) {
+	named, err := reference.WithName(repoName)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+	}
+	repository, err := gc.registry.Repository(ctx, named)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to construct repository: %v", err)
+	}
+
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+	return repository, manifestService, manifestEnumerator, nil
+}
+
+// markRepository marks the manifests of the repository named repoName, and
+// the blobs they reference. The untagged manifests are set aside for
+// deletion instead if opts.RemoveUntagged is set.
+func (gc *garbageCollector) markRepository(ctx context.Context, repoName string) error {
+	gc.emit(repoName)
+
+	repository, manifestService, manifestEnumerator, err := gc.repository(ctx, repoName)
+	if err != nil {
+		return err
+	}
+
+	err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		if gc.opts.RemoveUntagged {
+			// fetch all tags where this manifest is the latest one
+			tags, err := repository.Tags(ctx).Lookup(ctx, distribution.Descriptor{Digest: dgst})
+			if err != nil {
+				return fmt.Errorf("failed to retrieve tags for digest %v: %v", dgst, err)
+			}
+			if len(tags) == 0 {
+				// fetch all tags from repository
+				// all of these tags could contain manifest in history
+				// which means that we need check (and delete) those references when deleting manifest
+				allTags, err := repository.Tags(ctx).All(ctx)
+				if err != nil {
+					if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+						gc.emit("manifest tags path of repository %s does not exist", repoName)
+						return nil
+					}
+					return fmt.Errorf("failed to retrieve tags %v", err)
+				}
+				gc.mu.Lock()
+				gc.manifestArr = append(gc.manifestArr, ManifestDel{Name: repoName, Digest: dgst, Tags: allTags})
+				gc.mu.Unlock()
+				return nil
+			}
+		}
+		// Mark the manifest's blob
+		gc.emit("%s: marking manifest %s ", repoName, dgst)
+		gc.mu.Lock()
+		gc.markSet[dgst] = struct{}{}
+		gc.mu.Unlock()
+
+		return markManifestReferences(dgst, manifestService, ctx, func(d digest.Digest) bool {
+			gc.mu.Lock()
+			defer gc.mu.Unlock()
+			_, marked := gc.markSet[d]
+			if !marked {
+				gc.markSet[d] = struct{}{}
+				gc.emit("%s: marking blob %s", repoName, d)
+			}
+			return marked
+		})
+	})
+
+	// In certain situations such as unfinished uploads, deleting all
+	// tags in S3 or removing the _manifests folder manually, this
+	// error may be of type PathNotFound.
+	//
+	// In these cases we can continue marking other manifests safely.
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
+
 	return err
 }
 
+// collectReferences returns the function adding to set the blobs referenced
+// by a repository, tagged or not: its layer links, its manifests and the
+// blobs they reference.
+func (gc *garbageCollector) collectReferences(set map[digest.Digest]struct{}) func(context.Context, string) error {
+	return func(ctx context.Context, repoName string) error {
+		repository, manifestService, manifestEnumerator, err := gc.repository(ctx, repoName)
+		if err != nil {
+			return err
+		}
+		add := func(d digest.Digest) bool {
+			gc.mu.Lock()
+			defer gc.mu.Unlock()
+			_, ok := set[d]
+			set[d] = struct{}{}
+			return ok
+		}
+
+		if blobEnumerator, ok := repository.Blobs(ctx).(distribution.BlobEnumerator); ok {
+			err := blobEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+				add(dgst)
+				return nil
+			})
+			if _, ok := err.(driver.PathNotFoundError); !ok && err != nil {
+				return fmt.Errorf("failed to enumerate layers of %s: %v", repoName, err)
+			}
+		}
+
+		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+			if add(dgst) {
+				return nil
+			}
+			return markManifestReferences(dgst, manifestService, ctx, add)
+		})
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+}
+
 // unmarkReferencedManifest filters out manifest present in markSet
-func unmarkReferencedManifest(manifestArr []ManifestDel, markSet map[digest.Digest]struct{}) []ManifestDel {
+func (gc *garbageCollector) unmarkReferencedManifest() []ManifestDel {
 	filtered := make([]ManifestDel, 0)
-	for _, obj := range manifestArr {
-		if _, ok := markSet[obj.Digest]; !ok {
-			emit("manifest eligible for deletion: %s", obj)
+	for _, obj := range gc.manifestArr {
+		if _, ok := gc.markSet[obj.Digest]; !ok {
+			gc.emit("manifest eligible for deletion: %s", obj)
 			filtered = append(filtered, obj)
 		}
 	}
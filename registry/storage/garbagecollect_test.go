@@ -182,7 +182,7 @@ func TestNoDeletionNoEffect(t *testing.T) {
 	before := allBlobs(t, registry)
 
 	// Run GC
-	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+	_, err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
 		DryRun:         false,
 		RemoveUntagged: false,
 	})
@@ -259,7 +259,7 @@ func TestDeleteManifestIfTagNotFound(t *testing.T) {
 	before2 := allManifests(t, manifestService)
 
 	// run GC with dry-run (should not remove anything)
-	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+	_, err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
 		DryRun:         true,
 		RemoveUntagged: true,
 	})
@@ -276,7 +276,7 @@ func TestDeleteManifestIfTagNotFound(t *testing.T) {
 	}
 
 	// Run GC (removes everything because no manifests with tags exist)
-	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+	_, err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
 		DryRun:         false,
 		RemoveUntagged: true,
 	})
@@ -329,7 +329,7 @@ func TestDeleteManifestIndexWithDanglingReferences(t *testing.T) {
 	before2 := allManifests(t, manifestService)
 
 	// run GC (should not remove anything because of tag)
-	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+	_, err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
 		DryRun:         false,
 		RemoveUntagged: true,
 	})
@@ -376,7 +376,7 @@ func TestDeleteManifestIndexIfTagNotFound(t *testing.T) {
 	before2 := allManifests(t, manifestService)
 
 	// run GC (should not remove anything because of tag)
-	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+	_, err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
 		DryRun:         false,
 		RemoveUntagged: true,
 	})
@@ -398,7 +398,7 @@ func TestDeleteManifestIndexIfTagNotFound(t *testing.T) {
 	}
 
 	// Run GC (removes everything because no manifests with tags exist)
-	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+	_, err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
 		DryRun:         false,
 		RemoveUntagged: true,
 	})
@@ -440,7 +440,7 @@ func TestGCWithUnknownRepository(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = MarkAndSweep(dcontext.Background(), d, registry, GCOpts{
+	_, err = MarkAndSweep(dcontext.Background(), d, registry, GCOpts{
 		DryRun:         false,
 		RemoveUntagged: true,
 	})
@@ -469,7 +469,7 @@ func TestGCWithMissingManifests(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = MarkAndSweep(dcontext.Background(), d, registry, GCOpts{
+	_, err = MarkAndSweep(dcontext.Background(), d, registry, GCOpts{
 		DryRun:         false,
 		RemoveUntagged: false,
 	})
@@ -504,7 +504,7 @@ func TestDeletionHasEffect(t *testing.T) {
 	}
 
 	// Run GC
-	err := MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+	_, err := MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
 		DryRun:         false,
 		RemoveUntagged: false,
 	})
@@ -641,7 +641,7 @@ func TestOrphanBlobDeleted(t *testing.T) {
 	uploadRandomSchema2Image(t, repo)
 
 	// Run GC
-	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+	_, err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
 		DryRun:         false,
 		RemoveUntagged: false,
 	})
@@ -695,7 +695,7 @@ func TestTaggedManifestlistWithUntaggedManifest(t *testing.T) {
 	before := allBlobs(t, registry)
 
 	// Run GC
-	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+	_, err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
 		DryRun:         false,
 		RemoveUntagged: true,
 	})
@@ -750,7 +750,7 @@ func TestUnTaggedManifestlistWithUntaggedManifest(t *testing.T) {
 	}
 
 	// Run GC
-	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+	_, err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
 		DryRun:         false,
 		RemoveUntagged: true,
 	})
@@ -804,7 +804,7 @@ func TestUnTaggedManifestlistWithTaggedManifest(t *testing.T) {
 	}
 
 	// Run GC
-	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+	_, err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
 		DryRun:         false,
 		RemoveUntagged: true,
 	})
@@ -867,7 +867,7 @@ func TestTaggedManifestlistWithDeletedReference(t *testing.T) {
 	}
 
 	// Run GC
-	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+	_, err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
 		DryRun:         false,
 		RemoveUntagged: true,
 	})
@@ -880,3 +880,80 @@ func TestTaggedManifestlistWithDeletedReference(t *testing.T) {
 		t.Fatalf("Garbage collection affected storage: %d != %d", len(after), 0)
 	}
 }
+
+func TestScopedGC(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	scoped := makeRepository(t, registry, "scoped")
+	other := makeRepository(t, registry, "other")
+
+	// Both repositories hold an untagged image, and share another one.
+	scopedImage := uploadRandomSchema2Image(t, scoped)
+	taggedImage := uploadRandomSchema2Image(t, scoped)
+	err := scoped.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: taggedImage.manifestDigest})
+	if err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+	otherImage := uploadRandomSchema2Image(t, other)
+	sharedLayers, err := testutil.CreateRandomLayers(2)
+	if err != nil {
+		t.Fatalf("failed to make layers: %v", err)
+	}
+	for _, repo := range []distribution.Repository{scoped, other} {
+		for _, layer := range sharedLayers {
+			if _, err := layer.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+		}
+		manifest, err := testutil.MakeSchema2Manifest(repo, getKeys(sharedLayers))
+		if err != nil {
+			t.Fatalf("failed to make manifest: %v", err)
+		}
+		uploadImage(t, repo, image{manifest: manifest, layers: sharedLayers})
+	}
+
+	report, err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		RemoveUntagged: true,
+		Repositories:   []string{"scoped"},
+		Workers:        2,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	if report.Repositories != 1 || len(report.Manifests) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	for _, m := range report.Manifests {
+		if m.Name != "scoped" {
+			t.Fatalf("manifest of %s removed", m.Name)
+		}
+	}
+	if len(allManifests(t, makeManifestService(t, scoped))) != 1 {
+		t.Fatal("untagged manifests of the collected repository not removed")
+	}
+	if len(allManifests(t, makeManifestService(t, other))) != 2 {
+		t.Fatal("manifests of the other repository removed")
+	}
+
+	blobs := allBlobs(t, registry)
+	removed := make(map[digest.Digest]struct{})
+	for _, dgst := range report.Blobs {
+		removed[dgst] = struct{}{}
+	}
+	for _, dgst := range append(getKeys(scopedImage.layers), scopedImage.manifestDigest) {
+		if _, ok := removed[dgst]; !ok {
+			t.Errorf("blob %s of the collected repository not reported", dgst)
+		}
+		if _, ok := blobs[dgst]; ok {
+			t.Errorf("blob %s of the collected repository not removed", dgst)
+		}
+	}
+	for _, dgst := range append(append(getKeys(sharedLayers), getKeys(otherImage.layers)...), otherImage.manifestDigest, taggedImage.manifestDigest) {
+		if _, ok := blobs[dgst]; !ok {
+			t.Errorf("blob %s still referenced removed", dgst)
+		}
+	}
+}
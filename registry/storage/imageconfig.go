@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ValidateImageConfigs is a functional option for NewRegistry. It causes
+// image manifests, OCI or Docker manifests with an image config, to be
+// rejected if their config does not describe their layers, as checked by
+// manifest.ValidateImageConfig.
+func ValidateImageConfigs(registry *registry) error {
+	registry.validateImageConfigs = true
+	return nil
+}
+
+// verifyImageConfig returns an ErrManifestImageConfigInvalid error if mfst is
+// an image manifest whose config does not describe its layers. A config
+// missing from the repository is left to the verification of the manifest
+// references.
+func (ms *manifestStore) verifyImageConfig(ctx context.Context, mfst distribution.Manifest) error {
+	if !ms.repository.validateImageConfigs {
+		return nil
+	}
+
+	var (
+		config distribution.Descriptor
+		layers []distribution.Descriptor
+	)
+	switch m := mfst.(type) {
+	case *ocischema.DeserializedManifest:
+		if m.Config.MediaType != v1.MediaTypeImageConfig {
+			return nil
+		}
+		config, layers = m.Config, m.Layers
+	case *schema2.DeserializedManifest:
+		if m.Config.MediaType != schema2.MediaTypeImageConfig {
+			return nil
+		}
+		config, layers = m.Config, m.Layers
+	default:
+		return nil
+	}
+
+	configJSON, err := ms.repository.Blobs(ctx).Get(ctx, config.Digest)
+	if err != nil {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			return nil
+		}
+		return err
+	}
+	if err := manifest.ValidateImageConfig(configJSON, layers); err != nil {
+		return distribution.ErrManifestVerification{distribution.ErrManifestImageConfigInvalid{Reason: err}}
+	}
+	return nil
+}
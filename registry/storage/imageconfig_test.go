@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestValidateImageConfigs(t *testing.T) {
+	ctx := context.Background()
+	registry, err := NewRegistry(ctx, inmemory.New(), ValidateImageConfigs)
+	if err != nil {
+		t.Fatalf("unexpected error creating registry: %v", err)
+	}
+	named, _ := reference.WithName("images/nginx")
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx, SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imageManifest := func(configMediaType string, config []byte, layers int) distribution.Manifest {
+		desc, err := repo.Blobs(ctx).Put(ctx, configMediaType, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc.MediaType = configMediaType
+		m := ocischema.Manifest{
+			Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+			Config:    desc,
+		}
+		for i := 0; i < layers; i++ {
+			m.Layers = append(m.Layers, distribution.Descriptor{MediaType: v1.MediaTypeImageLayerGzip, Digest: digest.FromString(string(rune('a' + i))), Size: 1})
+		}
+		dm, err := ocischema.FromStruct(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dm
+	}
+	config := []byte(`{"rootfs":{"type":"layers","diff_ids":["sha256:c6f988f4874bb0add23a778f753c65efe992244e148a1d2ec2a8b664fb66bbd1","sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef"]}}`)
+
+	for _, tc := range []struct {
+		name     string
+		manifest distribution.Manifest
+		rejected bool
+	}{
+		{name: "valid", manifest: imageManifest(v1.MediaTypeImageConfig, config, 2)},
+		{name: "missing layer", manifest: imageManifest(v1.MediaTypeImageConfig, config, 1), rejected: true},
+		{name: "malformed", manifest: imageManifest(v1.MediaTypeImageConfig, []byte("{"), 0), rejected: true},
+		// The configs of artifacts are not checked.
+		{name: "artifact", manifest: imageManifest(helmConfigMediaType, []byte(`{"name":"chart"}`), 1)},
+	} {
+		_, err := manifests.Put(ctx, tc.manifest)
+		if !tc.rejected {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		var errs distribution.ErrManifestVerification
+		if !errors.As(err, &errs) || len(errs) != 1 {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if _, ok := errs[0].(distribution.ErrManifestImageConfigInvalid); !ok {
+			t.Errorf("%s: unexpected error: %v", tc.name, errs[0])
+		}
+	}
+}
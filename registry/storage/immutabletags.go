@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+)
+
+// ImmutableTagRule makes tags immutable in the repositories matching the
+// Repository glob, matched as in NamePolicy including the repositories nested
+// below a matching name. The tags whose name matches one of the Tags globs, as
+// understood by path.Match, can be created but never changed to reference
+// another manifest.
+type ImmutableTagRule struct {
+	Repository string
+	Tags       []string
+}
+
+// ImmutableTags is a functional option for NewRegistry. It causes the tags
+// matching a rule of their repository to be immutable: tagging another
+// manifest with them fails with an ErrTagImmutable error, while tagging the
+// manifest they reference again succeeds.
+func ImmutableTags(rules []ImmutableTagRule) RegistryOption {
+	return func(registry *registry) error {
+		for _, rule := range rules {
+			if _, err := NewNamePolicy(0, []string{rule.Repository}, nil); err != nil {
+				return err
+			}
+			for _, pattern := range rule.Tags {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("invalid immutable tag pattern %q: %v", pattern, err)
+				}
+			}
+		}
+		registry.immutableTags = rules
+		return nil
+	}
+}
+
+// immutableTag reports whether tag is immutable in the repository.
+func (repo *repository) immutableTag(tag string) bool {
+	name := repo.Named().Name()
+	for _, rule := range repo.immutableTags {
+		if _, ok := matchName([]string{rule.Repository}, name); !ok {
+			continue
+		}
+		for _, pattern := range rule.Tags {
+			if ok, _ := path.Match(pattern, tag); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
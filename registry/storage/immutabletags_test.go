@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestImmutableTags(t *testing.T) {
+	ctx := context.Background()
+	reg, err := NewRegistry(ctx, inmemory.New(), ImmutableTags([]ImmutableTagRule{
+		{Repository: "prod/*", Tags: []string{"v*", "release-*"}},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := func(name string) distribution.TagService {
+		named, err := reference.WithName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := reg.Repository(ctx, named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo.Tags(ctx)
+	}
+
+	d1 := distribution.Descriptor{Digest: "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}
+	d2 := distribution.Descriptor{Digest: "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"}
+
+	prod := tags("prod/app")
+	if err := prod.Tag(ctx, "v1", d1); err != nil {
+		t.Fatalf("unexpected error creating immutable tag: %v", err)
+	}
+	if err := prod.Tag(ctx, "v1", d1); err != nil {
+		t.Fatalf("unexpected error tagging the same manifest again: %v", err)
+	}
+
+	err = prod.Tag(ctx, "v1", d2)
+	var immutable distribution.ErrTagImmutable
+	if !errors.As(err, &immutable) {
+		t.Fatalf("expected ErrTagImmutable, got %v", err)
+	}
+	if immutable.Tag != "v1" || immutable.Digest != d1.Digest {
+		t.Fatalf("unexpected error: %#v", immutable)
+	}
+	desc, err := prod.Get(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != d1.Digest {
+		t.Fatalf("immutable tag was changed to %s", desc.Digest)
+	}
+
+	// Tags not matching a pattern, and tags in other repositories, stay
+	// mutable.
+	for _, tc := range []struct {
+		ts  distribution.TagService
+		tag string
+	}{
+		{prod, "latest"},
+		{tags("dev/app"), "v1"},
+	} {
+		if err := tc.ts.Tag(ctx, tc.tag, d1); err != nil {
+			t.Fatal(err)
+		}
+		if err := tc.ts.Tag(ctx, tc.tag, d2); err != nil {
+			t.Fatalf("unexpected error changing mutable tag %s: %v", tc.tag, err)
+		}
+	}
+
+	// Deleting an immutable tag is still allowed.
+	if err := prod.Untag(ctx, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := prod.Tag(ctx, "v1", d2); err != nil {
+		t.Fatalf("unexpected error creating deleted tag: %v", err)
+	}
+}
+
+func TestImmutableTagsConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	reg, err := NewRegistry(ctx, inmemory.New(), ImmutableTags([]ImmutableTagRule{
+		{Repository: "prod/app", Tags: []string{"*"}},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, _ := reference.WithName("prod/app")
+	repo, err := reg.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := repo.Tags(ctx)
+
+	const writers = 10
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded []digest.Digest
+	)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			dgst := digest.FromString(fmt.Sprint(i))
+			err := ts.Tag(ctx, "v1", distribution.Descriptor{Digest: dgst})
+			if err == nil {
+				mu.Lock()
+				succeeded = append(succeeded, dgst)
+				mu.Unlock()
+			} else if !errors.As(err, &distribution.ErrTagImmutable{}) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(succeeded) != 1 {
+		t.Fatalf("expected exactly one write to succeed, got %d", len(succeeded))
+	}
+	desc, err := ts.Get(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != succeeded[0] {
+		t.Fatalf("tag references %s, expected %s", desc.Digest, succeeded[0])
+	}
+}
+
+func TestImmutableTagsInvalidPattern(t *testing.T) {
+	for _, rule := range []ImmutableTagRule{
+		{Repository: "prod/[", Tags: []string{"v*"}},
+		{Repository: "prod/*", Tags: []string{"v["}},
+	} {
+		if _, err := NewRegistry(context.Background(), inmemory.New(), ImmutableTags([]ImmutableTagRule{rule})); err == nil {
+			t.Errorf("expected an error for rule %+v", rule)
+		}
+	}
+}
@@ -28,7 +28,7 @@ type linkedBlobStore struct {
 	registry               *registry
 	blobServer             distribution.BlobServer
 	blobAccessController   distribution.BlobDescriptorService
-	repository             distribution.Repository
+	repository             *repository
 	ctx                    context.Context // only to be used where context can't come through method args
 	deleteEnabled          bool
 	resumableDigestEnabled bool
@@ -148,7 +148,7 @@ func (lbs *linkedBlobStore) Create(ctx context.Context, options ...distribution.
 	startedAt := time.Now().UTC()
 
 	path, err := pathFor(uploadDataPathSpec{
-		name: lbs.repository.Named().Name(),
+		name: lbs.repository.pathName(),
 		id:   uuid,
 	})
 	if err != nil {
@@ -156,7 +156,7 @@ func (lbs *linkedBlobStore) Create(ctx context.Context, options ...distribution.
 	}
 
 	startedAtPath, err := pathFor(uploadStartedAtPathSpec{
-		name: lbs.repository.Named().Name(),
+		name: lbs.repository.pathName(),
 		id:   uuid,
 	})
 	if err != nil {
@@ -175,7 +175,7 @@ func (lbs *linkedBlobStore) Resume(ctx context.Context, id string) (distribution
 	dcontext.GetLogger(ctx).Debug("(*linkedBlobStore).Resume")
 
 	startedAtPath, err := pathFor(uploadStartedAtPathSpec{
-		name: lbs.repository.Named().Name(),
+		name: lbs.repository.pathName(),
 		id:   id,
 	})
 	if err != nil {
@@ -198,7 +198,7 @@ func (lbs *linkedBlobStore) Resume(ctx context.Context, id string) (distribution
 	}
 
 	path, err := pathFor(uploadDataPathSpec{
-		name: lbs.repository.Named().Name(),
+		name: lbs.repository.pathName(),
 		id:   id,
 	})
 	if err != nil {
@@ -267,7 +267,7 @@ func (lbs *linkedBlobStore) Enumerate(ctx context.Context, ingestor func(digest.
 		}
 
 		return nil
-	})
+	}, driver.WithParallelism(lbs.walkParallelism))
 }
 
 func (lbs *linkedBlobStore) mount(ctx context.Context, sourceRepo reference.Named, dgst digest.Digest, sourceStat *distribution.Descriptor) (distribution.Descriptor, error) {
@@ -316,6 +316,7 @@ func (lbs *linkedBlobStore) newBlobUpload(ctx context.Context, uuid, path string
 		driver:                 lbs.driver,
 		path:                   path,
 		resumableDigestEnabled: lbs.resumableDigestEnabled,
+		uploadRedirect:         lbs.registry != nil && lbs.registry.uploadRedirect,
 	}
 
 	return bw, nil
@@ -339,7 +340,7 @@ func (lbs *linkedBlobStore) linkBlob(ctx context.Context, canonical distribution
 		}
 		seenDigests[dgst] = struct{}{}
 
-		blobLinkPath, err := lbs.linkPath(lbs.repository.Named().Name(), dgst)
+		blobLinkPath, err := lbs.linkPath(lbs.repository.pathName(), dgst)
 		if err != nil {
 			return err
 		}
@@ -354,19 +355,39 @@ func (lbs *linkedBlobStore) linkBlob(ctx context.Context, canonical distribution
 
 type linkedBlobStatter struct {
 	*blobStore
-	repository distribution.Repository
+	repository *repository
 
 	// linkPath allows one to control the repository blob link set to which
 	// the blob store dispatches. This is required because manifest and layer
 	// blobs have not yet been fully merged. At some point, this functionality
 	// should be removed an the blob links folder should be merged.
 	linkPath linkPathFunc
+
+	// softDelete moves the links cleared to the trash of the repository
+	// rather than removing them.
+	softDelete bool
 }
 
 var _ distribution.BlobDescriptorService = &linkedBlobStatter{}
 
+// linked reports whether the repository links dgst, whether or not the blob
+// it links to exists.
+func (lbs *linkedBlobStatter) linked(ctx context.Context, dgst digest.Digest) (bool, error) {
+	blobLinkPath, err := lbs.linkPath(lbs.repository.pathName(), dgst)
+	if err != nil {
+		return false, err
+	}
+	if _, err := lbs.blobStore.readlink(ctx, blobLinkPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok || isMalformedLink(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func (lbs *linkedBlobStatter) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
-	blobLinkPath, err := lbs.linkPath(lbs.repository.Named().Name(), dgst)
+	blobLinkPath, err := lbs.linkPath(lbs.repository.pathName(), dgst)
 	if err != nil {
 		return distribution.Descriptor{}, err
 	}
@@ -393,11 +414,14 @@ func (lbs *linkedBlobStatter) Stat(ctx context.Context, dgst digest.Digest) (dis
 }
 
 func (lbs *linkedBlobStatter) Clear(ctx context.Context, dgst digest.Digest) (err error) {
-	blobLinkPath, err := lbs.linkPath(lbs.repository.Named().Name(), dgst)
+	blobLinkPath, err := lbs.linkPath(lbs.repository.pathName(), dgst)
 	if err != nil {
 		return err
 	}
 
+	if lbs.softDelete {
+		return lbs.repository.trashLinks(ctx, blobLinkPath)
+	}
 	return lbs.blobStore.driver.Delete(ctx, blobLinkPath)
 }
 
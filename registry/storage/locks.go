@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/coordination"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/google/uuid"
+)
+
+const (
+	// sharedLockTTL is how long a shared lock is held if its holder stops
+	// without releasing it.
+	sharedLockTTL = 30 * time.Second
+
+	// sharedLockRetryInterval is how long an instance waits before trying
+	// again to take a shared lock held by another one.
+	sharedLockRetryInterval = 20 * time.Millisecond
+)
+
+// Locks is a functional option for NewRegistry. It sets the locks shared by
+// the registry instances using the same storage, which serialize their
+// read-modify-write updates such as those of the blob reference index. By
+// default, locks are files of the storage, under <root>/v2/locks.
+func Locks(locks func(name string) coordination.Lock) RegistryOption {
+	return func(registry *registry) error {
+		registry.locks = locks
+		return nil
+	}
+}
+
+// storageLocks returns the locks stored through driver, shared by the
+// registry instances using the same storage.
+func storageLocks(driver storagedriver.StorageDriver) func(name string) coordination.Lock {
+	return func(name string) coordination.Lock {
+		lockPath, err := pathFor(lockPathSpec{name: name})
+		if err != nil {
+			// The lock path spec has no component to validate.
+			panic(err)
+		}
+		return coordination.NewStorageLock(driver, lockPath)
+	}
+}
+
+// sharedLock is a mutual exclusion lock held by one goroutine of the
+// registry instances sharing lock at a time.
+type sharedLock struct {
+	lock  coordination.Lock
+	owner string
+
+	// mu serializes the goroutines of this instance, which share its owner
+	// of lock.
+	mu sync.Mutex
+}
+
+func newSharedLock(lock coordination.Lock) *sharedLock {
+	return &sharedLock{lock: lock, owner: uuid.NewString()}
+}
+
+// acquire waits until the lock is held, or ctx is done, and returns the
+// function releasing it.
+func (l *sharedLock) acquire(ctx context.Context) (func(), error) {
+	l.mu.Lock()
+	for {
+		held, err := l.lock.TryLock(ctx, l.owner, sharedLockTTL)
+		if err != nil {
+			l.mu.Unlock()
+			return nil, err
+		}
+		if held {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			l.mu.Unlock()
+			return nil, ctx.Err()
+		case <-time.After(sharedLockRetryInterval):
+		}
+	}
+
+	return func() {
+		defer l.mu.Unlock()
+		if err := l.lock.Unlock(context.WithoutCancel(ctx), l.owner); err != nil {
+			dcontext.GetLogger(ctx).Errorf("failed to release shared lock: %v", err)
+		}
+	}, nil
+}
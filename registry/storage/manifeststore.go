@@ -3,7 +3,10 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
+	"strings"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/internal/dcontext"
@@ -11,8 +14,10 @@ import (
 	"github.com/distribution/distribution/v3/manifest/manifestlist"
 	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/manifest/schema2"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/errgroup"
 )
 
 // A ManifestHandler gets and puts manifests of a particular type.
@@ -88,8 +93,96 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 		return nil, err
 	}
 
+	if ms.repository.verifyOnRead {
+		if err := verifyContent(ctx, dgst, content); err != nil {
+			return nil, err
+		}
+	}
+
+	return ms.unmarshal(ctx, dgst, content)
+}
+
+// Stat describes the manifest dgst without reading it, from the media type
+// recorded when it was put. Manifests put before media types were recorded
+// are read to find theirs.
+func (ms *manifestStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Stat")
+
+	desc, err := ms.blobStore.Stat(ctx, dgst)
+	if err != nil {
+		if err == distribution.ErrBlobUnknown {
+			return distribution.Descriptor{}, distribution.ErrManifestUnknownRevision{
+				Name:     ms.repository.Named().Name(),
+				Revision: dgst,
+			}
+		}
+
+		return distribution.Descriptor{}, err
+	}
+
+	mediaType, err := ms.readMediaType(ctx, dgst)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	if mediaType != "" {
+		return distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: desc.Size}, nil
+	}
+
+	manifest, err := ms.Get(ctx, dgst)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}, nil
+}
+
+// readMediaType returns the media type recorded for the manifest dgst, or an
+// empty string if none is, or the recorded one is invalid.
+func (ms *manifestStore) readMediaType(ctx context.Context, dgst digest.Digest) (string, error) {
+	mediaTypePath, err := pathFor(manifestRevisionMediaTypePathSpec{name: ms.repository.pathName(), revision: dgst})
+	if err != nil {
+		return "", err
+	}
+	content, err := ms.blobStore.blobStore.driver.GetContent(ctx, mediaTypePath)
+	if err != nil {
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	mediaType := strings.TrimSpace(string(content))
+	if _, _, err := mime.ParseMediaType(mediaType); err != nil {
+		dcontext.GetLogger(ctx).Warnf("ignoring invalid media type recorded for manifest %s: %q", dgst, mediaType)
+		return "", nil
+	}
+	return mediaType, nil
+}
+
+// writeMediaType records the media type of the manifest dgst, so that Stat
+// does not read it. A failure is only logged: Stat then reads the manifest.
+func (ms *manifestStore) writeMediaType(ctx context.Context, dgst digest.Digest, mediaType string) {
+	bs := ms.blobStore.blobStore
+	mediaTypePath, err := pathFor(manifestRevisionMediaTypePathSpec{name: ms.repository.pathName(), revision: dgst})
+	if err == nil {
+		err = bs.driver.PutContent(ctx, mediaTypePath, []byte(mediaType))
+	}
+	if err == nil {
+		err = bs.replicate(ctx, mediaTypePath)
+	}
+	if err != nil {
+		dcontext.GetLogger(ctx).Warnf("failed to record the media type of manifest %s: %v", dgst, err)
+	}
+}
+
+// unmarshal unmarshals the content of the manifest dgst with the handler of
+// its media type.
+func (ms *manifestStore) unmarshal(ctx context.Context, dgst digest.Digest, content []byte) (distribution.Manifest, error) {
 	var versioned manifest.Versioned
-	if err = json.Unmarshal(content, &versioned); err != nil {
+	if err := json.Unmarshal(content, &versioned); err != nil {
 		return nil, err
 	}
 
@@ -128,24 +221,137 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 func (ms *manifestStore) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Put")
 
+	if err := ms.verifyCanonical(manifest); err != nil {
+		return "", err
+	}
+	if err := ms.verifyMediaTypes(manifest); err != nil {
+		return "", err
+	}
+	if err := ms.verifyImageConfig(ctx, manifest); err != nil {
+		return "", err
+	}
+
+	idx := ms.repository.refIndex
+	if idx == nil {
+		return ms.put(ctx, manifest)
+	}
+
+	// The references of a manifest new to the repository are counted
+	// before it is linked, so that a failure leaves them counted too many
+	// times rather than too few.
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return "", err
+	}
+	dgst := digest.FromBytes(payload)
+	exists, err := ms.Exists(ctx, dgst)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return ms.put(ctx, manifest)
+	}
+	if err := idx.add(ctx, dgst, manifest); err != nil {
+		return "", err
+	}
+	putDigest, err := ms.put(ctx, manifest)
+	if err != nil {
+		if err := idx.remove(ctx, dgst, manifest); err != nil {
+			dcontext.GetLogger(ctx).Errorf("failed to uncount references of manifest %s: %v", dgst, err)
+		}
+	}
+	return putDigest, err
+}
+
+// put puts manifest with the handler of its type, and records its media
+// type and its entry in the referrers of its subject.
+func (ms *manifestStore) put(ctx context.Context, manifest distribution.Manifest) (digest.Digest, error) {
+	var handler ManifestHandler
 	switch manifest.(type) {
 	case *schema2.DeserializedManifest:
-		return ms.schema2Handler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.schema2Handler
 	case *ocischema.DeserializedManifest:
-		return ms.ocischemaHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.ocischemaHandler
 	case *manifestlist.DeserializedManifestList:
-		return ms.manifestListHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.manifestListHandler
 	case *ocischema.DeserializedImageIndex:
-		return ms.ocischemaIndexHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.ocischemaIndexHandler
+	default:
+		return "", fmt.Errorf("unrecognized manifest type %T", manifest)
 	}
 
-	return "", fmt.Errorf("unrecognized manifest type %T", manifest)
+	dgst, err := handler.Put(ctx, manifest, ms.skipDependencyVerification)
+	if err != nil {
+		return "", err
+	}
+	mediaType, _, err := manifest.Payload()
+	if err != nil {
+		return "", err
+	}
+	ms.writeMediaType(ctx, dgst, mediaType)
+	if err := ms.writeReferrer(ctx, dgst, manifest); err != nil {
+		return "", err
+	}
+	return dgst, nil
+}
+
+// verifyCanonical returns an ErrManifestNotCanonical error if the payload
+// of mfst is not in canonical form while the repository requires it.
+func (ms *manifestStore) verifyCanonical(mfst distribution.Manifest) error {
+	if _, ok := matchName(ms.repository.canonicalManifests, ms.repository.Named().Name()); !ok {
+		return nil
+	}
+	_, payload, err := mfst.Payload()
+	if err != nil {
+		return err
+	}
+	canonical, err := manifest.Canonicalize(payload)
+	if err != nil {
+		return distribution.ErrManifestVerification{err}
+	}
+	if string(canonical) != string(payload) {
+		return distribution.ErrManifestVerification{distribution.ErrManifestNotCanonical{Canonical: digest.FromBytes(canonical)}}
+	}
+	return nil
 }
 
 // Delete removes the revision of the specified manifest.
 func (ms *manifestStore) Delete(ctx context.Context, dgst digest.Digest) error {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Delete")
-	return ms.blobStore.Delete(ctx, dgst)
+
+	idx := ms.repository.refIndex
+	if idx == nil || !ms.blobStore.deleteEnabled {
+		if err := ms.blobStore.Delete(ctx, dgst); err != nil {
+			return err
+		}
+		ms.removeReferrer(ctx, dgst)
+		return nil
+	}
+
+	// The manifest is read before it is unlinked, to uncount its references
+	// once it is. A manifest which cannot be read leaves them counted.
+	mfst, err := ms.Get(ctx, dgst)
+	if _, ok := err.(distribution.ErrManifestUnknownRevision); err != nil && !ok {
+		dcontext.GetLogger(ctx).Warnf("unable to read manifest %s, its references stay counted: %v", dgst, err)
+	}
+	if err := ms.blobStore.Delete(ctx, dgst); err != nil {
+		return err
+	}
+	ms.removeReferrer(ctx, dgst)
+	if mfst != nil {
+		if err := idx.remove(ctx, dgst, mfst); err != nil {
+			dcontext.GetLogger(ctx).Errorf("failed to uncount references of manifest %s: %v", dgst, err)
+		}
+	}
+	return nil
+}
+
+// removeReferrer removes the deleted manifest dgst from the referrers of its
+// subject. A failure is only logged: the manifest is deleted.
+func (ms *manifestStore) removeReferrer(ctx context.Context, dgst digest.Digest) {
+	if err := removeReferrer(ctx, ms.blobStore.blobStore.driver, ms.repository.pathName(), dgst); err != nil {
+		dcontext.GetLogger(ctx).Errorf("failed to remove manifest %s from the referrers of its subject: %v", dgst, err)
+	}
 }
 
 func (ms *manifestStore) Enumerate(ctx context.Context, ingester func(digest.Digest) error) error {
@@ -158,3 +364,31 @@ func (ms *manifestStore) Enumerate(ctx context.Context, ingester func(digest.Dig
 	})
 	return err
 }
+
+// verifyReferences verifies the references of a manifest with verify, up to
+// limit at a time. The errors of all references are returned together, in the
+// order of the references, as an ErrManifestVerification. verify must be safe
+// for concurrent use: the blob statters and descriptor caches are.
+func verifyReferences(ctx context.Context, limit int, references []distribution.Descriptor, verify func(context.Context, distribution.Descriptor) []error) error {
+	results := make([][]error, len(references))
+	g := errgroup.Group{}
+	g.SetLimit(limit)
+	for i, descriptor := range references {
+		i, descriptor := i, descriptor
+
+		g.Go(func() error {
+			results[i] = verify(ctx, descriptor)
+			return nil
+		})
+	}
+	_ = g.Wait() // the errors are recorded in results
+
+	var errs distribution.ErrManifestVerification
+	for _, result := range results {
+		errs = append(errs, result...)
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/distribution/distribution/v3"
@@ -508,3 +511,109 @@ func ociIndexFromDesriptorsWithMediaType(descriptors []distribution.Descriptor,
 
 	return &d, nil
 }
+
+// readCountingDriver counts the reads of blob data.
+type readCountingDriver struct {
+	driver.StorageDriver
+
+	mu    sync.Mutex
+	reads int
+}
+
+func (d *readCountingDriver) count(path string) {
+	if strings.Contains(path, "/blobs/") && strings.HasSuffix(path, "/data") {
+		d.mu.Lock()
+		d.reads++
+		d.mu.Unlock()
+	}
+}
+
+func (d *readCountingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	d.count(path)
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *readCountingDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	d.count(path)
+	return d.StorageDriver.Reader(ctx, path, offset)
+}
+
+func (d *readCountingDriver) reset() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	reads := d.reads
+	d.reads = 0
+	return reads
+}
+
+func TestManifestStat(t *testing.T) {
+	ctx := context.Background()
+	d := &readCountingDriver{StorageDriver: inmemory.New()}
+	registry, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	named, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, _ := pushTaggedImage(ctx, t, repo, "latest")
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statter := manifests.(*manifestStore)
+	m, err := manifests.Get(ctx, dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, payload, err := m.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}
+
+	d.reset()
+	desc, err := statter.Stat(ctx, dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(desc, expected) {
+		t.Fatalf("unexpected descriptor: %v != %v", desc, expected)
+	}
+	if reads := d.reset(); reads != 0 {
+		t.Fatalf("expected the manifest to be described without reading it, got %d reads", reads)
+	}
+
+	// Manifests put without a recorded media type, or with an invalid one,
+	// are read.
+	mediaTypePath, err := pathFor(manifestRevisionMediaTypePathSpec{name: "foo/bar", revision: dgst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, put := range []func() error{
+		func() error { return d.Delete(ctx, mediaTypePath) },
+		func() error { return d.PutContent(ctx, mediaTypePath, []byte("not a media type;")) },
+	} {
+		if err := put(); err != nil {
+			t.Fatal(err)
+		}
+		desc, err = statter.Stat(ctx, dgst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(desc, expected) {
+			t.Fatalf("unexpected descriptor: %v != %v", desc, expected)
+		}
+		if reads := d.reset(); reads != 1 {
+			t.Fatalf("expected the manifest to be read once, got %d reads", reads)
+		}
+	}
+
+	unknown := digest.FromString("unknown")
+	if _, err := statter.Stat(ctx, unknown); !errors.As(err, &distribution.ErrManifestUnknownRevision{}) {
+		t.Fatalf("expected ErrManifestUnknownRevision, got %v", err)
+	}
+}
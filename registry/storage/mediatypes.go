@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// MediaTypeImageLayerZstd is the media type of the OCI layers
+	// compressed with zstd.
+	MediaTypeImageLayerZstd = "application/vnd.oci.image.layer.v1.tar+zstd"
+
+	// MediaTypeImageLayerNonDistributableZstd is the media type of the OCI
+	// nondistributable layers compressed with zstd.
+	MediaTypeImageLayerNonDistributableZstd = "application/vnd.oci.image.layer.nondistributable.v1.tar+zstd"
+
+	// mediaTypeUncompressedLayer is the media type of the uncompressed
+	// layers of Docker images.
+	mediaTypeUncompressedLayer = "application/vnd.docker.image.rootfs.diff.tar"
+
+	// nonDistributableLayerPrefix prefixes the media types of the OCI
+	// nondistributable layers, whatever their compression.
+	nonDistributableLayerPrefix = "application/vnd.oci.image.layer.nondistributable."
+
+	// encryptedSuffix suffixes the media types of layers encrypted with
+	// ocicrypt.
+	encryptedSuffix = "+encrypted"
+)
+
+// DefaultLayerMediaTypes are the media types of the layers of image
+// manifests allowed unless configured otherwise with LayerMediaTypes: the
+// OCI layers, uncompressed or compressed with gzip or zstd, distributable or
+// not, possibly encrypted, and the Docker layers.
+var DefaultLayerMediaTypes = []string{
+	v1.MediaTypeImageLayer,
+	v1.MediaTypeImageLayerGzip,
+	MediaTypeImageLayerZstd,
+	v1.MediaTypeImageLayer + encryptedSuffix,
+	v1.MediaTypeImageLayerGzip + encryptedSuffix,
+	MediaTypeImageLayerZstd + encryptedSuffix,
+	v1.MediaTypeImageLayerNonDistributable,
+	v1.MediaTypeImageLayerNonDistributableGzip,
+	MediaTypeImageLayerNonDistributableZstd,
+	schema2.MediaTypeLayer,
+	mediaTypeUncompressedLayer,
+	schema2.MediaTypeForeignLayer,
+}
+
+// LayerMediaTypes is a functional option for NewRegistry. It causes image
+// manifests, OCI or Docker manifests with an image config, to be rejected if
+// the media type of one of their layers is not in mediaTypes. The layers of
+// other artifacts are not checked.
+func LayerMediaTypes(mediaTypes []string) RegistryOption {
+	return func(registry *registry) error {
+		registry.layerMediaTypes = mediaTypes
+		return nil
+	}
+}
+
+// nonDistributableLayer reports whether mediaType is the media type of OCI
+// nondistributable layers, which may be served from their URLs rather than
+// by the registry.
+func nonDistributableLayer(mediaType string) bool {
+	return strings.HasPrefix(mediaType, nonDistributableLayerPrefix)
+}
+
+// MediaTypeRule restricts the media types of the manifests pushed to the
+// repositories matching the Repository glob, matched as in NamePolicy.
+type MediaTypeRule struct {
+	Repository string
+	// Manifests lists the allowed manifest media types. They also apply to
+	// the manifests referenced by indexes and manifest lists. Empty allows
+	// any media type.
+	Manifests []string
+	// Configs lists the allowed config media types of OCI image manifests,
+	// which identify the type of OCI artifacts. Empty allows any media type.
+	Configs []string
+}
+
+// ManifestMediaTypeRules is a functional option for NewRegistry. It causes
+// manifests to be rejected unless their media types are allowed by all the
+// rules matching their repository.
+func ManifestMediaTypeRules(rules []MediaTypeRule) RegistryOption {
+	return func(registry *registry) error {
+		for _, rule := range rules {
+			if _, err := NewNamePolicy(0, []string{rule.Repository}, nil); err != nil {
+				return err
+			}
+		}
+		registry.mediaTypeRules = rules
+		return nil
+	}
+}
+
+// verifyMediaTypes returns an ErrManifestMediaTypeNotAllowed error if a
+// media type of mfst is not allowed by the rules of the repository.
+func (ms *manifestStore) verifyMediaTypes(mfst distribution.Manifest) error {
+	if err := ms.verifyLayerMediaTypes(mfst); err != nil {
+		return err
+	}
+
+	name := ms.repository.Named().Name()
+	for _, rule := range ms.repository.mediaTypeRules {
+		if _, ok := matchName([]string{rule.Repository}, name); !ok {
+			continue
+		}
+
+		mediaType, _, err := mfst.Payload()
+		if err != nil {
+			return err
+		}
+		if !allowedMediaType(rule.Manifests, mediaType) {
+			return distribution.ErrManifestVerification{distribution.ErrManifestMediaTypeNotAllowed{Kind: "manifest", MediaType: mediaType, Allowed: rule.Manifests}}
+		}
+
+		switch m := mfst.(type) {
+		case *ocischema.DeserializedManifest:
+			if !allowedMediaType(rule.Configs, m.Config.MediaType) {
+				return distribution.ErrManifestVerification{distribution.ErrManifestMediaTypeNotAllowed{Kind: "config", MediaType: m.Config.MediaType, Allowed: rule.Configs}}
+			}
+		case *ocischema.DeserializedImageIndex, *manifestlist.DeserializedManifestList:
+			var errs distribution.ErrManifestVerification
+			for _, desc := range m.References() {
+				if !allowedMediaType(rule.Manifests, desc.MediaType) {
+					errs = append(errs, distribution.ErrManifestMediaTypeNotAllowed{Kind: "referenced manifest", MediaType: desc.MediaType, Allowed: rule.Manifests})
+				}
+			}
+			if len(errs) > 0 {
+				return errs
+			}
+		}
+	}
+	return nil
+}
+
+// verifyLayerMediaTypes returns an ErrManifestMediaTypeNotAllowed error for
+// each layer of an image manifest whose media type is not one of the layer
+// media types of the registry.
+func (ms *manifestStore) verifyLayerMediaTypes(mfst distribution.Manifest) error {
+	allowed := ms.repository.layerMediaTypes
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	var layers []distribution.Descriptor
+	switch m := mfst.(type) {
+	case *ocischema.DeserializedManifest:
+		if m.Config.MediaType != v1.MediaTypeImageConfig {
+			return nil
+		}
+		layers = m.Layers
+	case *schema2.DeserializedManifest:
+		if m.Config.MediaType != schema2.MediaTypeImageConfig {
+			return nil
+		}
+		layers = m.Layers
+	default:
+		return nil
+	}
+
+	var errs distribution.ErrManifestVerification
+	for _, layer := range layers {
+		if !allowedMediaType(allowed, layer.MediaType) {
+			errs = append(errs, distribution.ErrManifestMediaTypeNotAllowed{Kind: "layer", MediaType: layer.MediaType, Allowed: allowed})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func allowedMediaType(allowed []string, mediaType string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == mediaType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const helmConfigMediaType = "application/vnd.cncf.helm.config.v1+json"
+
+func TestManifestMediaTypeRules(t *testing.T) {
+	ctx := context.Background()
+	registry, err := NewRegistry(ctx, inmemory.New(), ManifestMediaTypeRules([]MediaTypeRule{
+		{
+			Repository: "charts/*",
+			Manifests:  []string{v1.MediaTypeImageManifest},
+			Configs:    []string{helmConfigMediaType},
+		},
+		{
+			Repository: "images/*",
+			Manifests:  []string{v1.MediaTypeImageManifest, v1.MediaTypeImageIndex, schema2.MediaTypeManifest, manifestlist.MediaTypeManifestList},
+		},
+		{
+			Repository: "images/oci/*",
+			Manifests:  []string{v1.MediaTypeImageManifest, v1.MediaTypeImageIndex},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error creating registry: %v", err)
+	}
+
+	ociManifest := func(configMediaType string) distribution.Manifest {
+		m, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+			Config:    distribution.Descriptor{MediaType: configMediaType, Digest: digest.FromString("config"), Size: 6},
+			Layers:    []distribution.Descriptor{{MediaType: v1.MediaTypeImageLayerGzip, Digest: digest.FromString("layer"), Size: 5}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	schema2Manifest, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    distribution.Descriptor{MediaType: schema2.MediaTypeImageConfig, Digest: digest.FromString("config"), Size: 6},
+		Layers:    []distribution.Descriptor{{MediaType: schema2.MediaTypeLayer, Digest: digest.FromString("layer"), Size: 5}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ociIndex := func(childMediaTypes ...string) distribution.Manifest {
+		var descriptors []distribution.Descriptor
+		for i, mediaType := range childMediaTypes {
+			descriptors = append(descriptors, distribution.Descriptor{MediaType: mediaType, Digest: digest.FromString(mediaType), Size: int64(i + 1)})
+		}
+		m, err := ocischema.FromDescriptors(descriptors, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	for _, tc := range []struct {
+		repository string
+		manifest   distribution.Manifest
+		rejected   []distribution.ErrManifestMediaTypeNotAllowed
+	}{
+		{repository: "charts/nginx", manifest: ociManifest(helmConfigMediaType)},
+		{
+			repository: "charts/nginx",
+			manifest:   ociManifest(v1.MediaTypeImageConfig),
+			rejected:   []distribution.ErrManifestMediaTypeNotAllowed{{Kind: "config", MediaType: v1.MediaTypeImageConfig}},
+		},
+		{
+			repository: "charts/nginx",
+			manifest:   schema2Manifest,
+			rejected:   []distribution.ErrManifestMediaTypeNotAllowed{{Kind: "manifest", MediaType: schema2.MediaTypeManifest}},
+		},
+		{
+			repository: "charts/nginx",
+			manifest:   ociIndex(v1.MediaTypeImageManifest),
+			rejected:   []distribution.ErrManifestMediaTypeNotAllowed{{Kind: "manifest", MediaType: v1.MediaTypeImageIndex}},
+		},
+		{repository: "images/nginx", manifest: ociManifest(v1.MediaTypeImageConfig)},
+		{repository: "images/nginx", manifest: schema2Manifest},
+		{repository: "images/nginx", manifest: ociIndex(v1.MediaTypeImageManifest, schema2.MediaTypeManifest)},
+		{
+			repository: "images/nginx",
+			manifest:   ociIndex(v1.MediaTypeImageManifest, "application/vnd.example.unknown", "application/vnd.example.other"),
+			rejected: []distribution.ErrManifestMediaTypeNotAllowed{
+				{Kind: "referenced manifest", MediaType: "application/vnd.example.unknown"},
+				{Kind: "referenced manifest", MediaType: "application/vnd.example.other"},
+			},
+		},
+		{
+			// Both images/* and images/oci/* apply.
+			repository: "images/oci/nginx",
+			manifest:   ociIndex(v1.MediaTypeImageManifest, schema2.MediaTypeManifest),
+			rejected:   []distribution.ErrManifestMediaTypeNotAllowed{{Kind: "referenced manifest", MediaType: schema2.MediaTypeManifest}},
+		},
+		{repository: "other/nginx", manifest: schema2Manifest},
+	} {
+		named, _ := reference.WithName(tc.repository)
+		repo, err := registry.Repository(ctx, named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifests, err := repo.Manifests(ctx, SkipLayerVerification())
+		if err != nil {
+			t.Fatal(err)
+		}
+		mediaType, _, _ := tc.manifest.Payload()
+
+		_, err = manifests.Put(ctx, tc.manifest)
+		if len(tc.rejected) == 0 {
+			if err != nil {
+				t.Errorf("unexpected error putting %s to %s: %v", mediaType, tc.repository, err)
+			}
+			continue
+		}
+		var errs distribution.ErrManifestVerification
+		if !errors.As(err, &errs) || len(errs) != len(tc.rejected) {
+			t.Errorf("unexpected error putting %s to %s: %v", mediaType, tc.repository, err)
+			continue
+		}
+		for i, err := range errs {
+			notAllowed, ok := err.(distribution.ErrManifestMediaTypeNotAllowed)
+			if !ok || notAllowed.Kind != tc.rejected[i].Kind || notAllowed.MediaType != tc.rejected[i].MediaType || len(notAllowed.Allowed) == 0 {
+				t.Errorf("unexpected error putting %s to %s: %v", mediaType, tc.repository, err)
+			}
+		}
+	}
+
+	if _, err := NewRegistry(ctx, inmemory.New(), ManifestMediaTypeRules([]MediaTypeRule{{Repository: "charts/["}})); err == nil {
+		t.Fatal("expected error creating registry with malformed repository pattern")
+	}
+}
+
+func TestLayerMediaTypes(t *testing.T) {
+	ctx := context.Background()
+	registry, err := NewRegistry(ctx, inmemory.New(), LayerMediaTypes(DefaultLayerMediaTypes))
+	if err != nil {
+		t.Fatalf("unexpected error creating registry: %v", err)
+	}
+	named, _ := reference.WithName("images/nginx")
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx, SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ociManifest := func(configMediaType string, layerMediaTypes ...string) distribution.Manifest {
+		var layers []distribution.Descriptor
+		for i, mediaType := range layerMediaTypes {
+			layers = append(layers, distribution.Descriptor{MediaType: mediaType, Digest: digest.FromString(mediaType), Size: int64(i + 1)})
+		}
+		m, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+			Config:    distribution.Descriptor{MediaType: configMediaType, Digest: digest.FromString("config"), Size: 6},
+			Layers:    layers,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	for _, tc := range []struct {
+		name     string
+		manifest distribution.Manifest
+		rejected []string
+	}{
+		{name: "zstd", manifest: ociManifest(v1.MediaTypeImageConfig, MediaTypeImageLayerZstd, v1.MediaTypeImageLayerGzip)},
+		{name: "nondistributable", manifest: ociManifest(v1.MediaTypeImageConfig, MediaTypeImageLayerNonDistributableZstd, v1.MediaTypeImageLayerNonDistributableGzip)},
+		{name: "encrypted", manifest: ociManifest(v1.MediaTypeImageConfig, MediaTypeImageLayerZstd+"+encrypted")},
+		{
+			name:     "unknown",
+			manifest: ociManifest(v1.MediaTypeImageConfig, v1.MediaTypeImageLayer, "application/vnd.example.layer", "application/octet-stream"),
+			rejected: []string{"application/vnd.example.layer", "application/octet-stream"},
+		},
+		// The layers of artifacts are not checked.
+		{name: "artifact", manifest: ociManifest(helmConfigMediaType, "application/vnd.cncf.helm.chart.content.v1.tar+gzip")},
+	} {
+		_, err := manifests.Put(ctx, tc.manifest)
+		if len(tc.rejected) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		var errs distribution.ErrManifestVerification
+		if !errors.As(err, &errs) || len(errs) != len(tc.rejected) {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		for i, err := range errs {
+			notAllowed, ok := err.(distribution.ErrManifestMediaTypeNotAllowed)
+			if !ok || notAllowed.Kind != "layer" || notAllowed.MediaType != tc.rejected[i] {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// maxLegacyManifestSize is the size of the largest blob linked in the layers
+// of a repository which is considered as a possible manifest.
+const maxLegacyManifestSize = 4 << 20
+
+// MigrateLayoutOpts contains options for MigrateLayout.
+type MigrateLayoutOpts struct {
+	// RemoveLegacy removes the manifest links of the legacy location once
+	// they have been copied to the manifest revisions, and verified.
+	RemoveLegacy bool
+}
+
+// MigrateLayoutReport is the result of MigrateLayout.
+type MigrateLayoutReport struct {
+	Repositories int `json:"repositories"`
+	// Migrated is the number of manifest links copied to the manifest
+	// revisions.
+	Migrated int `json:"migrated"`
+	// Removed is the number of legacy manifest links removed.
+	Removed int `json:"removed"`
+	// Legacy lists the repositories with manifests which are still only
+	// linked in the legacy location, because their migration failed.
+	Legacy []string `json:"legacy"`
+}
+
+// MigrateLayout migrates the repositories of namespace from the legacy
+// layout, in which manifests are linked with the layers of the repository,
+// to the current layout, in which they are linked in the manifest revisions.
+// Manifests are recognized by their payload. Each link is verified to resolve
+// after it is copied and, if opts.RemoveLegacy is set, the legacy link is
+// removed. Blob data is never modified.
+func MigrateLayout(ctx context.Context, namespace distribution.Namespace, opts MigrateLayoutOpts) (MigrateLayoutReport, error) {
+	report := MigrateLayoutReport{Legacy: []string{}}
+	reg, ok := namespace.(*registry)
+	if !ok {
+		return report, fmt.Errorf("unable to migrate registry of type %T", namespace)
+	}
+
+	err := reg.Enumerate(ctx, func(repoName string) error {
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		r, err := reg.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+		repo := r.(*repository)
+		report.Repositories++
+
+		legacy, err := migrateRepositoryLayout(ctx, repo, opts, &report)
+		if err != nil {
+			return fmt.Errorf("failed to migrate %s: %v", repoName, err)
+		}
+		if legacy {
+			dcontext.GetLogger(ctx).Warnf("repository %s still has manifests linked in the legacy location only", repoName)
+			report.Legacy = append(report.Legacy, repoName)
+		}
+		return nil
+	})
+	return report, err
+}
+
+// migrateRepositoryLayout migrates the manifest links of repo, and reports
+// whether manifests failed to migrate.
+func migrateRepositoryLayout(ctx context.Context, repo *repository, opts MigrateLayoutOpts, report *MigrateLayoutReport) (bool, error) {
+	layerStatter := &linkedBlobStatter{
+		blobStore:  repo.blobStore,
+		repository: repo,
+		linkPath:   blobLinkPath,
+	}
+	manifestStatter := &linkedBlobStatter{
+		blobStore:  repo.blobStore,
+		repository: repo,
+		linkPath:   manifestRevisionLinkPath,
+	}
+
+	var legacy bool
+	err := walkLinks(ctx, repo.driver, layersPathSpec{name: repo.pathName()}, func(dgst digest.Digest) error {
+		desc, err := layerStatter.Stat(ctx, dgst)
+		if err == distribution.ErrBlobUnknown || isMalformedLink(err) {
+			// Broken links are reported by fsck.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		isManifest, err := isManifestBlob(ctx, repo.blobStore, desc)
+		if err != nil || !isManifest {
+			return err
+		}
+
+		if _, err := manifestStatter.Stat(ctx, dgst); err == distribution.ErrBlobUnknown || isMalformedLink(err) {
+			revisionPath, err := manifestRevisionLinkPath(repo.pathName(), dgst)
+			if err != nil {
+				return err
+			}
+			if err := repo.blobStore.link(ctx, revisionPath, desc.Digest); err != nil {
+				return err
+			}
+			if migrated, err := manifestStatter.Stat(ctx, dgst); err != nil || migrated.Digest != desc.Digest {
+				dcontext.GetLogger(ctx).Errorf("manifest %s of %s does not resolve after migration: %v", dgst, repo.Named().Name(), err)
+				legacy = true
+				return nil
+			}
+			dcontext.GetLogger(ctx).Infof("migrated manifest %s of %s", dgst, repo.Named().Name())
+			report.Migrated++
+		} else if err != nil {
+			return err
+		}
+
+		if opts.RemoveLegacy {
+			if err := layerStatter.Clear(ctx, dgst); err != nil {
+				return err
+			}
+			report.Removed++
+		}
+		return nil
+	})
+	return legacy, err
+}
+
+// isManifestBlob reports whether the blob desc is a manifest, which is a JSON
+// object with a schemaVersion.
+func isManifestBlob(ctx context.Context, blobs *blobStore, desc distribution.Descriptor) (bool, error) {
+	if desc.Size > maxLegacyManifestSize {
+		return false, nil
+	}
+	p, err := blobs.Get(ctx, desc.Digest)
+	if err == distribution.ErrBlobUnknown {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var versioned struct {
+		SchemaVersion *int `json:"schemaVersion"`
+	}
+	if err := json.Unmarshal(p, &versioned); err != nil {
+		return false, nil
+	}
+	return versioned.SchemaVersion != nil, nil
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestMigrateLayout(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d)
+	legacyRepo := makeRepository(t, registry, "foo/legacy")
+	currentRepo := makeRepository(t, registry, "foo/current")
+
+	legacy, layer := pushTaggedImage(ctx, t, legacyRepo, "latest")
+	current, _ := pushTaggedImage(ctx, t, currentRepo, "latest")
+
+	// Move the manifest link of foo/legacy to the layers, as written by
+	// registry 2.1.
+	revisionPath, err := pathFor(manifestRevisionPathSpec{name: "foo/legacy", revision: legacy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, revisionPath); err != nil {
+		t.Fatal(err)
+	}
+	legacyPath, err := pathFor(layerLinkPathSpec{name: "foo/legacy", digest: legacy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, legacyPath, []byte(legacy)); err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := legacyRepo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manifests.Get(ctx, legacy); err == nil {
+		t.Fatal("expected legacy manifest to be unknown before migration")
+	}
+
+	report, err := MigrateLayout(ctx, registry, MigrateLayoutOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error migrating: %v", err)
+	}
+	expected := MigrateLayoutReport{Repositories: 2, Migrated: 1, Legacy: []string{}}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("unexpected report: %+v != %+v", report, expected)
+	}
+	if _, err := manifests.Get(ctx, legacy); err != nil {
+		t.Fatalf("unexpected error getting migrated manifest: %v", err)
+	}
+	if _, err := d.Stat(ctx, legacyPath); err != nil {
+		t.Fatalf("legacy link removed without RemoveLegacy: %v", err)
+	}
+
+	report, err = MigrateLayout(ctx, registry, MigrateLayoutOpts{RemoveLegacy: true})
+	if err != nil {
+		t.Fatalf("unexpected error migrating: %v", err)
+	}
+	expected = MigrateLayoutReport{Repositories: 2, Removed: 1, Legacy: []string{}}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("unexpected report: %+v != %+v", report, expected)
+	}
+	if _, err := d.Stat(ctx, legacyPath); err == nil {
+		t.Fatal("expected legacy link to be removed")
+	}
+
+	// Layers and the manifests of the current layout are left alone.
+	if _, err := legacyRepo.Blobs(ctx).Stat(ctx, layer); err != nil {
+		t.Fatalf("unexpected error stating layer: %v", err)
+	}
+	currentManifests, err := currentRepo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := currentManifests.Get(ctx, current); err != nil {
+		t.Fatalf("unexpected error getting manifest: %v", err)
+	}
+	if _, err := manifests.Get(ctx, legacy); err != nil {
+		t.Fatalf("unexpected error getting migrated manifest: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+)
+
+// NamePolicy restricts the names of the repositories served by a registry.
+// Patterns are globs, as understood by path.Match, matched against the
+// repository name and each of its parent namespaces: "admin/*" matches both
+// "admin/tools" and "admin/tools/build". Deny patterns take precedence over
+// allow patterns and, if allow patterns are set, names must match one of them.
+type NamePolicy struct {
+	maxComponents int
+	allow         []string
+	deny          []string
+}
+
+// NewNamePolicy returns a NamePolicy limiting names to maxComponents path
+// components, if it is positive, and to the names matching the allow and deny
+// patterns. It returns an error if a pattern is malformed.
+func NewNamePolicy(maxComponents int, allow, deny []string) (*NamePolicy, error) {
+	for _, patterns := range [][]string{allow, deny} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid repository name pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	return &NamePolicy{
+		maxComponents: maxComponents,
+		allow:         allow,
+		deny:          deny,
+	}, nil
+}
+
+// Validate returns an ErrRepositoryNameInvalid error, whose reason explains
+// the rule that failed, if the policy forbids the repository name.
+func (p *NamePolicy) Validate(name string) error {
+	if p == nil {
+		return nil
+	}
+	var reason error
+	if n := strings.Count(name, "/") + 1; p.maxComponents > 0 && n > p.maxComponents {
+		reason = fmt.Errorf("name has %d path components, more than the maximum of %d", n, p.maxComponents)
+	} else if pattern, ok := matchName(p.deny, name); ok {
+		reason = fmt.Errorf("name matches denied pattern %q", pattern)
+	} else if _, ok := matchName(p.allow, name); !ok && len(p.allow) > 0 {
+		reason = fmt.Errorf("name does not match any allowed pattern")
+	}
+	if reason != nil {
+		return distribution.ErrRepositoryNameInvalid{Name: name, Reason: reason}
+	}
+	return nil
+}
+
+// matchName returns the first pattern matching name or one of its parent
+// namespaces.
+func matchName(patterns []string, name string) (string, bool) {
+	for _, pattern := range patterns {
+		for prefix := name; ; {
+			if ok, _ := path.Match(pattern, prefix); ok {
+				return pattern, true
+			}
+			i := strings.LastIndexByte(prefix, '/')
+			if i < 0 {
+				break
+			}
+			prefix = prefix[:i]
+		}
+	}
+	return "", false
+}
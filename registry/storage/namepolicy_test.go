@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+)
+
+func TestNamePolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		maxComponents int
+		allow         []string
+		deny          []string
+		valid         []string
+		invalid       map[string]string
+	}{
+		{
+			name:  "no rules",
+			valid: []string{"foo", "foo/bar/baz/qux"},
+		},
+		{
+			name:          "max components",
+			maxComponents: 3,
+			valid:         []string{"foo", "foo/bar/baz"},
+			invalid:       map[string]string{"foo/bar/baz/qux": "4 path components, more than the maximum of 3"},
+		},
+		{
+			name:    "deny",
+			deny:    []string{"admin/*", "*/internal"},
+			valid:   []string{"admin", "administrator/foo", "team/foo"},
+			invalid: map[string]string{"admin/foo": `denied pattern "admin/*"`, "admin/foo/bar": `denied pattern "admin/*"`, "team/internal/foo": `denied pattern "*/internal"`},
+		},
+		{
+			name:    "allow",
+			allow:   []string{"library/*", "team-?"},
+			valid:   []string{"library/ubuntu", "team-a", "team-b/app/build"},
+			invalid: map[string]string{"library": "does not match any allowed pattern", "team-ab/app": "does not match any allowed pattern"},
+		},
+		{
+			name:  "deny takes precedence over allow",
+			allow: []string{"team/*"},
+			deny:  []string{"team/secret*"},
+			valid: []string{"team/app", "team/app/secrets"},
+			invalid: map[string]string{
+				"team/secrets": `denied pattern "team/secret*"`,
+				"other/app":    "does not match any allowed pattern",
+			},
+		},
+		{
+			name:          "max components before patterns",
+			maxComponents: 2,
+			deny:          []string{"admin/*"},
+			invalid:       map[string]string{"admin/foo/bar": "3 path components"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := NewNamePolicy(tc.maxComponents, tc.allow, tc.deny)
+			if err != nil {
+				t.Fatalf("unexpected error creating policy: %v", err)
+			}
+			for _, name := range tc.valid {
+				if err := policy.Validate(name); err != nil {
+					t.Errorf("unexpected error validating %q: %v", name, err)
+				}
+			}
+			for name, reason := range tc.invalid {
+				err := policy.Validate(name)
+				var nameErr distribution.ErrRepositoryNameInvalid
+				if !errors.As(err, &nameErr) {
+					t.Errorf("expected ErrRepositoryNameInvalid validating %q, got %v", name, err)
+					continue
+				}
+				if nameErr.Name != name || !strings.Contains(nameErr.Reason.Error(), reason) {
+					t.Errorf("unexpected error validating %q: %v", name, err)
+				}
+			}
+		})
+	}
+
+	if _, err := NewNamePolicy(0, nil, []string{"admin/["}); err == nil {
+		t.Fatal("expected error creating policy with malformed pattern")
+	}
+	var policy *NamePolicy
+	if err := policy.Validate("foo"); err != nil {
+		t.Fatalf("unexpected error validating with nil policy: %v", err)
+	}
+}
+
+func TestRepositoryEnforcesNamePolicy(t *testing.T) {
+	ctx := context.Background()
+	policy, err := NewNamePolicy(0, nil, []string{"admin/*"})
+	if err != nil {
+		t.Fatalf("unexpected error creating policy: %v", err)
+	}
+	registry, err := NewRegistry(ctx, inmemory.New(), EnforceNamePolicy(policy))
+	if err != nil {
+		t.Fatalf("unexpected error creating registry: %v", err)
+	}
+
+	named, _ := reference.WithName("admin/foo")
+	if _, err := registry.Repository(ctx, named); !errors.As(err, &distribution.ErrRepositoryNameInvalid{}) {
+		t.Fatalf("expected ErrRepositoryNameInvalid, got %v", err)
+	}
+	named, _ = reference.WithName("foo/admin")
+	if _, err := registry.Repository(ctx, named); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,394 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxLayoutManifestSize is the size of the largest blob of an OCI image
+// layout archive which is considered as a possible manifest on import.
+const maxLayoutManifestSize = 4 << 20
+
+// Files and directories of an OCI image layout, besides the oci-layout file.
+const (
+	layoutIndexFile = "index.json"
+	layoutBlobsDir  = "blobs"
+)
+
+// layoutBlob is a blob to write to an OCI image layout archive. The payload
+// of manifests is kept, other blobs are streamed from the repository.
+type layoutBlob struct {
+	digest  digest.Digest
+	size    int64
+	payload []byte
+}
+
+// ExportOCILayout writes the tagged manifests of repo, the manifests they
+// reference and their blobs to w as a tar archive of an OCI image layout.
+// Tags are recorded with the org.opencontainers.image.ref.name annotation of
+// index.json. The digest of each blob is verified as it is read.
+func ExportOCILayout(ctx context.Context, repo distribution.Repository, w io.Writer) error {
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	tagService := repo.Tags(ctx)
+	tags, err := tagService.All(ctx)
+	if err != nil && !errors.As(err, &distribution.ErrRepositoryUnknown{}) {
+		return fmt.Errorf("failed to list tags: %v", err)
+	}
+	sort.Strings(tags)
+
+	var (
+		blobs []layoutBlob
+		seen  = make(map[digest.Digest]bool)
+	)
+	// addManifest adds the manifest dgst, and the blobs and manifests it
+	// references, to the blobs to write.
+	var addManifest func(dgst digest.Digest) (distribution.Descriptor, error)
+	addManifest = func(dgst digest.Digest) (distribution.Descriptor, error) {
+		m, err := manifests.Get(ctx, dgst)
+		if err != nil {
+			return distribution.Descriptor{}, fmt.Errorf("failed to get manifest %s: %v", dgst, err)
+		}
+		mediaType, payload, err := m.Payload()
+		if err != nil {
+			return distribution.Descriptor{}, err
+		}
+		if actual := dgst.Algorithm().FromBytes(payload); actual != dgst {
+			return distribution.Descriptor{}, fmt.Errorf("manifest %s has digest %s", dgst, actual)
+		}
+		desc := distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}
+		if seen[dgst] {
+			return desc, nil
+		}
+		seen[dgst] = true
+		blobs = append(blobs, layoutBlob{digest: dgst, size: desc.Size, payload: payload})
+
+		switch m.(type) {
+		case *ocischema.DeserializedImageIndex, *manifestlist.DeserializedManifestList:
+			for _, ref := range m.References() {
+				if _, err := addManifest(ref.Digest); err != nil {
+					return distribution.Descriptor{}, err
+				}
+			}
+		default:
+			for _, ref := range m.References() {
+				if len(ref.URLs) > 0 || seen[ref.Digest] {
+					// Foreign layers are not stored by the registry.
+					continue
+				}
+				seen[ref.Digest] = true
+				stat, err := repo.Blobs(ctx).Stat(ctx, ref.Digest)
+				if err != nil {
+					return distribution.Descriptor{}, fmt.Errorf("failed to stat blob %s of manifest %s: %v", ref.Digest, dgst, err)
+				}
+				blobs = append(blobs, layoutBlob{digest: ref.Digest, size: stat.Size})
+			}
+		}
+		return desc, nil
+	}
+
+	index := v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageIndex,
+		Manifests: []v1.Descriptor{},
+	}
+	for _, tag := range tags {
+		tagged, err := tagService.Get(ctx, tag)
+		if err != nil {
+			return fmt.Errorf("failed to get tag %s: %v", tag, err)
+		}
+		desc, err := addManifest(tagged.Digest)
+		if err != nil {
+			return err
+		}
+		index.Manifests = append(index.Manifests, v1.Descriptor{
+			MediaType:   desc.MediaType,
+			Digest:      desc.Digest,
+			Size:        desc.Size,
+			Annotations: map[string]string{v1.AnnotationRefName: tag},
+		})
+	}
+
+	tw := tar.NewWriter(w)
+	layout, err := json.Marshal(v1.ImageLayout{Version: v1.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	if err := writeLayoutFile(tw, v1.ImageLayoutFile, layout); err != nil {
+		return err
+	}
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := writeLayoutFile(tw, layoutIndexFile, indexJSON); err != nil {
+		return err
+	}
+
+	dirs := make(map[string]bool)
+	for _, blob := range blobs {
+		dir := path.Join(layoutBlobsDir, blob.digest.Algorithm().String())
+		for _, d := range []string{layoutBlobsDir, dir} {
+			if dirs[d] {
+				continue
+			}
+			dirs[d] = true
+			if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: d + "/", Mode: 0o755, ModTime: time.Unix(0, 0)}); err != nil {
+				return err
+			}
+		}
+
+		name := path.Join(dir, blob.digest.Encoded())
+		if blob.payload != nil {
+			err = writeLayoutFile(tw, name, blob.payload)
+		} else {
+			err = exportBlob(ctx, repo.Blobs(ctx), tw, name, blob)
+		}
+		if err != nil {
+			return err
+		}
+		dcontext.GetLogger(ctx).Infof("exported blob %s (%d bytes)", blob.digest, blob.size)
+	}
+	return tw.Close()
+}
+
+func writeLayoutFile(tw *tar.Writer, name string, content []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(content)), ModTime: time.Unix(0, 0)}); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
+
+// exportBlob streams the content of blob to tw, verifying its digest.
+func exportBlob(ctx context.Context, blobs distribution.BlobStore, tw *tar.Writer, name string, blob layoutBlob) error {
+	rc, err := blobs.Open(ctx, blob.digest)
+	if err != nil {
+		return fmt.Errorf("failed to open blob %s: %v", blob.digest, err)
+	}
+	defer rc.Close()
+
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: blob.size, ModTime: time.Unix(0, 0)}); err != nil {
+		return err
+	}
+	verifier := blob.digest.Verifier()
+	if _, err := io.CopyN(tw, io.TeeReader(rc, verifier), blob.size); err != nil {
+		return fmt.Errorf("failed to read blob %s: %v", blob.digest, err)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("blob %s does not match its digest", blob.digest)
+	}
+	return nil
+}
+
+// ImportOCILayout reads a tar archive of an OCI image layout from r and
+// pushes its blobs and the manifests referenced by index.json to repo. The
+// manifests of index.json with an org.opencontainers.image.ref.name
+// annotation are tagged with it. The digest of each blob is verified before
+// it is committed.
+func ImportOCILayout(ctx context.Context, repo distribution.Repository, r io.Reader) error {
+	blobs := repo.Blobs(ctx)
+	var (
+		index     *v1.Index
+		hasLayout bool
+		// pending holds the small JSON blobs, which may be manifests, until
+		// the whole archive has been read.
+		pending = make(map[digest.Digest][]byte)
+	)
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		switch {
+		case name == v1.ImageLayoutFile:
+			var layout v1.ImageLayout
+			if err := json.NewDecoder(tr).Decode(&layout); err != nil {
+				return fmt.Errorf("failed to decode %s: %v", name, err)
+			}
+			if layout.Version != v1.ImageLayoutVersion {
+				return fmt.Errorf("unsupported image layout version %q", layout.Version)
+			}
+			hasLayout = true
+		case name == layoutIndexFile:
+			index = &v1.Index{}
+			if err := json.NewDecoder(tr).Decode(index); err != nil {
+				return fmt.Errorf("failed to decode %s: %v", name, err)
+			}
+		case strings.HasPrefix(name, layoutBlobsDir+"/"):
+			dgst, err := layoutBlobDigest(name)
+			if err != nil {
+				return err
+			}
+			if hdr.Size > maxLayoutManifestSize {
+				if err := importBlob(ctx, blobs, tr, distribution.Descriptor{Digest: dgst, Size: hdr.Size}); err != nil {
+					return err
+				}
+				continue
+			}
+
+			p, err := io.ReadAll(tr)
+			if err != nil {
+				return fmt.Errorf("failed to read blob %s: %v", dgst, err)
+			}
+			if actual := dgst.Algorithm().FromBytes(p); actual != dgst {
+				return fmt.Errorf("blob %s does not match its digest, got %s", dgst, actual)
+			}
+			if bytes.HasPrefix(bytes.TrimSpace(p), []byte("{")) {
+				pending[dgst] = p
+				continue
+			}
+			if _, err := blobs.Put(ctx, "application/octet-stream", p); err != nil {
+				return fmt.Errorf("failed to put blob %s: %v", dgst, err)
+			}
+			dcontext.GetLogger(ctx).Infof("imported blob %s (%d bytes)", dgst, len(p))
+		}
+	}
+	if !hasLayout {
+		return fmt.Errorf("archive is not an OCI image layout: missing %s", v1.ImageLayoutFile)
+	}
+	if index == nil {
+		return fmt.Errorf("archive is not an OCI image layout: missing index.json")
+	}
+
+	// Manifests can only be pushed once the blobs and manifests they
+	// reference exist, so the manifests are found before pending blobs
+	// which are not manifests are pushed.
+	parsed := make(map[digest.Digest]distribution.Manifest)
+	var order []digest.Digest
+	var walk func(desc distribution.Descriptor) error
+	walk = func(desc distribution.Descriptor) error {
+		if _, ok := parsed[desc.Digest]; ok {
+			return nil
+		}
+		p, ok := pending[desc.Digest]
+		if !ok {
+			return fmt.Errorf("manifest %s not found in archive", desc.Digest)
+		}
+		m, _, err := distribution.UnmarshalManifest(desc.MediaType, p)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal manifest %s: %v", desc.Digest, err)
+		}
+		parsed[desc.Digest] = m
+		switch m.(type) {
+		case *ocischema.DeserializedImageIndex, *manifestlist.DeserializedManifestList:
+			for _, ref := range m.References() {
+				if err := walk(ref); err != nil {
+					return err
+				}
+			}
+		}
+		order = append(order, desc.Digest)
+		return nil
+	}
+	for _, desc := range index.Manifests {
+		if err := walk(distribution.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size}); err != nil {
+			return err
+		}
+	}
+
+	pendingBlobs := make([]digest.Digest, 0, len(pending))
+	for dgst := range pending {
+		if _, ok := parsed[dgst]; !ok {
+			pendingBlobs = append(pendingBlobs, dgst)
+		}
+	}
+	sort.Slice(pendingBlobs, func(i, j int) bool { return pendingBlobs[i] < pendingBlobs[j] })
+	for _, dgst := range pendingBlobs {
+		if _, err := blobs.Put(ctx, "application/octet-stream", pending[dgst]); err != nil {
+			return fmt.Errorf("failed to put blob %s: %v", dgst, err)
+		}
+		dcontext.GetLogger(ctx).Infof("imported blob %s (%d bytes)", dgst, len(pending[dgst]))
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	for _, dgst := range order {
+		if _, err := manifests.Put(ctx, parsed[dgst]); err != nil {
+			return fmt.Errorf("failed to put manifest %s: %v", dgst, err)
+		}
+		dcontext.GetLogger(ctx).Infof("imported manifest %s (%d bytes)", dgst, len(pending[dgst]))
+	}
+
+	for _, desc := range index.Manifests {
+		tag, ok := desc.Annotations[v1.AnnotationRefName]
+		if !ok {
+			continue
+		}
+		if err := repo.Tags(ctx).Tag(ctx, tag, distribution.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size}); err != nil {
+			return fmt.Errorf("failed to tag manifest %s as %s: %v", desc.Digest, tag, err)
+		}
+	}
+	return nil
+}
+
+// layoutBlobDigest returns the digest of the blob at name, a path of the form
+// blobs/<algorithm>/<encoded>.
+func layoutBlobDigest(name string) (digest.Digest, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("unexpected blob path %s", name)
+	}
+	dgst := digest.NewDigestFromEncoded(digest.Algorithm(parts[1]), parts[2])
+	if err := dgst.Validate(); err != nil {
+		return "", fmt.Errorf("invalid blob path %s: %v", name, err)
+	}
+	return dgst, nil
+}
+
+// importBlob streams the content of the blob desc from r to blobs. The
+// digest and size are verified on commit.
+func importBlob(ctx context.Context, blobs distribution.BlobStore, r io.Reader, desc distribution.Descriptor) error {
+	if _, err := blobs.Stat(ctx, desc.Digest); err == nil {
+		dcontext.GetLogger(ctx).Infof("blob %s already exists", desc.Digest)
+		return nil
+	}
+
+	bw, err := blobs.Create(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(bw, r); err != nil {
+		// nolint:errcheck
+		bw.Cancel(ctx)
+		return fmt.Errorf("failed to write blob %s: %v", desc.Digest, err)
+	}
+	if _, err := bw.Commit(ctx, desc); err != nil {
+		// nolint:errcheck
+		bw.Cancel(ctx)
+		return fmt.Errorf("failed to commit blob %s: %v", desc.Digest, err)
+	}
+	dcontext.GetLogger(ctx).Infof("imported blob %s (%d bytes)", desc.Digest, desc.Size)
+	return nil
+}
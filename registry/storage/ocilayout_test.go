@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestOCILayoutExportImport(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	src := makeRepository(t, createRegistry(t, d), "foo/src")
+
+	first, firstLayer := pushTaggedImage(ctx, t, src, "first")
+	second, _ := pushTaggedImage(ctx, t, src, "second")
+	index, err := ocischema.FromDescriptors([]distribution.Descriptor{
+		{MediaType: schema2.MediaTypeManifest, Digest: first, Size: 1},
+		{MediaType: schema2.MediaTypeManifest, Digest: second, Size: 1},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcManifests, err := src.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDigest, err := srcManifests.Put(ctx, index)
+	if err != nil {
+		t.Fatalf("index upload failed: %v", err)
+	}
+	if err := src.Tags(ctx).Tag(ctx, "multi", distribution.Descriptor{Digest: indexDigest}); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if err := ExportOCILayout(ctx, src, &archive); err != nil {
+		t.Fatalf("unexpected error exporting: %v", err)
+	}
+
+	// The index of the layout lists the tagged manifests.
+	files := readTar(t, archive.Bytes())
+	var layoutIndex v1.Index
+	if err := json.Unmarshal(files["index.json"], &layoutIndex); err != nil {
+		t.Fatalf("failed to decode index.json: %v", err)
+	}
+	refs := make(map[string]digest.Digest)
+	for _, desc := range layoutIndex.Manifests {
+		refs[desc.Annotations[v1.AnnotationRefName]] = desc.Digest
+	}
+	if len(refs) != 3 || refs["first"] != first || refs["second"] != second || refs["multi"] != indexDigest {
+		t.Fatalf("unexpected index.json: %s", files["index.json"])
+	}
+	if _, ok := files["blobs/sha256/"+firstLayer.Encoded()]; !ok {
+		t.Fatalf("layer %s missing from archive", firstLayer)
+	}
+
+	dst := makeRepository(t, createRegistry(t, inmemory.New()), "foo/dst")
+	if err := ImportOCILayout(ctx, dst, bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("unexpected error importing: %v", err)
+	}
+	dstManifests, err := dst.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tag, dgst := range refs {
+		desc, err := dst.Tags(ctx).Get(ctx, tag)
+		if err != nil || desc.Digest != dgst {
+			t.Fatalf("unexpected tag %s: %v %v", tag, desc.Digest, err)
+		}
+		if _, err := dstManifests.Get(ctx, dgst); err != nil {
+			t.Fatalf("unexpected error getting manifest %s: %v", dgst, err)
+		}
+	}
+	if _, err := dst.Blobs(ctx).Stat(ctx, firstLayer); err != nil {
+		t.Fatalf("unexpected error stating layer %s: %v", firstLayer, err)
+	}
+
+	// Importing again is a no-op.
+	if err := ImportOCILayout(ctx, dst, bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("unexpected error importing again: %v", err)
+	}
+
+	// A blob whose content does not match its digest is rejected.
+	corrupted := rewriteTar(t, archive.Bytes(), func(name string, content []byte) []byte {
+		if name == "blobs/sha256/"+firstLayer.Encoded() {
+			content[0] ^= 0xff
+		}
+		return content
+	})
+	other := makeRepository(t, createRegistry(t, inmemory.New()), "foo/other")
+	if err := ImportOCILayout(ctx, other, bytes.NewReader(corrupted)); err == nil || !strings.Contains(err.Error(), firstLayer.String()) {
+		t.Fatalf("expected digest error importing corrupted archive, got %v", err)
+	}
+
+	// So is an archive which is not an image layout.
+	if err := ImportOCILayout(ctx, other, bytes.NewReader(rewriteTar(t, archive.Bytes(), func(name string, content []byte) []byte {
+		if name == v1.ImageLayoutFile {
+			return nil
+		}
+		return content
+	}))); err == nil {
+		t.Fatal("expected error importing archive without oci-layout")
+	}
+
+	// Corrupted blob data is detected on export.
+	p, err := pathFor(blobDataPathSpec{digest: firstLayer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := d.GetContent(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[0] ^= 0xff
+	if err := d.PutContent(ctx, p, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := ExportOCILayout(ctx, src, io.Discard); err == nil {
+		t.Fatal("expected error exporting corrupted blob")
+	}
+}
+
+// readTar returns the content of the regular files of archive by name.
+func readTar(t *testing.T, archive []byte) map[string][]byte {
+	files := make(map[string][]byte)
+	rewriteTar(t, archive, func(name string, content []byte) []byte {
+		files[name] = content
+		return content
+	})
+	return files
+}
+
+// rewriteTar returns archive with the content of its regular files replaced
+// by fn. Files for which fn returns nil are removed.
+func rewriteTar(t *testing.T, archive []byte, fn func(name string, content []byte) []byte) []byte {
+	var out bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(archive))
+	tw := tar.NewWriter(&out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if content = fn(hdr.Name, content); content == nil {
+				continue
+			}
+			hdr.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
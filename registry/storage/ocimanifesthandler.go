@@ -18,6 +18,9 @@ type ocischemaManifestHandler struct {
 	blobStore    distribution.BlobStore
 	ctx          context.Context
 	manifestURLs manifestURLs
+
+	// verificationLimit is the number of references checked concurrently.
+	verificationLimit int
 }
 
 var _ ManifestHandler = &ocischemaManifestHandler{}
@@ -63,8 +66,6 @@ func (ms *ocischemaManifestHandler) Put(ctx context.Context, manifest distributi
 // perspective of the registry. As a policy, the registry only tries to store
 // valid content, leaving trust policies of that content up to consumers.
 func (ms *ocischemaManifestHandler) verifyManifest(ctx context.Context, mnfst ocischema.DeserializedManifest, skipDependencyVerification bool) error {
-	var errs distribution.ErrManifestVerification
-
 	if mnfst.Manifest.SchemaVersion != 2 {
 		return fmt.Errorf("unrecognized manifest schema version %d", mnfst.Manifest.SchemaVersion)
 	}
@@ -80,64 +81,66 @@ func (ms *ocischemaManifestHandler) verifyManifest(ctx context.Context, mnfst oc
 
 	blobsService := ms.repository.Blobs(ctx)
 
-	for _, descriptor := range mnfst.References() {
-		err := descriptor.Digest.Validate()
-		if err != nil {
-			errs = append(errs, err, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest})
-			continue
-		}
+	return verifyReferences(ctx, ms.verificationLimit, mnfst.References(), func(ctx context.Context, descriptor distribution.Descriptor) []error {
+		return ms.verifyReference(ctx, manifestService, blobsService, descriptor)
+	})
+}
 
-		switch descriptor.MediaType {
-		case v1.MediaTypeImageLayer, v1.MediaTypeImageLayerGzip, v1.MediaTypeImageLayerNonDistributable, v1.MediaTypeImageLayerNonDistributableGzip:
-			allow := ms.manifestURLs.allow
-			deny := ms.manifestURLs.deny
-			for _, u := range descriptor.URLs {
-				var pu *url.URL
-				pu, err = url.Parse(u)
-				if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Fragment != "" || (allow != nil && !allow.MatchString(u)) || (deny != nil && deny.MatchString(u)) {
-					err = errInvalidURL
-					break
-				}
-			}
-			if err == nil {
-				// check the presence if it is normal layer or
-				// there is no urls for non-distributable
-				if len(descriptor.URLs) == 0 ||
-					(descriptor.MediaType == v1.MediaTypeImageLayer || descriptor.MediaType == v1.MediaTypeImageLayerGzip) {
-
-					_, err = blobsService.Stat(ctx, descriptor.Digest)
-				}
-			}
+// verifyReference returns the errors making the descriptor an invalid
+// reference of a manifest.
+func (ms *ocischemaManifestHandler) verifyReference(ctx context.Context, manifestService distribution.ManifestService, blobsService distribution.BlobStatter, descriptor distribution.Descriptor) []error {
+	err := descriptor.Digest.Validate()
+	if err != nil {
+		return []error{err, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest}}
+	}
 
-		case v1.MediaTypeImageManifest:
-			var exists bool
-			exists, err = manifestService.Exists(ctx, descriptor.Digest)
-			if err != nil || !exists {
-				err = distribution.ErrBlobUnknown // just coerce to unknown.
+	switch descriptor.MediaType {
+	case v1.MediaTypeImageLayer, v1.MediaTypeImageLayerGzip, MediaTypeImageLayerZstd,
+		v1.MediaTypeImageLayerNonDistributable, v1.MediaTypeImageLayerNonDistributableGzip, MediaTypeImageLayerNonDistributableZstd:
+		allow := ms.manifestURLs.allow
+		deny := ms.manifestURLs.deny
+		for _, u := range descriptor.URLs {
+			var pu *url.URL
+			pu, err = url.Parse(u)
+			if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Fragment != "" || (allow != nil && !allow.MatchString(u)) || (deny != nil && deny.MatchString(u)) {
+				err = errInvalidURL
+				break
 			}
+		}
+		if err == nil {
+			// check the presence if it is normal layer or
+			// there is no urls for non-distributable
+			if len(descriptor.URLs) == 0 || !nonDistributableLayer(descriptor.MediaType) {
 
-			if err != nil {
-				dcontext.GetLogger(ms.ctx).WithError(err).Debugf("failed to ensure exists of %v in manifest service", descriptor.Digest)
+				_, err = blobsService.Stat(ctx, descriptor.Digest)
 			}
-			fallthrough // double check the blob store.
-		default:
-			// check the presence
-			_, err = blobsService.Stat(ctx, descriptor.Digest)
 		}
 
-		if err != nil {
-			if err != distribution.ErrBlobUnknown {
-				errs = append(errs, err)
-			}
+	case v1.MediaTypeImageManifest:
+		var exists bool
+		exists, err = manifestService.Exists(ctx, descriptor.Digest)
+		if err != nil || !exists {
+			err = distribution.ErrBlobUnknown // just coerce to unknown.
+		}
 
-			// On error here, we always append unknown blob errors.
-			errs = append(errs, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest})
+		if err != nil {
+			dcontext.GetLogger(ms.ctx).WithError(err).Debugf("failed to ensure exists of %v in manifest service", descriptor.Digest)
 		}
+		fallthrough // double check the blob store.
+	default:
+		// check the presence
+		_, err = blobsService.Stat(ctx, descriptor.Digest)
+	}
+
+	if err == nil {
+		return nil
 	}
 
-	if len(errs) != 0 {
-		return errs
+	var errs []error
+	if err != distribution.ErrBlobUnknown {
+		errs = append(errs, err)
 	}
 
-	return nil
+	// On error here, we always append unknown blob errors.
+	return append(errs, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest})
 }
@@ -39,6 +39,12 @@ func TestVerifyOCIManifestNonDistributableLayer(t *testing.T) {
 		MediaType: v1.MediaTypeImageLayerNonDistributableGzip,
 	}
 
+	nonDistributableZstdLayer := distribution.Descriptor{
+		Digest:    "sha256:7b6a1c5e2b36e5ac5f1b35e1a5d2c9a0c0fb7d3d1c2bcf4a2bd9bd1e4a6c0f1d",
+		Size:      6323,
+		MediaType: MediaTypeImageLayerNonDistributableZstd,
+	}
+
 	emptyLayer := distribution.Descriptor{
 		Digest: "",
 	}
@@ -118,6 +124,16 @@ func TestVerifyOCIManifestNonDistributableLayer(t *testing.T) {
 			[]string{"https://foo/bar"},
 			nil,
 		},
+		{
+			nonDistributableZstdLayer,
+			[]string{"https://foo/bar"},
+			nil,
+		},
+		{
+			nonDistributableZstdLayer,
+			[]string{"http://foo/nope"},
+			errInvalidURL,
+		},
 		{
 			emptyLayer,
 			[]string{"https://foo/empty"},
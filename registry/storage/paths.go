@@ -27,11 +27,20 @@ const (
 //	├── blobs
 //	│   └── <algorithm>
 //	│       └── <split directory content addressable storage>
+//	├── refindex
+//	│   └── <blob reference counts, when maintained>
+//	├── replication
+//	│   └── <copies pending replication>
 //	└── repositories
 //	    └── <name>
 //	        ├── _layers
 //	        │   └── <layer links to blob store>
 //	        ├── _manifests
+//	        │   ├── referrers
+//	        │   │   └── <subject manifest digest path>
+//	        │   │       └── <algorithm>
+//	        │   │           └── <hex digest>
+//	        │   │               └── entry
 //	        │   ├── revisions
 //	        │   │   └── <manifest digest path>
 //	        │   │       └── link
@@ -43,12 +52,18 @@ const (
 //	        │               └── <algorithm>
 //	        │                   └── <hex digest>
 //	        │                       └── link
+//	        ├── _trash
+//	        │   └── <deletion time>
+//	        │       └── <links deleted, at their path below <name>>
 //	        └── _uploads
 //	            └── <id>
 //	                ├── data
 //	                ├── hashstates
 //	                │   └── <algorithm>
 //	                │       └── <offset>
+//	                ├── staged
+//	                │   └── <algorithm>
+//	                │       └── <hex digest>
 //	                └── startedat
 //
 // The storage backend layout is broken up into a content-addressable blob
@@ -73,13 +88,20 @@ const (
 // implied as to the ordering of changes to a manifest. The tag store provides
 // support for name, tag lookups of manifests, using "current/link" under a
 // named tag directory. An index is maintained to support deletions of all
-// revisions of a given manifest tag.
+// revisions of a given manifest tag. The referrers store indexes the
+// manifests by the manifest they refer to as their subject, each entry
+// describing a referrer so that they can be listed without being read.
+//
+// When soft deletion is enabled, the links of deleted manifests and tags are
+// moved to the trash directory of the repository, under a directory named
+// after the time of their deletion, rather than removed.
 //
 // We cover the path formats implemented by this path mapper below.
 //
 //	Repositories:
 //
 //	repositoriesRootPathSpec:     <root>/v2/repositories
+//	repositoryPathSpec:           <root>/v2/repositories/<name>
 //
 //	Manifests:
 //
@@ -87,6 +109,13 @@ const (
 //	manifestRevisionsPathSpec:     <root>/v2/repositories/<name>/_manifests/revisions/
 //	manifestRevisionPathSpec:      <root>/v2/repositories/<name>/_manifests/revisions/<algorithm>/<hex digest>/
 //	manifestRevisionLinkPathSpec:  <root>/v2/repositories/<name>/_manifests/revisions/<algorithm>/<hex digest>/link
+//	manifestRevisionMediaTypePathSpec: <root>/v2/repositories/<name>/_manifests/revisions/<algorithm>/<hex digest>/mediatype
+//	manifestRevisionReferrerPathSpec:  <root>/v2/repositories/<name>/_manifests/revisions/<algorithm>/<hex digest>/referrer
+//
+//	Referrers:
+//
+//	manifestReferrersPathSpec:     <root>/v2/repositories/<name>/_manifests/referrers/<algorithm>/<hex digest>/
+//	manifestReferrerPathSpec:      <root>/v2/repositories/<name>/_manifests/referrers/<algorithm>/<hex digest>/<algorithm>/<hex digest>/entry
 //
 //	Tags:
 //
@@ -104,9 +133,15 @@ const (
 //
 //	Uploads:
 //
+//	uploadsPathSpec:                <root>/v2/repositories/<name>/_uploads
 //	uploadDataPathSpec:             <root>/v2/repositories/<name>/_uploads/<id>/data
 //	uploadStartedAtPathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/startedat
 //	uploadHashStatePathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/hashstates/<algorithm>/<offset>
+//	uploadStagedPathSpec:           <root>/v2/repositories/<name>/_uploads/<id>/staged/<algorithm>/<hex digest>
+//
+//	Trash:
+//
+//	trashPathSpec:                  <root>/v2/repositories/<name>/_trash
 //
 //	Blob Store:
 //
@@ -114,6 +149,26 @@ const (
 //	blobPathSpec:                   <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>
 //	blobDataPathSpec:               <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
 //
+//	Blob reference index:
+//
+//	refIndexPathSpec:               <root>/v2/refindex
+//	refIndexBuiltAtPathSpec:        <root>/v2/refindex/builtat
+//	refCountPathSpec:               <root>/v2/refindex/counts/<algorithm>/<first two hex bytes of digest>/<hex digest>
+//	refCountsPathSpec:              <root>/v2/refindex/counts
+//	refUnreferencedPathSpec:        <root>/v2/refindex/unreferenced/<algorithm>/<hex digest>
+//	refUnreferencedRootPathSpec:    <root>/v2/refindex/unreferenced
+//	refJournalPathSpec:             <root>/v2/refindex/journal
+//	refJournalEntryPathSpec:        <root>/v2/refindex/journal/<id>
+//
+//	Locks:
+//
+//	lockPathSpec:                   <root>/v2/locks/<name>
+//
+//	Replication:
+//
+//	replicationJournalPathSpec:       <root>/v2/replication
+//	replicationJournalEntryPathSpec:  <root>/v2/replication/<hex digest of path>
+//
 // For more information on the semantic meaning of each path and their
 // contents, please see the path spec documentation.
 func pathFor(spec pathSpec) (string, error) {
@@ -154,6 +209,38 @@ func pathFor(spec pathSpec) (string, error) {
 		}
 
 		return path.Join(root, "link"), nil
+	case manifestRevisionMediaTypePathSpec:
+		root, err := pathFor(manifestRevisionPathSpec(v))
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, "mediatype"), nil
+	case manifestRevisionReferrerPathSpec:
+		root, err := pathFor(manifestRevisionPathSpec(v))
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, "referrer"), nil
+	case manifestReferrersPathSpec:
+		components, err := digestPathComponents(v.subject, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(repoPrefix, v.name, "_manifests", "referrers"), components...)...), nil
+	case manifestReferrerPathSpec:
+		root, err := pathFor(manifestReferrersPathSpec{name: v.name, subject: v.subject})
+		if err != nil {
+			return "", err
+		}
+		components, err := digestPathComponents(v.referrer, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append([]string{root}, components...), "entry")...), nil
 	case manifestTagsPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_manifests", "tags")...), nil
 	case manifestTagPathSpec:
@@ -238,6 +325,8 @@ func pathFor(spec pathSpec) (string, error) {
 		blobPathPrefix := append(rootPrefix, "blobs")
 		return path.Join(append(blobPathPrefix, components...)...), nil
 
+	case uploadsPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_uploads")...), nil
 	case uploadDataPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "data")...), nil
 	case uploadStartedAtPathSpec:
@@ -248,8 +337,44 @@ func pathFor(spec pathSpec) (string, error) {
 			offset = "" // Limit to the prefix for listing offsets.
 		}
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "hashstates", string(v.alg), offset)...), nil
+	case uploadStagedPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "staged", string(v.digest.Algorithm()), v.digest.Encoded())...), nil
 	case repositoriesRootPathSpec:
 		return path.Join(repoPrefix...), nil
+	case repositoryPathSpec:
+		return path.Join(append(repoPrefix, v.name)...), nil
+	case trashPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_trash")...), nil
+	case refIndexPathSpec:
+		return path.Join(append(rootPrefix, "refindex")...), nil
+	case refIndexBuiltAtPathSpec:
+		return path.Join(append(rootPrefix, "refindex", "builtat")...), nil
+	case refCountsPathSpec:
+		return path.Join(append(rootPrefix, "refindex", "counts")...), nil
+	case refCountPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+		return path.Join(append(append(rootPrefix, "refindex", "counts"), components...)...), nil
+	case refUnreferencedRootPathSpec:
+		return path.Join(append(rootPrefix, "refindex", "unreferenced")...), nil
+	case refUnreferencedPathSpec:
+		components, err := digestPathComponents(v.digest, false)
+		if err != nil {
+			return "", err
+		}
+		return path.Join(append(append(rootPrefix, "refindex", "unreferenced"), components...)...), nil
+	case refJournalPathSpec:
+		return path.Join(append(rootPrefix, "refindex", "journal")...), nil
+	case refJournalEntryPathSpec:
+		return path.Join(append(rootPrefix, "refindex", "journal", v.id)...), nil
+	case lockPathSpec:
+		return path.Join(append(rootPrefix, "locks", v.name)...), nil
+	case replicationJournalPathSpec:
+		return path.Join(append(rootPrefix, "replication")...), nil
+	case replicationJournalEntryPathSpec:
+		return path.Join(append(rootPrefix, "replication", digest.FromString(v.path).Encoded())...), nil
 	default:
 		// TODO(sday): This is an internal error. Ensure it doesn't escape (panic?).
 		return "", fmt.Errorf("unknown path spec: %#v", v)
@@ -298,6 +423,47 @@ type manifestRevisionLinkPathSpec struct {
 
 func (manifestRevisionLinkPathSpec) pathSpec() {}
 
+// manifestRevisionMediaTypePathSpec describes the path components of the file
+// recording the media type of a revision of a manifest, so that the manifest
+// can be described without reading it. Manifests put by older versions have
+// none.
+type manifestRevisionMediaTypePathSpec struct {
+	name     string
+	revision digest.Digest
+}
+
+func (manifestRevisionMediaTypePathSpec) pathSpec() {}
+
+// manifestRevisionReferrerPathSpec describes the path components of the file
+// recording the referrers entry of a revision of a manifest which has a
+// subject, so that the entry can be removed and restored along with the
+// revision without reading the manifest.
+type manifestRevisionReferrerPathSpec struct {
+	name     string
+	revision digest.Digest
+}
+
+func (manifestRevisionReferrerPathSpec) pathSpec() {}
+
+// manifestReferrersPathSpec describes the path components of the directory
+// of the entries of the manifests referring to the manifest subject.
+type manifestReferrersPathSpec struct {
+	name    string
+	subject digest.Digest
+}
+
+func (manifestReferrersPathSpec) pathSpec() {}
+
+// manifestReferrerPathSpec describes the path components of the entry of the
+// manifest referrer, referring to the manifest subject.
+type manifestReferrerPathSpec struct {
+	name     string
+	subject  digest.Digest
+	referrer digest.Digest
+}
+
+func (manifestReferrerPathSpec) pathSpec() {}
+
 // manifestTagsPathSpec describes the path elements required to point to the
 // manifest tags directory.
 type manifestTagsPathSpec struct {
@@ -408,6 +574,14 @@ type blobDataPathSpec struct {
 
 func (blobDataPathSpec) pathSpec() {}
 
+// uploadsPathSpec defines the path of the uploads in progress of a
+// repository.
+type uploadsPathSpec struct {
+	name string
+}
+
+func (uploadsPathSpec) pathSpec() {}
+
 // uploadDataPathSpec defines the path parameters of the data file for
 // uploads.
 type uploadDataPathSpec struct {
@@ -445,11 +619,116 @@ type uploadHashStatePathSpec struct {
 
 func (uploadHashStatePathSpec) pathSpec() {}
 
+// uploadStagedPathSpec defines the path parameters of the object uploaded
+// by a client straight to the storage backend for the blob with the given
+// digest. Keying the object by digest ensures that a client redirected
+// several times, for different digests, cannot complete the upload with
+// content uploaded for another digest.
+type uploadStagedPathSpec struct {
+	name   string
+	id     string
+	digest digest.Digest
+}
+
+func (uploadStagedPathSpec) pathSpec() {}
+
 // repositoriesRootPathSpec returns the root of repositories
 type repositoriesRootPathSpec struct{}
 
 func (repositoriesRootPathSpec) pathSpec() {}
 
+// repositoryPathSpec describes the directory of a repository.
+type repositoryPathSpec struct {
+	name string
+}
+
+func (repositoryPathSpec) pathSpec() {}
+
+// trashPathSpec describes the directory holding the links deleted from a
+// repository while soft deletion is enabled.
+type trashPathSpec struct {
+	name string
+}
+
+func (trashPathSpec) pathSpec() {}
+
+// refIndexPathSpec describes the directory of the blob reference index.
+type refIndexPathSpec struct{}
+
+func (refIndexPathSpec) pathSpec() {}
+
+// refIndexBuiltAtPathSpec describes the file holding the time the blob
+// reference index was last rebuilt. The index is only used by garbage
+// collection once built.
+type refIndexBuiltAtPathSpec struct{}
+
+func (refIndexBuiltAtPathSpec) pathSpec() {}
+
+// refCountsPathSpec describes the directory of the reference counts of the
+// blobs.
+type refCountsPathSpec struct{}
+
+func (refCountsPathSpec) pathSpec() {}
+
+// refCountPathSpec describes the file holding the number of references to a
+// blob from the manifests of all repositories.
+type refCountPathSpec struct {
+	digest digest.Digest
+}
+
+func (refCountPathSpec) pathSpec() {}
+
+// refUnreferencedRootPathSpec describes the directory of the markers of the
+// blobs with no reference left.
+type refUnreferencedRootPathSpec struct{}
+
+func (refUnreferencedRootPathSpec) pathSpec() {}
+
+// refUnreferencedPathSpec describes the marker of a blob with no reference
+// left, which holds its digest.
+type refUnreferencedPathSpec struct {
+	digest digest.Digest
+}
+
+func (refUnreferencedPathSpec) pathSpec() {}
+
+// refJournalPathSpec describes the directory of the journal of the updates
+// of the reference counts.
+type refJournalPathSpec struct{}
+
+func (refJournalPathSpec) pathSpec() {}
+
+// refJournalEntryPathSpec describes the journal entry of an update of the
+// reference counts.
+type refJournalEntryPathSpec struct {
+	id string
+}
+
+func (refJournalEntryPathSpec) pathSpec() {}
+
+// lockPathSpec describes the file of the storage lock name, shared by the
+// registry instances using the same storage.
+type lockPathSpec struct {
+	name string
+}
+
+func (lockPathSpec) pathSpec() {}
+
+// replicationJournalPathSpec describes the directory of the journal of
+// copies pending replication to the secondary storage driver.
+type replicationJournalPathSpec struct{}
+
+func (replicationJournalPathSpec) pathSpec() {}
+
+// replicationJournalEntryPathSpec describes the journal entry of the copy of
+// path to the secondary storage driver. Entries are keyed by the digest of
+// path, so that a path is journaled once.
+type replicationJournalEntryPathSpec struct {
+	path string
+}
+
+func (replicationJournalEntryPathSpec) pathSpec() {}
+
 // digestPathComponents provides a consistent path breakdown for a given
 // digest. For a generic digest, it will be as follows:
 //
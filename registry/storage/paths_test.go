@@ -3,6 +3,7 @@ package storage
 import (
 	"testing"
 
+	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -25,6 +26,35 @@ func TestPathMapper(t *testing.T) {
 			},
 			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/revisions/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/link",
 		},
+		{
+			spec: manifestRevisionMediaTypePathSpec{
+				name:     "foo/bar",
+				revision: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/revisions/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/mediatype",
+		},
+		{
+			spec: manifestRevisionReferrerPathSpec{
+				name:     "foo/bar",
+				revision: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/revisions/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/referrer",
+		},
+		{
+			spec: manifestReferrersPathSpec{
+				name:    "foo/bar",
+				subject: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/referrers/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+		},
+		{
+			spec: manifestReferrerPathSpec{
+				name:     "foo/bar",
+				subject:  "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+				referrer: "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/referrers/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/sha256/0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef/entry",
+		},
 		{
 			spec: manifestTagsPathSpec{
 				name: "foo/bar",
@@ -69,6 +99,10 @@ func TestPathMapper(t *testing.T) {
 			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/tags/thetag/index/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/link",
 		},
 
+		{
+			spec:     uploadsPathSpec{name: "foo/bar"},
+			expected: "/docker/registry/v2/repositories/foo/bar/_uploads",
+		},
 		{
 			spec: uploadDataPathSpec{
 				name: "foo/bar",
@@ -83,10 +117,34 @@ func TestPathMapper(t *testing.T) {
 			},
 			expected: "/docker/registry/v2/repositories/foo/bar/_uploads/asdf-asdf-asdf-adsf/startedat",
 		},
+		{
+			spec: uploadStagedPathSpec{
+				name:   "foo/bar",
+				id:     "asdf-asdf-asdf-adsf",
+				digest: "sha256:abcdef0123456789",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_uploads/asdf-asdf-asdf-adsf/staged/sha256/abcdef0123456789",
+		},
 		{
 			spec:     layersPathSpec{name: "foo/bar"},
 			expected: "/docker/registry/v2/repositories/foo/bar/_layers",
 		},
+		{
+			spec:     repositoryPathSpec{name: "foo/bar"},
+			expected: "/docker/registry/v2/repositories/foo/bar",
+		},
+		{
+			spec:     trashPathSpec{name: "foo/bar"},
+			expected: "/docker/registry/v2/repositories/foo/bar/_trash",
+		},
+		{
+			spec:     replicationJournalPathSpec{},
+			expected: "/docker/registry/v2/replication",
+		},
+		{
+			spec:     replicationJournalEntryPathSpec{path: "/docker/registry/v2/repositories/foo/bar/_layers/sha256/abcdef/link"},
+			expected: "/docker/registry/v2/replication/2fc1912ca8a3bc0f0b0eb238d632b33a05baa76838cbe2108269d90b7218d34c",
+		},
 	} {
 		p, err := pathFor(testcase.spec)
 		if err != nil {
@@ -110,6 +168,86 @@ func TestPathMapper(t *testing.T) {
 	}
 }
 
+func TestNamespacePathMapper(t *testing.T) {
+	reg := &registry{}
+	if err := WithNamespacePrefix("team-a/ci/")(reg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	repo := &repository{registry: reg, name: mustNamed(t, "foo/bar")}
+
+	for _, testcase := range []struct {
+		spec     pathSpec
+		expected string
+	}{
+		{
+			spec:     manifestTagsPathSpec{name: repo.pathName()},
+			expected: "/docker/registry/v2/repositories/team-a/ci/foo/bar/_manifests/tags",
+		},
+		{
+			spec:     layersPathSpec{name: repo.pathName()},
+			expected: "/docker/registry/v2/repositories/team-a/ci/foo/bar/_layers",
+		},
+		{
+			spec:     uploadDataPathSpec{name: repo.pathName(), id: "asdf-asdf-asdf-adsf"},
+			expected: "/docker/registry/v2/repositories/team-a/ci/foo/bar/_uploads/asdf-asdf-asdf-adsf/data",
+		},
+		{
+			// Blobs are shared by all namespaces.
+			spec:     blobDataPathSpec{digest: digest.Digest("sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789")},
+			expected: "/docker/registry/v2/blobs/sha256/ab/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/data",
+		},
+	} {
+		p, err := pathFor(testcase.spec)
+		if err != nil {
+			t.Fatalf("unexpected generating path (%T): %v", testcase.spec, err)
+		}
+		if p != testcase.expected {
+			t.Fatalf("unexpected path generated (%T): %q != %q", testcase.spec, p, testcase.expected)
+		}
+	}
+
+	root, err := reg.repositoriesRoot()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if root != "/docker/registry/v2/repositories/team-a/ci" {
+		t.Fatalf("unexpected repositories root: %q", root)
+	}
+}
+
+func TestWithNamespacePrefix(t *testing.T) {
+	for prefix, valid := range map[string]bool{
+		"team-a":       true,
+		"team-a/":      true,
+		"team_a/ci.x/": true,
+		"":             false,
+		"/":            false,
+		"/team-a":      false,
+		"team-a//ci":   false,
+		"Team-A":       false,
+		"team-a/-ci":   false,
+		"../team-a":    false,
+		"team-a:5000/": false,
+	} {
+		err := WithNamespacePrefix(prefix)(&registry{})
+		if valid && err != nil {
+			t.Errorf("unexpected error for prefix %q: %v", prefix, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("expected error for prefix %q", prefix)
+		}
+	}
+}
+
+func mustNamed(t *testing.T, name string) reference.Named {
+	t.Helper()
+	named, err := reference.WithName(name)
+	if err != nil {
+		t.Fatalf("unexpected error parsing name %q: %v", name, err)
+	}
+	return named
+}
+
 func TestDigestFromPath(t *testing.T) {
 	for _, testcase := range []struct {
 		path       string
@@ -18,11 +18,11 @@ type uploadData struct {
 	startedAt     time.Time
 }
 
-func newUploadData() uploadData {
+func newUploadData(now time.Time) uploadData {
 	return uploadData{
 		containingDir: "",
 		// default to far in future to protect against missing startedat
-		startedAt: time.Now().Add(10000 * time.Hour),
+		startedAt: now.Add(10000 * time.Hour),
 	}
 }
 
@@ -30,8 +30,13 @@ func newUploadData() uploadData {
 // created before olderThan.  The list of files deleted and errors
 // encountered are returned
 func PurgeUploads(ctx context.Context, driver storageDriver.StorageDriver, olderThan time.Time, actuallyDelete bool) ([]string, []error) {
+	return purgeUploads(ctx, driver, olderThan, actuallyDelete, time.Now)
+}
+
+// purgeUploads implements PurgeUploads, with now returning the current time.
+func purgeUploads(ctx context.Context, driver storageDriver.StorageDriver, olderThan time.Time, actuallyDelete bool, now func() time.Time) ([]string, []error) {
 	logrus.Infof("PurgeUploads starting: olderThan=%s, actuallyDelete=%t", olderThan, actuallyDelete)
-	uploadData, errors := getOutstandingUploads(ctx, driver)
+	uploadData, errors := getOutstandingUploads(ctx, driver, now())
 	var deleted []string
 	for _, uploadData := range uploadData {
 		if uploadData.startedAt.Before(olderThan) {
@@ -56,8 +61,9 @@ func PurgeUploads(ctx context.Context, driver storageDriver.StorageDriver, older
 // getOutstandingUploads walks the upload directory, collecting files
 // which could be eligible for deletion.  The only reliable way to
 // classify the age of a file is with the date stored in the startedAt
-// file, so gather files by UUID with a date from startedAt.
-func getOutstandingUploads(ctx context.Context, driver storageDriver.StorageDriver) (map[string]uploadData, []error) {
+// file, so gather files by UUID with a date from startedAt. Uploads
+// missing it are dated far in the future from now.
+func getOutstandingUploads(ctx context.Context, driver storageDriver.StorageDriver, now time.Time) (map[string]uploadData, []error) {
 	var errors []error
 	uploads := make(map[string]uploadData)
 
@@ -87,7 +93,7 @@ func getOutstandingUploads(ctx context.Context, driver storageDriver.StorageDriv
 		}
 		ud, ok := uploads[uuid]
 		if !ok {
-			ud = newUploadData()
+			ud = newUploadData(now)
 		}
 		if isContainingDir {
 			ud.containingDir = filePath
@@ -12,8 +12,15 @@ import (
 	"github.com/google/uuid"
 )
 
+// testNow is the time of the clock shared by the test drivers and the purger.
+var testNow = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func testClock() time.Time {
+	return testNow
+}
+
 func testUploadFS(t *testing.T, numUploads int, repoName string, startedAt time.Time) (driver.StorageDriver, context.Context) {
-	d := inmemory.New()
+	d := inmemory.NewWithParameters(inmemory.DriverParameters{Clock: testClock})
 	ctx := context.Background()
 	for i := 0; i < numUploads; i++ {
 		addUploads(ctx, t, d, uuid.NewString(), repoName, startedAt)
@@ -42,8 +49,8 @@ func addUploads(ctx context.Context, t *testing.T, d driver.StorageDriver, uploa
 
 func TestPurgeGather(t *testing.T) {
 	uploadCount := 5
-	fs, ctx := testUploadFS(t, uploadCount, "test-repo", time.Now())
-	uploadData, errs := getOutstandingUploads(ctx, fs)
+	fs, ctx := testUploadFS(t, uploadCount, "test-repo", testNow)
+	uploadData, errs := getOutstandingUploads(ctx, fs, testNow)
 	if len(errs) != 0 {
 		t.Errorf("Unexpected errors: %q", errs)
 	}
@@ -53,9 +60,9 @@ func TestPurgeGather(t *testing.T) {
 }
 
 func TestPurgeNone(t *testing.T) {
-	fs, ctx := testUploadFS(t, 10, "test-repo", time.Now())
-	oneHourAgo := time.Now().Add(-1 * time.Hour)
-	deleted, errs := PurgeUploads(ctx, fs, oneHourAgo, true)
+	fs, ctx := testUploadFS(t, 10, "test-repo", testNow)
+	oneHourAgo := testNow.Add(-1 * time.Hour)
+	deleted, errs := purgeUploads(ctx, fs, oneHourAgo, true, testClock)
 	if len(errs) != 0 {
 		t.Error("Unexpected errors", errs)
 	}
@@ -66,14 +73,14 @@ func TestPurgeNone(t *testing.T) {
 
 func TestPurgeAll(t *testing.T) {
 	uploadCount := 10
-	oneHourAgo := time.Now().Add(-1 * time.Hour)
+	oneHourAgo := testNow.Add(-1 * time.Hour)
 	fs, ctx := testUploadFS(t, uploadCount, "test-repo", oneHourAgo)
 
 	// Ensure > 1 repos are purged
 	addUploads(ctx, t, fs, uuid.NewString(), "test-repo2", oneHourAgo)
 	uploadCount++
 
-	deleted, errs := PurgeUploads(ctx, fs, time.Now(), true)
+	deleted, errs := purgeUploads(ctx, fs, testNow, true, testClock)
 	if len(errs) != 0 {
 		t.Error("Unexpected errors:", errs)
 	}
@@ -86,16 +93,16 @@ func TestPurgeAll(t *testing.T) {
 
 func TestPurgeSome(t *testing.T) {
 	oldUploadCount := 5
-	oneHourAgo := time.Now().Add(-1 * time.Hour)
+	oneHourAgo := testNow.Add(-1 * time.Hour)
 	fs, ctx := testUploadFS(t, oldUploadCount, "library/test-repo", oneHourAgo)
 
 	newUploadCount := 4
 
 	for i := 0; i < newUploadCount; i++ {
-		addUploads(ctx, t, fs, uuid.NewString(), "test-repo", time.Now().Add(1*time.Hour))
+		addUploads(ctx, t, fs, uuid.NewString(), "test-repo", testNow.Add(1*time.Hour))
 	}
 
-	deleted, errs := PurgeUploads(ctx, fs, time.Now(), true)
+	deleted, errs := purgeUploads(ctx, fs, testNow, true, testClock)
 	if len(errs) != 0 {
 		t.Error("Unexpected errors:", errs)
 	}
@@ -107,7 +114,7 @@ func TestPurgeSome(t *testing.T) {
 
 func TestPurgeOnlyUploads(t *testing.T) {
 	oldUploadCount := 5
-	oneHourAgo := time.Now().Add(-1 * time.Hour)
+	oneHourAgo := testNow.Add(-1 * time.Hour)
 	fs, ctx := testUploadFS(t, oldUploadCount, "test-repo", oneHourAgo)
 
 	// Create a directory tree outside _uploads and ensure
@@ -126,7 +133,7 @@ func TestPurgeOnlyUploads(t *testing.T) {
 		t.Fatalf("Unable to write data file")
 	}
 
-	deleted, errs := PurgeUploads(ctx, fs, time.Now(), true)
+	deleted, errs := purgeUploads(ctx, fs, testNow, true, testClock)
 	if len(errs) != 0 {
 		t.Error("Unexpected errors", errs)
 	}
@@ -138,7 +145,7 @@ func TestPurgeOnlyUploads(t *testing.T) {
 }
 
 func TestPurgeMissingStartedAt(t *testing.T) {
-	oneHourAgo := time.Now().Add(-1 * time.Hour)
+	oneHourAgo := testNow.Add(-1 * time.Hour)
 	fs, ctx := testUploadFS(t, 1, "test-repo", oneHourAgo)
 
 	err := fs.Walk(ctx, "/", func(fileInfo driver.FileInfo) error {
@@ -155,11 +162,20 @@ func TestPurgeMissingStartedAt(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Unexpected error during Walk: %s ", err.Error())
 	}
-	deleted, errs := PurgeUploads(ctx, fs, time.Now(), true)
+	deleted, errs := purgeUploads(ctx, fs, testNow, true, testClock)
 	if len(errs) > 0 {
 		t.Errorf("Unexpected errors")
 	}
 	if len(deleted) > 0 {
 		t.Errorf("Files unexpectedly deleted: %s", deleted)
 	}
+
+	// Without startedat, the upload is dated far in the future of the clock.
+	deleted, errs = purgeUploads(ctx, fs, testNow.Add(10001*time.Hour), true, testClock)
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors")
+	}
+	if len(deleted) != 1 {
+		t.Errorf("Unexpectedly deleted file count %d != 1", len(deleted))
+	}
 }
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+var _ distribution.ManifestReferrers = &manifestStore{}
+
+// referrerEntry is the entry of a manifest in the referrers of its subject.
+// It is also recorded along with the revision of the manifest, so that the
+// entry can be removed and restored without reading the manifest.
+type referrerEntry struct {
+	Subject digest.Digest `json:"subject"`
+	distribution.Referrer
+}
+
+// referrerEntryOf returns the referrers entry of the manifest dgst, or nil if
+// it has no subject.
+func referrerEntryOf(dgst digest.Digest, mfst distribution.Manifest) (*referrerEntry, error) {
+	var (
+		subject      *distribution.Descriptor
+		artifactType string
+		annotations  map[string]string
+	)
+	switch m := mfst.(type) {
+	case *ocischema.DeserializedManifest:
+		subject, artifactType, annotations = m.Subject, m.ArtifactType, m.Annotations
+		if artifactType == "" {
+			artifactType = m.Config.MediaType
+		}
+	case *ocischema.DeserializedImageIndex:
+		subject, artifactType, annotations = m.Subject, m.ArtifactType, m.Annotations
+	}
+	if subject == nil {
+		return nil, nil
+	}
+	if err := subject.Digest.Validate(); err != nil {
+		return nil, err
+	}
+
+	mediaType, payload, err := mfst.Payload()
+	if err != nil {
+		return nil, err
+	}
+	return &referrerEntry{
+		Subject: subject.Digest,
+		Referrer: distribution.Referrer{
+			Descriptor: distribution.Descriptor{
+				MediaType:   mediaType,
+				Digest:      dgst,
+				Size:        int64(len(payload)),
+				Annotations: annotations,
+			},
+			ArtifactType: artifactType,
+		},
+	}, nil
+}
+
+// writeReferrer adds the manifest dgst to the referrers of its subject, if it
+// has one.
+func (ms *manifestStore) writeReferrer(ctx context.Context, dgst digest.Digest, mfst distribution.Manifest) error {
+	entry, err := referrerEntryOf(dgst, mfst)
+	if err != nil || entry == nil {
+		return err
+	}
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	bs := ms.blobStore.blobStore
+	name := ms.repository.pathName()
+	revisionPath, err := pathFor(manifestRevisionReferrerPathSpec{name: name, revision: dgst})
+	if err != nil {
+		return err
+	}
+	entryPath, err := pathFor(manifestReferrerPathSpec{name: name, subject: entry.Subject, referrer: dgst})
+	if err != nil {
+		return err
+	}
+	for _, p := range []string{revisionPath, entryPath} {
+		if err := bs.driver.PutContent(ctx, p, content); err != nil {
+			return err
+		}
+		if err := bs.replicate(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readReferrerEntry returns the referrers entry recorded along with the
+// revision dgst of the repository name, or nil if there is none.
+func readReferrerEntry(ctx context.Context, driver storagedriver.StorageDriver, name string, dgst digest.Digest) (*referrerEntry, error) {
+	revisionPath, err := pathFor(manifestRevisionReferrerPathSpec{name: name, revision: dgst})
+	if err != nil {
+		return nil, err
+	}
+	content, err := driver.GetContent(ctx, revisionPath)
+	if err != nil {
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entry referrerEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// removeReferrer removes the revision dgst of the repository name from the
+// referrers of its subject, if it has one. The referrers of the revision are
+// left in place: the spec lists them even once their subject is deleted.
+func removeReferrer(ctx context.Context, driver storagedriver.StorageDriver, name string, dgst digest.Digest) error {
+	entry, err := readReferrerEntry(ctx, driver, name, dgst)
+	if err != nil || entry == nil {
+		return err
+	}
+	entryPath, err := pathFor(manifestReferrerPathSpec{name: name, subject: entry.Subject, referrer: dgst})
+	if err != nil {
+		return err
+	}
+	err = driver.Delete(ctx, path.Dir(entryPath))
+	if errors.As(err, &storagedriver.PathNotFoundError{}) {
+		return nil
+	}
+	return err
+}
+
+// restoreReferrer adds the restored manifest dgst back to the referrers of its
+// subject, if it has one.
+func (repo *repository) restoreReferrer(ctx context.Context, dgst digest.Digest) error {
+	entry, err := readReferrerEntry(ctx, repo.driver, repo.pathName(), dgst)
+	if err != nil || entry == nil {
+		return err
+	}
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	entryPath, err := pathFor(manifestReferrerPathSpec{name: repo.pathName(), subject: entry.Subject, referrer: dgst})
+	if err != nil {
+		return err
+	}
+	if err := repo.driver.PutContent(ctx, entryPath, content); err != nil {
+		return err
+	}
+	return repo.blobStore.replicate(ctx, entryPath)
+}
+
+// Referrers returns the referrers of the manifest subject from their entries,
+// without reading the manifests.
+func (ms *manifestStore) Referrers(ctx context.Context, subject digest.Digest, artifactType string, last digest.Digest, n int) ([]distribution.Referrer, error) {
+	driver := ms.blobStore.blobStore.driver
+	root, err := pathFor(manifestReferrersPathSpec{name: ms.repository.pathName(), subject: subject})
+	if err != nil {
+		return nil, err
+	}
+	algorithms, err := driver.List(ctx, root)
+	if err != nil {
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
+			return []distribution.Referrer{}, nil
+		}
+		return nil, err
+	}
+
+	var digests []digest.Digest
+	for _, algorithmPath := range algorithms {
+		hexes, err := driver.List(ctx, algorithmPath)
+		if err != nil {
+			if errors.As(err, &storagedriver.PathNotFoundError{}) {
+				continue
+			}
+			return nil, err
+		}
+		for _, hexPath := range hexes {
+			dgst := digest.NewDigestFromEncoded(digest.Algorithm(path.Base(algorithmPath)), path.Base(hexPath))
+			if dgst.Validate() != nil || (last != "" && dgst <= last) {
+				continue
+			}
+			digests = append(digests, dgst)
+		}
+	}
+	sort.Slice(digests, func(i, j int) bool { return digests[i] < digests[j] })
+
+	referrers := []distribution.Referrer{}
+	for _, dgst := range digests {
+		if n > 0 && len(referrers) == n {
+			break
+		}
+		entryPath, err := pathFor(manifestReferrerPathSpec{name: ms.repository.pathName(), subject: subject, referrer: dgst})
+		if err != nil {
+			return nil, err
+		}
+		content, err := driver.GetContent(ctx, entryPath)
+		if err != nil {
+			// The entry is being removed.
+			if errors.As(err, &storagedriver.PathNotFoundError{}) {
+				continue
+			}
+			return nil, err
+		}
+		var entry referrerEntry
+		if err := json.Unmarshal(content, &entry); err != nil {
+			return nil, err
+		}
+		if artifactType != "" && entry.ArtifactType != artifactType {
+			continue
+		}
+		referrers = append(referrers, entry.Referrer)
+	}
+	return referrers, nil
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// emptyJSON is the media type of the empty config of artifacts.
+const emptyJSON = "application/vnd.oci.empty.v1+json"
+
+// putReferrer puts a manifest of artifactType referring to subject, with no
+// layers, and returns its digest.
+func putReferrer(ctx context.Context, t *testing.T, repo distribution.Repository, subject digest.Digest, artifactType string, annotations map[string]string) digest.Digest {
+	t.Helper()
+	config, err := repo.Blobs(ctx).Put(ctx, emptyJSON, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.MediaType = emptyJSON
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned:    manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+		ArtifactType: artifactType,
+		Config:       config,
+		Layers:       []distribution.Descriptor{},
+		Subject:      &distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: subject, Size: 1},
+		Annotations:  annotations,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := ms.Put(ctx, m)
+	if err != nil {
+		t.Fatalf("referrer upload failed: %v", err)
+	}
+	return dgst
+}
+
+func TestReferrers(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := NewRegistry(ctx, d, EnableDelete, SoftDelete)
+	if err != nil {
+		t.Fatalf("failed to construct registry: %v", err)
+	}
+	named, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := manifests.(*manifestStore)
+
+	list := func(subject digest.Digest, artifactType string, last digest.Digest, n int) []digest.Digest {
+		t.Helper()
+		referrers, err := ms.Referrers(ctx, subject, artifactType, last, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests := []digest.Digest{}
+		for _, referrer := range referrers {
+			digests = append(digests, referrer.Digest)
+		}
+		return digests
+	}
+
+	subject, _ := pushTaggedImage(ctx, t, repo, "latest")
+	if listed := list(subject, "", "", 0); len(listed) != 0 {
+		t.Fatalf("expected no referrers, got %v", listed)
+	}
+
+	signature := putReferrer(ctx, t, repo, subject, "application/vnd.example.signature", map[string]string{"org.example.key": "value"})
+	sbom := putReferrer(ctx, t, repo, subject, "application/vnd.example.sbom", nil)
+	// The artifact type of a manifest without one is the media type of its
+	// config.
+	untyped := putReferrer(ctx, t, repo, subject, "", nil)
+
+	referrers, err := ms.Referrers(ctx, subject, "application/vnd.example.signature", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := ms.Stat(ctx, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []distribution.Referrer{{
+		Descriptor: distribution.Descriptor{
+			MediaType:   v1.MediaTypeImageManifest,
+			Digest:      signature,
+			Size:        desc.Size,
+			Annotations: map[string]string{"org.example.key": "value"},
+		},
+		ArtifactType: "application/vnd.example.signature",
+	}}
+	if !reflect.DeepEqual(referrers, expected) {
+		t.Fatalf("unexpected referrers: %+v != %+v", referrers, expected)
+	}
+	if listed := list(subject, emptyJSON, "", 0); !reflect.DeepEqual(listed, []digest.Digest{untyped}) {
+		t.Fatalf("unexpected referrers of the config media type: %v", listed)
+	}
+
+	// The referrers are listed by digest, a page at a time.
+	all := list(subject, "", "", 0)
+	if len(all) != 3 {
+		t.Fatalf("expected 3 referrers, got %v", all)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i-1] >= all[i] {
+			t.Fatalf("referrers are not sorted: %v", all)
+		}
+	}
+	if page := list(subject, "", "", 2); !reflect.DeepEqual(page, all[:2]) {
+		t.Fatalf("unexpected first page: %v != %v", page, all[:2])
+	}
+	if page := list(subject, "", all[1], 2); !reflect.DeepEqual(page, all[2:]) {
+		t.Fatalf("unexpected last page: %v != %v", page, all[2:])
+	}
+
+	// Deleting the subject leaves its referrers.
+	if err := repo.Tags(ctx).Untag(ctx, "latest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Delete(ctx, subject); err != nil {
+		t.Fatal(err)
+	}
+	if listed := list(subject, "", "", 0); len(listed) != 3 {
+		t.Fatalf("expected the referrers of the deleted subject, got %v", listed)
+	}
+
+	// Deleting a referrer removes it, and restoring it adds it back.
+	if err := ms.Delete(ctx, signature); err != nil {
+		t.Fatal(err)
+	}
+	if listed := list(subject, "application/vnd.example.signature", "", 0); len(listed) != 0 {
+		t.Fatalf("expected the deleted referrer to be removed, got %v", listed)
+	}
+	if err := Restore(ctx, repo, signature.String()); err != nil {
+		t.Fatal(err)
+	}
+	if listed := list(subject, "application/vnd.example.signature", "", 0); !reflect.DeepEqual(listed, []digest.Digest{signature}) {
+		t.Fatalf("expected the restored referrer, got %v", listed)
+	}
+
+	// Garbage collection removes the untagged referrers along with their
+	// entries, and keeps the tagged ones.
+	if err := repo.Tags(ctx).Tag(ctx, "sbom", distribution.Descriptor{Digest: sbom}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MarkAndSweep(ctx, d, registry, GCOpts{RemoveUntagged: true}); err != nil {
+		t.Fatalf("failed mark and sweep: %v", err)
+	}
+	if listed := list(subject, "", "", 0); !reflect.DeepEqual(listed, []digest.Digest{sbom}) {
+		t.Fatalf("expected the tagged referrer only, got %v", listed)
+	}
+}
@@ -0,0 +1,440 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/google/uuid"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/sync/errgroup"
+)
+
+// BlobReferenceIndex is a functional option for NewRegistry. It causes the
+// number of references to each blob from the manifests of all repositories
+// to be maintained as manifests are put, deleted and restored, so that
+// garbage collection can remove the unreferenced blobs without walking the
+// repositories, with GCOpts.Incremental. The index is only used once built
+// with RebuildBlobReferenceIndex. Updates interrupted by a crash are
+// completed from their journal by NewRegistry. The updates of the registry
+// instances sharing the storage are serialized by the "refindex" lock, see
+// Locks.
+func BlobReferenceIndex(registry *registry) error {
+	registry.refIndex = &blobRefIndex{driver: registry.driver}
+	return nil
+}
+
+// blobRefIndex maintains the reference counts of the blobs. A blob is
+// referenced once by each manifest linked in a repository, as the manifest
+// itself or by the descriptors of the manifest. Counts may be higher than
+// the actual number of references when an update fails halfway, but never
+// lower, so that a blob is never removed while referenced.
+type blobRefIndex struct {
+	driver storagedriver.StorageDriver
+
+	// lock serializes the updates of the counts, which are read, modified
+	// and written back, across the registry instances sharing the storage.
+	lock *sharedLock
+}
+
+// refIndexLockName is the name of the lock of the blob reference index.
+const refIndexLockName = "refindex"
+
+// refJournalEntry is the journal entry of an update of the reference counts.
+// It holds the counts to write, and is written before any of them is, so
+// that an interrupted update is completed by writing them again.
+type refJournalEntry struct {
+	Counts map[digest.Digest]int `json:"counts"`
+}
+
+// manifestBlobs returns the blobs referenced by the manifest dgst: the
+// manifest itself and the distinct blobs of its descriptors.
+func manifestBlobs(dgst digest.Digest, mfst distribution.Manifest) []digest.Digest {
+	blobs := []digest.Digest{dgst}
+	seen := map[digest.Digest]struct{}{dgst: {}}
+	for _, descriptor := range mfst.References() {
+		if _, ok := seen[descriptor.Digest]; ok {
+			continue
+		}
+		seen[descriptor.Digest] = struct{}{}
+		blobs = append(blobs, descriptor.Digest)
+	}
+	return blobs
+}
+
+// add counts the references of the manifest dgst, linked in a repository.
+func (idx *blobRefIndex) add(ctx context.Context, dgst digest.Digest, mfst distribution.Manifest) error {
+	return idx.update(ctx, 1, manifestBlobs(dgst, mfst))
+}
+
+// remove uncounts the references of the manifest dgst, unlinked from a
+// repository.
+func (idx *blobRefIndex) remove(ctx context.Context, dgst digest.Digest, mfst distribution.Manifest) error {
+	return idx.update(ctx, -1, manifestBlobs(dgst, mfst))
+}
+
+// update adds delta to the counts of blobs, journaling the counts before
+// writing them.
+func (idx *blobRefIndex) update(ctx context.Context, delta int, blobs []digest.Digest) error {
+	release, err := idx.lock.acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to lock blob reference index: %v", err)
+	}
+	defer release()
+
+	entry := refJournalEntry{Counts: make(map[digest.Digest]int, len(blobs))}
+	for _, dgst := range blobs {
+		count, indexed, err := idx.count(ctx, dgst)
+		if err != nil {
+			return err
+		}
+		if delta < 0 && count <= 0 {
+			// The blob was referenced before the index was built, or by
+			// links written around the registry: leave it for a rebuild
+			// rather than mark it unreferenced.
+			dcontext.GetLogger(ctx).Warnf("blob %s has no reference counted to remove (indexed: %t), the blob reference index needs a rebuild", dgst, indexed)
+			continue
+		}
+		entry.Counts[dgst] = count + delta
+	}
+	if len(entry.Counts) == 0 {
+		return nil
+	}
+
+	entryPath, err := pathFor(refJournalEntryPathSpec{id: uuid.NewString()})
+	if err != nil {
+		return err
+	}
+	p, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := idx.driver.PutContent(ctx, entryPath, p); err != nil {
+		return fmt.Errorf("failed to journal blob reference counts: %v", err)
+	}
+	if err := idx.apply(ctx, entry.Counts); err != nil {
+		return err
+	}
+	return idx.driver.Delete(ctx, entryPath)
+}
+
+// count returns the count of dgst, and whether it is indexed at all.
+func (idx *blobRefIndex) count(ctx context.Context, dgst digest.Digest) (int, bool, error) {
+	countPath, err := pathFor(refCountPathSpec{digest: dgst})
+	if err != nil {
+		return 0, false, err
+	}
+	p, err := idx.driver.GetContent(ctx, countPath)
+	if err != nil {
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(string(p)))
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid reference count of blob %s: %v", dgst, err)
+	}
+	return count, true, nil
+}
+
+// apply writes counts, marking the blobs counted zero as unreferenced.
+func (idx *blobRefIndex) apply(ctx context.Context, counts map[digest.Digest]int) error {
+	for dgst, count := range counts {
+		if err := idx.set(ctx, dgst, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set writes the count of dgst, and its unreferenced marker if it is zero.
+func (idx *blobRefIndex) set(ctx context.Context, dgst digest.Digest, count int) error {
+	countPath, err := pathFor(refCountPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+	markerPath, err := pathFor(refUnreferencedPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+	if err := idx.driver.PutContent(ctx, countPath, []byte(strconv.Itoa(count))); err != nil {
+		return fmt.Errorf("failed to write reference count of blob %s: %v", dgst, err)
+	}
+	if count == 0 {
+		err = idx.driver.PutContent(ctx, markerPath, []byte(dgst))
+	} else {
+		err = idx.driver.Delete(ctx, markerPath)
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to mark blob %s: %v", dgst, err)
+	}
+	return nil
+}
+
+// forget removes dgst from the index, once the blob is removed.
+func (idx *blobRefIndex) forget(ctx context.Context, dgst digest.Digest) error {
+	for _, spec := range []pathSpec{refCountPathSpec{digest: dgst}, refUnreferencedPathSpec{digest: dgst}} {
+		p, err := pathFor(spec)
+		if err != nil {
+			return err
+		}
+		if err := idx.driver.Delete(ctx, p); err != nil && !errors.As(err, &storagedriver.PathNotFoundError{}) {
+			return err
+		}
+	}
+	return nil
+}
+
+// recover completes the updates interrupted by a crash, from their journal.
+func (idx *blobRefIndex) recover(ctx context.Context) error {
+	release, err := idx.lock.acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to lock blob reference index: %v", err)
+	}
+	defer release()
+
+	journalPath, err := pathFor(refJournalPathSpec{})
+	if err != nil {
+		return err
+	}
+	entries, err := idx.driver.List(ctx, journalPath)
+	if err != nil {
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
+			return nil
+		}
+		return err
+	}
+	for _, entryPath := range entries {
+		p, err := idx.driver.GetContent(ctx, entryPath)
+		if err != nil {
+			return err
+		}
+		var entry refJournalEntry
+		if err := json.Unmarshal(p, &entry); err != nil {
+			// The entry was not fully written, so none of its counts
+			// were.
+			dcontext.GetLogger(ctx).Warnf("discarding incomplete blob reference journal entry %s: %v", path.Base(entryPath), err)
+		} else if err := idx.apply(ctx, entry.Counts); err != nil {
+			return err
+		}
+		if err := idx.driver.Delete(ctx, entryPath); err != nil {
+			return err
+		}
+		dcontext.GetLogger(ctx).Infof("completed blob reference journal entry %s", path.Base(entryPath))
+	}
+	return nil
+}
+
+// built returns an error if the index was never built.
+func (idx *blobRefIndex) built(ctx context.Context) error {
+	builtAtPath, err := pathFor(refIndexBuiltAtPathSpec{})
+	if err != nil {
+		return err
+	}
+	if _, err := idx.driver.Stat(ctx, builtAtPath); err != nil {
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
+			return fmt.Errorf("the blob reference index is not built, rebuild it first")
+		}
+		return err
+	}
+	return nil
+}
+
+// invalidate marks the index as not built, once the links were changed
+// without updating it.
+func (idx *blobRefIndex) invalidate(ctx context.Context) error {
+	builtAtPath, err := pathFor(refIndexBuiltAtPathSpec{})
+	if err != nil {
+		return err
+	}
+	err = idx.driver.Delete(ctx, builtAtPath)
+	if errors.As(err, &storagedriver.PathNotFoundError{}) {
+		return nil
+	}
+	return err
+}
+
+// RefIndexOpts contains options for RebuildBlobReferenceIndex.
+type RefIndexOpts struct {
+	// VerifyOnly compares the index with the references counted without
+	// writing it.
+	VerifyOnly bool
+	// Workers is the number of repositories walked, and of counts written,
+	// concurrently, 1 if not positive.
+	Workers int
+}
+
+// RefIndexMismatch is a blob whose count in the index differs from the
+// number of references counted by walking the repositories. Blobs missing
+// from the index are indexed zero times.
+type RefIndexMismatch struct {
+	Blob    digest.Digest `json:"blob"`
+	Indexed int           `json:"indexed"`
+	Counted int           `json:"counted"`
+}
+
+// RefIndexReport is the result of RebuildBlobReferenceIndex.
+type RefIndexReport struct {
+	Repositories int                `json:"repositories"`
+	Manifests    int                `json:"manifests"`
+	Blobs        int                `json:"blobs"`
+	Mismatches   []RefIndexMismatch `json:"mismatches"`
+}
+
+// RebuildBlobReferenceIndex counts the references to the blobs of the
+// registry by walking its repositories, and compares the counts with those
+// of the blob reference index. Unless opts.VerifyOnly is set, the index is
+// then replaced by the counts, the blobs of the blob store referenced by no
+// manifest being marked unreferenced. As garbage collection, it must run
+// while the registry is read-only or stopped.
+func RebuildBlobReferenceIndex(ctx context.Context, storageDriver storagedriver.StorageDriver, registry distribution.Namespace, opts RefIndexOpts) (RefIndexReport, error) {
+	var report RefIndexReport
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return report, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	var repositories []string
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		repositories = append(repositories, repoName)
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to enumerate repositories: %v", err)
+	}
+	report.Repositories = len(repositories)
+
+	// count
+	gc := &garbageCollector{registry: registry, opts: GCOpts{Workers: opts.Workers}}
+	counts := make(map[digest.Digest]int)
+	err = gc.forEach(ctx, repositories, func(ctx context.Context, repoName string) error {
+		_, manifestService, manifestEnumerator, err := gc.repository(ctx, repoName)
+		if err != nil {
+			return err
+		}
+		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+			blobs := []digest.Digest{dgst}
+			mfst, err := manifestService.Get(ctx, dgst)
+			if err == nil {
+				blobs = manifestBlobs(dgst, mfst)
+			} else if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+				// The blob of the manifest is missing, its link still
+				// references it.
+				dcontext.GetLogger(ctx).Warnf("%s: manifest %s not found", repoName, dgst)
+			} else {
+				return fmt.Errorf("failed to retrieve manifest %s of %s: %v", dgst, repoName, err)
+			}
+
+			gc.mu.Lock()
+			defer gc.mu.Unlock()
+			report.Manifests++
+			for _, blob := range blobs {
+				counts[blob]++
+			}
+			return nil
+		})
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to count references: %v", err)
+	}
+	err = registry.Blobs().Enumerate(ctx, func(dgst digest.Digest) error {
+		report.Blobs++
+		if _, ok := counts[dgst]; !ok {
+			counts[dgst] = 0
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("error enumerating blobs: %v", err)
+	}
+
+	// verify
+	idx := &blobRefIndex{driver: storageDriver}
+	indexed := make(map[digest.Digest]int)
+	countsPath, err := pathFor(refCountsPathSpec{})
+	if err != nil {
+		return report, err
+	}
+	err = storageDriver.Walk(ctx, countsPath, func(fileInfo storagedriver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+		p := fileInfo.Path()
+		dgst := digest.NewDigestFromEncoded(digest.Algorithm(path.Base(path.Dir(path.Dir(p)))), path.Base(p))
+		if err := dgst.Validate(); err != nil {
+			return fmt.Errorf("invalid reference count path %s: %v", p, err)
+		}
+		count, _, err := idx.count(ctx, dgst)
+		if err != nil {
+			return err
+		}
+		indexed[dgst] = count
+		return nil
+	})
+	if err != nil && !errors.As(err, &storagedriver.PathNotFoundError{}) {
+		return report, fmt.Errorf("failed to read blob reference index: %v", err)
+	}
+	for dgst, count := range indexed {
+		if _, ok := counts[dgst]; !ok && count != 0 {
+			report.Mismatches = append(report.Mismatches, RefIndexMismatch{Blob: dgst, Indexed: count})
+		}
+	}
+	for dgst, count := range counts {
+		if indexed[dgst] != count {
+			report.Mismatches = append(report.Mismatches, RefIndexMismatch{Blob: dgst, Indexed: indexed[dgst], Counted: count})
+		}
+	}
+	sort.Slice(report.Mismatches, func(i, j int) bool { return report.Mismatches[i].Blob < report.Mismatches[j].Blob })
+	if opts.VerifyOnly {
+		return report, nil
+	}
+
+	// rebuild
+	indexPath, err := pathFor(refIndexPathSpec{})
+	if err != nil {
+		return report, err
+	}
+	if err := storageDriver.Delete(ctx, indexPath); err != nil && !errors.As(err, &storagedriver.PathNotFoundError{}) {
+		return report, fmt.Errorf("failed to remove blob reference index: %v", err)
+	}
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(workers)
+	for dgst, count := range counts {
+		dgst, count := dgst, count
+		g.Go(func() error {
+			return idx.set(gctx, dgst, count)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return report, err
+	}
+	builtAtPath, err := pathFor(refIndexBuiltAtPathSpec{})
+	if err != nil {
+		return report, err
+	}
+	if err := storageDriver.PutContent(ctx, builtAtPath, []byte(time.Now().UTC().Format(time.RFC3339))); err != nil {
+		return report, fmt.Errorf("failed to write blob reference index: %v", err)
+	}
+	return report, nil
+}
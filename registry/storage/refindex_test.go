@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestBlobReferenceIndex(t *testing.T) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d, BlobReferenceIndex)
+	idx := &blobRefIndex{driver: d}
+
+	expectCount := func(dgst digest.Digest, expected int) {
+		t.Helper()
+		count, _, err := idx.count(ctx, dgst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != expected {
+			t.Fatalf("blob %s: expected %d references, got %d", dgst, expected, count)
+		}
+	}
+
+	if _, err := MarkAndSweep(ctx, d, registry, GCOpts{Incremental: true}); err == nil {
+		t.Fatal("expected incremental collection to fail before the index is built")
+	}
+
+	// Both repositories hold the same image.
+	layers, err := testutil.CreateRandomLayers(2)
+	if err != nil {
+		t.Fatalf("failed to make layers: %v", err)
+	}
+	layerDigests := getKeys(layers)
+	var manifestDigest digest.Digest
+	repos := []distribution.Repository{makeRepository(t, registry, "first"), makeRepository(t, registry, "second")}
+	for _, repo := range repos {
+		for _, layer := range layers {
+			if _, err := layer.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+		}
+		manifest, err := testutil.MakeSchema2Manifest(repo, layerDigests)
+		if err != nil {
+			t.Fatalf("failed to make manifest: %v", err)
+		}
+		manifestDigest = uploadImage(t, repo, image{manifest: manifest, layers: layers})
+		// Putting the manifest again does not count it again.
+		if _, err := makeManifestService(t, repo).Put(ctx, manifest); err != nil {
+			t.Fatalf("manifest upload failed: %v", err)
+		}
+	}
+	for _, dgst := range append(layerDigests, manifestDigest) {
+		expectCount(dgst, 2)
+	}
+
+	// An orphan blob is only indexed by a rebuild.
+	orphan, err := repos[0].Blobs(ctx).Put(ctx, "application/octet-stream", []byte("orphan"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := RebuildBlobReferenceIndex(ctx, d, registry, RefIndexOpts{VerifyOnly: true})
+	if err != nil {
+		t.Fatalf("failed to verify index: %v", err)
+	}
+	if len(report.Mismatches) != 0 || report.Repositories != 2 || report.Manifests != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if _, err := RebuildBlobReferenceIndex(ctx, d, registry, RefIndexOpts{Workers: 2}); err != nil {
+		t.Fatalf("failed to rebuild index: %v", err)
+	}
+	expectCount(orphan.Digest, 0)
+
+	if err := makeManifestService(t, repos[0]).Delete(ctx, manifestDigest); err != nil {
+		t.Fatalf("failed to delete manifest: %v", err)
+	}
+	expectCount(manifestDigest, 1)
+
+	gcReport, err := MarkAndSweep(ctx, d, registry, GCOpts{Incremental: true})
+	if err != nil {
+		t.Fatalf("failed incremental collection: %v", err)
+	}
+	if len(gcReport.Blobs) != 1 || gcReport.Blobs[0] != orphan.Digest {
+		t.Fatalf("expected the orphan blob only to be removed, got %v", gcReport.Blobs)
+	}
+
+	if err := makeManifestService(t, repos[1]).Delete(ctx, manifestDigest); err != nil {
+		t.Fatalf("failed to delete manifest: %v", err)
+	}
+	gcReport, err = MarkAndSweep(ctx, d, registry, GCOpts{Incremental: true, DryRun: true})
+	if err != nil {
+		t.Fatalf("failed incremental collection: %v", err)
+	}
+	if len(gcReport.Blobs) != 4 {
+		t.Fatalf("expected the layers, config and manifest to be eligible for deletion, got %v", gcReport.Blobs)
+	}
+	if _, err := MarkAndSweep(ctx, d, registry, GCOpts{Incremental: true}); err != nil {
+		t.Fatalf("failed incremental collection: %v", err)
+	}
+	blobs := allBlobs(t, registry)
+	for _, dgst := range append(layerDigests, manifestDigest, orphan.Digest) {
+		if _, ok := blobs[dgst]; ok {
+			t.Errorf("blob %s not removed", dgst)
+		}
+	}
+
+	// The walk agrees with the index maintained since the rebuild.
+	report, err = RebuildBlobReferenceIndex(ctx, d, registry, RefIndexOpts{VerifyOnly: true})
+	if err != nil {
+		t.Fatalf("failed to verify index: %v", err)
+	}
+	if len(report.Mismatches) != 0 {
+		t.Fatalf("unexpected mismatches: %+v", report.Mismatches)
+	}
+
+	// A tampered count is found by the walk.
+	if err := idx.set(ctx, orphan.Digest, 3); err != nil {
+		t.Fatal(err)
+	}
+	report, err = RebuildBlobReferenceIndex(ctx, d, registry, RefIndexOpts{VerifyOnly: true})
+	if err != nil {
+		t.Fatalf("failed to verify index: %v", err)
+	}
+	if len(report.Mismatches) != 1 || report.Mismatches[0] != (RefIndexMismatch{Blob: orphan.Digest, Indexed: 3}) {
+		t.Fatalf("unexpected mismatches: %+v", report.Mismatches)
+	}
+}
+
+func TestBlobReferenceIndexRecover(t *testing.T) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+	referenced, unreferenced := digest.FromString("referenced"), digest.FromString("unreferenced")
+
+	// An update interrupted after its journal entry was written.
+	entryPath, err := pathFor(refJournalEntryPathSpec{id: "interrupted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := json.Marshal(refJournalEntry{Counts: map[digest.Digest]int{referenced: 2, unreferenced: 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, entryPath, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRegistry(ctx, d, BlobReferenceIndex); err != nil {
+		t.Fatalf("failed to construct registry: %v", err)
+	}
+
+	idx := &blobRefIndex{driver: d}
+	for dgst, expected := range map[digest.Digest]int{referenced: 2, unreferenced: 0} {
+		count, indexed, err := idx.count(ctx, dgst)
+		if err != nil || !indexed || count != expected {
+			t.Fatalf("blob %s: expected %d references, got %d, %t, %v", dgst, expected, count, indexed, err)
+		}
+	}
+	markerPath, err := pathFor(refUnreferencedPathSpec{digest: unreferenced})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, markerPath); err != nil {
+		t.Fatalf("expected blob %s to be marked unreferenced: %v", unreferenced, err)
+	}
+	if _, err := d.Stat(ctx, entryPath); err == nil {
+		t.Fatal("expected the journal entry to be removed")
+	}
+}
+
+func TestBlobReferenceIndexSharedLock(t *testing.T) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+	dgst := digest.FromString("shared")
+
+	// The instances sharing the storage update the same count concurrently.
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 2; i++ {
+		idx := &blobRefIndex{driver: d, lock: newSharedLock(storageLocks(d)(refIndexLockName))}
+		for j := 0; j < 5; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- idx.update(ctx, 1, []digest.Digest{dgst})
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("failed to update count: %v", err)
+		}
+	}
+
+	idx := &blobRefIndex{driver: d}
+	count, _, err := idx.count(ctx, dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 10 {
+		t.Fatalf("expected 10 references, got %d", count)
+	}
+}
+
+// revisionReadFailingDriver fails reading the manifest revision links while
+// failing is set.
+type revisionReadFailingDriver struct {
+	driver.StorageDriver
+	failing bool
+}
+
+func (d *revisionReadFailingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if d.failing && strings.Contains(path, "/_manifests/revisions/") {
+		return nil, errors.New("storage unavailable")
+	}
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func TestBlobReferenceIndexPutExistsFailure(t *testing.T) {
+	ctx := dcontext.Background()
+	d := &revisionReadFailingDriver{StorageDriver: inmemory.New()}
+	registry := createRegistry(t, d, BlobReferenceIndex)
+	repo := makeRepository(t, registry, "failing")
+
+	layers, err := testutil.CreateRandomLayers(1)
+	if err != nil {
+		t.Fatalf("failed to make layers: %v", err)
+	}
+	if err := testutil.UploadBlobs(repo, layers); err != nil {
+		t.Fatalf("layer upload failed: %v", err)
+	}
+	manifest, err := testutil.MakeSchema2Manifest(repo, getKeys(layers))
+	if err != nil {
+		t.Fatalf("failed to make manifest: %v", err)
+	}
+
+	// A manifest whose existence cannot be checked is neither counted nor
+	// stored, so that its references are never counted too few times.
+	d.failing = true
+	if _, err := makeManifestService(t, repo).Put(ctx, manifest); err == nil {
+		t.Fatal("expected the put to fail")
+	}
+	d.failing = false
+
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := makeManifestService(t, repo).Exists(ctx, digest.FromBytes(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected the manifest not to be stored")
+	}
+
+	idx := &blobRefIndex{driver: d}
+	for _, dgst := range getKeys(layers) {
+		count, _, err := idx.count(ctx, dgst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Fatalf("blob %s: expected no references, got %d", dgst, count)
+		}
+	}
+}
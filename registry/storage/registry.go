@@ -2,10 +2,17 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"path"
 	"regexp"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/workers"
+	"github.com/distribution/distribution/v3/registry/coordination"
 	"github.com/distribution/distribution/v3/registry/storage/cache"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
@@ -13,6 +20,15 @@ import (
 
 var (
 	DefaultConcurrencyLimit = runtime.GOMAXPROCS(0)
+
+	// DefaultManifestVerificationConcurrencyLimit is the number of references
+	// of a manifest checked concurrently when it is put, unless configured
+	// with ManifestVerificationConcurrencyLimit.
+	DefaultManifestVerificationConcurrencyLimit = 10
+
+	// namespaceComponentRegexp matches the path components of repository
+	// names, as defined by the reference grammar.
+	namespaceComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*$`)
 )
 
 // registry is the top-level implementation of Registry for use in the storage
@@ -23,11 +39,31 @@ type registry struct {
 	statter                      *blobStatter // global statter service.
 	blobDescriptorCacheProvider  cache.BlobDescriptorCacheProvider
 	deleteEnabled                bool
+	softDelete                   bool
+	verifyOnRead                 bool
 	tagLookupConcurrencyLimit    int
+	tagLookupWorkers             *workers.Pool
+	verificationConcurrencyLimit int
 	resumableDigestEnabled       bool
+	uploadRedirect               bool
 	blobDescriptorServiceFactory distribution.BlobDescriptorServiceFactory
 	manifestURLs                 manifestURLs
+	foreignLayerURLs             *manifestURLs
+	layerMediaTypes              []string
+	validateImageConfigs         bool
+	namePolicy                   *NamePolicy
+	canonicalManifests           []string
+	mediaTypeRules               []MediaTypeRule
+	immutableTags                []ImmutableTagRule
+	refIndex                     *blobRefIndex
+	locks                        func(name string) coordination.Lock
+	namespace                    string
 	driver                       storagedriver.StorageDriver
+
+	// immutableTagsMu serializes the writes of immutable tags, so that the
+	// check of the manifest an immutable tag references and its change
+	// cannot interleave with another write.
+	immutableTagsMu sync.Mutex
 }
 
 // manifestURLs holds regular expressions for controlling manifest URL whitelisting
@@ -46,6 +82,47 @@ func EnableRedirect(registry *registry) error {
 	return nil
 }
 
+// RedirectThreshold is a functional option for NewRegistry. It causes blobs
+// smaller than threshold bytes to be served directly even if redirects are
+// enabled, saving clients a round trip for small blobs.
+func RedirectThreshold(threshold int64) RegistryOption {
+	return func(registry *registry) error {
+		registry.blobServer.redirectThreshold = threshold
+		return nil
+	}
+}
+
+// BlobCacheControlMaxAge is a functional option for NewRegistry. It sets the
+// max-age of the Cache-Control header of the blobs served, instead of a year.
+// The header is also requested from the storage backends serving redirected
+// blobs, when their driver supports it.
+func BlobCacheControlMaxAge(maxAge time.Duration) RegistryOption {
+	return func(registry *registry) error {
+		if maxAge < 0 {
+			return fmt.Errorf("negative blob cache max-age: %v", maxAge)
+		}
+		registry.blobServer.cacheControl = fmt.Sprintf("max-age=%.f", maxAge.Seconds())
+		return nil
+	}
+}
+
+// EnableBlobAttachments is a functional option for NewRegistry. It causes the
+// blobs requested with the attach=true query parameter to be served with a
+// Content-Disposition header, as attachments named after their digest and
+// media type, so that browsers save them with a meaningful filename.
+func EnableBlobAttachments(registry *registry) error {
+	registry.blobServer.attachments = true
+	return nil
+}
+
+// EnableUploadRedirect is a functional option for NewRegistry. It allows
+// blob writers to redirect the upload of whole blobs to the URL returned by
+// (StorageDriver).RedirectURL for PUT requests.
+func EnableUploadRedirect(registry *registry) error {
+	registry.uploadRedirect = true
+	return nil
+}
+
 func TagLookupConcurrencyLimit(concurrencyLimit int) RegistryOption {
 	return func(registry *registry) error {
 		registry.tagLookupConcurrencyLimit = concurrencyLimit
@@ -53,6 +130,37 @@ func TagLookupConcurrencyLimit(concurrencyLimit int) RegistryOption {
 	}
 }
 
+// TagLookupWorkers is a functional option for NewRegistry. It sets the pool
+// of workers looking up the tags of manifests, which otherwise run on the
+// workers of workers.DefaultSemaphore.
+func TagLookupWorkers(pool *workers.Pool) RegistryOption {
+	return func(registry *registry) error {
+		registry.tagLookupWorkers = pool
+		return nil
+	}
+}
+
+// ManifestVerificationConcurrencyLimit is a functional option for
+// NewRegistry. It sets the number of references of a manifest whose presence
+// is checked concurrently when the manifest is put. When it is not positive,
+// DefaultManifestVerificationConcurrencyLimit is used.
+func ManifestVerificationConcurrencyLimit(concurrencyLimit int) RegistryOption {
+	return func(registry *registry) error {
+		registry.verificationConcurrencyLimit = concurrencyLimit
+		return nil
+	}
+}
+
+// WalkParallelism is a functional option for NewRegistry. It sets the number
+// of directories listed concurrently by the walks enumerating repositories and
+// blobs, for the storage drivers walking with List.
+func WalkParallelism(parallelism int) RegistryOption {
+	return func(registry *registry) error {
+		registry.blobStore.walkParallelism = parallelism
+		return nil
+	}
+}
+
 // EnableDelete is a functional option for NewRegistry. It enables deletion on
 // the registry.
 func EnableDelete(registry *registry) error {
@@ -83,6 +191,78 @@ func ManifestURLsDenyRegexp(r *regexp.Regexp) RegistryOption {
 	}
 }
 
+// ForeignLayerURLsAllowRegexp is a functional option for NewRegistry. It
+// causes the URLs of the foreign layers of schema2 manifests to be checked
+// against the foreign layer rules, rather than those of ManifestURLsAllowRegexp
+// and ManifestURLsDenyRegexp.
+func ForeignLayerURLsAllowRegexp(r *regexp.Regexp) RegistryOption {
+	return func(registry *registry) error {
+		if registry.foreignLayerURLs == nil {
+			registry.foreignLayerURLs = &manifestURLs{}
+		}
+		registry.foreignLayerURLs.allow = r
+		return nil
+	}
+}
+
+// ForeignLayerURLsDenyRegexp is a functional option for NewRegistry. Like
+// ForeignLayerURLsAllowRegexp, it causes the URLs of foreign layers to be
+// checked against the foreign layer rules.
+func ForeignLayerURLsDenyRegexp(r *regexp.Regexp) RegistryOption {
+	return func(registry *registry) error {
+		if registry.foreignLayerURLs == nil {
+			registry.foreignLayerURLs = &manifestURLs{}
+		}
+		registry.foreignLayerURLs.deny = r
+		return nil
+	}
+}
+
+// EnforceNamePolicy is a functional option for NewRegistry. It causes
+// Repository to reject the names forbidden by policy.
+func EnforceNamePolicy(policy *NamePolicy) RegistryOption {
+	return func(registry *registry) error {
+		registry.namePolicy = policy
+		return nil
+	}
+}
+
+// RequireCanonicalManifests is a functional option for NewRegistry. It
+// causes manifests pushed to the repositories matching one of patterns to be
+// rejected if their payload is not in canonical form, as returned by
+// manifest.Canonicalize. Patterns are matched as in NamePolicy.
+func RequireCanonicalManifests(patterns []string) RegistryOption {
+	return func(registry *registry) error {
+		if _, err := NewNamePolicy(0, patterns, nil); err != nil {
+			return err
+		}
+		registry.canonicalManifests = patterns
+		return nil
+	}
+}
+
+// WithNamespacePrefix is a functional option for NewRegistry. It stores the
+// repositories of the registry under prefix, a sequence of repository path
+// components such as "team-a/", isolating them from the repositories of
+// registries using other prefixes. Blobs are still shared, and deduplicated,
+// between all the registries using the same storage. Repository names are
+// given to and listed by the registry without the prefix.
+//
+// Garbage collection must run against a registry without a prefix, since it
+// needs to see the references of all the namespaces sharing the blobs.
+func WithNamespacePrefix(prefix string) RegistryOption {
+	return func(registry *registry) error {
+		namespace := strings.TrimSuffix(prefix, "/")
+		for _, component := range strings.Split(namespace, "/") {
+			if !namespaceComponentRegexp.MatchString(component) {
+				return fmt.Errorf("invalid namespace prefix %q: %q is not a valid repository path component", prefix, component)
+			}
+		}
+		registry.namespace = namespace
+		return nil
+	}
+}
+
 // BlobDescriptorServiceFactory returns a functional option for NewRegistry. It sets the
 // factory to create BlobDescriptorServiceFactory middleware.
 func BlobDescriptorServiceFactory(factory distribution.BlobDescriptorServiceFactory) RegistryOption {
@@ -135,7 +315,9 @@ func NewRegistry(ctx context.Context, driver storagedriver.StorageDriver, option
 			pathFn:  bs.path,
 		},
 		statter:                statter,
+		tagLookupWorkers:       workers.New("tag_lookup", nil),
 		resumableDigestEnabled: true,
+		locks:                  storageLocks(driver),
 		driver:                 driver,
 	}
 
@@ -145,9 +327,25 @@ func NewRegistry(ctx context.Context, driver storagedriver.StorageDriver, option
 		}
 	}
 
+	if registry.refIndex != nil {
+		registry.refIndex.lock = newSharedLock(registry.locks(refIndexLockName))
+		if err := registry.refIndex.recover(ctx); err != nil {
+			return nil, fmt.Errorf("failed to recover blob reference index: %v", err)
+		}
+	}
+
 	return registry, nil
 }
 
+// repositoryPath returns the name in storage paths of the repository name,
+// prefixed with the namespace of the registry.
+func (reg *registry) repositoryPath(name string) string {
+	if reg.namespace == "" {
+		return name
+	}
+	return path.Join(reg.namespace, name)
+}
+
 // Scope returns the namespace scope for a registry. The registry
 // will only serve repositories contained within this scope.
 func (reg *registry) Scope() distribution.Scope {
@@ -158,6 +356,10 @@ func (reg *registry) Scope() distribution.Scope {
 // Instances should not be shared between goroutines but are cheap to
 // allocate. In general, they should be request scoped.
 func (reg *registry) Repository(ctx context.Context, canonicalName reference.Named) (distribution.Repository, error) {
+	if err := reg.namePolicy.Validate(canonicalName.Name()); err != nil {
+		return nil, err
+	}
+
 	var descriptorCache distribution.BlobDescriptorService
 	if reg.blobDescriptorCacheProvider != nil {
 		var err error
@@ -196,6 +398,11 @@ func (repo *repository) Named() reference.Named {
 	return repo.name
 }
 
+// pathName returns the name of the repository in storage paths.
+func (repo *repository) pathName() string {
+	return repo.registry.repositoryPath(repo.name.Name())
+}
+
 func (repo *repository) Tags(ctx context.Context) distribution.TagService {
 	limit := DefaultConcurrencyLimit
 	if repo.tagLookupConcurrencyLimit > 0 {
@@ -205,6 +412,7 @@ func (repo *repository) Tags(ctx context.Context) distribution.TagService {
 		repository:       repo,
 		blobStore:        repo.registry.blobStore,
 		concurrencyLimit: limit,
+		workers:          repo.tagLookupWorkers,
 	}
 
 	return tags
@@ -214,12 +422,13 @@ func (repo *repository) Tags(ctx context.Context) distribution.TagService {
 // may be context sensitive in the future. The instance should be used similar
 // to a request local.
 func (repo *repository) Manifests(ctx context.Context, options ...distribution.ManifestServiceOption) (distribution.ManifestService, error) {
-	manifestDirectoryPathSpec := manifestRevisionsPathSpec{name: repo.name.Name()}
+	manifestDirectoryPathSpec := manifestRevisionsPathSpec{name: repo.pathName()}
 
 	var statter distribution.BlobDescriptorService = &linkedBlobStatter{
 		blobStore:  repo.blobStore,
 		repository: repo,
 		linkPath:   manifestRevisionLinkPath,
+		softDelete: repo.registry.softDelete,
 	}
 
 	if repo.registry.blobDescriptorServiceFactory != nil {
@@ -245,22 +454,34 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 		blobStore:  blobStore,
 	}
 
+	verificationLimit := DefaultManifestVerificationConcurrencyLimit
+	if repo.verificationConcurrencyLimit > 0 {
+		verificationLimit = repo.verificationConcurrencyLimit
+	}
+
+	foreignLayerURLs := repo.registry.manifestURLs
+	if repo.registry.foreignLayerURLs != nil {
+		foreignLayerURLs = *repo.registry.foreignLayerURLs
+	}
+
 	ms := &manifestStore{
 		ctx:        ctx,
 		repository: repo,
 		blobStore:  blobStore,
 		schema2Handler: &schema2ManifestHandler{
-			ctx:          ctx,
-			repository:   repo,
-			blobStore:    blobStore,
-			manifestURLs: repo.registry.manifestURLs,
+			ctx:               ctx,
+			repository:        repo,
+			blobStore:         blobStore,
+			manifestURLs:      foreignLayerURLs,
+			verificationLimit: verificationLimit,
 		},
 		manifestListHandler: manifestListHandler,
 		ocischemaHandler: &ocischemaManifestHandler{
-			ctx:          ctx,
-			repository:   repo,
-			blobStore:    blobStore,
-			manifestURLs: repo.registry.manifestURLs,
+			ctx:               ctx,
+			repository:        repo,
+			blobStore:         blobStore,
+			manifestURLs:      repo.registry.manifestURLs,
+			verificationLimit: verificationLimit,
 		},
 		ocischemaIndexHandler: &ocischemaIndexHandler{
 			manifestListHandler: manifestListHandler,
@@ -307,7 +528,7 @@ func (repo *repository) Blobs(ctx context.Context) distribution.BlobStore {
 		// TODO(stevvooe): linkPath limits this blob store to only layers.
 		// This instance cannot be used for manifest checks.
 		linkPath:               blobLinkPath,
-		linkDirectoryPathSpec:  layersPathSpec{name: repo.name.Name()},
+		linkDirectoryPathSpec:  layersPathSpec{name: repo.pathName()},
 		deleteEnabled:          repo.registry.deleteEnabled,
 		resumableDigestEnabled: repo.resumableDigestEnabled,
 	}
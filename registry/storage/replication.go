@@ -0,0 +1,489 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/docker/go-metrics"
+)
+
+var (
+	// replicationPending is the number of journaled copies not yet replicated
+	replicationPending = prometheus.StorageNamespace.NewGauge("replication_pending", "The number of copies waiting to be replicated to the secondary storage", metrics.Total)
+	// replicationOldestPending is the age of the oldest journaled copy
+	replicationOldestPending = prometheus.StorageNamespace.NewGauge("replication_oldest_pending", "The age of the oldest copy waiting to be replicated to the secondary storage", metrics.Seconds)
+	// replicationCopies is the number of copies to the secondary storage
+	replicationCopies = prometheus.StorageNamespace.NewLabeledCounter("replication_copies", "The number of copies to the secondary storage", "result")
+)
+
+// ReplicationOptions configures a Replicator. Zero values select the
+// defaults.
+type ReplicationOptions struct {
+	// Workers is the number of concurrent copies, 4 by default.
+	Workers int
+	// QueueSize bounds the number of copies waiting for a worker, 1024 by
+	// default. Copies which do not fit stay in the journal until the next
+	// scan.
+	QueueSize int
+	// MaxRetries is the number of times a failed copy is retried before it
+	// is left to the next scan, 5 by default.
+	MaxRetries int
+	// RetryDelay is the delay before the first retry, doubled on each
+	// retry, 1s by default.
+	RetryDelay time.Duration
+	// ScanInterval is the interval at which the journal is scanned for
+	// copies which are not queued, 1m by default.
+	ScanInterval time.Duration
+	// Leader reports whether the instance leads the registry instances
+	// sharing the primary storage. Only the leader scans the journal, which
+	// holds the copies of every instance, while each instance copies the
+	// content it writes. Without it, the instance always scans.
+	Leader func() bool
+}
+
+// Replicator copies the content written by the registry, blob data, the
+// links of layers, manifests and tags and the media types of manifests, from
+// the primary storage driver to a secondary one. Each copy is journaled in the primary driver before it is
+// queued and removed from the journal once done, so that a crash does not
+// lose replication work. Deletions are not replicated.
+type Replicator struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	primary   driver.StorageDriver
+	secondary driver.StorageDriver
+	opts      ReplicationOptions
+	queue     chan string
+	wg        sync.WaitGroup
+
+	mu sync.Mutex
+	// pending holds the journaled paths with the time they were journaled.
+	pending map[string]time.Time
+	// active holds the paths queued or being copied, and rerun those
+	// written again while being copied.
+	active map[string]bool
+	rerun  map[string]bool
+}
+
+// NewReplicator returns a Replicator copying from primary to secondary. The
+// copies journaled by a previous run are queued, and workers run until ctx is
+// done or Close is called.
+func NewReplicator(ctx context.Context, primary, secondary driver.StorageDriver, opts ReplicationOptions) (*Replicator, error) {
+	if opts.Workers < 0 || opts.QueueSize < 0 || opts.MaxRetries < 0 || opts.RetryDelay < 0 || opts.ScanInterval < 0 {
+		return nil, fmt.Errorf("replication options must not be negative: %+v", opts)
+	}
+	if opts.Workers == 0 {
+		opts.Workers = 4
+	}
+	if opts.QueueSize == 0 {
+		opts.QueueSize = 1024
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 5
+	}
+	if opts.RetryDelay == 0 {
+		opts.RetryDelay = time.Second
+	}
+	if opts.ScanInterval == 0 {
+		opts.ScanInterval = time.Minute
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &Replicator{
+		ctx:       ctx,
+		cancel:    cancel,
+		primary:   primary,
+		secondary: secondary,
+		opts:      opts,
+		queue:     make(chan string, opts.QueueSize),
+		pending:   make(map[string]time.Time),
+		active:    make(map[string]bool),
+		rerun:     make(map[string]bool),
+	}
+	if err := r.leaderScan(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to scan replication journal: %v", err)
+	}
+
+	r.wg.Add(opts.Workers + 1)
+	for i := 0; i < opts.Workers; i++ {
+		go r.run()
+	}
+	go r.loop()
+	return r, nil
+}
+
+// Replicate is a functional option for NewRegistry. It causes the content
+// written by the registry to be copied to secondary storage by r.
+func Replicate(r *Replicator) RegistryOption {
+	return func(registry *registry) error {
+		registry.blobStore.replicator = r
+		return nil
+	}
+}
+
+// Close stops the workers. Journaled copies are resumed by the next
+// Replicator.
+func (r *Replicator) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// Enqueue journals the copy of p to the secondary storage and queues it. It
+// is called once p has been written to the primary storage.
+func (r *Replicator) Enqueue(ctx context.Context, p string) error {
+	r.mu.Lock()
+	_, journaled := r.pending[p]
+	if !journaled {
+		r.pending[p] = time.Now()
+	}
+	if r.active[p] {
+		// A copy in progress may have read the previous content.
+		r.rerun[p] = true
+	}
+	r.mu.Unlock()
+
+	if !journaled {
+		if err := r.journal(ctx, p); err != nil {
+			return err
+		}
+	}
+	r.enqueue(p)
+	return nil
+}
+
+func (r *Replicator) journal(ctx context.Context, p string) error {
+	entryPath, err := pathFor(replicationJournalEntryPathSpec{path: p})
+	if err != nil {
+		return err
+	}
+	if err := r.primary.PutContent(ctx, entryPath, []byte(p)); err != nil {
+		return fmt.Errorf("failed to journal replication of %s: %v", p, err)
+	}
+	return nil
+}
+
+// enqueue queues p unless it is queued or being copied already. Paths which
+// do not fit in the queue are queued again by the next scan.
+func (r *Replicator) enqueue(p string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.active[p] {
+		return
+	}
+	select {
+	case r.queue <- p:
+		r.active[p] = true
+	default:
+		dcontext.GetLogger(r.ctx).Warnf("replication queue full, deferring %s to the next scan", p)
+	}
+}
+
+func (r *Replicator) run() {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case p := <-r.queue:
+			r.replicate(p)
+		}
+	}
+}
+
+// replicate copies p, retrying on failure, and removes it from the journal
+// once copied.
+func (r *Replicator) replicate(p string) {
+	r.mu.Lock()
+	delete(r.rerun, p)
+	r.mu.Unlock()
+
+	err := r.copyWithRetries(r.ctx, p)
+	if err != nil {
+		if r.ctx.Err() == nil {
+			dcontext.GetLogger(r.ctx).Errorf("failed to replicate %s, deferring to the next scan: %v", p, err)
+			replicationCopies.WithValues("failure").Inc(1)
+		}
+		r.mu.Lock()
+		delete(r.active, p)
+		delete(r.rerun, p)
+		r.mu.Unlock()
+		return
+	}
+	replicationCopies.WithValues("success").Inc(1)
+
+	r.mu.Lock()
+	rerun := r.rerun[p]
+	if !rerun {
+		delete(r.pending, p)
+	}
+	r.mu.Unlock()
+
+	if !rerun {
+		entryPath, err := pathFor(replicationJournalEntryPathSpec{path: p})
+		if err == nil {
+			err = r.primary.Delete(r.ctx, entryPath)
+		}
+		if err != nil && !errors.As(err, &driver.PathNotFoundError{}) {
+			dcontext.GetLogger(r.ctx).Errorf("failed to remove replication journal entry of %s: %v", p, err)
+		}
+	}
+
+	r.mu.Lock()
+	delete(r.active, p)
+	rerun = r.rerun[p]
+	if rerun {
+		// Written again while the journal entry was removed.
+		r.pending[p] = time.Now()
+	}
+	r.mu.Unlock()
+
+	if rerun {
+		if err := r.journal(r.ctx, p); err != nil {
+			dcontext.GetLogger(r.ctx).Errorf("%v", err)
+		}
+		r.enqueue(p)
+	}
+}
+
+func (r *Replicator) copyWithRetries(ctx context.Context, p string) error {
+	delay := r.opts.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := r.copy(ctx, p)
+		if err == nil || attempt == r.opts.MaxRetries {
+			return err
+		}
+		dcontext.GetLogger(ctx).Warnf("failed to replicate %s, retrying in %s: %v", p, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// copy streams p from the primary to the secondary storage. Paths removed
+// from the primary storage since they were written are skipped.
+func (r *Replicator) copy(ctx context.Context, p string) error {
+	rc, err := r.primary.Reader(ctx, p, 0)
+	if errors.As(err, &driver.PathNotFoundError{}) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fw, err := r.secondary.Writer(ctx, p, false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, rc); err != nil {
+		// nolint:errcheck
+		fw.Cancel(ctx)
+		return err
+	}
+	if err := fw.Commit(ctx); err != nil {
+		// nolint:errcheck
+		fw.Cancel(ctx)
+		return err
+	}
+	return fw.Close()
+}
+
+// loop periodically queues the journaled copies which are not queued, such
+// as those which did not fit in the queue or failed, and updates the
+// replication lag metrics.
+func (r *Replicator) loop() {
+	defer r.wg.Done()
+	scan := time.NewTicker(r.opts.ScanInterval)
+	defer scan.Stop()
+	lag := time.NewTicker(10 * time.Second)
+	defer lag.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-scan.C:
+			if err := r.leaderScan(); err != nil {
+				dcontext.GetLogger(r.ctx).Errorf("failed to scan replication journal: %v", err)
+			}
+		case <-lag.C:
+			r.updateLag()
+		}
+	}
+}
+
+// leaderScan scans the journal if the instance is the leader.
+func (r *Replicator) leaderScan() error {
+	if r.opts.Leader != nil && !r.opts.Leader() {
+		return nil
+	}
+	return r.scan()
+}
+
+// scan queues the copies of the journal.
+func (r *Replicator) scan() error {
+	journalPath, err := pathFor(replicationJournalPathSpec{})
+	if err != nil {
+		return err
+	}
+	var paths []string
+	err = r.primary.Walk(r.ctx, journalPath, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+		p, err := r.primary.GetContent(r.ctx, fileInfo.Path())
+		if errors.As(err, &driver.PathNotFoundError{}) {
+			// Removed once copied.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		if _, ok := r.pending[string(p)]; !ok {
+			r.pending[string(p)] = fileInfo.ModTime()
+		}
+		r.mu.Unlock()
+		paths = append(paths, string(p))
+		return nil
+	})
+	if err != nil && !errors.As(err, &driver.PathNotFoundError{}) {
+		return err
+	}
+
+	for _, p := range paths {
+		r.enqueue(p)
+	}
+	r.updateLag()
+	return nil
+}
+
+// updateLag sets the replication lag metrics.
+func (r *Replicator) updateLag() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var oldest time.Time
+	for _, t := range r.pending {
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+	}
+	replicationPending.Set(float64(len(r.pending)))
+	if oldest.IsZero() {
+		replicationOldestPending.Set(0)
+	} else {
+		replicationOldestPending.Set(time.Since(oldest).Seconds())
+	}
+}
+
+// Backfill copies the blob data and links of the primary storage which are
+// missing from the secondary storage, or differ in size, such as the content
+// written before replication was enabled. Uploads in progress and the
+// journal are skipped. It returns the number of files copied.
+func (r *Replicator) Backfill(ctx context.Context) (int, error) {
+	journalPath, err := pathFor(replicationJournalPathSpec{})
+	if err != nil {
+		return 0, err
+	}
+	root := path.Join(storagePathRoot, storagePathVersion)
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		copied int
+		errs   []error
+		paths  = make(chan string)
+	)
+	for i := 0; i < r.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				err := r.copyWithRetries(ctx, p)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("failed to copy %s: %v", p, err))
+				} else {
+					copied++
+					dcontext.GetLogger(ctx).Infof("backfilled %s", p)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	err = r.primary.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			if fileInfo.Path() == journalPath || path.Base(fileInfo.Path()) == "_uploads" {
+				return driver.ErrSkipDir
+			}
+			return nil
+		}
+		replicated, err := r.replicated(ctx, fileInfo)
+		if err != nil {
+			return err
+		}
+		if replicated {
+			return nil
+		}
+		select {
+		case paths <- fileInfo.Path():
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(paths)
+	wg.Wait()
+	if errors.As(err, &driver.PathNotFoundError{}) {
+		err = nil
+	}
+	if err == nil {
+		err = errors.Join(errs...)
+	}
+	return copied, err
+}
+
+// replicated reports whether the file of the primary storage exists in the
+// secondary storage. Blob data is content addressed and compared by size,
+// while links, which are overwritten with digests of the same size, are
+// compared by content.
+func (r *Replicator) replicated(ctx context.Context, fileInfo driver.FileInfo) (bool, error) {
+	fi, err := r.secondary.Stat(ctx, fileInfo.Path())
+	if errors.As(err, &driver.PathNotFoundError{}) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if fi.Size() != fileInfo.Size() {
+		return false, nil
+	}
+	if path.Base(fileInfo.Path()) != "link" {
+		return true, nil
+	}
+
+	primary, err := r.primary.GetContent(ctx, fileInfo.Path())
+	if err != nil {
+		return false, err
+	}
+	secondary, err := r.secondary.GetContent(ctx, fileInfo.Path())
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(primary, secondary), nil
+}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+// unavailableDriver fails writes while down is set.
+type unavailableDriver struct {
+	driver.StorageDriver
+	down atomic.Bool
+}
+
+func (d *unavailableDriver) Writer(ctx context.Context, path string, append bool) (driver.FileWriter, error) {
+	if d.down.Load() {
+		return nil, errors.New("secondary storage unavailable")
+	}
+	return d.StorageDriver.Writer(ctx, path, append)
+}
+
+// waitReplicated waits until r has no pending copies.
+func waitReplicated(t *testing.T, r *Replicator) {
+	t.Helper()
+	deadline := time.Now().Add(60 * time.Second)
+	for {
+		r.mu.Lock()
+		pending := len(r.pending)
+		r.mu.Unlock()
+		if pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d copies still pending", pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// checkReplicated checks that the files of the primary storage, except the
+// journal and uploads, have the same content in the secondary storage.
+func checkReplicated(ctx context.Context, t *testing.T, primary, secondary driver.StorageDriver) int {
+	t.Helper()
+	journalPath, err := pathFor(replicationJournalPathSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := 0
+	err = primary.Walk(ctx, "/docker/registry/v2", func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			if fileInfo.Path() == journalPath || path.Base(fileInfo.Path()) == "_uploads" {
+				return driver.ErrSkipDir
+			}
+			return nil
+		}
+		files++
+		expected, err := primary.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		actual, err := secondary.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			t.Errorf("%s not replicated: %v", fileInfo.Path(), err)
+			return nil
+		}
+		if string(actual) != string(expected) {
+			t.Errorf("unexpected content replicated for %s", fileInfo.Path())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestReplication(t *testing.T) {
+	ctx := context.Background()
+	primary := inmemory.New()
+	secondary := &unavailableDriver{StorageDriver: inmemory.New()}
+
+	r, err := NewReplicator(ctx, primary, secondary, ReplicationOptions{RetryDelay: time.Millisecond, MaxRetries: 1})
+	if err != nil {
+		t.Fatalf("unexpected error creating replicator: %v", err)
+	}
+	repo := makeRepository(t, createRegistry(t, primary, Replicate(r)), "foo/bar")
+	dgst, _ := pushTaggedImage(ctx, t, repo, "latest")
+	waitReplicated(t, r)
+	if files := checkReplicated(ctx, t, primary, secondary); files == 0 {
+		t.Fatal("nothing written to the primary storage")
+	}
+
+	// Copies which fail stay in the journal, and are resumed after a
+	// restart.
+	secondary.down.Store(true)
+	if err := repo.Tags(ctx).Tag(ctx, "other", distribution.Descriptor{Digest: dgst}); err != nil {
+		t.Fatal(err)
+	}
+	tagPath, err := pathFor(manifestTagCurrentPathSpec{name: "foo/bar", tag: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryPath, err := pathFor(replicationJournalEntryPathSpec{path: tagPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		r.mu.Lock()
+		active := len(r.active)
+		r.mu.Unlock()
+		if active == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("copies still active")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.Close()
+	if _, err := primary.Stat(ctx, entryPath); err != nil {
+		t.Fatalf("expected journal entry for %s: %v", tagPath, err)
+	}
+	if _, err := secondary.Stat(ctx, tagPath); err == nil {
+		t.Fatalf("unexpected copy of %s", tagPath)
+	}
+
+	// Only the leader of the instances resumes the copies of the journal.
+	secondary.down.Store(false)
+	follower, err := NewReplicator(ctx, primary, secondary, ReplicationOptions{Leader: func() bool { return false }})
+	if err != nil {
+		t.Fatalf("unexpected error creating replicator: %v", err)
+	}
+	follower.mu.Lock()
+	pending := len(follower.pending)
+	follower.mu.Unlock()
+	follower.Close()
+	if pending != 0 {
+		t.Fatalf("unexpected copies resumed by a follower: %d", pending)
+	}
+
+	r, err = NewReplicator(ctx, primary, secondary, ReplicationOptions{RetryDelay: time.Millisecond, Leader: func() bool { return true }})
+	if err != nil {
+		t.Fatalf("unexpected error creating replicator: %v", err)
+	}
+	defer r.Close()
+	waitReplicated(t, r)
+	checkReplicated(ctx, t, primary, secondary)
+	if _, err := primary.Stat(ctx, entryPath); !errors.As(err, &driver.PathNotFoundError{}) {
+		t.Fatalf("expected journal entry to be removed, got %v", err)
+	}
+}
+
+func TestReplicationBackfill(t *testing.T) {
+	ctx := context.Background()
+	primary := inmemory.New()
+	secondary := inmemory.New()
+
+	// Content written before replication is enabled.
+	repo := makeRepository(t, createRegistry(t, primary), "foo/bar")
+	first, _ := pushTaggedImage(ctx, t, repo, "first")
+	pushTaggedImage(ctx, t, repo, "second")
+
+	r, err := NewReplicator(ctx, primary, secondary, ReplicationOptions{RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected error creating replicator: %v", err)
+	}
+	defer r.Close()
+
+	copied, err := r.Backfill(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error backfilling: %v", err)
+	}
+	if files := checkReplicated(ctx, t, primary, secondary); copied != files {
+		t.Fatalf("unexpected number of files copied: %d != %d", copied, files)
+	}
+
+	copied, err = r.Backfill(ctx)
+	if err != nil || copied != 0 {
+		t.Fatalf("unexpected second backfill: %d %v", copied, err)
+	}
+
+	// Moving a tag rewrites its current link with a digest of the same size,
+	// and adds an index entry.
+	if err := repo.Tags(ctx).Tag(ctx, "second", distribution.Descriptor{Digest: first}); err != nil {
+		t.Fatal(err)
+	}
+	copied, err = r.Backfill(ctx)
+	if err != nil || copied != 2 {
+		t.Fatalf("unexpected backfill after moving tag: %d %v", copied, err)
+	}
+	checkReplicated(ctx, t, primary, secondary)
+}
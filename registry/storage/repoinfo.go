@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// RepositoryTag is a tag listed by DescribeRepository.
+type RepositoryTag struct {
+	Tag    string        `json:"tag"`
+	Digest digest.Digest `json:"digest"`
+	// Modified is the modification time of the current link of the tag.
+	Modified time.Time `json:"modified"`
+}
+
+// RepositoryManifest is a manifest revision listed by DescribeRepository.
+type RepositoryManifest struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType,omitempty"`
+	Size      int64         `json:"size"`
+	// Tags are the tags referencing the manifest.
+	Tags []string `json:"tags"`
+	// Blobs are the blobs, or the manifests of an index, referenced by the
+	// manifest.
+	Blobs []RepositoryBlob `json:"blobs"`
+	// Error is set if the manifest cannot be read.
+	Error string `json:"error,omitempty"`
+}
+
+// RepositoryBlob is a blob referenced by a manifest listed by
+// DescribeRepository.
+type RepositoryBlob struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType,omitempty"`
+	// Linked reports whether the repository links the blob, as a layer or,
+	// for the manifests of an index, as a manifest revision.
+	Linked bool `json:"linked"`
+	// Exists reports whether the blob is in the blob store, which gives its
+	// Size.
+	Exists bool  `json:"exists"`
+	Size   int64 `json:"size,omitempty"`
+	// Foreign is set for the layers stored outside of the registry, which
+	// are not expected to exist.
+	Foreign bool `json:"foreign,omitempty"`
+}
+
+// RepositoryTotals sums up the content of a repository described by
+// DescribeRepository.
+type RepositoryTotals struct {
+	Tags              int `json:"tags"`
+	Manifests         int `json:"manifests"`
+	UntaggedManifests int `json:"untaggedManifests"`
+	// Blobs counts the distinct blobs referenced by the manifests, and
+	// BlobSize sums the size of those which exist.
+	Blobs        int   `json:"blobs"`
+	MissingBlobs int   `json:"missingBlobs"`
+	BlobSize     int64 `json:"blobSize"`
+}
+
+// DescribeRepository lists the tags of repo, sorted by name, and then its
+// manifest revisions along with the blobs they reference, calling tagFn and
+// manifestFn with each of them as it goes, so that repositories with many
+// manifests are not held in memory. It returns the totals of the repository.
+// A manifest whose blob is missing counts as a missing blob.
+func DescribeRepository(ctx context.Context, repo distribution.Repository, tagFn func(RepositoryTag) error, manifestFn func(RepositoryManifest) error) (RepositoryTotals, error) {
+	var totals RepositoryTotals
+	r, ok := repo.(*repository)
+	if !ok {
+		return totals, fmt.Errorf("unable to describe repository of type %T", repo)
+	}
+
+	tagged, err := describeTags(ctx, r, tagFn)
+	if err != nil {
+		return totals, err
+	}
+	totals.Tags = len(tagged)
+	byManifest := make(map[digest.Digest][]string)
+	for _, tag := range tagged {
+		byManifest[tag.Digest] = append(byManifest[tag.Digest], tag.Tag)
+	}
+
+	manifests, err := r.Manifests(ctx)
+	if err != nil {
+		return totals, err
+	}
+	ms := manifests.(*manifestStore)
+	manifestStatter := &linkedBlobStatter{
+		blobStore:  r.blobStore,
+		repository: r,
+		linkPath:   manifestRevisionLinkPath,
+	}
+	layerStatter := &linkedBlobStatter{
+		blobStore:  r.blobStore,
+		repository: r,
+		linkPath:   blobLinkPath,
+	}
+	seen := make(map[digest.Digest]struct{})
+
+	err = ms.Enumerate(ctx, func(dgst digest.Digest) error {
+		totals.Manifests++
+		m := RepositoryManifest{
+			Digest: dgst,
+			Tags:   byManifest[dgst],
+			Blobs:  []RepositoryBlob{},
+		}
+		if m.Tags == nil {
+			m.Tags = []string{}
+			totals.UntaggedManifests++
+		}
+
+		desc, err := ms.Stat(ctx, dgst)
+		if err != nil {
+			if !errors.As(err, &distribution.ErrManifestUnknownRevision{}) {
+				return err
+			}
+			totals.MissingBlobs++
+			m.Error = "manifest blob unknown"
+			return manifestFn(m)
+		}
+		m.MediaType, m.Size = desc.MediaType, desc.Size
+
+		mfst, err := ms.Get(ctx, dgst)
+		if err != nil {
+			m.Error = err.Error()
+			return manifestFn(m)
+		}
+		statter := layerStatter
+		switch mfst.(type) {
+		case *ocischema.DeserializedImageIndex, *manifestlist.DeserializedManifestList:
+			statter = manifestStatter
+		}
+
+		for _, ref := range mfst.References() {
+			blob := RepositoryBlob{
+				Digest:    ref.Digest,
+				MediaType: ref.MediaType,
+				Foreign:   len(ref.URLs) > 0,
+			}
+			if blob.Linked, err = statter.linked(ctx, ref.Digest); err != nil {
+				return err
+			}
+			stat, err := r.blobStore.statter.Stat(ctx, ref.Digest)
+			switch {
+			case err == nil:
+				blob.Exists, blob.Size = true, stat.Size
+			case err != distribution.ErrBlobUnknown:
+				return err
+			}
+			m.Blobs = append(m.Blobs, blob)
+
+			if _, ok := seen[ref.Digest]; ok {
+				continue
+			}
+			seen[ref.Digest] = struct{}{}
+			totals.Blobs++
+			switch {
+			case blob.Exists:
+				totals.BlobSize += blob.Size
+			case !blob.Foreign:
+				totals.MissingBlobs++
+			}
+		}
+		return manifestFn(m)
+	})
+	if errors.As(err, &driver.PathNotFoundError{}) {
+		err = nil
+	}
+	return totals, err
+}
+
+// describeTags calls tagFn with each tag of repo, sorted by name, and returns
+// them.
+func describeTags(ctx context.Context, repo *repository, tagFn func(RepositoryTag) error) ([]RepositoryTag, error) {
+	tags := repo.Tags(ctx)
+	all, err := tags.All(ctx)
+	if errors.As(err, &distribution.ErrRepositoryUnknown{}) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(all)
+
+	described := make([]RepositoryTag, 0, len(all))
+	for _, tag := range all {
+		desc, err := tags.Get(ctx, tag)
+		if err != nil {
+			return nil, err
+		}
+		linkPath, err := pathFor(manifestTagCurrentPathSpec{name: repo.pathName(), tag: tag})
+		if err != nil {
+			return nil, err
+		}
+		fi, err := repo.driver.Stat(ctx, linkPath)
+		if err != nil {
+			return nil, err
+		}
+
+		t := RepositoryTag{Tag: tag, Digest: desc.Digest, Modified: fi.ModTime()}
+		if err := tagFn(t); err != nil {
+			return nil, err
+		}
+		described = append(described, t)
+	}
+	return described, nil
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestDescribeRepository(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := NewRegistry(ctx, d, EnableDelete)
+	if err != nil {
+		t.Fatalf("failed to construct registry: %v", err)
+	}
+	named, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	describe := func() ([]RepositoryTag, map[digest.Digest]RepositoryManifest, RepositoryTotals) {
+		t.Helper()
+		var tags []RepositoryTag
+		manifests := make(map[digest.Digest]RepositoryManifest)
+		totals, err := DescribeRepository(ctx, repo, func(tag RepositoryTag) error {
+			tags = append(tags, tag)
+			return nil
+		}, func(m RepositoryManifest) error {
+			manifests[m.Digest] = m
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tags, manifests, totals
+	}
+
+	// An unknown repository is empty.
+	tags, manifests, totals := describe()
+	if len(tags) != 0 || len(manifests) != 0 || totals != (RepositoryTotals{}) {
+		t.Fatalf("expected an empty repository, got %v, %v and %+v", tags, manifests, totals)
+	}
+
+	healthy, _ := pushTaggedImage(ctx, t, repo, "latest")
+	if err := repo.Tags(ctx).Tag(ctx, "v1", distribution.Descriptor{Digest: healthy}); err != nil {
+		t.Fatal(err)
+	}
+	broken, missingLayer := pushTaggedImage(ctx, t, repo, "broken")
+	untagged, _ := pushTaggedImage(ctx, t, repo, "untagged")
+	if err := repo.Tags(ctx).Untag(ctx, "untagged"); err != nil {
+		t.Fatal(err)
+	}
+	index, err := ocischema.FromDescriptors([]distribution.Descriptor{
+		{MediaType: v1.MediaTypeImageManifest, Digest: healthy, Size: 1},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDigest, err := ms.Put(ctx, index)
+	if err != nil {
+		t.Fatalf("index upload failed: %v", err)
+	}
+	p, _ := pathFor(blobDataPathSpec{digest: missingLayer})
+	if err := d.Delete(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	tags, manifests, totals = describe()
+	var tagged []string
+	for _, tag := range tags {
+		tagged = append(tagged, tag.Tag)
+		if tag.Modified.IsZero() {
+			t.Fatalf("expected the modification time of tag %s", tag.Tag)
+		}
+	}
+	if expected := []string{"broken", "latest", "v1"}; !reflect.DeepEqual(tagged, expected) {
+		t.Fatalf("unexpected tags: %v != %v", tagged, expected)
+	}
+
+	for dgst, expected := range map[digest.Digest][]string{
+		healthy:     {"latest", "v1"},
+		broken:      {"broken"},
+		untagged:    {},
+		indexDigest: {},
+	} {
+		m, ok := manifests[dgst]
+		if !ok {
+			t.Fatalf("manifest %s not listed", dgst)
+		}
+		sort.Strings(m.Tags)
+		if !reflect.DeepEqual(m.Tags, expected) {
+			t.Fatalf("unexpected tags of manifest %s: %v != %v", dgst, m.Tags, expected)
+		}
+		if m.MediaType == "" || m.Size == 0 {
+			t.Fatalf("expected the media type and size of manifest %s: %+v", dgst, m)
+		}
+	}
+
+	for _, blob := range manifests[broken].Blobs {
+		if missing := blob.Digest == missingLayer; blob.Exists == missing || !blob.Linked {
+			t.Fatalf("unexpected blob of the broken manifest: %+v", blob)
+		}
+	}
+	if expected := []RepositoryBlob{{Digest: healthy, MediaType: v1.MediaTypeImageManifest, Linked: true, Exists: true, Size: manifests[healthy].Size}}; !reflect.DeepEqual(manifests[indexDigest].Blobs, expected) {
+		t.Fatalf("unexpected blobs of the index: %+v != %+v", manifests[indexDigest].Blobs, expected)
+	}
+
+	if totals.Tags != 3 || totals.Manifests != 4 || totals.UntaggedManifests != 2 || totals.MissingBlobs != 1 {
+		t.Fatalf("unexpected totals: %+v", totals)
+	}
+	// The layers of the three images, the config they share, and the
+	// manifest of the index.
+	if totals.Blobs != 5 {
+		t.Fatalf("expected 5 distinct blobs, got %+v", totals)
+	}
+}
@@ -23,6 +23,9 @@ type schema2ManifestHandler struct {
 	blobStore    distribution.BlobStore
 	ctx          context.Context
 	manifestURLs manifestURLs
+
+	// verificationLimit is the number of references checked concurrently.
+	verificationLimit int
 }
 
 var _ ManifestHandler = &schema2ManifestHandler{}
@@ -68,8 +71,6 @@ func (ms *schema2ManifestHandler) Put(ctx context.Context, manifest distribution
 // perspective of the registry. As a policy, the registry only tries to store
 // valid content, leaving trust policies of that content up to consumers.
 func (ms *schema2ManifestHandler) verifyManifest(ctx context.Context, mnfst schema2.DeserializedManifest, skipDependencyVerification bool) error {
-	var errs distribution.ErrManifestVerification
-
 	if mnfst.Manifest.SchemaVersion != 2 {
 		return fmt.Errorf("unrecognized manifest schema version %d", mnfst.Manifest.SchemaVersion)
 	}
@@ -85,59 +86,61 @@ func (ms *schema2ManifestHandler) verifyManifest(ctx context.Context, mnfst sche
 
 	blobsService := ms.repository.Blobs(ctx)
 
-	for _, descriptor := range mnfst.References() {
-		err := descriptor.Digest.Validate()
-		if err != nil {
-			errs = append(errs, err, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest})
-			continue
-		}
+	return verifyReferences(ctx, ms.verificationLimit, mnfst.References(), func(ctx context.Context, descriptor distribution.Descriptor) []error {
+		return ms.verifyReference(ctx, manifestService, blobsService, descriptor)
+	})
+}
 
-		switch descriptor.MediaType {
-		case schema2.MediaTypeForeignLayer:
-			// Clients download this layer from an external URL, so do not check for
-			// its presence.
-			if len(descriptor.URLs) == 0 {
-				err = errMissingURL
-			}
-			allow := ms.manifestURLs.allow
-			deny := ms.manifestURLs.deny
-			for _, u := range descriptor.URLs {
-				var pu *url.URL
-				pu, err = url.Parse(u)
-				if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Fragment != "" || (allow != nil && !allow.MatchString(u)) || (deny != nil && deny.MatchString(u)) {
-					err = errInvalidURL
-					break
-				}
-			}
-		case schema2.MediaTypeManifest:
-			var exists bool
-			exists, err = manifestService.Exists(ctx, descriptor.Digest)
-			if err != nil || !exists {
-				err = distribution.ErrBlobUnknown // just coerce to unknown.
-			}
+// verifyReference returns the errors making the descriptor an invalid
+// reference of a manifest.
+func (ms *schema2ManifestHandler) verifyReference(ctx context.Context, manifestService distribution.ManifestService, blobsService distribution.BlobStatter, descriptor distribution.Descriptor) []error {
+	err := descriptor.Digest.Validate()
+	if err != nil {
+		return []error{err, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest}}
+	}
 
-			if err != nil {
-				dcontext.GetLogger(ms.ctx).WithError(err).Debugf("failed to ensure exists of %v in manifest service", descriptor.Digest)
+	switch descriptor.MediaType {
+	case schema2.MediaTypeForeignLayer:
+		// Clients download this layer from an external URL, so do not check for
+		// its presence.
+		if len(descriptor.URLs) == 0 {
+			err = errMissingURL
+		}
+		allow := ms.manifestURLs.allow
+		deny := ms.manifestURLs.deny
+		for _, u := range descriptor.URLs {
+			var pu *url.URL
+			pu, err = url.Parse(u)
+			if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Fragment != "" || (allow != nil && !allow.MatchString(u)) || (deny != nil && deny.MatchString(u)) {
+				err = errInvalidURL
+				break
 			}
-			fallthrough // double check the blob store.
-		default:
-			// check its presence
-			_, err = blobsService.Stat(ctx, descriptor.Digest)
+		}
+	case schema2.MediaTypeManifest:
+		var exists bool
+		exists, err = manifestService.Exists(ctx, descriptor.Digest)
+		if err != nil || !exists {
+			err = distribution.ErrBlobUnknown // just coerce to unknown.
 		}
 
 		if err != nil {
-			if err != distribution.ErrBlobUnknown {
-				errs = append(errs, err)
-			}
-
-			// On error here, we always append unknown blob errors.
-			errs = append(errs, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest})
+			dcontext.GetLogger(ms.ctx).WithError(err).Debugf("failed to ensure exists of %v in manifest service", descriptor.Digest)
 		}
+		fallthrough // double check the blob store.
+	default:
+		// check its presence
+		_, err = blobsService.Stat(ctx, descriptor.Digest)
+	}
+
+	if err == nil {
+		return nil
 	}
 
-	if len(errs) != 0 {
-		return errs
+	var errs []error
+	if err != distribution.ErrBlobUnknown {
+		errs = append(errs, err)
 	}
 
-	return nil
+	// On error here, we always append unknown blob errors.
+	return append(errs, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest})
 }
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 	"testing"
@@ -315,3 +316,113 @@ func TestVerifyManifestBlobLayerAndConfig(t *testing.T) {
 		checkFn(m, c.Err)
 	}
 }
+
+func TestVerifyManifestMissingReferences(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+	registry := createRegistry(t, inmemoryDriver, ManifestVerificationConcurrencyLimit(2))
+	repo := makeRepository(t, registry, strings.ToLower(t.Name()))
+	manifestService := makeManifestService(t, repo)
+
+	config, err := repo.Blobs(ctx).Put(ctx, schema2.MediaTypeImageConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layer, err := repo.Blobs(ctx).Put(ctx, schema2.MediaTypeLayer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layers := []distribution.Descriptor{layer}
+	var missing []digest.Digest
+	for i := 0; i < 5; i++ {
+		dgst := digest.FromString(fmt.Sprintf("missing layer %d", i))
+		missing = append(missing, dgst)
+		layers = append(layers, distribution.Descriptor{MediaType: schema2.MediaTypeLayer, Digest: dgst})
+	}
+	invalid := distribution.Descriptor{MediaType: schema2.MediaTypeLayer, Digest: "sha256:invalid"}
+	layers = append(layers, invalid)
+
+	dm, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     schema2.MediaTypeManifest,
+		},
+		Config: config,
+		Layers: layers,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = manifestService.Put(ctx, dm)
+	verr, ok := err.(distribution.ErrManifestVerification)
+	if !ok {
+		t.Fatalf("expected a verification error, got %v", err)
+	}
+
+	// The errors of all references are reported, in the order of the layers.
+	if len(verr) != len(missing)+2 {
+		t.Fatalf("unexpected errors: %v", verr)
+	}
+	for i, dgst := range missing {
+		if err, ok := verr[i].(distribution.ErrManifestBlobUnknown); !ok || err.Digest != dgst {
+			t.Errorf("expected unknown blob %s, got %v", dgst, verr[i])
+		}
+	}
+	if verr[len(missing)] != digest.ErrDigestInvalidLength {
+		t.Errorf("expected an invalid digest error, got %v", verr[len(missing)])
+	}
+	if err, ok := verr[len(missing)+1].(distribution.ErrManifestBlobUnknown); !ok || err.Digest != invalid.Digest {
+		t.Errorf("expected unknown blob %s, got %v", invalid.Digest, verr[len(missing)+1])
+	}
+}
+
+func TestVerifyManifestForeignLayerURLs(t *testing.T) {
+	ctx := dcontext.Background()
+	registry := createRegistry(t, inmemory.New(),
+		ManifestURLsAllowRegexp(regexp.MustCompile("^$")),
+		ForeignLayerURLsAllowRegexp(regexp.MustCompile("^https://mcr\\.microsoft\\.com/")),
+		ForeignLayerURLsDenyRegexp(regexp.MustCompile("/denied/")))
+	repo := makeRepository(t, registry, strings.ToLower(t.Name()))
+	manifestService := makeManifestService(t, repo)
+
+	config, err := repo.Blobs(ctx).Put(ctx, schema2.MediaTypeImageConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		url string
+		err error
+	}{
+		{"https://mcr.microsoft.com/v2/windows/blobs/sha256:abcd", nil},
+		{"https://mcr.microsoft.com/denied/blob", errInvalidURL},
+		{"https://example.com/blob", errInvalidURL},
+	} {
+		m, err := schema2.FromStruct(schema2.Manifest{
+			Versioned: schema2.SchemaVersion,
+			Config:    config,
+			Layers: []distribution.Descriptor{{
+				Digest:    digest.FromString(c.url),
+				Size:      6323,
+				MediaType: schema2.MediaTypeForeignLayer,
+				URLs:      []string{c.url},
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The foreign layer rules apply rather than the manifest ones,
+		// which allow no URL.
+		_, err = manifestService.Put(ctx, m)
+		if verr, ok := err.(distribution.ErrManifestVerification); ok && len(verr) == 2 {
+			err = verr[0]
+		}
+		if err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.url, c.err, err)
+		}
+	}
+}
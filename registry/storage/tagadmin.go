@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// TagDigest is a tag of a repository and the digest of the manifest it
+// references.
+type TagDigest struct {
+	Tag    string        `json:"tag"`
+	Digest digest.Digest `json:"digest"`
+}
+
+// ListTags returns the tags of repo, sorted by name, with the digests of the
+// manifests they reference.
+func ListTags(ctx context.Context, repo distribution.Repository) ([]TagDigest, error) {
+	tags := repo.Tags(ctx)
+	all, err := tags.All(ctx)
+	if err != nil {
+		if errors.As(err, &distribution.ErrRepositoryUnknown{}) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Strings(all)
+
+	listed := make([]TagDigest, 0, len(all))
+	for _, tag := range all {
+		desc, err := tags.Get(ctx, tag)
+		if err != nil {
+			return nil, err
+		}
+		listed = append(listed, TagDigest{Tag: tag, Digest: desc.Digest})
+	}
+	return listed, nil
+}
+
+// SetTag links tag of repo to the manifest dgst, which must be a manifest
+// revision of repo.
+func SetTag(ctx context.Context, repo distribution.Repository, tag string, dgst digest.Digest) error {
+	if _, err := reference.WithTag(repo.Named(), tag); err != nil {
+		return err
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	exists, err := manifests.Exists(ctx, dgst)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return distribution.ErrManifestUnknownRevision{Name: repo.Named().Name(), Revision: dgst}
+	}
+
+	return repo.Tags(ctx).Tag(ctx, tag, distribution.Descriptor{Digest: dgst})
+}
+
+// RemoveTag removes tag from repo.
+func RemoveTag(ctx context.Context, repo distribution.Repository, tag string) error {
+	err := repo.Tags(ctx).Untag(ctx, tag)
+	if errors.As(err, &driver.PathNotFoundError{}) {
+		return distribution.ErrTagUnknown{Tag: tag}
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+)
+
+func TestTagAdmin(t *testing.T) {
+	ctx := context.Background()
+	registry, err := NewRegistry(ctx, inmemory.New(), EnableDelete)
+	if err != nil {
+		t.Fatalf("failed to construct registry: %v", err)
+	}
+	named, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed, err := ListTags(ctx, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 0 {
+		t.Fatalf("expected no tags, got %v", listed)
+	}
+
+	dgst, layer := pushTaggedImage(ctx, t, repo, "latest")
+	if err := SetTag(ctx, repo, "v1", dgst); err != nil {
+		t.Fatal(err)
+	}
+	listed, err = ListTags(ctx, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []TagDigest{{Tag: "latest", Digest: dgst}, {Tag: "v1", Digest: dgst}}; !reflect.DeepEqual(listed, expected) {
+		t.Fatalf("unexpected tags: %v != %v", listed, expected)
+	}
+
+	// Tags only link to the manifest revisions of the repository.
+	if err := SetTag(ctx, repo, "v2", layer); !errors.As(err, &distribution.ErrManifestUnknownRevision{}) {
+		t.Fatalf("expected ErrManifestUnknownRevision, got %v", err)
+	}
+	if err := SetTag(ctx, repo, "-invalid", dgst); err == nil {
+		t.Fatal("expected an invalid tag to be rejected")
+	}
+
+	if err := RemoveTag(ctx, repo, "latest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveTag(ctx, repo, "latest"); !errors.As(err, &distribution.ErrTagUnknown{}) {
+		t.Fatalf("expected ErrTagUnknown, got %v", err)
+	}
+	listed, err = ListTags(ctx, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []TagDigest{{Tag: "v1", Digest: dgst}}; !reflect.DeepEqual(listed, expected) {
+		t.Fatalf("unexpected tags: %v != %v", listed, expected)
+	}
+}
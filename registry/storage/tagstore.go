@@ -7,13 +7,17 @@ import (
 	"sync"
 
 	"github.com/opencontainers/go-digest"
-	"golang.org/x/sync/errgroup"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/workers"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 )
 
-var _ distribution.TagService = &tagStore{}
+var (
+	_ distribution.TagService   = &tagStore{}
+	_ distribution.TagCounter   = &tagStore{}
+	_ distribution.TagDescriber = &tagStore{}
+)
 
 // tagStore provides methods to manage manifest tags in a backend storage driver.
 // This implementation uses the same on-disk layout as the (now deleted) tag
@@ -24,12 +28,13 @@ type tagStore struct {
 	repository       *repository
 	blobStore        *blobStore
 	concurrencyLimit int
+	workers          *workers.Pool
 }
 
 // All returns all tags
 func (ts *tagStore) All(ctx context.Context) ([]string, error) {
 	pathSpec, err := pathFor(manifestTagsPathSpec{
-		name: ts.repository.Named().Name(),
+		name: ts.repository.pathName(),
 	})
 	if err != nil {
 		return nil, err
@@ -58,17 +63,53 @@ func (ts *tagStore) All(ctx context.Context) ([]string, error) {
 	return tags, nil
 }
 
+// Count returns the number of tags, without sorting them.
+func (ts *tagStore) Count(ctx context.Context) (int, error) {
+	pathSpec, err := pathFor(manifestTagsPathSpec{
+		name: ts.repository.pathName(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := ts.blobStore.driver.List(ctx, pathSpec)
+	if err != nil {
+		switch err := err.(type) {
+		case storagedriver.PathNotFoundError:
+			return 0, distribution.ErrRepositoryUnknown{Name: ts.repository.Named().Name()}
+		default:
+			return 0, err
+		}
+	}
+	return len(entries), nil
+}
+
 // Tag tags the digest with the given tag, updating the store to point at
-// the current tag. The digest must point to a manifest.
+// the current tag. The digest must point to a manifest. An immutable tag
+// referencing another manifest is left unchanged, and ErrTagImmutable
+// returned.
 func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
 	currentPath, err := pathFor(manifestTagCurrentPathSpec{
-		name: ts.repository.Named().Name(),
+		name: ts.repository.pathName(),
 		tag:  tag,
 	})
 	if err != nil {
 		return err
 	}
 
+	if ts.repository.immutableTag(tag) {
+		ts.repository.immutableTagsMu.Lock()
+		defer ts.repository.immutableTagsMu.Unlock()
+
+		current, err := ts.Get(ctx, tag)
+		if err == nil && current.Digest != desc.Digest {
+			return distribution.ErrTagImmutable{Tag: tag, Digest: current.Digest}
+		}
+		if _, ok := err.(distribution.ErrTagUnknown); err != nil && !ok {
+			return err
+		}
+	}
+
 	lbs := ts.linkedBlobStore(ctx, tag)
 
 	// Link into the index
@@ -83,7 +124,7 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descr
 // resolve the current revision for name and tag.
 func (ts *tagStore) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
 	currentPath, err := pathFor(manifestTagCurrentPathSpec{
-		name: ts.repository.Named().Name(),
+		name: ts.repository.pathName(),
 		tag:  tag,
 	})
 	if err != nil {
@@ -106,13 +147,16 @@ func (ts *tagStore) Get(ctx context.Context, tag string) (distribution.Descripto
 // Untag removes the tag association
 func (ts *tagStore) Untag(ctx context.Context, tag string) error {
 	tagPath, err := pathFor(manifestTagPathSpec{
-		name: ts.repository.Named().Name(),
+		name: ts.repository.pathName(),
 		tag:  tag,
 	})
 	if err != nil {
 		return err
 	}
 
+	if ts.repository.softDelete {
+		return ts.repository.trashLinks(ctx, tagPath)
+	}
 	return ts.blobStore.driver.Delete(ctx, tagPath)
 }
 
@@ -149,7 +193,7 @@ func (ts *tagStore) Lookup(ctx context.Context, desc distribution.Descriptor) ([
 		return nil, err
 	}
 
-	g, ctx := errgroup.WithContext(ctx)
+	g, ctx := ts.workers.WithContext(ctx)
 	g.SetLimit(ts.concurrencyLimit)
 
 	var (
@@ -164,7 +208,7 @@ func (ts *tagStore) Lookup(ctx context.Context, desc distribution.Descriptor) ([
 
 		g.Go(func() error {
 			tagLinkPathSpec := manifestTagCurrentPathSpec{
-				name: ts.repository.Named().Name(),
+				name: ts.repository.pathName(),
 				tag:  tag,
 			}
 
@@ -215,7 +259,7 @@ func (ts *tagStore) ManifestDigests(ctx context.Context, tag string) ([]digest.D
 		ctx:        ctx,
 		linkPath:   tagLinkPath,
 		linkDirectoryPathSpec: manifestTagIndexPathSpec{
-			name: ts.repository.Named().Name(),
+			name: ts.repository.pathName(),
 			tag:  tag,
 		},
 	}
@@ -229,3 +273,54 @@ func (ts *tagStore) ManifestDigests(ctx context.Context, tag string) ([]digest.D
 	}
 	return dgsts, nil
 }
+
+// Describe returns the details of tag: the modification time of its current
+// link, and of the links of its index to the manifests it referenced.
+func (ts *tagStore) Describe(ctx context.Context, tag string) (distribution.TagDetails, error) {
+	desc, err := ts.Get(ctx, tag)
+	if err != nil {
+		return distribution.TagDetails{}, err
+	}
+	currentPath, err := pathFor(manifestTagCurrentPathSpec{
+		name: ts.repository.pathName(),
+		tag:  tag,
+	})
+	if err != nil {
+		return distribution.TagDetails{}, err
+	}
+	fi, err := ts.blobStore.driver.Stat(ctx, currentPath)
+	if err != nil {
+		return distribution.TagDetails{}, err
+	}
+	details := distribution.TagDetails{
+		Descriptor:   desc,
+		LastModified: fi.ModTime(),
+	}
+
+	dgsts, err := ts.ManifestDigests(ctx, tag)
+	if err != nil {
+		return distribution.TagDetails{}, err
+	}
+	for _, dgst := range dgsts {
+		linkPath, err := pathFor(manifestTagIndexEntryLinkPathSpec{
+			name:     ts.repository.pathName(),
+			tag:      tag,
+			revision: dgst,
+		})
+		if err != nil {
+			return distribution.TagDetails{}, err
+		}
+		fi, err := ts.blobStore.driver.Stat(ctx, linkPath)
+		if err != nil {
+			return distribution.TagDetails{}, err
+		}
+		details.History = append(details.History, distribution.TagHistoryEntry{Digest: dgst, Tagged: fi.ModTime()})
+	}
+	sort.Slice(details.History, func(i, j int) bool {
+		if !details.History[i].Tagged.Equal(details.History[j].Tagged) {
+			return details.History[i].Tagged.Before(details.History[j].Tagged)
+		}
+		return details.History[i].Digest < details.History[j].Digest
+	})
+	return details, nil
+}
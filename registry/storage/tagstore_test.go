@@ -2,10 +2,14 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/workers"
 	"github.com/distribution/distribution/v3/manifest"
 	"github.com/distribution/distribution/v3/manifest/schema2"
 	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
@@ -165,6 +169,31 @@ func TestTagStoreAll(t *testing.T) {
 	}
 }
 
+func TestTagStoreCount(t *testing.T) {
+	env := testTagStore(t)
+	tagStore := env.ts
+	counter := tagStore.(distribution.TagCounter)
+	ctx := env.ctx
+
+	if _, err := counter.Count(ctx); !errors.As(err, &distribution.ErrRepositoryUnknown{}) {
+		t.Fatalf("expected ErrRepositoryUnknown counting the tags of an unknown repository, got %v", err)
+	}
+
+	desc := distribution.Descriptor{Digest: "sha256:eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"}
+	for _, tag := range []string{"c", "a", "b"} {
+		if err := tagStore.Tag(ctx, tag, desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := counter.Count(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error counting tags: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("unexpected count of tags: %d", n)
+	}
+}
+
 func TestTagLookup(t *testing.T) {
 	env := testTagStore(t)
 	tagStore := env.ts
@@ -220,6 +249,60 @@ func TestTagLookup(t *testing.T) {
 	}
 }
 
+// TestTagLookupWorkers ensures that the lookups complete whatever the limit
+// of the workers, including the lookups of other workers, such as those of a
+// notification sink.
+func TestTagLookupWorkers(t *testing.T) {
+	for _, limit := range []int{1, 2, 8} {
+		ctx := context.Background()
+		sem := workers.NewSemaphore(limit)
+		reg, err := NewRegistry(ctx, inmemory.New(), TagLookupWorkers(workers.New("tag_lookup", sem)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		repoRef, _ := reference.WithName("a/b")
+		repo, err := reg.Repository(ctx, repoRef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tagStore := repo.Tags(ctx)
+
+		desc := distribution.Descriptor{Digest: "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}
+		for _, tag := range []string{"a", "b", "c", "d", "e"} {
+			if err := tagStore.Tag(ctx, tag, desc); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		g, _ := workers.New("notifications", sem).WithContext(ctx)
+		results := make([][]string, 4)
+		for i := range results {
+			i := i
+			g.Go(func() error {
+				tags, err := tagStore.Lookup(context.Background(), desc)
+				sort.Strings(tags)
+				results[i] = tags
+				return err
+			})
+		}
+		done := make(chan error)
+		go func() { done <- g.Wait() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("limit %d: the lookups deadlocked", limit)
+		}
+		for _, tags := range results {
+			if expected := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(tags, expected) {
+				t.Fatalf("limit %d: unexpected tags: %v != %v", limit, tags, expected)
+			}
+		}
+	}
+}
+
 func TestTagIndexes(t *testing.T) {
 	env := testTagStore(t)
 	tagStore := env.ts
@@ -313,3 +396,69 @@ func digestMap(dgsts []digest.Digest) map[digest.Digest]struct{} {
 	}
 	return set
 }
+
+func TestTagDescribe(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	d := inmemory.NewWithParameters(inmemory.DriverParameters{Clock: func() time.Time { return now }})
+	reg, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoRef, _ := reference.WithName("a/b")
+	repo, err := reg.Repository(ctx, repoRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagStore := repo.Tags(ctx)
+	describer, ok := tagStore.(distribution.TagDescriber)
+	if !ok {
+		t.Fatal("tagStore does not implement TagDescriber interface")
+	}
+
+	if _, err := describer.Describe(ctx, "latest"); !errors.As(err, &distribution.ErrTagUnknown{}) {
+		t.Fatalf("expected ErrTagUnknown, got %v", err)
+	}
+
+	// Tag three revisions, an hour apart, and tag the first again.
+	manifests, err := repo.Manifests(ctx, SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dgsts []digest.Digest
+	for i := 0; i < 3; i++ {
+		dm, err := schema2.FromStruct(schema2.Manifest{
+			Versioned: schema2.SchemaVersion,
+			Config:    distribution.Descriptor{MediaType: schema2.MediaTypeImageConfig, Digest: digest.FromBytes([]byte{byte(i)}), Size: 1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dgst, err := manifests.Put(ctx, dm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dgsts = append(dgsts, dgst)
+	}
+	var expected []distribution.TagHistoryEntry
+	for i, dgst := range append(dgsts, dgsts[0]) {
+		now = now.Add(time.Hour)
+		if err := tagStore.Tag(ctx, "latest", distribution.Descriptor{Digest: dgst}); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 {
+			expected = append(expected, distribution.TagHistoryEntry{Digest: dgst, Tagged: now})
+		}
+	}
+
+	details, err := describer.Describe(ctx, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Descriptor.Digest != dgsts[0] || !details.LastModified.Equal(now) {
+		t.Fatalf("unexpected details: %+v", details)
+	}
+	if !reflect.DeepEqual(details.History, expected) {
+		t.Fatalf("unexpected history: %+v, expected %+v", details.History, expected)
+	}
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	storageDriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// trashTimeFormat is the format of the names of the trash entries, the
+// directories holding the links deleted at a given time. It sorts in the
+// order of deletion.
+const trashTimeFormat = "20060102T150405.000000000Z"
+
+// SoftDelete is a functional option for NewRegistry. It causes the links of
+// deleted manifests and tags to be moved to the trash of their repository
+// rather than removed, so that they can be restored with Restore until they
+// are purged with PurgeTrash. It only has an effect if deletes are enabled.
+func SoftDelete(registry *registry) error {
+	registry.softDelete = true
+	return nil
+}
+
+// trashLinks moves the link, or the directory of links, at p to a new entry
+// of the trash of the repository.
+func (repo *repository) trashLinks(ctx context.Context, p string) error {
+	repoPath, err := pathFor(repositoryPathSpec{name: repo.pathName()})
+	if err != nil {
+		return err
+	}
+	trashPath, err := pathFor(trashPathSpec{name: repo.pathName()})
+	if err != nil {
+		return err
+	}
+	entry := path.Join(trashPath, time.Now().UTC().Format(trashTimeFormat))
+
+	files, err := listFiles(ctx, repo.driver, p)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := repo.driver.Move(ctx, file, path.Join(entry, strings.TrimPrefix(file, repoPath))); err != nil {
+			return err
+		}
+	}
+
+	// Remove the directories left behind.
+	err = repo.driver.Delete(ctx, p)
+	if errors.As(err, &storageDriver.PathNotFoundError{}) {
+		return nil
+	}
+	return err
+}
+
+// listFiles returns the files at or below p.
+func listFiles(ctx context.Context, driver storageDriver.StorageDriver, p string) ([]string, error) {
+	fi, err := driver.Stat(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{p}, nil
+	}
+
+	var files []string
+	err = driver.Walk(ctx, p, func(fileInfo storageDriver.FileInfo) error {
+		if !fileInfo.IsDir() {
+			files = append(files, fileInfo.Path())
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Restore moves back the links of the manifest or tag ref of repo, deleted
+// with soft deletion enabled, from the most recent trash entry holding them.
+// A manifest can only be restored while its blob has not been removed by
+// garbage collection, and a tag only once the manifest it references is
+// linked in the repository.
+func Restore(ctx context.Context, repo distribution.Repository, ref string) error {
+	r, ok := repo.(*repository)
+	if !ok {
+		return fmt.Errorf("unable to restore in repository of type %T", repo)
+	}
+
+	repoPath, err := pathFor(repositoryPathSpec{name: r.pathName()})
+	if err != nil {
+		return err
+	}
+
+	var linkPath, restorePath string
+	if dgst, err := digest.Parse(ref); err == nil {
+		linkPath, err = manifestRevisionLinkPath(r.pathName(), dgst)
+		if err != nil {
+			return err
+		}
+		restorePath = linkPath
+	} else {
+		if _, err := reference.WithTag(r.Named(), ref); err != nil {
+			return err
+		}
+		linkPath, err = pathFor(manifestTagCurrentPathSpec{name: r.pathName(), tag: ref})
+		if err != nil {
+			return err
+		}
+		restorePath, err = pathFor(manifestTagPathSpec{name: r.pathName(), tag: ref})
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := r.driver.Stat(ctx, linkPath); err == nil {
+		return fmt.Errorf("%s is not deleted from %s", ref, r.Named().Name())
+	} else if !errors.As(err, &storageDriver.PathNotFoundError{}) {
+		return err
+	}
+
+	entry, err := r.findTrashEntry(ctx, strings.TrimPrefix(linkPath, repoPath))
+	if err != nil {
+		return err
+	}
+	if entry == "" {
+		return fmt.Errorf("%s is not in the trash of %s", ref, r.Named().Name())
+	}
+
+	dgst, err := r.blobStore.readlink(ctx, path.Join(entry, strings.TrimPrefix(linkPath, repoPath)))
+	if err != nil {
+		return err
+	}
+	if linkPath == restorePath {
+		if _, err := r.statter.Stat(ctx, dgst); err != nil {
+			if errors.Is(err, distribution.ErrBlobUnknown) {
+				return fmt.Errorf("manifest %s was removed by garbage collection", dgst)
+			}
+			return err
+		}
+		if r.refIndex != nil {
+			if err := r.countRestoredManifest(ctx, dgst); err != nil {
+				return err
+			}
+		}
+		if err := r.restoreReferrer(ctx, dgst); err != nil {
+			return err
+		}
+	} else {
+		revisionPath, err := manifestRevisionLinkPath(r.pathName(), dgst)
+		if err != nil {
+			return err
+		}
+		if _, err := r.driver.Stat(ctx, revisionPath); err != nil {
+			if errors.As(err, &storageDriver.PathNotFoundError{}) {
+				return fmt.Errorf("tag %s references manifest %s which is deleted, restore it first", ref, dgst)
+			}
+			return err
+		}
+	}
+
+	trashed := path.Join(entry, strings.TrimPrefix(restorePath, repoPath))
+	files, err := listFiles(ctx, r.driver, trashed)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := r.driver.Move(ctx, file, path.Join(repoPath, strings.TrimPrefix(file, entry))); err != nil {
+			return err
+		}
+	}
+
+	// Remove the directories left behind, and the entry if it is empty.
+	if err := r.driver.Delete(ctx, trashed); err != nil && !errors.As(err, &storageDriver.PathNotFoundError{}) {
+		return err
+	}
+	remaining, err := listFiles(ctx, r.driver, entry)
+	if err != nil && !errors.As(err, &storageDriver.PathNotFoundError{}) {
+		return err
+	}
+	if len(remaining) == 0 {
+		if err := r.driver.Delete(ctx, entry); err != nil && !errors.As(err, &storageDriver.PathNotFoundError{}) {
+			return err
+		}
+	}
+	return nil
+}
+
+// countRestoredManifest counts the references of the manifest dgst in the
+// blob reference index, before its link is restored.
+func (repo *repository) countRestoredManifest(ctx context.Context, dgst digest.Digest) error {
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	ms, ok := manifests.(*manifestStore)
+	if !ok {
+		return fmt.Errorf("unable to count references with manifest service of type %T", manifests)
+	}
+	content, err := repo.blobStore.Get(ctx, dgst)
+	if err != nil {
+		return err
+	}
+	mfst, err := ms.unmarshal(ctx, dgst, content)
+	if err != nil {
+		return err
+	}
+	return repo.refIndex.add(ctx, dgst, mfst)
+}
+
+// findTrashEntry returns the most recent trash entry of the repository
+// holding the file at p, relative to the repository directory, or an empty
+// string if there is none.
+func (repo *repository) findTrashEntry(ctx context.Context, p string) (string, error) {
+	trashPath, err := pathFor(trashPathSpec{name: repo.pathName()})
+	if err != nil {
+		return "", err
+	}
+	entries, err := repo.driver.List(ctx, trashPath)
+	if errors.As(err, &storageDriver.PathNotFoundError{}) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(entries)))
+	for _, entry := range entries {
+		if _, err := repo.driver.Stat(ctx, path.Join(entry, p)); err == nil {
+			return entry, nil
+		} else if !errors.As(err, &storageDriver.PathNotFoundError{}) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// PurgeTrash deletes the trash entries of all repositories holding links
+// deleted before olderThan. The entries deleted and errors encountered are
+// returned.
+func PurgeTrash(ctx context.Context, driver storageDriver.StorageDriver, olderThan time.Time, actuallyDelete bool) ([]string, []error) {
+	logrus.Infof("PurgeTrash starting: olderThan=%s, actuallyDelete=%t", olderThan, actuallyDelete)
+	var (
+		deleted []string
+		errs    []error
+	)
+
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return nil, append(errs, err)
+	}
+
+	err = driver.Walk(ctx, root, func(fileInfo storageDriver.FileInfo) error {
+		if !fileInfo.IsDir() {
+			return nil
+		}
+		_, file := path.Split(fileInfo.Path())
+		if file != "_trash" {
+			if strings.HasPrefix(file, "_") {
+				// Reserved directory
+				return storageDriver.ErrSkipDir
+			}
+			return nil
+		}
+
+		entries, err := driver.List(ctx, fileInfo.Path())
+		if err != nil {
+			errs = pushError(errs, fileInfo.Path(), err)
+			return storageDriver.ErrSkipDir
+		}
+		for _, entry := range entries {
+			deletedAt, err := time.Parse(trashTimeFormat, path.Base(entry))
+			if err != nil {
+				errs = pushError(errs, entry, err)
+				continue
+			}
+			if !deletedAt.Before(olderThan) {
+				continue
+			}
+			logrus.Infof("Trash entry %s is older (%s) than purge date (%s).  Removing trash entry.", entry, deletedAt, olderThan)
+			if actuallyDelete {
+				err = driver.Delete(ctx, entry)
+			}
+			if err == nil {
+				deleted = append(deleted, entry)
+			} else {
+				errs = append(errs, err)
+			}
+		}
+		return storageDriver.ErrSkipDir
+	})
+	if err != nil {
+		errs = pushError(errs, root, err)
+	}
+
+	logrus.Infof("Purge trash finished.  Num deleted=%d, num errors=%d", len(deleted), len(errs))
+	return deleted, errs
+}
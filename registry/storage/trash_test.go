@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestSoftDeleteRestore(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, SoftDelete)
+	repo := makeRepository(t, registry, "palaiologos")
+	manifests := makeManifestService(t, repo)
+	tags := repo.Tags(ctx)
+
+	image := uploadRandomSchema2Image(t, repo)
+	if err := tags.Tag(ctx, "latest", distribution.Descriptor{Digest: image.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manifests.Delete(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("failed deleting manifest: %v", err)
+	}
+	if err := tags.Untag(ctx, "latest"); err != nil {
+		t.Fatalf("failed deleting tag: %v", err)
+	}
+	if exists, err := manifests.Exists(ctx, image.manifestDigest); err != nil || exists {
+		t.Fatalf("expected deleted manifest to be missing: %t, %v", exists, err)
+	}
+	if _, err := tags.Get(ctx, "latest"); err == nil {
+		t.Fatal("expected deleted tag to be missing")
+	}
+
+	trashPath, err := pathFor(trashPathSpec{name: "palaiologos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := inmemoryDriver.List(ctx, trashPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected a trash entry per deletion, got %v", entries)
+	}
+
+	// The tag references a deleted manifest.
+	if err := Restore(ctx, repo, "latest"); err == nil {
+		t.Fatal("expected an error restoring a tag of a deleted manifest")
+	}
+	if err := Restore(ctx, repo, image.manifestDigest.String()); err != nil {
+		t.Fatalf("failed restoring manifest: %v", err)
+	}
+	if err := Restore(ctx, repo, "latest"); err != nil {
+		t.Fatalf("failed restoring tag: %v", err)
+	}
+
+	if _, err := manifests.Get(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("failed getting restored manifest: %v", err)
+	}
+	desc, err := tags.Get(ctx, "latest")
+	if err != nil {
+		t.Fatalf("failed getting restored tag: %v", err)
+	}
+	if desc.Digest != image.manifestDigest {
+		t.Fatalf("restored tag references %s, expected %s", desc.Digest, image.manifestDigest)
+	}
+
+	for _, ref := range []string{"latest", image.manifestDigest.String(), "unknown"} {
+		if err := Restore(ctx, repo, ref); err == nil {
+			t.Errorf("expected an error restoring %s", ref)
+		}
+	}
+	if files, err := listFiles(ctx, inmemoryDriver, trashPath); err == nil && len(files) > 0 {
+		t.Fatalf("expected the trash to be empty, got %v", files)
+	}
+}
+
+func TestSoftDeleteGarbageCollect(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, SoftDelete)
+	repo := makeRepository(t, registry, "palaiologos")
+	manifests := makeManifestService(t, repo)
+
+	image := uploadRandomSchema2Image(t, repo)
+	if err := manifests.Delete(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("failed deleting manifest: %v", err)
+	}
+
+	// Trashed links do not reference blobs.
+	_, err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	blobs := allBlobs(t, registry)
+	if _, ok := blobs[image.manifestDigest]; ok {
+		t.Fatal("trashed manifest was not garbage collected")
+	}
+	for layer := range image.layers {
+		if _, ok := blobs[layer]; ok {
+			t.Fatalf("layer of trashed manifest was not garbage collected: %v", layer)
+		}
+	}
+
+	if err := Restore(ctx, repo, image.manifestDigest.String()); err == nil {
+		t.Fatal("expected an error restoring a garbage collected manifest")
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, SoftDelete)
+	repo := makeRepository(t, registry, "palaiologos")
+	manifests := makeManifestService(t, repo)
+
+	image := uploadRandomSchema2Image(t, repo)
+	if err := manifests.Delete(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("failed deleting manifest: %v", err)
+	}
+
+	deleted, errs := PurgeTrash(ctx, inmemoryDriver, time.Now().Add(-time.Hour), true)
+	if len(errs) > 0 || len(deleted) > 0 {
+		t.Fatalf("unexpected purge of recent trash: %v, %v", deleted, errs)
+	}
+	deleted, errs = PurgeTrash(ctx, inmemoryDriver, time.Now().Add(time.Second), false)
+	if len(errs) > 0 || len(deleted) != 1 {
+		t.Fatalf("unexpected dry run purge: %v, %v", deleted, errs)
+	}
+	deleted, errs = PurgeTrash(ctx, inmemoryDriver, time.Now().Add(time.Second), true)
+	if len(errs) > 0 || len(deleted) != 1 {
+		t.Fatalf("unexpected purge: %v, %v", deleted, errs)
+	}
+
+	if err := Restore(ctx, repo, image.manifestDigest.String()); err == nil {
+		t.Fatal("expected an error restoring a purged manifest")
+	}
+}
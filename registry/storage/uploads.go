@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+var _ distribution.BlobUploadLister = &linkedBlobStore{}
+
+// Uploads returns the uploads in progress of the repository, from their
+// state in the storage. The uploads whose startedat file is missing are
+// unknown, as for Resume, and not listed.
+func (lbs *linkedBlobStore) Uploads(ctx context.Context) ([]distribution.BlobUpload, error) {
+	uploadsPath, err := pathFor(uploadsPathSpec{name: lbs.repository.pathName()})
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := lbs.driver.List(ctx, uploadsPath)
+	if err != nil {
+		if errors.As(err, &driver.PathNotFoundError{}) {
+			return []distribution.BlobUpload{}, nil
+		}
+		return nil, err
+	}
+
+	uploads := []distribution.BlobUpload{}
+	for _, dir := range dirs {
+		upload, err := lbs.upload(ctx, path.Base(dir))
+		if err != nil {
+			// The upload was completed or cancelled since it was listed.
+			if errors.Is(err, distribution.ErrBlobUploadUnknown) {
+				continue
+			}
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].ID < uploads[j].ID })
+	return uploads, nil
+}
+
+// upload describes the upload id from its startedat and data files.
+func (lbs *linkedBlobStore) upload(ctx context.Context, id string) (distribution.BlobUpload, error) {
+	startedAtPath, err := pathFor(uploadStartedAtPathSpec{name: lbs.repository.pathName(), id: id})
+	if err != nil {
+		return distribution.BlobUpload{}, err
+	}
+	startedAt, err := readStartedAtFile(ctx, lbs.driver, startedAtPath)
+	if err != nil {
+		if errors.As(err, &driver.PathNotFoundError{}) {
+			return distribution.BlobUpload{}, distribution.ErrBlobUploadUnknown
+		}
+		return distribution.BlobUpload{}, err
+	}
+
+	upload := distribution.BlobUpload{ID: id, StartedAt: startedAt, LastActivity: startedAt}
+	dataPath, err := pathFor(uploadDataPathSpec{name: lbs.repository.pathName(), id: id})
+	if err != nil {
+		return distribution.BlobUpload{}, err
+	}
+	fi, err := lbs.driver.Stat(ctx, dataPath)
+	switch {
+	case err == nil:
+		upload.Size = fi.Size()
+		if fi.ModTime().After(startedAt) {
+			upload.LastActivity = fi.ModTime().UTC()
+		}
+	case errors.As(err, &driver.PathNotFoundError{}):
+		// No bytes were received yet.
+	default:
+		return distribution.BlobUpload{}, err
+	}
+	return upload, nil
+}
+
+// CancelUpload cancels the upload id, as its client would, removing its
+// state from the storage.
+func (lbs *linkedBlobStore) CancelUpload(ctx context.Context, id string) error {
+	bw, err := lbs.Resume(ctx, id)
+	if err != nil {
+		return err
+	}
+	return bw.Cancel(ctx)
+}
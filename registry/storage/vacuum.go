@@ -76,6 +76,12 @@ func (v Vacuum) RemoveManifest(name string, dgst digest.Digest, tags []string) e
 		}
 	}
 
+	// The entry of the manifest in the referrers of its subject is recorded
+	// in the revision directory, so it is removed first.
+	if err := removeReferrer(v.ctx, v.driver, name, dgst); err != nil {
+		return err
+	}
+
 	manifestPath, err := pathFor(manifestRevisionPathSpec{name: name, revision: dgst})
 	if err != nil {
 		return err
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/opencontainers/go-digest"
+)
+
+// corruptContent is the number of reads of stored content not matching its
+// digest, by type of content.
+var corruptContent = prometheus.StorageNamespace.NewLabeledCounter("corrupt_content", "The number of reads of stored content not matching its digest", "type")
+
+// VerifyOnRead is a functional option for NewRegistry. It causes the content
+// of manifests to be verified against their digest before they are served,
+// and blobs served whole without redirect to be verified as they are
+// written. Blobs larger than maxSize bytes are not verified, unless maxSize
+// is zero.
+func VerifyOnRead(maxSize int64) RegistryOption {
+	return func(registry *registry) error {
+		registry.verifyOnRead = true
+		registry.blobServer.verifyOnRead = true
+		registry.blobServer.verifyMaxSize = maxSize
+		return nil
+	}
+}
+
+// verifyContent verifies that content, the manifest read for dgst, has the
+// digest dgst.
+func verifyContent(ctx context.Context, dgst digest.Digest, content []byte) error {
+	if actual := dgst.Algorithm().FromBytes(content); actual != dgst {
+		return contentCorrupt(ctx, "manifest", dgst, actual)
+	}
+	return nil
+}
+
+// contentCorrupt reports content of the given type read for dgst but
+// digested as actual.
+func contentCorrupt(ctx context.Context, contentType string, dgst, actual digest.Digest) error {
+	dcontext.GetLoggerWithFields(ctx, map[interface{}]interface{}{
+		"digest": dgst,
+		"actual": actual,
+	}, "digest", "actual").Errorf("stored %s content is corrupt: it does not match its digest", contentType)
+	corruptContent.WithValues(contentType).Inc(1)
+	return distribution.ErrContentCorrupt{Digest: dgst, Actual: actual}
+}
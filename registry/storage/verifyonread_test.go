@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+// corruptBlob overwrites the data of blob dgst with content of the same size.
+func corruptBlob(t *testing.T, ctx context.Context, d driver.StorageDriver, dgst digest.Digest) {
+	p, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := d.GetContent(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, p, bytes.Repeat([]byte{'x'}, len(content))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyOnReadManifest(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d, VerifyOnRead(0))
+	repo := makeRepository(t, registry, "verified")
+	manifests := makeManifestService(t, repo)
+
+	image := uploadRandomSchema2Image(t, repo)
+	if _, err := manifests.Get(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("unexpected error getting manifest: %v", err)
+	}
+
+	corruptBlob(t, ctx, d, image.manifestDigest)
+	_, err := manifests.Get(ctx, image.manifestDigest)
+	var corrupt distribution.ErrContentCorrupt
+	if !errors.As(err, &corrupt) {
+		t.Fatalf("expected ErrContentCorrupt, got %v", err)
+	}
+	if corrupt.Digest != image.manifestDigest || corrupt.Actual == image.manifestDigest {
+		t.Fatalf("unexpected error: %#v", corrupt)
+	}
+}
+
+func TestVerifyOnReadBlob(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d, VerifyOnRead(1<<20))
+	repo := makeRepository(t, registry, "verified")
+	blobs := repo.Blobs(ctx)
+
+	small := []byte("small blob content")
+	smallDesc, err := blobs.Put(ctx, "application/octet-stream", small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := bytes.Repeat([]byte("large"), 1<<20)
+	largeDesc, err := blobs.Put(ctx, "application/octet-stream", large)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(dgst digest.Digest, header http.Header) (*httptest.ResponseRecorder, error) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		return w, blobs.ServeBlob(ctx, w, r, dgst)
+	}
+
+	w, err := serve(smallDesc.Digest, nil)
+	if err != nil {
+		t.Fatalf("unexpected error serving blob: %v", err)
+	}
+	if w.Header().Get("Content-Length") != "" {
+		t.Fatalf("unexpected Content-Length for verified blob: %s", w.Header().Get("Content-Length"))
+	}
+	if !bytes.Equal(w.Body.Bytes(), small) {
+		t.Fatalf("unexpected content: %q", w.Body.Bytes())
+	}
+
+	corruptBlob(t, ctx, d, smallDesc.Digest)
+	corruptBlob(t, ctx, d, largeDesc.Digest)
+
+	if _, err := serve(smallDesc.Digest, nil); !errors.As(err, &distribution.ErrContentCorrupt{}) {
+		t.Fatalf("expected ErrContentCorrupt, got %v", err)
+	}
+
+	// Blobs over the size limit, and parts of blobs, are not verified.
+	w, err = serve(largeDesc.Digest, nil)
+	if err != nil {
+		t.Fatalf("unexpected error serving large blob: %v", err)
+	}
+	if w.Header().Get("Content-Length") == "" {
+		t.Fatal("expected Content-Length for unverified blob")
+	}
+	w, err = serve(smallDesc.Digest, http.Header{"Range": []string{"bytes=0-4"}})
+	if err != nil {
+		t.Fatalf("unexpected error serving blob range: %v", err)
+	}
+	if body, _ := io.ReadAll(w.Body); w.Code != http.StatusPartialContent || len(body) != 5 {
+		t.Fatalf("unexpected range response: %d %q", w.Code, body)
+	}
+}
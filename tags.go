@@ -2,6 +2,7 @@ package distribution
 
 import (
 	"context"
+	"time"
 
 	"github.com/opencontainers/go-digest"
 )
@@ -35,3 +36,38 @@ type TagManifestsProvider interface {
 	// includes currently linked digest. There is no ordering guaranteed
 	ManifestDigests(ctx context.Context, tag string) ([]digest.Digest, error)
 }
+
+// TagCounter counts the tags of a repository, without listing them in order.
+type TagCounter interface {
+	// Count returns the number of tags of the repository, or
+	// ErrRepositoryUnknown if it does not exist.
+	Count(ctx context.Context) (int, error)
+}
+
+// TagDescriber describes tags, with when they were changed.
+type TagDescriber interface {
+	// Describe returns the details of the tag, or ErrTagUnknown if it does
+	// not exist.
+	Describe(ctx context.Context, tag string) (TagDetails, error)
+}
+
+// TagDetails describes a tag, and the manifests it referenced.
+type TagDetails struct {
+	// Descriptor describes the manifest the tag references.
+	Descriptor Descriptor
+
+	// LastModified is when the tag was last changed.
+	LastModified time.Time
+
+	// History lists the manifests the tag referenced, including the
+	// current one, ordered by when the tag was last made to reference them.
+	History []TagHistoryEntry
+}
+
+// TagHistoryEntry is a manifest a tag referenced.
+type TagHistoryEntry struct {
+	Digest digest.Digest
+
+	// Tagged is when the tag was last made to reference the manifest.
+	Tagged time.Time
+}
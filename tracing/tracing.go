@@ -2,16 +2,24 @@ package tracing
 
 import (
 	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"sync/atomic"
 
+	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/version"
 	"go.opentelemetry.io/contrib/exporters/autoexport"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -26,20 +34,29 @@ const (
 	AttributePrefix = "io.cncf.distribution."
 )
 
+// provider is the tracer provider installed by InitOpenTelemetry, nil while
+// tracing is disabled.
+var provider atomic.Pointer[sdktrace.TracerProvider]
+
 // InitOpenTelemetry initializes OpenTelemetry for the application. This function sets up the
 // necessary components for collecting telemetry data, such as traces.
-func InitOpenTelemetry(ctx context.Context) error {
+//
+// Traces are exported to the collector of config, or to the exporter set by
+// the standard OTEL_TRACES_EXPORTER and OTEL_EXPORTER_OTLP_ENDPOINT
+// environment variables. If neither is configured, tracing stays disabled
+// and the global tracer provider is left as a no-op.
+func InitOpenTelemetry(ctx context.Context, config configuration.OTel) error {
+	exporter, err := newSpanExporter(ctx, config)
+	if err != nil || exporter == nil {
+		return err
+	}
+
 	res := resource.NewWithAttributes(
 		semconv.SchemaURL,
 		semconv.ServiceNameKey.String(serviceName),
 		semconv.ServiceVersionKey.String(version.Version()),
 	)
 
-	autoExp, err := autoexport.NewSpanExporter(ctx)
-	if err != nil {
-		return err
-	}
-
 	lw := &loggerWriter{
 		logger: dcontext.GetLogger(ctx),
 	}
@@ -49,19 +66,90 @@ func InitOpenTelemetry(ctx context.Context) error {
 		return err
 	}
 
-	compositeExp := newCompositeExporter(autoExp, loggerExp)
+	compositeExp := newCompositeExporter(exporter, loggerExp)
+
+	samplingRatio := config.Sampling
+	if samplingRatio == 0 {
+		samplingRatio = defaultSamplingRatio
+	}
 
 	sp := sdktrace.NewBatchSpanProcessor(compositeExp)
-	provider := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(defaultSamplingRatio)),
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(samplingRatio))),
 		sdktrace.WithResource(res),
 		sdktrace.WithSpanProcessor(sp),
 	)
-	otel.SetTracerProvider(provider)
+	otel.SetTracerProvider(tp)
 	otel.SetErrorHandler(lw)
+	provider.Store(tp)
 
 	pr := propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
 	otel.SetTextMapPropagator(pr)
 
 	return nil
 }
+
+// newSpanExporter returns the exporter of the collector of config, or of the
+// OpenTelemetry environment variables. It returns nil if neither is
+// configured.
+func newSpanExporter(ctx context.Context, config configuration.OTel) (sdktrace.SpanExporter, error) {
+	if config.Endpoint == "" {
+		for _, env := range []string{"OTEL_TRACES_EXPORTER", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"} {
+			if os.Getenv(env) != "" {
+				return autoexport.NewSpanExporter(ctx)
+			}
+		}
+		return nil, nil
+	}
+
+	u, err := url.Parse(config.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid otel endpoint %q", config.Endpoint)
+	}
+	insecure := u.Scheme == "http"
+
+	switch config.Protocol {
+	case "grpc":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(u.Host)}
+		if insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case "", "http/protobuf":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+		if u.Path != "" && u.Path != "/" {
+			opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+		}
+		if insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported otel protocol %q", config.Protocol)
+	}
+}
+
+// Enabled reports whether InitOpenTelemetry enabled tracing.
+func Enabled() bool {
+	return provider.Load() != nil
+}
+
+// Shutdown exports the spans not exported yet and stops tracing, if
+// enabled.
+func Shutdown(ctx context.Context) error {
+	if tp := provider.Swap(nil); tp != nil {
+		return tp.Shutdown(ctx)
+	}
+	return nil
+}
+
+// StartSpan starts a span named name with tracer, as a child of the span of
+// ctx, if tracing is enabled. Otherwise, it returns ctx and its span, a no-op
+// one, without allocating: callers on hot paths should only set the
+// attributes of the span if it is recording.
+func StartSpan(ctx context.Context, tracer trace.Tracer, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if provider.Load() == nil {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return tracer.Start(ctx, name, opts...)
+}
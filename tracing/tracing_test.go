@@ -0,0 +1,104 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNewSpanExporter(t *testing.T) {
+	for _, env := range []string{"OTEL_TRACES_EXPORTER", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"} {
+		t.Setenv(env, "")
+	}
+	ctx := context.Background()
+
+	exporter, err := newSpanExporter(ctx, configuration.OTel{})
+	if err != nil || exporter != nil {
+		t.Fatalf("expected no exporter without configuration, got %v, %v", exporter, err)
+	}
+
+	for _, config := range []configuration.OTel{
+		{Endpoint: "http://localhost:4318"},
+		{Endpoint: "https://collector.example.com/custom/traces", Protocol: "http/protobuf"},
+		{Endpoint: "http://localhost:4317", Protocol: "grpc"},
+	} {
+		exporter, err := newSpanExporter(ctx, config)
+		if err != nil {
+			t.Fatalf("%+v: unexpected error: %v", config, err)
+		}
+		if exporter == nil {
+			t.Fatalf("%+v: expected an exporter", config)
+		}
+		exporter.Shutdown(ctx)
+	}
+
+	for _, config := range []configuration.OTel{
+		{Endpoint: "localhost:4318"},
+		{Endpoint: "http://localhost:4318", Protocol: "thrift"},
+	} {
+		if _, err := newSpanExporter(ctx, config); err == nil {
+			t.Fatalf("%+v: expected an error", config)
+		}
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := tp.Tracer("test")
+	ctx := context.Background()
+
+	spanCtx, span := StartSpan(ctx, tracer, "disabled")
+	span.End()
+	if spanCtx != ctx || span.IsRecording() {
+		t.Fatal("expected a no-op span while tracing is disabled")
+	}
+
+	provider.Store(tp)
+	defer provider.Store(nil)
+
+	spanCtx, span = StartSpan(ctx, tracer, "enabled")
+	if !span.IsRecording() || trace.SpanFromContext(spanCtx) != span {
+		t.Fatal("expected a recording span in the context while tracing is enabled")
+	}
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "enabled" {
+		t.Fatalf("unexpected spans recorded: %v", spans)
+	}
+}
+
+// TestStartSpanDisabledAllocs ensures that the spans of the global tracer,
+// a no-op one until tracing is enabled, add no allocations.
+func TestStartSpanDisabledAllocs(t *testing.T) {
+	tracer := otel.Tracer("test")
+	ctx := context.Background()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, span := StartSpan(ctx, tracer, "disabled")
+		if span.IsRecording() {
+			t.Fatal("unexpected recording span")
+		}
+		span.End()
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, got %v", allocs)
+	}
+}
+
+func BenchmarkStartSpanDisabled(b *testing.B) {
+	tracer := otel.Tracer("test")
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, span := StartSpan(ctx, tracer, "disabled")
+		span.End()
+	}
+}
@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func TestTransport(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: NewTransport(http.DefaultTransport)}
+
+	// Without a span context, nothing is forwarded.
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if tp := received.Get("traceparent"); tp != "" {
+		t.Fatalf("unexpected traceparent: %q", tp)
+	}
+
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier{
+		"Traceparent": []string{traceparent},
+		"Tracestate":  []string{"vendor=value"},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if tp := received.Get("traceparent"); tp != traceparent {
+		t.Fatalf("unexpected traceparent: %q", tp)
+	}
+	if ts := received.Get("tracestate"); ts != "vendor=value" {
+		t.Fatalf("unexpected tracestate: %q", ts)
+	}
+	if req.Header.Get("traceparent") != "" {
+		t.Fatal("the request was modified")
+	}
+}